@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"pical/database/schemas"
+	"pical/server"
+)
+
+// runAPIToken handles `server api-token <name> <scope>...`, which issues an
+// API token without going through the API, e.g. the first admin token once
+// REQUIRE_API_TOKEN is set. The secret is printed once.
+func runAPIToken(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: api-token <name> <scope>...")
+	}
+	// The table may not exist yet on a fresh install
+	if err := schemas.MigrateTo(ctx, db, schemas.LatestMigration()); err != nil {
+		return err
+	}
+
+	issued, err := server.IssueAPIToken(ctx, db, schemas.APIToken{Name: args[0], Scopes: args[1:]})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s  %s\n", issued.TokenID, issued.Token)
+	return nil
+}
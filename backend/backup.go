@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"pical/backups"
+	"pical/database/schemas"
+)
+
+// backupEncryptionKey is BACKUP_ENCRYPTION_KEY, or nil when it isn't set.
+func backupEncryptionKey() ([]byte, error) {
+	s := getenv("BACKUP_ENCRYPTION_KEY", "")
+	if s == "" {
+		return nil, nil
+	}
+	return backups.ParseKey(s)
+}
+
+// runBackup handles `server backup [file]`, which writes the same JSON backup
+// as GET /api/admin/export, and `server backup restore <file>`, which
+// replaces everything with one as POST /api/admin/import does. An encrypted
+// backup is decrypted with BACKUP_ENCRYPTION_KEY.
+func runBackup(ctx context.Context, db *sql.DB, args []string) error {
+	// A backup is labelled with the server's migration, so the database has
+	// to be at it
+	if err := schemas.MigrateTo(ctx, db, schemas.LatestMigration()); err != nil {
+		return err
+	}
+
+	if len(args) > 0 && args[0] == "restore" {
+		if len(args) < 2 {
+			return fmt.Errorf("usage: backup restore <file>")
+		}
+		data, err := os.ReadFile(args[1])
+		if err != nil {
+			return err
+		}
+		if backups.IsEncrypted(data) {
+			key, err := backupEncryptionKey()
+			if err != nil {
+				return err
+			}
+			if key == nil {
+				return fmt.Errorf("%s is encrypted; set BACKUP_ENCRYPTION_KEY to the key it was made with", args[1])
+			}
+			if data, err = backups.Decrypt(key, data); err != nil {
+				return fmt.Errorf("%s: %w", args[1], err)
+			}
+		}
+		var in schemas.Backup
+		if err := json.Unmarshal(data, &in); err != nil {
+			return fmt.Errorf("%s: %w", args[1], err)
+		}
+		rows, err := schemas.RestoreBackup(ctx, db, in)
+		if err != nil {
+			return err
+		}
+		total := 0
+		for _, n := range rows {
+			total += n
+		}
+		fmt.Printf("restored %d rows across %d tables from the backup of %s\n", total, len(rows), in.ExportedAt.Format("2006-01-02 15:04:05"))
+		return nil
+	}
+
+	backup, err := schemas.ExportBackup(ctx, db)
+	if err != nil {
+		return err
+	}
+	out, err := output(args)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(out).Encode(backup); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,160 @@
+// Package backups keeps backup files in a directory, an S3-compatible
+// bucket, on a WebDAV server such as Nextcloud or, with backups/sftp, over
+// SFTP, optionally encrypted first.
+package backups
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Target is somewhere backup files are kept, each under a plain file name.
+type Target interface {
+	Put(ctx context.Context, name string, data []byte) error
+	Get(ctx context.Context, name string) ([]byte, error)
+	// List returns the names of the files there, in no particular order
+	List(ctx context.Context) ([]string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// S3Config is what an s3:// target signs its requests with.
+type S3Config struct {
+	// Where the bucket is served, such as https://s3.eu-west-2.amazonaws.com
+	// or a MinIO server; buckets are addressed by path
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// TargetConfig is where a target is and how to get in.
+type TargetConfig struct {
+	// s3://bucket/prefix, dav://host/path or davs://host/path for WebDAV
+	// over HTTP or HTTPS, sftp://host[:port]/path, or else a directory
+	URL string
+	S3  S3Config
+	// For WebDAV and SFTP; the URL's user when Username is empty
+	Username string
+	Password string
+	// For SFTP: a PEM private key to log in with instead of or besides the
+	// password, and the server's public key in authorized_keys format,
+	// without which the backup could go to whoever answers
+	PrivateKey string
+	HostKey    string
+	// When set, a 32-byte key files are encrypted with before they leave
+	Key []byte
+}
+
+// schemes are the targets other packages provide, by URL scheme.
+var schemes = map[string]func(cfg TargetConfig, u *url.URL) (Target, error){}
+
+// RegisterScheme is called from init by a package providing a kind of
+// target, so only builds that import it pull in what it needs.
+func RegisterScheme(scheme string, open func(cfg TargetConfig, u *url.URL) (Target, error)) {
+	schemes[scheme] = open
+}
+
+// New returns the target cfg points at, encrypting what goes there when
+// cfg has a key. A directory is created if need be.
+func New(cfg TargetConfig) (Target, error) {
+	t, err := open(cfg)
+	if err != nil || cfg.Key == nil {
+		return t, err
+	}
+	return Encrypt(t, cfg.Key)
+}
+
+func open(cfg TargetConfig) (Target, error) {
+	if !strings.Contains(cfg.URL, "://") {
+		if err := os.MkdirAll(cfg.URL, 0o750); err != nil {
+			return nil, fmt.Errorf("backup dir: %w", err)
+		}
+		return NewDir(cfg.URL), nil
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("backup target: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("backup target %s:// must name a host or bucket", u.Scheme)
+	}
+	switch u.Scheme {
+	case "s3":
+		s3 := cfg.S3
+		if s3.Endpoint == "" || s3.AccessKey == "" || s3.SecretKey == "" {
+			return nil, fmt.Errorf("an s3:// backup target needs an endpoint, access key and secret key")
+		}
+		return NewS3(s3, u.Host, strings.Trim(u.Path, "/")), nil
+	case "dav", "davs":
+		return NewWebDAV(u, Username(cfg, u), cfg.Password), nil
+	}
+	if open, ok := schemes[u.Scheme]; ok {
+		return open(cfg, u)
+	}
+	return nil, fmt.Errorf("backup target: unknown scheme %s://; use s3://, dav://, davs://, sftp:// or a directory", u.Scheme)
+}
+
+// Username is who to log in to u as.
+func Username(cfg TargetConfig, u *url.URL) string {
+	if cfg.Username != "" || u.User == nil {
+		return cfg.Username
+	}
+	return u.User.Username()
+}
+
+// Dir keeps backups as files in a directory.
+type Dir struct {
+	path string
+}
+
+func NewDir(path string) *Dir {
+	return &Dir{path: path}
+}
+
+// Put writes the file beside its final name and renames it into place, so
+// a backup interrupted part way never looks like a whole one.
+func (d *Dir) Put(ctx context.Context, name string, data []byte) error {
+	tmp := filepath.Join(d.path, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("backup dir: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(d.path, name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("backup dir: %w", err)
+	}
+	return nil
+}
+
+func (d *Dir) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(d.path, name))
+	if err != nil {
+		return nil, fmt.Errorf("backup dir: %w", err)
+	}
+	return data, nil
+}
+
+func (d *Dir) List(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return nil, fmt.Errorf("backup dir: %w", err)
+	}
+	out := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+			out = append(out, e.Name())
+		}
+	}
+	return out, nil
+}
+
+func (d *Dir) Delete(ctx context.Context, name string) error {
+	if err := os.Remove(filepath.Join(d.path, name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("backup dir: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package backups
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDAV is just enough of a WebDAV server for WebDAV: files in one
+// collection, which has to be made before anything is put in it.
+type fakeDAV struct {
+	mu    sync.Mutex
+	dir   string
+	made  bool
+	files map[string][]byte
+}
+
+func (f *fakeDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user, pass, _ := r.BasicAuth(); user != "me" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, f.dir)
+	switch r.Method {
+	case "MKCOL":
+		f.made = true
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodPut:
+		if !f.made {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.files[name], _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		data, ok := f.files[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case "MOVE":
+		dest, _ := url.Parse(r.Header.Get("Destination"))
+		f.files[strings.TrimPrefix(dest.Path, f.dir)] = f.files[name]
+		delete(f.files, name)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if _, ok := f.files[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.files, name)
+		w.WriteHeader(http.StatusNoContent)
+	case "PROPFIND":
+		if !f.made {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var b strings.Builder
+		b.WriteString(`<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">`)
+		fmt.Fprintf(&b, `<d:response><d:href>%s</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop></d:propstat></d:response>`, f.dir)
+		for name := range f.files {
+			fmt.Fprintf(&b, `<d:response><d:href>%s</d:href><d:propstat><d:prop><d:resourcetype/></d:prop></d:propstat></d:response>`, path.Join(f.dir, name))
+		}
+		b.WriteString(`</d:multistatus>`)
+		w.WriteHeader(http.StatusMultiStatus)
+		io.WriteString(w, b.String())
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestWebDAV(t *testing.T) {
+	ctx := context.Background()
+	dav := &fakeDAV{dir: "/remote.php/dav/files/me/pical/", files: map[string][]byte{}}
+	srv := httptest.NewServer(dav)
+	defer srv.Close()
+
+	target, err := New(TargetConfig{
+		URL:      "dav://" + strings.TrimPrefix(srv.URL, "http://") + "/remote.php/dav/files/me/pical",
+		Username: "me",
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	names, err := target.List(ctx)
+	if err != nil || len(names) != 0 {
+		t.Fatalf("List before any put = %v, %v; want none", names, err)
+	}
+	for _, name := range []string{"a.json", "b.json"} {
+		if err := target.Put(ctx, name, []byte(name)); err != nil {
+			t.Fatalf("Put %s: %v", name, err)
+		}
+	}
+	names, err = target.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"a.json", "b.json"}) {
+		t.Errorf("List = %v, want a.json and b.json without the temporary files", names)
+	}
+	if data, err := target.Get(ctx, "b.json"); err != nil || string(data) != "b.json" {
+		t.Errorf("Get = %q, %v", data, err)
+	}
+	if err := target.Delete(ctx, "a.json"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := target.Delete(ctx, "a.json"); err != nil {
+		t.Errorf("Delete of a missing file = %v, want nil", err)
+	}
+	if _, ok := dav.files["a.json"]; ok {
+		t.Error("a.json is still there")
+	}
+
+	wrong, _ := New(TargetConfig{URL: "dav://" + strings.TrimPrefix(srv.URL, "http://") + "/remote.php/dav/files/me/pical", Username: "me"})
+	if err := wrong.Put(ctx, "c.json", nil); err == nil {
+		t.Error("Put without the password succeeded")
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{7}, 32)
+
+	plain, err := New(TargetConfig{URL: dir})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := plain.Put(ctx, "old.json", []byte(`{"old":true}`)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	target, err := New(TargetConfig{URL: dir, Key: key})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := target.Put(ctx, "new.json", []byte(`{"new":true}`)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	raw, err := plain.Get(ctx, "new.json")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !IsEncrypted(raw) || bytes.Contains(raw, []byte("new")) {
+		t.Fatalf("stored file isn't encrypted: %q", raw)
+	}
+	if data, err := target.Get(ctx, "new.json"); err != nil || string(data) != `{"new":true}` {
+		t.Errorf("Get = %q, %v", data, err)
+	}
+	if data, err := target.Get(ctx, "old.json"); err != nil || string(data) != `{"old":true}` {
+		t.Errorf("Get of a file from before encryption = %q, %v", data, err)
+	}
+	if data, err := Decrypt(key, raw); err != nil || string(data) != `{"new":true}` {
+		t.Errorf("Decrypt = %q, %v", data, err)
+	}
+	if _, err := Decrypt(bytes.Repeat([]byte{8}, 32), raw); err == nil {
+		t.Error("Decrypt with the wrong key succeeded")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	if _, err := ParseKey("c2hvcnQ="); err == nil {
+		t.Error("ParseKey took a 5-byte key")
+	}
+	key, err := ParseKey(" AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n")
+	if err != nil || len(key) != 32 {
+		t.Errorf("ParseKey = %d bytes, %v; want 32", len(key), err)
+	}
+}
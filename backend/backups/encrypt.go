@@ -0,0 +1,96 @@
+package backups
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// encryptedMagic starts every encrypted file, followed by the nonce and
+// the AES-256-GCM ciphertext, so a file can be told apart from a plain one.
+var encryptedMagic = []byte("PICALENC1\n")
+
+// ParseKey reads an encryption key as base64 of 32 random bytes, such as
+// `openssl rand -base64 32` makes.
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("backup encryption key must be 32 bytes in base64, such as `openssl rand -base64 32` makes")
+	}
+	return key, nil
+}
+
+// Encrypted files are sealed before they are put and opened when read back.
+// Files from before encryption was turned on still read as they are.
+type Encrypted struct {
+	Target
+	aead cipher.AEAD
+}
+
+// Encrypt wraps t so what goes there is encrypted with key.
+func Encrypt(t Target, key []byte) (*Encrypted, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Encrypted{Target: t, aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("backup encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (e *Encrypted) Put(ctx context.Context, name string, data []byte) error {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	out := append(append(bytes.Clone(encryptedMagic), nonce...), e.aead.Seal(nil, nonce, data, nil)...)
+	return e.Target.Put(ctx, name, out)
+}
+
+func (e *Encrypted) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := e.Target.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	return unseal(e.aead, data)
+}
+
+// IsEncrypted reports whether data is a file Encrypted put.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// Decrypt opens a file Encrypted put, for restoring one by hand.
+func Decrypt(key, data []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return unseal(aead, data)
+}
+
+func unseal(aead cipher.AEAD, data []byte) ([]byte, error) {
+	sealed, ok := bytes.CutPrefix(data, encryptedMagic)
+	if !ok || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("not an encrypted backup")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt backup: wrong key, or the file is damaged")
+	}
+	return plain, nil
+}
@@ -0,0 +1,192 @@
+// Package sftp adds sftp:// backup targets: a directory on an SSH server,
+// reached with a password or a private key. Importing it registers the
+// scheme with the backups package.
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"pical/backups"
+)
+
+func init() {
+	backups.RegisterScheme("sftp", func(cfg backups.TargetConfig, u *url.URL) (backups.Target, error) {
+		return New(cfg, u)
+	})
+}
+
+// Target keeps backups in a directory on an SSH server. It connects for
+// each call, as backups are made a few times a day at most.
+type Target struct {
+	addr string
+	dir  string
+	ssh  *ssh.ClientConfig
+}
+
+// New is the directory u points at, sftp://host[:port]/path, the path
+// taken from the login directory unless it starts with "//".
+func New(cfg backups.TargetConfig, u *url.URL) (*Target, error) {
+	user := backups.Username(cfg, u)
+	if user == "" {
+		return nil, fmt.Errorf("an sftp:// backup target needs a username")
+	}
+	if cfg.HostKey == "" {
+		return nil, fmt.Errorf("an sftp:// backup target needs the server's host key, as in its ssh_host_ed25519_key.pub")
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(cfg.HostKey))
+	if err != nil {
+		return nil, fmt.Errorf("sftp host key: %w", err)
+	}
+
+	auth := make([]ssh.AuthMethod, 0, 2)
+	if cfg.PrivateKey != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(cfg.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("sftp private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("an sftp:// backup target needs a password or a private key")
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	dir := strings.TrimPrefix(u.Path, "/")
+	if dir == "" {
+		dir = "."
+	}
+	return &Target{
+		addr: addr,
+		dir:  dir,
+		ssh: &ssh.ClientConfig{
+			User:            user,
+			Auth:            auth,
+			HostKeyCallback: ssh.FixedHostKey(hostKey),
+			Timeout:         30 * time.Second,
+		},
+	}, nil
+}
+
+// session connects, runs fn and hangs up, giving up when ctx is done.
+func (t *Target) session(ctx context.Context, fn func(c *sftp.Client) error) error {
+	conn, err := (&net.Dialer{Timeout: t.ssh.Timeout}).DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return fmt.Errorf("sftp: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	sc, chans, reqs, err := ssh.NewClientConn(conn, t.addr, t.ssh)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("sftp: %w", err)
+	}
+	client := ssh.NewClient(sc, chans, reqs)
+	defer client.Close()
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("sftp: %w", err)
+	}
+	defer c.Close()
+
+	if err := fn(c); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("sftp: %w", err)
+	}
+	return nil
+}
+
+// Put writes the file beside its final name and renames it into place, as
+// backups.Dir does, creating the directory first if need be.
+func (t *Target) Put(ctx context.Context, name string, data []byte) error {
+	return t.session(ctx, func(c *sftp.Client) error {
+		if err := c.MkdirAll(t.dir); err != nil {
+			return err
+		}
+		tmp := path.Join(t.dir, "."+name+".tmp")
+		f, err := c.Create(tmp)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, bytes.NewReader(data)); err != nil {
+			f.Close()
+			c.Remove(tmp)
+			return err
+		}
+		if err := f.Close(); err != nil {
+			c.Remove(tmp)
+			return err
+		}
+		if err := c.PosixRename(tmp, path.Join(t.dir, name)); err != nil {
+			c.Remove(tmp)
+			return err
+		}
+		return nil
+	})
+}
+
+func (t *Target) Get(ctx context.Context, name string) ([]byte, error) {
+	var out []byte
+	err := t.session(ctx, func(c *sftp.Client) error {
+		f, err := c.Open(path.Join(t.dir, name))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out, err = io.ReadAll(f)
+		return err
+	})
+	return out, err
+}
+
+func (t *Target) List(ctx context.Context) ([]string, error) {
+	out := make([]string, 0)
+	err := t.session(ctx, func(c *sftp.Client) error {
+		entries, err := c.ReadDir(t.dir)
+		if errors.Is(err, os.ErrNotExist) {
+			// Nothing has been put there yet
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.Mode().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+				out = append(out, e.Name())
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (t *Target) Delete(ctx context.Context, name string) error {
+	return t.session(ctx, func(c *sftp.Client) error {
+		if err := c.Remove(path.Join(t.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	})
+}
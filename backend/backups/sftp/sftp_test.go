@@ -0,0 +1,128 @@
+package sftp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"pical/backups"
+)
+
+// serveSFTP runs an SSH server on localhost that takes password "secret"
+// for "me" and keeps files in memory, returning its address and host key.
+func serveSFTP(t *testing.T) (string, string) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "me" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	handlers := sftp.InMemHandler()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					channel, requests, err := ch.Accept()
+					if err != nil {
+						return
+					}
+					go func() {
+						for req := range requests {
+							req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+						}
+					}()
+					server := sftp.NewRequestServer(channel, handlers)
+					server.Serve()
+					server.Close()
+				}
+			}()
+		}
+	}()
+
+	return ln.Addr().String(), string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+}
+
+func TestTarget(t *testing.T) {
+	ctx := context.Background()
+	addr, hostKey := serveSFTP(t)
+	u, _ := url.Parse("sftp://me@" + addr + "//backups/pical")
+
+	if _, err := New(backups.TargetConfig{Password: "secret"}, u); err == nil {
+		t.Error("New without the host key succeeded")
+	}
+	target, err := New(backups.TargetConfig{Password: "secret", HostKey: hostKey}, u)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	names, err := target.List(ctx)
+	if err != nil || len(names) != 0 {
+		t.Fatalf("List before any put = %v, %v; want none", names, err)
+	}
+	for _, name := range []string{"a.json", "b.json"} {
+		if err := target.Put(ctx, name, []byte(name)); err != nil {
+			t.Fatalf("Put %s: %v", name, err)
+		}
+	}
+	names, err = target.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"a.json", "b.json"}) {
+		t.Errorf("List = %v, want a.json and b.json", names)
+	}
+	if data, err := target.Get(ctx, "a.json"); err != nil || string(data) != "a.json" {
+		t.Errorf("Get = %q, %v", data, err)
+	}
+	if err := target.Delete(ctx, "a.json"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := target.Delete(ctx, "a.json"); err != nil {
+		t.Errorf("Delete of a missing file = %v, want nil", err)
+	}
+
+	_, other := serveSFTP(t)
+	impostor, err := New(backups.TargetConfig{Password: "secret", HostKey: other}, u)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := impostor.Put(ctx, "c.json", nil); err == nil {
+		t.Error("Put went to a server with the wrong host key")
+	}
+}
@@ -0,0 +1,173 @@
+package backups
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// WebDAV keeps backups as files in a collection on a WebDAV server, such as
+// a Nextcloud folder at davs://cloud.example.com/remote.php/dav/files/me/pical,
+// logging in with basic auth. An app password is best for Nextcloud.
+type WebDAV struct {
+	base     *url.URL
+	username string
+	password string
+}
+
+// NewWebDAV is the collection u points at, over HTTPS for davs:// and
+// plain HTTP for dav://.
+func NewWebDAV(u *url.URL, username, password string) *WebDAV {
+	base := *u
+	base.Scheme = "https"
+	if u.Scheme == "dav" {
+		base.Scheme = "http"
+	}
+	base.User = nil
+	base.Path = strings.TrimRight(base.Path, "/") + "/"
+	return &WebDAV{base: &base, username: username, password: password}
+}
+
+func (d *WebDAV) fileURL(name string) string {
+	u := *d.base
+	u.Path += name
+	return u.String()
+}
+
+// Put uploads the file beside its final name and moves it into place, as
+// Dir does, creating the collection first if it isn't there yet.
+func (d *WebDAV) Put(ctx context.Context, name string, data []byte) error {
+	tmp := "." + name + ".tmp"
+	resp, err := d.do(ctx, http.MethodPut, d.fileURL(tmp), nil, data)
+	if resp != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusConflict) {
+		if _, err := d.do(ctx, "MKCOL", d.base.String(), nil, nil); err != nil {
+			return err
+		}
+		_, err = d.do(ctx, http.MethodPut, d.fileURL(tmp), nil, data)
+	}
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Destination": {d.fileURL(name)}, "Overwrite": {"T"}}
+	if _, err := d.do(ctx, "MOVE", d.fileURL(tmp), header, nil); err != nil {
+		_, _ = d.do(ctx, http.MethodDelete, d.fileURL(tmp), nil, nil)
+		return err
+	}
+	return nil
+}
+
+func (d *WebDAV) Get(ctx context.Context, name string) ([]byte, error) {
+	req, err := d.request(ctx, http.MethodGet, d.fileURL(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("webdav: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("webdav: GET %s: unexpected status %s", name, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("webdav: get: %w", err)
+	}
+	return data, nil
+}
+
+// List asks for the collection's members, one level deep, and keeps the
+// files, leaving out the collection itself and any folders in it.
+func (d *WebDAV) List(ctx context.Context) ([]string, error) {
+	const body = `<?xml version="1.0" encoding="utf-8"?><propfind xmlns="DAV:"><prop><resourcetype/></prop></propfind>`
+	header := http.Header{"Depth": {"1"}, "Content-Type": {"application/xml; charset=utf-8"}}
+	req, err := d.request(ctx, "PROPFIND", d.base.String(), header, []byte(body))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("webdav: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		// Nothing has been put there yet
+		return []string{}, nil
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("webdav: PROPFIND: unexpected status %s", resp.Status)
+	}
+
+	var ms struct {
+		Responses []struct {
+			Href       string    `xml:"href"`
+			Collection *struct{} `xml:"propstat>prop>resourcetype>collection"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("webdav: list: %w", err)
+	}
+	out := make([]string, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		if r.Collection != nil {
+			continue
+		}
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		// Only the files directly in the collection
+		if dir, name := path.Split(href.Path); dir == d.base.Path && name != "" && !strings.HasPrefix(name, ".") {
+			out = append(out, name)
+		}
+	}
+	return out, nil
+}
+
+func (d *WebDAV) Delete(ctx context.Context, name string) error {
+	resp, err := d.do(ctx, http.MethodDelete, d.fileURL(name), nil, nil)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (d *WebDAV) request(ctx context.Context, method, u string, header http.Header, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if d.username != "" || d.password != "" {
+		req.SetBasicAuth(d.username, d.password)
+	}
+	return req, nil
+}
+
+// do sends a request whose response body doesn't matter. On an unexpected
+// status the response comes back alongside the error, so the caller can
+// tell what it was.
+func (d *WebDAV) do(ctx context.Context, method, u string, header http.Header, body []byte) (*http.Response, error) {
+	req, err := d.request(ctx, method, u, header, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("webdav: %w", err)
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp, fmt.Errorf("webdav: %s %s: unexpected status %s: %s", method, req.URL.Path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
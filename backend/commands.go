@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// commands are the maintenance subcommands, `server <command> [args]`. Each
+// only touches the database and exits; `server serve`, or no command at all,
+// starts the server.
+var commands = map[string]func(ctx context.Context, db *sql.DB, args []string) error{
+	"migrate":           runMigrate,
+	"backup":            runBackup,
+	"export":            runExport,
+	"seed":              runSeed,
+	"api-token":         runAPIToken,
+	"default-reminders": runDefaultReminders,
+}
+
+const usage = `usage: server [command] [args]
+
+  serve [-demo [-timezone zone]]         run the server (the default); -demo seeds an empty database first
+  migrate [status | up | down [n] | to <version> | verify]
+                                         show or move the database's migration
+  backup [file]                          write a JSON backup to file, or stdout
+  backup restore <file>                  replace everything with a backup
+  export [-calendar ID] [-person ID] [-tag name] [file]
+                                         write the calendar as ICS to file, or stdout
+  seed [-timezone zone]                  add example people and events to an empty database
+  api-token <name> <scope>...            issue an API token
+  default-reminders [-calendar ID] [-person ID] [-tag name] [-channel alert|push] [-leave-by] [-dry-run] <minutes>...
+                                         give matching events reminders
+`
+
+// command picks the subcommand from os.Args. It is nil for serve, and an
+// unknown command, or asking for help, prints the usage and exits.
+func command() (string, func(ctx context.Context, db *sql.DB, args []string) error) {
+	if len(os.Args) < 2 || os.Args[1] == "serve" {
+		return "serve", nil
+	}
+	name := os.Args[1]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		os.Exit(0)
+	}
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
+	return name, run
+}
+
+// output is where a subcommand writes: the file named by args' first entry
+// when there is one other than "-", or stdout. The file is replaced.
+func output(args []string) (*os.File, error) {
+	if len(args) == 0 || args[0] == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(args[0])
+}
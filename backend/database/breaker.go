@@ -0,0 +1,271 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrUnavailable is returned in place of running a query while the breaker
+// is open.
+var ErrUnavailable = errors.New("database unavailable")
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	// Queries fail straight away until the cooldown is over
+	BreakerOpen
+	// One query is let through to see whether the database is back
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// Breaker stops sending queries to a database that has stopped answering,
+// so callers fail fast instead of piling up behind a stalled connection
+// pool. It opens after threshold failures in a row that look like the
+// database going away, and after cooldown lets one query through to probe
+// it: success closes it again, failure starts another cooldown.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trips    int64
+	lastErr  string
+}
+
+// BreakerStats is a snapshot of a Breaker for health checks and metrics.
+type BreakerStats struct {
+	State string `json:"state"`
+	// Failures in a row so far; reset by any success
+	Failures int        `json:"failures"`
+	Trips    int64      `json:"trips"`
+	OpenedAt *time.Time `json:"openedAt,omitempty"`
+	// When the next probe may run, while open
+	RetryAt   *time.Time `json:"retryAt,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a query may run now, moving an open breaker whose
+// cooldown is over to half-open for this one query.
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrUnavailable
+		}
+		b.state = BreakerHalfOpen
+		return nil
+	case BreakerHalfOpen:
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// record counts the outcome of a query that allow let through.
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !connFailure(err) {
+		if b.state != BreakerClosed {
+			log.Printf("database: reachable again, closing circuit")
+		}
+		b.state, b.failures = BreakerClosed, 0
+		return
+	}
+
+	b.failures++
+	b.lastErr = err.Error()
+	if b.state == BreakerHalfOpen || b.state == BreakerClosed && b.failures >= b.threshold {
+		if b.state == BreakerClosed {
+			log.Printf("database: opening circuit after %d failures: %v", b.failures, err)
+			b.trips++
+		}
+		b.state, b.openedAt = BreakerOpen, time.Now()
+	}
+}
+
+// Rejecting reports whether requests should be turned away before they
+// reach the database, and for how long.
+func (b *Breaker) Rejecting() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if left := b.cooldown - time.Since(b.openedAt); left > 0 {
+			return true, left
+		}
+	case BreakerHalfOpen:
+		return true, b.cooldown
+	}
+	return false, 0
+}
+
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := BreakerStats{State: b.state.String(), Failures: b.failures, Trips: b.trips, LastError: b.lastErr}
+	if b.state != BreakerClosed {
+		opened, retry := b.openedAt, b.openedAt.Add(b.cooldown)
+		out.OpenedAt, out.RetryAt = &opened, &retry
+	}
+	return out
+}
+
+// connFailure tells the database going away apart from errors it answered
+// with, such as constraint violations, which say it is working fine.
+func connFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
+
+// breakerConnector wraps a driver connector so every connection, query and
+// exec made through it goes past the breaker.
+type breakerConnector struct {
+	driver.Connector
+	b *Breaker
+}
+
+func (c breakerConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := c.b.allow(); err != nil {
+		return nil, err
+	}
+	conn, err := c.Connector.Connect(ctx)
+	c.b.record(err)
+	if err != nil {
+		return nil, err
+	}
+	return breakerConn{conn, c.b}, nil
+}
+
+// breakerConn passes through the optional driver interfaces pgx
+// implements, as countingConn does.
+type breakerConn struct {
+	driver.Conn
+	b *Breaker
+}
+
+func (c breakerConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.b.allow(); err != nil {
+		return nil, err
+	}
+	rows, err := q.QueryContext(ctx, query, args)
+	c.b.record(err)
+	return rows, err
+}
+
+func (c breakerConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.b.allow(); err != nil {
+		return nil, err
+	}
+	res, err := e.ExecContext(ctx, query, args)
+	c.b.record(err)
+	return res, err
+}
+
+// CheckNamedValue hands arguments to the driver as they are, so it can
+// encode slices and other types database/sql doesn't know.
+func (c breakerConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if ch, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (c breakerConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c breakerConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.b.allow(); err != nil {
+		return nil, err
+	}
+	var tx driver.Tx
+	var err error
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	c.b.record(err)
+	return tx, err
+}
+
+func (c breakerConn) Ping(ctx context.Context) error {
+	p, ok := c.Conn.(driver.Pinger)
+	if !ok {
+		return nil
+	}
+	if err := c.b.allow(); err != nil {
+		return err
+	}
+	err := p.Ping(ctx)
+	c.b.record(err)
+	return err
+}
+
+func (c breakerConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c breakerConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
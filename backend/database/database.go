@@ -3,10 +3,16 @@ package database
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
 type Config struct {
@@ -16,35 +22,141 @@ type Config struct {
 	Password string
 	Name     string
 	SSLMode  string // "disable" for local, "require"/etc for prod
+	// How many prepared statements each connection keeps; zero keeps pgx's
+	// default, and a negative number turns the cache off, as poolers such as
+	// PgBouncer in transaction mode need
+	StatementCacheCapacity int
+	// Session settings sent when connecting, e.g. application_name or
+	// statement_timeout
+	RuntimeParams map[string]string
+	// Count queries per context for WithQueryCounter; meant for development
+	CountQueries bool
+	// Fail queries fast while the database is unreachable; nil sends every
+	// query through regardless
+	Breaker *Breaker
+	// Connection pool limits; zero keeps the defaults of 25 open, as many
+	// idle as open and a 30 minute lifetime, with idle connections kept for
+	// all of it. Negative idle keeps none. A small board such as a Pi Zero
+	// wants far fewer.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// How long Open keeps retrying while the database isn't up yet, as at
+	// boot when Postgres starts after the server; zero tries once
+	ConnectWait time.Duration
 }
 
-func Open(cfg Config) (*sql.DB, error) {
+// Bounds on the pause between connection attempts while Open waits
+const (
+	connectFirstRetry = 500 * time.Millisecond
+	connectMaxRetry   = 15 * time.Second
+)
+
+// dsnValue quotes v for a key=value connection string.
+func dsnValue(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// Open connects to the database, waiting up to cfg.ConnectWait for it to
+// come up, or until ctx is canceled.
+func Open(ctx context.Context, cfg Config) (*sql.DB, error) {
 	if cfg.SSLMode == "" {
 		cfg.SSLMode = "disable"
 	}
 
 	dsn := fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Name, cfg.SSLMode,
+		dsnValue(cfg.Host), cfg.Port, dsnValue(cfg.User), dsnValue(cfg.Password), dsnValue(cfg.Name), dsnValue(cfg.SSLMode),
 	)
 
-	db, err := sql.Open("postgres", dsn)
+	pgxCfg, err := pgx.ParseConfig(dsn)
 	if err != nil {
 		return nil, err
 	}
+	switch {
+	case cfg.StatementCacheCapacity > 0:
+		pgxCfg.StatementCacheCapacity = cfg.StatementCacheCapacity
+	case cfg.StatementCacheCapacity < 0:
+		// Statements are still described first, so arguments keep being
+		// sent as the types the server expects
+		pgxCfg.StatementCacheCapacity = 0
+		pgxCfg.DefaultQueryExecMode = pgx.QueryExecModeDescribeExec
+	}
+	for k, v := range cfg.RuntimeParams {
+		pgxCfg.RuntimeParams[k] = v
+	}
+
+	connector := stdlib.GetConnector(*pgxCfg)
+	// Straight to the driver, so the failures don't trip the breaker
+	if err := waitForDatabase(ctx, connector, cfg.ConnectWait); err != nil {
+		return nil, err
+	}
+	var c driver.Connector = connector
+	if cfg.CountQueries {
+		c = countingConnector{c}
+	}
+	if cfg.Breaker != nil {
+		c = breakerConnector{c, cfg.Breaker}
+	}
+	db := sql.OpenDB(c)
 
-	// Sensible pool defaults (tune later)
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(25)
-	db.SetConnMaxLifetime(30 * time.Minute)
+	if cfg.MaxOpenConns == 0 {
+		cfg.MaxOpenConns = 25
+	}
+	if cfg.MaxIdleConns == 0 {
+		cfg.MaxIdleConns = cfg.MaxOpenConns
+	}
+	if cfg.ConnMaxLifetime == 0 {
+		cfg.ConnMaxLifetime = 30 * time.Minute
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 
-	// Fail fast at startup
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	if err := db.PingContext(ctx); err != nil {
+	if err := db.PingContext(pingCtx); err != nil {
 		_ = db.Close()
 		return nil, err
 	}
 
 	return db, nil
 }
+
+// waitForDatabase tries to connect until it works, backing off
+// exponentially between attempts, and gives up once wait has passed.
+func waitForDatabase(ctx context.Context, c driver.Connector, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	delay := connectFirstRetry
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		conn, err := c.Connect(attemptCtx)
+		cancel()
+		if err == nil {
+			return conn.Close()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Bad credentials won't come right by waiting
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "28") {
+			return err
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			return err
+		}
+
+		pause := min(delay, left)
+		log.Printf("database: not reachable yet, retrying in %s: %v", pause.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+		delay = min(delay*2, connectMaxRetry)
+	}
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"runtime/debug"
+	"sync"
+)
+
+// QueryCounter tallies the queries run with a context, for spotting
+// handlers that query once per row (N+1) instead of once per request.
+type QueryCounter struct {
+	limit int
+
+	mu    sync.Mutex
+	count int
+	stack []byte
+}
+
+type queryCounterKey struct{}
+
+// WithQueryCounter returns a context whose queries are counted. The stack
+// of the first query past limit is kept, since that is usually inside the
+// offending loop.
+func WithQueryCounter(ctx context.Context, limit int) (context.Context, *QueryCounter) {
+	qc := &QueryCounter{limit: limit}
+	return context.WithValue(ctx, queryCounterKey{}, qc), qc
+}
+
+// Count returns how many queries have run, and the stack of the first one
+// over the limit, if any.
+func (qc *QueryCounter) Count() (int, []byte) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	return qc.count, qc.stack
+}
+
+func countQuery(ctx context.Context) {
+	qc, ok := ctx.Value(queryCounterKey{}).(*QueryCounter)
+	if !ok {
+		return
+	}
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	qc.count++
+	if qc.count == qc.limit+1 {
+		qc.stack = debug.Stack()
+	}
+}
+
+// countingConnector wraps a driver connector so every query and exec on its
+// connections, including inside transactions, is counted.
+type countingConnector struct {
+	driver.Connector
+}
+
+func (c countingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{conn}, nil
+}
+
+// countingConn passes through the optional driver interfaces pgx
+// implements; without them database/sql would fall back to slower paths.
+type countingConn struct {
+	driver.Conn
+}
+
+func (c countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	countQuery(ctx)
+	return q.QueryContext(ctx, query, args)
+}
+
+func (c countingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	countQuery(ctx)
+	return e.ExecContext(ctx, query, args)
+}
+
+// CheckNamedValue hands arguments to the driver as they are, so it can
+// encode slices and other types database/sql doesn't know.
+func (c countingConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if ch, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (c countingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c countingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c countingConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c countingConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c countingConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
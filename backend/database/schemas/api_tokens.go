@@ -0,0 +1,253 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scopes an API token can be given.
+const (
+	// GET requests to the app's API
+	ScopeReadEvents = "read:events"
+	// Anything else the app's API does; implies read:events
+	ScopeWriteEvents = "write:events"
+	// The admin endpoints, such as tokens, webhooks and devices; implies the others
+	ScopeAdmin = "admin"
+)
+
+var apiScopes = []string{ScopeReadEvents, ScopeWriteEvents, ScopeAdmin}
+
+// Ways JSON responses can write times, for clients that can't parse the
+// default.
+const (
+	// RFC 3339 in UTC, the default
+	TimeFormatRFC3339 = "rfc3339"
+	// RFC 3339 with each time's own offset: occurrences in the zone asked
+	// for, everything else in the server's
+	TimeFormatOffset = "offset"
+	// Milliseconds since the Unix epoch, as a number
+	TimeFormatEpochMillis = "epochMillis"
+)
+
+var TimeFormats = []string{TimeFormatRFC3339, TimeFormatOffset, TimeFormatEpochMillis}
+
+// APIToken lets a client other than the app, such as a wall dashboard, call
+// the API with only the scopes it was given. The secret itself is only ever
+// stored hashed.
+type APIToken struct {
+	TokenID    string     `json:"tokenId"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	// How responses to requests made with the token write times, unless the
+	// request asks otherwise; empty is the default
+	TimeFormat string `json:"timeFormat,omitempty"`
+}
+
+// Allows reports whether the token may do what scope covers.
+func (t APIToken) Allows(scope string) bool {
+	switch {
+	case slices.Contains(t.Scopes, ScopeAdmin):
+		return true
+	case scope == ScopeReadEvents:
+		return slices.Contains(t.Scopes, ScopeReadEvents) || slices.Contains(t.Scopes, ScopeWriteEvents)
+	}
+	return slices.Contains(t.Scopes, scope)
+}
+
+const apiTokenColumns = `tokenID, name, scopes, expiresAt, createdAt, updatedAt, lastUsedAt, timeFormat`
+
+func scanAPIToken(row rowScanner, t *APIToken) error {
+	return row.Scan(
+		&t.TokenID,
+		&t.Name,
+		textArray(&t.Scopes),
+		&t.ExpiresAt,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.LastUsedAt,
+		&t.TimeFormat,
+	)
+}
+
+func CreateAPITokenSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "tokenID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		// SHA-256 of the secret, hex encoded
+		Column{Name: "tokenHash",
+			Type:   ColumnString,
+			Unique: true},
+		Column{Name: "name",
+			Type: ColumnString},
+		Column{Name: "scopes",
+			Type: ColumnTextArray},
+		Column{Name: "expiresAt",
+			Type:     ColumnTimestamp,
+			Nullable: true},
+		Column{Name: "lastUsedAt",
+			Type:     ColumnTimestamp,
+			Nullable: true},
+		Column{Name: "timeFormat",
+			Type:           ColumnString,
+			DefaultSQLExpr: SQLDefault("''")},
+	)
+
+	schema := Schema{Name: "api_tokens", Columns: cols}
+	return schema
+}
+
+func validateAPIToken(in *APIToken) error {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(in.Scopes) == 0 {
+		return fmt.Errorf("scopes are required: any of %s", strings.Join(apiScopes, ", "))
+	}
+	scopes := make([]string, 0, len(in.Scopes))
+	for _, sc := range in.Scopes {
+		if !slices.Contains(apiScopes, sc) {
+			return fmt.Errorf("unknown scope %q; use any of %s", sc, strings.Join(apiScopes, ", "))
+		}
+		if !slices.Contains(scopes, sc) {
+			scopes = append(scopes, sc)
+		}
+	}
+	in.Scopes = scopes
+	if in.TimeFormat != "" && !slices.Contains(TimeFormats, in.TimeFormat) {
+		return fmt.Errorf("unknown timeFormat %q; use any of %s", in.TimeFormat, strings.Join(TimeFormats, ", "))
+	}
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("expiresAt must be in the future")
+	}
+	return nil
+}
+
+// CreateAPIToken stores a token whose secret hashes to hash.
+func CreateAPIToken(ctx context.Context, db *sql.DB, in APIToken, hash string) (APIToken, error) {
+	if db == nil {
+		return APIToken{}, fmt.Errorf("db is nil")
+	}
+	if err := validateAPIToken(&in); err != nil {
+		return APIToken{}, err
+	}
+
+	row := db.QueryRowContext(ctx, `
+		INSERT INTO api_tokens (tokenHash, name, scopes, expiresAt, timeFormat)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+apiTokenColumns+`;
+	`, hash, in.Name, in.Scopes, in.ExpiresAt, in.TimeFormat)
+
+	var out APIToken
+	if err := scanAPIToken(row, &out); err != nil {
+		return APIToken{}, fmt.Errorf("insert api token: %w", err)
+	}
+
+	return out, nil
+}
+
+func ListAPITokens(ctx context.Context, db *sql.DB) ([]APIToken, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+apiTokenColumns+`
+		FROM api_tokens
+		ORDER BY createdAt, tokenID;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list api tokens query: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]APIToken, 0)
+	for rows.Next() {
+		var t APIToken
+		if err := scanAPIToken(rows, &t); err != nil {
+			return nil, fmt.Errorf("list api tokens scan: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list api tokens rows: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// UseAPIToken finds the unexpired token with hash and records that it was
+// used. Unknown, revoked and expired tokens all give sql.ErrNoRows.
+func UseAPIToken(ctx context.Context, db *sql.DB, hash string, now time.Time) (*APIToken, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		UPDATE api_tokens SET lastUsedAt = $2
+		WHERE tokenHash = $1 AND (expiresAt IS NULL OR expiresAt > $2)
+		RETURNING `+apiTokenColumns+`;
+	`, hash, now)
+
+	var t APIToken
+	if err := scanAPIToken(row, &t); err != nil {
+		return nil, fmt.Errorf("use api token: %w", err)
+	}
+
+	return &t, nil
+}
+
+// HasAdminAPIToken reports whether any unexpired token has the admin scope.
+func HasAdminAPIToken(ctx context.Context, db *sql.DB, now time.Time) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("db is nil")
+	}
+
+	var has bool
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM api_tokens
+			WHERE $1 = ANY(scopes) AND (expiresAt IS NULL OR expiresAt > $2)
+		);
+	`, ScopeAdmin, now).Scan(&has)
+	if err != nil {
+		return false, fmt.Errorf("admin api token query: %w", err)
+	}
+	return has, nil
+}
+
+// DeleteAPIToken revokes a token; requests made with it fail immediately.
+func DeleteAPIToken(ctx context.Context, db *sql.DB, id string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM api_tokens WHERE tokenID = $1`, id)
+	if err != nil {
+		return fmt.Errorf("Failed to execute api token delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,211 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// AttachmentStatus is what the virus scanner made of an attachment.
+type AttachmentStatus string
+
+const (
+	// Uploaded while no scanner was configured
+	AttachmentUnscanned AttachmentStatus = "unscanned"
+	AttachmentClean     AttachmentStatus = "clean"
+	// Kept for the admin to look at, but never served
+	AttachmentQuarantined AttachmentStatus = "quarantined"
+)
+
+// Attachment is a file uploaded to an event. The bytes live on disk under
+// the attachment's ID; this is only the metadata.
+type Attachment struct {
+	AttachmentID string           `json:"attachmentId"`
+	EventID      string           `json:"eventId"`
+	Filename     string           `json:"filename"`
+	ContentType  string           `json:"contentType"`
+	Size         int64            `json:"size"`
+	Status       AttachmentStatus `json:"status"`
+	// What the scanner found in a quarantined file
+	Threat    *string   `json:"threat,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+const attachmentColumns = `attachmentID, eventID, filename, contentType, size, status, threat, createdAt, updatedAt`
+
+func scanAttachment(row rowScanner, a *Attachment) error {
+	return row.Scan(
+		&a.AttachmentID,
+		&a.EventID,
+		&a.Filename,
+		&a.ContentType,
+		&a.Size,
+		&a.Status,
+		&a.Threat,
+		&a.CreatedAt,
+		&a.UpdatedAt,
+	)
+}
+
+func CreateAttachmentSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "attachmentID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		Column{Name: "eventID",
+			Type:       ColumnUUID,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "events", ColumnName: "eventID", OnDelete: FKCascade}}},
+		Column{Name: "filename",
+			Type: ColumnString},
+		Column{Name: "contentType",
+			Type: ColumnString},
+		Column{Name: "size",
+			Type: ColumnInt},
+		Column{Name: "status",
+			Type:           ColumnString,
+			DefaultSQLExpr: SQLDefault("'" + string(AttachmentUnscanned) + "'")},
+		Column{Name: "threat",
+			Type:     ColumnString,
+			Nullable: true},
+		Column{Name: "createdAt",
+			Type:           ColumnTimestamp,
+			DefaultSQLExpr: DefaultNow()},
+	)
+
+	schema := Schema{Name: "attachments", Columns: cols, Indexes: []Index{
+		{Name: "attachments_event_idx", Columns: []string{"eventID"}},
+	}}
+	return schema
+}
+
+// CreateAttachment stores an attachment's metadata; an empty Status means
+// unscanned.
+func CreateAttachment(ctx context.Context, db *sql.DB, in Attachment) (Attachment, error) {
+	if db == nil {
+		return Attachment{}, fmt.Errorf("db is nil")
+	}
+
+	if in.Status == "" {
+		in.Status = AttachmentUnscanned
+	}
+
+	row := db.QueryRowContext(ctx, `
+		INSERT INTO attachments (eventID, filename, contentType, size, status, threat)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+attachmentColumns+`;
+	`, in.EventID, in.Filename, in.ContentType, in.Size, in.Status, in.Threat)
+
+	var out Attachment
+	if err := scanAttachment(row, &out); err != nil {
+		return Attachment{}, fmt.Errorf("insert attachment: %w", err)
+	}
+
+	return out, nil
+}
+
+func GetAttachment(ctx context.Context, db *sql.DB, eventID, id string) (*Attachment, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		SELECT `+attachmentColumns+`
+		FROM attachments
+		WHERE eventID = $1 AND attachmentID = $2
+	`, eventID, id)
+
+	var a Attachment
+	if err := scanAttachment(row, &a); err != nil {
+		return nil, fmt.Errorf("get attachment scan: %w", err)
+	}
+
+	return &a, nil
+}
+
+func ListEventAttachments(ctx context.Context, db *sql.DB, eventID string) ([]Attachment, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+attachmentColumns+`
+		FROM attachments
+		WHERE eventID = $1
+		ORDER BY createdAt, attachmentID;
+	`, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("list attachments query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]Attachment, 0)
+	for rows.Next() {
+		var a Attachment
+		if err := scanAttachment(rows, &a); err != nil {
+			return nil, fmt.Errorf("list attachments scan: %w", err)
+		}
+		out = append(out, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list attachments rows: %w", err)
+	}
+
+	return out, nil
+}
+
+// ListAttachmentIDs returns the ID of every stored attachment, for finding
+// files whose rows went with a deleted event.
+func ListAttachmentIDs(ctx context.Context, db *sql.DB) (map[string]bool, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT attachmentID FROM attachments`)
+	if err != nil {
+		return nil, fmt.Errorf("list attachment ids query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("list attachment ids scan: %w", err)
+		}
+		out[id] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list attachment ids rows: %w", err)
+	}
+
+	return out, nil
+}
+
+func DeleteAttachment(ctx context.Context, db *sql.DB, eventID, id string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM attachments WHERE eventID = $1 AND attachmentID = $2`, eventID, id)
+	if err != nil {
+		return fmt.Errorf("Failed to execute attachment delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,351 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// ErrAttendeeExists is returned when the person or email is already on the event.
+var ErrAttendeeExists = errors.New("already attending this event")
+
+// RSVPStatus is an attendee's answer to an invitation.
+type RSVPStatus string
+
+const (
+	RSVPPending   RSVPStatus = "pending"
+	RSVPAccepted  RSVPStatus = "accepted"
+	RSVPDeclined  RSVPStatus = "declined"
+	RSVPTentative RSVPStatus = "tentative"
+)
+
+func (s RSVPStatus) valid() bool {
+	switch s {
+	case RSVPPending, RSVPAccepted, RSVPDeclined, RSVPTentative:
+		return true
+	}
+	return false
+}
+
+var errInvalidRSVP = fmt.Errorf("status must be one of %q, %q, %q or %q", RSVPPending, RSVPAccepted, RSVPDeclined, RSVPTentative)
+
+// Attendee is someone invited to an event: a person in the household, or
+// anyone else by email address.
+type Attendee struct {
+	AttendeeID string  `json:"attendeeId"`
+	EventID    string  `json:"eventId"`
+	PersonID   *string `json:"personId,omitempty"`
+	// Display name of the person; on add, used to find them when personId is empty
+	PersonName *string `json:"personName,omitempty"`
+	Email      *string `json:"email,omitempty"`
+	// Optional display name for an email attendee
+	Name        *string    `json:"name,omitempty"`
+	Status      RSVPStatus `json:"status"`
+	RespondedAt *time.Time `json:"respondedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// AttendeeSummary counts an event's attendees by response.
+type AttendeeSummary struct {
+	Total     int `json:"total"`
+	Accepted  int `json:"accepted"`
+	Declined  int `json:"declined"`
+	Tentative int `json:"tentative"`
+	Pending   int `json:"pending"`
+}
+
+// Scan reads the JSON object built by attendeeSummaryColumn.
+func (s *AttendeeSummary) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	case nil:
+		*s = AttendeeSummary{}
+		return nil
+	}
+	return fmt.Errorf("attendee summary: unexpected %T", src)
+}
+
+// attendeeSummaryColumn reads an event's AttendeeSummary as JSON.
+const attendeeSummaryColumn = `(
+		SELECT json_build_object(
+			'total', COUNT(*),
+			'accepted', COUNT(*) FILTER (WHERE status = 'accepted'),
+			'declined', COUNT(*) FILTER (WHERE status = 'declined'),
+			'tentative', COUNT(*) FILTER (WHERE status = 'tentative'),
+			'pending', COUNT(*) FILTER (WHERE status = 'pending'))
+		FROM attendees WHERE attendees.eventID = events.eventID)`
+
+var attendeeColumns = `attendeeID, eventID, personID, ` + personNameColumn("attendees") + ` AS personName,
+	email, name, status, respondedAt, createdAt, updatedAt`
+
+func scanAttendee(row rowScanner, a *Attendee) error {
+	return row.Scan(
+		&a.AttendeeID,
+		&a.EventID,
+		&a.PersonID,
+		&a.PersonName,
+		&a.Email,
+		&a.Name,
+		&a.Status,
+		&a.RespondedAt,
+		&a.CreatedAt,
+		&a.UpdatedAt,
+	)
+}
+
+func CreateAttendeeSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "attendeeID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		Column{Name: "eventID",
+			Type:       ColumnUUID,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "events", ColumnName: "eventID", OnDelete: FKCascade}}},
+		// Exactly one of personID and email is set
+		Column{Name: "personID",
+			Type:       ColumnUUID,
+			Nullable:   true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "people", ColumnName: "personID", OnDelete: FKCascade}}},
+		Column{Name: "email",
+			Type:     ColumnString,
+			Nullable: true},
+		Column{Name: "name",
+			Type:     ColumnString,
+			Nullable: true},
+		Column{Name: "status",
+			Type:           ColumnString,
+			DefaultSQLExpr: SQLDefault("'" + string(RSVPPending) + "'")},
+		Column{Name: "respondedAt",
+			Type:     ColumnTimestamp,
+			Nullable: true},
+		// SHA-256 of the secret in the attendee's RSVP link, hex encoded
+		Column{Name: "rsvpTokenHash",
+			Type:     ColumnString,
+			Nullable: true,
+			Unique:   true},
+	)
+
+	schema := Schema{Name: "attendees", Columns: cols, Indexes: []Index{
+		{Name: "attendees_person_idx", Columns: []string{"eventID", "personID"}, Unique: true},
+		{Name: "attendees_email_idx", Columns: []string{"eventID", "lower(email)"}, Unique: true},
+	}}
+	return schema
+}
+
+// AddAttendee invites a person, or an email address, to an event.
+// rsvpTokenHash is the hash of the secret in their RSVP link.
+func AddAttendee(ctx context.Context, db *sql.DB, in Attendee, rsvpTokenHash string) (Attendee, error) {
+	if db == nil {
+		return Attendee{}, fmt.Errorf("db is nil")
+	}
+
+	if in.Email != nil {
+		if in.PersonID != nil || in.PersonName != nil {
+			return Attendee{}, fmt.Errorf("give either personId/personName or email, not both")
+		}
+		addr, err := mail.ParseAddress(strings.TrimSpace(*in.Email))
+		if err != nil {
+			return Attendee{}, fmt.Errorf("email is not a valid address")
+		}
+		in.Email = &addr.Address
+		if in.Name == nil && addr.Name != "" {
+			in.Name = &addr.Name
+		}
+	} else {
+		var id, name string
+		if in.PersonID != nil {
+			id = *in.PersonID
+		}
+		if in.PersonName != nil {
+			name = *in.PersonName
+		}
+		if id == "" && name == "" {
+			return Attendee{}, fmt.Errorf("personId, personName or email is required")
+		}
+		if err := resolvePerson(ctx, db, &id, name); err != nil {
+			return Attendee{}, err
+		}
+		in.PersonID, in.Name = &id, nil
+	}
+
+	if in.Status == "" {
+		in.Status = RSVPPending
+	}
+	if !in.Status.valid() {
+		return Attendee{}, errInvalidRSVP
+	}
+	var responded *time.Time
+	if in.Status != RSVPPending {
+		now := time.Now()
+		responded = &now
+	}
+
+	var taken bool
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM attendees
+			WHERE eventID = $1 AND (personID = $2 OR lower(email) = lower($3))
+		);
+	`, in.EventID, in.PersonID, in.Email).Scan(&taken)
+	if err != nil {
+		return Attendee{}, fmt.Errorf("attendee exists query: %w", err)
+	}
+	if taken {
+		return Attendee{}, ErrAttendeeExists
+	}
+
+	row := db.QueryRowContext(ctx, `
+		INSERT INTO attendees (eventID, personID, email, name, status, respondedAt, rsvpTokenHash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+attendeeColumns+`;
+	`, in.EventID, in.PersonID, in.Email, in.Name, in.Status, responded, rsvpTokenHash)
+
+	var out Attendee
+	if err := scanAttendee(row, &out); err != nil {
+		return Attendee{}, fmt.Errorf("insert attendee: %w", err)
+	}
+	if err := logEventChange(ctx, db, out.EventID, false); err != nil {
+		return Attendee{}, err
+	}
+
+	return out, nil
+}
+
+// ListEventAttendees returns an event's attendees, household members first.
+func ListEventAttendees(ctx context.Context, db *sql.DB, eventID string) ([]Attendee, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+attendeeColumns+`
+		FROM attendees
+		WHERE eventID = $1
+		ORDER BY personID IS NULL, personName, name, email;
+	`, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("list attendees query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]Attendee, 0)
+	for rows.Next() {
+		var a Attendee
+		if err := scanAttendee(rows, &a); err != nil {
+			return nil, fmt.Errorf("list attendees scan: %w", err)
+		}
+		out = append(out, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list attendees rows: %w", err)
+	}
+
+	return out, nil
+}
+
+// SetAttendeeStatus records an attendee's response.
+func SetAttendeeStatus(ctx context.Context, db *sql.DB, eventID, attendeeID string, status RSVPStatus) (Attendee, error) {
+	if db == nil {
+		return Attendee{}, fmt.Errorf("db is nil")
+	}
+
+	if !status.valid() {
+		return Attendee{}, errInvalidRSVP
+	}
+
+	row := db.QueryRowContext(ctx, `
+		UPDATE attendees
+		SET status = $3, respondedAt = CASE WHEN $3 = 'pending' THEN NULL ELSE now() END
+		WHERE eventID = $1 AND attendeeID = $2
+		RETURNING `+attendeeColumns+`;
+	`, eventID, attendeeID, status)
+
+	var out Attendee
+	if err := scanAttendee(row, &out); err != nil {
+		return Attendee{}, fmt.Errorf("set attendee status: %w", err)
+	}
+	if err := logEventChange(ctx, db, eventID, false); err != nil {
+		return Attendee{}, err
+	}
+
+	return out, nil
+}
+
+// SetAttendeeRSVPToken gives the attendee a new RSVP link, the one whose
+// secret hashes to hash. Any earlier link stops working.
+func SetAttendeeRSVPToken(ctx context.Context, db *sql.DB, eventID, attendeeID, hash string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		UPDATE attendees SET rsvpTokenHash = $3
+		WHERE eventID = $1 AND attendeeID = $2`, eventID, attendeeID, hash)
+	if err != nil {
+		return fmt.Errorf("set rsvp token: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetAttendeeByRSVPToken returns the attendee whose RSVP link's secret
+// hashes to hash, or sql.ErrNoRows.
+func GetAttendeeByRSVPToken(ctx context.Context, db *sql.DB, hash string) (Attendee, error) {
+	if db == nil {
+		return Attendee{}, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		SELECT `+attendeeColumns+`
+		FROM attendees
+		WHERE rsvpTokenHash = $1;
+	`, hash)
+
+	var out Attendee
+	if err := scanAttendee(row, &out); err != nil {
+		return Attendee{}, fmt.Errorf("get attendee by rsvp token: %w", err)
+	}
+	return out, nil
+}
+
+func DeleteAttendee(ctx context.Context, db *sql.DB, eventID, attendeeID string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM attendees WHERE eventID = $1 AND attendeeID = $2`, eventID, attendeeID)
+	if err != nil {
+		return fmt.Errorf("Failed to execute attendee delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return logEventChange(ctx, db, eventID, false)
+}
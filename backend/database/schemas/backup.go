@@ -0,0 +1,289 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Backup is every table that holds the household's data, as JSON rows
+// keyed by column name, for moving an install to another Pi.
+type Backup struct {
+	// The migration the tables were at; only a server at the same one can
+	// restore them
+	Migration  int       `json:"migration"`
+	ExportedAt time.Time `json:"exportedAt"`
+	// By table name, each a JSON array of rows
+	Tables map[string]json.RawMessage `json:"tables"`
+}
+
+// ErrIncompatibleBackup is returned by RestoreBackup for a backup this
+// server can't take as it is.
+var ErrIncompatibleBackup = errors.New("backup doesn't fit this server")
+
+// Tables a backup leaves out: the migration history, which the server
+// keeps itself, attachments, whose files live outside the database,
+// short-lived bookkeeping nobody would miss, and the logins for where
+// backups go, which shouldn't travel inside them.
+var unbackedTables = []string{"schema_migrations", "attachments", "webhook_deliveries", "idempotency_keys", "travel_times", "backup_targets"}
+
+// backupTables are the tables a backup holds, in the order they were
+// created in, which restoring them in satisfies their foreign keys.
+func backupTables() []string {
+	out := make([]string, 0, len(tableSchemas))
+	for _, create := range tableSchemas {
+		if name := create().Name; !slices.Contains(unbackedTables, name) {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// ExportBackup reads every backed-up table from one snapshot, so the rows
+// agree with each other even while the server is in use.
+func ExportBackup(ctx context.Context, db *sql.DB) (Backup, error) {
+	if db == nil {
+		return Backup{}, fmt.Errorf("db is nil")
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return Backup{}, fmt.Errorf("begin export: %w", err)
+	}
+	defer tx.Rollback()
+
+	out := Backup{Migration: LatestMigration(), ExportedAt: time.Now().UTC(), Tables: make(map[string]json.RawMessage)}
+	for _, name := range backupTables() {
+		var rows []byte
+		if err := tx.QueryRowContext(ctx, `
+			SELECT COALESCE(json_agg(t), '[]') FROM `+name+` t;
+		`).Scan(&rows); err != nil {
+			return Backup{}, fmt.Errorf("export %s: %w", name, err)
+		}
+		out.Tables[name] = rows
+	}
+
+	return out, nil
+}
+
+// RestoreBackup replaces everything in the backed-up tables with in, in
+// one transaction, and returns how many rows each table got. Columns the
+// backup doesn't have take their defaults, so a backup from before a column
+// was added still restores. The occurrence cache is restored as it was;
+// the materializer catches up on anything stale.
+func RestoreBackup(ctx context.Context, db *sql.DB, in Backup) (map[string]int, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+	if err := checkBackup(in); err != nil {
+		return nil, err
+	}
+
+	var counts map[string]int
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		var err error
+		counts, err = restoreTables(ctx, tx, in)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// checkBackup makes sure in is from this server's migration and holds no
+// table it doesn't know.
+func checkBackup(in Backup) error {
+	if in.Migration != LatestMigration() {
+		return fmt.Errorf("%w: it is from migration %d and this server is at %d; restore it with a server at the same one", ErrIncompatibleBackup, in.Migration, LatestMigration())
+	}
+	tables := backupTables()
+	for name := range in.Tables {
+		if !slices.Contains(tables, name) {
+			return fmt.Errorf("%w: unknown table %q", ErrIncompatibleBackup, name)
+		}
+	}
+	return nil
+}
+
+// restoreTables empties the backed-up tables on the search path and fills
+// them from in, returning how many rows each got.
+func restoreTables(ctx context.Context, tx *sql.Tx, in Backup) (map[string]int, error) {
+	tables := backupTables()
+	counts := make(map[string]int, len(tables))
+
+	// Children first, so foreign keys never point at a row that's gone
+	for i := len(tables) - 1; i >= 0; i-- {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+tables[i]); err != nil {
+			return nil, fmt.Errorf("restore: empty %s: %w", tables[i], err)
+		}
+	}
+
+	for _, name := range tables {
+		counts[name] = 0
+		rows, ok := in.Tables[name]
+		if !ok {
+			continue
+		}
+		cols, err := restoreColumns(ctx, tx, name, rows)
+		if err != nil {
+			return nil, err
+		}
+		if cols == "" {
+			continue
+		}
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO `+name+` (`+cols+`)
+			SELECT `+cols+` FROM json_populate_recordset(NULL::`+name+`, $1::json);
+		`, string(rows))
+		if err != nil {
+			return nil, fmt.Errorf("restore %s: %w", name, err)
+		}
+		n, _ := result.RowsAffected()
+		counts[name] = int(n)
+
+		if err := resetSequences(ctx, tx, name); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+// restoreDrillSchema is where VerifyBackup restores a backup.
+const restoreDrillSchema = "pical_restore_drill"
+
+// BackupCheck is how a backup fared being restored by VerifyBackup.
+type BackupCheck struct {
+	OK bool `json:"ok"`
+	// What went wrong when OK is false
+	Error  string             `json:"error,omitempty"`
+	Tables []BackupTableCheck `json:"tables"`
+}
+
+// BackupTableCheck compares one table's rows in a backup with what it held
+// once the backup was restored.
+type BackupTableCheck struct {
+	Table    string `json:"table"`
+	Backup   int    `json:"backup"`
+	Restored int    `json:"restored"`
+}
+
+// VerifyBackup proves in can be restored without touching the live
+// tables. Like VerifyBootstrap, it builds every table in a scratch Postgres
+// schema inside a transaction that is always rolled back, then restores in
+// there and counts each table's rows against the backup's. A backup that
+// won't restore, or restores short, comes back as a failed check; the
+// error is for when the check itself couldn't be run.
+func VerifyBackup(ctx context.Context, db *sql.DB, in Backup) (BackupCheck, error) {
+	if db == nil {
+		return BackupCheck{}, fmt.Errorf("db is nil")
+	}
+	out := BackupCheck{Tables: make([]BackupTableCheck, 0)}
+	if err := checkBackup(in); err != nil {
+		out.Error = err.Error()
+		return out, nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return BackupCheck{}, fmt.Errorf("begin restore drill: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `CREATE SCHEMA `+restoreDrillSchema+`; SET LOCAL search_path TO `+restoreDrillSchema); err != nil {
+		return BackupCheck{}, fmt.Errorf("restore drill schema: %w", err)
+	}
+	for _, m := range migrations {
+		if err := m.Up(ctx, tx); err != nil {
+			return BackupCheck{}, fmt.Errorf("restore drill: migration %d %s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	if _, err := restoreTables(ctx, tx, in); err != nil {
+		out.Error = err.Error()
+		return out, nil
+	}
+
+	short := make([]string, 0)
+	for _, name := range backupTables() {
+		check := BackupTableCheck{Table: name}
+		if rows, ok := in.Tables[name]; ok {
+			var list []json.RawMessage
+			if err := json.Unmarshal(rows, &list); err != nil {
+				out.Error = fmt.Sprintf("%s: %v", name, err)
+				return out, nil
+			}
+			check.Backup = len(list)
+		}
+		if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM `+name).Scan(&check.Restored); err != nil {
+			return BackupCheck{}, fmt.Errorf("restore drill count %s: %w", name, err)
+		}
+		if check.Restored != check.Backup {
+			short = append(short, fmt.Sprintf("%s has %d rows in the backup but %d restored", name, check.Backup, check.Restored))
+		}
+		out.Tables = append(out.Tables, check)
+	}
+
+	out.OK = len(short) == 0
+	out.Error = strings.Join(short, "; ")
+	return out, nil
+}
+
+// restoreColumns lists, ready for an INSERT, the columns of table that the
+// backup's rows have values for. Generated columns are left out, since
+// Postgres computes those itself.
+func restoreColumns(ctx context.Context, q queryer, table string, rows json.RawMessage) (string, error) {
+	var cols string
+	err := q.QueryRowContext(ctx, `
+		SELECT COALESCE(string_agg(column_name, ', ' ORDER BY ordinal_position), '')
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND is_generated = 'NEVER'
+			AND column_name IN (SELECT json_object_keys(r) FROM json_array_elements($2::json) r);
+	`, table, string(rows)).Scan(&cols)
+	if err != nil {
+		return "", fmt.Errorf("restore %s columns: %w", table, err)
+	}
+	return cols, nil
+}
+
+// resetSequences moves table's serial columns on past the restored rows, so
+// the next insert doesn't collide with one of them.
+func resetSequences(ctx context.Context, tx *sql.Tx, table string) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT column_name
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND column_default LIKE 'nextval(%';
+	`, table)
+	if err != nil {
+		return fmt.Errorf("restore %s sequences query: %w", table, err)
+	}
+	cols := make([]string, 0)
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			rows.Close()
+			return fmt.Errorf("restore %s sequences scan: %w", table, err)
+		}
+		cols = append(cols, col)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return fmt.Errorf("restore %s sequences rows: %w", table, err)
+	}
+
+	for _, col := range cols {
+		if _, err := tx.ExecContext(ctx, `
+			SELECT setval(pg_get_serial_sequence($1, $2), COALESCE((SELECT MAX(`+col+`) FROM `+table+`), 0) + 1, false);
+		`, table, col); err != nil {
+			return fmt.Errorf("restore %s sequence for %s: %w", table, col, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// BackupTarget is somewhere scheduled backups are written besides
+// BACKUP_TARGET, each keeping its own number of them.
+type BackupTarget struct {
+	TargetID string `json:"targetId"`
+	Name     string `json:"name"`
+	// As for BACKUP_TARGET: s3://, dav://, davs://, sftp:// or a directory
+	URL string `json:"url"`
+	// For s3:// targets, which take Username as the access key
+	S3Endpoint string `json:"s3Endpoint"`
+	S3Region   string `json:"s3Region"`
+	Username   string `json:"username"`
+	// For sftp:// targets: the server's public key, in authorized_keys format
+	HostKey string `json:"hostKey"`
+	// How many backups are kept there; zero keeps them all
+	Keep int `json:"keep"`
+	// Whether backups are encrypted with BACKUP_ENCRYPTION_KEY on the way
+	Encrypt bool `json:"encrypt"`
+	// Whether a password or private key is stored; neither is ever sent back
+	HasSecret bool      `json:"hasSecret"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// BackupTargetSecrets are how a backup target is logged in to.
+type BackupTargetSecrets struct {
+	// The password, or for s3:// the secret key
+	Secret string `json:"secret,omitempty"`
+	// For sftp://, a PEM private key
+	PrivateKey string `json:"privateKey,omitempty"`
+}
+
+// BackupTargetLogin is a backup target with its secrets, for writing to it.
+type BackupTargetLogin struct {
+	BackupTarget
+	BackupTargetSecrets
+}
+
+const backupTargetColumns = `targetID, name, url, s3Endpoint, s3Region, username, hostKey, keep, encrypt,
+	secret <> '' OR privateKey <> '', createdAt, updatedAt`
+
+func scanBackupTarget(row rowScanner, t *BackupTarget, extra ...any) error {
+	dest := []any{
+		&t.TargetID,
+		&t.Name,
+		&t.URL,
+		&t.S3Endpoint,
+		&t.S3Region,
+		&t.Username,
+		&t.HostKey,
+		&t.Keep,
+		&t.Encrypt,
+		&t.HasSecret,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+func CreateBackupTargetSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "targetID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		Column{Name: "name",
+			Type:   ColumnString,
+			Unique: true},
+		Column{Name: "url",
+			Type: ColumnText},
+		Column{Name: "s3Endpoint",
+			Type:           ColumnText,
+			DefaultSQLExpr: SQLDefault("''")},
+		Column{Name: "s3Region",
+			Type:           ColumnString,
+			DefaultSQLExpr: SQLDefault("''")},
+		Column{Name: "username",
+			Type:           ColumnString,
+			DefaultSQLExpr: SQLDefault("''")},
+		// Used to log in, so kept as is rather than hashed
+		Column{Name: "secret",
+			Type:           ColumnText,
+			DefaultSQLExpr: SQLDefault("''")},
+		Column{Name: "privateKey",
+			Type:           ColumnText,
+			DefaultSQLExpr: SQLDefault("''")},
+		Column{Name: "hostKey",
+			Type:           ColumnText,
+			DefaultSQLExpr: SQLDefault("''")},
+		Column{Name: "keep",
+			Type:           ColumnInt,
+			DefaultSQLExpr: SQLDefault("7")},
+		Column{Name: "encrypt",
+			Type:           ColumnBool,
+			DefaultSQLExpr: SQLDefault("false")},
+	)
+
+	schema := Schema{Name: "backup_targets", Columns: cols}
+	return schema
+}
+
+func validateBackupTarget(in *BackupTarget) error {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	in.URL = strings.TrimSpace(in.URL)
+	if in.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	if in.Keep < 0 {
+		return fmt.Errorf("keep must not be negative")
+	}
+	return nil
+}
+
+// CreateBackupTarget adds a backup target, logged in to with secrets.
+func CreateBackupTarget(ctx context.Context, db *sql.DB, in BackupTarget, secrets BackupTargetSecrets) (BackupTarget, error) {
+	if db == nil {
+		return BackupTarget{}, fmt.Errorf("db is nil")
+	}
+
+	if err := validateBackupTarget(&in); err != nil {
+		return BackupTarget{}, err
+	}
+
+	row := db.QueryRowContext(ctx, `
+		INSERT INTO backup_targets (name, url, s3Endpoint, s3Region, username, secret, privateKey, hostKey, keep, encrypt)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING `+backupTargetColumns+`;
+	`, in.Name, in.URL, in.S3Endpoint, in.S3Region, in.Username, secrets.Secret, secrets.PrivateKey, in.HostKey, in.Keep, in.Encrypt)
+
+	var out BackupTarget
+	if err := scanBackupTarget(row, &out); err != nil {
+		return BackupTarget{}, fmt.Errorf("insert backup target: %w", err)
+	}
+
+	return out, nil
+}
+
+// UpdateBackupTarget replaces a backup target's settings. Secrets left
+// empty stay as they were.
+func UpdateBackupTarget(ctx context.Context, db *sql.DB, id string, in BackupTarget, secrets BackupTargetSecrets) (BackupTarget, error) {
+	if db == nil {
+		return BackupTarget{}, fmt.Errorf("db is nil")
+	}
+
+	if err := validateBackupTarget(&in); err != nil {
+		return BackupTarget{}, err
+	}
+
+	row := db.QueryRowContext(ctx, `
+		UPDATE backup_targets SET name = $2, url = $3, s3Endpoint = $4, s3Region = $5, username = $6,
+			secret = COALESCE(NULLIF($7, ''), secret), privateKey = COALESCE(NULLIF($8, ''), privateKey),
+			hostKey = $9, keep = $10, encrypt = $11
+		WHERE targetID = $1
+		RETURNING `+backupTargetColumns+`;
+	`, id, in.Name, in.URL, in.S3Endpoint, in.S3Region, in.Username, secrets.Secret, secrets.PrivateKey, in.HostKey, in.Keep, in.Encrypt)
+
+	var out BackupTarget
+	if err := scanBackupTarget(row, &out); err != nil {
+		return BackupTarget{}, fmt.Errorf("update backup target: %w", err)
+	}
+
+	return out, nil
+}
+
+func GetBackupTarget(ctx context.Context, db *sql.DB, id string) (*BackupTargetLogin, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		SELECT `+backupTargetColumns+`, secret, privateKey
+		FROM backup_targets
+		WHERE targetID = $1
+	`, id)
+
+	var t BackupTargetLogin
+	if err := scanBackupTarget(row, &t.BackupTarget, &t.Secret, &t.PrivateKey); err != nil {
+		return nil, fmt.Errorf("get backup target scan: %w", err)
+	}
+
+	return &t, nil
+}
+
+// ListBackupTargets returns every backup target with its secrets, which
+// callers showing them must leave out.
+func ListBackupTargets(ctx context.Context, db *sql.DB) ([]BackupTargetLogin, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+backupTargetColumns+`, secret, privateKey
+		FROM backup_targets
+		ORDER BY name;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list backup targets query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]BackupTargetLogin, 0)
+	for rows.Next() {
+		var t BackupTargetLogin
+		if err := scanBackupTarget(rows, &t.BackupTarget, &t.Secret, &t.PrivateKey); err != nil {
+			return nil, fmt.Errorf("list backup targets scan: %w", err)
+		}
+		out = append(out, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list backup targets rows: %w", err)
+	}
+
+	return out, nil
+}
+
+func DeleteBackupTarget(ctx context.Context, db *sql.DB, id string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM backup_targets WHERE targetID = $1`, id)
+	if err != nil {
+		return fmt.Errorf("Failed to execute backup target delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrCalendarInUse is returned when deleting a calendar that still has events or feeds.
+var ErrCalendarInUse = errors.New("calendar still has events or feeds")
+
+// Calendar groups events, e.g. "Family", "Work" or "School".
+type Calendar struct {
+	CalendarID string `json:"calendarId"`
+	Name       string `json:"name"`
+	// CSS hex colour, e.g. "#3b82f6"
+	Color     *string   `json:"color,omitempty"`
+	SortOrder int       `json:"sortOrder"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+const calendarColumns = `calendarID, name, color, sortOrder, createdAt, updatedAt`
+
+func scanCalendar(row rowScanner, c *Calendar) error {
+	return row.Scan(
+		&c.CalendarID,
+		&c.Name,
+		&c.Color,
+		&c.SortOrder,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
+}
+
+func CreateCalendarSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "calendarID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		Column{Name: "name",
+			Type: ColumnString},
+		Column{Name: "color",
+			Type:     ColumnString,
+			Nullable: true},
+		Column{Name: "sortOrder",
+			Type:           ColumnInt,
+			DefaultSQLExpr: SQLDefault("0")},
+	)
+
+	schema := Schema{Name: "calendars", Columns: cols}
+	return schema
+}
+
+func validateCalendar(in Calendar) error {
+	if in.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if in.Color != nil && !isHexColor(*in.Color) {
+		return fmt.Errorf("color must be a hex colour like #3b82f6")
+	}
+	return nil
+}
+
+func CreateCalendar(ctx context.Context, db *sql.DB, in Calendar) (Calendar, error) {
+	if db == nil {
+		return Calendar{}, fmt.Errorf("db is nil")
+	}
+
+	in.Name = strings.TrimSpace(in.Name)
+	if err := validateCalendar(in); err != nil {
+		return Calendar{}, err
+	}
+
+	row := db.QueryRowContext(ctx, `
+		INSERT INTO calendars (name, color, sortOrder)
+		VALUES ($1, $2, $3)
+		RETURNING `+calendarColumns+`;
+	`, in.Name, in.Color, in.SortOrder)
+
+	var out Calendar
+	if err := scanCalendar(row, &out); err != nil {
+		return Calendar{}, fmt.Errorf("insert calendar: %w", err)
+	}
+
+	return out, nil
+}
+
+func UpdateCalendar(ctx context.Context, db *sql.DB, id string, in Calendar) (Calendar, error) {
+	if db == nil {
+		return Calendar{}, fmt.Errorf("db is nil")
+	}
+
+	in.Name = strings.TrimSpace(in.Name)
+	if err := validateCalendar(in); err != nil {
+		return Calendar{}, err
+	}
+
+	row := db.QueryRowContext(ctx, `
+		UPDATE calendars SET name = $2, color = $3, sortOrder = $4
+		WHERE calendarID = $1
+		RETURNING `+calendarColumns+`;
+	`, id, in.Name, in.Color, in.SortOrder)
+
+	var out Calendar
+	if err := scanCalendar(row, &out); err != nil {
+		return Calendar{}, fmt.Errorf("update calendar: %w", err)
+	}
+
+	return out, nil
+}
+
+func GetCalendar(ctx context.Context, db *sql.DB, id string) (*Calendar, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		SELECT `+calendarColumns+`
+		FROM calendars
+		WHERE calendarID = $1
+	`, id)
+
+	var c Calendar
+	if err := scanCalendar(row, &c); err != nil {
+		return nil, fmt.Errorf("get calendar scan: %w", err)
+	}
+
+	return &c, nil
+}
+
+// ListCalendars returns every calendar in display order.
+func ListCalendars(ctx context.Context, db *sql.DB) ([]Calendar, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+calendarColumns+`
+		FROM calendars
+		ORDER BY sortOrder, name, calendarID;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list calendars query: %w", err)
+	}
+	defer rows.Close()
+
+	calendars := make([]Calendar, 0)
+	for rows.Next() {
+		var c Calendar
+		if err := scanCalendar(rows, &c); err != nil {
+			return nil, fmt.Errorf("list calendars scan: %w", err)
+		}
+		calendars = append(calendars, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list calendars rows: %w", err)
+	}
+
+	return calendars, nil
+}
+
+// DeleteCalendar removes an empty calendar.
+func DeleteCalendar(ctx context.Context, db *sql.DB, id string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM calendars
+		WHERE calendarID = $1
+			AND NOT EXISTS (SELECT 1 FROM events WHERE calendarID = $1)
+			AND NOT EXISTS (SELECT 1 FROM feeds WHERE calendarID = $1)`, id)
+	if err != nil {
+		return fmt.Errorf("Failed to execute calendar delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		if _, err := GetCalendar(ctx, db, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return sql.ErrNoRows
+			}
+			return err
+		}
+		return ErrCalendarInUse
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// EventChange is the latest change to an event. Seq grows with every change
+// to any event, so a client that has seen up to some Seq only needs the rows
+// after it.
+type EventChange struct {
+	EventID   string
+	Seq       int64
+	Deleted   bool
+	ChangedAt time.Time
+}
+
+// CreateEventChangeSchema keeps one row per event, moved to a new seq each
+// time the event changes. Rows for deleted events stay behind as tombstones
+// so clients learn of the deletion. There is no foreign key for that reason.
+func CreateEventChangeSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "eventID",
+			Type:       ColumnUUID,
+			PrimaryKey: true},
+		Column{Name: "seq",
+			Type: ColumnBigSerial},
+		Column{Name: "deleted",
+			Type:           ColumnBool,
+			DefaultSQLExpr: DefaultFalse()},
+		Column{Name: "changedAt",
+			Type:           ColumnTimestamp,
+			DefaultSQLExpr: DefaultNow()},
+	)
+
+	schema := Schema{Name: "event_changes", Columns: cols, Indexes: []Index{
+		{Name: "event_changes_seq_idx", Columns: []string{"seq"}, Unique: true},
+	}}
+	return schema
+}
+
+// logEventChange records that an event was written or deleted. Every write
+// to an event, including to what it shows from other tables, goes through
+// here or logEventChanges.
+func logEventChange(ctx context.Context, q queryer, eventID string, deleted bool) error {
+	if _, err := q.ExecContext(ctx, `
+		INSERT INTO event_changes (eventID, deleted)
+		VALUES ($1, $2)
+		ON CONFLICT (eventID) DO UPDATE
+		SET seq = DEFAULT, deleted = EXCLUDED.deleted, changedAt = CURRENT_TIMESTAMP
+	`, eventID, deleted); err != nil {
+		return fmt.Errorf("log event change: %w", err)
+	}
+	return nil
+}
+
+// logEventChanges is logEventChange for every event matching cond, for
+// writes that reach many events at once.
+func logEventChanges(ctx context.Context, q queryer, deleted bool, cond string, args ...any) error {
+	if _, err := q.ExecContext(ctx, `
+		INSERT INTO event_changes (eventID, deleted)
+		SELECT eventID, `+fmt.Sprint(deleted)+` FROM events WHERE `+cond+`
+		ON CONFLICT (eventID) DO UPDATE
+		SET seq = DEFAULT, deleted = EXCLUDED.deleted, changedAt = CURRENT_TIMESTAMP
+	`, args...); err != nil {
+		return fmt.Errorf("log event changes: %w", err)
+	}
+	return nil
+}
+
+// backfillEventChanges logs events that predate the change log, so a full
+// sync finds them.
+func backfillEventChanges(ctx context.Context, q queryer) error {
+	if _, err := q.ExecContext(ctx, `
+		INSERT INTO event_changes (eventID)
+		SELECT eventID FROM events
+		ON CONFLICT DO NOTHING
+	`); err != nil {
+		return fmt.Errorf("backfill event changes: %w", err)
+	}
+	return nil
+}
+
+// ListEventChanges returns up to limit changes after seq since, oldest first.
+func ListEventChanges(ctx context.Context, db *sql.DB, since int64, limit int) ([]EventChange, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT eventID, seq, deleted, changedAt
+		FROM event_changes
+		WHERE seq > $1
+		ORDER BY seq
+		LIMIT $2;
+	`, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list event changes query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]EventChange, 0)
+	for rows.Next() {
+		var c EventChange
+		if err := rows.Scan(&c.EventID, &c.Seq, &c.Deleted, &c.ChangedAt); err != nil {
+			return nil, fmt.Errorf("list event changes scan: %w", err)
+		}
+		out = append(out, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list event changes rows: %w", err)
+	}
+
+	return out, nil
+}
+
+// LatestEventChangeSeq returns the seq of the most recent change, or zero
+// before the first. It moves whenever any event does.
+func LatestEventChangeSeq(ctx context.Context, db *sql.DB) (int64, error) {
+	if db == nil {
+		return 0, fmt.Errorf("db is nil")
+	}
+
+	var seq int64
+	if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(seq), 0) FROM event_changes`).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("latest event change query: %w", err)
+	}
+	return seq, nil
+}
+
+// PruneEventChanges forgets deletions logged before cutoff. Clients that
+// last synced before then have to start over.
+func PruneEventChanges(ctx context.Context, db *sql.DB, cutoff time.Time) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM event_changes WHERE deleted AND changedAt < $1`, cutoff); err != nil {
+		return fmt.Errorf("prune event changes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Commands a kiosk picks up with its next heartbeat.
+const (
+	DeviceCommandReload = "reload"
+	DeviceCommandReboot = "reboot"
+)
+
+// Device is a kiosk display that reports in with regular heartbeats.
+type Device struct {
+	DeviceID string `json:"deviceId"`
+	Name     string `json:"name"`
+	// Profile the display shows, if any
+	DisplayProfileID *string    `json:"displayProfileId,omitempty"`
+	LastSeenAt       *time.Time `json:"lastSeenAt,omitempty"`
+	// Set once the watchdog notices missed heartbeats; cleared by the next one
+	MissingSince *time.Time `json:"missingSince,omitempty"`
+	// Sent with the next heartbeat, then cleared
+	PendingCommand *string   `json:"pendingCommand,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+const deviceColumns = `deviceID, name, displayProfileID, lastSeenAt, missingSince, pendingCommand, createdAt, updatedAt`
+
+func scanDevice(row rowScanner, d *Device) error {
+	return row.Scan(
+		&d.DeviceID,
+		&d.Name,
+		&d.DisplayProfileID,
+		&d.LastSeenAt,
+		&d.MissingSince,
+		&d.PendingCommand,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+	)
+}
+
+func CreateDeviceSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "deviceID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		Column{Name: "name",
+			Type: ColumnString},
+		Column{Name: "displayProfileID",
+			Type:       ColumnUUID,
+			Nullable:   true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "display_profiles", ColumnName: "profileID", OnDelete: FKSetNull}}},
+		Column{Name: "lastSeenAt",
+			Type:     ColumnTimestamp,
+			Nullable: true},
+		Column{Name: "missingSince",
+			Type:     ColumnTimestamp,
+			Nullable: true},
+		Column{Name: "pendingCommand",
+			Type:     ColumnString,
+			Nullable: true},
+		Column{Name: "createdAt",
+			Type:           ColumnTimestamp,
+			DefaultSQLExpr: DefaultNow()},
+	)
+
+	schema := Schema{Name: "devices", Columns: cols}
+	return schema
+}
+
+// ValidDeviceCommand reports whether cmd is a command kiosks understand.
+func ValidDeviceCommand(cmd string) bool {
+	return cmd == DeviceCommandReload || cmd == DeviceCommandReboot
+}
+
+func CreateDevice(ctx context.Context, db *sql.DB, in Device) (Device, error) {
+	if db == nil {
+		return Device{}, fmt.Errorf("db is nil")
+	}
+
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return Device{}, fmt.Errorf("name is required")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		INSERT INTO devices (name, displayProfileID)
+		VALUES ($1, $2)
+		RETURNING `+deviceColumns+`;
+	`, in.Name, in.DisplayProfileID)
+
+	var out Device
+	if err := scanDevice(row, &out); err != nil {
+		return Device{}, fmt.Errorf("insert device: %w", err)
+	}
+
+	return out, nil
+}
+
+func GetDevice(ctx context.Context, db *sql.DB, id string) (*Device, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		SELECT `+deviceColumns+`
+		FROM devices
+		WHERE deviceID = $1
+	`, id)
+
+	var d Device
+	if err := scanDevice(row, &d); err != nil {
+		return nil, fmt.Errorf("get device scan: %w", err)
+	}
+
+	return &d, nil
+}
+
+func ListDevices(ctx context.Context, db *sql.DB) ([]Device, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+deviceColumns+`
+		FROM devices
+		ORDER BY name, deviceID;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list devices query: %w", err)
+	}
+	defer rows.Close()
+
+	return collectDevices(rows, "list devices")
+}
+
+func collectDevices(rows *sql.Rows, op string) ([]Device, error) {
+	devices := make([]Device, 0)
+	for rows.Next() {
+		var d Device
+		if err := scanDevice(rows, &d); err != nil {
+			return nil, fmt.Errorf("%s scan: %w", op, err)
+		}
+		devices = append(devices, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s rows: %w", op, err)
+	}
+
+	return devices, nil
+}
+
+func DeleteDevice(ctx context.Context, db *sql.DB, id string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM devices WHERE deviceID = $1`, id)
+	if err != nil {
+		return fmt.Errorf("Failed to execute device delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// RecordHeartbeat marks the device as seen at now and hands over its pending
+// command, if any. The returned Device is as it was before the heartbeat, so
+// callers can tell whether it had been missing.
+func RecordHeartbeat(ctx context.Context, db *sql.DB, id string, now time.Time) (*Device, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	var before Device
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		// Locked so a command queued meanwhile is never lost
+		row := tx.QueryRowContext(ctx, `
+			SELECT `+deviceColumns+`
+			FROM devices
+			WHERE deviceID = $1
+			FOR UPDATE
+		`, id)
+
+		if err := scanDevice(row, &before); err != nil {
+			return fmt.Errorf("heartbeat scan: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE devices SET lastSeenAt = $2, missingSince = NULL, pendingCommand = NULL
+			WHERE deviceID = $1
+		`, id, now); err != nil {
+			return fmt.Errorf("record heartbeat: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &before, nil
+}
+
+// QueueDeviceCommand replaces the device's pending command with cmd.
+func QueueDeviceCommand(ctx context.Context, db *sql.DB, id, cmd string) (*Device, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	if !ValidDeviceCommand(cmd) {
+		return nil, fmt.Errorf("command must be %q or %q", DeviceCommandReload, DeviceCommandReboot)
+	}
+
+	row := db.QueryRowContext(ctx, `
+		UPDATE devices SET pendingCommand = $2
+		WHERE deviceID = $1
+		RETURNING `+deviceColumns+`;
+	`, id, cmd)
+
+	var d Device
+	if err := scanDevice(row, &d); err != nil {
+		return nil, fmt.Errorf("queue device command: %w", err)
+	}
+
+	return &d, nil
+}
+
+// MarkMissingDevices flags devices last seen before cutoff as missing since
+// now and queues a reload for when they come back. Devices already flagged,
+// and ones that have never sent a heartbeat, are left alone; only newly
+// missing devices are returned.
+func MarkMissingDevices(ctx context.Context, db *sql.DB, cutoff, now time.Time) ([]Device, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		UPDATE devices SET missingSince = $2, pendingCommand = coalesce(pendingCommand, $3)
+		WHERE missingSince IS NULL AND lastSeenAt < $1
+		RETURNING `+deviceColumns+`;
+	`, cutoff, now, DeviceCommandReload)
+	if err != nil {
+		return nil, fmt.Errorf("mark missing devices query: %w", err)
+	}
+	defer rows.Close()
+
+	return collectDevices(rows, "mark missing devices")
+}
+
+// EscalateMissingDevices upgrades the queued reload to a reboot for devices
+// missing since before cutoff, returning those it changed.
+func EscalateMissingDevices(ctx context.Context, db *sql.DB, cutoff time.Time) ([]Device, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		UPDATE devices SET pendingCommand = $3
+		WHERE missingSince < $1 AND pendingCommand = $2
+		RETURNING `+deviceColumns+`;
+	`, cutoff, DeviceCommandReload, DeviceCommandReboot)
+	if err != nil {
+		return nil, fmt.Errorf("escalate missing devices query: %w", err)
+	}
+	defer rows.Close()
+
+	return collectDevices(rows, "escalate missing devices")
+}
@@ -0,0 +1,296 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// DisplayProfile is a saved view for a wall display. Empty lists show everything.
+type DisplayProfile struct {
+	ProfileID   string    `json:"profileId"`
+	Name        string    `json:"name"`
+	CalendarIDs []string  `json:"calendarIds"`
+	PersonIDs   []string  `json:"personIds"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+func CreateDisplayProfileSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "profileID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		Column{Name: "name",
+			Type: ColumnString},
+	)
+
+	schema := Schema{Name: "display_profiles", Columns: cols}
+	return schema
+}
+
+func CreateDisplayProfileCalendarSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "profileID",
+			Type:       ColumnUUID,
+			PrimaryKey: true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "display_profiles", ColumnName: "profileID", OnDelete: FKCascade}}},
+		Column{Name: "calendarID",
+			Type:       ColumnUUID,
+			PrimaryKey: true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "calendars", ColumnName: "calendarID", OnDelete: FKCascade}}},
+	)
+
+	schema := Schema{Name: "display_profile_calendars", Columns: cols}
+	return schema
+}
+
+func CreateDisplayProfilePersonSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "profileID",
+			Type:       ColumnUUID,
+			PrimaryKey: true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "display_profiles", ColumnName: "profileID", OnDelete: FKCascade}}},
+		Column{Name: "personID",
+			Type:       ColumnUUID,
+			PrimaryKey: true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "people", ColumnName: "personID", OnDelete: FKCascade}}},
+	)
+
+	schema := Schema{Name: "display_profile_people", Columns: cols}
+	return schema
+}
+
+func validateDisplayProfile(in DisplayProfile) error {
+	if in.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	return nil
+}
+
+// writeProfileFilters replaces the profile's calendar and person lists.
+func writeProfileFilters(ctx context.Context, q queryer, in DisplayProfile) error {
+	if _, err := q.ExecContext(ctx, `DELETE FROM display_profile_calendars WHERE profileID = $1`, in.ProfileID); err != nil {
+		return fmt.Errorf("clear profile calendars: %w", err)
+	}
+	if _, err := q.ExecContext(ctx, `DELETE FROM display_profile_people WHERE profileID = $1`, in.ProfileID); err != nil {
+		return fmt.Errorf("clear profile people: %w", err)
+	}
+
+	for _, id := range in.CalendarIDs {
+		if _, err := q.ExecContext(ctx, `
+			INSERT INTO display_profile_calendars (profileID, calendarID)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING;
+		`, in.ProfileID, id); err != nil {
+			return fmt.Errorf("insert profile calendar %s: %w", id, err)
+		}
+	}
+	for _, id := range in.PersonIDs {
+		if _, err := q.ExecContext(ctx, `
+			INSERT INTO display_profile_people (profileID, personID)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING;
+		`, in.ProfileID, id); err != nil {
+			return fmt.Errorf("insert profile person %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// loadProfileFilters fills in the calendar and person lists of each profile.
+func loadProfileFilters(ctx context.Context, q queryer, profiles []DisplayProfile) error {
+	byID := make(map[string]*DisplayProfile, len(profiles))
+	for i := range profiles {
+		profiles[i].CalendarIDs = make([]string, 0)
+		profiles[i].PersonIDs = make([]string, 0)
+		byID[profiles[i].ProfileID] = &profiles[i]
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT profileID, calendarID, NULL FROM display_profile_calendars
+		UNION ALL
+		SELECT profileID, NULL, personID FROM display_profile_people
+		ORDER BY 1, 2, 3;
+	`)
+	if err != nil {
+		return fmt.Errorf("list profile filters query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var profileID string
+		var calendarID, personID sql.NullString
+		if err := rows.Scan(&profileID, &calendarID, &personID); err != nil {
+			return fmt.Errorf("list profile filters scan: %w", err)
+		}
+		p, ok := byID[profileID]
+		if !ok {
+			continue
+		}
+		if calendarID.Valid {
+			p.CalendarIDs = append(p.CalendarIDs, calendarID.String)
+		}
+		if personID.Valid {
+			p.PersonIDs = append(p.PersonIDs, personID.String)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("list profile filters rows: %w", err)
+	}
+
+	return nil
+}
+
+func CreateDisplayProfile(ctx context.Context, db *sql.DB, in DisplayProfile) (DisplayProfile, error) {
+	if db == nil {
+		return DisplayProfile{}, fmt.Errorf("db is nil")
+	}
+
+	in.Name = strings.TrimSpace(in.Name)
+	if err := validateDisplayProfile(in); err != nil {
+		return DisplayProfile{}, err
+	}
+
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx, `
+			INSERT INTO display_profiles (name)
+			VALUES ($1)
+			RETURNING profileID;
+		`, in.Name).Scan(&in.ProfileID); err != nil {
+			return fmt.Errorf("insert display profile: %w", err)
+		}
+		return writeProfileFilters(ctx, tx, in)
+	})
+	if err != nil {
+		return DisplayProfile{}, err
+	}
+
+	out, err := GetDisplayProfile(ctx, db, in.ProfileID)
+	if err != nil {
+		return DisplayProfile{}, err
+	}
+	return *out, nil
+}
+
+func UpdateDisplayProfile(ctx context.Context, db *sql.DB, id string, in DisplayProfile) (DisplayProfile, error) {
+	if db == nil {
+		return DisplayProfile{}, fmt.Errorf("db is nil")
+	}
+
+	in.Name = strings.TrimSpace(in.Name)
+	if err := validateDisplayProfile(in); err != nil {
+		return DisplayProfile{}, err
+	}
+	in.ProfileID = id
+
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			UPDATE display_profiles SET name = $2, updatedAt = CURRENT_TIMESTAMP
+			WHERE profileID = $1;
+		`, id, in.Name)
+		if err != nil {
+			return fmt.Errorf("update display profile: %w", err)
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return sql.ErrNoRows
+		}
+		return writeProfileFilters(ctx, tx, in)
+	})
+	if err != nil {
+		return DisplayProfile{}, err
+	}
+
+	out, err := GetDisplayProfile(ctx, db, id)
+	if err != nil {
+		return DisplayProfile{}, err
+	}
+	return *out, nil
+}
+
+func GetDisplayProfile(ctx context.Context, db *sql.DB, id string) (*DisplayProfile, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	var p DisplayProfile
+	if err := db.QueryRowContext(ctx, `
+		SELECT profileID, name, createdAt, updatedAt
+		FROM display_profiles
+		WHERE profileID = $1
+	`, id).Scan(&p.ProfileID, &p.Name, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("get display profile scan: %w", err)
+	}
+
+	profiles := []DisplayProfile{p}
+	if err := loadProfileFilters(ctx, db, profiles); err != nil {
+		return nil, err
+	}
+
+	return &profiles[0], nil
+}
+
+func ListDisplayProfiles(ctx context.Context, db *sql.DB) ([]DisplayProfile, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT profileID, name, createdAt, updatedAt
+		FROM display_profiles
+		ORDER BY name, profileID;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list display profiles query: %w", err)
+	}
+	defer rows.Close()
+
+	profiles := make([]DisplayProfile, 0)
+	for rows.Next() {
+		var p DisplayProfile
+		if err := rows.Scan(&p.ProfileID, &p.Name, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("list display profiles scan: %w", err)
+		}
+		profiles = append(profiles, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list display profiles rows: %w", err)
+	}
+
+	if err := loadProfileFilters(ctx, db, profiles); err != nil {
+		return nil, err
+	}
+
+	return profiles, nil
+}
+
+func DeleteDisplayProfile(ctx context.Context, db *sql.DB, id string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM display_profiles WHERE profileID = $1`, id)
+	if err != nil {
+		return fmt.Errorf("Failed to execute display profile delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
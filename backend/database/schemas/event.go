@@ -3,18 +3,133 @@ package schemas
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
+// ErrVersionMismatch is returned by writes made against a version of an
+// event that is no longer current.
+var ErrVersionMismatch = errors.New("event has changed since it was read")
+
+// ErrTooManyEvents is returned by TrashEvents when more events match than
+// it was allowed to move.
+var ErrTooManyEvents = errors.New("too many events match")
+
 type Event struct {
-	EventID    string  `json:"eventId"`
-	PersonName string  `json:"personName"`
-	Title      string  `json:"title"`
-	Notes      *string `json:"notes,omitempty"`
-	Timezone   string  `json:"timezone"`
-	AllDay     bool    `json:"allDay"`
-	Rrule      *string `json:"rrule,omitempty"`
+	EventID  string `json:"eventId"`
+	PersonID string `json:"personId"`
+	// Display name of the person; on create, used to find them when personId is empty
+	PersonName string     `json:"personName"`
+	CalendarID *string    `json:"calendarId,omitempty"`
+	Title      string     `json:"title"`
+	Notes      *string    `json:"notes,omitempty"`
+	Timezone   string     `json:"timezone"`
+	AllDay     bool       `json:"allDay"`
+	Rrule      *string    `json:"rrule,omitempty"`
+	StartTime  time.Time  `json:"startTime"`
+	EndTime    *time.Time `json:"endTime,omitempty"`
+	// Video call or meeting link
+	URL *string `json:"url,omitempty"`
+	// Hex colour shown instead of the person's or calendar's colour
+	Color *string `json:"color,omitempty"`
+	// Free-text place, usually an address
+	Location *string `json:"location,omitempty"`
+	// Coordinates of Location, filled in by the geocoder when one is
+	// configured; ignored on create and update
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	// Set when the event was pulled from a subscribed feed; such events are read-only
+	FeedID *string `json:"feedId,omitempty"`
+	// The feed's UID for the event, used to diff refreshes
+	FeedUID *string `json:"-"`
+	// Tag names; on create and update, each must name an existing tag
+	Tags []string `json:"tags"`
+	// A chore to tick off; each occurrence can be marked done
+	Task bool `json:"task"`
+	// For recurring all-day tasks: occurrences not done by the end of their
+	// day move on to the next one until they are
+	Rollover bool `json:"rollover"`
+	// Focus time: a timed block the person keeps clear. Invitations that
+	// overlap one are declined for them
+	Focus bool `json:"focus"`
+	// Attendee counts by response; ignored on create and update
+	AttendeeSummary AttendeeSummary `json:"attendeeSummary"`
+	// When a series is paused; set under /api/events/{id}/pauses and
+	// ignored on create and update
+	Pauses Pauses `json:"pauses"`
+	// Set by the database; ignored on create and update
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// When the event was moved to the trash; only trash listings have it
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// Bumped by every edit to the event itself. On update, a non-zero
+	// Version makes the write fail with ErrVersionMismatch unless it is
+	// still current.
+	Version int `json:"version"`
+}
+
+// Columns selected whenever a full Event is read back, in the order scanEvent expects.
+var eventColumns = `eventID, personID, ` + personNameColumn("events") + ` AS personName,
+	calendarID, title, notes, timezone, allDay, rrule, startTime, endTime, url, color, location, latitude, longitude, feedID, feedUID, ` + eventTagsColumn + ` AS tags,
+	task, rollover, focus,
+	` + attendeeSummaryColumn + ` AS attendeeSummary, ` + eventPausesColumn + ` AS pauses, createdAt, updatedAt, deletedAt, version`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// textArray scans a text[] column into dst, leaving it nil for NULL. Slices
+// can be passed as query arguments as they are.
+func textArray(dst *[]string) sql.Scanner {
+	// A Map isn't safe to share between goroutines, and is cheap to make
+	return pgtype.NewMap().SQLScanner(dst)
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func scanEvent(row rowScanner, e *Event, extra ...any) error {
+	dest := []any{
+		&e.EventID,
+		&e.PersonID,
+		&e.PersonName,
+		&e.CalendarID,
+		&e.Title,
+		&e.Notes,
+		&e.Timezone,
+		&e.AllDay,
+		&e.Rrule,
+		&e.StartTime,
+		&e.EndTime,
+		&e.URL,
+		&e.Color,
+		&e.Location,
+		&e.Latitude,
+		&e.Longitude,
+		&e.FeedID,
+		&e.FeedUID,
+		textArray(&e.Tags),
+		&e.Task,
+		&e.Rollover,
+		&e.Focus,
+		&e.AttendeeSummary,
+		&e.Pauses,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+		&e.DeletedAt,
+		&e.Version,
+	}
+	return row.Scan(append(dest, extra...)...)
 }
 
 func CreateEventSchema() Schema {
@@ -24,8 +139,15 @@ func CreateEventSchema() Schema {
 			Type:           ColumnUUID,
 			PrimaryKey:     true,
 			DefaultSQLExpr: DefaultUUID()},
-		Column{Name: "personName",
-			Type: ColumnString},
+		Column{Name: "personID",
+			Type:       ColumnUUID,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "people", ColumnName: "personID", OnDelete: FKRestrict}}},
+		// Calendar the event is filed under; events without one show on every view
+		Column{Name: "calendarID",
+			Type:       ColumnUUID,
+			Nullable:   true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "calendars", ColumnName: "calendarID", OnDelete: FKRestrict}},
+			Indexed:    true},
 		Column{Name: "title",
 			Type: ColumnString},
 		Column{Name: "notes",
@@ -40,61 +162,243 @@ func CreateEventSchema() Schema {
 		Column{Name: "rrule",
 			Type:     ColumnString,
 			Nullable: true},
+		// Start of the event, or of the first occurrence for recurring events (DTSTART)
+		Column{Name: "startTime",
+			Type: ColumnTimestamp},
+		Column{Name: "endTime",
+			Type:     ColumnTimestamp,
+			Nullable: true},
+		Column{Name: "url",
+			Type:     ColumnText,
+			Nullable: true},
+		Column{Name: "color",
+			Type:     ColumnString,
+			Nullable: true},
+		Column{Name: "location",
+			Type:     ColumnText,
+			Nullable: true},
+		Column{Name: "latitude",
+			Type:     ColumnFloat,
+			Nullable: true},
+		Column{Name: "longitude",
+			Type:     ColumnFloat,
+			Nullable: true},
+		// The location the coordinates were resolved from, so edits are re-geocoded
+		Column{Name: "geocodedLocation",
+			Type:     ColumnText,
+			Nullable: true},
+		Column{Name: "feedID",
+			Type:       ColumnUUID,
+			Nullable:   true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "feeds", ColumnName: "feedID", OnDelete: FKCascade}},
+			Indexed:    true},
+		Column{Name: "feedUID",
+			Type:     ColumnText,
+			Nullable: true},
+		Column{Name: "task",
+			Type:           ColumnBool,
+			DefaultSQLExpr: DefaultFalse()},
+		Column{Name: "rollover",
+			Type:           ColumnBool,
+			DefaultSQLExpr: DefaultFalse()},
+		Column{Name: "focus",
+			Type:           ColumnBool,
+			DefaultSQLExpr: DefaultFalse()},
+		Column{Name: "createdAt",
+			Type:           ColumnTimestamp,
+			DefaultSQLExpr: DefaultNow()},
+		// Set while the event is in the trash
+		Column{Name: "deletedAt",
+			Type:     ColumnTimestamp,
+			Nullable: true},
+		Column{Name: "version",
+			Type:           ColumnInt,
+			DefaultSQLExpr: SQLDefault("1")},
+		// Full-text search document; titles rank above notes
+		Column{Name: "search",
+			Type: ColumnTSVector,
+			GeneratedSQLExpr: SQLDefault(`setweight(to_tsvector('` + searchConfig + `', coalesce(title, '')), 'A') ||
+				setweight(to_tsvector('` + searchConfig + `', coalesce(notes, '')), 'B')`)},
 	)
 
-	schema := Schema{Name: "events", Columns: cols}
+	schema := Schema{Name: "events", Columns: cols, Indexes: []Index{
+		{Name: "events_search_idx", Columns: []string{"search"}, Method: "GIN"},
+		// Range listings only ever want events out of the trash
+		{Name: "events_person_start_idx", Columns: []string{"personID", "startTime"}, Where: "deletedAt IS NULL"},
+		{Name: "events_trash_idx", Columns: []string{"deletedAt"}, Where: "deletedAt IS NOT NULL"},
+	}}
 	return schema
 }
 
 func CreateEvent(ctx context.Context, db *sql.DB, in Event) (Event, error) {
-	if db == nil {
-		return Event{}, fmt.Errorf("db is nil")
+	var out Event
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		var err error
+		out, err = CreateEventTx(ctx, tx, in)
+		return err
+	})
+	return out, err
+}
+
+// CreateEventTx is CreateEvent within tx.
+func CreateEventTx(ctx context.Context, tx *sql.Tx, in Event) (Event, error) {
+	if err := ValidateEvent(in); err != nil {
+		return Event{}, err
 	}
 
-	// Minimal validation (optional but recommended)
-	if in.PersonName == "" {
-		return Event{}, fmt.Errorf("personName is required")
+	// Feed ownership is only ever assigned by the feed refresher
+	in.FeedID, in.FeedUID = nil, nil
+	return insertEvent(ctx, tx, in)
+}
+
+// ValidateEvent checks the fields every stored event must have.
+func ValidateEvent(in Event) error {
+	if in.PersonID == "" && in.PersonName == "" {
+		return fmt.Errorf("personId is required")
 	}
 	if in.Title == "" {
-		return Event{}, fmt.Errorf("title is required")
+		return fmt.Errorf("title is required")
 	}
 	if in.Timezone == "" {
-		return Event{}, fmt.Errorf("timezone is required")
+		return fmt.Errorf("timezone is required")
+	}
+	if _, err := time.LoadLocation(in.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", in.Timezone)
+	}
+	if in.StartTime.IsZero() {
+		return fmt.Errorf("startTime is required")
+	}
+	if in.EndTime != nil && in.EndTime.Before(in.StartTime) {
+		return fmt.Errorf("endTime must not be before startTime")
 	}
+	if in.URL != nil {
+		u, err := url.Parse(*in.URL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("url must be an absolute http or https link")
+		}
+	}
+	if in.Color != nil && !isHexColor(*in.Color) {
+		return fmt.Errorf("color must be a hex colour like #3b82f6")
+	}
+	if in.Rollover && (!in.Task || !in.AllDay || in.Rrule == nil || *in.Rrule == "") {
+		return fmt.Errorf("rollover is only for recurring all-day tasks")
+	}
+	if in.Focus && (in.AllDay || in.Task || in.EndTime == nil || !in.EndTime.After(in.StartTime)) {
+		return fmt.Errorf("focus time must be a timed event with an endTime, and not a task")
+	}
+	return nil
+}
 
-	row := db.QueryRowContext(ctx, `
-		INSERT INTO events (personName, title, notes, timezone, allDay, rrule)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING eventID, personName, title, notes, timezone, allDay, rrule;
-	`, in.PersonName, in.Title, in.Notes, in.Timezone, in.AllDay, in.Rrule)
+func insertEvent(ctx context.Context, q queryer, in Event) (Event, error) {
+	if err := resolvePerson(ctx, q, &in.PersonID, in.PersonName); err != nil {
+		return Event{}, err
+	}
+	tags, err := resolveTags(ctx, q, in.Tags)
+	if err != nil {
+		return Event{}, err
+	}
+
+	row := q.QueryRowContext(ctx, `
+		INSERT INTO events (personID, calendarID, title, notes, timezone, allDay, rrule, startTime, endTime, url, color, location, feedID, feedUID, task, rollover, focus)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		RETURNING `+eventColumns+`;
+	`, in.PersonID, in.CalendarID, in.Title, in.Notes, in.Timezone, in.AllDay, in.Rrule, in.StartTime, in.EndTime, in.URL, in.Color, in.Location, in.FeedID, in.FeedUID, in.Task, in.Rollover, in.Focus)
 
 	var out Event
-	if err := row.Scan(
-		&out.EventID,
-		&out.PersonName,
-		&out.Title,
-		&out.Notes,
-		&out.Timezone,
-		&out.AllDay,
-		&out.Rrule,
-	); err != nil {
+	if err := scanEvent(row, &out); err != nil {
 		return Event{}, fmt.Errorf("insert event: %w", err)
 	}
+	if out.Tags, err = setEventTags(ctx, q, out.EventID, tags); err != nil {
+		return Event{}, err
+	}
+	if err := logEventChange(ctx, q, out.EventID, false); err != nil {
+		return Event{}, err
+	}
 
 	return out, nil
 }
 
+// UpdateEvent replaces the editable fields of an event. Feed ownership is not changed.
+func UpdateEvent(ctx context.Context, db *sql.DB, id string, in Event) (Event, error) {
+	var out Event
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		var err error
+		out, err = UpdateEventTx(ctx, tx, id, in)
+		return err
+	})
+	return out, err
+}
+
+// UpdateEventTx is UpdateEvent within tx.
+func UpdateEventTx(ctx context.Context, tx *sql.Tx, id string, in Event) (Event, error) {
+	if err := ValidateEvent(in); err != nil {
+		return Event{}, err
+	}
+
+	return updateEvent(ctx, tx, id, in)
+}
+
+func updateEvent(ctx context.Context, q queryer, id string, in Event) (Event, error) {
+	if err := resolvePerson(ctx, q, &in.PersonID, in.PersonName); err != nil {
+		return Event{}, err
+	}
+	tags, err := resolveTags(ctx, q, in.Tags)
+	if err != nil {
+		return Event{}, err
+	}
+
+	// Coordinates only stay while the location they were found for does
+	row := q.QueryRowContext(ctx, `
+		UPDATE events
+		SET personID = $2, calendarID = $3, title = $4, notes = $5, timezone = $6, allDay = $7,
+			rrule = $8, startTime = $9, endTime = $10, url = $11, color = $12, location = $13,
+			latitude = CASE WHEN location IS DISTINCT FROM $13 THEN NULL ELSE latitude END,
+			longitude = CASE WHEN location IS DISTINCT FROM $13 THEN NULL ELSE longitude END,
+			geocodedLocation = CASE WHEN location IS DISTINCT FROM $13 THEN NULL ELSE geocodedLocation END,
+			task = $15, rollover = $16, focus = $17, version = version + 1
+		WHERE eventID = $1 AND deletedAt IS NULL AND ($14 = 0 OR version = $14)
+		RETURNING `+eventColumns+`;
+	`, id, in.PersonID, in.CalendarID, in.Title, in.Notes, in.Timezone, in.AllDay, in.Rrule, in.StartTime, in.EndTime, in.URL, in.Color, in.Location, in.Version, in.Task, in.Rollover, in.Focus)
+
+	var out Event
+	if err := scanEvent(row, &out); err != nil {
+		if errors.Is(err, sql.ErrNoRows) && in.Version != 0 {
+			return Event{}, versionError(ctx, q, id)
+		}
+		return Event{}, fmt.Errorf("update event: %w", err)
+	}
+	if out.Tags, err = setEventTags(ctx, q, id, tags); err != nil {
+		return Event{}, err
+	}
+	if err := invalidateOccurrences(ctx, q, id); err != nil {
+		return Event{}, err
+	}
+	if err := logEventChange(ctx, q, id, false); err != nil {
+		return Event{}, err
+	}
+
+	return out, nil
+}
+
+// DeleteEvent moves an event to the trash, from where RestoreEvent brings
+// it back. A non-zero version makes it fail with ErrVersionMismatch unless
+// that is still the event's version.
 func DeleteEvent(
 	ctx context.Context,
 	db *sql.DB,
 	id string,
+	version int,
 ) error {
-	if db == nil {
-		return fmt.Errorf("db is nil")
-	}
+	return WithTx(ctx, db, func(tx *sql.Tx) error {
+		return DeleteEventTx(ctx, tx, id, version)
+	})
+}
 
-	result, err := db.ExecContext(ctx, `
-		DELETE FROM events WHERE eventID = $1`, id)
+// DeleteEventTx is DeleteEvent within tx.
+func DeleteEventTx(ctx context.Context, tx *sql.Tx, id string, version int) error {
+	result, err := tx.ExecContext(ctx, `
+		UPDATE events SET deletedAt = CURRENT_TIMESTAMP, version = version + 1
+		WHERE eventID = $1 AND deletedAt IS NULL AND ($2 = 0 OR version = $2)`, id, version)
 	if err != nil {
 		return fmt.Errorf("Failed to execute event delete statement: %w", err)
 	}
@@ -104,15 +408,315 @@ func DeleteEvent(
 		log.Printf("could not get rows affected: %v", err)
 	}
 	if rowsAffected == 0 {
+		if version != 0 {
+			return versionError(ctx, tx, id)
+		}
+		return sql.ErrNoRows
+	}
+
+	if err := invalidateOccurrences(ctx, tx, id); err != nil {
+		return err
+	}
+	return logEventChange(ctx, tx, id, true)
+}
+
+// PurgeEvent deletes an event for good, whether or not it is in the trash.
+// version works as for DeleteEvent.
+func PurgeEvent(ctx context.Context, db *sql.DB, id string, version int) error {
+	return WithTx(ctx, db, func(tx *sql.Tx) error {
+		return PurgeEventTx(ctx, tx, id, version)
+	})
+}
+
+// PurgeEventTx is PurgeEvent within tx.
+func PurgeEventTx(ctx context.Context, tx *sql.Tx, id string, version int) error {
+	result, err := tx.ExecContext(ctx, `
+		DELETE FROM events WHERE eventID = $1 AND ($2 = 0 OR version = $2)`, id, version)
+	if err != nil {
+		return fmt.Errorf("Failed to execute event purge statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		if version != 0 {
+			var exists bool
+			if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM events WHERE eventID = $1)`, id).Scan(&exists); err != nil {
+				return fmt.Errorf("event version query: %w", err)
+			}
+			if exists {
+				return ErrVersionMismatch
+			}
+		}
 		return sql.ErrNoRows
 	}
 
+	return logEventChange(ctx, tx, id, true)
+}
+
+// RestoreEvent takes an event back out of the trash.
+func RestoreEvent(ctx context.Context, db *sql.DB, id string) (Event, error) {
+	var out Event
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		var err error
+		out, err = RestoreEventTx(ctx, tx, id)
+		return err
+	})
+	return out, err
+}
+
+// RestoreEventTx is RestoreEvent within tx.
+func RestoreEventTx(ctx context.Context, tx *sql.Tx, id string) (Event, error) {
+	row := tx.QueryRowContext(ctx, `
+		UPDATE events SET deletedAt = NULL, version = version + 1
+		WHERE eventID = $1 AND deletedAt IS NOT NULL
+		RETURNING `+eventColumns+`;
+	`, id)
+
+	var out Event
+	if err := scanEvent(row, &out); err != nil {
+		return Event{}, fmt.Errorf("restore event: %w", err)
+	}
+	if err := invalidateOccurrences(ctx, tx, id); err != nil {
+		return Event{}, err
+	}
+	if err := logEventChange(ctx, tx, id, false); err != nil {
+		return Event{}, err
+	}
+
+	return out, nil
+}
+
+// ListTrashedEvents pages through the trash, most recently deleted first.
+func ListTrashedEvents(ctx context.Context, db *sql.DB, limit, offset int) ([]Event, int, error) {
+	if db == nil {
+		return nil, 0, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT
+			`+eventColumns+`,
+			COUNT(*) OVER() AS total_count
+		FROM events
+		WHERE deletedAt IS NOT NULL
+		ORDER BY deletedAt DESC, eventID
+		LIMIT $1 OFFSET $2;
+	`, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list trashed events query: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]Event, 0, limit)
+	total := 0
+
+	for rows.Next() {
+		var e Event
+		if err := scanEvent(rows, &e, &total); err != nil {
+			return nil, 0, fmt.Errorf("list trashed events scan: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("list trashed events rows: %w", err)
+	}
+
+	return events, total, nil
+}
+
+// EmptyTrash deletes for good the events trashed before cutoff.
+func EmptyTrash(ctx context.Context, db *sql.DB, cutoff time.Time) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM events WHERE deletedAt < $1`, cutoff); err != nil {
+		return fmt.Errorf("empty trash: %w", err)
+	}
 	return nil
 }
 
+// versionError explains why a versioned write to id matched nothing: the
+// event is gone, or it has moved on.
+func versionError(ctx context.Context, q queryer, id string) error {
+	var exists bool
+	if err := q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM events WHERE eventID = $1 AND deletedAt IS NULL)`, id).Scan(&exists); err != nil {
+		return fmt.Errorf("event version query: %w", err)
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	return ErrVersionMismatch
+}
+
+// EventFilter narrows event listings; zero fields match everything.
+type EventFilter struct {
+	CalendarID string
+	PersonID   string
+	// Applies a display profile's calendar and person lists
+	DisplayProfileID string
+	AllDay           *bool
+	// true: only recurring events; false: only one-off events
+	Recurring *bool
+	// true: only focus time; false: everything else
+	Focus *bool
+	// Bounds on createdAt and updatedAt, each exclusive
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+	// Case-insensitive substring of the title or notes
+	Search string
+	// Tag name, ignoring case
+	Tag string
+}
+
+// where returns the filter as an SQL condition, numbering its placeholders
+// after args and appending their values. Values only ever reach the query as
+// placeholders. Callers check that the IDs are UUIDs; they are compared as
+// UUIDs so the indexes on them are used.
+func (f EventFilter) where(args []any) (string, []any) {
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// Trashed events are left out of every listing
+	conds := []string{"deletedAt IS NULL"}
+	if f.CalendarID != "" {
+		conds = append(conds, "calendarID = "+arg(f.CalendarID)+"::uuid")
+	}
+	if f.PersonID != "" {
+		conds = append(conds, "personID = "+arg(f.PersonID)+"::uuid")
+	}
+	if f.DisplayProfileID != "" {
+		p := arg(f.DisplayProfileID)
+		// An empty list allows everything; events without a calendar always show
+		conds = append(conds, `(calendarID IS NULL
+			OR NOT EXISTS (SELECT 1 FROM display_profile_calendars WHERE profileID = `+p+`::uuid)
+			OR calendarID IN (SELECT calendarID FROM display_profile_calendars WHERE profileID = `+p+`::uuid))`)
+		conds = append(conds, `(NOT EXISTS (SELECT 1 FROM display_profile_people WHERE profileID = `+p+`::uuid)
+			OR personID IN (SELECT personID FROM display_profile_people WHERE profileID = `+p+`::uuid))`)
+	}
+	if f.AllDay != nil {
+		conds = append(conds, "allDay = "+arg(*f.AllDay))
+	}
+	if f.Recurring != nil {
+		if *f.Recurring {
+			conds = append(conds, "rrule IS NOT NULL")
+		} else {
+			conds = append(conds, "rrule IS NULL")
+		}
+	}
+	if f.Focus != nil {
+		conds = append(conds, "focus = "+arg(*f.Focus))
+	}
+	if f.CreatedAfter != nil {
+		conds = append(conds, "createdAt > "+arg(*f.CreatedAfter))
+	}
+	if f.CreatedBefore != nil {
+		conds = append(conds, "createdAt < "+arg(*f.CreatedBefore))
+	}
+	if f.UpdatedAfter != nil {
+		conds = append(conds, "updatedAt > "+arg(*f.UpdatedAfter))
+	}
+	if f.UpdatedBefore != nil {
+		conds = append(conds, "updatedAt < "+arg(*f.UpdatedBefore))
+	}
+	if f.Search != "" {
+		p := arg("%" + likePattern(f.Search) + "%")
+		conds = append(conds, "(title ILIKE "+p+" OR notes ILIKE "+p+")")
+	}
+	if f.Tag != "" {
+		conds = append(conds, `EXISTS (
+			SELECT 1 FROM event_tags JOIN tags ON tags.tagID = event_tags.tagID
+			WHERE event_tags.eventID = events.eventID AND lower(tags.name) = lower(`+arg(f.Tag)+`))`)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// EventSort orders ListEvents by "createdAt", "updatedAt" or "startTime",
+// with a leading "-" for newest or latest first. Empty sorts by person and
+// title.
+type EventSort string
+
+var eventSortColumns = map[string]bool{"createdAt": true, "updatedAt": true, "startTime": true}
+
+func (s EventSort) Validate() error {
+	if s != "" && !eventSortColumns[strings.TrimPrefix(string(s), "-")] {
+		return fmt.Errorf("sort must be createdAt, updatedAt or startTime, optionally with a leading -")
+	}
+	return nil
+}
+
+func (s EventSort) orderBy() string {
+	col, desc := strings.CutPrefix(string(s), "-")
+	if !eventSortColumns[col] {
+		return "personName, title, eventID"
+	}
+	if desc {
+		return col + " DESC, eventID"
+	}
+	return col + ", eventID"
+}
+
+// EventCursor marks an event's place in a listing, for keyset paging with
+// ListEventsAfter. At is the createdAt, updatedAt or startTime the listing
+// is sorted by; the default sort uses PersonName and Title instead.
+type EventCursor struct {
+	PersonName string     `json:"p,omitempty"`
+	Title      string     `json:"t,omitempty"`
+	At         *time.Time `json:"a,omitempty"`
+	EventID    string     `json:"i"`
+}
+
+// CursorFor is e's place in a listing sorted by s.
+func (s EventSort) CursorFor(e Event) EventCursor {
+	c := EventCursor{EventID: e.EventID}
+	switch strings.TrimPrefix(string(s), "-") {
+	case "createdAt":
+		c.At = &e.CreatedAt
+	case "updatedAt":
+		c.At = &e.UpdatedAt
+	case "startTime":
+		c.At = &e.StartTime
+	default:
+		c.PersonName, c.Title = e.PersonName, e.Title
+	}
+	return c
+}
+
+// after is the SQL condition for the events after c in orderBy's order,
+// numbering its placeholders after args as EventFilter.where does.
+func (s EventSort) after(c EventCursor, args []any) (string, []any, error) {
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	col, desc := strings.CutPrefix(string(s), "-")
+	if !eventSortColumns[col] {
+		return "(" + personNameColumn("events") + ", title, eventID) > (" + arg(c.PersonName) + ", " + arg(c.Title) + ", " + arg(c.EventID) + ")", args, nil
+	}
+	if c.At == nil {
+		return "", nil, fmt.Errorf("cursor is for another sort")
+	}
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	at, id := arg(*c.At), arg(c.EventID)
+	return "(" + col + " " + op + " " + at + " OR (" + col + " = " + at + " AND eventID > " + id + "))", args, nil
+}
+
 func ListEvents(
 	ctx context.Context,
 	db *sql.DB,
+	filter EventFilter,
+	sort EventSort,
 	limit, offset int,
 ) ([]Event, int, error) {
 
@@ -120,20 +724,16 @@ func ListEvents(
 		return nil, 0, fmt.Errorf("db is nil")
 	}
 
+	cond, args := filter.where([]any{limit, offset})
 	rows, err := db.QueryContext(ctx, `
 		SELECT
-			eventID,
-			personName,
-			title,
-			notes,
-			timezone,
-			allDay,
-			rrule,
+			`+eventColumns+`,
 			COUNT(*) OVER() AS total_count
 		FROM events
-		ORDER BY personName, title, eventID
+		WHERE `+cond+`
+		ORDER BY `+sort.orderBy()+`
 		LIMIT $1 OFFSET $2;
-	`, limit, offset)
+	`, args...)
 	if err != nil {
 		return nil, 0, fmt.Errorf("list events query: %w", err)
 	}
@@ -144,16 +744,7 @@ func ListEvents(
 
 	for rows.Next() {
 		var e Event
-		if err := rows.Scan(
-			&e.EventID,
-			&e.PersonName,
-			&e.Title,
-			&e.Notes,
-			&e.Timezone,
-			&e.AllDay,
-			&e.Rrule,
-			&total, // same value for every row
-		); err != nil {
+		if err := scanEvent(rows, &e, &total); err != nil { // total is the same value for every row
 			return nil, 0, fmt.Errorf("list events scan: %w", err)
 		}
 		events = append(events, e)
@@ -166,6 +757,65 @@ func ListEvents(
 	return events, total, nil
 }
 
+// ListEventsAfter pages through ListEvents by keyset instead of offset,
+// which stays quick however deep into the listing a page is. The page starts
+// just after the event at after, or at the beginning when after is nil.
+// The total still counts every event that matches filter.
+func ListEventsAfter(
+	ctx context.Context,
+	db *sql.DB,
+	filter EventFilter,
+	sort EventSort,
+	after *EventCursor,
+	limit int,
+) ([]Event, int, error) {
+	if db == nil {
+		return nil, 0, fmt.Errorf("db is nil")
+	}
+
+	// Counted apart, since the page itself may be empty
+	countCond, countArgs := filter.where(nil)
+	var total int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM events WHERE `+countCond, countArgs...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count events: %w", err)
+	}
+
+	cond, args := filter.where([]any{limit})
+	if after != nil {
+		keyCond, keyArgs, err := sort.after(*after, args)
+		if err != nil {
+			return nil, 0, err
+		}
+		cond, args = cond+" AND "+keyCond, keyArgs
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+eventColumns+`
+		FROM events
+		WHERE `+cond+`
+		ORDER BY `+sort.orderBy()+`
+		LIMIT $1;
+	`, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list events after query: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]Event, 0, limit)
+	for rows.Next() {
+		var e Event
+		if err := scanEvent(rows, &e); err != nil {
+			return nil, 0, fmt.Errorf("list events after scan: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("list events after rows: %w", err)
+	}
+
+	return events, total, nil
+}
+
 func GetEvent(
 	ctx context.Context,
 	db *sql.DB,
@@ -176,9 +826,9 @@ func GetEvent(
 	}
 
 	row := db.QueryRowContext(ctx, `
-		SELECT eventID, personName, title, notes, timezone, allDay, rrule
+		SELECT `+eventColumns+`
 		FROM events
-		WHERE eventID = $1
+		WHERE eventID = $1 AND deletedAt IS NULL
 	`, id)
 
 	if row.Err() != nil {
@@ -186,17 +836,406 @@ func GetEvent(
 	}
 
 	var e Event
-	if err := row.Scan(
-		&e.EventID,
-		&e.PersonName,
-		&e.Title,
-		&e.Notes,
-		&e.Timezone,
-		&e.AllDay,
-		&e.Rrule,
-	); err != nil {
+	if err := scanEvent(row, &e); err != nil {
 		return nil, fmt.Errorf("list events scan: %w", err)
 	}
 
 	return &e, nil
 }
+
+// GetTrashedEvent is GetEvent for an event in the trash.
+func GetTrashedEvent(ctx context.Context, db *sql.DB, id string) (*Event, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		SELECT `+eventColumns+`
+		FROM events
+		WHERE eventID = $1 AND deletedAt IS NOT NULL
+	`, id)
+
+	var e Event
+	if err := scanEvent(row, &e); err != nil {
+		return nil, fmt.Errorf("get trashed event scan: %w", err)
+	}
+
+	return &e, nil
+}
+
+// GetEvents returns the events with the given IDs in one query, in the order
+// asked for. IDs that match nothing are left out; every ID must be a UUID.
+func GetEvents(ctx context.Context, db *sql.DB, ids []string) ([]Event, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+eventColumns+`
+		FROM events
+		WHERE eventID = ANY($1::uuid[]) AND deletedAt IS NULL
+	`, ids)
+	if err != nil {
+		return nil, fmt.Errorf("get events query: %w", err)
+	}
+	defer rows.Close()
+
+	byID := make(map[string]Event, len(ids))
+	for rows.Next() {
+		var e Event
+		if err := scanEvent(rows, &e); err != nil {
+			return nil, fmt.Errorf("get events scan: %w", err)
+		}
+		byID[e.EventID] = e
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get events rows: %w", err)
+	}
+
+	out := make([]Event, 0, len(byID))
+	for _, id := range ids {
+		if e, ok := byID[id]; ok {
+			out = append(out, e)
+			// Asked for twice, listed once
+			delete(byID, id)
+		}
+	}
+	return out, nil
+}
+
+// ListAllEvents returns every event matching filter without pagination, for exports and feeds.
+func ListAllEvents(ctx context.Context, db *sql.DB, filter EventFilter) ([]Event, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	cond, args := filter.where(nil)
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+eventColumns+`
+		FROM events
+		WHERE `+cond+`
+		ORDER BY startTime, eventID;
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list all events query: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]Event, 0)
+	for rows.Next() {
+		var e Event
+		if err := scanEvent(rows, &e); err != nil {
+			return nil, fmt.Errorf("list all events scan: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list all events rows: %w", err)
+	}
+
+	return events, nil
+}
+
+// ListJoinableEvents returns the events with a URL that may have an
+// occurrence starting within window of now and not yet ended: one-off events
+// that do, treating those without an end as lasting an hour, and series that
+// have started by then, which the caller has to expand.
+func ListJoinableEvents(ctx context.Context, db *sql.DB, now time.Time, window time.Duration) ([]Event, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+eventColumns+`
+		FROM events
+		WHERE url IS NOT NULL
+			AND deletedAt IS NULL
+			AND startTime <= $1
+			AND (rrule IS NOT NULL OR COALESCE(endTime, startTime + interval '1 hour') > $2)
+		ORDER BY startTime, eventID;
+	`, now.Add(window), now)
+	if err != nil {
+		return nil, fmt.Errorf("list joinable events query: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]Event, 0)
+	for rows.Next() {
+		var e Event
+		if err := scanEvent(rows, &e); err != nil {
+			return nil, fmt.Errorf("list joinable events scan: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list joinable events rows: %w", err)
+	}
+
+	return events, nil
+}
+
+// ListEventsInRange returns the events matching filter that may have an
+// occurrence in [from, to): one-off events that overlap it and recurring
+// events that start before to, which the caller has to expand.
+func ListEventsInRange(ctx context.Context, db *sql.DB, filter EventFilter, from, to time.Time) ([]Event, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	cond, args := filter.where([]any{from, to})
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+eventColumns+`
+		FROM events
+		WHERE startTime < $2
+			AND (rrule IS NOT NULL OR COALESCE(endTime, startTime) >= $1)
+			AND `+cond+`
+		ORDER BY startTime, eventID;
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list events in range query: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]Event, 0)
+	for rows.Next() {
+		var e Event
+		if err := scanEvent(rows, &e); err != nil {
+			return nil, fmt.Errorf("list events in range scan: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list events in range rows: %w", err)
+	}
+
+	return events, nil
+}
+
+// ListRecurringEventsBefore returns the editable recurring events whose
+// series started before cutoff, oldest first.
+func ListRecurringEventsBefore(ctx context.Context, db *sql.DB, cutoff time.Time) ([]Event, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+eventColumns+`
+		FROM events
+		WHERE rrule IS NOT NULL AND feedID IS NULL AND deletedAt IS NULL AND startTime < $1
+		ORDER BY startTime, eventID;
+	`, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("list recurring events query: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]Event, 0)
+	for rows.Next() {
+		var e Event
+		if err := scanEvent(rows, &e); err != nil {
+			return nil, fmt.Errorf("list recurring events scan: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list recurring events rows: %w", err)
+	}
+
+	return events, nil
+}
+
+// SetEventRrules rewrites the rrule of each event in rules (by event ID) in
+// one transaction, returning the updated events. A dry run returns them as
+// they would be and rolls back.
+func SetEventRrules(ctx context.Context, db *sql.DB, rules map[string]string, dryRun bool) ([]Event, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	out := make([]Event, 0, len(rules))
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		for id, rule := range rules {
+			row := tx.QueryRowContext(ctx, `
+				UPDATE events SET rrule = $2, version = version + 1
+				WHERE eventID = $1
+				RETURNING `+eventColumns+`;
+			`, id, rule)
+
+			var e Event
+			if err := scanEvent(row, &e); err != nil {
+				return fmt.Errorf("set rrule %s: %w", id, err)
+			}
+			if err := invalidateOccurrences(ctx, tx, id); err != nil {
+				return err
+			}
+			if err := logEventChange(ctx, tx, id, false); err != nil {
+				return err
+			}
+			out = append(out, e)
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// TrashEvents moves the events matching filter to the trash in one
+// transaction, returning them as they were. When ids isn't empty, only those
+// of them that match are moved. Feed events are left alone, since the next
+// refresh would bring them back. If more than limit match, nothing is moved
+// and the error is ErrTooManyEvents; a dry run returns the matches without
+// moving them.
+func TrashEvents(ctx context.Context, db *sql.DB, filter EventFilter, ids []string, limit int, dryRun bool) ([]Event, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	out := make([]Event, 0)
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		cond, args := filter.where([]any{limit + 1})
+		if len(ids) > 0 {
+			args = append(args, ids)
+			cond += fmt.Sprintf(" AND eventID = ANY($%d::uuid[])", len(args))
+		}
+		rows, err := tx.QueryContext(ctx, `
+			SELECT `+eventColumns+`
+			FROM events
+			WHERE `+cond+` AND feedID IS NULL
+			ORDER BY eventID
+			LIMIT $1
+			FOR UPDATE;
+		`, args...)
+		if err != nil {
+			return fmt.Errorf("trash events query: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var e Event
+			if err := scanEvent(rows, &e); err != nil {
+				return fmt.Errorf("trash events scan: %w", err)
+			}
+			out = append(out, e)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("trash events rows: %w", err)
+		}
+		rows.Close()
+
+		if len(out) > limit {
+			return ErrTooManyEvents
+		}
+		// Nothing has been written for a dry run to roll back
+		if dryRun || len(out) == 0 {
+			return nil
+		}
+
+		moved := make([]string, 0, len(out))
+		for _, e := range out {
+			moved = append(moved, e.EventID)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE events SET deletedAt = CURRENT_TIMESTAMP, version = version + 1
+			WHERE eventID = ANY($1::uuid[])`, moved); err != nil {
+			return fmt.Errorf("trash events: %w", err)
+		}
+		for _, id := range moved {
+			if err := invalidateOccurrences(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+		return logEventChanges(ctx, tx, true, `eventID = ANY($1::uuid[])`, moved)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// SplitSeries ends the series id with origRrule and stores next as a new
+// event in one transaction. Exceptions from split onwards move to the new
+// event when moveExceptions is set and are dropped otherwise. With them,
+// the new event also gets the part of any pause reaching past split.
+// version works as for DeleteEvent.
+func SplitSeries(ctx context.Context, db *sql.DB, id string, version int, origRrule string, next Event, split time.Time, moveExceptions bool) (Event, Event, error) {
+	if db == nil {
+		return Event{}, Event{}, fmt.Errorf("db is nil")
+	}
+	if err := ValidateEvent(next); err != nil {
+		return Event{}, Event{}, err
+	}
+
+	var orig, created Event
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, `
+			UPDATE events SET rrule = $2, version = version + 1
+			WHERE eventID = $1 AND ($3 = 0 OR version = $3)
+			RETURNING `+eventColumns+`;
+		`, id, origRrule, version)
+		if err := scanEvent(row, &orig); err != nil {
+			if errors.Is(err, sql.ErrNoRows) && version != 0 {
+				return versionError(ctx, tx, id)
+			}
+			return fmt.Errorf("truncate series: %w", err)
+		}
+		if err := invalidateOccurrences(ctx, tx, id); err != nil {
+			return err
+		}
+		if err := logEventChange(ctx, tx, id, false); err != nil {
+			return err
+		}
+
+		next.FeedID, next.FeedUID = nil, nil
+		var err error
+		if created, err = insertEvent(ctx, tx, next); err != nil {
+			return err
+		}
+
+		if moveExceptions {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE exceptions SET eventID = $3
+				WHERE eventID = $1 AND recurrenceID >= $2;
+			`, id, split, created.EventID)
+		} else {
+			_, err = tx.ExecContext(ctx, `
+				DELETE FROM exceptions
+				WHERE eventID = $1 AND recurrenceID >= $2;
+			`, id, split)
+		}
+		if err != nil {
+			return fmt.Errorf("split exceptions: %w", err)
+		}
+		// Pauses reaching past the split carry on into the new series too
+		if moveExceptions {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO pauses (eventID, fromTime, untilTime)
+				SELECT $3, GREATEST(fromTime, $2), untilTime FROM pauses
+				WHERE eventID = $1 AND untilTime > $2;
+			`, id, split, created.EventID); err != nil {
+				return fmt.Errorf("split pauses: %w", err)
+			}
+			if created.Pauses, err = eventPauses(ctx, tx, created.EventID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Event{}, Event{}, err
+	}
+
+	return orig, created, nil
+}
@@ -1,6 +1,11 @@
 package schemas
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
 	"time"
 )
 
@@ -13,10 +18,13 @@ const (
 
 type Exception struct {
 	EventID      string        `json:"eventId"`
-	RecurrenceID string        `json:"recurrenceId"`
+	RecurrenceID time.Time     `json:"recurrenceId"`
 	Kind         ExceptionType `json:"kind"`
 	NewStart     *time.Time    `json:"newStart,omitempty"`
 	NewEnd       *time.Time    `json:"newEnd,omitempty"`
+	// Set by the database; ignored when writing
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
 }
 
 func CreateExceptionSchema() Schema {
@@ -39,6 +47,209 @@ func CreateExceptionSchema() Schema {
 			Nullable: true},
 	)
 
-	schema := Schema{Name: "exceptions", Columns: cols}
+	schema := Schema{Name: "exceptions", Columns: cols, Indexes: []Index{
+		// Moved occurrences by where they went
+		{Name: "exceptions_event_new_start_idx", Columns: []string{"eventID", "newStart"}, Where: "newStart IS NOT NULL"},
+	}}
 	return schema
 }
+
+const exceptionColumns = `eventID, recurrenceID, kind, newStart, newEnd, createdAt, updatedAt`
+
+func scanException(row rowScanner, ex *Exception) error {
+	return row.Scan(
+		&ex.EventID,
+		&ex.RecurrenceID,
+		&ex.Kind,
+		&ex.NewStart,
+		&ex.NewEnd,
+		&ex.CreatedAt,
+		&ex.UpdatedAt,
+	)
+}
+
+func insertException(ctx context.Context, q queryer, in Exception) error {
+	if _, err := q.ExecContext(ctx, `
+		INSERT INTO exceptions (eventID, recurrenceID, kind, newStart, newEnd)
+		VALUES ($1, $2, $3, $4, $5);
+	`, in.EventID, in.RecurrenceID, in.Kind, in.NewStart, in.NewEnd); err != nil {
+		return fmt.Errorf("insert exception: %w", err)
+	}
+	if err := invalidateOccurrences(ctx, q, in.EventID); err != nil {
+		return err
+	}
+	return logEventChange(ctx, q, in.EventID, false)
+}
+
+// UpsertException stores ex, replacing any exception for the same occurrence.
+func UpsertException(ctx context.Context, db *sql.DB, ex Exception) error {
+	return WithTx(ctx, db, func(tx *sql.Tx) error {
+		return UpsertExceptionTx(ctx, tx, ex)
+	})
+}
+
+// UpsertExceptionTx is UpsertException within tx.
+func UpsertExceptionTx(ctx context.Context, tx *sql.Tx, ex Exception) error {
+	return upsertException(ctx, tx, ex)
+}
+
+// UpsertExceptions stores every exception in exs in a single transaction.
+// A dry run checks they can be written and then rolls back.
+func UpsertExceptions(ctx context.Context, db *sql.DB, exs []Exception, dryRun bool) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		for _, ex := range exs {
+			if err := upsertException(ctx, tx, ex); err != nil {
+				return err
+			}
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		return nil
+	}
+	return err
+}
+
+func upsertException(ctx context.Context, q queryer, ex Exception) error {
+	if _, err := q.ExecContext(ctx, `
+		INSERT INTO exceptions (eventID, recurrenceID, kind, newStart, newEnd)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (eventID, recurrenceID) DO UPDATE
+		SET kind = EXCLUDED.kind, newStart = EXCLUDED.newStart, newEnd = EXCLUDED.newEnd;
+	`, ex.EventID, ex.RecurrenceID, ex.Kind, ex.NewStart, ex.NewEnd); err != nil {
+		return fmt.Errorf("upsert exception: %w", err)
+	}
+	if err := invalidateOccurrences(ctx, q, ex.EventID); err != nil {
+		return err
+	}
+	return logEventChange(ctx, q, ex.EventID, false)
+}
+
+// ReplaceEventExceptions swaps all of an event's exceptions for exs in one transaction.
+func ReplaceEventExceptions(ctx context.Context, db *sql.DB, eventID string, exs []Exception) error {
+	return WithTx(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM exceptions WHERE eventID = $1`, eventID); err != nil {
+			return fmt.Errorf("clear exceptions: %w", err)
+		}
+		if err := invalidateOccurrences(ctx, tx, eventID); err != nil {
+			return err
+		}
+		if err := logEventChange(ctx, tx, eventID, false); err != nil {
+			return err
+		}
+		for _, ex := range exs {
+			ex.EventID = eventID
+			if err := insertException(ctx, tx, ex); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteException removes the exception for one occurrence, returning
+// sql.ErrNoRows when there was none.
+func DeleteException(ctx context.Context, db *sql.DB, eventID string, recurrenceID time.Time) error {
+	return WithTx(ctx, db, func(tx *sql.Tx) error {
+		return DeleteExceptionTx(ctx, tx, eventID, recurrenceID)
+	})
+}
+
+// DeleteExceptionTx is DeleteException within tx.
+func DeleteExceptionTx(ctx context.Context, tx *sql.Tx, eventID string, recurrenceID time.Time) error {
+	result, err := tx.ExecContext(ctx, `
+		DELETE FROM exceptions WHERE eventID = $1 AND recurrenceID = $2`, eventID, recurrenceID)
+	if err != nil {
+		return fmt.Errorf("Failed to execute exception delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := invalidateOccurrences(ctx, tx, eventID); err != nil {
+		return err
+	}
+	return logEventChange(ctx, tx, eventID, false)
+}
+
+// ListEventExceptions returns the exceptions for a single event, ordered by recurrence.
+func ListEventExceptions(ctx context.Context, db *sql.DB, eventID string) ([]Exception, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	return eventExceptions(ctx, db, eventID)
+}
+
+func eventExceptions(ctx context.Context, q queryer, eventID string) ([]Exception, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+exceptionColumns+`
+		FROM exceptions
+		WHERE eventID = $1
+		ORDER BY recurrenceID;
+	`, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("list exceptions query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]Exception, 0)
+	for rows.Next() {
+		var ex Exception
+		if err := scanException(rows, &ex); err != nil {
+			return nil, fmt.Errorf("list exceptions scan: %w", err)
+		}
+		out = append(out, ex)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list exceptions rows: %w", err)
+	}
+
+	return out, nil
+}
+
+// ListAllExceptions returns every exception keyed by the owning eventID.
+func ListAllExceptions(ctx context.Context, db *sql.DB) (map[string][]Exception, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+exceptionColumns+`
+		FROM exceptions
+		ORDER BY eventID, recurrenceID;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list all exceptions query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[string][]Exception)
+	for rows.Next() {
+		var ex Exception
+		if err := scanException(rows, &ex); err != nil {
+			return nil, fmt.Errorf("list all exceptions scan: %w", err)
+		}
+		out[ex.EventID] = append(out[ex.EventID], ex)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list all exceptions rows: %w", err)
+	}
+
+	return out, nil
+}
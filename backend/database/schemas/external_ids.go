@@ -0,0 +1,93 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// CreateExternalIDSchema maps an identifier another system uses for an
+// event, e.g. a script's own record ID, to the event it created.
+func CreateExternalIDSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		// Who owns the uid, e.g. "homeassistant"; uids only need to be unique per source
+		Column{Name: "source",
+			Type:       ColumnText,
+			PrimaryKey: true},
+		Column{Name: "uid",
+			Type:       ColumnText,
+			PrimaryKey: true},
+		Column{Name: "eventID",
+			Type:       ColumnUUID,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "events", ColumnName: "eventID", OnDelete: FKCascade}}},
+	)
+
+	schema := Schema{Name: "event_external_ids", Columns: cols}
+	return schema
+}
+
+// UpsertExternalEvent creates the event known to source as uid, or replaces
+// its editable fields if it already exists. created reports which happened.
+// Concurrent calls for the same key are serialised, so retries never create
+// a second event.
+func UpsertExternalEvent(ctx context.Context, db *sql.DB, source, uid string, in Event) (out Event, created bool, err error) {
+	if db == nil {
+		return Event{}, false, fmt.Errorf("db is nil")
+	}
+
+	if source == "" || uid == "" {
+		return Event{}, false, fmt.Errorf("source and uid are required")
+	}
+	if err := ValidateEvent(in); err != nil {
+		return Event{}, false, err
+	}
+
+	err = WithTx(ctx, db, func(tx *sql.Tx) error {
+		// Held until commit; a plain row lock can't cover a key that doesn't exist yet
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1 || '/' || $2))`, source, uid); err != nil {
+			return fmt.Errorf("lock external id: %w", err)
+		}
+
+		var eventID string
+		err := tx.QueryRowContext(ctx, `
+			SELECT eventID FROM event_external_ids
+			WHERE source = $1 AND uid = $2;
+		`, source, uid).Scan(&eventID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// There is no version of it yet to be current
+			if in.Version != 0 {
+				return ErrVersionMismatch
+			}
+			in.FeedID, in.FeedUID = nil, nil
+			if out, err = insertEvent(ctx, tx, in); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO event_external_ids (source, uid, eventID)
+				VALUES ($1, $2, $3);
+			`, source, uid, out.EventID); err != nil {
+				return fmt.Errorf("insert external id: %w", err)
+			}
+			created = true
+		case err != nil:
+			return fmt.Errorf("external id query: %w", err)
+		default:
+			// Pushing an event again fishes it out of the trash
+			if _, err := tx.ExecContext(ctx, `UPDATE events SET deletedAt = NULL WHERE eventID = $1 AND deletedAt IS NOT NULL`, eventID); err != nil {
+				return fmt.Errorf("restore external event: %w", err)
+			}
+			if out, err = updateEvent(ctx, tx, eventID, in); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Event{}, false, err
+	}
+
+	return out, created, nil
+}
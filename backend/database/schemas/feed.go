@@ -0,0 +1,362 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+type Feed struct {
+	FeedID   string `json:"feedId"`
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	PersonID string `json:"personId"`
+	// Display name of the person; on create, used to find them when personId is empty
+	PersonName string `json:"personName"`
+	// Calendar the feed's events are filed under
+	CalendarID      *string    `json:"calendarId,omitempty"`
+	LastRefreshedAt *time.Time `json:"lastRefreshedAt,omitempty"`
+	LastError       *string    `json:"lastError,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+// FeedItem is one VEVENT series read from a feed, keyed by its UID.
+type FeedItem struct {
+	UID        string
+	Event      Event
+	Exceptions []Exception
+}
+
+type FeedSyncResult struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	Removed int `json:"removed"`
+	Skipped int `json:"skipped"`
+}
+
+var feedColumns = `feedID, name, url, personID, ` + personNameColumn("feeds") + ` AS personName, calendarID, lastRefreshedAt, lastError, createdAt, updatedAt`
+
+func scanFeed(row rowScanner, f *Feed) error {
+	return row.Scan(
+		&f.FeedID,
+		&f.Name,
+		&f.URL,
+		&f.PersonID,
+		&f.PersonName,
+		&f.CalendarID,
+		&f.LastRefreshedAt,
+		&f.LastError,
+		&f.CreatedAt,
+		&f.UpdatedAt,
+	)
+}
+
+func CreateFeedSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "feedID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		Column{Name: "name",
+			Type: ColumnString},
+		Column{Name: "url",
+			Type: ColumnText},
+		// Person that the feed's events are shown for
+		Column{Name: "personID",
+			Type:       ColumnUUID,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "people", ColumnName: "personID", OnDelete: FKRestrict}}},
+		Column{Name: "calendarID",
+			Type:       ColumnUUID,
+			Nullable:   true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "calendars", ColumnName: "calendarID", OnDelete: FKRestrict}}},
+		Column{Name: "lastRefreshedAt",
+			Type:     ColumnTimestamp,
+			Nullable: true},
+		Column{Name: "lastError",
+			Type:     ColumnText,
+			Nullable: true},
+	)
+
+	schema := Schema{Name: "feeds", Columns: cols}
+	return schema
+}
+
+func validateFeed(in Feed) error {
+	if in.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if in.PersonID == "" && in.PersonName == "" {
+		return fmt.Errorf("personId is required")
+	}
+	u, err := url.Parse(in.URL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("url is invalid")
+	}
+	switch u.Scheme {
+	case "http", "https", "webcal":
+	default:
+		return fmt.Errorf("url must be http, https or webcal")
+	}
+	return nil
+}
+
+func CreateFeed(ctx context.Context, db *sql.DB, in Feed) (Feed, error) {
+	if db == nil {
+		return Feed{}, fmt.Errorf("db is nil")
+	}
+	if err := validateFeed(in); err != nil {
+		return Feed{}, err
+	}
+	if err := resolvePerson(ctx, db, &in.PersonID, in.PersonName); err != nil {
+		return Feed{}, err
+	}
+
+	row := db.QueryRowContext(ctx, `
+		INSERT INTO feeds (name, url, personID, calendarID)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+feedColumns+`;
+	`, in.Name, in.URL, in.PersonID, in.CalendarID)
+
+	var out Feed
+	if err := scanFeed(row, &out); err != nil {
+		return Feed{}, fmt.Errorf("insert feed: %w", err)
+	}
+
+	return out, nil
+}
+
+func UpdateFeed(ctx context.Context, db *sql.DB, id string, in Feed) (Feed, error) {
+	if db == nil {
+		return Feed{}, fmt.Errorf("db is nil")
+	}
+	if err := validateFeed(in); err != nil {
+		return Feed{}, err
+	}
+	if err := resolvePerson(ctx, db, &in.PersonID, in.PersonName); err != nil {
+		return Feed{}, err
+	}
+
+	row := db.QueryRowContext(ctx, `
+		UPDATE feeds SET name = $2, url = $3, personID = $4, calendarID = $5
+		WHERE feedID = $1
+		RETURNING `+feedColumns+`;
+	`, id, in.Name, in.URL, in.PersonID, in.CalendarID)
+
+	var out Feed
+	if err := scanFeed(row, &out); err != nil {
+		return Feed{}, fmt.Errorf("update feed: %w", err)
+	}
+
+	return out, nil
+}
+
+func GetFeed(ctx context.Context, db *sql.DB, id string) (*Feed, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		SELECT `+feedColumns+`
+		FROM feeds
+		WHERE feedID = $1
+	`, id)
+
+	var f Feed
+	if err := scanFeed(row, &f); err != nil {
+		return nil, fmt.Errorf("get feed scan: %w", err)
+	}
+
+	return &f, nil
+}
+
+func ListFeeds(ctx context.Context, db *sql.DB) ([]Feed, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+feedColumns+`
+		FROM feeds
+		ORDER BY name, feedID;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list feeds query: %w", err)
+	}
+	defer rows.Close()
+
+	feeds := make([]Feed, 0)
+	for rows.Next() {
+		var f Feed
+		if err := scanFeed(rows, &f); err != nil {
+			return nil, fmt.Errorf("list feeds scan: %w", err)
+		}
+		feeds = append(feeds, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list feeds rows: %w", err)
+	}
+
+	return feeds, nil
+}
+
+// DeleteFeed removes the subscription; its events go with it via the FK cascade.
+func DeleteFeed(ctx context.Context, db *sql.DB, id string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	return WithTx(ctx, db, func(tx *sql.Tx) error {
+		// The feed's events go with it
+		if err := logEventChanges(ctx, tx, true, `feedID = $1`, id); err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, `
+			DELETE FROM feeds WHERE feedID = $1`, id)
+		if err != nil {
+			return fmt.Errorf("Failed to execute feed delete statement: %w", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			log.Printf("could not get rows affected: %v", err)
+		}
+		if rowsAffected == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// RecordFeedRefresh stores the outcome of a refresh attempt.
+func RecordFeedRefresh(ctx context.Context, db *sql.DB, id string, at time.Time, refreshErr error) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	var lastError *string
+	if refreshErr != nil {
+		msg := refreshErr.Error()
+		lastError = &msg
+	}
+
+	if _, err := db.ExecContext(ctx, `
+		UPDATE feeds SET lastRefreshedAt = $2, lastError = $3
+		WHERE feedID = $1;
+	`, id, at, lastError); err != nil {
+		return fmt.Errorf("record feed refresh: %w", err)
+	}
+
+	return nil
+}
+
+// SyncFeedEvents makes the feed's stored events match items: series with a
+// known UID are rewritten in place, new UIDs are inserted and UIDs that
+// disappeared from the feed are deleted. It all happens in one transaction.
+func SyncFeedEvents(ctx context.Context, db *sql.DB, feedID string, items []FeedItem) (FeedSyncResult, error) {
+	var res FeedSyncResult
+	if db == nil {
+		return res, fmt.Errorf("db is nil")
+	}
+
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		existing, err := feedEventIDs(ctx, tx, feedID)
+		if err != nil {
+			return err
+		}
+
+		seen := make(map[string]bool, len(items))
+		for _, it := range items {
+			if it.UID == "" || seen[it.UID] {
+				res.Skipped++
+				continue
+			}
+			if err := ValidateEvent(it.Event); err != nil {
+				res.Skipped++
+				continue
+			}
+			seen[it.UID] = true
+
+			uid := it.UID
+			it.Event.FeedID, it.Event.FeedUID = &feedID, &uid
+
+			var stored Event
+			if id, ok := existing[uid]; ok {
+				stored, err = updateEvent(ctx, tx, id, it.Event)
+				if err != nil {
+					return err
+				}
+				if _, err := tx.ExecContext(ctx, `DELETE FROM exceptions WHERE eventID = $1`, id); err != nil {
+					return fmt.Errorf("clear feed exceptions: %w", err)
+				}
+				res.Updated++
+			} else {
+				stored, err = insertEvent(ctx, tx, it.Event)
+				if err != nil {
+					return err
+				}
+				res.Added++
+			}
+
+			for _, ex := range it.Exceptions {
+				ex.EventID = stored.EventID
+				if err := insertException(ctx, tx, ex); err != nil {
+					return err
+				}
+			}
+		}
+
+		for uid, id := range existing {
+			if seen[uid] {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM events WHERE eventID = $1`, id); err != nil {
+				return fmt.Errorf("remove feed event: %w", err)
+			}
+			if err := logEventChange(ctx, tx, id, true); err != nil {
+				return err
+			}
+			res.Removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+func feedEventIDs(ctx context.Context, q queryer, feedID string) (map[string]string, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT feedUID, eventID FROM events WHERE feedID = $1;
+	`, feedID)
+	if err != nil {
+		return nil, fmt.Errorf("list feed events query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]string)
+	for rows.Next() {
+		var uid sql.NullString
+		var id string
+		if err := rows.Scan(&uid, &id); err != nil {
+			return nil, fmt.Errorf("list feed events scan: %w", err)
+		}
+		if uid.Valid {
+			out[uid.String] = id
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list feed events rows: %w", err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,190 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// FeedToken grants read access to a published ICS feed. The secret itself is
+// only ever stored hashed.
+type FeedToken struct {
+	TokenID string `json:"tokenId"`
+	Name    string `json:"name"`
+	// Scope: only this person's and/or this calendar's events; nil means all
+	PersonID   *string    `json:"personId,omitempty"`
+	CalendarID *string    `json:"calendarId,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// Filter returns the event filter for the token's scope.
+func (t FeedToken) Filter() EventFilter {
+	var f EventFilter
+	if t.PersonID != nil {
+		f.PersonID = *t.PersonID
+	}
+	if t.CalendarID != nil {
+		f.CalendarID = *t.CalendarID
+	}
+	return f
+}
+
+const feedTokenColumns = `tokenID, name, personID, calendarID, expiresAt, createdAt, updatedAt, lastUsedAt`
+
+func scanFeedToken(row rowScanner, t *FeedToken) error {
+	return row.Scan(
+		&t.TokenID,
+		&t.Name,
+		&t.PersonID,
+		&t.CalendarID,
+		&t.ExpiresAt,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.LastUsedAt,
+	)
+}
+
+func CreateFeedTokenSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "tokenID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		// SHA-256 of the secret, hex encoded
+		Column{Name: "tokenHash",
+			Type:   ColumnString,
+			Unique: true},
+		Column{Name: "name",
+			Type: ColumnString},
+		Column{Name: "personID",
+			Type:       ColumnUUID,
+			Nullable:   true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "people", ColumnName: "personID", OnDelete: FKCascade}}},
+		Column{Name: "calendarID",
+			Type:       ColumnUUID,
+			Nullable:   true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "calendars", ColumnName: "calendarID", OnDelete: FKCascade}}},
+		Column{Name: "expiresAt",
+			Type:     ColumnTimestamp,
+			Nullable: true},
+		Column{Name: "createdAt",
+			Type:           ColumnTimestamp,
+			DefaultSQLExpr: DefaultNow()},
+		Column{Name: "lastUsedAt",
+			Type:     ColumnTimestamp,
+			Nullable: true},
+	)
+
+	schema := Schema{Name: "feed_tokens", Columns: cols}
+	return schema
+}
+
+// CreateFeedToken stores a token whose secret hashes to hash.
+func CreateFeedToken(ctx context.Context, db *sql.DB, in FeedToken, hash string) (FeedToken, error) {
+	if db == nil {
+		return FeedToken{}, fmt.Errorf("db is nil")
+	}
+
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return FeedToken{}, fmt.Errorf("name is required")
+	}
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(time.Now()) {
+		return FeedToken{}, fmt.Errorf("expiresAt must be in the future")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		INSERT INTO feed_tokens (tokenHash, name, personID, calendarID, expiresAt)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+feedTokenColumns+`;
+	`, hash, in.Name, in.PersonID, in.CalendarID, in.ExpiresAt)
+
+	var out FeedToken
+	if err := scanFeedToken(row, &out); err != nil {
+		return FeedToken{}, fmt.Errorf("insert feed token: %w", err)
+	}
+
+	return out, nil
+}
+
+func ListFeedTokens(ctx context.Context, db *sql.DB) ([]FeedToken, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+feedTokenColumns+`
+		FROM feed_tokens
+		ORDER BY createdAt, tokenID;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list feed tokens query: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]FeedToken, 0)
+	for rows.Next() {
+		var t FeedToken
+		if err := scanFeedToken(rows, &t); err != nil {
+			return nil, fmt.Errorf("list feed tokens scan: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list feed tokens rows: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// UseFeedToken finds the unexpired token with hash and records that it was
+// used. Unknown, revoked and expired tokens all give sql.ErrNoRows.
+func UseFeedToken(ctx context.Context, db *sql.DB, hash string, now time.Time) (*FeedToken, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		UPDATE feed_tokens SET lastUsedAt = $2
+		WHERE tokenHash = $1 AND (expiresAt IS NULL OR expiresAt > $2)
+		RETURNING `+feedTokenColumns+`;
+	`, hash, now)
+
+	var t FeedToken
+	if err := scanFeedToken(row, &t); err != nil {
+		return nil, fmt.Errorf("use feed token: %w", err)
+	}
+
+	return &t, nil
+}
+
+// DeleteFeedToken revokes a token; its URL stops working immediately.
+func DeleteFeedToken(ctx context.Context, db *sql.DB, id string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM feed_tokens WHERE tokenID = $1`, id)
+	if err != nil {
+		return fmt.Errorf("Failed to execute feed token delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
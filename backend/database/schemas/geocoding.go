@@ -0,0 +1,69 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// PendingGeocode is an event location that hasn't been looked up yet.
+type PendingGeocode struct {
+	EventID  string
+	Location string
+}
+
+// ListPendingGeocodes returns up to limit events, oldest first, whose
+// location is new or changed since it was last geocoded.
+func ListPendingGeocodes(ctx context.Context, db *sql.DB, limit int) ([]PendingGeocode, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT eventID, location
+		FROM events
+		WHERE location IS NOT NULL AND geocodedLocation IS DISTINCT FROM location AND deletedAt IS NULL
+		ORDER BY createdAt, eventID
+		LIMIT $1;
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list pending geocodes query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]PendingGeocode, 0)
+	for rows.Next() {
+		var p PendingGeocode
+		if err := rows.Scan(&p.EventID, &p.Location); err != nil {
+			return nil, fmt.Errorf("list pending geocodes scan: %w", err)
+		}
+		out = append(out, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list pending geocodes rows: %w", err)
+	}
+
+	return out, nil
+}
+
+// SetEventCoordinates records where location is for an event. Nil
+// coordinates mean the address couldn't be found; it isn't retried until it
+// changes. Nothing is stored if the location was edited in the meantime.
+func SetEventCoordinates(ctx context.Context, db *sql.DB, id, location string, lat, lng *float64) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		UPDATE events SET latitude = $3, longitude = $4, geocodedLocation = $2
+		WHERE eventID = $1 AND location = $2
+	`, id, location, lat, lng)
+	if err != nil {
+		return fmt.Errorf("set event coordinates: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil
+	}
+	return logEventChange(ctx, db, id, false)
+}
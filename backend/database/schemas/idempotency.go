@@ -0,0 +1,134 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// IdempotencyKey is a request made with an Idempotency-Key header and, once
+// it has finished, the response it got.
+type IdempotencyKey struct {
+	Key string
+	// Hash of the method, URL and body, so a key reused for a different
+	// request can be told apart from a retry
+	RequestHash string
+	// 0 while the first request is still running
+	Status      int
+	ContentType string
+	ETag        string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+func CreateIdempotencyKeySchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "key",
+			Type:       ColumnText,
+			PrimaryKey: true},
+		Column{Name: "requestHash",
+			Type: ColumnText},
+		Column{Name: "status",
+			Type:           ColumnInt,
+			DefaultSQLExpr: SQLDefault("0")},
+		Column{Name: "contentType",
+			Type:     ColumnText,
+			Nullable: true},
+		Column{Name: "etag",
+			Type:     ColumnText,
+			Nullable: true},
+		Column{Name: "body",
+			Type:     ColumnText,
+			Nullable: true},
+		Column{Name: "createdAt",
+			Type:           ColumnTimestamp,
+			DefaultSQLExpr: DefaultNow()},
+	)
+
+	schema := Schema{Name: "idempotency_keys", Columns: cols}
+	return schema
+}
+
+// ClaimIdempotencyKey marks key as taken by the request hashed as hash. If
+// another request holds it already, that one is returned instead and the
+// caller must not run its own.
+func ClaimIdempotencyKey(ctx context.Context, db *sql.DB, key, hash string) (*IdempotencyKey, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (key, requestHash)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING;
+	`, key, hash)
+	if err != nil {
+		return nil, fmt.Errorf("claim idempotency key: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 1 {
+		return nil, nil
+	}
+
+	var k IdempotencyKey
+	var contentType, etag, body sql.NullString
+	err = db.QueryRowContext(ctx, `
+		SELECT key, requestHash, status, contentType, etag, body, createdAt
+		FROM idempotency_keys
+		WHERE key = $1;
+	`, key).Scan(&k.Key, &k.RequestHash, &k.Status, &contentType, &etag, &body, &k.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released between the insert and the read; claim it again
+		return ClaimIdempotencyKey(ctx, db, key, hash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("idempotency key query: %w", err)
+	}
+	k.ContentType, k.ETag, k.Body = contentType.String, etag.String, []byte(body.String)
+
+	return &k, nil
+}
+
+// CompleteIdempotencyKey stores the response to the request holding key,
+// for its retries to get.
+func CompleteIdempotencyKey(ctx context.Context, db *sql.DB, key string, status int, contentType, etag string, body []byte) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	if _, err := db.ExecContext(ctx, `
+		UPDATE idempotency_keys
+		SET status = $2, contentType = $3, etag = $4, body = $5
+		WHERE key = $1;
+	`, key, status, contentType, etag, string(body)); err != nil {
+		return fmt.Errorf("complete idempotency key: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets key, so the next request with it runs
+// afresh.
+func ReleaseIdempotencyKey(ctx context.Context, db *sql.DB, key string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1`, key); err != nil {
+		return fmt.Errorf("release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// PruneIdempotencyKeys forgets keys claimed before cutoff.
+func PruneIdempotencyKeys(ctx context.Context, db *sql.DB, cutoff time.Time) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE createdAt < $1`, cutoff); err != nil {
+		return fmt.Errorf("prune idempotency keys: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Series is an event together with the exceptions that modify it and the
+// reminders it should have.
+type Series struct {
+	Event      Event
+	Exceptions []Exception
+	Reminders  []Reminder
+}
+
+// ImportSeries creates every series in a single transaction. Either all of
+// them are stored or, on the first error, none are. A dry run rolls the
+// transaction back, returning the events as they would have been created.
+func ImportSeries(ctx context.Context, db *sql.DB, series []Series, dryRun bool) ([]Event, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	created := make([]Event, 0, len(series))
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		for i, s := range series {
+			if err := ValidateEvent(s.Event); err != nil {
+				return fmt.Errorf("series %d: %w", i, err)
+			}
+
+			s.Event.FeedID, s.Event.FeedUID = nil, nil
+			e, err := insertEvent(ctx, tx, s.Event)
+			if err != nil {
+				return fmt.Errorf("series %d: %w", i, err)
+			}
+
+			for _, ex := range s.Exceptions {
+				ex.EventID = e.EventID
+				if err := insertException(ctx, tx, ex); err != nil {
+					return fmt.Errorf("series %d: %w", i, err)
+				}
+			}
+			for _, r := range s.Reminders {
+				r.EventID = e.EventID
+				if err := insertReminder(ctx, tx, r); err != nil {
+					return fmt.Errorf("series %d: %w", i, err)
+				}
+			}
+			created = append(created, e)
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+
+	return created, nil
+}
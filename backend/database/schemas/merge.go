@@ -0,0 +1,165 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// MergeResult is what merging one person or calendar into another moved.
+type MergeResult struct {
+	// The events that moved, as they are after the merge, trashed ones included
+	Events []Event
+	// Other rows that moved, by table. Rows the one kept already had an
+	// equivalent of, like attendance at the same event, are dropped instead
+	// and not counted.
+	Moved map[string]int
+}
+
+// mergeStep moves one table's rows from $1 to $2, or with drop set, deletes
+// the ones that can't move.
+type mergeStep struct {
+	table string
+	query string
+	drop  bool
+}
+
+// Everything besides events that points at a person, in the order it is moved
+var personMergeSteps = []mergeStep{
+	// Someone listed as both only attends once
+	{"attendees", `DELETE FROM attendees a WHERE personID = $1
+		AND EXISTS (SELECT 1 FROM attendees b WHERE b.eventID = a.eventID AND b.personID = $2)`, true},
+	{"attendees", `UPDATE attendees SET personID = $2 WHERE personID = $1`, false},
+	{"feeds", `UPDATE feeds SET personID = $2 WHERE personID = $1`, false},
+	{"sync_state", `UPDATE sync_state SET personID = $2 WHERE personID = $1`, false},
+	{"feed_tokens", `UPDATE feed_tokens SET personID = $2 WHERE personID = $1`, false},
+	// The kept person's own push settings win
+	{"push_settings", `UPDATE push_settings SET personID = $2 WHERE personID = $1
+		AND NOT EXISTS (SELECT 1 FROM push_settings WHERE personID = $2)`, false},
+	{"display_profile_people", `INSERT INTO display_profile_people (profileID, personID)
+		SELECT profileID, $2 FROM display_profile_people WHERE personID = $1
+		ON CONFLICT DO NOTHING`, false},
+	{"webhooks", `UPDATE webhooks SET personIDs = ` + mergedIDs("personIDs") + ` WHERE $1::text = ANY(personIDs)`, false},
+}
+
+// Everything besides events that points at a calendar, in the order it is moved
+var calendarMergeSteps = []mergeStep{
+	{"feeds", `UPDATE feeds SET calendarID = $2 WHERE calendarID = $1`, false},
+	{"feed_tokens", `UPDATE feed_tokens SET calendarID = $2 WHERE calendarID = $1`, false},
+	{"display_profile_calendars", `INSERT INTO display_profile_calendars (profileID, calendarID)
+		SELECT profileID, $2 FROM display_profile_calendars WHERE calendarID = $1
+		ON CONFLICT DO NOTHING`, false},
+	{"webhooks", `UPDATE webhooks SET calendarIDs = ` + mergedIDs("calendarIDs") + ` WHERE $1::text = ANY(calendarIDs)`, false},
+}
+
+// mergedIDs is col with $1 replaced by $2, keeping its order and listing $2 once.
+func mergedIDs(col string) string {
+	return `ARRAY(SELECT id FROM unnest(array_replace(` + col + `, $1::text, $2::text)) WITH ORDINALITY u(id, n)
+		GROUP BY id ORDER BY min(n))`
+}
+
+// MergePeople moves everything of from's, their events, attendance, feeds,
+// sync accounts, feed tokens, push settings, display profiles and webhook
+// filters, to into and deletes from, in one transaction. Exceptions,
+// reminders and task completions go with their events. A dry run reports
+// what would move without changing anything. Either person not existing is
+// sql.ErrNoRows.
+func MergePeople(ctx context.Context, db *sql.DB, from, into string, dryRun bool) (MergeResult, error) {
+	if db == nil {
+		return MergeResult{}, fmt.Errorf("db is nil")
+	}
+	// Events they attend list them by name
+	attended := `eventID IN (SELECT eventID FROM attendees WHERE personID = $1)`
+	return merge(ctx, db, "people", "personID", from, into, attended, personMergeSteps, dryRun)
+}
+
+// MergeCalendars is MergePeople for calendars: from's events, feeds, feed
+// tokens, display profiles and webhook filters move to into.
+func MergeCalendars(ctx context.Context, db *sql.DB, from, into string, dryRun bool) (MergeResult, error) {
+	if db == nil {
+		return MergeResult{}, fmt.Errorf("db is nil")
+	}
+	return merge(ctx, db, "calendars", "calendarID", from, into, "", calendarMergeSteps, dryRun)
+}
+
+// merge moves the events and steps' rows that point at from in table to
+// into, where key is the column for both, and then deletes from. Events
+// matching also, if given, for from as $1, are logged as changed as well.
+func merge(ctx context.Context, db *sql.DB, table, key, from, into, also string, steps []mergeStep, dryRun bool) (MergeResult, error) {
+	if from == into {
+		return MergeResult{}, fmt.Errorf("can't merge into itself")
+	}
+
+	out := MergeResult{Events: make([]Event, 0), Moved: make(map[string]int, len(steps))}
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		var found int
+		if err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM (SELECT 1 FROM `+table+` WHERE `+key+` IN ($1, $2) FOR UPDATE) t;
+		`, from, into).Scan(&found); err != nil {
+			return fmt.Errorf("merge %s query: %w", table, err)
+		}
+		if found != 2 {
+			return sql.ErrNoRows
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+			UPDATE events SET `+key+` = $2, version = version + 1
+			WHERE `+key+` = $1
+			RETURNING `+eventColumns+`;
+		`, from, into)
+		if err != nil {
+			return fmt.Errorf("merge %s events: %w", table, err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var e Event
+			if err := scanEvent(rows, &e); err != nil {
+				return fmt.Errorf("merge %s events scan: %w", table, err)
+			}
+			out.Events = append(out.Events, e)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("merge %s events rows: %w", table, err)
+		}
+		rows.Close()
+
+		moved := make([]string, 0, len(out.Events))
+		for _, e := range out.Events {
+			moved = append(moved, e.EventID)
+		}
+		if err := logEventChanges(ctx, tx, false, `eventID = ANY($1::uuid[]) AND deletedAt IS NULL`, moved); err != nil {
+			return err
+		}
+		if also != "" {
+			if err := logEventChanges(ctx, tx, false, also+` AND deletedAt IS NULL`, from); err != nil {
+				return err
+			}
+		}
+
+		for _, step := range steps {
+			result, err := tx.ExecContext(ctx, step.query, from, into)
+			if err != nil {
+				return fmt.Errorf("merge %s %s: %w", table, step.table, err)
+			}
+			if n, _ := result.RowsAffected(); !step.drop {
+				out.Moved[step.table] += int(n)
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE `+key+` = $1`, from); err != nil {
+			return fmt.Errorf("merge %s delete: %w", table, err)
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return MergeResult{}, err
+	}
+
+	return out, nil
+}
@@ -0,0 +1,440 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Migration is one numbered step in the database's history. Up and Down
+// each run in a transaction of their own, together with the update to
+// schema_migrations. A nil Down means the step can't be undone.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, tx *sql.Tx) error
+	Down    func(ctx context.Context, tx *sql.Tx) error
+}
+
+// AppliedMigration is a migration recorded in schema_migrations.
+type AppliedMigration struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"appliedAt"`
+}
+
+// The Create*Schema functions describe the tables as they are now. The
+// baseline creates them on a fresh install, and once the database is at the
+// latest migration every table is brought in line with them, so new columns
+// that are nullable or have a default, and changed types or nullability,
+// need no migration of their own. Migrations are for the rest, such as new
+// NOT NULL columns existing rows need filling in, renaming or dropping
+// columns and moving data, and should use IF EXISTS and IF NOT EXISTS since a fresh
+// install already has the tables in their current shape.
+var migrations = []Migration{
+	{Version: 1, Name: "baseline", Up: baselineUp, Down: baselineDown},
+	{Version: 2, Name: "reminder dismissals",
+		Up:   execMigration(`ALTER TABLE reminder_deliveries ADD COLUMN IF NOT EXISTS dismissedAt timestamptz, ADD COLUMN IF NOT EXISTS dismissedBy varchar(255)`),
+		Down: execMigration(`ALTER TABLE reminder_deliveries DROP COLUMN IF EXISTS dismissedAt, DROP COLUMN IF EXISTS dismissedBy`)},
+	{Version: 3, Name: "series pauses",
+		Up:   func(ctx context.Context, tx *sql.Tx) error { return createSchema(ctx, tx, CreatePauseSchema()) },
+		Down: execMigration(`DROP TABLE IF EXISTS pauses`)},
+	// Nothing to undo: a fresh install at 3 already has events this way
+	{Version: 4, Name: "legacy events", Up: legacyEventsUp,
+		Down: func(ctx context.Context, tx *sql.Tx) error { return nil }},
+	{Version: 5, Name: "backup targets",
+		Up:   func(ctx context.Context, tx *sql.Tx) error { return createSchema(ctx, tx, CreateBackupTargetSchema()) },
+		Down: execMigration(`DROP TABLE IF EXISTS backup_targets`)},
+	{Version: 6, Name: "leave-by reminders", Up: leaveByRemindersUp, Down: leaveByRemindersDown},
+}
+
+// execMigration is a migration step that runs one SQL statement.
+func execMigration(sqlStr string) func(ctx context.Context, tx *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, sqlStr); err != nil {
+			return fmt.Errorf("%w\nSQL: %s", err, sqlStr)
+		}
+		return nil
+	}
+}
+
+// hasLegacyEvents reports whether events is still the table from before
+// people, with the person's name on every event.
+func hasLegacyEvents(ctx context.Context, q queryer) (bool, error) {
+	live, err := liveColumns(ctx, q, "events")
+	if err != nil {
+		return false, err
+	}
+	_, ok := live["personname"]
+	return ok, nil
+}
+
+// legacyEventsUp moves events from before people onto them: a person for
+// every distinct name, ignoring case and surrounding space, and the event's
+// personID pointed at them in place of personName. Their times move in from
+// the old occurrences table, one row per event, which is then dropped; an
+// event without one starts at midnight today. It does nothing on installs
+// whose events are already in their current shape.
+func legacyEventsUp(ctx context.Context, tx *sql.Tx) error {
+	legacy, err := hasLegacyEvents(ctx, tx)
+	if err != nil || !legacy {
+		return err
+	}
+
+	var hasOccurrences bool
+	if err := tx.QueryRowContext(ctx, `SELECT to_regclass('occurrences') IS NOT NULL`).Scan(&hasOccurrences); err != nil {
+		return fmt.Errorf("legacy occurrences query: %w", err)
+	}
+	stmts := []string{
+		`ALTER TABLE events ADD COLUMN IF NOT EXISTS startTime timestamptz, ADD COLUMN IF NOT EXISTS endTime timestamptz`,
+	}
+	if hasOccurrences {
+		stmts = append(stmts,
+			`UPDATE events SET startTime = o.startTime, endTime = o.endTime
+			FROM occurrences o
+			WHERE o.eventID = events.eventID AND events.startTime IS NULL`,
+			`DROP TABLE occurrences`)
+	}
+	for _, sqlStr := range stmts {
+		if _, err := tx.ExecContext(ctx, sqlStr); err != nil {
+			return fmt.Errorf("%w\nSQL: %s", err, sqlStr)
+		}
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE events SET startTime = date_trunc('day', CURRENT_TIMESTAMP) WHERE startTime IS NULL`)
+	if err != nil {
+		return fmt.Errorf("legacy start times: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		log.Printf("database: %d events had no start time and now start at midnight today", n)
+	}
+
+	// A blank name still has to belong to someone
+	const name = `coalesce(nullif(trim(events.personName), ''), 'Unassigned')`
+	stmts = []string{
+		`INSERT INTO people (displayName)
+		SELECT DISTINCT ON (lower(` + name + `)) ` + name + `
+		FROM events
+		WHERE NOT EXISTS (SELECT 1 FROM people p WHERE lower(p.displayName) = lower(` + name + `))
+		ORDER BY lower(` + name + `), ` + name,
+		`ALTER TABLE events ADD COLUMN IF NOT EXISTS personID uuid REFERENCES people(personID) ON DELETE RESTRICT`,
+		`UPDATE events SET personID = p.personID
+		FROM people p
+		WHERE events.personID IS NULL AND lower(p.displayName) = lower(` + name + `)`,
+		`ALTER TABLE events ALTER COLUMN personID SET NOT NULL, ALTER COLUMN startTime SET NOT NULL`,
+		`ALTER TABLE events DROP COLUMN personName`,
+	}
+	for _, sqlStr := range stmts {
+		if _, err := tx.ExecContext(ctx, sqlStr); err != nil {
+			return fmt.Errorf("%w\nSQL: %s", err, sqlStr)
+		}
+	}
+	return nil
+}
+
+// leaveByRemindersUp lets an event have a leave-by reminder beside a plain
+// one at the same offset, and adds the travel times they are worked out
+// from.
+func leaveByRemindersUp(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`ALTER TABLE reminders ADD COLUMN IF NOT EXISTS leaveBy boolean NOT NULL DEFAULT false`,
+		`DROP INDEX IF EXISTS reminders_event_idx`,
+		`CREATE UNIQUE INDEX reminders_event_idx ON reminders (eventID, offsetMinutes, channel, leaveBy)`,
+	}
+	for _, sqlStr := range stmts {
+		if _, err := tx.ExecContext(ctx, sqlStr); err != nil {
+			return fmt.Errorf("%w\nSQL: %s", err, sqlStr)
+		}
+	}
+	return createSchema(ctx, tx, CreateTravelTimeSchema())
+}
+
+// leaveByRemindersDown drops leave-by reminders, since they would clash
+// with plain ones at the same offset once the index is back as it was.
+func leaveByRemindersDown(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`DROP TABLE IF EXISTS travel_times`,
+		`DELETE FROM reminders WHERE leaveBy`,
+		`DROP INDEX IF EXISTS reminders_event_idx`,
+		`ALTER TABLE reminders DROP COLUMN IF EXISTS leaveBy`,
+		`CREATE UNIQUE INDEX reminders_event_idx ON reminders (eventID, offsetMinutes, channel)`,
+	}
+	for _, sqlStr := range stmts {
+		if _, err := tx.ExecContext(ctx, sqlStr); err != nil {
+			return fmt.Errorf("%w\nSQL: %s", err, sqlStr)
+		}
+	}
+	return nil
+}
+
+// tableSchemas are every table, in the order they have to be created in for
+// their foreign keys.
+var tableSchemas = []func() Schema{
+	// People first: events, feeds and sync accounts reference them
+	CreatePeopleSchema,
+	CreatePushSchema,
+	CreateCalendarSchema,
+	CreateTagSchema,
+	// Feeds before events: events reference them
+	CreateFeedSchema,
+	CreateEventSchema,
+	CreateEventChangeSchema,
+	CreateEventTagSchema,
+	CreateReminderSchema,
+	CreateReminderDeliverySchema,
+	CreateTravelTimeSchema,
+	CreateAttachmentSchema,
+	CreateAttendeeSchema,
+	CreateExternalIDSchema,
+	CreateOccurrenceStateSchema,
+	CreateOccurrenceSchema,
+	CreateExceptionSchema,
+	CreatePauseSchema,
+	CreateDisplayProfileSchema,
+	CreateDisplayProfileCalendarSchema,
+	CreateDisplayProfilePersonSchema,
+	CreateWebhookSchema,
+	CreateWebhookDeliverySchema,
+	CreateDeviceSchema,
+	CreateFeedTokenSchema,
+	CreateAPITokenSchema,
+	CreateSyncStateSchema,
+	CreateSyncLinkSchema,
+	CreateTaskCompletionSchema,
+	CreateIdempotencyKeySchema,
+	CreateSettingsSchema,
+	CreateBackupTargetSchema,
+}
+
+// baselineUp creates every table. Installs from before migrations already
+// have most of them, so existing tables are only given the columns they
+// are missing. Events from before people are left to legacyEventsUp, as
+// their new columns need filling in from the old ones.
+func baselineUp(ctx context.Context, tx *sql.Tx) error {
+	legacy, err := hasLegacyEvents(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, create := range tableSchemas {
+		schema := create()
+		if schema.Name == "events" && legacy {
+			continue
+		}
+		if err := createSchema(ctx, tx, schema); err != nil {
+			return err
+		}
+		if schema.Name == "event_changes" {
+			if err := backfillEventChanges(ctx, tx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func baselineDown(ctx context.Context, tx *sql.Tx) error {
+	for i := len(tableSchemas) - 1; i >= 0; i-- {
+		name := tableSchemas[i]().Name
+		if _, err := tx.ExecContext(ctx, `DROP TABLE IF EXISTS `+name+` CASCADE`); err != nil {
+			return fmt.Errorf("drop %q: %w", name, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DROP FUNCTION IF EXISTS touch_updated_at()`); err != nil {
+		return fmt.Errorf("drop touch_updated_at: %w", err)
+	}
+	return nil
+}
+
+func CreateMigrationSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "version",
+			Type:       ColumnInt,
+			PrimaryKey: true},
+		Column{Name: "name",
+			Type: ColumnString},
+		Column{Name: "appliedAt",
+			Type:           ColumnTimestamp,
+			DefaultSQLExpr: DefaultNow()},
+	)
+
+	schema := Schema{Name: "schema_migrations", Columns: cols}
+	return schema
+}
+
+// LatestMigration is the version this build migrates up to.
+func LatestMigration() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// beginMigration starts a transaction holding the migration lock, so
+// servers starting side by side take turns, and returns the version the
+// database is at.
+func beginMigration(ctx context.Context, db *sql.DB) (*sql.Tx, int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("begin migration: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('schema_migrations'))`); err != nil {
+		_ = tx.Rollback()
+		return nil, 0, fmt.Errorf("lock migrations: %w", err)
+	}
+	if err := createSchema(ctx, tx, CreateMigrationSchema()); err != nil {
+		_ = tx.Rollback()
+		return nil, 0, err
+	}
+
+	var current int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		_ = tx.Rollback()
+		return nil, 0, fmt.Errorf("current migration: %w", err)
+	}
+	return tx, current, nil
+}
+
+// MigrateTo runs migrations up or down, one transaction each, until the
+// database is at version; 0 undoes them all. Migrating to the latest also
+// syncs the tables with their schemas.
+func MigrateTo(ctx context.Context, db *sql.DB, version int) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+	if version < 0 || version > LatestMigration() {
+		return fmt.Errorf("no migration %d; this build knows 0 to %d", version, LatestMigration())
+	}
+	if err := checkReferences(declaredTables()); err != nil {
+		return fmt.Errorf("schemas: %w", err)
+	}
+
+	for {
+		done, err := migrateStep(ctx, db, version)
+		if err != nil {
+			return err
+		}
+		if done {
+			break
+		}
+	}
+	if version == LatestMigration() {
+		return syncSchemas(ctx, db)
+	}
+	return nil
+}
+
+// syncSchemas brings every table in line with its schema, in one
+// transaction under the migration lock.
+func syncSchemas(ctx context.Context, db *sql.DB) error {
+	tx, _, err := beginMigration(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, create := range tableSchemas {
+		if err := createSchema(ctx, tx, create()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// migrateStep applies or undoes the one migration that takes the database
+// closer to version, and reports whether it was already there.
+func migrateStep(ctx context.Context, db *sql.DB, version int) (bool, error) {
+	tx, current, err := beginMigration(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if current == version {
+		return true, tx.Commit()
+	}
+	if current > LatestMigration() {
+		return false, fmt.Errorf("database is at migration %d, newer than this build's %d; migrate it down with the build that applied it", current, LatestMigration())
+	}
+
+	if current < version {
+		var m Migration
+		for _, m = range migrations {
+			if m.Version > current {
+				break
+			}
+		}
+		if err := m.Up(ctx, tx); err != nil {
+			return false, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name); err != nil {
+			return false, fmt.Errorf("record migration %d: %w", m.Version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return false, err
+		}
+		log.Printf("database: applied migration %d %s", m.Version, m.Name)
+		return false, nil
+	}
+
+	var m *Migration
+	for i := range migrations {
+		if migrations[i].Version == current {
+			m = &migrations[i]
+		}
+	}
+	if m == nil {
+		return false, fmt.Errorf("database is at migration %d, which this build doesn't have", current)
+	}
+	if m.Down == nil {
+		return false, fmt.Errorf("migration %d %s can't be undone", m.Version, m.Name)
+	}
+	if err := m.Down(ctx, tx); err != nil {
+		return false, fmt.Errorf("undo migration %d %s: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+		return false, fmt.Errorf("unrecord migration %d: %w", m.Version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	log.Printf("database: undid migration %d %s", m.Version, m.Name)
+	return false, nil
+}
+
+// ListAppliedMigrations returns the migrations the database has had, oldest
+// first.
+func ListAppliedMigrations(ctx context.Context, db *sql.DB) ([]AppliedMigration, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	tx, _, err := beginMigration(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT version, name, appliedAt
+		FROM schema_migrations
+		ORDER BY version;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list migrations query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]AppliedMigration, 0)
+	for rows.Next() {
+		var m AppliedMigration
+		if err := rows.Scan(&m.Version, &m.Name, &m.AppliedAt); err != nil {
+			return nil, fmt.Errorf("list migrations scan: %w", err)
+		}
+		out = append(out, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list migrations rows: %w", err)
+	}
+
+	return out, nil
+}
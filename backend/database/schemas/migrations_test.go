@@ -0,0 +1,300 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// scratchDB connects to DATABASE_URL with a new, empty Postgres schema as
+// the only one on the search path, dropped again when the test ends. The
+// test is skipped without DATABASE_URL.
+func scratchDB(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	url := os.Getenv("DATABASE_URL")
+	if url == "" {
+		t.Skip("DATABASE_URL isn't set")
+	}
+	ctx := context.Background()
+
+	cfg, err := pgx.ParseConfig(url)
+	if err != nil {
+		t.Fatalf("DATABASE_URL: %v", err)
+	}
+	admin := stdlib.OpenDB(*cfg)
+	t.Cleanup(func() { admin.Close() })
+
+	namespace := fmt.Sprintf("pical_migration_test_%d", time.Now().UnixNano())
+	if _, err := admin.ExecContext(ctx, `CREATE SCHEMA `+namespace); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.ExecContext(context.Background(), `DROP SCHEMA `+namespace+` CASCADE`); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	scoped := cfg.Copy()
+	scoped.RuntimeParams["search_path"] = namespace
+	db := stdlib.OpenDB(*scoped)
+	t.Cleanup(func() { db.Close() })
+	return db, namespace
+}
+
+// liveTables lists the tables in namespace other than schema_migrations.
+func liveTables(t *testing.T, db *sql.DB, namespace string) []string {
+	t.Helper()
+	rows, err := db.Query(`
+		SELECT table_name FROM information_schema.tables
+		WHERE table_schema = $1 AND table_name <> 'schema_migrations'
+		ORDER BY table_name;
+	`, namespace)
+	if err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+	defer rows.Close()
+
+	out := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("list tables scan: %v", err)
+		}
+		out = append(out, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("list tables rows: %v", err)
+	}
+	return out
+}
+
+func TestMigrationsFromScratch(t *testing.T) {
+	db, namespace := scratchDB(t)
+	ctx := context.Background()
+	tables := declaredTables()
+
+	if err := checkReferences(tables); err != nil {
+		t.Fatalf("schemas: %v", err)
+	}
+
+	// Twice, so the downs are shown to leave nothing the ups trip over
+	for round := 1; round <= 2; round++ {
+		if err := MigrateTo(ctx, db, LatestMigration()); err != nil {
+			t.Fatalf("round %d: migrate up: %v", round, err)
+		}
+		applied, err := ListAppliedMigrations(ctx, db)
+		if err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		if len(applied) != len(migrations) {
+			t.Fatalf("round %d: %d migrations applied, want %d", round, len(applied), len(migrations))
+		}
+
+		fks, err := checkLiveSchema(ctx, db, namespace, tables)
+		if err != nil {
+			t.Fatalf("round %d: after migrating up: %v", round, err)
+		}
+		if fks == 0 {
+			t.Fatalf("round %d: no foreign keys were checked", round)
+		}
+		if got := liveTables(t, db, namespace); len(got) != len(tables) {
+			t.Fatalf("round %d: %d tables after migrating up, want %d: %v", round, len(got), len(tables), got)
+		}
+
+		if err := MigrateTo(ctx, db, 0); err != nil {
+			t.Fatalf("round %d: migrate down: %v", round, err)
+		}
+		if got := liveTables(t, db, namespace); len(got) != 0 {
+			t.Fatalf("round %d: tables left after migrating down: %v", round, got)
+		}
+		var dangling int
+		if err := db.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM pg_constraint WHERE contype = 'f' AND connamespace = $1::regnamespace;
+		`, namespace).Scan(&dangling); err != nil {
+			t.Fatalf("round %d: count foreign keys: %v", round, err)
+		}
+		if dangling != 0 {
+			t.Fatalf("round %d: %d foreign keys left after migrating down", round, dangling)
+		}
+	}
+}
+
+// legacyDDL is the first schema, from before migrations, people or start
+// times on events.
+const legacyDDL = `
+	CREATE TABLE events (
+		eventID uuid PRIMARY KEY NOT NULL DEFAULT gen_random_uuid(),
+		personName varchar(255) NOT NULL,
+		title varchar(255) NOT NULL,
+		notes varchar(255),
+		timezone varchar(255) NOT NULL DEFAULT 'UTC',
+		allDay boolean NOT NULL DEFAULT FALSE,
+		rrule varchar(255)
+	);
+	CREATE TABLE occurrences (
+		eventID uuid PRIMARY KEY NOT NULL REFERENCES Events(eventID) ON DELETE CASCADE,
+		startTime timestamptz NOT NULL,
+		endTime timestamptz,
+		moved boolean NOT NULL DEFAULT FALSE,
+		oldStartTime timestamptz,
+		oldEndTime timestamptz
+	);
+	CREATE TABLE exceptions (
+		eventID uuid NOT NULL REFERENCES events(eventID) ON DELETE CASCADE,
+		recurrenceID timestamptz NOT NULL,
+		kind integer NOT NULL,
+		newStart timestamptz,
+		newEnd timestamptz,
+		PRIMARY KEY (eventID, recurrenceID)
+	);
+	INSERT INTO events (eventID, personName, title) VALUES
+		('00000000-0000-0000-0000-000000000001', 'Ann', 'Swimming'),
+		('00000000-0000-0000-0000-000000000002', ' ann ', 'Piano'),
+		('00000000-0000-0000-0000-000000000003', 'Bob', 'Football');
+	INSERT INTO occurrences (eventID, startTime, endTime) VALUES
+		('00000000-0000-0000-0000-000000000001', '2024-03-01 17:00Z', '2024-03-01 18:00Z'),
+		('00000000-0000-0000-0000-000000000002', '2024-03-02 09:00Z', NULL);
+	INSERT INTO exceptions (eventID, recurrenceID, kind) VALUES
+		('00000000-0000-0000-0000-000000000001', '2024-03-08 17:00Z', 0);
+`
+
+func TestMigrationsFromLegacy(t *testing.T) {
+	db, namespace := scratchDB(t)
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, legacyDDL); err != nil {
+		t.Fatalf("legacy schema: %v", err)
+	}
+
+	if err := MigrateTo(ctx, db, LatestMigration()); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	if _, err := checkLiveSchema(ctx, db, namespace, declaredTables()); err != nil {
+		t.Fatalf("after migrating up: %v", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT e.eventID::text, p.displayName, e.startTime, e.endTime IS NOT NULL
+		FROM events e JOIN people p USING (personID)
+		ORDER BY e.eventID;
+	`)
+	if err != nil {
+		t.Fatalf("events query: %v", err)
+	}
+	defer rows.Close()
+	type row struct {
+		person string
+		start  time.Time
+		hasEnd bool
+	}
+	got := make(map[string]row)
+	for rows.Next() {
+		var id string
+		var r row
+		if err := rows.Scan(&id, &r.person, &r.start, &r.hasEnd); err != nil {
+			t.Fatalf("events scan: %v", err)
+		}
+		got[id] = r
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("events rows: %v", err)
+	}
+
+	ann := got["00000000-0000-0000-0000-000000000001"]
+	if ann.person != "Ann" || !ann.start.Equal(time.Date(2024, 3, 1, 17, 0, 0, 0, time.UTC)) || !ann.hasEnd {
+		t.Errorf("swimming = %+v, want Ann at 2024-03-01 17:00 with an end", ann)
+	}
+	if piano := got["00000000-0000-0000-0000-000000000002"]; piano.person != "Ann" || piano.hasEnd {
+		t.Errorf("piano = %+v, want Ann without an end", piano)
+	}
+	if football := got["00000000-0000-0000-0000-000000000003"]; football.person != "Bob" || football.start.IsZero() {
+		t.Errorf("football = %+v, want Bob with a start time", football)
+	}
+	var people int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM people`).Scan(&people); err != nil {
+		t.Fatalf("count people: %v", err)
+	}
+	if people != 2 {
+		t.Errorf("%d people, want Ann and Bob", people)
+	}
+	var exceptions int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM exceptions`).Scan(&exceptions); err != nil {
+		t.Fatalf("count exceptions: %v", err)
+	}
+	if exceptions != 1 {
+		t.Errorf("%d exceptions, want the one from before", exceptions)
+	}
+
+	// Events can be written in the new shape
+	if _, err := CreateEvent(ctx, db, Event{PersonName: "Bob", Title: "Tennis", Timezone: "UTC", StartTime: time.Now()}); err != nil {
+		t.Fatalf("create event after upgrading: %v", err)
+	}
+}
+
+func TestVerifyBootstrap(t *testing.T) {
+	db, _ := scratchDB(t)
+
+	n, fks, err := VerifyBootstrap(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(tableSchemas) || fks == 0 {
+		t.Fatalf("checked %d tables and %d foreign keys, want %d tables and some keys", n, fks, len(tableSchemas))
+	}
+}
+
+func TestVerifyBackup(t *testing.T) {
+	db, _ := scratchDB(t)
+	ctx := context.Background()
+	if err := MigrateTo(ctx, db, LatestMigration()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if _, err := CreateEvent(ctx, db, Event{PersonName: "Ann", Title: "Swim", Timezone: "UTC", StartTime: time.Now()}); err != nil {
+		t.Fatalf("create event: %v", err)
+	}
+	backup, err := ExportBackup(ctx, db)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	check, err := VerifyBackup(ctx, db, backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.OK || len(check.Tables) != len(backupTables()) {
+		t.Fatalf("check = %+v, want OK for every table", check)
+	}
+	for _, c := range check.Tables {
+		if c.Table == "events" && c.Restored != 1 {
+			t.Errorf("events restored %d rows, want 1", c.Restored)
+		}
+	}
+
+	// The same person twice breaks the primary key, so it can't restore
+	broken := backup
+	broken.Tables = maps.Clone(backup.Tables)
+	people := string(backup.Tables["people"])
+	broken.Tables["people"] = json.RawMessage(people[:len(people)-1] + "," + people[1:])
+	if check, err := VerifyBackup(ctx, db, broken); err != nil || check.OK || check.Error == "" {
+		t.Errorf("broken backup: check = %+v, err = %v; want a failed check", check, err)
+	}
+
+	old := backup
+	old.Migration--
+	if check, err := VerifyBackup(ctx, db, old); err != nil || check.OK {
+		t.Errorf("backup from an older migration: check = %+v, err = %v; want a failed check", check, err)
+	}
+
+	// The live tables are left as they were
+	var events int
+	if err := db.QueryRowContext(ctx, `SELECT count(*) FROM events`).Scan(&events); err != nil || events != 1 {
+		t.Errorf("%d live events, %v; want the 1 from before", events, err)
+	}
+}
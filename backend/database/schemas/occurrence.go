@@ -1,22 +1,47 @@
 package schemas
 
-import "time"
-
-type OccurrenceType int
-
-const (
-	OccurrenceNormal OccurrenceType = iota
-	OccurrenceMoved
-	OccurrenceCancelled
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
 )
 
+// Occurrence is one materialized instance of a recurring or one-off event.
 type Occurrence struct {
-	EventID      string         `json:"eventId"`
-	StartTime    time.Time      `json:"startTime"`
-	EndTime      *time.Time     `json:"endTime,omitempty"`
-	Kind         OccurrenceType `json:"moved"`
-	NewStartTime *time.Time     `json:"newStartTime,omitempty"`
-	NewEndTime   *time.Time     `json:"newEndTime,omitempty"`
+	EventID string `json:"eventId"`
+	// Original start of the instance; identifies it in exceptions
+	RecurrenceID time.Time  `json:"recurrenceId"`
+	StartTime    time.Time  `json:"startTime"`
+	EndTime      *time.Time `json:"endTime,omitempty"`
+	Moved        bool       `json:"moved,omitempty"`
+}
+
+// EventOccurrence is a cached occurrence read back with its event.
+type EventOccurrence struct {
+	Event      Event
+	Occurrence Occurrence
+}
+
+// CreateOccurrenceStateSchema records which window each event's occurrences
+// were expanded for. An event without a row, or with a different window, has
+// to be expanded again; deleting the row drops its cached occurrences too.
+func CreateOccurrenceStateSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "eventID",
+			Type:       ColumnUUID,
+			PrimaryKey: true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "events", ColumnName: "eventID", OnDelete: FKCascade}}},
+		Column{Name: "fromTime",
+			Type: ColumnTimestamp},
+		Column{Name: "throughTime",
+			Type: ColumnTimestamp},
+	)
+
+	schema := Schema{Name: "occurrence_cache_state", Columns: cols}
+	return schema
 }
 
 func CreateOccurrenceSchema() Schema {
@@ -25,23 +50,185 @@ func CreateOccurrenceSchema() Schema {
 		Column{Name: "eventID",
 			Type:       ColumnUUID,
 			PrimaryKey: true,
-			ForeignKey: []ForeignKeyMatch{{TargetSchema: "Events", ColumnName: "eventID", OnDelete: FKCascade}}},
-		Column{Name: "startTime",
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "occurrence_cache_state", ColumnName: "eventID", OnDelete: FKCascade}}},
+		Column{Name: "recurrenceID",
+			Type:       ColumnTimestamp,
+			PrimaryKey: true},
+		// Not startTime/endTime: reads join events, which already has those
+		Column{Name: "occurrenceStart",
 			Type: ColumnTimestamp},
-		Column{Name: "endTime",
+		Column{Name: "occurrenceEnd",
 			Type:     ColumnTimestamp,
 			Nullable: true},
 		Column{Name: "moved",
 			Type:           ColumnBool,
-			DefaultSQLExpr: SQLDefault("FALSE")},
-		Column{Name: "oldStartTime",
-			Type:     ColumnTimestamp,
-			Nullable: true},
-		Column{Name: "oldEndTime",
-			Type:     ColumnTimestamp,
-			Nullable: true},
+			DefaultSQLExpr: DefaultFalse()},
 	)
 
-	schema := Schema{Name: "occurrences", Columns: cols}
+	schema := Schema{Name: "occurrence_cache", Columns: cols, Indexes: []Index{
+		{Name: "occurrence_cache_start_idx", Columns: []string{"occurrenceStart"}},
+		{Name: "occurrence_cache_event_start_idx", Columns: []string{"eventID", "occurrenceStart"}},
+	}}
 	return schema
 }
+
+// lockOccurrences serialises invalidating and rebuilding one event's cached
+// occurrences until the surrounding transaction ends.
+func lockOccurrences(ctx context.Context, q queryer, eventID string) error {
+	if _, err := q.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('occurrences'), hashtext($1::text))`, eventID); err != nil {
+		return fmt.Errorf("lock occurrences: %w", err)
+	}
+	return nil
+}
+
+// invalidateOccurrences drops an event's cached occurrences. Every write to
+// an event's timing or exceptions calls it after the write, in the same
+// transaction where there is one.
+func invalidateOccurrences(ctx context.Context, q queryer, eventID string) error {
+	if err := lockOccurrences(ctx, q, eventID); err != nil {
+		return err
+	}
+	if _, err := q.ExecContext(ctx, `DELETE FROM occurrence_cache_state WHERE eventID = $1`, eventID); err != nil {
+		return fmt.Errorf("invalidate occurrences: %w", err)
+	}
+	return nil
+}
+
+// ListStaleOccurrenceEvents returns the IDs of events whose occurrences
+// aren't cached for [from, through).
+func ListStaleOccurrenceEvents(ctx context.Context, db *sql.DB, from, through time.Time) ([]string, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT eventID FROM events
+		WHERE deletedAt IS NULL AND NOT EXISTS (
+			SELECT 1 FROM occurrence_cache_state s
+			WHERE s.eventID = events.eventID AND s.fromTime = $1 AND s.throughTime = $2
+		)
+		ORDER BY eventID;
+	`, from, through)
+	if err != nil {
+		return nil, fmt.Errorf("list stale occurrences query: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("list stale occurrences scan: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list stale occurrences rows: %w", err)
+	}
+
+	return ids, nil
+}
+
+// MaterializeOccurrences caches the occurrences expand returns for an event
+// over [from, through). The event and its exceptions are read under the same
+// lock writers take, so a concurrent edit is never overwritten by older data.
+// Events deleted in the meantime are skipped.
+func MaterializeOccurrences(
+	ctx context.Context,
+	db *sql.DB,
+	eventID string,
+	from, through time.Time,
+	expand func(Event, []Exception) []Occurrence,
+) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	return WithTx(ctx, db, func(tx *sql.Tx) error {
+		if err := lockOccurrences(ctx, tx, eventID); err != nil {
+			return err
+		}
+
+		var e Event
+		row := tx.QueryRowContext(ctx, `
+			SELECT `+eventColumns+`
+			FROM events
+			WHERE eventID = $1
+		`, eventID)
+		if err := scanEvent(row, &e); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return fmt.Errorf("materialize occurrences event: %w", err)
+		}
+		exs, err := eventExceptions(ctx, tx, eventID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM occurrence_cache_state WHERE eventID = $1`, eventID); err != nil {
+			return fmt.Errorf("clear occurrences: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO occurrence_cache_state (eventID, fromTime, throughTime)
+			VALUES ($1, $2, $3);
+		`, eventID, from, through); err != nil {
+			return fmt.Errorf("insert occurrence state: %w", err)
+		}
+		for _, occ := range expand(e, exs) {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO occurrence_cache (eventID, recurrenceID, occurrenceStart, occurrenceEnd, moved)
+				VALUES ($1, $2, $3, $4, $5);
+			`, eventID, occ.RecurrenceID, occ.StartTime, occ.EndTime, occ.Moved); err != nil {
+				return fmt.Errorf("insert occurrence: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ListCachedOccurrences returns the cached occurrences of events matching
+// filter that overlap [from, to), in start order. Open-ended occurrences
+// count as instants. Only events already materialized are included.
+func ListCachedOccurrences(ctx context.Context, db *sql.DB, filter EventFilter, from, to time.Time) ([]EventOccurrence, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	cond, args := filter.where([]any{from, to})
+	// Only the cache columns needed are joined, so its createdAt and
+	// updatedAt don't clash with the event's
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+eventColumns+`, recurrenceID, occurrenceStart, occurrenceEnd, moved
+		FROM events JOIN (
+			SELECT eventID, recurrenceID, occurrenceStart, occurrenceEnd, moved FROM occurrence_cache
+		) AS occurrence_cache USING (eventID)
+		WHERE occurrenceStart < $2
+			AND CASE WHEN occurrenceEnd > occurrenceStart THEN occurrenceEnd > $1
+				ELSE occurrenceStart >= $1 END
+			AND `+cond+`
+		ORDER BY occurrenceStart, eventID;
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list cached occurrences query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]EventOccurrence, 0)
+	for rows.Next() {
+		var eo EventOccurrence
+		o := &eo.Occurrence
+		if err := scanEvent(rows, &eo.Event, &o.RecurrenceID, &o.StartTime, &o.EndTime, &o.Moved); err != nil {
+			return nil, fmt.Errorf("list cached occurrences scan: %w", err)
+		}
+		o.EventID = eo.Event.EventID
+		out = append(out, eo)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list cached occurrences rows: %w", err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,175 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Pause skips a series' occurrences that would start in [From, Until), such
+// as lessons over the summer holidays, without an exception for each.
+// Occurrences moved by an exception still happen.
+type Pause struct {
+	PauseID   string    `json:"pauseId"`
+	EventID   string    `json:"eventId"`
+	From      time.Time `json:"from"`
+	Until     time.Time `json:"until"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Covers reports whether an occurrence starting at t is paused.
+func (p Pause) Covers(t time.Time) bool {
+	return !t.Before(p.From) && t.Before(p.Until)
+}
+
+// Pauses is an event's pauses, in start order.
+type Pauses []Pause
+
+// Scan reads the JSON array built by eventPausesColumn.
+func (p *Pauses) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, p)
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	case nil:
+		*p = Pauses{}
+		return nil
+	}
+	return fmt.Errorf("pauses: unexpected %T", src)
+}
+
+// eventPausesColumn reads an event's Pauses as JSON.
+const eventPausesColumn = `(
+		SELECT COALESCE(json_agg(json_build_object(
+			'pauseId', pauseID, 'eventId', eventID, 'from', fromTime, 'until', untilTime,
+			'createdAt', createdAt, 'updatedAt', updatedAt) ORDER BY fromTime, pauseID), '[]')
+		FROM pauses WHERE pauses.eventID = events.eventID)`
+
+const pauseColumns = `pauseID, eventID, fromTime, untilTime, createdAt, updatedAt`
+
+func scanPause(row rowScanner, p *Pause) error {
+	return row.Scan(
+		&p.PauseID,
+		&p.EventID,
+		&p.From,
+		&p.Until,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+}
+
+func CreatePauseSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "pauseID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		Column{Name: "eventID",
+			Type:       ColumnUUID,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "events", ColumnName: "eventID", OnDelete: FKCascade}}},
+		// Not from/until: both are reserved words
+		Column{Name: "fromTime",
+			Type: ColumnTimestamp},
+		Column{Name: "untilTime",
+			Type: ColumnTimestamp},
+	)
+
+	schema := Schema{Name: "pauses", Columns: cols}
+	return schema
+}
+
+// CreatePause pauses a series between in.From and in.Until. Pauses may
+// overlap; an occurrence is skipped if any of them covers it.
+func CreatePause(ctx context.Context, db *sql.DB, in Pause) (Pause, error) {
+	if db == nil {
+		return Pause{}, fmt.Errorf("db is nil")
+	}
+	if !in.Until.After(in.From) {
+		return Pause{}, fmt.Errorf("until must be after from")
+	}
+
+	var out Pause
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, `
+			INSERT INTO pauses (eventID, fromTime, untilTime)
+			VALUES ($1, $2, $3)
+			RETURNING `+pauseColumns+`;
+		`, in.EventID, in.From, in.Until)
+		if err := scanPause(row, &out); err != nil {
+			return fmt.Errorf("insert pause: %w", err)
+		}
+		if err := invalidateOccurrences(ctx, tx, in.EventID); err != nil {
+			return err
+		}
+		return logEventChange(ctx, tx, in.EventID, false)
+	})
+	if err != nil {
+		return Pause{}, err
+	}
+	return out, nil
+}
+
+// DeletePause resumes the occurrences one pause skipped, returning
+// sql.ErrNoRows when the event has no such pause.
+func DeletePause(ctx context.Context, db *sql.DB, eventID, pauseID string) (Pause, error) {
+	var out Pause
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, `
+			DELETE FROM pauses WHERE eventID = $1 AND pauseID = $2
+			RETURNING `+pauseColumns+`;
+		`, eventID, pauseID)
+		if err := scanPause(row, &out); err != nil {
+			return fmt.Errorf("delete pause: %w", err)
+		}
+		if err := invalidateOccurrences(ctx, tx, eventID); err != nil {
+			return err
+		}
+		return logEventChange(ctx, tx, eventID, false)
+	})
+	if err != nil {
+		return Pause{}, err
+	}
+	return out, nil
+}
+
+// ListEventPauses returns an event's pauses in start order.
+func ListEventPauses(ctx context.Context, db *sql.DB, eventID string) ([]Pause, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	return eventPauses(ctx, db, eventID)
+}
+
+func eventPauses(ctx context.Context, q queryer, eventID string) ([]Pause, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+pauseColumns+`
+		FROM pauses
+		WHERE eventID = $1
+		ORDER BY fromTime, pauseID;
+	`, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("list pauses query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]Pause, 0)
+	for rows.Next() {
+		var p Pause
+		if err := scanPause(rows, &p); err != nil {
+			return nil, fmt.Errorf("list pauses scan: %w", err)
+		}
+		out = append(out, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list pauses rows: %w", err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,289 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrPersonExists is returned when another person already has the display name.
+	ErrPersonExists = errors.New("a person with that name already exists")
+	// ErrPersonInUse is returned when deleting a person that events, feeds or
+	// sync accounts still point at.
+	ErrPersonInUse = errors.New("person still has events, feeds or sync accounts")
+)
+
+type Person struct {
+	PersonID    string `json:"personId"`
+	DisplayName string `json:"displayName"`
+	// CSS hex colour, e.g. "#3b82f6"
+	Color     *string   `json:"color,omitempty"`
+	SortOrder int       `json:"sortOrder"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+const personColumns = `personID, displayName, color, sortOrder, createdAt, updatedAt`
+
+// personNameColumn reads the display name for a row of table that has a personID column.
+func personNameColumn(table string) string {
+	return `(SELECT displayName FROM people WHERE people.personID = ` + table + `.personID)`
+}
+
+func scanPerson(row rowScanner, p *Person) error {
+	return row.Scan(
+		&p.PersonID,
+		&p.DisplayName,
+		&p.Color,
+		&p.SortOrder,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+}
+
+func CreatePeopleSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "personID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		Column{Name: "displayName",
+			Type: ColumnString},
+		Column{Name: "color",
+			Type:     ColumnString,
+			Nullable: true},
+		Column{Name: "sortOrder",
+			Type:           ColumnInt,
+			DefaultSQLExpr: SQLDefault("0")},
+	)
+
+	schema := Schema{Name: "people", Columns: cols}
+	return schema
+}
+
+func validatePerson(in Person) error {
+	if in.DisplayName == "" {
+		return fmt.Errorf("displayName is required")
+	}
+	if in.Color != nil && !isHexColor(*in.Color) {
+		return fmt.Errorf("color must be a hex colour like #3b82f6")
+	}
+	return nil
+}
+
+func isHexColor(s string) bool {
+	if len(s) != 4 && len(s) != 7 || s[0] != '#' {
+		return false
+	}
+	for _, c := range s[1:] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// nameTaken reports whether a person other than exceptID is called name, ignoring case.
+func nameTaken(ctx context.Context, q queryer, name, exceptID string) (bool, error) {
+	var taken bool
+	err := q.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM people
+			WHERE lower(displayName) = lower($1) AND personID::text <> $2
+		);
+	`, name, exceptID).Scan(&taken)
+	if err != nil {
+		return false, fmt.Errorf("person name query: %w", err)
+	}
+	return taken, nil
+}
+
+func CreatePerson(ctx context.Context, db *sql.DB, in Person) (Person, error) {
+	if db == nil {
+		return Person{}, fmt.Errorf("db is nil")
+	}
+
+	in.DisplayName = strings.TrimSpace(in.DisplayName)
+	if err := validatePerson(in); err != nil {
+		return Person{}, err
+	}
+	taken, err := nameTaken(ctx, db, in.DisplayName, "")
+	if err != nil {
+		return Person{}, err
+	}
+	if taken {
+		return Person{}, ErrPersonExists
+	}
+
+	row := db.QueryRowContext(ctx, `
+		INSERT INTO people (displayName, color, sortOrder)
+		VALUES ($1, $2, $3)
+		RETURNING `+personColumns+`;
+	`, in.DisplayName, in.Color, in.SortOrder)
+
+	var out Person
+	if err := scanPerson(row, &out); err != nil {
+		return Person{}, fmt.Errorf("insert person: %w", err)
+	}
+
+	return out, nil
+}
+
+func UpdatePerson(ctx context.Context, db *sql.DB, id string, in Person) (Person, error) {
+	if db == nil {
+		return Person{}, fmt.Errorf("db is nil")
+	}
+
+	in.DisplayName = strings.TrimSpace(in.DisplayName)
+	if err := validatePerson(in); err != nil {
+		return Person{}, err
+	}
+	taken, err := nameTaken(ctx, db, in.DisplayName, id)
+	if err != nil {
+		return Person{}, err
+	}
+	if taken {
+		return Person{}, ErrPersonExists
+	}
+
+	row := db.QueryRowContext(ctx, `
+		UPDATE people SET displayName = $2, color = $3, sortOrder = $4
+		WHERE personID = $1
+		RETURNING `+personColumns+`;
+	`, id, in.DisplayName, in.Color, in.SortOrder)
+
+	var out Person
+	if err := scanPerson(row, &out); err != nil {
+		return Person{}, fmt.Errorf("update person: %w", err)
+	}
+	// Events carry their person's name
+	if err := logEventChanges(ctx, db, false, `personID = $1`, id); err != nil {
+		return Person{}, err
+	}
+
+	return out, nil
+}
+
+func GetPerson(ctx context.Context, db *sql.DB, id string) (*Person, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		SELECT `+personColumns+`
+		FROM people
+		WHERE personID = $1
+	`, id)
+
+	var p Person
+	if err := scanPerson(row, &p); err != nil {
+		return nil, fmt.Errorf("get person scan: %w", err)
+	}
+
+	return &p, nil
+}
+
+// ListPeople returns everyone in display order.
+func ListPeople(ctx context.Context, db *sql.DB) ([]Person, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+personColumns+`
+		FROM people
+		ORDER BY sortOrder, displayName, personID;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list people query: %w", err)
+	}
+	defer rows.Close()
+
+	people := make([]Person, 0)
+	for rows.Next() {
+		var p Person
+		if err := scanPerson(rows, &p); err != nil {
+			return nil, fmt.Errorf("list people scan: %w", err)
+		}
+		people = append(people, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list people rows: %w", err)
+	}
+
+	return people, nil
+}
+
+// DeletePerson removes a person nothing refers to any more.
+func DeletePerson(ctx context.Context, db *sql.DB, id string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM people
+		WHERE personID = $1
+			AND NOT EXISTS (SELECT 1 FROM events WHERE personID = $1)
+			AND NOT EXISTS (SELECT 1 FROM feeds WHERE personID = $1)
+			AND NOT EXISTS (SELECT 1 FROM sync_state WHERE personID = $1)`, id)
+	if err != nil {
+		return fmt.Errorf("Failed to execute person delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		if _, err := GetPerson(ctx, db, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return sql.ErrNoRows
+			}
+			return err
+		}
+		return ErrPersonInUse
+	}
+
+	return nil
+}
+
+// resolvePerson fills in *id from name when only a name was given, so
+// callers that only know a display name (imports, feeds) still link to an
+// existing person. Unknown names are an error rather than a new person.
+func resolvePerson(ctx context.Context, q queryer, id *string, name string) error {
+	if *id != "" {
+		return nil
+	}
+
+	err := q.QueryRowContext(ctx, `
+		SELECT personID FROM people
+		WHERE lower(displayName) = lower($1)
+		ORDER BY sortOrder, personID
+		LIMIT 1;
+	`, strings.TrimSpace(name)).Scan(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("unknown person %q", name)
+	}
+	if err != nil {
+		return fmt.Errorf("resolve person query: %w", err)
+	}
+	return nil
+}
+
+// ResolvePersonID returns id, or the ID of the person called name when id is empty.
+func ResolvePersonID(ctx context.Context, db *sql.DB, id, name string) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("db is nil")
+	}
+	if err := resolvePerson(ctx, db, &id, name); err != nil {
+		return "", err
+	}
+	return id, nil
+}
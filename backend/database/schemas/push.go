@@ -0,0 +1,186 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// PushService is the kind of push server a person's notifications go to.
+type PushService string
+
+const (
+	PushNtfy   PushService = "ntfy"
+	PushGotify PushService = "gotify"
+)
+
+// PushSettings is where one person's notifications are pushed.
+type PushSettings struct {
+	PersonID string      `json:"personId"`
+	Service  PushService `json:"service"`
+	// Base URL of the ntfy or Gotify server, e.g. "https://ntfy.sh"
+	ServerURL string `json:"serverUrl"`
+	// ntfy topic; unused by Gotify
+	Topic *string `json:"topic,omitempty"`
+	// Gotify application token, or ntfy access token for a protected topic.
+	// Write-only: responses give HasToken instead
+	Token    *string `json:"token,omitempty"`
+	HasToken bool    `json:"hasToken"`
+	// Also push a notice when one of the person's events is changed or deleted
+	EventChanges bool      `json:"eventChanges"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+const pushColumns = `personID, service, serverURL, topic, token, eventChanges, createdAt, updatedAt`
+
+func scanPushSettings(row rowScanner, p *PushSettings) error {
+	if err := row.Scan(
+		&p.PersonID,
+		&p.Service,
+		&p.ServerURL,
+		&p.Topic,
+		&p.Token,
+		&p.EventChanges,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	); err != nil {
+		return err
+	}
+	p.HasToken = p.Token != nil
+	return nil
+}
+
+func CreatePushSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "personID",
+			Type:       ColumnUUID,
+			PrimaryKey: true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "people", ColumnName: "personID", OnDelete: FKCascade}}},
+		Column{Name: "service",
+			Type: ColumnString},
+		Column{Name: "serverURL",
+			Type: ColumnString},
+		Column{Name: "topic",
+			Type:     ColumnString,
+			Nullable: true},
+		Column{Name: "token",
+			Type:     ColumnString,
+			Nullable: true},
+		Column{Name: "eventChanges",
+			Type:           ColumnBool,
+			DefaultSQLExpr: SQLDefault("false")},
+	)
+
+	schema := Schema{Name: "push_settings", Columns: cols}
+	return schema
+}
+
+func validatePushSettings(in PushSettings) error {
+	switch in.Service {
+	case PushNtfy:
+		if in.Topic == nil || strings.TrimSpace(*in.Topic) == "" {
+			return fmt.Errorf("topic is required for ntfy")
+		}
+	case PushGotify:
+		if in.Token == nil || *in.Token == "" {
+			return fmt.Errorf("token is required for gotify")
+		}
+	default:
+		return fmt.Errorf("service must be %q or %q", PushNtfy, PushGotify)
+	}
+
+	u, err := url.Parse(in.ServerURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("serverUrl must be an http or https URL")
+	}
+	return nil
+}
+
+// SetPushSettings saves where a person's notifications go, replacing what was
+// there. A nil token keeps the stored one, so a client can change the other
+// settings without having to know it.
+func SetPushSettings(ctx context.Context, db *sql.DB, in PushSettings) (PushSettings, error) {
+	if db == nil {
+		return PushSettings{}, fmt.Errorf("db is nil")
+	}
+
+	if in.Token == nil {
+		existing, err := GetPushSettings(ctx, db, in.PersonID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return PushSettings{}, err
+		}
+		if existing != nil && existing.Service == in.Service {
+			in.Token = existing.Token
+		}
+	}
+	if in.Token != nil && *in.Token == "" {
+		in.Token = nil
+	}
+	if err := validatePushSettings(in); err != nil {
+		return PushSettings{}, err
+	}
+
+	row := db.QueryRowContext(ctx, `
+		INSERT INTO push_settings (personID, service, serverURL, topic, token, eventChanges)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (personID) DO UPDATE
+		SET service = EXCLUDED.service, serverURL = EXCLUDED.serverURL, topic = EXCLUDED.topic,
+			token = EXCLUDED.token, eventChanges = EXCLUDED.eventChanges
+		RETURNING `+pushColumns+`;
+	`, in.PersonID, in.Service, in.ServerURL, in.Topic, in.Token, in.EventChanges)
+
+	var out PushSettings
+	if err := scanPushSettings(row, &out); err != nil {
+		return PushSettings{}, fmt.Errorf("set push settings: %w", err)
+	}
+
+	return out, nil
+}
+
+func GetPushSettings(ctx context.Context, db *sql.DB, personID string) (*PushSettings, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		SELECT `+pushColumns+`
+		FROM push_settings
+		WHERE personID = $1
+	`, personID)
+
+	var p PushSettings
+	if err := scanPushSettings(row, &p); err != nil {
+		return nil, fmt.Errorf("get push settings scan: %w", err)
+	}
+
+	return &p, nil
+}
+
+func DeletePushSettings(ctx context.Context, db *sql.DB, personID string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM push_settings WHERE personID = $1`, personID)
+	if err != nil {
+		return fmt.Errorf("Failed to execute push settings delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
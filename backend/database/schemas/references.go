@@ -0,0 +1,227 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// checkReferences makes sure tables can be created in the order given: each
+// table is named once, in lower case, since Postgres folds the unquoted
+// names the SQL uses, and each foreign key points at a table created before
+// it (or itself), at a column there that is unique on its own and of the
+// same type. It needs no database, so a mistake in a schema stops a server
+// before it touches one.
+func checkReferences(tables []Schema) error {
+	created := make(map[string]Schema, len(tables))
+	for _, schema := range tables {
+		if schema.Name != strings.ToLower(schema.Name) {
+			return fmt.Errorf("table %q: names must be lower case", schema.Name)
+		}
+		if _, ok := created[schema.Name]; ok {
+			return fmt.Errorf("table %q is declared twice", schema.Name)
+		}
+		created[schema.Name] = schema
+
+		for _, col := range schema.Columns {
+			for _, fk := range col.ForeignKey {
+				where := schema.Name + "." + col.Name
+				target, ok := created[fk.TargetSchema]
+				if !ok {
+					if slices.ContainsFunc(tables, func(s Schema) bool { return s.Name == fk.TargetSchema }) {
+						return fmt.Errorf("%s references %q, which is created after it", where, fk.TargetSchema)
+					}
+					if _, ok := created[strings.ToLower(fk.TargetSchema)]; ok {
+						return fmt.Errorf("%s references %q; table names are lower case", where, fk.TargetSchema)
+					}
+					return fmt.Errorf("%s references unknown table %q", where, fk.TargetSchema)
+				}
+				i := slices.IndexFunc(target.Columns, func(c Column) bool { return strings.EqualFold(c.Name, fk.ColumnName) })
+				if i < 0 {
+					return fmt.Errorf("%s references %s.%s, which doesn't exist", where, fk.TargetSchema, fk.ColumnName)
+				}
+				if !uniqueOnItsOwn(target, target.Columns[i]) {
+					return fmt.Errorf("%s references %s.%s, which isn't the primary key or unique on its own", where, fk.TargetSchema, fk.ColumnName)
+				}
+				if catalogType(col.Type) != catalogType(target.Columns[i].Type) {
+					return fmt.Errorf("%s is %s but references %s.%s, which is %s", where,
+						catalogType(col.Type), fk.TargetSchema, fk.ColumnName, catalogType(target.Columns[i].Type))
+				}
+				if fk.OnDelete == FKSetNull && !col.Nullable {
+					return fmt.Errorf("%s is set to null when %s goes, so it has to be nullable", where, fk.TargetSchema)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// declaredTables is every table's schema, in tableSchemas' order.
+func declaredTables() []Schema {
+	out := make([]Schema, 0, len(tableSchemas))
+	for _, create := range tableSchemas {
+		out = append(out, create())
+	}
+	return out
+}
+
+// uniqueOnItsOwn reports whether no two rows of schema can share a value of
+// col, which Postgres requires of a column a foreign key references.
+func uniqueOnItsOwn(schema Schema, col Column) bool {
+	if col.Unique {
+		return true
+	}
+	if col.PrimaryKey {
+		pks := 0
+		for _, c := range schema.Columns {
+			if c.PrimaryKey {
+				pks++
+			}
+		}
+		return pks == 1
+	}
+	only := func(cols []string) bool { return len(cols) == 1 && strings.EqualFold(cols[0], col.Name) }
+	for _, u := range schema.Uniques {
+		if only(u.Columns) {
+			return true
+		}
+	}
+	for _, idx := range schema.Indexes {
+		if idx.Unique && idx.Where == "" && only(idx.Columns) {
+			return true
+		}
+	}
+	return false
+}
+
+// bootstrapCheckSchema is where VerifyBootstrap builds its tables.
+const bootstrapCheckSchema = "pical_bootstrap_check"
+
+// VerifyBootstrap builds every table from nothing, as a fresh install does,
+// and checks the result: that no table would need altering straight after
+// being created, and that Postgres has exactly the foreign keys the schemas
+// declare. It works in a scratch Postgres schema inside a transaction that
+// is always rolled back, so the database is left as it was; the user needs
+// to be allowed to create schemas. It returns how many tables and foreign
+// keys it checked.
+func VerifyBootstrap(ctx context.Context, db *sql.DB) (int, int, error) {
+	if db == nil {
+		return 0, 0, fmt.Errorf("db is nil")
+	}
+	tables := declaredTables()
+	if err := checkReferences(tables); err != nil {
+		return 0, 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("begin bootstrap check: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Nothing else on the search path, so the live tables can't stand in for
+	// ones the check hasn't created yet
+	if _, err := tx.ExecContext(ctx, `CREATE SCHEMA `+bootstrapCheckSchema+`; SET LOCAL search_path TO `+bootstrapCheckSchema); err != nil {
+		return 0, 0, fmt.Errorf("bootstrap check schema: %w", err)
+	}
+	for _, m := range migrations {
+		if err := m.Up(ctx, tx); err != nil {
+			return 0, 0, fmt.Errorf("bootstrap check: migration %d %s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	fks, err := checkLiveSchema(ctx, tx, bootstrapCheckSchema, tables)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bootstrap check: %w", err)
+	}
+	return len(tables), fks, nil
+}
+
+// checkLiveSchema checks the tables on the search path, in the Postgres
+// schema namespace, against tables: that none would need altering to match
+// its declaration, and that the foreign keys are exactly the declared ones,
+// so none is missing or points somewhere no schema says. It returns how
+// many foreign keys it checked.
+func checkLiveSchema(ctx context.Context, q queryer, namespace string, tables []Schema) (int, error) {
+	declared := make(map[string]string)
+	for _, schema := range tables {
+		live, err := liveColumns(ctx, q, schema.Name)
+		if err != nil {
+			return 0, err
+		}
+		if live == nil {
+			return 0, fmt.Errorf("%s wasn't created", schema.Name)
+		}
+		stmts, err := alterStatements(withTimestamps(schema), live)
+		if err != nil {
+			return 0, err
+		}
+		if len(stmts) > 0 {
+			return 0, fmt.Errorf("%s doesn't come out as declared; syncing would run %s", schema.Name, strings.Join(stmts, " "))
+		}
+		for _, col := range schema.Columns {
+			for _, fk := range col.ForeignKey {
+				key := strings.ToLower(schema.Name + "." + col.Name + " -> " + fk.TargetSchema + "." + fk.ColumnName)
+				declared[key] = onDeleteCode(fk.OnDelete)
+			}
+		}
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT c.conrelid::regclass::text || '.' || a.attname || ' -> ' || c.confrelid::regclass::text || '.' || fa.attname, c.confdeltype
+		FROM pg_constraint c
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = c.conkey[1]
+		JOIN pg_attribute fa ON fa.attrelid = c.confrelid AND fa.attnum = c.confkey[1]
+		WHERE c.contype = 'f' AND c.connamespace = $1::regnamespace;
+	`, namespace)
+	if err != nil {
+		return 0, fmt.Errorf("live foreign keys query: %w", err)
+	}
+	defer rows.Close()
+
+	found := make(map[string]string)
+	for rows.Next() {
+		var key, onDelete string
+		if err := rows.Scan(&key, &onDelete); err != nil {
+			return 0, fmt.Errorf("live foreign keys scan: %w", err)
+		}
+		found[key] = onDelete
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("live foreign keys rows: %w", err)
+	}
+
+	for key, onDelete := range declared {
+		got, ok := found[key]
+		switch {
+		case !ok:
+			return 0, fmt.Errorf("foreign key %s is missing", key)
+		case got != onDelete:
+			return 0, fmt.Errorf("foreign key %s has ON DELETE %q, not %q", key, got, onDelete)
+		}
+	}
+	for key := range found {
+		if _, ok := declared[key]; !ok {
+			return 0, fmt.Errorf("foreign key %s isn't declared by any schema", key)
+		}
+	}
+
+	return len(declared), nil
+}
+
+// onDeleteCode is how pg_constraint.confdeltype records an ON DELETE action.
+func onDeleteCode(a ForeignKeyAction) string {
+	switch a {
+	case FKRestrict:
+		return "r"
+	case FKSetNull:
+		return "n"
+	case FKSetDefault:
+		return "d"
+	case FKCascade:
+		return "c"
+	}
+	return "a"
+}
@@ -0,0 +1,472 @@
+package schemas
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// ErrReminderExists is returned when the event already has a reminder at that
+// offset on that channel, of the same kind.
+var ErrReminderExists = errors.New("event already has this reminder")
+
+// ReminderChannel is how a reminder is delivered.
+type ReminderChannel string
+
+const (
+	// Posted to the admin alert URL, like the device watchdog's alerts
+	ReminderAlert ReminderChannel = "alert"
+	// Pushed to the event's person through their ntfy or Gotify settings
+	ReminderPush ReminderChannel = "push"
+)
+
+func (c ReminderChannel) valid() bool {
+	switch c {
+	case ReminderAlert, ReminderPush:
+		return true
+	}
+	return false
+}
+
+// MaxReminderOffset is how far ahead of an occurrence a reminder may fire.
+const MaxReminderOffset = 4 * 7 * 24 * time.Hour
+
+// Reminder fires OffsetMinutes before every occurrence of its event.
+type Reminder struct {
+	ReminderID    string          `json:"reminderId"`
+	EventID       string          `json:"eventId"`
+	OffsetMinutes int             `json:"offsetMinutes"`
+	Channel       ReminderChannel `json:"channel"`
+	// Fires OffsetMinutes before it is time to leave for the event's
+	// location instead, once the travel time there is known
+	LeaveBy   bool      `json:"leaveBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Offset is how long before the occurrence starts the reminder fires.
+func (r Reminder) Offset() time.Duration {
+	return time.Duration(r.OffsetMinutes) * time.Minute
+}
+
+const reminderColumns = `reminderID, eventID, offsetMinutes, channel, leaveBy, createdAt, updatedAt`
+
+// ReminderDelivery is a reminder that has fired for one occurrence. Displays
+// show it until it is dismissed, on any of them.
+type ReminderDelivery struct {
+	ReminderID string `json:"reminderId"`
+	EventID    string `json:"eventId"`
+	// Original start of the occurrence, as used by exceptions
+	RecurrenceID  time.Time       `json:"recurrenceId"`
+	OffsetMinutes int             `json:"offsetMinutes"`
+	Channel       ReminderChannel `json:"channel"`
+	LeaveBy       bool            `json:"leaveBy"`
+	FiredAt       time.Time       `json:"firedAt"`
+	DismissedAt   *time.Time      `json:"dismissedAt,omitempty"`
+	// Whatever the dismissing client calls itself, e.g. "kitchen" or "phone"
+	DismissedBy *string   `json:"dismissedBy,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Selected from reminder_deliveries d joined with reminders r
+const reminderDeliveryColumns = `d.reminderID, r.eventID, d.recurrenceID, r.offsetMinutes, r.channel, r.leaveBy, d.firedAt, d.dismissedAt, d.dismissedBy, d.createdAt, d.updatedAt`
+
+func scanReminderDelivery(row rowScanner, d *ReminderDelivery) error {
+	return row.Scan(
+		&d.ReminderID,
+		&d.EventID,
+		&d.RecurrenceID,
+		&d.OffsetMinutes,
+		&d.Channel,
+		&d.LeaveBy,
+		&d.FiredAt,
+		&d.DismissedAt,
+		&d.DismissedBy,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+	)
+}
+
+func scanReminder(row rowScanner, r *Reminder) error {
+	return row.Scan(
+		&r.ReminderID,
+		&r.EventID,
+		&r.OffsetMinutes,
+		&r.Channel,
+		&r.LeaveBy,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+	)
+}
+
+func CreateReminderSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "reminderID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		Column{Name: "eventID",
+			Type:       ColumnUUID,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "events", ColumnName: "eventID", OnDelete: FKCascade}}},
+		Column{Name: "offsetMinutes",
+			Type: ColumnInt},
+		Column{Name: "channel",
+			Type: ColumnString},
+		// Added by migration 6
+		Column{Name: "leaveBy",
+			Type:           ColumnBool,
+			DefaultSQLExpr: SQLDefault("false")},
+		Column{Name: "createdAt",
+			Type:           ColumnTimestamp,
+			DefaultSQLExpr: DefaultNow()},
+	)
+
+	schema := Schema{Name: "reminders", Columns: cols, Indexes: []Index{
+		{Name: "reminders_event_idx", Columns: []string{"eventID", "offsetMinutes", "channel", "leaveBy"}, Unique: true},
+	}}
+	return schema
+}
+
+// CreateReminderDeliverySchema records which occurrences a reminder has
+// already fired for, so a restart or a slow tick never sends it twice.
+func CreateReminderDeliverySchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "reminderID",
+			Type:       ColumnUUID,
+			PrimaryKey: true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "reminders", ColumnName: "reminderID", OnDelete: FKCascade}}},
+		Column{Name: "recurrenceID",
+			Type:       ColumnTimestamp,
+			PrimaryKey: true},
+		Column{Name: "firedAt",
+			Type:           ColumnTimestamp,
+			DefaultSQLExpr: DefaultNow()},
+		// Added by migration 2
+		Column{Name: "dismissedAt",
+			Type:     ColumnTimestamp,
+			Nullable: true},
+		Column{Name: "dismissedBy",
+			Type:     ColumnString,
+			Nullable: true},
+	)
+
+	schema := Schema{Name: "reminder_deliveries", Columns: cols}
+	return schema
+}
+
+func validateReminder(in *Reminder) error {
+	if in.Channel == "" {
+		in.Channel = ReminderAlert
+	}
+	if !in.Channel.valid() {
+		return fmt.Errorf("channel must be %q or %q", ReminderAlert, ReminderPush)
+	}
+	if in.OffsetMinutes < 0 || in.Offset() > MaxReminderOffset {
+		return fmt.Errorf("offsetMinutes must be between 0 and %d", int(MaxReminderOffset/time.Minute))
+	}
+	return nil
+}
+
+// insertReminder adds a reminder to an event being created, skipping it if
+// the event already has the same one.
+func insertReminder(ctx context.Context, q queryer, in Reminder) error {
+	if err := validateReminder(&in); err != nil {
+		return err
+	}
+	if _, err := q.ExecContext(ctx, `
+		INSERT INTO reminders (eventID, offsetMinutes, channel, leaveBy)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING;
+	`, in.EventID, in.OffsetMinutes, in.Channel, in.LeaveBy); err != nil {
+		return fmt.Errorf("insert reminder: %w", err)
+	}
+	return nil
+}
+
+func CreateReminder(ctx context.Context, db *sql.DB, in Reminder) (Reminder, error) {
+	if db == nil {
+		return Reminder{}, fmt.Errorf("db is nil")
+	}
+
+	if err := validateReminder(&in); err != nil {
+		return Reminder{}, err
+	}
+
+	var taken bool
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM reminders
+			WHERE eventID = $1 AND offsetMinutes = $2 AND channel = $3 AND leaveBy = $4
+		);
+	`, in.EventID, in.OffsetMinutes, in.Channel, in.LeaveBy).Scan(&taken)
+	if err != nil {
+		return Reminder{}, fmt.Errorf("reminder exists query: %w", err)
+	}
+	if taken {
+		return Reminder{}, ErrReminderExists
+	}
+
+	row := db.QueryRowContext(ctx, `
+		INSERT INTO reminders (eventID, offsetMinutes, channel, leaveBy)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+reminderColumns+`;
+	`, in.EventID, in.OffsetMinutes, in.Channel, in.LeaveBy)
+
+	var out Reminder
+	if err := scanReminder(row, &out); err != nil {
+		return Reminder{}, fmt.Errorf("insert reminder: %w", err)
+	}
+
+	return out, nil
+}
+
+// AddDefaultReminders gives every event matching filter each of reminders
+// that it doesn't have already, in one transaction. It returns how many
+// events matched and the reminders added, ordered as ListAllReminders is.
+// A dry run returns the same and rolls back.
+func AddDefaultReminders(ctx context.Context, db *sql.DB, filter EventFilter, reminders []Reminder, dryRun bool) (int, []Reminder, error) {
+	if db == nil {
+		return 0, nil, fmt.Errorf("db is nil")
+	}
+	if len(reminders) == 0 {
+		return 0, nil, fmt.Errorf("reminders are required")
+	}
+	for i := range reminders {
+		if err := validateReminder(&reminders[i]); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	var matched int
+	added := make([]Reminder, 0)
+	err := WithTx(ctx, db, func(tx *sql.Tx) error {
+		cond, args := filter.where(nil)
+		if err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM events WHERE `+cond+`;
+		`, args...).Scan(&matched); err != nil {
+			return fmt.Errorf("default reminders count: %w", err)
+		}
+
+		for _, rem := range reminders {
+			cond, args := filter.where([]any{rem.OffsetMinutes, rem.Channel, rem.LeaveBy})
+			rows, err := tx.QueryContext(ctx, `
+				INSERT INTO reminders (eventID, offsetMinutes, channel, leaveBy)
+				SELECT eventID, $1, $2, $3 FROM events WHERE `+cond+`
+				ON CONFLICT DO NOTHING
+				RETURNING `+reminderColumns+`;
+			`, args...)
+			if err != nil {
+				return fmt.Errorf("default reminders insert: %w", err)
+			}
+			for rows.Next() {
+				var r Reminder
+				if err := scanReminder(rows, &r); err != nil {
+					rows.Close()
+					return fmt.Errorf("default reminders scan: %w", err)
+				}
+				added = append(added, r)
+			}
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				return fmt.Errorf("default reminders rows: %w", err)
+			}
+		}
+		leaveBy := func(r Reminder) int {
+			if r.LeaveBy {
+				return 1
+			}
+			return 0
+		}
+		slices.SortFunc(added, func(a, b Reminder) int {
+			return cmp.Or(cmp.Compare(a.EventID, b.EventID), cmp.Compare(b.OffsetMinutes, a.OffsetMinutes), cmp.Compare(a.Channel, b.Channel), cmp.Compare(leaveBy(a), leaveBy(b)))
+		})
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return 0, nil, err
+	}
+
+	return matched, added, nil
+}
+
+// ListEventReminders returns an event's reminders, earliest first.
+func ListEventReminders(ctx context.Context, db *sql.DB, eventID string) ([]Reminder, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	return listReminders(ctx, db, `WHERE eventID = $1`, eventID)
+}
+
+// ListAllReminders returns every reminder, for the scheduler.
+func ListAllReminders(ctx context.Context, db *sql.DB) ([]Reminder, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	return listReminders(ctx, db, ``)
+}
+
+func listReminders(ctx context.Context, db *sql.DB, where string, args ...any) ([]Reminder, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+reminderColumns+`
+		FROM reminders
+		`+where+`
+		ORDER BY eventID, offsetMinutes DESC, channel, leaveBy;
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list reminders query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]Reminder, 0)
+	for rows.Next() {
+		var r Reminder
+		if err := scanReminder(rows, &r); err != nil {
+			return nil, fmt.Errorf("list reminders scan: %w", err)
+		}
+		out = append(out, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list reminders rows: %w", err)
+	}
+
+	return out, nil
+}
+
+func DeleteReminder(ctx context.Context, db *sql.DB, eventID, id string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM reminders WHERE eventID = $1 AND reminderID = $2`, eventID, id)
+	if err != nil {
+		return fmt.Errorf("Failed to execute reminder delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ClaimReminderDelivery marks the reminder as fired for the occurrence with
+// recurrenceID. It reports false when it already had been.
+func ClaimReminderDelivery(ctx context.Context, db *sql.DB, reminderID string, recurrenceID time.Time) (ReminderDelivery, bool, error) {
+	if db == nil {
+		return ReminderDelivery{}, false, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		WITH d AS (
+			INSERT INTO reminder_deliveries (reminderID, recurrenceID)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+			RETURNING *
+		)
+		SELECT `+reminderDeliveryColumns+`
+		FROM d JOIN reminders r ON r.reminderID = d.reminderID;
+	`, reminderID, recurrenceID)
+
+	var out ReminderDelivery
+	if err := scanReminderDelivery(row, &out); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ReminderDelivery{}, false, nil
+		}
+		return ReminderDelivery{}, false, fmt.Errorf("claim reminder delivery: %w", err)
+	}
+	return out, true, nil
+}
+
+// ListActiveReminders returns the deliveries fired after since that nobody
+// has dismissed, oldest first.
+func ListActiveReminders(ctx context.Context, db *sql.DB, since time.Time) ([]ReminderDelivery, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+reminderDeliveryColumns+`
+		FROM reminder_deliveries d JOIN reminders r ON r.reminderID = d.reminderID
+		WHERE d.firedAt > $1 AND d.dismissedAt IS NULL
+		ORDER BY d.firedAt, d.reminderID;
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("list active reminders query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]ReminderDelivery, 0)
+	for rows.Next() {
+		var d ReminderDelivery
+		if err := scanReminderDelivery(rows, &d); err != nil {
+			return nil, fmt.Errorf("list active reminders scan: %w", err)
+		}
+		out = append(out, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list active reminders rows: %w", err)
+	}
+
+	return out, nil
+}
+
+// DismissReminder records that the delivery for the occurrence with
+// recurrenceID has been seen. Dismissing it again keeps the first
+// dismissal. It returns sql.ErrNoRows if the reminder hasn't fired for it.
+func DismissReminder(ctx context.Context, db *sql.DB, reminderID string, recurrenceID time.Time, by *string) (ReminderDelivery, error) {
+	if db == nil {
+		return ReminderDelivery{}, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		UPDATE reminder_deliveries d
+		SET dismissedAt = COALESCE(d.dismissedAt, CURRENT_TIMESTAMP),
+			dismissedBy = CASE WHEN d.dismissedAt IS NULL THEN $3 ELSE d.dismissedBy END
+		FROM reminders r
+		WHERE r.reminderID = d.reminderID AND d.reminderID::text = $1 AND d.recurrenceID = $2
+		RETURNING `+reminderDeliveryColumns+`;
+	`, reminderID, recurrenceID, by)
+
+	var out ReminderDelivery
+	if err := scanReminderDelivery(row, &out); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ReminderDelivery{}, err
+		}
+		return ReminderDelivery{}, fmt.Errorf("dismiss reminder: %w", err)
+	}
+	return out, nil
+}
+
+// PruneReminderDeliveries forgets deliveries fired before cutoff.
+func PruneReminderDeliveries(ctx context.Context, db *sql.DB, cutoff time.Time) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM reminder_deliveries WHERE firedAt < $1`, cutoff); err != nil {
+		return fmt.Errorf("prune reminder deliveries: %w", err)
+	}
+	return nil
+}
@@ -2,8 +2,9 @@ package schemas
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
+	"log"
+	"sort"
 	"strings"
 )
 
@@ -39,6 +40,12 @@ const (
 	ColumnBool
 	ColumnTimestamp
 	ColumnUUID
+	ColumnText // unbounded, for URLs and error messages
+	ColumnTSVector
+	ColumnFloat
+	ColumnTextArray
+	// Numbered from a sequence; DEFAULT takes the next number
+	ColumnBigSerial
 )
 
 type ForeignKeyAction string
@@ -65,11 +72,38 @@ type Column struct {
 	ForeignKey     []ForeignKeyMatch
 	Nullable       bool    // Default no
 	DefaultSQLExpr *string // nil = no default; otherwise literal SQL like "'abc'" or "CURRENT_TIMESTAMP"
+	// Non-nil makes this a stored generated column computed from SQL like "lower(name)"
+	GeneratedSQLExpr *string
+	// Index the column on its own, as <table>_<column>_idx
+	Indexed bool
+	// No two rows may share a value, enforced as <table>_<column>_key
+	Unique bool
+}
+
+// Index is created if no index of that name exists yet, so changing one
+// means giving it a new name.
+type Index struct {
+	Name string
+	// Column names, or expressions
+	Columns []string
+	Method  string // optional, e.g. "GIN"; defaults to btree
+	Unique  bool
+	// Optional condition making it a partial index, e.g. "deletedAt IS NULL"
+	Where string
+}
+
+// UniqueConstraint stops two rows sharing a value for every one of its
+// columns. Like an index, it is added if no constraint of that name exists.
+type UniqueConstraint struct {
+	Name    string
+	Columns []string
 }
 
 type Schema struct {
 	Name    string
 	Columns []Column
+	Indexes []Index
+	Uniques []UniqueConstraint
 }
 
 func columnTypeToString(colType ColumnType) string {
@@ -84,6 +118,16 @@ func columnTypeToString(colType ColumnType) string {
 		return "timestamptz"
 	case ColumnUUID:
 		return "uuid"
+	case ColumnText:
+		return "text"
+	case ColumnTSVector:
+		return "tsvector"
+	case ColumnFloat:
+		return "double precision"
+	case ColumnTextArray:
+		return "text[]"
+	case ColumnBigSerial:
+		return "bigserial"
 	default:
 		// fallback to something safe so schema generation never explodes
 		return "varchar(255)"
@@ -104,9 +148,15 @@ func columnToString(col Column, pkCount int) string {
 	if !col.Nullable {
 		parts = append(parts, "NOT NULL")
 	}
+	if col.Unique {
+		parts = append(parts, "UNIQUE")
+	}
 	if col.DefaultSQLExpr != nil {
 		parts = append(parts, "DEFAULT "+*col.DefaultSQLExpr)
 	}
+	if col.GeneratedSQLExpr != nil {
+		parts = append(parts, "GENERATED ALWAYS AS ("+*col.GeneratedSQLExpr+") STORED")
+	}
 
 	// foreign keys (if you allow multiple, emit multiple REFERENCES clauses)
 	for _, fk := range col.ForeignKey {
@@ -144,22 +194,311 @@ func schemaToCreationString(schema Schema) string {
 	if len(pkCols) > 1 {
 		cols = append(cols, "PRIMARY KEY ("+strings.Join(pkCols, ", ")+")")
 	}
+	for _, u := range schema.Uniques {
+		cols = append(cols, uniqueConstraintString(u))
+	}
 
 	return "CREATE TABLE IF NOT EXISTS " + schema.Name + " (" + strings.Join(cols, ", ") + ");"
 }
 
-func CreateSchema(ctx context.Context, db *sql.DB, schema Schema) error {
-	if db == nil {
+func indexCreationString(schema Schema, idx Index) string {
+	using := ""
+	if idx.Method != "" {
+		using = " USING " + idx.Method
+	}
+	kind := "INDEX"
+	if idx.Unique {
+		kind = "UNIQUE INDEX"
+	}
+	where := ""
+	if idx.Where != "" {
+		where = " WHERE " + idx.Where
+	}
+	return "CREATE " + kind + " IF NOT EXISTS " + idx.Name + " ON " + schema.Name + using + " (" + strings.Join(idx.Columns, ", ") + ")" + where + ";"
+}
+
+func uniqueConstraintString(u UniqueConstraint) string {
+	return "CONSTRAINT " + u.Name + " UNIQUE (" + strings.Join(u.Columns, ", ") + ")"
+}
+
+// schemaUniques is the schema's unique constraints plus those its columns
+// ask for, under the name Postgres gives an inline UNIQUE.
+func schemaUniques(schema Schema) []UniqueConstraint {
+	out := append([]UniqueConstraint{}, schema.Uniques...)
+	for _, col := range schema.Columns {
+		if col.Unique {
+			out = append(out, UniqueConstraint{Name: schema.Name + "_" + strings.ToLower(col.Name) + "_key", Columns: []string{col.Name}})
+		}
+	}
+	return out
+}
+
+// schemaIndexes is the schema's indexes plus those its columns ask for.
+func schemaIndexes(schema Schema) []Index {
+	out := append([]Index{}, schema.Indexes...)
+	for _, col := range schema.Columns {
+		if col.Indexed {
+			out = append(out, Index{Name: schema.Name + "_" + strings.ToLower(col.Name) + "_idx", Columns: []string{col.Name}})
+		}
+	}
+	return out
+}
+
+// withTimestamps adds the createdAt and updatedAt columns every table has,
+// unless the schema declares them itself.
+func withTimestamps(schema Schema) Schema {
+	has := make(map[string]bool, len(schema.Columns))
+	for _, col := range schema.Columns {
+		has[col.Name] = true
+	}
+
+	cols := append([]Column{}, schema.Columns...)
+	for _, name := range []string{"createdAt", "updatedAt"} {
+		if !has[name] {
+			cols = append(cols, Column{Name: name, Type: ColumnTimestamp, DefaultSQLExpr: DefaultNow()})
+		}
+	}
+	schema.Columns = cols
+	return schema
+}
+
+// touchUpdatedAtSQL keeps updatedAt current on any UPDATE that changes the
+// row, so no query has to remember to set it.
+const touchUpdatedAtSQL = `
+	CREATE OR REPLACE FUNCTION touch_updated_at() RETURNS trigger AS $$
+	BEGIN
+		IF NEW IS DISTINCT FROM OLD THEN
+			NEW.updatedAt = CURRENT_TIMESTAMP;
+		END IF;
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;`
+
+// triggerSQL (re)attaches the updatedAt trigger to the table.
+func triggerSQL(schema Schema) string {
+	return "DROP TRIGGER IF EXISTS " + schema.Name + "_touch ON " + schema.Name + ";" +
+		" CREATE TRIGGER " + schema.Name + "_touch BEFORE UPDATE ON " + schema.Name +
+		" FOR EACH ROW EXECUTE FUNCTION touch_updated_at();"
+}
+
+// catalogType is how Postgres reports a column of type t, for comparing a
+// declared column with the live one.
+func catalogType(t ColumnType) string {
+	switch t {
+	case ColumnString:
+		return "character varying(255)"
+	case ColumnTimestamp:
+		return "timestamp with time zone"
+	case ColumnBigSerial:
+		// A bigint with a sequence behind its default
+		return "bigint"
+	}
+	return columnTypeToString(t)
+}
+
+// liveColumn is a column as the database has it.
+type liveColumn struct {
+	dataType   string
+	nullable   bool
+	hasDefault bool
+	generated  bool
+}
+
+// liveColumns returns the table's columns by lowercased name, as unquoted
+// names are folded, or nil if there is no such table.
+func liveColumns(ctx context.Context, q queryer, table string) (map[string]liveColumn, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT a.attname, format_type(a.atttypid, a.atttypmod), NOT a.attnotnull, a.atthasdef, a.attgenerated <> ''
+		FROM pg_attribute a
+		WHERE a.attrelid = to_regclass($1) AND a.attnum > 0 AND NOT a.attisdropped;
+	`, table)
+	if err != nil {
+		return nil, fmt.Errorf("live columns query: %w", err)
+	}
+	defer rows.Close()
+
+	var out map[string]liveColumn
+	for rows.Next() {
+		var name string
+		var c liveColumn
+		if err := rows.Scan(&name, &c.dataType, &c.nullable, &c.hasDefault, &c.generated); err != nil {
+			return nil, fmt.Errorf("live columns scan: %w", err)
+		}
+		if out == nil {
+			out = make(map[string]liveColumn)
+		}
+		out[name] = c
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("live columns rows: %w", err)
+	}
+
+	return out, nil
+}
+
+// liveUniques returns the names of the table's unique constraints.
+func liveUniques(ctx context.Context, q queryer, table string) (map[string]bool, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT conname
+		FROM pg_constraint
+		WHERE conrelid = to_regclass($1) AND contype = 'u';
+	`, table)
+	if err != nil {
+		return nil, fmt.Errorf("live uniques query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("live uniques scan: %w", err)
+		}
+		out[name] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("live uniques rows: %w", err)
+	}
+
+	return out, nil
+}
+
+// alterStatements returns what it takes to bring the live table in line
+// with schema: adding missing columns, and changing the type, nullability
+// or missing default of existing ones. Columns the schema no longer has, a
+// changed default or generated expression, new primary key columns and new
+// NOT NULL columns with nothing to fill existing rows take a migration;
+// leftover columns are logged, and refused if they would fail every insert.
+func alterStatements(schema Schema, live map[string]liveColumn) ([]string, error) {
+	alter := "ALTER TABLE " + schema.Name + " "
+	out := make([]string, 0)
+	declared := make(map[string]bool, len(schema.Columns))
+	for _, col := range schema.Columns {
+		declared[strings.ToLower(col.Name)] = true
+	}
+	dropped := make([]string, 0)
+	for name := range live {
+		if !declared[name] {
+			dropped = append(dropped, name)
+		}
+	}
+	sort.Strings(dropped)
+	for _, name := range dropped {
+		if lc := live[name]; !lc.nullable && !lc.hasDefault && !lc.generated {
+			return nil, fmt.Errorf("%s.%s is NOT NULL but no longer in the schema, so inserts would fail; drop it with a migration", schema.Name, name)
+		}
+		log.Printf("database: %s.%s is no longer in the schema; drop it with a migration", schema.Name, name)
+	}
+
+	for _, col := range schema.Columns {
+		lc, ok := live[strings.ToLower(col.Name)]
+		if !ok {
+			if col.PrimaryKey {
+				return nil, fmt.Errorf("%s.%s is a new primary key column; add it with a migration", schema.Name, col.Name)
+			}
+			if !col.Nullable && col.DefaultSQLExpr == nil && col.GeneratedSQLExpr == nil && col.Type != ColumnBigSerial {
+				return nil, fmt.Errorf("%s.%s is a new NOT NULL column without a default; add it with a migration", schema.Name, col.Name)
+			}
+			out = append(out, alter+"ADD COLUMN "+columnToString(col, 0)+";")
+			continue
+		}
+		if lc.generated || col.GeneratedSQLExpr != nil {
+			continue
+		}
+
+		if want := catalogType(col.Type); lc.dataType != want {
+			typ := columnTypeToString(col.Type)
+			if col.Type == ColumnBigSerial {
+				typ = want
+			}
+			out = append(out, alter+"ALTER COLUMN "+col.Name+" TYPE "+typ+" USING "+col.Name+"::"+typ+";")
+		}
+		if col.DefaultSQLExpr != nil && !lc.hasDefault {
+			out = append(out, alter+"ALTER COLUMN "+col.Name+" SET DEFAULT "+*col.DefaultSQLExpr+";")
+		}
+		if col.PrimaryKey {
+			continue
+		}
+		switch {
+		case col.Nullable && !lc.nullable:
+			out = append(out, alter+"ALTER COLUMN "+col.Name+" DROP NOT NULL;")
+		case !col.Nullable && lc.nullable:
+			if col.DefaultSQLExpr != nil {
+				out = append(out, "UPDATE "+schema.Name+" SET "+col.Name+" = DEFAULT WHERE "+col.Name+" IS NULL;")
+			}
+			out = append(out, alter+"ALTER COLUMN "+col.Name+" SET NOT NULL;")
+		}
+	}
+	return out, nil
+}
+
+// createSchema creates the table and its indexes, or brings an existing one
+// in line with the schema as alterStatements describes and adds any unique
+// constraints it is missing.
+func createSchema(ctx context.Context, q queryer, schema Schema) error {
+	if q == nil {
 		return fmt.Errorf("db is nil")
 	}
 	if schema.Name == "" {
 		return fmt.Errorf("schema name is empty")
 	}
 
-	sqlStr := schemaToCreationString(schema)
+	schema = withTimestamps(schema)
+	live, err := liveColumns(ctx, q, schema.Name)
+	if err != nil {
+		return err
+	}
 
-	if _, err := db.ExecContext(ctx, sqlStr); err != nil {
-		return fmt.Errorf("create schema %q failed: %w\nSQL: %s", schema.Name, err, sqlStr)
+	if live == nil {
+		sqlStr := schemaToCreationString(schema)
+		if _, err := q.ExecContext(ctx, sqlStr); err != nil {
+			return fmt.Errorf("create schema %q failed: %w\nSQL: %s", schema.Name, err, sqlStr)
+		}
+	} else {
+		stmts, err := alterStatements(schema, live)
+		if err != nil {
+			return err
+		}
+		for _, sqlStr := range stmts {
+			if _, err := q.ExecContext(ctx, sqlStr); err != nil {
+				return fmt.Errorf("alter schema %q failed: %w\nSQL: %s", schema.Name, err, sqlStr)
+			}
+			log.Printf("database: %s", sqlStr)
+		}
+
+		// Existing rows may already break a new constraint, in which case
+		// adding it fails and the duplicates have to be sorted out by hand
+		uniques, err := liveUniques(ctx, q, schema.Name)
+		if err != nil {
+			return err
+		}
+		for _, u := range schemaUniques(schema) {
+			if uniques[strings.ToLower(u.Name)] {
+				continue
+			}
+			sqlStr := "ALTER TABLE " + schema.Name + " ADD " + uniqueConstraintString(u) + ";"
+			if _, err := q.ExecContext(ctx, sqlStr); err != nil {
+				return fmt.Errorf("add constraint %q failed: %w\nSQL: %s", u.Name, err, sqlStr)
+			}
+			log.Printf("database: %s", sqlStr)
+		}
+	}
+
+	if _, err := q.ExecContext(ctx, touchUpdatedAtSQL); err != nil {
+		return fmt.Errorf("create touch_updated_at failed: %w", err)
+	}
+	sqlStr := triggerSQL(schema)
+	if _, err := q.ExecContext(ctx, sqlStr); err != nil {
+		return fmt.Errorf("attach touch_updated_at to %q failed: %w\nSQL: %s", schema.Name, err, sqlStr)
+	}
+
+	for _, idx := range schemaIndexes(schema) {
+		sqlStr := indexCreationString(schema, idx)
+		if _, err := q.ExecContext(ctx, sqlStr); err != nil {
+			return fmt.Errorf("create index %q failed: %w\nSQL: %s", idx.Name, err, sqlStr)
+		}
 	}
 
 	return nil
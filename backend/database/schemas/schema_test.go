@@ -0,0 +1,103 @@
+package schemas
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAlterStatements(t *testing.T) {
+	schema := Schema{Name: "things", Columns: []Column{
+		{Name: "thingID", Type: ColumnUUID, PrimaryKey: true},
+		{Name: "name", Type: ColumnString},
+	}}
+	base := map[string]liveColumn{
+		"thingid": {dataType: "uuid"},
+		"name":    {dataType: "character varying(255)"},
+	}
+	with := func(name string, c liveColumn) map[string]liveColumn {
+		live := make(map[string]liveColumn, len(base)+1)
+		for k, v := range base {
+			live[k] = v
+		}
+		live[name] = c
+		return live
+	}
+	withColumn := func(col Column) Schema {
+		s := schema
+		s.Columns = append(append([]Column{}, schema.Columns...), col)
+		return s
+	}
+
+	cases := []struct {
+		name    string
+		schema  Schema
+		live    map[string]liveColumn
+		want    []string
+		wantErr string
+	}{
+		{name: "in line", schema: schema, live: base},
+		{
+			name:   "nullable column",
+			schema: withColumn(Column{Name: "notes", Type: ColumnText, Nullable: true}),
+			live:   base,
+			want:   []string{"ALTER TABLE things ADD COLUMN notes text"},
+		},
+		{
+			name:   "NOT NULL column with a default",
+			schema: withColumn(Column{Name: "rank", Type: ColumnInt, DefaultSQLExpr: SQLDefault("0")}),
+			live:   base,
+			want:   []string{"ALTER TABLE things ADD COLUMN rank integer NOT NULL DEFAULT 0"},
+		},
+		{
+			name:    "NOT NULL column without a default",
+			schema:  withColumn(Column{Name: "ownerID", Type: ColumnUUID}),
+			live:    base,
+			wantErr: "things.ownerID is a new NOT NULL column without a default",
+		},
+		{
+			name:    "new primary key column",
+			schema:  withColumn(Column{Name: "slot", Type: ColumnInt, PrimaryKey: true}),
+			live:    base,
+			wantErr: "things.slot is a new primary key column",
+		},
+		{
+			name:   "leftover nullable column",
+			schema: schema,
+			live:   with("oldnotes", liveColumn{dataType: "text", nullable: true}),
+		},
+		{
+			name:    "leftover NOT NULL column",
+			schema:  schema,
+			live:    with("ownername", liveColumn{dataType: "character varying(255)"}),
+			wantErr: "things.ownername is NOT NULL but no longer in the schema",
+		},
+		{
+			name:   "nullable column made NOT NULL",
+			schema: schema,
+			live:   with("name", liveColumn{dataType: "character varying(255)", nullable: true}),
+			want:   []string{"ALTER TABLE things ALTER COLUMN name SET NOT NULL;"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := alterStatements(tc.schema, tc.live)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("alterStatements: %v", err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+			for i := range got {
+				if !strings.HasPrefix(got[i], tc.want[i]) {
+					t.Errorf("statement %d = %q, want %q", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// searchConfig is the text search configuration for the events.search column
+// and the queries run against it; they must agree for the index to be used.
+const searchConfig = "english"
+
+// ts_headline wraps matched words in these
+const headlineOptions = `StartSel=<mark>, StopSel=</mark>, HighlightAll=TRUE`
+
+type SearchResult struct {
+	Event
+	Rank float64 `json:"rank"`
+	// Title and notes with matches wrapped in <mark>; the rest of the text is
+	// not HTML-escaped
+	TitleHighlight string  `json:"titleHighlight"`
+	NotesHighlight *string `json:"notesHighlight,omitempty"`
+}
+
+// SearchEvents runs a web-style query ("dentist -kids", "\"swim club\"")
+// against event titles and notes, best matches first.
+func SearchEvents(ctx context.Context, db *sql.DB, q string, filter EventFilter, limit int) ([]SearchResult, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	cond, args := filter.where([]any{q, limit})
+	rows, err := db.QueryContext(ctx, `
+		SELECT
+			`+eventColumns+`,
+			ts_rank(search, query) AS rank,
+			ts_headline('`+searchConfig+`', title, query, '`+headlineOptions+`'),
+			CASE WHEN notes IS NULL THEN NULL
+				ELSE ts_headline('`+searchConfig+`', notes, query, '`+headlineOptions+`') END
+		FROM events, websearch_to_tsquery('`+searchConfig+`', $1) AS query
+		WHERE search @@ query AND `+cond+`
+		ORDER BY rank DESC, startTime DESC, eventID
+		LIMIT $2;
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search events query: %w", err)
+	}
+	defer rows.Close()
+
+	results := make([]SearchResult, 0)
+	for rows.Next() {
+		var res SearchResult
+		if err := scanEvent(rows, &res.Event, &res.Rank, &res.TitleHighlight, &res.NotesHighlight); err != nil {
+			return nil, fmt.Errorf("search events scan: %w", err)
+		}
+		results = append(results, res)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("search events rows: %w", err)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,160 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Settings are the household's preferences. There is only ever one row;
+// until it is first saved, the defaults apply.
+type Settings struct {
+	// Day weeks start on, e.g. "monday"
+	WeekStart string `json:"weekStart"`
+	// Days shown and counted as the weekend, e.g. ["friday", "saturday"]
+	WeekendDays []string `json:"weekendDays"`
+	// Zero until the settings are first saved
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// DefaultSettings is what a household has before changing anything.
+func DefaultSettings() Settings {
+	return Settings{WeekStart: "monday", WeekendDays: []string{"saturday", "sunday"}}
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// IsWeekend reports whether d is one of the household's weekend days.
+func (s Settings) IsWeekend(d time.Weekday) bool {
+	for _, name := range s.WeekendDays {
+		if weekdayNames[name] == d {
+			return true
+		}
+	}
+	return false
+}
+
+// FirstWeekday is the day weeks start on.
+func (s Settings) FirstWeekday() time.Weekday {
+	if d, ok := weekdayNames[s.WeekStart]; ok {
+		return d
+	}
+	return time.Monday
+}
+
+// WeekStartOf returns midnight on the first day of the week t falls in, in
+// t's location.
+func (s Settings) WeekStartOf(t time.Time) time.Time {
+	back := (int(t.Weekday()) - int(s.FirstWeekday()) + 7) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-back, 0, 0, 0, 0, t.Location())
+}
+
+func CreateSettingsSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		// Always 1
+		Column{Name: "settingsID",
+			Type:           ColumnInt,
+			PrimaryKey:     true,
+			DefaultSQLExpr: SQLDefault("1")},
+		Column{Name: "weekStart",
+			Type:           ColumnString,
+			DefaultSQLExpr: SQLDefault("'monday'")},
+		Column{Name: "weekendDays",
+			Type:           ColumnTextArray,
+			DefaultSQLExpr: SQLDefault("'{saturday,sunday}'")},
+	)
+
+	schema := Schema{Name: "settings", Columns: cols}
+	return schema
+}
+
+func validateSettings(in *Settings) error {
+	in.WeekStart = strings.ToLower(strings.TrimSpace(in.WeekStart))
+	if _, ok := weekdayNames[in.WeekStart]; !ok {
+		return fmt.Errorf("weekStart must be a day of the week, like \"monday\"")
+	}
+	if in.WeekendDays == nil {
+		in.WeekendDays = []string{}
+	}
+	days := make([]string, 0, len(in.WeekendDays))
+	for _, d := range in.WeekendDays {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if _, ok := weekdayNames[d]; !ok {
+			return fmt.Errorf("weekendDays may only contain days of the week, like \"saturday\"")
+		}
+		if !slices.Contains(days, d) {
+			days = append(days, d)
+		}
+	}
+	// Keep them in week order, so saving the same days always reads back alike
+	slices.SortFunc(days, func(a, b string) int { return int(weekdayNames[a]) - int(weekdayNames[b]) })
+	in.WeekendDays = days
+	return nil
+}
+
+// GetSettings returns the household's settings, or the defaults if they
+// have never been saved.
+func GetSettings(ctx context.Context, db *sql.DB) (Settings, error) {
+	if db == nil {
+		return Settings{}, fmt.Errorf("db is nil")
+	}
+
+	var out Settings
+	err := db.QueryRowContext(ctx, `
+		SELECT weekStart, weekendDays, createdAt, updatedAt
+		FROM settings
+		WHERE settingsID = 1;
+	`).Scan(&out.WeekStart, textArray(&out.WeekendDays), &out.CreatedAt, &out.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DefaultSettings(), nil
+		}
+		return Settings{}, fmt.Errorf("get settings: %w", err)
+	}
+	if out.WeekendDays == nil {
+		out.WeekendDays = []string{}
+	}
+
+	return out, nil
+}
+
+// SetSettings saves the household's settings, replacing what was there.
+func SetSettings(ctx context.Context, db *sql.DB, in Settings) (Settings, error) {
+	if db == nil {
+		return Settings{}, fmt.Errorf("db is nil")
+	}
+	if err := validateSettings(&in); err != nil {
+		return Settings{}, err
+	}
+
+	var out Settings
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO settings (settingsID, weekStart, weekendDays)
+		VALUES (1, $1, $2)
+		ON CONFLICT (settingsID) DO UPDATE
+		SET weekStart = EXCLUDED.weekStart, weekendDays = EXCLUDED.weekendDays
+		RETURNING weekStart, weekendDays, createdAt, updatedAt;
+	`, in.WeekStart, in.WeekendDays).Scan(&out.WeekStart, textArray(&out.WeekendDays), &out.CreatedAt, &out.UpdatedAt)
+	if err != nil {
+		return Settings{}, fmt.Errorf("set settings: %w", err)
+	}
+	if out.WeekendDays == nil {
+		out.WeekendDays = []string{}
+	}
+
+	return out, nil
+}
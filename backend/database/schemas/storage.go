@@ -0,0 +1,55 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// TableStorage is how much room a table takes up.
+type TableStorage struct {
+	Name string `json:"name"`
+	// Postgres' estimate, as of the last vacuum or analyze
+	Rows int64 `json:"rows"`
+	// Including indexes and TOAST
+	Bytes int64 `json:"bytes"`
+}
+
+// ListTableStorage returns the app's tables, largest first, and the size of
+// the whole database in bytes.
+func ListTableStorage(ctx context.Context, db *sql.DB) ([]TableStorage, int64, error) {
+	if db == nil {
+		return nil, 0, fmt.Errorf("db is nil")
+	}
+
+	var total int64
+	if err := db.QueryRowContext(ctx, `SELECT pg_database_size(current_database())`).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("database size query: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT relname, n_live_tup, pg_total_relation_size(relid) AS bytes
+		FROM pg_stat_user_tables
+		WHERE schemaname = current_schema()
+		ORDER BY bytes DESC, relname;
+	`)
+	if err != nil {
+		return nil, 0, fmt.Errorf("table storage query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]TableStorage, 0)
+	for rows.Next() {
+		var t TableStorage
+		if err := rows.Scan(&t.Name, &t.Rows, &t.Bytes); err != nil {
+			return nil, 0, fmt.Errorf("table storage scan: %w", err)
+		}
+		out = append(out, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("table storage rows: %w", err)
+	}
+
+	return out, total, nil
+}
@@ -0,0 +1,154 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// TitleSuggestion is a previously used title with the values it usually came with.
+type TitleSuggestion struct {
+	Title string `json:"title"`
+	Count int    `json:"count"`
+	// Most common person, duration and location among events with this title
+	PersonID        string  `json:"personId"`
+	PersonName      string  `json:"personName"`
+	DurationMinutes *int    `json:"durationMinutes,omitempty"`
+	Location        *string `json:"location,omitempty"`
+	AllDay          bool    `json:"allDay"`
+}
+
+type PersonSuggestion struct {
+	PersonID   string `json:"personId"`
+	PersonName string `json:"personName"`
+	Count      int    `json:"count"`
+}
+
+type DurationSuggestion struct {
+	Minutes int `json:"minutes"`
+	Count   int `json:"count"`
+}
+
+// Suggestions are autocomplete candidates for the quick-add flow.
+type Suggestions struct {
+	Titles    []TitleSuggestion    `json:"titles"`
+	Persons   []PersonSuggestion   `json:"persons"`
+	Durations []DurationSuggestion `json:"durations"`
+}
+
+// likePattern escapes q for use in a LIKE pattern.
+func likePattern(q string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(q)
+}
+
+// SuggestFromHistory ranks titles, people and durations from past events by
+// how often they were used. Titles match q at the start of any word; people
+// match at the start of the name, and those without events yet come last; durations are those of the matching titles,
+// or of all events when q is empty. Feed events are left out since nobody in
+// the household typed them.
+func SuggestFromHistory(ctx context.Context, db *sql.DB, q string, limit int) (Suggestions, error) {
+	out := Suggestions{
+		Titles:    make([]TitleSuggestion, 0),
+		Persons:   make([]PersonSuggestion, 0),
+		Durations: make([]DurationSuggestion, 0),
+	}
+	if db == nil {
+		return out, fmt.Errorf("db is nil")
+	}
+
+	pat := likePattern(strings.TrimSpace(q))
+
+	rows, err := db.QueryContext(ctx, `
+		WITH titles AS (
+			SELECT
+				title,
+				COUNT(*) AS uses,
+				mode() WITHIN GROUP (ORDER BY personID) AS personID,
+				mode() WITHIN GROUP (ORDER BY ROUND(EXTRACT(EPOCH FROM (endTime - startTime)) / 60)::int)
+					FILTER (WHERE endTime IS NOT NULL AND NOT allDay) AS minutes,
+				mode() WITHIN GROUP (ORDER BY location) AS location,
+				bool_and(allDay) AS allDay,
+				MAX(startTime) AS lastUsed
+			FROM events
+			WHERE feedID IS NULL AND deletedAt IS NULL
+				AND (title ILIKE $1 || '%' OR title ILIKE '% ' || $1 || '%')
+			GROUP BY title
+		)
+		SELECT title, uses, personID, `+personNameColumn("titles")+`, minutes, location, allDay
+		FROM titles
+		ORDER BY uses DESC, lastUsed DESC
+		LIMIT $2;
+	`, pat, limit)
+	if err != nil {
+		return out, fmt.Errorf("suggest titles query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t TitleSuggestion
+		if err := rows.Scan(&t.Title, &t.Count, &t.PersonID, &t.PersonName, &t.DurationMinutes, &t.Location, &t.AllDay); err != nil {
+			return out, fmt.Errorf("suggest titles scan: %w", err)
+		}
+		out.Titles = append(out.Titles, t)
+	}
+	if err := rows.Err(); err != nil {
+		return out, fmt.Errorf("suggest titles rows: %w", err)
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT p.personID, p.displayName, COUNT(e.eventID) AS uses
+		FROM people p
+		LEFT JOIN events e ON e.personID = p.personID AND e.feedID IS NULL AND e.deletedAt IS NULL
+		WHERE p.displayName ILIKE $1 || '%'
+		GROUP BY p.personID, p.displayName, p.sortOrder
+		ORDER BY uses DESC, p.sortOrder, p.displayName
+		LIMIT $2;
+	`, pat, limit)
+	if err != nil {
+		return out, fmt.Errorf("suggest persons query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p PersonSuggestion
+		if err := rows.Scan(&p.PersonID, &p.PersonName, &p.Count); err != nil {
+			return out, fmt.Errorf("suggest persons scan: %w", err)
+		}
+		out.Persons = append(out.Persons, p)
+	}
+	if err := rows.Err(); err != nil {
+		return out, fmt.Errorf("suggest persons rows: %w", err)
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT ROUND(EXTRACT(EPOCH FROM (endTime - startTime)) / 60)::int AS minutes, COUNT(*) AS uses
+		FROM events
+		WHERE feedID IS NULL AND deletedAt IS NULL
+			AND endTime IS NOT NULL
+			AND NOT allDay
+			AND endTime > startTime
+			AND (title ILIKE $1 || '%' OR title ILIKE '% ' || $1 || '%')
+		GROUP BY minutes
+		ORDER BY uses DESC, minutes
+		LIMIT $2;
+	`, pat, limit)
+	if err != nil {
+		return out, fmt.Errorf("suggest durations query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d DurationSuggestion
+		if err := rows.Scan(&d.Minutes, &d.Count); err != nil {
+			return out, fmt.Errorf("suggest durations scan: %w", err)
+		}
+		out.Durations = append(out.Durations, d)
+	}
+	if err := rows.Err(); err != nil {
+		return out, fmt.Errorf("suggest durations rows: %w", err)
+	}
+
+	return out, nil
+}
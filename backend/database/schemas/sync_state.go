@@ -0,0 +1,465 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ConflictPolicy decides who wins when an event changed on both sides since the last sync.
+type ConflictPolicy string
+
+const (
+	ConflictRemoteWins ConflictPolicy = "remote"
+	ConflictLocalWins  ConflictPolicy = "local"
+)
+
+// SyncState is one connected external calendar account.
+type SyncState struct {
+	SyncID   string `json:"syncId"`
+	Provider string `json:"provider"`
+	PersonID string `json:"personId"`
+	// Display name of the person; on create, used to find them when personId is empty
+	PersonName string `json:"personName"`
+	// Remote calendar being synced; empty until one is selected
+	CalendarID     string         `json:"calendarId"`
+	ConflictPolicy ConflictPolicy `json:"conflictPolicy"`
+	AccessToken    string         `json:"-"`
+	RefreshToken   string         `json:"-"`
+	TokenExpiry    *time.Time     `json:"-"`
+	// Provider cursor for incremental pulls (Google syncToken, Graph deltaLink)
+	SyncToken  *string    `json:"-"`
+	LastSyncAt *time.Time `json:"lastSyncAt,omitempty"`
+	LastError  *string    `json:"lastError,omitempty"`
+	Conflicts  int        `json:"conflicts"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+}
+
+// Connected reports whether the account has usable credentials.
+func (st SyncState) Connected() bool {
+	return st.RefreshToken != "" || st.AccessToken != ""
+}
+
+// SyncLink ties a local event to its copy in an external calendar.
+type SyncLink struct {
+	SyncID   string
+	EventID  string
+	RemoteID string
+	// Remote version at the last sync (Google etag, Graph changeKey)
+	RemoteVersion string
+	// EventHash of the local event at the last sync
+	LocalHash string
+}
+
+var syncStateColumns = `syncID, provider, personID, ` + personNameColumn("sync_state") + ` AS personName, calendarID, conflictPolicy, accessToken, refreshToken,
+	tokenExpiry, syncToken, lastSyncAt, lastError, conflicts, createdAt, updatedAt`
+
+func scanSyncState(row rowScanner, st *SyncState) error {
+	return row.Scan(
+		&st.SyncID,
+		&st.Provider,
+		&st.PersonID,
+		&st.PersonName,
+		&st.CalendarID,
+		&st.ConflictPolicy,
+		&st.AccessToken,
+		&st.RefreshToken,
+		&st.TokenExpiry,
+		&st.SyncToken,
+		&st.LastSyncAt,
+		&st.LastError,
+		&st.Conflicts,
+		&st.CreatedAt,
+		&st.UpdatedAt,
+	)
+}
+
+func CreateSyncStateSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "syncID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		Column{Name: "provider",
+			Type: ColumnString},
+		// Person pulled events are assigned to, and whose events are pushed
+		Column{Name: "personID",
+			Type:       ColumnUUID,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "people", ColumnName: "personID", OnDelete: FKRestrict}}},
+		Column{Name: "calendarID",
+			Type:           ColumnText,
+			DefaultSQLExpr: SQLDefault("''")},
+		Column{Name: "conflictPolicy",
+			Type:           ColumnString,
+			DefaultSQLExpr: SQLDefault("'remote'")},
+		Column{Name: "accessToken",
+			Type:           ColumnText,
+			DefaultSQLExpr: SQLDefault("''")},
+		Column{Name: "refreshToken",
+			Type:           ColumnText,
+			DefaultSQLExpr: SQLDefault("''")},
+		Column{Name: "tokenExpiry",
+			Type:     ColumnTimestamp,
+			Nullable: true},
+		Column{Name: "syncToken",
+			Type:     ColumnText,
+			Nullable: true},
+		Column{Name: "lastSyncAt",
+			Type:     ColumnTimestamp,
+			Nullable: true},
+		Column{Name: "lastError",
+			Type:     ColumnText,
+			Nullable: true},
+		Column{Name: "conflicts",
+			Type:           ColumnInt,
+			DefaultSQLExpr: SQLDefault("0")},
+	)
+
+	schema := Schema{Name: "sync_state", Columns: cols}
+	return schema
+}
+
+func CreateSyncLinkSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "syncID",
+			Type:       ColumnUUID,
+			PrimaryKey: true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "sync_state", ColumnName: "syncID", OnDelete: FKCascade}}},
+		// Deliberately not a foreign key: a link outliving its event is how local deletes are detected
+		Column{Name: "eventID",
+			Type:       ColumnUUID,
+			PrimaryKey: true},
+		Column{Name: "remoteID",
+			Type: ColumnText},
+		Column{Name: "remoteVersion",
+			Type:           ColumnText,
+			DefaultSQLExpr: SQLDefault("''")},
+		Column{Name: "localHash",
+			Type:           ColumnString,
+			DefaultSQLExpr: SQLDefault("''")},
+	)
+
+	schema := Schema{Name: "sync_links", Columns: cols}
+	return schema
+}
+
+func validateSyncState(in SyncState) error {
+	if in.Provider == "" {
+		return fmt.Errorf("provider is required")
+	}
+	if in.PersonID == "" && in.PersonName == "" {
+		return fmt.Errorf("personId is required")
+	}
+	switch in.ConflictPolicy {
+	case ConflictRemoteWins, ConflictLocalWins:
+	default:
+		return fmt.Errorf("conflictPolicy must be %q or %q", ConflictRemoteWins, ConflictLocalWins)
+	}
+	return nil
+}
+
+func CreateSyncState(ctx context.Context, db *sql.DB, in SyncState) (SyncState, error) {
+	if db == nil {
+		return SyncState{}, fmt.Errorf("db is nil")
+	}
+	if in.ConflictPolicy == "" {
+		in.ConflictPolicy = ConflictRemoteWins
+	}
+	if err := validateSyncState(in); err != nil {
+		return SyncState{}, err
+	}
+	if err := resolvePerson(ctx, db, &in.PersonID, in.PersonName); err != nil {
+		return SyncState{}, err
+	}
+
+	row := db.QueryRowContext(ctx, `
+		INSERT INTO sync_state (provider, personID, calendarID, conflictPolicy)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+syncStateColumns+`;
+	`, in.Provider, in.PersonID, in.CalendarID, in.ConflictPolicy)
+
+	var out SyncState
+	if err := scanSyncState(row, &out); err != nil {
+		return SyncState{}, fmt.Errorf("insert sync state: %w", err)
+	}
+
+	return out, nil
+}
+
+func GetSyncState(ctx context.Context, db *sql.DB, id string) (*SyncState, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		SELECT `+syncStateColumns+`
+		FROM sync_state
+		WHERE syncID = $1
+	`, id)
+
+	var st SyncState
+	if err := scanSyncState(row, &st); err != nil {
+		return nil, fmt.Errorf("get sync state scan: %w", err)
+	}
+
+	return &st, nil
+}
+
+// ListSyncStates returns the accounts for provider, or every account when provider is empty.
+func ListSyncStates(ctx context.Context, db *sql.DB, provider string) ([]SyncState, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+syncStateColumns+`
+		FROM sync_state
+		WHERE $1 = '' OR provider = $1
+		ORDER BY provider, personName, syncID;
+	`, provider)
+	if err != nil {
+		return nil, fmt.Errorf("list sync states query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]SyncState, 0)
+	for rows.Next() {
+		var st SyncState
+		if err := scanSyncState(rows, &st); err != nil {
+			return nil, fmt.Errorf("list sync states scan: %w", err)
+		}
+		out = append(out, st)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list sync states rows: %w", err)
+	}
+
+	return out, nil
+}
+
+// UpdateSyncSettings changes the user-editable parts of an account. Changing
+// the calendar discards the sync cursor and links so the next run starts over.
+func UpdateSyncSettings(ctx context.Context, db *sql.DB, id string, in SyncState) (SyncState, error) {
+	if db == nil {
+		return SyncState{}, fmt.Errorf("db is nil")
+	}
+
+	current, err := GetSyncState(ctx, db, id)
+	if err != nil {
+		return SyncState{}, err
+	}
+	in.Provider = current.Provider
+	if in.PersonID == "" && in.PersonName == "" {
+		in.PersonID = current.PersonID
+	}
+	if in.ConflictPolicy == "" {
+		in.ConflictPolicy = current.ConflictPolicy
+	}
+	if err := validateSyncState(in); err != nil {
+		return SyncState{}, err
+	}
+
+	var out SyncState
+	err = WithTx(ctx, db, func(tx *sql.Tx) error {
+		if err := resolvePerson(ctx, tx, &in.PersonID, in.PersonName); err != nil {
+			return err
+		}
+
+		if in.CalendarID != current.CalendarID {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM sync_links WHERE syncID = $1`, id); err != nil {
+				return fmt.Errorf("clear sync links: %w", err)
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE sync_state SET syncToken = NULL WHERE syncID = $1`, id); err != nil {
+				return fmt.Errorf("clear sync token: %w", err)
+			}
+		}
+
+		row := tx.QueryRowContext(ctx, `
+			UPDATE sync_state SET personID = $2, calendarID = $3, conflictPolicy = $4
+			WHERE syncID = $1
+			RETURNING `+syncStateColumns+`;
+		`, id, in.PersonID, in.CalendarID, in.ConflictPolicy)
+
+		if err := scanSyncState(row, &out); err != nil {
+			return fmt.Errorf("update sync state: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return SyncState{}, err
+	}
+
+	return out, nil
+}
+
+func UpdateSyncTokens(ctx context.Context, db *sql.DB, id, access, refresh string, expiry *time.Time) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	// Providers only send a refresh token on first consent; keep the old one otherwise
+	if _, err := db.ExecContext(ctx, `
+		UPDATE sync_state
+		SET accessToken = $2,
+			refreshToken = CASE WHEN $3 = '' THEN refreshToken ELSE $3 END,
+			tokenExpiry = $4
+		WHERE syncID = $1;
+	`, id, access, refresh, expiry); err != nil {
+		return fmt.Errorf("update sync tokens: %w", err)
+	}
+	return nil
+}
+
+// RecordSyncRun stores the cursor and outcome of a sync run.
+func RecordSyncRun(ctx context.Context, db *sql.DB, id string, cursor *string, at time.Time, conflicts int, runErr error) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	var lastError *string
+	if runErr != nil {
+		msg := runErr.Error()
+		lastError = &msg
+	}
+
+	if _, err := db.ExecContext(ctx, `
+		UPDATE sync_state
+		SET syncToken = COALESCE($2, syncToken), lastSyncAt = $3, lastError = $4, conflicts = conflicts + $5
+		WHERE syncID = $1;
+	`, id, cursor, at, lastError, conflicts); err != nil {
+		return fmt.Errorf("record sync run: %w", err)
+	}
+	return nil
+}
+
+// ResetSyncCursor forces the next run to do a full pull.
+func ResetSyncCursor(ctx context.Context, db *sql.DB, id string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+	if _, err := db.ExecContext(ctx, `UPDATE sync_state SET syncToken = NULL WHERE syncID = $1`, id); err != nil {
+		return fmt.Errorf("reset sync cursor: %w", err)
+	}
+	return nil
+}
+
+// DeleteSyncState disconnects an account. Events it pulled stay as plain local events.
+func DeleteSyncState(ctx context.Context, db *sql.DB, id string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM sync_state WHERE syncID = $1`, id)
+	if err != nil {
+		return fmt.Errorf("Failed to execute sync state delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func ListSyncLinks(ctx context.Context, db *sql.DB, syncID string) ([]SyncLink, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT syncID, eventID, remoteID, remoteVersion, localHash
+		FROM sync_links
+		WHERE syncID = $1;
+	`, syncID)
+	if err != nil {
+		return nil, fmt.Errorf("list sync links query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]SyncLink, 0)
+	for rows.Next() {
+		var l SyncLink
+		if err := rows.Scan(&l.SyncID, &l.EventID, &l.RemoteID, &l.RemoteVersion, &l.LocalHash); err != nil {
+			return nil, fmt.Errorf("list sync links scan: %w", err)
+		}
+		out = append(out, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list sync links rows: %w", err)
+	}
+
+	return out, nil
+}
+
+func UpsertSyncLink(ctx context.Context, db *sql.DB, l SyncLink) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO sync_links (syncID, eventID, remoteID, remoteVersion, localHash)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (syncID, eventID) DO UPDATE
+		SET remoteID = EXCLUDED.remoteID, remoteVersion = EXCLUDED.remoteVersion, localHash = EXCLUDED.localHash;
+	`, l.SyncID, l.EventID, l.RemoteID, l.RemoteVersion, l.LocalHash); err != nil {
+		return fmt.Errorf("upsert sync link: %w", err)
+	}
+	return nil
+}
+
+func DeleteSyncLink(ctx context.Context, db *sql.DB, syncID, eventID string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+	if _, err := db.ExecContext(ctx, `
+		DELETE FROM sync_links WHERE syncID = $1 AND eventID = $2;
+	`, syncID, eventID); err != nil {
+		return fmt.Errorf("delete sync link: %w", err)
+	}
+	return nil
+}
+
+// ListPersonEvents returns every editable (non-feed) event belonging to the person.
+func ListPersonEvents(ctx context.Context, db *sql.DB, personID string) ([]Event, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+eventColumns+`
+		FROM events
+		WHERE personID = $1 AND feedID IS NULL AND deletedAt IS NULL
+		ORDER BY startTime, eventID;
+	`, personID)
+	if err != nil {
+		return nil, fmt.Errorf("list person events query: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]Event, 0)
+	for rows.Next() {
+		var e Event
+		if err := scanEvent(rows, &e); err != nil {
+			return nil, fmt.Errorf("list person events scan: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list person events rows: %w", err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,298 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrTagExists is returned when another tag already has the name.
+var ErrTagExists = errors.New("a tag with that name already exists")
+
+// Tag labels events across people and calendars, e.g. "school" or "medical".
+type Tag struct {
+	TagID string `json:"tagId"`
+	Name  string `json:"name"`
+	// CSS hex colour, e.g. "#3b82f6"
+	Color     *string   `json:"color,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+const tagColumns = `tagID, name, color, createdAt, updatedAt`
+
+// eventTagsColumn reads an event's tag names, in the order setEventTags returns them.
+const eventTagsColumn = `ARRAY(
+		SELECT tags.name FROM event_tags JOIN tags ON tags.tagID = event_tags.tagID
+		WHERE event_tags.eventID = events.eventID
+		ORDER BY lower(tags.name))`
+
+func scanTag(row rowScanner, t *Tag) error {
+	return row.Scan(
+		&t.TagID,
+		&t.Name,
+		&t.Color,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+}
+
+func CreateTagSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "tagID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		Column{Name: "name",
+			Type: ColumnString},
+		Column{Name: "color",
+			Type:     ColumnString,
+			Nullable: true},
+	)
+
+	schema := Schema{Name: "tags", Columns: cols}
+	return schema
+}
+
+func CreateEventTagSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "eventID",
+			Type:       ColumnUUID,
+			PrimaryKey: true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "events", ColumnName: "eventID", OnDelete: FKCascade}}},
+		Column{Name: "tagID",
+			Type:       ColumnUUID,
+			PrimaryKey: true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "tags", ColumnName: "tagID", OnDelete: FKCascade}}},
+	)
+
+	schema := Schema{Name: "event_tags", Columns: cols}
+	return schema
+}
+
+func validateTag(in Tag) error {
+	if in.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if in.Color != nil && !isHexColor(*in.Color) {
+		return fmt.Errorf("color must be a hex colour like #3b82f6")
+	}
+	return nil
+}
+
+// tagTaken reports whether a tag other than exceptID is called name, ignoring case.
+func tagTaken(ctx context.Context, q queryer, name, exceptID string) (bool, error) {
+	var taken bool
+	err := q.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM tags
+			WHERE lower(name) = lower($1) AND tagID::text <> $2
+		);
+	`, name, exceptID).Scan(&taken)
+	if err != nil {
+		return false, fmt.Errorf("tag name query: %w", err)
+	}
+	return taken, nil
+}
+
+func CreateTag(ctx context.Context, db *sql.DB, in Tag) (Tag, error) {
+	if db == nil {
+		return Tag{}, fmt.Errorf("db is nil")
+	}
+
+	in.Name = strings.TrimSpace(in.Name)
+	if err := validateTag(in); err != nil {
+		return Tag{}, err
+	}
+	taken, err := tagTaken(ctx, db, in.Name, "")
+	if err != nil {
+		return Tag{}, err
+	}
+	if taken {
+		return Tag{}, ErrTagExists
+	}
+
+	row := db.QueryRowContext(ctx, `
+		INSERT INTO tags (name, color)
+		VALUES ($1, $2)
+		RETURNING `+tagColumns+`;
+	`, in.Name, in.Color)
+
+	var out Tag
+	if err := scanTag(row, &out); err != nil {
+		return Tag{}, fmt.Errorf("insert tag: %w", err)
+	}
+
+	return out, nil
+}
+
+// UpdateTag renames or recolours a tag; events carrying it follow along.
+func UpdateTag(ctx context.Context, db *sql.DB, id string, in Tag) (Tag, error) {
+	if db == nil {
+		return Tag{}, fmt.Errorf("db is nil")
+	}
+
+	in.Name = strings.TrimSpace(in.Name)
+	if err := validateTag(in); err != nil {
+		return Tag{}, err
+	}
+	taken, err := tagTaken(ctx, db, in.Name, id)
+	if err != nil {
+		return Tag{}, err
+	}
+	if taken {
+		return Tag{}, ErrTagExists
+	}
+
+	row := db.QueryRowContext(ctx, `
+		UPDATE tags SET name = $2, color = $3
+		WHERE tagID = $1
+		RETURNING `+tagColumns+`;
+	`, id, in.Name, in.Color)
+
+	var out Tag
+	if err := scanTag(row, &out); err != nil {
+		return Tag{}, fmt.Errorf("update tag: %w", err)
+	}
+	// Events list their tags by name
+	if err := logEventChanges(ctx, db, false, `eventID IN (SELECT eventID FROM event_tags WHERE tagID = $1)`, id); err != nil {
+		return Tag{}, err
+	}
+
+	return out, nil
+}
+
+func GetTag(ctx context.Context, db *sql.DB, id string) (*Tag, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		SELECT `+tagColumns+`
+		FROM tags
+		WHERE tagID = $1
+	`, id)
+
+	var t Tag
+	if err := scanTag(row, &t); err != nil {
+		return nil, fmt.Errorf("get tag scan: %w", err)
+	}
+
+	return &t, nil
+}
+
+func ListTags(ctx context.Context, db *sql.DB) ([]Tag, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+tagColumns+`
+		FROM tags
+		ORDER BY lower(name), tagID;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list tags query: %w", err)
+	}
+	defer rows.Close()
+
+	tags := make([]Tag, 0)
+	for rows.Next() {
+		var t Tag
+		if err := scanTag(rows, &t); err != nil {
+			return nil, fmt.Errorf("list tags scan: %w", err)
+		}
+		tags = append(tags, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list tags rows: %w", err)
+	}
+
+	return tags, nil
+}
+
+// DeleteTag removes a tag and takes it off every event that had it.
+func DeleteTag(ctx context.Context, db *sql.DB, id string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	// Logged first: the delete takes the tag off the events
+	if err := logEventChanges(ctx, db, false, `eventID IN (SELECT eventID FROM event_tags WHERE tagID = $1)`, id); err != nil {
+		return err
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM tags WHERE tagID = $1`, id)
+	if err != nil {
+		return fmt.Errorf("Failed to execute tag delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// resolveTags looks up tag names, ignoring case and duplicates. Unknown
+// names are an error rather than a new tag, so typos don't pile up.
+func resolveTags(ctx context.Context, q queryer, names []string) ([]Tag, error) {
+	out := make([]Tag, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+
+		row := q.QueryRowContext(ctx, `
+			SELECT `+tagColumns+`
+			FROM tags
+			WHERE lower(name) = lower($1)
+		`, name)
+		var t Tag
+		if err := scanTag(row, &t); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, fmt.Errorf("unknown tag %q", name)
+			}
+			return nil, fmt.Errorf("resolve tag query: %w", err)
+		}
+		out = append(out, t)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return strings.ToLower(out[i].Name) < strings.ToLower(out[j].Name) })
+	return out, nil
+}
+
+// setEventTags replaces an event's tags and returns their names.
+func setEventTags(ctx context.Context, q queryer, eventID string, tags []Tag) ([]string, error) {
+	if _, err := q.ExecContext(ctx, `DELETE FROM event_tags WHERE eventID = $1`, eventID); err != nil {
+		return nil, fmt.Errorf("clear event tags: %w", err)
+	}
+
+	names := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if _, err := q.ExecContext(ctx, `
+			INSERT INTO event_tags (eventID, tagID)
+			VALUES ($1, $2);
+		`, eventID, t.TagID); err != nil {
+			return nil, fmt.Errorf("insert event tag %s: %w", t.Name, err)
+		}
+		names = append(names, t.Name)
+	}
+	return names, nil
+}
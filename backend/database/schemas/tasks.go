@@ -0,0 +1,149 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// TaskCompletion marks one occurrence of a task as done.
+type TaskCompletion struct {
+	EventID string `json:"eventId"`
+	// Original start of the occurrence, as used by exceptions, so it stays
+	// done wherever it has been moved to
+	RecurrenceID time.Time `json:"recurrenceId"`
+	CompletedAt  time.Time `json:"completedAt"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+func CreateTaskCompletionSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "eventID",
+			Type:       ColumnUUID,
+			PrimaryKey: true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "events", ColumnName: "eventID", OnDelete: FKCascade}}},
+		Column{Name: "recurrenceID",
+			Type:       ColumnTimestamp,
+			PrimaryKey: true},
+		Column{Name: "completedAt",
+			Type:           ColumnTimestamp,
+			DefaultSQLExpr: DefaultNow()},
+	)
+
+	schema := Schema{Name: "task_completions", Columns: cols}
+	return schema
+}
+
+// CompleteTask marks an occurrence done. Marking it again keeps the first
+// completion.
+func CompleteTask(ctx context.Context, db *sql.DB, eventID string, recurrenceID time.Time) (TaskCompletion, error) {
+	if db == nil {
+		return TaskCompletion{}, fmt.Errorf("db is nil")
+	}
+
+	var out TaskCompletion
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO task_completions (eventID, recurrenceID)
+		VALUES ($1, $2)
+		ON CONFLICT (eventID, recurrenceID) DO UPDATE SET completedAt = task_completions.completedAt
+		RETURNING eventID, recurrenceID, completedAt, createdAt, updatedAt;
+	`, eventID, recurrenceID).Scan(&out.EventID, &out.RecurrenceID, &out.CompletedAt, &out.CreatedAt, &out.UpdatedAt)
+	if err != nil {
+		return TaskCompletion{}, fmt.Errorf("complete task: %w", err)
+	}
+
+	return out, nil
+}
+
+// UncompleteTask marks an occurrence not done after all.
+func UncompleteTask(ctx context.Context, db *sql.DB, eventID string, recurrenceID time.Time) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM task_completions WHERE eventID = $1 AND recurrenceID = $2`, eventID, recurrenceID)
+	if err != nil {
+		return fmt.Errorf("Failed to execute task completion delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ListTaskCompletions returns the completions of the events in eventIDs,
+// oldest occurrence first.
+func ListTaskCompletions(ctx context.Context, db *sql.DB, eventIDs []string) ([]TaskCompletion, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT eventID, recurrenceID, completedAt, createdAt, updatedAt
+		FROM task_completions
+		WHERE eventID::text = ANY($1)
+		ORDER BY eventID, recurrenceID;
+	`, eventIDs)
+	if err != nil {
+		return nil, fmt.Errorf("list task completions query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]TaskCompletion, 0)
+	for rows.Next() {
+		var c TaskCompletion
+		if err := rows.Scan(&c.EventID, &c.RecurrenceID, &c.CompletedAt, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("list task completions scan: %w", err)
+		}
+		out = append(out, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list task completions rows: %w", err)
+	}
+
+	return out, nil
+}
+
+// ListRolloverEvents returns the tasks whose unfinished occurrences roll over.
+func ListRolloverEvents(ctx context.Context, db *sql.DB) ([]Event, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+eventColumns+`
+		FROM events
+		WHERE task AND rollover AND deletedAt IS NULL;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list rollover events query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]Event, 0)
+	for rows.Next() {
+		var e Event
+		if err := scanEvent(rows, &e); err != nil {
+			return nil, fmt.Errorf("list rollover events scan: %w", err)
+		}
+		out = append(out, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list rollover events rows: %w", err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,99 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// TravelTime is how long it takes to get to one occurrence of an event, as
+// leave-by reminders last worked it out. It holds for as long as the
+// occurrence keeps its start and the event its coordinates.
+type TravelTime struct {
+	EventID      string
+	RecurrenceID time.Time
+	StartTime    time.Time
+	Latitude     float64
+	Longitude    float64
+	Seconds      int
+	UpdatedAt    time.Time
+}
+
+func CreateTravelTimeSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "eventID",
+			Type:       ColumnUUID,
+			PrimaryKey: true,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "events", ColumnName: "eventID", OnDelete: FKCascade}}},
+		Column{Name: "recurrenceID",
+			Type:       ColumnTimestamp,
+			PrimaryKey: true},
+		Column{Name: "startTime",
+			Type: ColumnTimestamp},
+		Column{Name: "latitude",
+			Type: ColumnFloat},
+		Column{Name: "longitude",
+			Type: ColumnFloat},
+		Column{Name: "seconds",
+			Type: ColumnInt},
+	)
+
+	schema := Schema{Name: "travel_times", Columns: cols}
+	return schema
+}
+
+// GetTravelTime returns the travel time kept for an occurrence, or
+// sql.ErrNoRows when there is none.
+func GetTravelTime(ctx context.Context, db *sql.DB, eventID string, recurrenceID time.Time) (*TravelTime, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	var t TravelTime
+	err := db.QueryRowContext(ctx, `
+		SELECT eventID, recurrenceID, startTime, latitude, longitude, seconds, updatedAt
+		FROM travel_times
+		WHERE eventID = $1 AND recurrenceID = $2
+	`, eventID, recurrenceID).Scan(&t.EventID, &t.RecurrenceID, &t.StartTime, &t.Latitude, &t.Longitude, &t.Seconds, &t.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("get travel time scan: %w", err)
+	}
+
+	return &t, nil
+}
+
+// SaveTravelTime keeps t, replacing what was kept for the occurrence, and
+// stamps it as worked out now.
+func SaveTravelTime(ctx context.Context, db *sql.DB, t TravelTime) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO travel_times (eventID, recurrenceID, startTime, latitude, longitude, seconds)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (eventID, recurrenceID) DO UPDATE
+		SET startTime = EXCLUDED.startTime, latitude = EXCLUDED.latitude,
+			longitude = EXCLUDED.longitude, seconds = EXCLUDED.seconds,
+			-- The trigger leaves it alone when the time hasn't changed
+			updatedAt = CURRENT_TIMESTAMP;
+	`, t.EventID, t.RecurrenceID, t.StartTime, t.Latitude, t.Longitude, t.Seconds); err != nil {
+		return fmt.Errorf("save travel time: %w", err)
+	}
+	return nil
+}
+
+// PruneTravelTimes forgets travel times to occurrences that started before
+// cutoff.
+func PruneTravelTimes(ctx context.Context, db *sql.DB, cutoff time.Time) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM travel_times WHERE startTime < $1`, cutoff); err != nil {
+		return fmt.Errorf("prune travel times: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// errDryRun is returned from a WithTx function to roll back work that went
+// through, so a dry run can report what it would have done.
+var errDryRun = errors.New("dry run")
+
+// WithTx runs fn in a transaction, committing it if fn returns nil and
+// rolling it back otherwise, or if fn panics. The *Tx variants of the write
+// functions take the transaction, so several of them can be made atomic:
+//
+//	err := schemas.WithTx(ctx, db, func(tx *sql.Tx) error {
+//		e, err := schemas.CreateEventTx(ctx, tx, in)
+//		if err != nil {
+//			return err
+//		}
+//		ex.EventID = e.EventID
+//		return schemas.UpsertExceptionTx(ctx, tx, ex)
+//	})
+func WithTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,499 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Kinds of change webhooks and realtime clients can be sent.
+const (
+	WebhookEventCreated   = "event.created"
+	WebhookEventUpdated   = "event.updated"
+	WebhookEventDeleted   = "event.deleted"
+	WebhookExceptionAdded = "exception.added"
+	// A reminder went off, or was dismissed on some device
+	WebhookReminderFired     = "reminder.fired"
+	WebhookReminderDismissed = "reminder.dismissed"
+)
+
+var webhookTypes = []string{WebhookEventCreated, WebhookEventUpdated, WebhookEventDeleted, WebhookExceptionAdded, WebhookReminderFired, WebhookReminderDismissed}
+
+// ChangeFilter picks which calendar changes are wanted. Empty lists match
+// everything.
+type ChangeFilter struct {
+	// Only these kinds of change, e.g. "event.created"
+	Types []string `json:"types"`
+	// Only events in these calendars, or of these people
+	CalendarIDs []string `json:"calendarIds"`
+	PersonIDs   []string `json:"personIds"`
+}
+
+// Matches reports whether a change of kind typ to e passes the filter.
+func (f ChangeFilter) Matches(typ string, e Event) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, typ) {
+		return false
+	}
+	if len(f.PersonIDs) > 0 && !slices.Contains(f.PersonIDs, e.PersonID) {
+		return false
+	}
+	if len(f.CalendarIDs) > 0 && (e.CalendarID == nil || !slices.Contains(f.CalendarIDs, *e.CalendarID)) {
+		return false
+	}
+	return true
+}
+
+// Validate checks the types are known ones, and makes missing lists empty.
+func (f *ChangeFilter) Validate() error {
+	for _, t := range f.Types {
+		if !slices.Contains(webhookTypes, t) {
+			return fmt.Errorf("types may only contain %s", strings.Join(webhookTypes, ", "))
+		}
+	}
+	if f.Types == nil {
+		f.Types = []string{}
+	}
+	if f.CalendarIDs == nil {
+		f.CalendarIDs = []string{}
+	}
+	if f.PersonIDs == nil {
+		f.PersonIDs = []string{}
+	}
+	return nil
+}
+
+// Webhook is a URL that calendar changes passing its filter are POSTed to.
+type Webhook struct {
+	WebhookID string `json:"webhookId"`
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	ChangeFilter
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+const webhookColumns = `webhookID, name, url, types, calendarIDs, personIDs, createdAt, updatedAt`
+
+func scanWebhook(row rowScanner, h *Webhook, extra ...any) error {
+	dest := []any{
+		&h.WebhookID,
+		&h.Name,
+		&h.URL,
+		textArray(&h.Types),
+		textArray(&h.CalendarIDs),
+		textArray(&h.PersonIDs),
+		&h.CreatedAt,
+		&h.UpdatedAt,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+func CreateWebhookSchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "webhookID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		Column{Name: "name",
+			Type: ColumnString},
+		Column{Name: "url",
+			Type: ColumnText},
+		// Signs payloads, so it is kept as is rather than hashed
+		Column{Name: "secret",
+			Type: ColumnString},
+		Column{Name: "types",
+			Type:           ColumnTextArray,
+			DefaultSQLExpr: SQLDefault("'{}'")},
+		Column{Name: "calendarIDs",
+			Type:           ColumnTextArray,
+			DefaultSQLExpr: SQLDefault("'{}'")},
+		Column{Name: "personIDs",
+			Type:           ColumnTextArray,
+			DefaultSQLExpr: SQLDefault("'{}'")},
+		Column{Name: "createdAt",
+			Type:           ColumnTimestamp,
+			DefaultSQLExpr: DefaultNow()},
+	)
+
+	schema := Schema{Name: "webhooks", Columns: cols}
+	return schema
+}
+
+// WebhookDelivery is one change queued for, or sent to, a webhook.
+type WebhookDelivery struct {
+	DeliveryID string `json:"deliveryId"`
+	WebhookID  string `json:"webhookId"`
+	Type       string `json:"type"`
+	// The JSON body that is POSTed
+	Payload string `json:"-"`
+	// "pending" until it succeeds ("delivered") or runs out of attempts ("failed")
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt,omitempty"`
+	ResponseStatus *int       `json:"responseStatus,omitempty"`
+	LastError      *string    `json:"lastError,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+const webhookDeliveryColumns = `deliveryID, webhookID, type, payload, status, attempts, nextAttemptAt,
+	responseStatus, lastError, createdAt, updatedAt, deliveredAt`
+
+func scanWebhookDelivery(row rowScanner, d *WebhookDelivery, extra ...any) error {
+	dest := []any{
+		&d.DeliveryID,
+		&d.WebhookID,
+		&d.Type,
+		&d.Payload,
+		&d.Status,
+		&d.Attempts,
+		&d.NextAttemptAt,
+		&d.ResponseStatus,
+		&d.LastError,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+		&d.DeliveredAt,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+func CreateWebhookDeliverySchema() Schema {
+	cols := make([]Column, 0)
+	cols = append(cols,
+		Column{Name: "deliveryID",
+			Type:           ColumnUUID,
+			PrimaryKey:     true,
+			DefaultSQLExpr: DefaultUUID()},
+		Column{Name: "webhookID",
+			Type:       ColumnUUID,
+			ForeignKey: []ForeignKeyMatch{{TargetSchema: "webhooks", ColumnName: "webhookID", OnDelete: FKCascade}}},
+		Column{Name: "type",
+			Type: ColumnString},
+		Column{Name: "payload",
+			Type: ColumnText},
+		Column{Name: "status",
+			Type:           ColumnString,
+			DefaultSQLExpr: SQLDefault("'" + DeliveryPending + "'")},
+		Column{Name: "attempts",
+			Type:           ColumnInt,
+			DefaultSQLExpr: SQLDefault("0")},
+		// Null once the delivery is finished either way
+		Column{Name: "nextAttemptAt",
+			Type:           ColumnTimestamp,
+			Nullable:       true,
+			DefaultSQLExpr: DefaultNow()},
+		Column{Name: "responseStatus",
+			Type:     ColumnInt,
+			Nullable: true},
+		Column{Name: "lastError",
+			Type:     ColumnText,
+			Nullable: true},
+		Column{Name: "createdAt",
+			Type:           ColumnTimestamp,
+			DefaultSQLExpr: DefaultNow()},
+		Column{Name: "deliveredAt",
+			Type:     ColumnTimestamp,
+			Nullable: true},
+	)
+
+	schema := Schema{Name: "webhook_deliveries", Columns: cols, Indexes: []Index{
+		{Name: "webhook_deliveries_due_idx", Columns: []string{"nextAttemptAt"}},
+		{Name: "webhook_deliveries_webhook_idx", Columns: []string{"webhookID", "createdAt"}},
+	}}
+	return schema
+}
+
+func validateWebhook(in *Webhook) error {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	u, err := url.Parse(in.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https URL")
+	}
+	return in.ChangeFilter.Validate()
+}
+
+// CreateWebhook registers a webhook whose payloads are signed with secret.
+func CreateWebhook(ctx context.Context, db *sql.DB, in Webhook, secret string) (Webhook, error) {
+	if db == nil {
+		return Webhook{}, fmt.Errorf("db is nil")
+	}
+
+	if err := validateWebhook(&in); err != nil {
+		return Webhook{}, err
+	}
+
+	row := db.QueryRowContext(ctx, `
+		INSERT INTO webhooks (name, url, secret, types, calendarIDs, personIDs)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+webhookColumns+`;
+	`, in.Name, in.URL, secret, in.Types, in.CalendarIDs, in.PersonIDs)
+
+	var out Webhook
+	if err := scanWebhook(row, &out); err != nil {
+		return Webhook{}, fmt.Errorf("insert webhook: %w", err)
+	}
+
+	return out, nil
+}
+
+// UpdateWebhook changes a webhook's name, URL and filters; the secret stays.
+func UpdateWebhook(ctx context.Context, db *sql.DB, id string, in Webhook) (Webhook, error) {
+	if db == nil {
+		return Webhook{}, fmt.Errorf("db is nil")
+	}
+
+	if err := validateWebhook(&in); err != nil {
+		return Webhook{}, err
+	}
+
+	row := db.QueryRowContext(ctx, `
+		UPDATE webhooks SET name = $2, url = $3, types = $4, calendarIDs = $5, personIDs = $6
+		WHERE webhookID = $1
+		RETURNING `+webhookColumns+`;
+	`, id, in.Name, in.URL, in.Types, in.CalendarIDs, in.PersonIDs)
+
+	var out Webhook
+	if err := scanWebhook(row, &out); err != nil {
+		return Webhook{}, fmt.Errorf("update webhook: %w", err)
+	}
+
+	return out, nil
+}
+
+func GetWebhook(ctx context.Context, db *sql.DB, id string) (*Webhook, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	row := db.QueryRowContext(ctx, `
+		SELECT `+webhookColumns+`
+		FROM webhooks
+		WHERE webhookID = $1
+	`, id)
+
+	var h Webhook
+	if err := scanWebhook(row, &h); err != nil {
+		return nil, fmt.Errorf("get webhook scan: %w", err)
+	}
+
+	return &h, nil
+}
+
+func ListWebhooks(ctx context.Context, db *sql.DB) ([]Webhook, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+webhookColumns+`
+		FROM webhooks
+		ORDER BY createdAt, webhookID;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list webhooks query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]Webhook, 0)
+	for rows.Next() {
+		var h Webhook
+		if err := scanWebhook(rows, &h); err != nil {
+			return nil, fmt.Errorf("list webhooks scan: %w", err)
+		}
+		out = append(out, h)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list webhooks rows: %w", err)
+	}
+
+	return out, nil
+}
+
+func DeleteWebhook(ctx context.Context, db *sql.DB, id string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM webhooks WHERE webhookID = $1`, id)
+	if err != nil {
+		return fmt.Errorf("Failed to execute webhook delete statement: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("could not get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// QueueWebhookDelivery stores a payload for the dispatcher to send.
+func QueueWebhookDelivery(ctx context.Context, db *sql.DB, webhookID, typ, payload string) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhookID, type, payload)
+		VALUES ($1, $2, $3)
+	`, webhookID, typ, payload); err != nil {
+		return fmt.Errorf("queue webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// DueWebhookDelivery is a pending delivery with what is needed to send it.
+type DueWebhookDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// ListDueWebhookDeliveries returns up to limit pending deliveries whose next
+// attempt is due by now, oldest first.
+func ListDueWebhookDeliveries(ctx context.Context, db *sql.DB, now time.Time, limit int) ([]DueWebhookDelivery, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+webhookDeliveryColumns+`,
+			(SELECT url FROM webhooks WHERE webhooks.webhookID = webhook_deliveries.webhookID),
+			(SELECT secret FROM webhooks WHERE webhooks.webhookID = webhook_deliveries.webhookID)
+		FROM webhook_deliveries
+		WHERE status = 'pending' AND nextAttemptAt <= $1
+		ORDER BY nextAttemptAt, createdAt
+		LIMIT $2;
+	`, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list due webhook deliveries query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]DueWebhookDelivery, 0)
+	for rows.Next() {
+		var d DueWebhookDelivery
+		if err := scanWebhookDelivery(rows, &d.WebhookDelivery, &d.URL, &d.Secret); err != nil {
+			return nil, fmt.Errorf("list due webhook deliveries scan: %w", err)
+		}
+		out = append(out, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list due webhook deliveries rows: %w", err)
+	}
+
+	return out, nil
+}
+
+// RecordWebhookAttempt stores the outcome of sending a delivery. A nil next
+// finishes it: delivered when it succeeded, failed when it didn't.
+func RecordWebhookAttempt(ctx context.Context, db *sql.DB, id string, succeeded bool, responseStatus *int, lastError *string, next *time.Time) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	status := DeliveryPending
+	switch {
+	case succeeded:
+		status, next = DeliveryDelivered, nil
+	case next == nil:
+		status = DeliveryFailed
+	}
+
+	if _, err := db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = attempts + 1, responseStatus = $3, lastError = $4, nextAttemptAt = $5,
+			deliveredAt = CASE WHEN $2 = 'delivered' THEN now() ELSE NULL END
+		WHERE deliveryID = $1
+	`, id, status, responseStatus, lastError, next); err != nil {
+		return fmt.Errorf("record webhook attempt: %w", err)
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns a page of the webhook's deliveries, newest first,
+// and how many it has in all.
+func ListWebhookDeliveries(ctx context.Context, db *sql.DB, webhookID string, limit, offset int) ([]WebhookDelivery, int, error) {
+	if db == nil {
+		return nil, 0, fmt.Errorf("db is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+webhookDeliveryColumns+`,
+			COUNT(*) OVER() AS total_count
+		FROM webhook_deliveries
+		WHERE webhookID = $1
+		ORDER BY createdAt DESC, deliveryID
+		LIMIT $2 OFFSET $3;
+	`, webhookID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list webhook deliveries query: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]WebhookDelivery, 0, limit)
+	total := 0
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := scanWebhookDelivery(rows, &d, &total); err != nil {
+			return nil, 0, fmt.Errorf("list webhook deliveries scan: %w", err)
+		}
+		out = append(out, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("list webhook deliveries rows: %w", err)
+	}
+
+	return out, total, nil
+}
+
+// PruneWebhookDeliveries forgets finished deliveries created before cutoff,
+// and all but the newest maxRows of them. A zero cutoff or maxRows leaves
+// that limit off.
+func PruneWebhookDeliveries(ctx context.Context, db *sql.DB, cutoff time.Time, maxRows int) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+
+	if !cutoff.IsZero() {
+		if _, err := db.ExecContext(ctx, `
+			DELETE FROM webhook_deliveries WHERE status <> 'pending' AND createdAt < $1`, cutoff); err != nil {
+			return fmt.Errorf("prune webhook deliveries: %w", err)
+		}
+	}
+	if maxRows > 0 {
+		if _, err := db.ExecContext(ctx, `
+			DELETE FROM webhook_deliveries WHERE deliveryID IN (
+				SELECT deliveryID FROM webhook_deliveries
+				WHERE status <> 'pending'
+				ORDER BY createdAt DESC, deliveryID
+				OFFSET $1
+			)`, maxRows); err != nil {
+			return fmt.Errorf("prune webhook deliveries over %d: %w", maxRows, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package schemas
+
+import "testing"
+
+func TestChangeFilterMatches(t *testing.T) {
+	school, family := "school", "family"
+	ann := Event{PersonID: "ann", CalendarID: &school}
+	bob := Event{PersonID: "bob", CalendarID: &family}
+	loose := Event{PersonID: "ann"}
+
+	tests := []struct {
+		name   string
+		filter ChangeFilter
+		typ    string
+		event  Event
+		want   bool
+	}{
+		{"empty filter", ChangeFilter{}, WebhookEventDeleted, loose, true},
+		{"type listed", ChangeFilter{Types: []string{WebhookEventCreated, WebhookEventUpdated}}, WebhookEventUpdated, ann, true},
+		{"type not listed", ChangeFilter{Types: []string{WebhookEventCreated}}, WebhookExceptionAdded, ann, false},
+		{"person listed", ChangeFilter{PersonIDs: []string{"ann"}}, WebhookEventCreated, ann, true},
+		{"person not listed", ChangeFilter{PersonIDs: []string{"ann"}}, WebhookEventCreated, bob, false},
+		{"calendar listed", ChangeFilter{CalendarIDs: []string{"family"}}, WebhookEventCreated, bob, true},
+		{"calendar not listed", ChangeFilter{CalendarIDs: []string{"family"}}, WebhookEventCreated, ann, false},
+		{"no calendar against a calendar filter", ChangeFilter{CalendarIDs: []string{"school"}}, WebhookEventCreated, loose, false},
+		{"all three match", ChangeFilter{Types: []string{WebhookEventCreated}, PersonIDs: []string{"bob"}, CalendarIDs: []string{"family"}}, WebhookEventCreated, bob, true},
+		{"right person in the wrong calendar", ChangeFilter{PersonIDs: []string{"ann"}, CalendarIDs: []string{"family"}}, WebhookEventCreated, ann, false},
+		{"right calendar for the wrong type", ChangeFilter{Types: []string{WebhookEventDeleted}, CalendarIDs: []string{"school"}}, WebhookEventCreated, ann, false},
+		{"empty lists match everything", ChangeFilter{Types: []string{}, PersonIDs: []string{}, CalendarIDs: []string{}}, WebhookReminderFired, loose, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Matches(tt.typ, tt.event); got != tt.want {
+				t.Errorf("Matches(%q, %+v) = %v, want %v", tt.typ, tt.event, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangeFilterValidate(t *testing.T) {
+	var f ChangeFilter
+	if err := f.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if f.Types == nil || f.PersonIDs == nil || f.CalendarIDs == nil {
+		t.Errorf("Validate left nil lists: %+v", f)
+	}
+
+	f = ChangeFilter{Types: []string{WebhookEventCreated, "event.exploded"}}
+	if err := f.Validate(); err == nil {
+		t.Error("Validate took an unknown type")
+	}
+}
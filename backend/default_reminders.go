@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"pical/database/schemas"
+)
+
+// runDefaultReminders handles `server default-reminders [flags] <minutes>...`,
+// which gives every matching event reminders that many minutes ahead, as
+// POST /api/admin/default-reminders does. With -dry-run it only prints what
+// would be added.
+func runDefaultReminders(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("default-reminders", flag.ContinueOnError)
+	calendar := fs.String("calendar", "", "only events on this calendar `ID`")
+	person := fs.String("person", "", "only events for this person `ID`")
+	tag := fs.String("tag", "", "only events with this tag `name`")
+	channel := fs.String("channel", string(schemas.ReminderAlert), "deliver by alert or push")
+	leaveBy := fs.Bool("leave-by", false, "count the minutes back from when to leave, not from the start")
+	dryRun := fs.Bool("dry-run", false, "report what would be added without adding it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: default-reminders [-calendar ID] [-person ID] [-tag name] [-channel alert|push] [-leave-by] [-dry-run] <minutes>...")
+	}
+
+	reminders := make([]schemas.Reminder, 0, fs.NArg())
+	for _, v := range fs.Args() {
+		minutes, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a number of minutes", v)
+		}
+		reminders = append(reminders, schemas.Reminder{OffsetMinutes: minutes, Channel: schemas.ReminderChannel(*channel), LeaveBy: *leaveBy})
+	}
+	filter := schemas.EventFilter{CalendarID: *calendar, PersonID: *person, Tag: *tag}
+
+	matched, added, err := schemas.AddDefaultReminders(ctx, db, filter, reminders, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, r := range added {
+		before := ""
+		if r.LeaveBy {
+			before = " before leaving"
+		}
+		fmt.Printf("%s  %d minutes%s  %s\n", r.EventID, r.OffsetMinutes, before, r.Channel)
+	}
+	verb := "added"
+	if *dryRun {
+		verb = "would add"
+	}
+	fmt.Printf("%s %d reminders across %d matching events\n", verb, len(added), matched)
+	return nil
+}
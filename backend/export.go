@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"time"
+
+	"pical/database/schemas"
+	"pical/ical"
+)
+
+// runExport handles `server export [flags] [file]`, which writes the
+// calendar as ICS, as GET /api/calendar.ics does, optionally only the events
+// on one calendar, for one person or with one tag.
+func runExport(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	calendar := fs.String("calendar", "", "only events on this calendar `ID`")
+	person := fs.String("person", "", "only events for this person `ID`")
+	tag := fs.String("tag", "", "only events with this tag `name`")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	filter := schemas.EventFilter{CalendarID: *calendar, PersonID: *person, Tag: *tag}
+
+	events, err := schemas.ListAllEvents(ctx, db, filter)
+	if err != nil {
+		return err
+	}
+	exceptions, err := schemas.ListAllExceptions(ctx, db)
+	if err != nil {
+		return err
+	}
+	items := make([]ical.Item, 0, len(events))
+	for _, e := range events {
+		items = append(items, ical.Item{Event: e, Exceptions: exceptions[e.EventID]})
+	}
+
+	out, err := output(fs.Args())
+	if err != nil {
+		return err
+	}
+	if err := ical.Encode(out, "PiCal", items, time.Now()); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
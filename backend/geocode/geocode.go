@@ -0,0 +1,78 @@
+// Package geocode turns free-text event locations into coordinates.
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound means the provider answered but had no match for the address.
+var ErrNotFound = errors.New("address not found")
+
+// Geocoder looks up where an address is.
+type Geocoder interface {
+	Geocode(ctx context.Context, address string) (lat, lng float64, err error)
+}
+
+var client = &http.Client{Timeout: 15 * time.Second}
+
+// Nominatim queries an OpenStreetMap Nominatim server, either the public one
+// at https://nominatim.openstreetmap.org or a self-hosted instance.
+type Nominatim struct {
+	baseURL string
+}
+
+func NewNominatim(baseURL string) *Nominatim {
+	return &Nominatim{baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+func (n *Nominatim) Geocode(ctx context.Context, address string) (float64, float64, error) {
+	q := url.Values{"q": {address}, "format": {"jsonv2"}, "limit": {"1"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, n.baseURL+"/search?"+q.Encode(), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	// The public server's usage policy asks every application to identify itself
+	req.Header.Set("User-Agent", "PiCal (self-hosted family calendar)")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("nominatim: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, 0, fmt.Errorf("nominatim: unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var results []struct {
+		Lat string `json:"lat"`
+		Lon string `json:"lon"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return 0, 0, fmt.Errorf("nominatim: decode: %w", err)
+	}
+	if len(results) == 0 {
+		return 0, 0, ErrNotFound
+	}
+
+	lat, err := strconv.ParseFloat(results[0].Lat, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("nominatim: lat: %w", err)
+	}
+	lng, err := strconv.ParseFloat(results[0].Lon, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("nominatim: lon: %w", err)
+	}
+	return lat, lng, nil
+}
@@ -0,0 +1,847 @@
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pical/database/schemas"
+)
+
+// DecodeOptions supplies values for fields ICS data does not always carry.
+type DecodeOptions struct {
+	// Person assigned to events without an X-PICAL-PERSON property
+	DefaultPerson string
+	// Zone used for floating times and all-day dates; UTC when nil
+	DefaultLocation *time.Location
+}
+
+// Skipped describes a VEVENT that could not be turned into an event.
+type Skipped struct {
+	UID     string `json:"uid,omitempty"`
+	Summary string `json:"summary,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// Decoded is the result of reading a VCALENDAR.
+type Decoded struct {
+	Items   []Item
+	Skipped []Skipped
+}
+
+// Decode parses a VCALENDAR stream into events and their exceptions.
+//
+// RRULEs are stored verbatim; EXDATEs become cancel exceptions and VEVENTs
+// carrying a RECURRENCE-ID become move (or, when STATUS:CANCELLED, cancel)
+// exceptions on the series with the same UID. TZIDs are resolved from the
+// system tz database first and fall back to the calendar's VTIMEZONE
+// definitions, in which case times are converted to UTC. Each distinct
+// VALARM that goes off at or before the event's start becomes an alert
+// reminder; later ones, and ones further ahead than reminders may be, are
+// dropped.
+func Decode(r io.Reader, opts DecodeOptions) (Decoded, error) {
+	if opts.DefaultLocation == nil {
+		opts.DefaultLocation = time.UTC
+	}
+
+	root, err := parse(r)
+	if err != nil {
+		return Decoded{}, err
+	}
+
+	cal := root
+	if cal.name != "VCALENDAR" {
+		cal = root.child("VCALENDAR")
+		if cal == nil {
+			return Decoded{}, fmt.Errorf("no VCALENDAR found")
+		}
+	}
+
+	d := &decoder{opts: opts, zones: make(map[string]*vtimezone)}
+	for _, c := range cal.children {
+		if c.name == "VTIMEZONE" {
+			if tz := parseVTimezone(c); tz != nil {
+				d.zones[tz.id] = tz
+			}
+		}
+	}
+
+	var out Decoded
+	masters := make(map[string]int) // UID -> index in out.Items
+	var overrides []*component
+
+	for _, c := range cal.children {
+		if c.name != "VEVENT" {
+			continue
+		}
+		if c.prop("RECURRENCE-ID") != nil {
+			overrides = append(overrides, c)
+			continue
+		}
+
+		item, err := d.event(c)
+		if err != nil {
+			out.Skipped = append(out.Skipped, skippedFor(c, err))
+			continue
+		}
+		if uid := c.value("UID"); uid != "" {
+			masters[uid] = len(out.Items)
+		}
+		out.Items = append(out.Items, item)
+	}
+
+	for _, c := range overrides {
+		idx, ok := masters[c.value("UID")]
+		if !ok {
+			out.Skipped = append(out.Skipped, skippedFor(c, fmt.Errorf("override has no matching recurring event")))
+			continue
+		}
+		item := &out.Items[idx]
+		if item.Event.Rrule == nil {
+			out.Skipped = append(out.Skipped, skippedFor(c, fmt.Errorf("override targets a non-recurring event")))
+			continue
+		}
+
+		ex, err := d.override(c, item.Event)
+		if err != nil {
+			out.Skipped = append(out.Skipped, skippedFor(c, err))
+			continue
+		}
+		item.Exceptions = mergeException(item.Exceptions, ex)
+	}
+
+	for i := range out.Items {
+		sort.Slice(out.Items[i].Exceptions, func(a, b int) bool {
+			return out.Items[i].Exceptions[a].RecurrenceID.Before(out.Items[i].Exceptions[b].RecurrenceID)
+		})
+	}
+
+	return out, nil
+}
+
+// ParseRecurrence reads RRULE and EXDATE content lines, as found in the
+// recurrence arrays of calendar APIs, and returns the rule and cancelled
+// occurrence starts. Times without a zone are read in def.
+func ParseRecurrence(lines []string, def *time.Location) (*string, []time.Time, error) {
+	if def == nil {
+		def = time.UTC
+	}
+	d := &decoder{opts: DecodeOptions{DefaultLocation: def}, zones: map[string]*vtimezone{}}
+
+	var rrule *string
+	var exdates []time.Time
+	for _, line := range lines {
+		p, err := parseLine(line)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch p.name {
+		case "RRULE":
+			v := p.value
+			rrule = &v
+		case "EXDATE":
+			for _, v := range strings.Split(p.value, ",") {
+				t, _, _, err := d.timeValue(property{name: p.name, params: p.params, value: v})
+				if err != nil {
+					return nil, nil, fmt.Errorf("EXDATE: %w", err)
+				}
+				exdates = append(exdates, t)
+			}
+		}
+	}
+	return rrule, exdates, nil
+}
+
+type decoder struct {
+	opts  DecodeOptions
+	zones map[string]*vtimezone
+}
+
+func (d *decoder) event(c *component) (Item, error) {
+	startProp := c.prop("DTSTART")
+	if startProp == nil {
+		return Item{}, fmt.Errorf("missing DTSTART")
+	}
+
+	start, allDay, loc, err := d.timeValue(*startProp)
+	if err != nil {
+		return Item{}, fmt.Errorf("DTSTART: %w", err)
+	}
+
+	e := schemas.Event{
+		Title:      unescapeText(c.value("SUMMARY")),
+		PersonName: unescapeText(c.value("X-PICAL-PERSON")),
+		Timezone:   loc.String(),
+		AllDay:     allDay,
+		StartTime:  start,
+	}
+	if e.Title == "" {
+		e.Title = "(untitled)"
+	}
+	if e.PersonName == "" {
+		e.PersonName = d.opts.DefaultPerson
+	}
+	if e.PersonName == "" {
+		return Item{}, fmt.Errorf("no person for event and no default person given")
+	}
+	if notes := unescapeText(c.value("DESCRIPTION")); notes != "" {
+		e.Notes = &notes
+	}
+	if where := strings.TrimSpace(unescapeText(c.value("LOCATION"))); where != "" {
+		e.Location = &where
+	}
+	// Only web links are kept; mailto: and friends would fail validation
+	if link := c.value("URL"); strings.HasPrefix(link, "https://") || strings.HasPrefix(link, "http://") {
+		e.URL = &link
+	}
+
+	if p := c.prop("DTEND"); p != nil {
+		end, _, _, err := d.timeValue(*p)
+		if err != nil {
+			return Item{}, fmt.Errorf("DTEND: %w", err)
+		}
+		e.EndTime = &end
+	} else if v := c.value("DURATION"); v != "" {
+		dur, err := parseDuration(v)
+		if err != nil {
+			return Item{}, fmt.Errorf("DURATION: %w", err)
+		}
+		end := addDuration(start, dur)
+		e.EndTime = &end
+	}
+	if e.EndTime != nil && e.EndTime.Before(e.StartTime) {
+		return Item{}, fmt.Errorf("DTEND is before DTSTART")
+	}
+
+	item := Item{UID: c.value("UID"), Event: e}
+	for _, ch := range c.children {
+		if ch.name != "VALARM" {
+			continue
+		}
+		r, ok := d.alarm(ch, e)
+		if ok && !slices.Contains(item.Reminders, r) {
+			item.Reminders = append(item.Reminders, r)
+		}
+	}
+	if rrule := c.value("RRULE"); rrule != "" {
+		item.Event.Rrule = &rrule
+
+		for _, p := range c.props {
+			if p.name != "EXDATE" {
+				continue
+			}
+			for _, v := range strings.Split(p.value, ",") {
+				t, _, _, err := d.timeValue(property{name: p.name, params: p.params, value: v})
+				if err != nil {
+					return Item{}, fmt.Errorf("EXDATE: %w", err)
+				}
+				item.Exceptions = mergeException(item.Exceptions, schemas.Exception{
+					RecurrenceID: t,
+					Kind:         schemas.ExceptionCancel,
+				})
+			}
+		}
+	}
+
+	return item, nil
+}
+
+// alarm turns a VALARM into a reminder, reporting false if it can't be one.
+func (d *decoder) alarm(c *component, e schemas.Event) (schemas.Reminder, bool) {
+	p := c.prop("TRIGGER")
+	if p == nil || strings.EqualFold(c.value("ACTION"), "NONE") {
+		return schemas.Reminder{}, false
+	}
+
+	var at time.Time
+	if strings.EqualFold(p.params["VALUE"], "DATE-TIME") {
+		t, _, _, err := d.timeValue(*p)
+		if err != nil {
+			return schemas.Reminder{}, false
+		}
+		at = t
+	} else {
+		dur, err := parseDuration(p.value)
+		if err != nil {
+			return schemas.Reminder{}, false
+		}
+		from := e.StartTime
+		if strings.EqualFold(p.params["RELATED"], "END") && e.EndTime != nil {
+			from = *e.EndTime
+		}
+		at = addDuration(from, dur)
+	}
+
+	offset := e.StartTime.Sub(at)
+	if offset < 0 || offset > schemas.MaxReminderOffset {
+		return schemas.Reminder{}, false
+	}
+	return schemas.Reminder{OffsetMinutes: int(offset / time.Minute), Channel: schemas.ReminderAlert}, true
+}
+
+func (d *decoder) override(c *component, master schemas.Event) (schemas.Exception, error) {
+	recurrenceID, _, _, err := d.timeValue(*c.prop("RECURRENCE-ID"))
+	if err != nil {
+		return schemas.Exception{}, fmt.Errorf("RECURRENCE-ID: %w", err)
+	}
+
+	ex := schemas.Exception{RecurrenceID: recurrenceID, Kind: schemas.ExceptionCancel}
+	if strings.EqualFold(c.value("STATUS"), "CANCELLED") {
+		return ex, nil
+	}
+
+	ex.Kind = schemas.ExceptionMove
+	if p := c.prop("DTSTART"); p != nil {
+		start, _, _, err := d.timeValue(*p)
+		if err != nil {
+			return schemas.Exception{}, fmt.Errorf("DTSTART: %w", err)
+		}
+		ex.NewStart = &start
+	} else {
+		ex.NewStart = &recurrenceID
+	}
+
+	if p := c.prop("DTEND"); p != nil {
+		end, _, _, err := d.timeValue(*p)
+		if err != nil {
+			return schemas.Exception{}, fmt.Errorf("DTEND: %w", err)
+		}
+		ex.NewEnd = &end
+	} else if v := c.value("DURATION"); v != "" {
+		dur, err := parseDuration(v)
+		if err != nil {
+			return schemas.Exception{}, fmt.Errorf("DURATION: %w", err)
+		}
+		end := addDuration(*ex.NewStart, dur)
+		ex.NewEnd = &end
+	} else if master.EndTime != nil {
+		end := ex.NewStart.Add(master.EndTime.Sub(master.StartTime))
+		ex.NewEnd = &end
+	}
+
+	return ex, nil
+}
+
+// timeValue parses a DATE or DATE-TIME property. It reports whether the value
+// was a DATE and the location the value was expressed in.
+func (d *decoder) timeValue(p property) (time.Time, bool, *time.Location, error) {
+	v := strings.TrimSpace(p.value)
+	def := d.opts.DefaultLocation
+
+	if strings.EqualFold(p.params["VALUE"], "DATE") || (len(v) == 8 && !strings.Contains(v, "T")) {
+		t, err := time.ParseInLocation(dateFormat, v, def)
+		return t, true, def, err
+	}
+
+	if strings.HasSuffix(v, "Z") {
+		t, err := time.ParseInLocation(utcFormat, v, time.UTC)
+		return t, false, time.UTC, err
+	}
+
+	tzid := strings.Trim(p.params["TZID"], `"`)
+	if tzid == "" {
+		// Floating time
+		t, err := time.ParseInLocation(localFormat, v, def)
+		return t, false, def, err
+	}
+
+	if loc := d.location(tzid); loc != nil {
+		t, err := time.ParseInLocation(localFormat, v, loc)
+		return t, false, loc, err
+	}
+
+	if tz, ok := d.zones[tzid]; ok {
+		local, err := time.ParseInLocation(localFormat, v, time.UTC)
+		if err != nil {
+			return time.Time{}, false, nil, err
+		}
+		return local.Add(-tz.offsetAt(local)).UTC(), false, time.UTC, nil
+	}
+
+	return time.Time{}, false, nil, fmt.Errorf("unknown TZID %q", tzid)
+}
+
+// location resolves a TZID to an IANA zone, trying the VTIMEZONE's own hint
+// and a few common Windows names before giving up.
+func (d *decoder) location(tzid string) *time.Location {
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return loc
+	}
+	if tz, ok := d.zones[tzid]; ok && tz.ianaHint != "" {
+		if loc, err := time.LoadLocation(tz.ianaHint); err == nil {
+			return loc
+		}
+	}
+	if name, ok := windowsZones[tzid]; ok {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return nil
+}
+
+// LoadZone resolves an IANA or common Windows timezone name, or returns nil.
+func LoadZone(name string) *time.Location {
+	if loc, err := time.LoadLocation(name); err == nil && name != "" {
+		return loc
+	}
+	if iana, ok := windowsZones[name]; ok {
+		if loc, err := time.LoadLocation(iana); err == nil {
+			return loc
+		}
+	}
+	return nil
+}
+
+// WindowsZone returns the Windows name for an IANA zone, for services that only
+// accept those.
+func WindowsZone(iana string) (string, bool) {
+	for win, name := range windowsZones {
+		if name == iana {
+			return win, true
+		}
+	}
+	return "", false
+}
+
+// Common Outlook/Exchange TZIDs
+var windowsZones = map[string]string{
+	"GMT Standard Time":              "Europe/London",
+	"W. Europe Standard Time":        "Europe/Berlin",
+	"Romance Standard Time":          "Europe/Paris",
+	"Central Europe Standard Time":   "Europe/Budapest",
+	"E. Europe Standard Time":        "Europe/Chisinau",
+	"Eastern Standard Time":          "America/New_York",
+	"Central Standard Time":          "America/Chicago",
+	"Mountain Standard Time":         "America/Denver",
+	"Pacific Standard Time":          "America/Los_Angeles",
+	"AUS Eastern Standard Time":      "Australia/Sydney",
+	"New Zealand Standard Time":      "Pacific/Auckland",
+	"India Standard Time":            "Asia/Kolkata",
+	"Tokyo Standard Time":            "Asia/Tokyo",
+	"China Standard Time":            "Asia/Shanghai",
+	"UTC":                            "UTC",
+	"Greenwich Standard Time":        "Atlantic/Reykjavik",
+	"Central European Standard Time": "Europe/Warsaw",
+}
+
+// mergeException adds ex unless an exception for the same recurrence exists,
+// in which case the later definition wins.
+func mergeException(list []schemas.Exception, ex schemas.Exception) []schemas.Exception {
+	for i := range list {
+		if list[i].RecurrenceID.Equal(ex.RecurrenceID) {
+			list[i] = ex
+			return list
+		}
+	}
+	return append(list, ex)
+}
+
+func skippedFor(c *component, err error) Skipped {
+	return Skipped{
+		UID:     c.value("UID"),
+		Summary: unescapeText(c.value("SUMMARY")),
+		Reason:  err.Error(),
+	}
+}
+
+// --- content lines and components
+
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+type component struct {
+	name     string
+	props    []property
+	children []*component
+}
+
+func (c *component) prop(name string) *property {
+	for i := range c.props {
+		if c.props[i].name == name {
+			return &c.props[i]
+		}
+	}
+	return nil
+}
+
+func (c *component) value(name string) string {
+	if p := c.prop(name); p != nil {
+		return p.value
+	}
+	return ""
+}
+
+func (c *component) child(name string) *component {
+	for _, ch := range c.children {
+		if ch.name == name {
+			return ch
+		}
+		if found := ch.child(name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// parse unfolds content lines and builds the component tree.
+func parse(r io.Reader) (*component, error) {
+	root := &component{}
+	stack := []*component{root}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var pending string
+	lineNo := 0
+	flush := func() error {
+		if pending == "" {
+			return nil
+		}
+		p, err := parseLine(pending)
+		pending = ""
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		top := stack[len(stack)-1]
+		switch p.name {
+		case "BEGIN":
+			c := &component{name: strings.ToUpper(p.value)}
+			top.children = append(top.children, c)
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 1 || top.name != strings.ToUpper(p.value) {
+				return fmt.Errorf("line %d: unexpected END:%s", lineNo, p.value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			top.props = append(top.props, p)
+		}
+		return nil
+	}
+
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimRight(sc.Text(), "\r")
+		if lineNo == 1 {
+			line = strings.TrimPrefix(line, "\ufeff") // byte order mark
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			pending += line[1:]
+			continue
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+		pending = line
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("unterminated %s", stack[len(stack)-1].name)
+	}
+	if len(root.children) == 0 {
+		return nil, fmt.Errorf("no calendar data")
+	}
+	return root.children[0], nil
+}
+
+// parseLine splits "NAME;PARAM=a,b;P2=\"x:y\":value".
+func parseLine(line string) (property, error) {
+	p := property{params: make(map[string]string)}
+
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return p, fmt.Errorf("malformed content line %q", line)
+	}
+	p.name = strings.ToUpper(line[:i])
+	rest := line[i:]
+
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return p, fmt.Errorf("malformed parameter in %q", line)
+		}
+		key := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+
+		var val strings.Builder
+		inQuote := false
+		j := 0
+		for ; j < len(rest); j++ {
+			ch := rest[j]
+			if ch == '"' {
+				inQuote = !inQuote
+				continue
+			}
+			if !inQuote && (ch == ';' || ch == ':') {
+				break
+			}
+			val.WriteByte(ch)
+		}
+		p.params[key] = val.String()
+		rest = rest[j:]
+	}
+
+	if !strings.HasPrefix(rest, ":") {
+		return p, fmt.Errorf("missing value in %q", line)
+	}
+	p.value = rest[1:]
+	return p, nil
+}
+
+// unescapeText reverses escapeText.
+func unescapeText(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// --- durations
+
+type icsDuration struct {
+	days int // kept apart so all-day arithmetic follows the calendar
+	rest time.Duration
+}
+
+func parseDuration(v string) (icsDuration, error) {
+	var d icsDuration
+	s := strings.ToUpper(strings.TrimSpace(v))
+	neg := false
+	if strings.HasPrefix(s, "-") {
+		neg = true
+	}
+	s = strings.TrimLeft(s, "+-")
+	if !strings.HasPrefix(s, "P") {
+		return d, fmt.Errorf("invalid duration %q", v)
+	}
+	s = s[1:]
+
+	inTime := false
+	num := ""
+	for _, ch := range s {
+		switch {
+		case ch >= '0' && ch <= '9':
+			num += string(ch)
+			continue
+		case ch == 'T':
+			inTime = true
+			continue
+		}
+
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return d, fmt.Errorf("invalid duration %q", v)
+		}
+		num = ""
+		switch {
+		case ch == 'W' && !inTime:
+			d.days += 7 * n
+		case ch == 'D' && !inTime:
+			d.days += n
+		case ch == 'H' && inTime:
+			d.rest += time.Duration(n) * time.Hour
+		case ch == 'M' && inTime:
+			d.rest += time.Duration(n) * time.Minute
+		case ch == 'S' && inTime:
+			d.rest += time.Duration(n) * time.Second
+		default:
+			return d, fmt.Errorf("invalid duration %q", v)
+		}
+	}
+	if num != "" {
+		return d, fmt.Errorf("invalid duration %q", v)
+	}
+
+	if neg {
+		d.days, d.rest = -d.days, -d.rest
+	}
+	return d, nil
+}
+
+func addDuration(t time.Time, d icsDuration) time.Time {
+	return t.AddDate(0, 0, d.days).Add(d.rest)
+}
+
+// --- VTIMEZONE
+
+type observance struct {
+	start    time.Time // local wall time, expressed in UTC for arithmetic
+	offsetTo time.Duration
+	month    int
+	weekday  time.Weekday
+	nth      int // 1..5 or -1 for "last"; 0 when there is no yearly rule
+}
+
+type vtimezone struct {
+	id          string
+	ianaHint    string
+	observances []observance
+}
+
+func parseVTimezone(c *component) *vtimezone {
+	tz := &vtimezone{id: c.value("TZID"), ianaHint: c.value("X-LIC-LOCATION")}
+	if tz.id == "" {
+		return nil
+	}
+
+	for _, ch := range c.children {
+		if ch.name != "STANDARD" && ch.name != "DAYLIGHT" {
+			continue
+		}
+		start, err := time.ParseInLocation(localFormat, ch.value("DTSTART"), time.UTC)
+		if err != nil {
+			continue
+		}
+		offset, err := parseUTCOffset(ch.value("TZOFFSETTO"))
+		if err != nil {
+			continue
+		}
+
+		o := observance{start: start, offsetTo: offset}
+		if rule := ch.value("RRULE"); rule != "" {
+			o.month, o.weekday, o.nth = parseYearlyRule(rule)
+		}
+		tz.observances = append(tz.observances, o)
+	}
+
+	if len(tz.observances) == 0 {
+		return nil
+	}
+	return tz
+}
+
+// offsetAt returns the UTC offset in effect at the given local wall time.
+func (tz *vtimezone) offsetAt(local time.Time) time.Duration {
+	var best time.Time
+	offset := tz.observances[0].offsetTo
+
+	for _, o := range tz.observances {
+		for _, year := range []int{local.Year() - 1, local.Year()} {
+			onset := o.onsetIn(year)
+			if onset.IsZero() || onset.After(local) {
+				continue
+			}
+			if best.IsZero() || onset.After(best) {
+				best = onset
+				offset = o.offsetTo
+			}
+		}
+	}
+	return offset
+}
+
+func (o observance) onsetIn(year int) time.Time {
+	if year < o.start.Year() {
+		return time.Time{}
+	}
+	if o.nth == 0 {
+		if year == o.start.Year() {
+			return o.start
+		}
+		return time.Time{}
+	}
+
+	clock := time.Duration(o.start.Hour())*time.Hour + time.Duration(o.start.Minute())*time.Minute
+	if o.nth > 0 {
+		first := time.Date(year, time.Month(o.month), 1, 0, 0, 0, 0, time.UTC)
+		shift := (int(o.weekday) - int(first.Weekday()) + 7) % 7
+		day := first.AddDate(0, 0, shift+(o.nth-1)*7)
+		return day.Add(clock)
+	}
+
+	last := time.Date(year, time.Month(o.month)+1, 0, 0, 0, 0, 0, time.UTC)
+	shift := (int(last.Weekday()) - int(o.weekday) + 7) % 7
+	return last.AddDate(0, 0, -shift).Add(clock)
+}
+
+var weekdayCodes = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// parseYearlyRule understands the "FREQ=YEARLY;BYMONTH=m;BYDAY=nDD" form used
+// by virtually every VTIMEZONE in the wild.
+func parseYearlyRule(rule string) (int, time.Weekday, int) {
+	var month, nth int
+	var weekday time.Weekday
+	for _, part := range strings.Split(rule, ";") {
+		k, v, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(k) {
+		case "BYMONTH":
+			month, _ = strconv.Atoi(v)
+		case "BYDAY":
+			v = strings.ToUpper(v)
+			if len(v) < 2 {
+				continue
+			}
+			weekday = weekdayCodes[v[len(v)-2:]]
+			nth = 1
+			if prefix := v[:len(v)-2]; prefix != "" {
+				nth, _ = strconv.Atoi(prefix)
+			}
+		}
+	}
+	if month < 1 || month > 12 || nth == 0 {
+		return 0, 0, 0
+	}
+	return month, weekday, nth
+}
+
+// parseUTCOffset parses "+0100", "-0530" or "+013000".
+func parseUTCOffset(v string) (time.Duration, error) {
+	if len(v) != 5 && len(v) != 7 {
+		return 0, fmt.Errorf("invalid offset %q", v)
+	}
+	sign := time.Duration(1)
+	switch v[0] {
+	case '-':
+		sign = -1
+	case '+':
+	default:
+		return 0, fmt.Errorf("invalid offset %q", v)
+	}
+
+	h, err1 := strconv.Atoi(v[1:3])
+	m, err2 := strconv.Atoi(v[3:5])
+	sec := 0
+	var err3 error
+	if len(v) == 7 {
+		sec, err3 = strconv.Atoi(v[5:7])
+	}
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, fmt.Errorf("invalid offset %q", v)
+	}
+
+	return sign * (time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second), nil
+}
@@ -0,0 +1,246 @@
+package ical
+
+import (
+	"bufio"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"pical/database/schemas"
+	"pical/recurrence"
+)
+
+const (
+	prodID        = "-//PiCal//PiCal Calendar//EN"
+	maxLineOctets = 75
+	dateFormat    = "20060102"
+	localFormat   = "20060102T150405"
+	utcFormat     = "20060102T150405Z"
+)
+
+// Item pairs an event with the exceptions applied to its series.
+type Item struct {
+	// UID as read from a VEVENT; Encode derives its own from the eventID
+	UID        string
+	Event      schemas.Event
+	Exceptions []schemas.Exception
+	// Read from VALARMs; Encode doesn't write them
+	Reminders []schemas.Reminder
+}
+
+// Encode writes items as a single VCALENDAR (RFC 5545).
+//
+// Timed one-off events are written in UTC. Recurring timed events are written
+// as local time with an IANA TZID so the series keeps its wall-clock time
+// across DST changes; clients resolve the TZID from their own tz database.
+// Cancelled and paused occurrences become EXDATEs and moved occurrences
+// become extra VEVENTs carrying a RECURRENCE-ID.
+func Encode(w io.Writer, calName string, items []Item, now time.Time) error {
+	lw := &lineWriter{w: bufio.NewWriter(w)}
+
+	lw.line("BEGIN", "VCALENDAR")
+	lw.line("VERSION", "2.0")
+	lw.line("PRODID", prodID)
+	lw.line("CALSCALE", "GREGORIAN")
+	if calName != "" {
+		lw.line("X-WR-CALNAME", escapeText(calName))
+	}
+
+	stamp := now.UTC().Format(utcFormat)
+	for _, it := range items {
+		writeEvent(lw, it, stamp)
+	}
+
+	lw.line("END", "VCALENDAR")
+	return lw.flush()
+}
+
+func writeEvent(lw *lineWriter, it Item, stamp string) {
+	e := it.Event
+	loc := eventLocation(e)
+	recurring := e.Rrule != nil && *e.Rrule != ""
+
+	lw.line("BEGIN", "VEVENT")
+	lw.line("UID", eventUID(e))
+	lw.line("DTSTAMP", stamp)
+	writeTimes(lw, e, loc, recurring, e.StartTime, e.EndTime)
+	lw.line("SUMMARY", escapeText(e.Title))
+	if e.Notes != nil && *e.Notes != "" {
+		lw.line("DESCRIPTION", escapeText(*e.Notes))
+	}
+	if e.URL != nil {
+		lw.line("URL;VALUE=URI", *e.URL)
+	}
+	writeLocation(lw, e)
+	lw.line("X-PICAL-PERSON", escapeText(e.PersonName))
+
+	if recurring {
+		lw.line("RRULE", strings.TrimPrefix(*e.Rrule, "RRULE:"))
+		for _, ex := range it.Exceptions {
+			// A moved occurrence is excluded by its override VEVENT, not by EXDATE
+			if ex.Kind != schemas.ExceptionCancel {
+				continue
+			}
+			name, value := timeProp("EXDATE", e, loc, recurring, ex.RecurrenceID)
+			lw.line(name, value)
+		}
+		for _, t := range recurrence.PausedStarts(e) {
+			if slices.ContainsFunc(it.Exceptions, func(ex schemas.Exception) bool { return ex.RecurrenceID.Equal(t) }) {
+				continue
+			}
+			name, value := timeProp("EXDATE", e, loc, recurring, t)
+			lw.line(name, value)
+		}
+	}
+	lw.line("END", "VEVENT")
+
+	if !recurring {
+		return
+	}
+	for _, ex := range it.Exceptions {
+		if ex.Kind != schemas.ExceptionMove || ex.NewStart == nil {
+			continue
+		}
+		end := ex.NewEnd
+		if end == nil && e.EndTime != nil {
+			moved := ex.NewStart.Add(e.EndTime.Sub(e.StartTime))
+			end = &moved
+		}
+
+		lw.line("BEGIN", "VEVENT")
+		lw.line("UID", eventUID(e))
+		lw.line("DTSTAMP", stamp)
+		name, value := timeProp("RECURRENCE-ID", e, loc, recurring, ex.RecurrenceID)
+		lw.line(name, value)
+		writeTimes(lw, e, loc, recurring, *ex.NewStart, end)
+		lw.line("SUMMARY", escapeText(e.Title))
+		if e.Notes != nil && *e.Notes != "" {
+			lw.line("DESCRIPTION", escapeText(*e.Notes))
+		}
+		writeLocation(lw, e)
+		lw.line("X-PICAL-PERSON", escapeText(e.PersonName))
+		lw.line("END", "VEVENT")
+	}
+}
+
+func writeLocation(lw *lineWriter, e schemas.Event) {
+	if e.Location == nil || *e.Location == "" {
+		return
+	}
+	lw.line("LOCATION", escapeText(*e.Location))
+	if e.Latitude != nil && e.Longitude != nil {
+		lw.line("GEO", strconv.FormatFloat(*e.Latitude, 'f', -1, 64)+";"+strconv.FormatFloat(*e.Longitude, 'f', -1, 64))
+	}
+}
+
+func writeTimes(lw *lineWriter, e schemas.Event, loc *time.Location, recurring bool, start time.Time, end *time.Time) {
+	name, value := timeProp("DTSTART", e, loc, recurring, start)
+	lw.line(name, value)
+
+	if e.AllDay {
+		// DTEND is exclusive for dates; an all-day event always covers at least one day
+		startDay := dayOf(start, loc)
+		endDay := startDay.AddDate(0, 0, 1)
+		if end != nil {
+			last := end.In(loc)
+			endDay = dayOf(last, loc)
+			if !last.Equal(endDay) {
+				endDay = endDay.AddDate(0, 0, 1)
+			}
+			if !endDay.After(startDay) {
+				endDay = startDay.AddDate(0, 0, 1)
+			}
+		}
+		lw.line("DTEND;VALUE=DATE", endDay.Format(dateFormat))
+		return
+	}
+
+	if end != nil {
+		name, value := timeProp("DTEND", e, loc, recurring, *end)
+		lw.line(name, value)
+	}
+}
+
+// timeProp formats a date-time property using the event's all-day and timezone rules.
+func timeProp(name string, e schemas.Event, loc *time.Location, recurring bool, t time.Time) (string, string) {
+	switch {
+	case e.AllDay:
+		return name + ";VALUE=DATE", t.In(loc).Format(dateFormat)
+	case recurring && loc != time.UTC:
+		return name + ";TZID=" + loc.String(), t.In(loc).Format(localFormat)
+	default:
+		return name, t.UTC().Format(utcFormat)
+	}
+}
+
+func eventLocation(e schemas.Event) *time.Location {
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func eventUID(e schemas.Event) string {
+	return e.EventID + "@pical"
+}
+
+func dayOf(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// escapeText escapes a TEXT value per RFC 5545 section 3.3.11.
+func escapeText(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	)
+	return r.Replace(s)
+}
+
+// lineWriter emits CRLF-terminated content lines, folding them at 75 octets.
+type lineWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (lw *lineWriter) line(name, value string) {
+	if lw.err != nil {
+		return
+	}
+
+	s := name + ":" + value
+	limit := maxLineOctets
+	for len(s) > limit {
+		// Never split a multi-byte UTF-8 sequence across a fold
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		lw.write(s[:cut] + "\r\n ")
+		s = s[cut:]
+		limit = maxLineOctets - 1 // continuation lines start with a space
+	}
+	lw.write(s + "\r\n")
+}
+
+func (lw *lineWriter) write(s string) {
+	if lw.err != nil {
+		return
+	}
+	_, lw.err = lw.w.WriteString(s)
+}
+
+func (lw *lineWriter) flush() error {
+	if lw.err != nil {
+		return lw.err
+	}
+	return lw.w.Flush()
+}
@@ -0,0 +1,215 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"pical/database/schemas"
+	"pical/recurrence"
+)
+
+func TestRoundTrip(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2025, month, day, hour, 0, 0, 0, london)
+	}
+	ptr := func(t time.Time) *time.Time { return &t }
+	str := func(s string) *string { return &s }
+
+	items := []Item{
+		{
+			// Over the spring change, with a cancelled, a moved and a paused occurrence
+			Event: schemas.Event{
+				EventID:    "swimming",
+				PersonName: "Sam",
+				Title:      "Swimming",
+				Notes:      str("Bring goggles, a towel; and a snack\nPickup at 7"),
+				Location:   str("Leisure Centre, High St"),
+				URL:        str("https://example.com/swim"),
+				Timezone:   "Europe/London",
+				Rrule:      str("FREQ=WEEKLY;BYDAY=TU;COUNT=6"),
+				StartTime:  at(time.March, 18, 18),
+				EndTime:    ptr(at(time.March, 18, 19)),
+				Pauses:     schemas.Pauses{{From: at(time.April, 15, 0), Until: at(time.April, 16, 0)}},
+			},
+			Exceptions: []schemas.Exception{
+				{RecurrenceID: at(time.March, 25, 18), Kind: schemas.ExceptionCancel},
+				{RecurrenceID: at(time.April, 1, 18), Kind: schemas.ExceptionMove, NewStart: ptr(at(time.April, 2, 17)), NewEnd: ptr(at(time.April, 2, 18))},
+			},
+		},
+		{
+			Event: schemas.Event{
+				EventID:    "birthday",
+				PersonName: "Alex",
+				Title:      "Alex's birthday",
+				Timezone:   "Europe/London",
+				AllDay:     true,
+				Rrule:      str("FREQ=YEARLY"),
+				StartTime:  at(time.March, 30, 0),
+				EndTime:    ptr(at(time.March, 31, 0)),
+			},
+		},
+		{
+			Event: schemas.Event{
+				EventID:    "trip",
+				PersonName: "Sam",
+				Title:      "Café ☕ and a very long title that has to be folded over more than one content line",
+				Timezone:   "Europe/London",
+				StartTime:  at(time.April, 5, 10),
+				EndTime:    ptr(at(time.April, 5, 12)),
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, "Family", items, at(time.March, 1, 0)); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	for _, line := range strings.SplitAfter(buf.String(), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if !strings.HasSuffix(line, "\r\n") || len(line) > maxLineOctets+2 {
+			t.Errorf("bad content line %q", line)
+		}
+	}
+
+	decoded, err := Decode(&buf, DecodeOptions{DefaultLocation: london})
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if len(decoded.Skipped) != 0 {
+		t.Errorf("skipped %+v", decoded.Skipped)
+	}
+	if len(decoded.Items) != len(items) {
+		t.Fatalf("decoded %d items, want %d", len(decoded.Items), len(items))
+	}
+
+	from, to := at(time.January, 1, 0), at(time.December, 31, 0)
+	for i, it := range items {
+		want, got := it.Event, decoded.Items[i].Event
+		if uid := decoded.Items[i].UID; uid != want.EventID+"@pical" {
+			t.Errorf("%s: UID %q", want.EventID, uid)
+		}
+		// Timed one-off events are written in UTC and come back in it
+		zone := want.Timezone
+		if want.Rrule == nil && !want.AllDay {
+			zone = "UTC"
+		}
+		if got.Title != want.Title || got.PersonName != want.PersonName || got.Timezone != zone || got.AllDay != want.AllDay {
+			t.Errorf("%s: decoded %+v", want.EventID, got)
+		}
+		if !got.StartTime.Equal(want.StartTime) || got.EndTime == nil || !got.EndTime.Equal(*want.EndTime) {
+			t.Errorf("%s: times %v to %v, want %v to %v", want.EventID, got.StartTime, got.EndTime, want.StartTime, want.EndTime)
+		}
+		if (got.Rrule == nil) != (want.Rrule == nil) || (got.Rrule != nil && *got.Rrule != *want.Rrule) {
+			t.Errorf("%s: rrule %v, want %v", want.EventID, got.Rrule, want.Rrule)
+		}
+		for _, field := range [][2]*string{{got.Notes, want.Notes}, {got.Location, want.Location}, {got.URL, want.URL}} {
+			if (field[0] == nil) != (field[1] == nil) || (field[0] != nil && *field[0] != *field[1]) {
+				t.Errorf("%s: got %v, want %v", want.EventID, field[0], field[1])
+			}
+		}
+
+		// Pauses come back as cancelled occurrences, so the decoded series
+		// has the same occurrences without them
+		before := recurrence.Expand(want, it.Exceptions, from, to)
+		after := recurrence.Expand(got, decoded.Items[i].Exceptions, from, to)
+		if len(before) != len(after) {
+			t.Fatalf("%s: %d occurrences after the round trip, want %d", want.EventID, len(after), len(before))
+		}
+		for j := range before {
+			b, a := before[j], after[j]
+			if !a.StartTime.Equal(b.StartTime) || !a.RecurrenceID.Equal(b.RecurrenceID) || !a.EndTime.Equal(*b.EndTime) || a.Moved != b.Moved {
+				t.Errorf("%s: occurrence %d is %+v, want %+v", want.EventID, j, a, b)
+			}
+		}
+	}
+
+	exs := decoded.Items[0].Exceptions
+	wantExs := []struct {
+		id   time.Time
+		kind schemas.ExceptionType
+	}{
+		{at(time.March, 25, 18), schemas.ExceptionCancel},
+		{at(time.April, 1, 18), schemas.ExceptionMove},
+		{at(time.April, 15, 18), schemas.ExceptionCancel},
+	}
+	if len(exs) != len(wantExs) {
+		t.Fatalf("decoded exceptions %+v", exs)
+	}
+	for i, w := range wantExs {
+		if !exs[i].RecurrenceID.Equal(w.id) || exs[i].Kind != w.kind {
+			t.Errorf("exception %d is %v at %v, want %v at %v", i, exs[i].Kind, exs[i].RecurrenceID, w.kind, w.id)
+		}
+	}
+}
+
+func TestEncodeTimes(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrule := "FREQ=DAILY"
+	end := time.Date(2025, 4, 1, 19, 0, 0, 0, london)
+	tests := []struct {
+		name  string
+		event schemas.Event
+		want  []string
+	}{
+		{
+			name: "one-off in utc",
+			event: schemas.Event{
+				Timezone:  "Europe/London",
+				StartTime: time.Date(2025, 4, 1, 18, 0, 0, 0, london),
+				EndTime:   &end,
+			},
+			want: []string{"DTSTART:20250401T170000Z", "DTEND:20250401T180000Z"},
+		},
+		{
+			name: "recurring in local time",
+			event: schemas.Event{
+				Timezone:  "Europe/London",
+				Rrule:     &rrule,
+				StartTime: time.Date(2025, 4, 1, 18, 0, 0, 0, london),
+				EndTime:   &end,
+			},
+			want: []string{"DTSTART;TZID=Europe/London:20250401T180000", "DTEND;TZID=Europe/London:20250401T190000", "RRULE:FREQ=DAILY"},
+		},
+		{
+			name: "all-day without an end covers one day",
+			event: schemas.Event{
+				Timezone:  "Europe/London",
+				AllDay:    true,
+				StartTime: time.Date(2025, 3, 30, 0, 0, 0, 0, london),
+			},
+			want: []string{"DTSTART;VALUE=DATE:20250330", "DTEND;VALUE=DATE:20250331"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.event.EventID, tt.event.Title, tt.event.PersonName = "e", "Event", "Sam"
+			var buf bytes.Buffer
+			if err := Encode(&buf, "", []Item{{Event: tt.event}}, time.Now()); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			lines := strings.Split(buf.String(), "\r\n")
+			for _, want := range tt.want {
+				found := false
+				for _, line := range lines {
+					found = found || line == want
+				}
+				if !found {
+					t.Errorf("no %q in\n%s", want, buf.String())
+				}
+			}
+		})
+	}
+}
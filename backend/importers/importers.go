@@ -0,0 +1,364 @@
+// Package importers maps CSV exports from other family-calendar apps onto
+// PiCal events.
+//
+// None of these apps publish a stable export format, so each source is only a
+// preset: a guess at which column holds which field and how dates are written.
+// Callers preview the guessed Mapping against the uploaded file, let the user
+// correct it, and then import with the reviewed Mapping.
+package importers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pical/database/schemas"
+)
+
+// PiCal fields a CSV column can be mapped onto.
+const (
+	FieldTitle     = "title"
+	FieldPerson    = "person"
+	FieldStartDate = "startDate"
+	FieldStartTime = "startTime"
+	FieldEndDate   = "endDate"
+	FieldEndTime   = "endTime"
+	FieldAllDay    = "allDay"
+	FieldNotes     = "notes"
+	FieldDuration  = "duration" // minutes, used when there is no end column
+)
+
+var Fields = []string{
+	FieldTitle, FieldPerson, FieldStartDate, FieldStartTime,
+	FieldEndDate, FieldEndTime, FieldAllDay, FieldNotes, FieldDuration,
+}
+
+// Mapping says how to read one CSV export.
+type Mapping struct {
+	// PiCal field -> CSV header
+	Fields map[string]string `json:"fields"`
+	// Go time layouts; when empty a list of common layouts is tried
+	DateLayout string `json:"dateLayout,omitempty"`
+	TimeLayout string `json:"timeLayout,omitempty"`
+	// Zone the export's wall-clock times are in
+	Timezone string `json:"timezone"`
+	// Used for rows without a person column or value
+	DefaultPerson string `json:"defaultPerson,omitempty"`
+}
+
+// Source is a known export format.
+type Source struct {
+	Name string
+	// Candidate headers per field, matched case-insensitively in order
+	Headers    map[string][]string
+	DateLayout string
+}
+
+var sources = map[string]Source{
+	"cozi": {
+		Name: "cozi",
+		Headers: map[string][]string{
+			FieldTitle:     {"Subject", "Title"},
+			FieldPerson:    {"Who", "Attendees", "Family Members"},
+			FieldStartDate: {"Start Date"},
+			FieldStartTime: {"Start Time"},
+			FieldEndDate:   {"End Date"},
+			FieldEndTime:   {"End Time"},
+			FieldAllDay:    {"All Day Event", "All day event", "All Day"},
+			FieldNotes:     {"Notes", "Description"},
+		},
+		DateLayout: "1/2/2006",
+	},
+	"skylight": {
+		Name: "skylight",
+		Headers: map[string][]string{
+			FieldTitle:     {"Title", "Event", "Name"},
+			FieldPerson:    {"Profile", "Profiles", "Category", "Assigned To"},
+			FieldStartDate: {"Start Date", "Date", "Start"},
+			FieldStartTime: {"Start Time"},
+			FieldEndDate:   {"End Date", "End"},
+			FieldEndTime:   {"End Time"},
+			FieldAllDay:    {"All Day", "All-Day"},
+			FieldNotes:     {"Notes", "Description"},
+		},
+	},
+	"ourhome": {
+		Name: "ourhome",
+		Headers: map[string][]string{
+			FieldTitle:     {"Event", "Title", "Name"},
+			FieldPerson:    {"Assigned To", "Member", "Who"},
+			FieldStartDate: {"Date", "Start Date"},
+			FieldStartTime: {"Time", "Start Time"},
+			FieldDuration:  {"Duration", "Duration (min)"},
+			FieldAllDay:    {"All Day"},
+			FieldNotes:     {"Notes", "Description"},
+		},
+	},
+}
+
+// Lookup returns the preset for a source name such as "cozi".
+func Lookup(name string) (Source, bool) {
+	src, ok := sources[strings.ToLower(name)]
+	return src, ok
+}
+
+// SourceNames lists the supported sources.
+func SourceNames() []string {
+	names := make([]string, 0, len(sources))
+	for n := range sources {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Guess builds a Mapping for headers from the source's candidate names.
+func (src Source) Guess(headers []string) Mapping {
+	m := Mapping{Fields: make(map[string]string), DateLayout: src.DateLayout}
+	for _, field := range Fields {
+		for _, want := range src.Headers[field] {
+			if h, ok := findHeader(headers, want); ok {
+				m.Fields[field] = h
+				break
+			}
+		}
+	}
+	return m
+}
+
+// RowError explains why a CSV row was not turned into an event.
+type RowError struct {
+	Row    int    `json:"row"` // 1-based, counting the header row
+	Title  string `json:"title,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Result is the outcome of applying a Mapping to a file.
+type Result struct {
+	Headers []string
+	Events  []schemas.Event
+	Errors  []RowError
+}
+
+// ReadAll reads the whole CSV file, header row first.
+func ReadAll(r io.Reader) ([][]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read csv: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("csv is empty")
+	}
+	// Excel likes to prefix a byte order mark
+	records[0][0] = strings.TrimPrefix(records[0][0], "\ufeff")
+	return records, nil
+}
+
+// Apply maps every data row of records onto events.
+func Apply(records [][]string, m Mapping) (Result, error) {
+	res := Result{Headers: records[0]}
+
+	loc := time.UTC
+	if m.Timezone != "" {
+		l, err := time.LoadLocation(m.Timezone)
+		if err != nil {
+			return res, fmt.Errorf("unknown timezone %q", m.Timezone)
+		}
+		loc = l
+	}
+
+	index := make(map[string]int, len(m.Fields))
+	for field, header := range m.Fields {
+		if header == "" {
+			continue
+		}
+		i, ok := headerIndex(res.Headers, header)
+		if !ok {
+			return res, fmt.Errorf("mapped column %q for %s is not in the file", header, field)
+		}
+		index[field] = i
+	}
+	if _, ok := index[FieldTitle]; !ok {
+		return res, fmt.Errorf("a column must be mapped to %s", FieldTitle)
+	}
+	if _, ok := index[FieldStartDate]; !ok {
+		return res, fmt.Errorf("a column must be mapped to %s", FieldStartDate)
+	}
+
+	for i, rec := range records[1:] {
+		rowNo := i + 2
+		get := func(field string) string {
+			col, ok := index[field]
+			if !ok || col >= len(rec) {
+				return ""
+			}
+			return strings.TrimSpace(rec[col])
+		}
+
+		if strings.TrimSpace(strings.Join(rec, "")) == "" {
+			continue
+		}
+
+		e, err := rowEvent(get, m, loc)
+		if err != nil {
+			res.Errors = append(res.Errors, RowError{Row: rowNo, Title: get(FieldTitle), Reason: err.Error()})
+			continue
+		}
+		if err := schemas.ValidateEvent(e); err != nil {
+			res.Errors = append(res.Errors, RowError{Row: rowNo, Title: e.Title, Reason: err.Error()})
+			continue
+		}
+		res.Events = append(res.Events, e)
+	}
+
+	return res, nil
+}
+
+func rowEvent(get func(string) string, m Mapping, loc *time.Location) (schemas.Event, error) {
+	e := schemas.Event{
+		Title:      get(FieldTitle),
+		PersonName: get(FieldPerson),
+		Timezone:   loc.String(),
+	}
+	if e.PersonName == "" {
+		e.PersonName = m.DefaultPerson
+	}
+	if notes := get(FieldNotes); notes != "" {
+		e.Notes = &notes
+	}
+
+	startDate, err := parseDate(get(FieldStartDate), m.DateLayout, loc)
+	if err != nil {
+		return e, fmt.Errorf("start date: %w", err)
+	}
+
+	startClock := get(FieldStartTime)
+	e.AllDay = parseBool(get(FieldAllDay)) || startClock == ""
+
+	if e.AllDay {
+		e.StartTime = startDate
+		endDate := startDate
+		if v := get(FieldEndDate); v != "" {
+			if endDate, err = parseDate(v, m.DateLayout, loc); err != nil {
+				return e, fmt.Errorf("end date: %w", err)
+			}
+		}
+		// Stored all-day ends are exclusive midnights
+		end := endDate.AddDate(0, 0, 1)
+		e.EndTime = &end
+		return e, nil
+	}
+
+	start, err := withClock(startDate, startClock, m.TimeLayout)
+	if err != nil {
+		return e, fmt.Errorf("start time: %w", err)
+	}
+	e.StartTime = start
+
+	switch {
+	case get(FieldEndTime) != "":
+		endDate := startDate
+		if v := get(FieldEndDate); v != "" {
+			if endDate, err = parseDate(v, m.DateLayout, loc); err != nil {
+				return e, fmt.Errorf("end date: %w", err)
+			}
+		}
+		end, err := withClock(endDate, get(FieldEndTime), m.TimeLayout)
+		if err != nil {
+			return e, fmt.Errorf("end time: %w", err)
+		}
+		e.EndTime = &end
+	case get(FieldDuration) != "":
+		minutes, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(get(FieldDuration)), " min"))
+		if err != nil || minutes < 0 {
+			return e, fmt.Errorf("duration %q is not a number of minutes", get(FieldDuration))
+		}
+		end := start.Add(time.Duration(minutes) * time.Minute)
+		e.EndTime = &end
+	}
+
+	return e, nil
+}
+
+var dateLayouts = []string{
+	"2006-01-02",
+	"1/2/2006",
+	"01/02/2006",
+	"1/2/06",
+	"2 Jan 2006",
+	"Jan 2, 2006",
+	"January 2, 2006",
+	"Mon, Jan 2, 2006",
+}
+
+var timeLayouts = []string{
+	"15:04",
+	"15:04:05",
+	"3:04 PM",
+	"3:04PM",
+	"3:04:05 PM",
+	"3 PM",
+	"3PM",
+}
+
+func parseDate(v, layout string, loc *time.Location) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, fmt.Errorf("missing")
+	}
+	layouts := dateLayouts
+	if layout != "" {
+		layouts = []string{layout}
+	}
+	for _, l := range layouts {
+		if t, err := time.ParseInLocation(l, v, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised date %q", v)
+}
+
+func withClock(day time.Time, v, layout string) (time.Time, error) {
+	layouts := timeLayouts
+	if layout != "" {
+		layouts = []string{layout}
+	}
+	up := strings.ToUpper(v)
+	for _, l := range layouts {
+		if t, err := time.Parse(l, up); err == nil {
+			return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), t.Second(), 0, day.Location()), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised time %q", v)
+}
+
+func parseBool(v string) bool {
+	switch strings.ToLower(v) {
+	case "true", "yes", "y", "1", "x":
+		return true
+	}
+	return false
+}
+
+func findHeader(headers []string, want string) (string, bool) {
+	if i, ok := headerIndex(headers, want); ok {
+		return headers[i], true
+	}
+	return "", false
+}
+
+func headerIndex(headers []string, want string) (int, bool) {
+	for i, h := range headers {
+		if strings.EqualFold(strings.TrimSpace(h), strings.TrimSpace(want)) {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,411 @@
+// Package integrations syncs PiCal events with external calendar services.
+//
+// Each service implements Provider; the Engine owns the provider-independent
+// parts: token refresh, mapping local events to remote ones through
+// sync_links, conflict detection and pushing local changes back.
+package integrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"pical/database/schemas"
+)
+
+// ErrCursorExpired is returned by Provider.Pull when the stored cursor is no
+// longer accepted and a full pull is needed.
+var ErrCursorExpired = errors.New("sync cursor expired")
+
+// ErrUnsupported is returned by Provider.Create and Update for events the
+// service can't represent; the event is left unsynced rather than failing the run.
+var ErrUnsupported = errors.New("not supported by provider")
+
+// RemoteRef identifies a remote event and its version after a write.
+type RemoteRef struct {
+	ID      string
+	Version string
+}
+
+// Change is one remote event as reported by a pull.
+type Change struct {
+	RemoteRef
+	Deleted bool
+	// Full series data; unset for instance-level changes
+	Event      schemas.Event
+	Exceptions []schemas.Exception
+	// An edit to a single occurrence of MasterID rather than a series
+	MasterID  string
+	Exception *schemas.Exception
+}
+
+// Pull is the result of fetching remote changes.
+type Pull struct {
+	Changes []Change
+	// Cursor to store for the next incremental pull
+	Cursor string
+	// Full is set when Changes lists every remote event, so anything linked
+	// but absent has been deleted remotely
+	Full bool
+}
+
+// Calendar is a remote calendar an account can sync with.
+type Calendar struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Primary  bool   `json:"primary,omitempty"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+}
+
+// Provider is an external calendar service.
+type Provider interface {
+	Name() string
+	OAuth() OAuthConfig
+	Calendars(ctx context.Context, token string) ([]Calendar, error)
+	// Pull returns changes since st.SyncToken, or everything when it's nil
+	Pull(ctx context.Context, token string, st schemas.SyncState) (Pull, error)
+	Create(ctx context.Context, token string, st schemas.SyncState, e schemas.Event, exs []schemas.Exception) (RemoteRef, error)
+	Update(ctx context.Context, token string, st schemas.SyncState, remoteID string, e schemas.Event, exs []schemas.Exception) (RemoteRef, error)
+	Delete(ctx context.Context, token string, st schemas.SyncState, remoteID string) error
+}
+
+// Result summarises a sync run.
+type Result struct {
+	Pulled    int `json:"pulled"`
+	Pushed    int `json:"pushed"`
+	Deleted   int `json:"deleted"`
+	Conflicts int `json:"conflicts"`
+}
+
+// Engine runs syncs against the database.
+type Engine struct {
+	DB *sql.DB
+}
+
+// Sync pulls remote changes into PiCal and then pushes local changes for
+// st's person back, recording the outcome on the sync_state row.
+func (en *Engine) Sync(ctx context.Context, p Provider, st schemas.SyncState) (Result, error) {
+	res, cursor, err := en.sync(ctx, p, &st)
+
+	var cur *string
+	if err == nil && cursor != "" {
+		cur = &cursor
+	}
+	if recErr := schemas.RecordSyncRun(ctx, en.DB, st.SyncID, cur, time.Now(), res.Conflicts, err); recErr != nil {
+		log.Printf("%s sync %s: %v", p.Name(), st.SyncID, recErr)
+	}
+	return res, err
+}
+
+func (en *Engine) sync(ctx context.Context, p Provider, st *schemas.SyncState) (Result, string, error) {
+	var res Result
+	if st.CalendarID == "" {
+		return res, "", fmt.Errorf("no remote calendar selected")
+	}
+
+	token, err := AccessToken(ctx, en.DB, p.OAuth(), st)
+	if err != nil {
+		return res, "", err
+	}
+
+	pull, err := p.Pull(ctx, token, *st)
+	if errors.Is(err, ErrCursorExpired) {
+		if err := schemas.ResetSyncCursor(ctx, en.DB, st.SyncID); err != nil {
+			return res, "", err
+		}
+		st.SyncToken = nil
+		pull, err = p.Pull(ctx, token, *st)
+	}
+	if err != nil {
+		return res, "", fmt.Errorf("pull: %w", err)
+	}
+
+	links, err := schemas.ListSyncLinks(ctx, en.DB, st.SyncID)
+	if err != nil {
+		return res, "", err
+	}
+	byRemote := make(map[string]schemas.SyncLink, len(links))
+	for _, l := range links {
+		byRemote[l.RemoteID] = l
+	}
+
+	if err := en.applyPull(ctx, st, pull, byRemote, &res); err != nil {
+		return res, "", err
+	}
+	if err := en.push(ctx, p, token, st, &res); err != nil {
+		return res, "", err
+	}
+
+	return res, pull.Cursor, nil
+}
+
+func (en *Engine) applyPull(ctx context.Context, st *schemas.SyncState, pull Pull, byRemote map[string]schemas.SyncLink, res *Result) error {
+	seen := make(map[string]bool, len(pull.Changes))
+	var instances []Change
+
+	for _, ch := range pull.Changes {
+		if ch.MasterID != "" {
+			instances = append(instances, ch)
+			continue
+		}
+		seen[ch.ID] = true
+
+		link, linked := byRemote[ch.ID]
+		if ch.Deleted {
+			if linked {
+				if err := en.remoteDeleted(ctx, st, link, res); err != nil {
+					return err
+				}
+				delete(byRemote, ch.ID)
+			}
+			continue
+		}
+		if linked && link.RemoteVersion == ch.Version {
+			continue // our own write echoing back
+		}
+
+		ch.Event.PersonID, ch.Event.PersonName = st.PersonID, st.PersonName
+		if err := schemas.ValidateEvent(ch.Event); err != nil {
+			log.Printf("%s sync %s: skipping remote event %s: %v", st.Provider, st.SyncID, ch.ID, err)
+			continue
+		}
+
+		if !linked {
+			created, err := schemas.CreateEvent(ctx, en.DB, ch.Event)
+			if err != nil {
+				return err
+			}
+			if err := schemas.ReplaceEventExceptions(ctx, en.DB, created.EventID, ch.Exceptions); err != nil {
+				return err
+			}
+			link = schemas.SyncLink{SyncID: st.SyncID, EventID: created.EventID, RemoteID: ch.ID, RemoteVersion: ch.Version, LocalHash: EventHash(created)}
+			if err := schemas.UpsertSyncLink(ctx, en.DB, link); err != nil {
+				return err
+			}
+			byRemote[ch.ID] = link
+			res.Pulled++
+			continue
+		}
+
+		local, err := schemas.GetEvent(ctx, en.DB, link.EventID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue // deleted locally; push will propagate that
+		}
+		if err != nil {
+			return err
+		}
+
+		if EventHash(*local) != link.LocalHash {
+			res.Conflicts++
+			if st.ConflictPolicy == schemas.ConflictLocalWins {
+				// Keep the local edit; push overwrites the remote copy
+				link.RemoteVersion = ch.Version
+				if err := schemas.UpsertSyncLink(ctx, en.DB, link); err != nil {
+					return err
+				}
+				byRemote[ch.ID] = link
+				continue
+			}
+		}
+
+		// Filing into a PiCal calendar, tagging, colour overrides, chores and focus time are local-only
+		ch.Event.CalendarID, ch.Event.Tags, ch.Event.Color = local.CalendarID, local.Tags, local.Color
+		ch.Event.Task = local.Task
+		// Unless the remote edit made it something that can't roll over
+		ch.Event.Rollover = local.Rollover && ch.Event.AllDay && ch.Event.Rrule != nil && *ch.Event.Rrule != ""
+		ch.Event.Focus = local.Focus && !ch.Event.AllDay && ch.Event.EndTime != nil && ch.Event.EndTime.After(ch.Event.StartTime)
+		updated, err := schemas.UpdateEvent(ctx, en.DB, link.EventID, ch.Event)
+		if err != nil {
+			return err
+		}
+		if err := schemas.ReplaceEventExceptions(ctx, en.DB, link.EventID, ch.Exceptions); err != nil {
+			return err
+		}
+		link.RemoteVersion, link.LocalHash = ch.Version, EventHash(updated)
+		if err := schemas.UpsertSyncLink(ctx, en.DB, link); err != nil {
+			return err
+		}
+		byRemote[ch.ID] = link
+		res.Pulled++
+	}
+
+	for _, ch := range instances {
+		link, ok := byRemote[ch.MasterID]
+		if !ok || ch.Exception == nil {
+			continue
+		}
+		ex := *ch.Exception
+		ex.EventID = link.EventID
+		if err := schemas.UpsertException(ctx, en.DB, ex); err != nil {
+			return err
+		}
+		res.Pulled++
+	}
+
+	if pull.Full {
+		for remoteID, link := range byRemote {
+			if seen[remoteID] {
+				continue
+			}
+			if err := en.remoteDeleted(ctx, st, link, res); err != nil {
+				return err
+			}
+			delete(byRemote, remoteID)
+		}
+	}
+
+	return nil
+}
+
+// remoteDeleted removes the local copy of a remotely deleted event, unless it
+// was edited locally and local edits win, in which case it is re-created remotely.
+func (en *Engine) remoteDeleted(ctx context.Context, st *schemas.SyncState, link schemas.SyncLink, res *Result) error {
+	local, err := schemas.GetEvent(ctx, en.DB, link.EventID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if local != nil && EventHash(*local) != link.LocalHash && st.ConflictPolicy == schemas.ConflictLocalWins {
+		res.Conflicts++
+	} else if local != nil {
+		if err := schemas.DeleteEvent(ctx, en.DB, link.EventID, 0); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		res.Deleted++
+	}
+
+	// Dropping the link makes push treat a surviving local event as new
+	return schemas.DeleteSyncLink(ctx, en.DB, link.SyncID, link.EventID)
+}
+
+func (en *Engine) push(ctx context.Context, p Provider, token string, st *schemas.SyncState, res *Result) error {
+	locals, err := schemas.ListPersonEvents(ctx, en.DB, st.PersonID)
+	if err != nil {
+		return err
+	}
+	links, err := schemas.ListSyncLinks(ctx, en.DB, st.SyncID)
+	if err != nil {
+		return err
+	}
+
+	byEvent := make(map[string]schemas.SyncLink, len(links))
+	for _, l := range links {
+		byEvent[l.EventID] = l
+	}
+
+	// Events synced through another account belong to that account
+	foreign, err := en.linkedElsewhere(ctx, st.SyncID)
+	if err != nil {
+		return err
+	}
+
+	exists := make(map[string]bool, len(locals))
+	for _, e := range locals {
+		exists[e.EventID] = true
+		link, linked := byEvent[e.EventID]
+		if !linked && foreign[e.EventID] {
+			continue
+		}
+
+		hash := EventHash(e)
+		if linked && hash == link.LocalHash {
+			continue
+		}
+
+		exs, err := schemas.ListEventExceptions(ctx, en.DB, e.EventID)
+		if err != nil {
+			return err
+		}
+
+		var ref RemoteRef
+		if linked {
+			ref, err = p.Update(ctx, token, *st, link.RemoteID, e, exs)
+		} else {
+			ref, err = p.Create(ctx, token, *st, e, exs)
+		}
+		if errors.Is(err, ErrUnsupported) {
+			log.Printf("%s sync %s: not pushing event %s: %v", p.Name(), st.SyncID, e.EventID, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("push %s: %w", e.EventID, err)
+		}
+
+		link = schemas.SyncLink{SyncID: st.SyncID, EventID: e.EventID, RemoteID: ref.ID, RemoteVersion: ref.Version, LocalHash: hash}
+		if err := schemas.UpsertSyncLink(ctx, en.DB, link); err != nil {
+			return err
+		}
+		res.Pushed++
+	}
+
+	// Links whose event is gone (or changed person) were deleted locally
+	for eventID, link := range byEvent {
+		if exists[eventID] {
+			continue
+		}
+		if err := p.Delete(ctx, token, *st, link.RemoteID); err != nil {
+			return fmt.Errorf("push delete %s: %w", eventID, err)
+		}
+		if err := schemas.DeleteSyncLink(ctx, en.DB, st.SyncID, eventID); err != nil {
+			return err
+		}
+		res.Deleted++
+	}
+
+	return nil
+}
+
+func (en *Engine) linkedElsewhere(ctx context.Context, syncID string) (map[string]bool, error) {
+	states, err := schemas.ListSyncStates(ctx, en.DB, "")
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]bool)
+	for _, other := range states {
+		if other.SyncID == syncID {
+			continue
+		}
+		links, err := schemas.ListSyncLinks(ctx, en.DB, other.SyncID)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range links {
+			out[l.EventID] = true
+		}
+	}
+	return out, nil
+}
+
+// EventHash fingerprints the synced fields of an event so local edits can be
+// detected between runs.
+func EventHash(e schemas.Event) string {
+	parts := []string{
+		e.Title,
+		deref(e.Notes),
+		e.Timezone,
+		fmt.Sprint(e.AllDay),
+		deref(e.Rrule),
+		e.StartTime.UTC().Format(time.RFC3339),
+		deref(e.URL),
+	}
+	if e.EndTime != nil {
+		parts = append(parts, e.EndTime.UTC().Format(time.RFC3339))
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:16])
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,421 @@
+// Package google implements integrations.Provider for Google Calendar using
+// the Calendar v3 REST API.
+package google
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"pical/database/schemas"
+	"pical/ical"
+	"pical/integrations"
+)
+
+const (
+	ProviderName = "google"
+	apiBase      = "https://www.googleapis.com/calendar/v3"
+	authURL      = "https://accounts.google.com/o/oauth2/v2/auth"
+	tokenURL     = "https://oauth2.googleapis.com/token"
+	scope        = "https://www.googleapis.com/auth/calendar"
+)
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// Provider talks to Google Calendar on behalf of one OAuth client.
+type Provider struct {
+	oauth integrations.OAuthConfig
+}
+
+func New(clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{oauth: integrations.OAuthConfig{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      authURL,
+		TokenURL:     tokenURL,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{scope},
+	}}
+}
+
+func (p *Provider) Name() string { return ProviderName }
+
+func (p *Provider) OAuth() integrations.OAuthConfig { return p.oauth }
+
+// AuthCodeURL asks for offline access so Google issues a refresh token.
+func (p *Provider) AuthCodeURL(state string) string {
+	return p.oauth.AuthCodeURL(state, url.Values{
+		"access_type": {"offline"},
+		"prompt":      {"consent"},
+	})
+}
+
+type calendarEntry struct {
+	ID         string `json:"id"`
+	Summary    string `json:"summary"`
+	Primary    bool   `json:"primary"`
+	AccessRole string `json:"accessRole"`
+}
+
+// Calendars lists the calendars the account can see.
+func (p *Provider) Calendars(ctx context.Context, token string) ([]integrations.Calendar, error) {
+	out := make([]integrations.Calendar, 0)
+	pageToken := ""
+	for {
+		q := url.Values{}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+
+		var page struct {
+			Items         []calendarEntry `json:"items"`
+			NextPageToken string          `json:"nextPageToken"`
+		}
+		if err := call(ctx, token, http.MethodGet, "/users/me/calendarList?"+q.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		for _, c := range page.Items {
+			out = append(out, integrations.Calendar{
+				ID:       c.ID,
+				Name:     c.Summary,
+				Primary:  c.Primary,
+				ReadOnly: c.AccessRole != "owner" && c.AccessRole != "writer",
+			})
+		}
+
+		if page.NextPageToken == "" {
+			return out, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+type eventTime struct {
+	Date     string `json:"date,omitempty"`
+	DateTime string `json:"dateTime,omitempty"`
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+type event struct {
+	ID                string     `json:"id,omitempty"`
+	ETag              string     `json:"etag,omitempty"`
+	Status            string     `json:"status,omitempty"`
+	Summary           string     `json:"summary"`
+	Description       string     `json:"description,omitempty"`
+	Location          string     `json:"location,omitempty"`
+	Start             *eventTime `json:"start,omitempty"`
+	End               *eventTime `json:"end,omitempty"`
+	Recurrence        []string   `json:"recurrence,omitempty"`
+	RecurringEventID  string     `json:"recurringEventId,omitempty"`
+	OriginalStartTime *eventTime `json:"originalStartTime,omitempty"`
+	HangoutLink       string     `json:"hangoutLink,omitempty"`
+}
+
+// Pull lists events changed since the stored sync token, or every event when
+// there is none. Google answers 410 Gone once a sync token has expired.
+func (p *Provider) Pull(ctx context.Context, token string, st schemas.SyncState) (integrations.Pull, error) {
+	var pull integrations.Pull
+	pull.Full = st.SyncToken == nil
+
+	pageToken := ""
+	for {
+		q := url.Values{}
+		q.Set("showDeleted", "true")
+		q.Set("maxResults", "250")
+		if st.SyncToken != nil {
+			q.Set("syncToken", *st.SyncToken)
+		}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+
+		var page struct {
+			TimeZone      string  `json:"timeZone"`
+			Items         []event `json:"items"`
+			NextPageToken string  `json:"nextPageToken"`
+			NextSyncToken string  `json:"nextSyncToken"`
+		}
+		if err := call(ctx, token, http.MethodGet, eventsPath(st.CalendarID)+"?"+q.Encode(), nil, &page); err != nil {
+			return pull, err
+		}
+
+		def := time.UTC
+		if loc, err := time.LoadLocation(page.TimeZone); err == nil && page.TimeZone != "" {
+			def = loc
+		}
+		for _, ge := range page.Items {
+			ch, err := toChange(ge, def)
+			if err != nil {
+				// One malformed event shouldn't stall the whole calendar
+				continue
+			}
+			pull.Changes = append(pull.Changes, ch)
+		}
+
+		if page.NextPageToken == "" {
+			pull.Cursor = page.NextSyncToken
+			return pull, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+func (p *Provider) Create(ctx context.Context, token string, st schemas.SyncState, e schemas.Event, exs []schemas.Exception) (integrations.RemoteRef, error) {
+	var out event
+	if err := call(ctx, token, http.MethodPost, eventsPath(st.CalendarID), fromEvent(e, exs), &out); err != nil {
+		return integrations.RemoteRef{}, err
+	}
+	return integrations.RemoteRef{ID: out.ID, Version: out.ETag}, nil
+}
+
+func (p *Provider) Update(ctx context.Context, token string, st schemas.SyncState, remoteID string, e schemas.Event, exs []schemas.Exception) (integrations.RemoteRef, error) {
+	var out event
+	path := eventsPath(st.CalendarID) + "/" + url.PathEscape(remoteID)
+	if err := call(ctx, token, http.MethodPut, path, fromEvent(e, exs), &out); err != nil {
+		return integrations.RemoteRef{}, err
+	}
+	return integrations.RemoteRef{ID: out.ID, Version: out.ETag}, nil
+}
+
+func (p *Provider) Delete(ctx context.Context, token string, st schemas.SyncState, remoteID string) error {
+	path := eventsPath(st.CalendarID) + "/" + url.PathEscape(remoteID)
+	err := call(ctx, token, http.MethodDelete, path, nil, nil)
+	if apiErr, ok := err.(*APIError); ok && (apiErr.Status == http.StatusNotFound || apiErr.Status == http.StatusGone) {
+		return nil // already gone
+	}
+	return err
+}
+
+func eventsPath(calendarID string) string {
+	return "/calendars/" + url.PathEscape(calendarID) + "/events"
+}
+
+// APIError is a non-2xx response from the Calendar API.
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("google calendar: %d %s", e.Status, e.Message)
+}
+
+func call(ctx context.Context, token, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiBase+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("google calendar: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone && method == http.MethodGet {
+		return integrations.ErrCursorExpired
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&e)
+		return &APIError{Status: resp.StatusCode, Message: e.Error.Message}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("google calendar response: %w", err)
+	}
+	return nil
+}
+
+// toChange maps a Google event onto PiCal. Edited or cancelled instances of a
+// recurring series become exceptions on the series' master event.
+func toChange(ge event, def *time.Location) (integrations.Change, error) {
+	ch := integrations.Change{RemoteRef: integrations.RemoteRef{ID: ge.ID, Version: ge.ETag}}
+
+	if ge.RecurringEventID != "" {
+		ch.MasterID = ge.RecurringEventID
+		if ge.OriginalStartTime == nil {
+			return ch, fmt.Errorf("instance without originalStartTime")
+		}
+		orig, _, err := parseTime(*ge.OriginalStartTime, def)
+		if err != nil {
+			return ch, err
+		}
+
+		ex := schemas.Exception{RecurrenceID: orig, Kind: schemas.ExceptionCancel}
+		if ge.Status != "cancelled" && ge.Start != nil {
+			start, _, err := parseTime(*ge.Start, def)
+			if err != nil {
+				return ch, err
+			}
+			ex.Kind, ex.NewStart = schemas.ExceptionMove, &start
+			if ge.End != nil {
+				if end, _, err := parseTime(*ge.End, def); err == nil {
+					ex.NewEnd = &end
+				}
+			}
+		}
+		ch.Exception = &ex
+		return ch, nil
+	}
+
+	if ge.Status == "cancelled" {
+		ch.Deleted = true
+		return ch, nil
+	}
+	if ge.Start == nil {
+		return ch, fmt.Errorf("event without start")
+	}
+
+	loc := def
+	if ge.Start.TimeZone != "" {
+		if l, err := time.LoadLocation(ge.Start.TimeZone); err == nil {
+			loc = l
+		}
+	}
+
+	start, allDay, err := parseTime(*ge.Start, loc)
+	if err != nil {
+		return ch, err
+	}
+
+	e := schemas.Event{
+		Title:     ge.Summary,
+		Timezone:  loc.String(),
+		AllDay:    allDay,
+		StartTime: start,
+	}
+	if e.Title == "" {
+		e.Title = "(No title)"
+	}
+	if ge.Description != "" {
+		e.Notes = &ge.Description
+	}
+	if ge.End != nil {
+		if end, _, err := parseTime(*ge.End, loc); err == nil {
+			e.EndTime = &end
+		}
+	}
+	link := joinLink(ge)
+	if link != "" {
+		e.URL = &link
+	}
+	// A link typed into the location is the join link, not a place
+	if where := strings.TrimSpace(ge.Location); where != "" && where != link {
+		e.Location = &where
+	}
+
+	if len(ge.Recurrence) > 0 {
+		rrule, exdates, err := ical.ParseRecurrence(ge.Recurrence, loc)
+		if err != nil {
+			return ch, err
+		}
+		e.Rrule = rrule
+		for _, d := range exdates {
+			ch.Exceptions = append(ch.Exceptions, schemas.Exception{RecurrenceID: d, Kind: schemas.ExceptionCancel})
+		}
+	}
+
+	ch.Event = e
+	return ch, nil
+}
+
+// joinLink prefers the Meet link and falls back to a URL typed into the location.
+func joinLink(ge event) string {
+	if ge.HangoutLink != "" {
+		return ge.HangoutLink
+	}
+	loc := strings.TrimSpace(ge.Location)
+	if u, err := url.Parse(loc); err == nil && u.Host != "" && (u.Scheme == "http" || u.Scheme == "https") {
+		return loc
+	}
+	return ""
+}
+
+func parseTime(t eventTime, loc *time.Location) (time.Time, bool, error) {
+	if t.Date != "" {
+		d, err := time.ParseInLocation("2006-01-02", t.Date, loc)
+		return d, true, err
+	}
+	d, err := time.Parse(time.RFC3339, t.DateTime)
+	return d, false, err
+}
+
+// fromEvent builds the request body for an insert or update. Cancelled
+// occurrences are sent as EXDATEs; moved occurrences stay local.
+func fromEvent(e schemas.Event, exs []schemas.Exception) event {
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	ge := event{Summary: e.Title}
+	if e.Notes != nil {
+		ge.Description = *e.Notes
+	}
+	if e.Location != nil {
+		ge.Location = *e.Location
+	} else if e.URL != nil {
+		ge.Location = *e.URL
+	}
+
+	if e.AllDay {
+		start := e.StartTime.In(loc)
+		end := start.AddDate(0, 0, 1)
+		if e.EndTime != nil && e.EndTime.After(e.StartTime) {
+			end = e.EndTime.In(loc)
+		}
+		ge.Start = &eventTime{Date: start.Format("2006-01-02")}
+		ge.End = &eventTime{Date: end.Format("2006-01-02")}
+	} else {
+		end := e.StartTime.Add(time.Hour)
+		if e.EndTime != nil {
+			end = *e.EndTime
+		}
+		ge.Start = &eventTime{DateTime: e.StartTime.In(loc).Format(time.RFC3339), TimeZone: loc.String()}
+		ge.End = &eventTime{DateTime: end.In(loc).Format(time.RFC3339), TimeZone: loc.String()}
+	}
+
+	if e.Rrule != nil && *e.Rrule != "" {
+		ge.Recurrence = []string{"RRULE:" + strings.TrimPrefix(*e.Rrule, "RRULE:")}
+		for _, ex := range exs {
+			if ex.Kind != schemas.ExceptionCancel {
+				continue
+			}
+			if e.AllDay {
+				ge.Recurrence = append(ge.Recurrence, "EXDATE;VALUE=DATE:"+ex.RecurrenceID.In(loc).Format("20060102"))
+			} else {
+				ge.Recurrence = append(ge.Recurrence, "EXDATE;TZID="+loc.String()+":"+ex.RecurrenceID.In(loc).Format("20060102T150405"))
+			}
+		}
+	}
+
+	return ge
+}
@@ -0,0 +1,589 @@
+// Package microsoft implements integrations.Provider for Outlook and
+// Microsoft 365 calendars using the Microsoft Graph API.
+//
+// Accounts connect with the OAuth device-code flow, so sign-in can be started
+// from the Pi and finished on a phone. Pulls use calendarView delta queries,
+// which only cover a window around today (pullPast to pullAhead), so a cursor
+// reset never treats events outside that window as deleted.
+package microsoft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"pical/database/schemas"
+	"pical/ical"
+	"pical/integrations"
+)
+
+const (
+	ProviderName = "microsoft"
+	apiBase      = "https://graph.microsoft.com/v1.0"
+	loginBase    = "https://login.microsoftonline.com/"
+
+	pullPast  = 30 * 24 * time.Hour
+	pullAhead = 365 * 24 * time.Hour
+
+	graphTimeLayout = "2006-01-02T15:04:05.9999999"
+)
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// Provider talks to Microsoft Graph on behalf of one public client registration.
+type Provider struct {
+	oauth  integrations.OAuthConfig
+	tenant string
+}
+
+// New configures a provider for an app registration. tenant is usually
+// "common", or "consumers" for personal accounts only.
+func New(clientID, tenant string) *Provider {
+	if tenant == "" {
+		tenant = "common"
+	}
+	base := loginBase + url.PathEscape(tenant) + "/oauth2/v2.0"
+	return &Provider{
+		tenant: tenant,
+		oauth: integrations.OAuthConfig{
+			ClientID: clientID,
+			AuthURL:  base + "/authorize",
+			TokenURL: base + "/token",
+			Scopes:   []string{"offline_access", "Calendars.ReadWrite"},
+		},
+	}
+}
+
+func (p *Provider) Name() string { return ProviderName }
+
+func (p *Provider) OAuth() integrations.OAuthConfig { return p.oauth }
+
+func (p *Provider) StartDevice(ctx context.Context) (integrations.DeviceCode, error) {
+	return p.oauth.StartDevice(ctx, loginBase+url.PathEscape(p.tenant)+"/oauth2/v2.0/devicecode")
+}
+
+func (p *Provider) PollDevice(ctx context.Context, dc integrations.DeviceCode) (integrations.Token, error) {
+	return p.oauth.PollDevice(ctx, dc)
+}
+
+// Calendars lists the calendars of the signed-in user.
+func (p *Provider) Calendars(ctx context.Context, token string) ([]integrations.Calendar, error) {
+	out := make([]integrations.Calendar, 0)
+	next := apiBase + "/me/calendars"
+	for next != "" {
+		var page struct {
+			Value []struct {
+				ID                string `json:"id"`
+				Name              string `json:"name"`
+				IsDefaultCalendar bool   `json:"isDefaultCalendar"`
+				CanEdit           bool   `json:"canEdit"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		if err := call(ctx, token, http.MethodGet, next, nil, &page); err != nil {
+			return nil, err
+		}
+		for _, c := range page.Value {
+			out = append(out, integrations.Calendar{ID: c.ID, Name: c.Name, Primary: c.IsDefaultCalendar, ReadOnly: !c.CanEdit})
+		}
+		next = page.NextLink
+	}
+	return out, nil
+}
+
+type dateTimeZone struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+type itemBody struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+type location struct {
+	DisplayName string `json:"displayName"`
+}
+
+type event struct {
+	ID                    string        `json:"id,omitempty"`
+	ChangeKey             string        `json:"changeKey,omitempty"`
+	Subject               string        `json:"subject"`
+	Body                  *itemBody     `json:"body,omitempty"`
+	Start                 *dateTimeZone `json:"start,omitempty"`
+	End                   *dateTimeZone `json:"end,omitempty"`
+	IsAllDay              bool          `json:"isAllDay"`
+	Type                  string        `json:"type,omitempty"`
+	SeriesMasterID        string        `json:"seriesMasterId,omitempty"`
+	OriginalStart         string        `json:"originalStart,omitempty"`
+	OriginalStartTimeZone string        `json:"originalStartTimeZone,omitempty"`
+	Recurrence            *recurrence   `json:"recurrence,omitempty"`
+	Location              *location     `json:"location,omitempty"`
+	OnlineMeeting         *struct {
+		JoinURL string `json:"joinUrl"`
+	} `json:"onlineMeeting,omitempty"`
+	OnlineMeetingURL string `json:"onlineMeetingUrl,omitempty"`
+	Removed          *struct {
+		Reason string `json:"reason"`
+	} `json:"@removed,omitempty"`
+}
+
+// Pull follows the stored deltaLink, or starts a new delta query over the
+// pull window. Changed occurrences are reported via their series master,
+// which is fetched separately because delta queries only return instances.
+func (p *Provider) Pull(ctx context.Context, token string, st schemas.SyncState) (integrations.Pull, error) {
+	var pull integrations.Pull
+
+	next := ""
+	if st.SyncToken != nil {
+		next = *st.SyncToken
+	} else {
+		now := time.Now().UTC()
+		q := url.Values{}
+		q.Set("startDateTime", now.Add(-pullPast).Format(time.RFC3339))
+		q.Set("endDateTime", now.Add(pullAhead).Format(time.RFC3339))
+		next = apiBase + "/me/calendars/" + url.PathEscape(st.CalendarID) + "/calendarView/delta?" + q.Encode()
+	}
+
+	masters := make([]string, 0)
+	seenMaster := map[string]bool{}
+	var instances []integrations.Change
+
+	for next != "" {
+		var page struct {
+			Value     []event `json:"value"`
+			NextLink  string  `json:"@odata.nextLink"`
+			DeltaLink string  `json:"@odata.deltaLink"`
+		}
+		if err := call(ctx, token, http.MethodGet, next, nil, &page); err != nil {
+			return pull, err
+		}
+
+		for _, me := range page.Value {
+			if me.Removed != nil {
+				// Removed instances aren't linked, so the engine ignores them
+				pull.Changes = append(pull.Changes, integrations.Change{RemoteRef: integrations.RemoteRef{ID: me.ID}, Deleted: true})
+				continue
+			}
+
+			switch me.Type {
+			case "singleInstance", "":
+				ch, err := toChange(me)
+				if err != nil {
+					continue
+				}
+				pull.Changes = append(pull.Changes, ch)
+			case "seriesMaster", "occurrence", "exception":
+				master := me.SeriesMasterID
+				if me.Type == "seriesMaster" {
+					master = me.ID
+				}
+				if master != "" && !seenMaster[master] {
+					seenMaster[master] = true
+					masters = append(masters, master)
+				}
+				if me.Type == "exception" {
+					if ch, err := toInstance(me); err == nil {
+						instances = append(instances, ch)
+					}
+				}
+			}
+		}
+
+		next = page.NextLink
+		if next == "" {
+			pull.Cursor = page.DeltaLink
+		}
+	}
+
+	for _, id := range masters {
+		var me event
+		err := call(ctx, token, http.MethodGet, apiBase+"/me/events/"+url.PathEscape(id), nil, &me)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return pull, err
+		}
+		ch, err := toChange(me)
+		if err != nil {
+			continue
+		}
+		pull.Changes = append(pull.Changes, ch)
+	}
+
+	// Instance edits come after their masters so the engine can resolve them
+	pull.Changes = append(pull.Changes, instances...)
+	return pull, nil
+}
+
+func (p *Provider) Create(ctx context.Context, token string, st schemas.SyncState, e schemas.Event, exs []schemas.Exception) (integrations.RemoteRef, error) {
+	body, err := fromEvent(e)
+	if err != nil {
+		return integrations.RemoteRef{}, err
+	}
+
+	var out event
+	if err := call(ctx, token, http.MethodPost, apiBase+"/me/calendars/"+url.PathEscape(st.CalendarID)+"/events", body, &out); err != nil {
+		return integrations.RemoteRef{}, err
+	}
+	return p.applyExceptions(ctx, token, out, e, exs)
+}
+
+func (p *Provider) Update(ctx context.Context, token string, st schemas.SyncState, remoteID string, e schemas.Event, exs []schemas.Exception) (integrations.RemoteRef, error) {
+	body, err := fromEvent(e)
+	if err != nil {
+		return integrations.RemoteRef{}, err
+	}
+
+	var out event
+	if err := call(ctx, token, http.MethodPatch, apiBase+"/me/events/"+url.PathEscape(remoteID), body, &out); err != nil {
+		return integrations.RemoteRef{}, err
+	}
+	return p.applyExceptions(ctx, token, out, e, exs)
+}
+
+func (p *Provider) Delete(ctx context.Context, token string, st schemas.SyncState, remoteID string) error {
+	err := call(ctx, token, http.MethodDelete, apiBase+"/me/events/"+url.PathEscape(remoteID), nil, nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		return nil // already gone
+	}
+	return err
+}
+
+// applyExceptions deletes cancelled and moves rescheduled instances of a
+// pushed series. Graph has no EXDATE, so each one is a call on the instance.
+// The master is re-read afterwards because instance edits bump its changeKey.
+func (p *Provider) applyExceptions(ctx context.Context, token string, master event, e schemas.Event, exs []schemas.Exception) (integrations.RemoteRef, error) {
+	ref := integrations.RemoteRef{ID: master.ID, Version: master.ChangeKey}
+	if master.Recurrence == nil || master.Start == nil || len(exs) == 0 {
+		return ref, nil
+	}
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	first, last := exs[0].RecurrenceID, exs[0].RecurrenceID
+	for _, ex := range exs {
+		if ex.RecurrenceID.Before(first) {
+			first = ex.RecurrenceID
+		}
+		if ex.RecurrenceID.After(last) {
+			last = ex.RecurrenceID
+		}
+	}
+
+	q := url.Values{}
+	q.Set("startDateTime", first.Add(-24*time.Hour).UTC().Format(time.RFC3339))
+	q.Set("endDateTime", last.Add(48*time.Hour).UTC().Format(time.RFC3339))
+	next := apiBase + "/me/events/" + url.PathEscape(master.ID) + "/instances?" + q.Encode()
+
+	byStart := map[int64]string{}
+	for next != "" {
+		var page struct {
+			Value    []event `json:"value"`
+			NextLink string  `json:"@odata.nextLink"`
+		}
+		if err := call(ctx, token, http.MethodGet, next, nil, &page); err != nil {
+			return ref, err
+		}
+		for _, inst := range page.Value {
+			if t, err := time.Parse(time.RFC3339Nano, inst.OriginalStart); err == nil {
+				byStart[t.UnixNano()] = inst.ID
+			}
+		}
+		next = page.NextLink
+	}
+
+	for _, ex := range exs {
+		instID, ok := byStart[ex.RecurrenceID.UnixNano()]
+		if !ok {
+			continue
+		}
+		path := apiBase + "/me/events/" + url.PathEscape(instID)
+
+		if ex.Kind == schemas.ExceptionCancel {
+			if err := call(ctx, token, http.MethodDelete, path, nil, nil); err != nil {
+				return ref, err
+			}
+			continue
+		}
+		if ex.NewStart == nil {
+			continue
+		}
+		end := ex.NewStart.Add(time.Hour)
+		if ex.NewEnd != nil {
+			end = *ex.NewEnd
+		} else if e.EndTime != nil {
+			end = ex.NewStart.Add(e.EndTime.Sub(e.StartTime))
+		}
+		patch := map[string]*dateTimeZone{"start": utcTime(*ex.NewStart), "end": utcTime(end)}
+		if e.AllDay {
+			patch["start"] = &dateTimeZone{DateTime: ex.NewStart.In(loc).Format("2006-01-02") + "T00:00:00", TimeZone: master.Start.TimeZone}
+			patch["end"] = &dateTimeZone{DateTime: end.In(loc).Format("2006-01-02") + "T00:00:00", TimeZone: master.Start.TimeZone}
+		}
+		if err := call(ctx, token, http.MethodPatch, path, patch, nil); err != nil {
+			return ref, err
+		}
+	}
+
+	var out event
+	if err := call(ctx, token, http.MethodGet, apiBase+"/me/events/"+url.PathEscape(master.ID), nil, &out); err != nil {
+		return ref, err
+	}
+	ref.Version = out.ChangeKey
+	return ref, nil
+}
+
+// APIError is a non-2xx response from Graph.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("microsoft graph: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+func call(ctx context.Context, token, method, endpoint string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	// Times come back in UTC and bodies as plain text instead of HTML
+	req.Header.Add("Prefer", `outlook.timezone="UTC"`)
+	req.Header.Add("Prefer", `outlook.body-content-type="text"`)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("microsoft graph: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&e)
+
+		// An expired delta token is reported as 410, or as a SyncStateNotFound error
+		if method == http.MethodGet && (resp.StatusCode == http.StatusGone || strings.EqualFold(e.Error.Code, "SyncStateNotFound")) {
+			return integrations.ErrCursorExpired
+		}
+		return &APIError{Status: resp.StatusCode, Code: e.Error.Code, Message: e.Error.Message}
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("microsoft graph response: %w", err)
+	}
+	return nil
+}
+
+func toChange(me event) (integrations.Change, error) {
+	ch := integrations.Change{RemoteRef: integrations.RemoteRef{ID: me.ID, Version: me.ChangeKey}}
+	if me.Start == nil {
+		return ch, fmt.Errorf("event without start")
+	}
+
+	loc := ical.LoadZone(me.OriginalStartTimeZone)
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	e := schemas.Event{
+		Title:    me.Subject,
+		Timezone: loc.String(),
+		AllDay:   me.IsAllDay,
+	}
+	if e.Title == "" {
+		e.Title = "(No title)"
+	}
+
+	start, err := parseGraphTime(*me.Start)
+	if err != nil {
+		return ch, err
+	}
+	var end *time.Time
+	if me.End != nil {
+		if t, err := parseGraphTime(*me.End); err == nil {
+			end = &t
+		}
+	}
+	if e.AllDay {
+		// All-day times are floating midnights; re-anchor them in the event's zone
+		start = floatingDate(start, loc)
+		if end != nil {
+			d := floatingDate(*end, loc)
+			end = &d
+		}
+	}
+	e.StartTime, e.EndTime = start, end
+
+	if me.Body != nil && strings.TrimSpace(me.Body.Content) != "" {
+		notes := strings.TrimSpace(me.Body.Content)
+		e.Notes = &notes
+	}
+	link := joinLink(me)
+	if link != "" {
+		e.URL = &link
+	}
+	// A link typed into the location is the join link, not a place
+	if me.Location != nil {
+		if where := strings.TrimSpace(me.Location.DisplayName); where != "" && where != link {
+			e.Location = &where
+		}
+	}
+
+	if me.Recurrence != nil {
+		rrule, err := me.Recurrence.rrule(start.In(loc))
+		if err != nil {
+			return ch, err
+		}
+		e.Rrule = &rrule
+	}
+
+	ch.Event = e
+	return ch, nil
+}
+
+// toInstance maps an edited occurrence onto a move exception of its series.
+func toInstance(me event) (integrations.Change, error) {
+	ch := integrations.Change{RemoteRef: integrations.RemoteRef{ID: me.ID, Version: me.ChangeKey}, MasterID: me.SeriesMasterID}
+
+	orig, err := time.Parse(time.RFC3339Nano, me.OriginalStart)
+	if err != nil {
+		return ch, fmt.Errorf("originalStart: %w", err)
+	}
+	if me.Start == nil {
+		return ch, fmt.Errorf("instance without start")
+	}
+	start, err := parseGraphTime(*me.Start)
+	if err != nil {
+		return ch, err
+	}
+
+	ex := schemas.Exception{RecurrenceID: orig, Kind: schemas.ExceptionMove, NewStart: &start}
+	if me.End != nil {
+		if end, err := parseGraphTime(*me.End); err == nil {
+			ex.NewEnd = &end
+		}
+	}
+	ch.Exception = &ex
+	return ch, nil
+}
+
+func parseGraphTime(t dateTimeZone) (time.Time, error) {
+	loc := ical.LoadZone(t.TimeZone)
+	if loc == nil {
+		loc = time.UTC
+	}
+	return time.ParseInLocation(graphTimeLayout, t.DateTime, loc)
+}
+
+func floatingDate(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+func utcTime(t time.Time) *dateTimeZone {
+	return &dateTimeZone{DateTime: t.UTC().Format(graphTimeLayout), TimeZone: "UTC"}
+}
+
+// joinLink prefers the Teams/Skype link and falls back to a URL typed into the location.
+func joinLink(me event) string {
+	if me.OnlineMeeting != nil && me.OnlineMeeting.JoinURL != "" {
+		return me.OnlineMeeting.JoinURL
+	}
+	if me.OnlineMeetingURL != "" {
+		return me.OnlineMeetingURL
+	}
+	if me.Location == nil {
+		return ""
+	}
+	loc := strings.TrimSpace(me.Location.DisplayName)
+	if u, err := url.Parse(loc); err == nil && u.Host != "" && (u.Scheme == "http" || u.Scheme == "https") {
+		return loc
+	}
+	return ""
+}
+
+// fromEvent builds the request body for an insert or update. Series times are
+// sent in the event's own zone so the pattern keeps its wall-clock time.
+func fromEvent(e schemas.Event) (event, error) {
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	zone := e.Timezone
+	if win, ok := ical.WindowsZone(e.Timezone); ok {
+		zone = win
+	}
+
+	me := event{Subject: e.Title, IsAllDay: e.AllDay}
+	if e.Notes != nil {
+		me.Body = &itemBody{ContentType: "text", Content: *e.Notes}
+	}
+	if e.Location != nil {
+		me.Location = &location{DisplayName: *e.Location}
+	} else if e.URL != nil {
+		me.Location = &location{DisplayName: *e.URL}
+	}
+
+	start := e.StartTime.In(loc)
+	var end time.Time
+	switch {
+	case e.AllDay:
+		end = start.AddDate(0, 0, 1)
+		if e.EndTime != nil && e.EndTime.After(e.StartTime) {
+			end = e.EndTime.In(loc)
+		}
+		start, end = floatingDate(start, loc), floatingDate(end, loc)
+	case e.EndTime != nil:
+		end = e.EndTime.In(loc)
+	default:
+		end = start.Add(time.Hour)
+	}
+	me.Start = &dateTimeZone{DateTime: start.Format(graphTimeLayout), TimeZone: zone}
+	me.End = &dateTimeZone{DateTime: end.Format(graphTimeLayout), TimeZone: zone}
+
+	if e.Rrule != nil && *e.Rrule != "" {
+		rec, err := fromRRule(*e.Rrule, start, zone)
+		if err != nil {
+			return me, fmt.Errorf("%w: %v", integrations.ErrUnsupported, err)
+		}
+		me.Recurrence = &rec
+	}
+
+	return me, nil
+}
@@ -0,0 +1,240 @@
+package microsoft
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	rrule "pical/recurrence"
+)
+
+// recurrence is Graph's patternedRecurrence.
+type recurrence struct {
+	Pattern struct {
+		Type           string   `json:"type"`
+		Interval       int      `json:"interval"`
+		Month          int      `json:"month,omitempty"`
+		DayOfMonth     int      `json:"dayOfMonth,omitempty"`
+		DaysOfWeek     []string `json:"daysOfWeek,omitempty"`
+		FirstDayOfWeek string   `json:"firstDayOfWeek,omitempty"`
+		Index          string   `json:"index,omitempty"`
+	} `json:"pattern"`
+	Range struct {
+		Type                string `json:"type"`
+		StartDate           string `json:"startDate"`
+		EndDate             string `json:"endDate,omitempty"`
+		NumberOfOccurrences int    `json:"numberOfOccurrences,omitempty"`
+		RecurrenceTimeZone  string `json:"recurrenceTimeZone,omitempty"`
+	} `json:"range"`
+}
+
+var indexes = map[int]string{1: "first", 2: "second", 3: "third", 4: "fourth", -1: "last"}
+
+// rrule converts the pattern to an RRULE value for a series starting at start.
+func (rec recurrence) rrule(start time.Time) (string, error) {
+	p := rec.Pattern
+	r := rrule.Rule{Interval: max(p.Interval, 1), WeekStart: time.Sunday}
+	if p.FirstDayOfWeek != "" {
+		if wd, ok := parseDay(p.FirstDayOfWeek); ok {
+			r.WeekStart = wd
+		}
+	}
+
+	days := func(n int) error {
+		for _, d := range p.DaysOfWeek {
+			wd, ok := parseDay(d)
+			if !ok {
+				return fmt.Errorf("unknown day %q", d)
+			}
+			r.ByDay = append(r.ByDay, rrule.WeekdayNum{Day: wd})
+		}
+		if n == 0 {
+			return nil
+		}
+		// One day takes an ordinal directly; several need BYSETPOS
+		if len(r.ByDay) == 1 {
+			r.ByDay[0].N = n
+		} else {
+			r.BySetPos = []int{n}
+		}
+		return nil
+	}
+
+	index := 0
+	if p.Index != "" {
+		for n, name := range indexes {
+			if strings.EqualFold(name, p.Index) {
+				index = n
+			}
+		}
+		if index == 0 {
+			return "", fmt.Errorf("unknown index %q", p.Index)
+		}
+	}
+
+	switch p.Type {
+	case "daily":
+		r.Freq = rrule.Daily
+	case "weekly":
+		r.Freq = rrule.Weekly
+		if err := days(0); err != nil {
+			return "", err
+		}
+	case "absoluteMonthly":
+		r.Freq = rrule.Monthly
+		r.ByMonthDay = []int{p.DayOfMonth}
+	case "relativeMonthly":
+		r.Freq = rrule.Monthly
+		if err := days(index); err != nil {
+			return "", err
+		}
+	case "absoluteYearly":
+		r.Freq = rrule.Yearly
+		r.ByMonth = []time.Month{time.Month(p.Month)}
+		r.ByMonthDay = []int{p.DayOfMonth}
+	case "relativeYearly":
+		r.Freq = rrule.Yearly
+		r.ByMonth = []time.Month{time.Month(p.Month)}
+		if err := days(index); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unsupported recurrence pattern %q", p.Type)
+	}
+
+	switch rec.Range.Type {
+	case "numbered":
+		r.Count = rec.Range.NumberOfOccurrences
+	case "endDate":
+		end, err := time.ParseInLocation("2006-01-02", rec.Range.EndDate, start.Location())
+		if err != nil {
+			return "", fmt.Errorf("recurrence endDate: %w", err)
+		}
+		// endDate is inclusive of that whole day
+		r.SetUntil(end.AddDate(0, 0, 1).Add(-time.Second))
+	}
+
+	return r.String(), nil
+}
+
+// fromRRule converts an RRULE to a Graph pattern. Graph can't express every
+// RRULE, e.g. several BYMONTHDAY values or yearly rules over several months.
+func fromRRule(value string, start time.Time, zone string) (recurrence, error) {
+	var rec recurrence
+	r, err := rrule.Parse(value)
+	if err != nil {
+		return rec, err
+	}
+
+	p := &rec.Pattern
+	p.Interval = r.Interval
+	p.FirstDayOfWeek = dayName(r.WeekStart)
+
+	// relative sets daysOfWeek and index from BYDAY ordinals or BYSETPOS
+	relative := func() error {
+		n := 0
+		for _, wd := range r.ByDay {
+			if wd.N != 0 {
+				if n != 0 && n != wd.N {
+					return fmt.Errorf("mixed BYDAY ordinals")
+				}
+				n = wd.N
+			}
+			p.DaysOfWeek = append(p.DaysOfWeek, dayName(wd.Day))
+		}
+		if n == 0 && len(r.BySetPos) == 1 {
+			n = r.BySetPos[0]
+		}
+		name, ok := indexes[n]
+		if !ok {
+			return fmt.Errorf("ordinal %d", n)
+		}
+		p.Index = name
+		return nil
+	}
+
+	if len(r.ByMonthDay) > 1 || (len(r.ByMonthDay) == 1 && r.ByMonthDay[0] < 0) {
+		return rec, fmt.Errorf("BYMONTHDAY %v", r.ByMonthDay)
+	}
+	if len(r.ByMonth) > 1 || (len(r.ByMonth) == 1 && r.Freq != rrule.Yearly) {
+		return rec, fmt.Errorf("BYMONTH %v", r.ByMonth)
+	}
+
+	switch r.Freq {
+	case rrule.Daily:
+		if len(r.ByDay) == 0 {
+			p.Type = "daily"
+			break
+		}
+		// DAILY;BYDAY=MO,...,FR is how most apps write "every weekday"
+		if r.Interval != 1 {
+			return rec, fmt.Errorf("DAILY with BYDAY and INTERVAL")
+		}
+		p.Type = "weekly"
+		for _, wd := range r.ByDay {
+			p.DaysOfWeek = append(p.DaysOfWeek, dayName(wd.Day))
+		}
+	case rrule.Weekly:
+		p.Type = "weekly"
+		for _, wd := range r.ByDay {
+			p.DaysOfWeek = append(p.DaysOfWeek, dayName(wd.Day))
+		}
+		if len(p.DaysOfWeek) == 0 {
+			p.DaysOfWeek = []string{dayName(start.Weekday())}
+		}
+	case rrule.Monthly:
+		switch {
+		case len(r.ByDay) > 0:
+			p.Type = "relativeMonthly"
+			if err := relative(); err != nil {
+				return rec, err
+			}
+		case len(r.ByMonthDay) == 1:
+			p.Type, p.DayOfMonth = "absoluteMonthly", r.ByMonthDay[0]
+		default:
+			p.Type, p.DayOfMonth = "absoluteMonthly", start.Day()
+		}
+	case rrule.Yearly:
+		p.Month = int(start.Month())
+		if len(r.ByMonth) == 1 {
+			p.Month = int(r.ByMonth[0])
+		}
+		switch {
+		case len(r.ByDay) > 0:
+			p.Type = "relativeYearly"
+			if err := relative(); err != nil {
+				return rec, err
+			}
+		case len(r.ByMonthDay) == 1:
+			p.Type, p.DayOfMonth = "absoluteYearly", r.ByMonthDay[0]
+		default:
+			p.Type, p.DayOfMonth = "absoluteYearly", start.Day()
+		}
+	}
+
+	rec.Range.StartDate = start.Format("2006-01-02")
+	rec.Range.RecurrenceTimeZone = zone
+	switch until := r.UntilAt(start); {
+	case r.Count > 0:
+		rec.Range.Type, rec.Range.NumberOfOccurrences = "numbered", r.Count
+	case until != nil:
+		rec.Range.Type, rec.Range.EndDate = "endDate", until.In(start.Location()).Format("2006-01-02")
+	default:
+		rec.Range.Type = "noEnd"
+	}
+
+	return rec, nil
+}
+
+func parseDay(name string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), name) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+func dayName(d time.Weekday) string {
+	return strings.ToLower(d.String())
+}
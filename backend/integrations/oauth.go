@@ -0,0 +1,256 @@
+package integrations
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"pical/database/schemas"
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// OAuthConfig describes a provider's OAuth 2.0 client registration.
+type OAuthConfig struct {
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Configured reports whether a client ID was provided.
+func (c OAuthConfig) Configured() bool {
+	return c.ClientID != ""
+}
+
+// Token is an OAuth token endpoint response.
+type Token struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Expiry converts ExpiresIn into an absolute time, or nil if the provider didn't say.
+func (t Token) Expiry(now time.Time) *time.Time {
+	if t.ExpiresIn <= 0 {
+		return nil
+	}
+	exp := now.Add(time.Duration(t.ExpiresIn) * time.Second)
+	return &exp
+}
+
+// TokenError is an error returned by the token endpoint, e.g. "authorization_pending".
+type TokenError struct {
+	Code        string
+	Description string
+}
+
+func (e *TokenError) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+// AuthCodeURL is where the user is sent to grant access.
+func (c OAuthConfig) AuthCodeURL(state string, extra url.Values) string {
+	v := url.Values{}
+	v.Set("client_id", c.ClientID)
+	v.Set("redirect_uri", c.RedirectURL)
+	v.Set("response_type", "code")
+	v.Set("scope", strings.Join(c.Scopes, " "))
+	v.Set("state", state)
+	for k, vals := range extra {
+		v[k] = vals
+	}
+	return c.AuthURL + "?" + v.Encode()
+}
+
+// Exchange trades an authorization code for tokens.
+func (c OAuthConfig) Exchange(ctx context.Context, code string) (Token, error) {
+	return c.PostToken(ctx, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {c.RedirectURL},
+	})
+}
+
+// Refresh obtains a new access token.
+func (c OAuthConfig) Refresh(ctx context.Context, refreshToken string) (Token, error) {
+	return c.PostToken(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+}
+
+// PostToken calls the token endpoint with the client credentials added to form.
+func (c OAuthConfig) PostToken(ctx context.Context, form url.Values) (Token, error) {
+	form.Set("client_id", c.ClientID)
+	if c.ClientSecret != "" {
+		form.Set("client_secret", c.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return Token{}, fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tok Token
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return Token{}, fmt.Errorf("token response: %w", err)
+	}
+	if tok.Error != "" {
+		return Token{}, &TokenError{Code: tok.Error, Description: tok.ErrorDescription}
+	}
+	if resp.StatusCode != http.StatusOK || tok.AccessToken == "" {
+		return Token{}, fmt.Errorf("token request: unexpected status %s", resp.Status)
+	}
+	return tok, nil
+}
+
+// AccessToken returns a usable access token for st, refreshing and persisting
+// it when it is missing or about to expire.
+func AccessToken(ctx context.Context, db *sql.DB, cfg OAuthConfig, st *schemas.SyncState) (string, error) {
+	if st.AccessToken != "" && st.TokenExpiry != nil && time.Until(*st.TokenExpiry) > time.Minute {
+		return st.AccessToken, nil
+	}
+	if st.RefreshToken == "" {
+		if st.AccessToken != "" && st.TokenExpiry == nil {
+			return st.AccessToken, nil
+		}
+		return "", fmt.Errorf("account is not connected")
+	}
+
+	tok, err := cfg.Refresh(ctx, st.RefreshToken)
+	if err != nil {
+		return "", fmt.Errorf("refresh token: %w", err)
+	}
+
+	expiry := tok.Expiry(time.Now())
+	if err := schemas.UpdateSyncTokens(ctx, db, st.SyncID, tok.AccessToken, tok.RefreshToken, expiry); err != nil {
+		return "", err
+	}
+	st.AccessToken, st.TokenExpiry = tok.AccessToken, expiry
+	if tok.RefreshToken != "" {
+		st.RefreshToken = tok.RefreshToken
+	}
+	return st.AccessToken, nil
+}
+
+// RedirectAuth is implemented by providers that connect through a browser
+// redirect back to PiCal.
+type RedirectAuth interface {
+	AuthCodeURL(state string) string
+}
+
+// DeviceCode is a pending device authorization (RFC 8628).
+type DeviceCode struct {
+	DeviceCode      string `json:"-"`
+	UserCode        string `json:"userCode"`
+	VerificationURI string `json:"verificationUri"`
+	Message         string `json:"message,omitempty"`
+	ExpiresIn       int    `json:"expiresIn"`
+	Interval        int    `json:"-"`
+}
+
+// DeviceAuth is implemented by providers that connect with a code the user
+// enters on another device, which suits the Pi's touchscreen.
+type DeviceAuth interface {
+	StartDevice(ctx context.Context) (DeviceCode, error)
+	PollDevice(ctx context.Context, dc DeviceCode) (Token, error)
+}
+
+// StartDevice requests a device code from endpoint.
+func (c OAuthConfig) StartDevice(ctx context.Context, endpoint string) (DeviceCode, error) {
+	form := url.Values{
+		"client_id": {c.ClientID},
+		"scope":     {strings.Join(c.Scopes, " ")},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return DeviceCode{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return DeviceCode{}, fmt.Errorf("device code request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		DeviceCode       string `json:"device_code"`
+		UserCode         string `json:"user_code"`
+		VerificationURI  string `json:"verification_uri"`
+		Message          string `json:"message"`
+		ExpiresIn        int    `json:"expires_in"`
+		Interval         int    `json:"interval"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return DeviceCode{}, fmt.Errorf("device code response: %w", err)
+	}
+	if out.Error != "" {
+		return DeviceCode{}, &TokenError{Code: out.Error, Description: out.ErrorDescription}
+	}
+	if out.Interval <= 0 {
+		out.Interval = 5
+	}
+	return DeviceCode{
+		DeviceCode:      out.DeviceCode,
+		UserCode:        out.UserCode,
+		VerificationURI: out.VerificationURI,
+		Message:         out.Message,
+		ExpiresIn:       out.ExpiresIn,
+		Interval:        out.Interval,
+	}, nil
+}
+
+// PollDevice polls the token endpoint until the user approves dc, it expires
+// or ctx is canceled.
+func (c OAuthConfig) PollDevice(ctx context.Context, dc DeviceCode) (Token, error) {
+	interval := time.Duration(dc.Interval) * time.Second
+	for {
+		select {
+		case <-ctx.Done():
+			return Token{}, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		tok, err := c.PostToken(ctx, url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {dc.DeviceCode},
+		})
+		var te *TokenError
+		if errors.As(err, &te) {
+			switch te.Code {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				interval += 5 * time.Second
+				continue
+			}
+		}
+		return tok, err
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,11 +11,14 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"pical/backups"
 	"pical/database"
 	"pical/server"
+	"pical/travel"
 
 	"github.com/joho/godotenv"
 )
@@ -24,46 +28,189 @@ func main() {
 	rootCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	name, run := command()
+
 	err := godotenv.Load("../.env")
 	if err != nil {
 		log.Fatal("Error loading .env file")
 	}
 
-	dist, err := findFrontendDist()
-	if err != nil {
-		log.Fatal(err)
+	port, _ := strconv.Atoi(getenv("DB_PORT", "5432"))
+	queryWarn, _ := strconv.Atoi(getenv("QUERY_WARN_THRESHOLD", "0"))
+	breakerFailures, _ := strconv.Atoi(getenv("DB_BREAKER_FAILURES", "5"))
+	var breaker *database.Breaker
+	if breakerFailures > 0 {
+		breaker = database.NewBreaker(breakerFailures, getenvDuration("DB_BREAKER_COOLDOWN", 10*time.Second))
 	}
-	log.Printf("Serving UI from: %s", dist)
 
-	port, _ := strconv.Atoi(getenv("DB_PORT", "5432"))
+	maxOpen, _ := strconv.Atoi(getenv("DB_MAX_OPEN_CONNS", "25"))
+	maxIdle, _ := strconv.Atoi(getenv("DB_MAX_IDLE_CONNS", "0"))
+	statementCache, _ := strconv.Atoi(getenv("DB_STATEMENT_CACHE_SIZE", "0"))
+	runtimeParams := make(map[string]string)
+	for _, kv := range strings.Split(getenv("DB_RUNTIME_PARAMS", ""), ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			runtimeParams[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
 
-	conn, err := database.Open(database.Config{
-		Host:     getenv("DB_HOST", "localhost"),
-		Port:     port,
-		User:     getenv("DB_USER", "postgres"),
-		Password: getenv("DB_PASSWORD", ""),
-		Name:     getenv("DB_NAME", "postgres"),
-		SSLMode:  getenv("DB_SSLMODE", "disable"),
+	conn, err := database.Open(rootCtx, database.Config{
+		Host:                   getenv("DB_HOST", "localhost"),
+		Port:                   port,
+		User:                   getenv("DB_USER", "postgres"),
+		Password:               getenv("DB_PASSWORD", ""),
+		Name:                   getenv("DB_NAME", "postgres"),
+		SSLMode:                getenv("DB_SSLMODE", "disable"),
+		StatementCacheCapacity: statementCache,
+		RuntimeParams:          runtimeParams,
+		MaxOpenConns:           maxOpen,
+		MaxIdleConns:           maxIdle,
+		ConnMaxLifetime:        getenvDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute),
+		ConnMaxIdleTime:        getenvDuration("DB_CONN_MAX_IDLE_TIME", 0),
+		// Counting costs a little on every query, so only when it's wanted
+		CountQueries: queryWarn > 0,
+		Breaker:      breaker,
+		ConnectWait:  getenvDuration("DB_CONNECT_WAIT", time.Minute),
 	})
 	if err != nil {
 		log.Fatalf("db open: %v", err)
 	}
 	defer conn.Close()
 
-	s, err := server.New(rootCtx, conn, dist)
+	if run != nil {
+		if err := run(rootCtx, conn, os.Args[2:]); err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		return
+	}
+
+	serveFlags := flag.NewFlagSet("serve", flag.ExitOnError)
+	demo := serveFlags.Bool("demo", false, "fill an empty database with example data first, as seed does")
+	demoZone := serveFlags.String("timezone", "UTC", "IANA `zone` the -demo events are in")
+	_ = serveFlags.Parse(os.Args[min(len(os.Args), 2):])
+	if *demo {
+		if err := seedIfEmpty(rootCtx, conn, *demoZone); err != nil {
+			log.Fatalf("demo: %v", err)
+		}
+	}
+
+	dist, err := findFrontendDist()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Serving UI from: %s", dist)
+
+	horizon, _ := strconv.Atoi(getenv("RECURRENCE_HORIZON_MONTHS", "18"))
+	missedHeartbeats, _ := strconv.Atoi(getenv("MISSED_HEARTBEATS", "3"))
+	attachmentMaxMB, _ := strconv.Atoi(getenv("ATTACHMENT_MAX_MB", "10"))
+	pageSize, _ := strconv.Atoi(getenv("PAGE_SIZE", "50"))
+	maxPageSize, _ := strconv.Atoi(getenv("MAX_PAGE_SIZE", "200"))
+	heavyConcurrency, _ := strconv.Atoi(getenv("HEAVY_CONCURRENCY", "2"))
+	backupKeep, _ := strconv.Atoi(getenv("BACKUP_KEEP", "7"))
+	backupTarget := backups.TargetConfig{
+		URL: getenv("BACKUP_TARGET", ""),
+		S3: backups.S3Config{
+			Endpoint:  getenv("BACKUP_S3_ENDPOINT", "https://s3.amazonaws.com"),
+			Region:    getenv("BACKUP_S3_REGION", "us-east-1"),
+			AccessKey: getenv("BACKUP_S3_ACCESS_KEY", ""),
+			SecretKey: getenv("BACKUP_S3_SECRET_KEY", ""),
+		},
+		Username: getenv("BACKUP_USERNAME", ""),
+		Password: getenv("BACKUP_PASSWORD", ""),
+		HostKey:  getenv("BACKUP_SSH_HOST_KEY", ""),
+	}
+	if path := getenv("BACKUP_SSH_KEY_FILE", ""); path != "" {
+		key, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("BACKUP_SSH_KEY_FILE: %v", err)
+		}
+		backupTarget.PrivateKey = string(key)
+	}
+	travelCfg := travel.Config{
+		Provider: getenv("TRAVEL_PROVIDER", ""),
+		URL:      getenv("TRAVEL_URL", ""),
+		APIKey:   getenv("TRAVEL_API_KEY", ""),
+		Mode:     getenv("TRAVEL_MODE", "driving"),
+	}
+	var travelOrigin travel.Point
+	if v := getenv("TRAVEL_ORIGIN", ""); v != "" {
+		if travelOrigin, err = travel.ParsePoint(v); err != nil {
+			log.Fatalf("TRAVEL_ORIGIN: %v", err)
+		}
+	}
+	backupRestoreDrill, _ := strconv.ParseBool(getenv("BACKUP_RESTORE_DRILL", "true"))
+	backupKey, err := backupEncryptionKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	webhookLogMaxRows, _ := strconv.Atoi(getenv("WEBHOOK_LOG_MAX_ROWS", "10000"))
+	requireAPIToken, _ := strconv.ParseBool(getenv("REQUIRE_API_TOKEN", "false"))
+	apiSpecCheck, _ := strconv.ParseBool(getenv("API_SPEC_CHECK", "false"))
+	trustedProxies, err := server.ParseTrustedProxies(getenv("TRUSTED_PROXIES", ""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	apiPrefix, err := server.ParseAPIPrefix(getenv("API_PREFIX", "/api/v1"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s, err := server.New(rootCtx, conn, dist, server.Config{
+		FeedRefreshInterval:     getenvDuration("FEED_REFRESH_INTERVAL", time.Hour),
+		JoinWindow:              getenvDuration("JOIN_WINDOW", 10*time.Minute),
+		MaxClockSkew:            getenvDuration("MAX_CLOCK_SKEW", 10*time.Minute),
+		RecurrenceHorizonMonths: horizon,
+		QueryWarnThreshold:      queryWarn,
+		HeartbeatInterval:       getenvDuration("HEARTBEAT_INTERVAL", time.Minute),
+		MissedHeartbeats:        missedHeartbeats,
+		AlertURL:                getenv("ALERT_URL", ""),
+		BackupTarget:            backupTarget,
+		BackupKey:               backupKey,
+		BackupInterval:          getenvDuration("BACKUP_INTERVAL", 24*time.Hour),
+		BackupKeep:              backupKeep,
+		BackupRestoreDrill:      backupRestoreDrill,
+		TelemetryURL:            getenv("TELEMETRY_URL", ""),
+		TTSCommand:              getenv("TTS_COMMAND", "espeak-ng --stdout"),
+		GeocoderURL:             getenv("GEOCODER_URL", ""),
+		Travel:                  travelCfg,
+		TravelOrigin:            travelOrigin,
+		AttachmentDir:           getenv("ATTACHMENT_DIR", "../data/attachments"),
+		AttachmentMaxBytes:      int64(attachmentMaxMB) << 20,
+		AttachmentTypes:         strings.Split(getenv("ATTACHMENT_TYPES", "image/jpeg,image/png,image/gif,image/webp,application/pdf,text/plain"), ","),
+		AttachmentScanner:       getenv("ATTACHMENT_SCANNER", ""),
+		PageSize:                pageSize,
+		MaxPageSize:             maxPageSize,
+		HeavyConcurrency:        heavyConcurrency,
+		HeavyQueueTimeout:       getenvDuration("HEAVY_QUEUE_TIMEOUT", 5*time.Second),
+		TrashRetention:          getenvDuration("TRASH_RETENTION", 30*24*time.Hour),
+		ChangeRetention:         getenvDuration("CHANGE_LOG_RETENTION", 90*24*time.Hour),
+		WebhookLogRetention:     getenvDuration("WEBHOOK_LOG_RETENTION", 30*24*time.Hour),
+		WebhookLogMaxRows:       webhookLogMaxRows,
+		RequireAPIToken:         requireAPIToken,
+		TrustedProxies:          trustedProxies,
+		Breaker:                 breaker,
+		APISpecCheck:            apiSpecCheck,
+		APIPrefix:               apiPrefix,
+		GoogleClientID:          getenv("GOOGLE_CLIENT_ID", ""),
+		GoogleClientSecret:      getenv("GOOGLE_CLIENT_SECRET", ""),
+		GoogleRedirectURL:       getenv("GOOGLE_REDIRECT_URL", "http://localhost:8080/api/integrations/google/callback"),
+		GoogleSyncInterval:      getenvDuration("GOOGLE_SYNC_INTERVAL", 15*time.Minute),
+		MicrosoftClientID:       getenv("MICROSOFT_CLIENT_ID", ""),
+		MicrosoftTenant:         getenv("MICROSOFT_TENANT", "common"),
+		MicrosoftSyncInterval:   getenvDuration("MICROSOFT_SYNC_INTERVAL", 15*time.Minute),
+	})
 	if err != nil {
 		log.Fatalf("Failed to create server! %v", err)
 	}
 
-	pical_port _ := strconv.Atoi(getenv("PICAL_PORT", "8080"))
+	picalPort, _ := strconv.Atoi(getenv("PICAL_PORT", "8080"))
 	httpSrv := &http.Server{
-		Addr:    ":8080",
-		Handler: s.Mux,
+		Addr:    fmt.Sprintf(":%d", picalPort),
+		Handler: s.Handler(),
 	}
 
 	// Run server in background
 	go func() {
-		log.Println("listening on :8080")
+		log.Printf("listening on %s", httpSrv.Addr)
 		if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("listen: %v", err)
 		}
@@ -91,6 +238,19 @@ func getenv(k, def string) string {
 	return def
 }
 
+func getenvDuration(k string, def time.Duration) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("invalid %s %q, using %s", k, v, def)
+		return def
+	}
+	return d
+}
+
 // Finding the frontend directory
 
 func findFrontendDist() (string, error) {
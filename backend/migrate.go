@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"pical/database/schemas"
+)
+
+// runMigrate handles `server migrate [status | up | down [n] | to <version> | verify]`,
+// which moves the database between versions without starting the server.
+// down undoes the last n migrations, one by default. verify builds every
+// table from scratch out of the way of the real ones and checks the result.
+func runMigrate(ctx context.Context, db *sql.DB, args []string) error {
+	cmd := "status"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	applied, err := schemas.ListAppliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "status":
+		for _, m := range applied {
+			fmt.Printf("%4d  %-30s  %s\n", m.Version, m.Name, m.AppliedAt.Format("2006-01-02 15:04:05"))
+		}
+		current := 0
+		if len(applied) > 0 {
+			current = applied[len(applied)-1].Version
+		}
+		fmt.Printf("at migration %d of %d\n", current, schemas.LatestMigration())
+		return nil
+	case "up":
+		return schemas.MigrateTo(ctx, db, schemas.LatestMigration())
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				return fmt.Errorf("down takes a number of migrations to undo")
+			}
+		}
+		target := 0
+		if n < len(applied) {
+			target = applied[len(applied)-1-n].Version
+		}
+		return schemas.MigrateTo(ctx, db, target)
+	case "to":
+		if len(args) < 2 {
+			return fmt.Errorf("to takes a migration version")
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("to takes a migration version")
+		}
+		return schemas.MigrateTo(ctx, db, version)
+	case "verify":
+		tables, fks, err := schemas.VerifyBootstrap(ctx, db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d tables and %d foreign keys build from scratch as declared\n", tables, fks)
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q; use status, up, down [n], to <version> or verify", cmd)
+}
@@ -0,0 +1,98 @@
+// Package push sends notifications to self-hosted push servers.
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Message is one notification.
+type Message struct {
+	Title string
+	Body  string
+}
+
+// Notifier delivers messages to one destination.
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var client = &http.Client{Timeout: 15 * time.Second}
+
+// Ntfy publishes to a topic on an ntfy server, https://ntfy.sh or a
+// self-hosted one. The token is only needed for protected topics.
+type Ntfy struct {
+	topicURL string
+	token    string
+}
+
+func NewNtfy(serverURL, topic, token string) *Ntfy {
+	return &Ntfy{topicURL: strings.TrimRight(serverURL, "/") + "/" + url.PathEscape(topic), token: token}
+}
+
+func (n *Ntfy) Send(ctx context.Context, msg Message) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.topicURL, strings.NewReader(msg.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if msg.Title != "" {
+		// Header values must stay on one line
+		req.Header.Set("Title", strings.Join(strings.Fields(msg.Title), " "))
+	}
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+
+	return send(req, "ntfy")
+}
+
+// Gotify posts to a Gotify server as the application the token belongs to.
+type Gotify struct {
+	messageURL string
+	token      string
+}
+
+func NewGotify(serverURL, token string) *Gotify {
+	return &Gotify{messageURL: strings.TrimRight(serverURL, "/") + "/message", token: token}
+}
+
+func (g *Gotify) Send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(struct {
+		Title   string `json:"title,omitempty"`
+		Message string `json:"message"`
+	}{msg.Title, msg.Body})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.messageURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.token)
+
+	return send(req, "gotify")
+}
+
+func send(req *http.Request, service string) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", service, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: unexpected status %s: %s", service, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
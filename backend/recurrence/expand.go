@@ -0,0 +1,156 @@
+package recurrence
+
+import (
+	"sort"
+	"time"
+
+	"pical/database/schemas"
+)
+
+// Occurrence is one concrete instance of an event.
+type Occurrence struct {
+	EventID string `json:"eventId"`
+	// Original start of the instance; identifies it in exceptions
+	RecurrenceID time.Time  `json:"recurrenceId"`
+	StartTime    time.Time  `json:"startTime"`
+	EndTime      *time.Time `json:"endTime,omitempty"`
+	Moved        bool       `json:"moved,omitempty"`
+}
+
+// Recurs reports whether e has a recurrence rule.
+func Recurs(e schemas.Event) bool {
+	return e.Rrule != nil && *e.Rrule != ""
+}
+
+// Expand returns e's occurrences that overlap [from, to), applying exs and
+// skipping those e's pauses cover. One-off events, and events whose rule
+// can't be parsed, yield at most their single stored occurrence.
+func Expand(e schemas.Event, exs []schemas.Exception, from, to time.Time) []Occurrence {
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	start := e.StartTime.In(loc)
+
+	var length time.Duration
+	if e.EndTime != nil {
+		length = e.EndTime.Sub(e.StartTime)
+	}
+	// All-day spans are whole days, which are not always 24h long
+	days := 0
+	if e.AllDay && length > 0 {
+		days = int((length + 12*time.Hour) / (24 * time.Hour))
+	}
+	makeOccurrence := func(recurrenceID, start time.Time) Occurrence {
+		occ := Occurrence{EventID: e.EventID, RecurrenceID: recurrenceID, StartTime: start}
+		switch {
+		case days > 0:
+			end := start.AddDate(0, 0, days)
+			occ.EndTime = &end
+		case length > 0:
+			end := start.Add(length)
+			occ.EndTime = &end
+		}
+		return occ
+	}
+
+	out := make([]Occurrence, 0)
+	if !Recurs(e) {
+		if occ := makeOccurrence(start, start); occ.overlaps(from, to) {
+			out = append(out, occ)
+		}
+		return out
+	}
+
+	rule, err := Parse(*e.Rrule)
+	if err != nil {
+		if occ := makeOccurrence(start, start); occ.overlaps(from, to) {
+			out = append(out, occ)
+		}
+		return out
+	}
+
+	byID := make(map[int64]schemas.Exception, len(exs))
+	for _, ex := range exs {
+		byID[ex.RecurrenceID.UnixNano()] = ex
+	}
+
+	// Start early enough to catch instances that began before from but are still running
+	for _, t := range rule.Between(start, from.Add(-length-24*time.Hour), to) {
+		if _, ok := byID[t.UnixNano()]; ok || Paused(e, t) {
+			continue
+		}
+		if occ := makeOccurrence(t, t); occ.overlaps(from, to) {
+			out = append(out, occ)
+		}
+	}
+
+	// Moved instances can land in the window from anywhere in the series
+	for _, ex := range exs {
+		if ex.Kind != schemas.ExceptionMove || ex.NewStart == nil {
+			continue
+		}
+		occ := makeOccurrence(ex.RecurrenceID.In(loc), ex.NewStart.In(loc))
+		if ex.NewEnd != nil {
+			end := ex.NewEnd.In(loc)
+			occ.EndTime = &end
+		}
+		occ.Moved = true
+		if occ.overlaps(from, to) {
+			out = append(out, occ)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].StartTime.Before(out[j].StartTime) })
+	return out
+}
+
+// Paused reports whether the occurrence of e originally starting at
+// recurrenceID falls in one of e's pauses.
+func Paused(e schemas.Event, recurrenceID time.Time) bool {
+	for _, p := range e.Pauses {
+		if p.Covers(recurrenceID) {
+			return true
+		}
+	}
+	return false
+}
+
+// PausedStarts returns the original starts of the occurrences e's pauses
+// skip, in order, for writing out as excluded dates.
+func PausedStarts(e schemas.Event) []time.Time {
+	out := make([]time.Time, 0)
+	if !Recurs(e) || len(e.Pauses) == 0 {
+		return out
+	}
+	rule, err := Parse(*e.Rrule)
+	if err != nil {
+		return out
+	}
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	until := e.Pauses[0].Until
+	for _, p := range e.Pauses {
+		if p.Until.After(until) {
+			until = p.Until
+		}
+	}
+	for _, t := range rule.Between(e.StartTime.In(loc), e.Pauses[0].From, until) {
+		if Paused(e, t) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func (o Occurrence) overlaps(from, to time.Time) bool {
+	if !o.StartTime.Before(to) {
+		return false
+	}
+	if o.EndTime == nil || !o.EndTime.After(o.StartTime) {
+		return !o.StartTime.Before(from)
+	}
+	return o.EndTime.After(from)
+}
@@ -0,0 +1,198 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+
+	"pical/database/schemas"
+)
+
+func TestExpand(t *testing.T) {
+	london := mustLoad(t, "Europe/London")
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2025, month, day, hour, 0, 0, 0, london)
+	}
+	ptr := func(t time.Time) *time.Time { return &t }
+	rrule := func(s string) *string { return &s }
+
+	// Tuesdays at 6pm for an hour, over the spring change
+	swimming := schemas.Event{
+		EventID:   "swimming",
+		Timezone:  "Europe/London",
+		Rrule:     rrule("FREQ=WEEKLY;BYDAY=TU;COUNT=5"),
+		StartTime: at(time.March, 18, 18),
+		EndTime:   ptr(at(time.March, 18, 19)),
+	}
+
+	tests := []struct {
+		name     string
+		event    schemas.Event
+		exs      []schemas.Exception
+		from, to time.Time
+		want     []string // RFC 3339 start and end, and a * when moved
+	}{
+		{
+			name:  "plain series",
+			event: swimming,
+			from:  at(time.March, 1, 0),
+			to:    at(time.May, 1, 0),
+			want: []string{
+				"2025-03-18T18:00:00Z 2025-03-18T19:00:00Z",
+				"2025-03-25T18:00:00Z 2025-03-25T19:00:00Z",
+				"2025-04-01T18:00:00+01:00 2025-04-01T19:00:00+01:00",
+				"2025-04-08T18:00:00+01:00 2025-04-08T19:00:00+01:00",
+				"2025-04-15T18:00:00+01:00 2025-04-15T19:00:00+01:00",
+			},
+		},
+		{
+			name:  "cancelled and moved occurrences",
+			event: swimming,
+			exs: []schemas.Exception{
+				{RecurrenceID: at(time.March, 25, 18), Kind: schemas.ExceptionCancel},
+				{RecurrenceID: at(time.April, 1, 18), Kind: schemas.ExceptionMove, NewStart: ptr(at(time.April, 2, 17))},
+				{RecurrenceID: at(time.April, 8, 18), Kind: schemas.ExceptionMove, NewStart: ptr(at(time.April, 8, 9)), NewEnd: ptr(at(time.April, 8, 11))},
+			},
+			from: at(time.March, 1, 0),
+			to:   at(time.May, 1, 0),
+			want: []string{
+				"2025-03-18T18:00:00Z 2025-03-18T19:00:00Z",
+				"2025-04-02T17:00:00+01:00 2025-04-02T18:00:00+01:00 *",
+				"2025-04-08T09:00:00+01:00 2025-04-08T11:00:00+01:00 *",
+				"2025-04-15T18:00:00+01:00 2025-04-15T19:00:00+01:00",
+			},
+		},
+		{
+			name:  "moved into the window from outside it",
+			event: swimming,
+			exs: []schemas.Exception{
+				{RecurrenceID: at(time.April, 15, 18), Kind: schemas.ExceptionMove, NewStart: ptr(at(time.March, 20, 18))},
+			},
+			from: at(time.March, 19, 0),
+			to:   at(time.March, 24, 0),
+			want: []string{
+				"2025-03-20T18:00:00Z 2025-03-20T19:00:00Z *",
+			},
+		},
+		{
+			name:  "an occurrence already running when the window opens",
+			event: swimming,
+			from:  at(time.March, 18, 18).Add(30 * time.Minute),
+			to:    at(time.March, 20, 0),
+			want: []string{
+				"2025-03-18T18:00:00Z 2025-03-18T19:00:00Z",
+			},
+		},
+		{
+			name: "paused occurrences",
+			event: func() schemas.Event {
+				e := swimming
+				e.Pauses = schemas.Pauses{{From: at(time.March, 24, 0), Until: at(time.April, 7, 0)}}
+				return e
+			}(),
+			from: at(time.March, 1, 0),
+			to:   at(time.May, 1, 0),
+			want: []string{
+				"2025-03-18T18:00:00Z 2025-03-18T19:00:00Z",
+				"2025-04-08T18:00:00+01:00 2025-04-08T19:00:00+01:00",
+				"2025-04-15T18:00:00+01:00 2025-04-15T19:00:00+01:00",
+			},
+		},
+		{
+			name: "all-day days stay whole over the change",
+			event: schemas.Event{
+				EventID:   "holiday",
+				Timezone:  "Europe/London",
+				AllDay:    true,
+				Rrule:     rrule("FREQ=DAILY;COUNT=3"),
+				StartTime: at(time.March, 29, 0),
+				EndTime:   ptr(at(time.March, 30, 0)),
+			},
+			from: at(time.March, 1, 0),
+			to:   at(time.May, 1, 0),
+			want: []string{
+				"2025-03-29T00:00:00Z 2025-03-30T00:00:00Z",
+				"2025-03-30T00:00:00Z 2025-03-31T00:00:00+01:00",
+				"2025-03-31T00:00:00+01:00 2025-04-01T00:00:00+01:00",
+			},
+		},
+		{
+			name: "one-off event",
+			event: schemas.Event{
+				EventID:   "dentist",
+				Timezone:  "Europe/London",
+				StartTime: at(time.April, 2, 10),
+			},
+			from: at(time.April, 1, 0),
+			to:   at(time.April, 3, 0),
+			want: []string{
+				"2025-04-02T10:00:00+01:00",
+			},
+		},
+		{
+			name: "unparseable rule falls back to the stored occurrence",
+			event: func() schemas.Event {
+				e := swimming
+				e.Rrule = rrule("FREQ=SECONDLY")
+				return e
+			}(),
+			from: at(time.March, 1, 0),
+			to:   at(time.May, 1, 0),
+			want: []string{
+				"2025-03-18T18:00:00Z 2025-03-18T19:00:00Z",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Expand(tt.event, tt.exs, tt.from, tt.to)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d occurrences %+v, want %v", len(got), got, tt.want)
+			}
+			for i, occ := range got {
+				s := occ.StartTime.Format(time.RFC3339)
+				if occ.EndTime != nil {
+					s += " " + occ.EndTime.Format(time.RFC3339)
+				}
+				if occ.Moved {
+					s += " *"
+				}
+				if s != tt.want[i] {
+					t.Errorf("occurrence %d: got %s, want %s", i, s, tt.want[i])
+				}
+				if occ.EventID != tt.event.EventID {
+					t.Errorf("occurrence %d: eventId %q, want %q", i, occ.EventID, tt.event.EventID)
+				}
+			}
+		})
+	}
+}
+
+func TestPausedStarts(t *testing.T) {
+	london := mustLoad(t, "Europe/London")
+	rrule := "FREQ=DAILY"
+	e := schemas.Event{
+		Timezone:  "Europe/London",
+		Rrule:     &rrule,
+		StartTime: time.Date(2025, 3, 28, 9, 0, 0, 0, london),
+		Pauses: schemas.Pauses{
+			{From: time.Date(2025, 3, 29, 0, 0, 0, 0, london), Until: time.Date(2025, 3, 31, 0, 0, 0, 0, london)},
+			{From: time.Date(2025, 4, 2, 0, 0, 0, 0, london), Until: time.Date(2025, 4, 3, 0, 0, 0, 0, london)},
+		},
+	}
+
+	want := []string{
+		"2025-03-29T09:00:00Z",
+		"2025-03-30T09:00:00+01:00",
+		"2025-04-02T09:00:00+01:00",
+	}
+	got := PausedStarts(e)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if s := got[i].Format(time.RFC3339); s != want[i] {
+			t.Errorf("start %d: got %s, want %s", i, s, want[i])
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package recurrence
+
+import "time"
+
+// LocalKind is how a wall-clock time falls against its zone's DST changes.
+type LocalKind int
+
+const (
+	LocalNormal LocalKind = iota
+	// Skipped when the clocks go forward, like 02:30 on a spring-forward night
+	LocalNonexistent
+	// Passed twice when the clocks go back, like 01:30 on a fall-back night
+	LocalAmbiguous
+)
+
+func (k LocalKind) String() string {
+	switch k {
+	case LocalNonexistent:
+		return "nonexistent"
+	case LocalAmbiguous:
+		return "ambiguous"
+	}
+	return "normal"
+}
+
+// ResolveLocal is time.Date with RFC 5545's rules for times a DST change
+// upsets, which time.Date leaves unspecified. A nonexistent time is read
+// with the offset from before the change, so it lands as far past the change
+// as it was meant to be past the missing hour's start: 02:30 becomes 03:30.
+// An ambiguous time is the first of the two, before the clocks go back.
+// Out-of-range fields normalize as they do for time.Date.
+func ResolveLocal(year int, month time.Month, day, hour, min, sec int, loc *time.Location) (time.Time, LocalKind) {
+	// The wall clock as if it were UTC, normalized
+	naive := time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+	// Zones change offset at most once in a couple of days, so the offsets a
+	// day either side are the only two the time can have
+	_, before := naive.Add(-24 * time.Hour).In(loc).Zone()
+	_, after := naive.Add(24 * time.Hour).In(loc).Zone()
+
+	early := naive.Add(-time.Duration(before) * time.Second).In(loc)
+	late := naive.Add(-time.Duration(after) * time.Second).In(loc)
+	earlyOK, lateOK := sameClock(early, naive), sameClock(late, naive)
+	switch {
+	case earlyOK && lateOK && !early.Equal(late):
+		if late.Before(early) {
+			return late, LocalAmbiguous
+		}
+		return early, LocalAmbiguous
+	case earlyOK:
+		return early, LocalNormal
+	case lateOK:
+		return late, LocalNormal
+	case before != after:
+		return early, LocalNonexistent
+	}
+	return time.Date(year, month, day, hour, min, sec, 0, loc), LocalNormal
+}
+
+// sameClock reports whether t reads the same date and time as naive does in UTC.
+func sameClock(t, naive time.Time) bool {
+	y, m, d := t.Date()
+	hh, mm, ss := t.Clock()
+	return time.Date(y, m, d, hh, mm, ss, 0, time.UTC).Equal(naive)
+}
+
+// sameDate reports whether a and b fall on the same date in their own locations.
+func sameDate(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
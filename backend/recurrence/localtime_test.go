@@ -0,0 +1,117 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("load %s: %v", name, err)
+	}
+	return loc
+}
+
+func TestResolveLocal(t *testing.T) {
+	tests := []struct {
+		name  string
+		zone  string
+		clock time.Time // the wall clock asked for, in UTC
+		want  string    // RFC 3339
+		kind  LocalKind
+	}{
+		{"plain winter", "America/New_York", time.Date(2025, 1, 15, 9, 0, 0, 0, time.UTC), "2025-01-15T09:00:00-05:00", LocalNormal},
+		{"plain summer", "America/New_York", time.Date(2025, 6, 15, 9, 0, 0, 0, time.UTC), "2025-06-15T09:00:00-04:00", LocalNormal},
+		{"spring forward gap", "America/New_York", time.Date(2025, 3, 9, 2, 30, 0, 0, time.UTC), "2025-03-09T03:30:00-04:00", LocalNonexistent},
+		{"just before the gap", "America/New_York", time.Date(2025, 3, 9, 1, 59, 0, 0, time.UTC), "2025-03-09T01:59:00-05:00", LocalNormal},
+		{"just after the gap", "America/New_York", time.Date(2025, 3, 9, 3, 0, 0, 0, time.UTC), "2025-03-09T03:00:00-04:00", LocalNormal},
+		{"fall back overlap", "America/New_York", time.Date(2025, 11, 2, 1, 30, 0, 0, time.UTC), "2025-11-02T01:30:00-04:00", LocalAmbiguous},
+		{"after the overlap", "America/New_York", time.Date(2025, 11, 2, 2, 0, 0, 0, time.UTC), "2025-11-02T02:00:00-05:00", LocalNormal},
+		{"london gap", "Europe/London", time.Date(2025, 3, 30, 1, 30, 0, 0, time.UTC), "2025-03-30T02:30:00+01:00", LocalNonexistent},
+		{"london overlap", "Europe/London", time.Date(2025, 10, 26, 1, 30, 0, 0, time.UTC), "2025-10-26T01:30:00+01:00", LocalAmbiguous},
+		{"normalizes fields", "America/New_York", time.Date(2025, 3, 8, 26, 30, 0, 0, time.UTC), "2025-03-09T03:30:00-04:00", LocalNonexistent},
+		{"no dst", "UTC", time.Date(2025, 3, 9, 2, 30, 0, 0, time.UTC), "2025-03-09T02:30:00Z", LocalNormal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc := mustLoad(t, tt.zone)
+			c := tt.clock
+			got, kind := ResolveLocal(c.Year(), c.Month(), c.Day(), c.Hour(), c.Minute(), c.Second(), loc)
+			if got.Format(time.RFC3339) != tt.want || kind != tt.kind {
+				t.Errorf("got %s (%s), want %s (%s)", got.Format(time.RFC3339), kind, tt.want, tt.kind)
+			}
+		})
+	}
+}
+
+func TestBetweenAcrossDST(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+
+	tests := []struct {
+		name     string
+		rrule    string
+		dtstart  time.Time
+		from, to time.Time
+		want     []string // RFC 3339
+	}{
+		{
+			name:    "daily into the spring gap",
+			rrule:   "FREQ=DAILY",
+			dtstart: time.Date(2025, 3, 7, 2, 30, 0, 0, ny),
+			from:    time.Date(2025, 3, 7, 0, 0, 0, 0, ny),
+			to:      time.Date(2025, 3, 11, 0, 0, 0, 0, ny),
+			want: []string{
+				"2025-03-07T02:30:00-05:00",
+				"2025-03-08T02:30:00-05:00",
+				"2025-03-09T03:30:00-04:00",
+				"2025-03-10T02:30:00-04:00",
+			},
+		},
+		{
+			name:    "daily through the fall overlap",
+			rrule:   "FREQ=DAILY",
+			dtstart: time.Date(2025, 10, 31, 1, 30, 0, 0, ny),
+			from:    time.Date(2025, 11, 1, 0, 0, 0, 0, ny),
+			to:      time.Date(2025, 11, 4, 0, 0, 0, 0, ny),
+			want: []string{
+				"2025-11-01T01:30:00-04:00",
+				"2025-11-02T01:30:00-04:00",
+				"2025-11-03T01:30:00-05:00",
+			},
+		},
+		{
+			name:    "weekly keeps its wall clock",
+			rrule:   "FREQ=WEEKLY;BYDAY=SU",
+			dtstart: time.Date(2025, 3, 2, 9, 0, 0, 0, ny),
+			from:    time.Date(2025, 3, 1, 0, 0, 0, 0, ny),
+			to:      time.Date(2025, 3, 17, 0, 0, 0, 0, ny),
+			want: []string{
+				"2025-03-02T09:00:00-05:00",
+				"2025-03-09T09:00:00-04:00",
+				"2025-03-16T09:00:00-04:00",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rrule)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tt.rrule, err)
+			}
+			got := rule.Between(tt.dtstart, tt.from, tt.to)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d starts %v, want %v", len(got), got, tt.want)
+			}
+			for i, start := range got {
+				if start.Format(time.RFC3339) != tt.want[i] {
+					t.Errorf("start %d: got %s, want %s", i, start.Format(time.RFC3339), tt.want[i])
+				}
+			}
+		})
+	}
+}
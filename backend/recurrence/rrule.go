@@ -0,0 +1,523 @@
+// Package recurrence expands RFC 5545 recurrence rules into occurrences.
+//
+// Only the parts of RRULE a family calendar actually produces are supported:
+// DAILY, WEEKLY, MONTHLY and YEARLY frequencies with INTERVAL, COUNT, UNTIL,
+// BYDAY (with ordinals), BYMONTHDAY, BYMONTH, BYSETPOS and WKST. Occurrences
+// keep DTSTART's wall-clock time in its location, so a 9am series stays at
+// 9am across DST changes; see ResolveLocal for times a change skips or
+// repeats.
+package recurrence
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency int
+
+const (
+	Daily Frequency = iota
+	Weekly
+	Monthly
+	Yearly
+)
+
+// WeekdayNum is a BYDAY entry such as "MO" or "-1FR" (N is 0 when there is no ordinal).
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+// Rule is a parsed RRULE.
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	Until      *time.Time
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []time.Month
+	BySetPos   []int
+	WeekStart  time.Weekday
+
+	// UNTIL given as a DATE, or as a floating local time, is only resolved
+	// once the series' zone is known
+	untilDate  string
+	untilLocal string
+}
+
+// maxPeriods bounds expansion of rules that never produce a match.
+const maxPeriods = 100_000
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// Parse reads an RRULE value, with or without the "RRULE:" prefix.
+func Parse(s string) (Rule, error) {
+	r := Rule{Interval: 1, WeekStart: time.Monday}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+
+	freqSet := false
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return r, fmt.Errorf("rrule: malformed part %q", part)
+		}
+
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			switch strings.ToUpper(value) {
+			case "DAILY":
+				r.Freq = Daily
+			case "WEEKLY":
+				r.Freq = Weekly
+			case "MONTHLY":
+				r.Freq = Monthly
+			case "YEARLY":
+				r.Freq = Yearly
+			default:
+				return r, fmt.Errorf("rrule: unsupported FREQ %q", value)
+			}
+			freqSet = true
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return r, fmt.Errorf("rrule: bad INTERVAL %q", value)
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return r, fmt.Errorf("rrule: bad COUNT %q", value)
+			}
+			r.Count = n
+		case "UNTIL":
+			if len(value) == 8 {
+				if _, err := time.Parse("20060102", value); err != nil {
+					return r, fmt.Errorf("rrule: bad UNTIL %q", value)
+				}
+				r.untilDate = value
+				continue
+			}
+			if !strings.HasSuffix(value, "Z") {
+				if _, err := time.Parse("20060102T150405", value); err != nil {
+					return r, fmt.Errorf("rrule: bad UNTIL %q", value)
+				}
+				r.untilLocal = value
+				continue
+			}
+			t, err := time.Parse("20060102T150405Z", value)
+			if err != nil {
+				return r, fmt.Errorf("rrule: bad UNTIL %q", value)
+			}
+			r.Until = &t
+		case "BYDAY":
+			for _, v := range strings.Split(value, ",") {
+				wd, err := parseWeekdayNum(v)
+				if err != nil {
+					return r, err
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, v := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(v)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return r, fmt.Errorf("rrule: bad BYMONTHDAY %q", v)
+				}
+				r.ByMonthDay = append(r.ByMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, v := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 1 || n > 12 {
+					return r, fmt.Errorf("rrule: bad BYMONTH %q", v)
+				}
+				r.ByMonth = append(r.ByMonth, time.Month(n))
+			}
+		case "BYSETPOS":
+			for _, v := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(v)
+				if err != nil || n == 0 {
+					return r, fmt.Errorf("rrule: bad BYSETPOS %q", v)
+				}
+				r.BySetPos = append(r.BySetPos, n)
+			}
+		case "WKST":
+			wd, ok := weekdays[strings.ToUpper(value)]
+			if !ok {
+				return r, fmt.Errorf("rrule: bad WKST %q", value)
+			}
+			r.WeekStart = wd
+		default:
+			// BYHOUR, BYMINUTE and friends are ignored; occurrences use DTSTART's time of day
+		}
+	}
+
+	if !freqSet {
+		return r, fmt.Errorf("rrule: FREQ is required")
+	}
+	return r, nil
+}
+
+func parseWeekdayNum(v string) (WeekdayNum, error) {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	if len(v) < 2 {
+		return WeekdayNum{}, fmt.Errorf("rrule: bad BYDAY %q", v)
+	}
+	day, ok := weekdays[v[len(v)-2:]]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("rrule: bad BYDAY %q", v)
+	}
+	wd := WeekdayNum{Day: day}
+	if prefix := v[:len(v)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -53 || n > 53 {
+			return WeekdayNum{}, fmt.Errorf("rrule: bad BYDAY %q", v)
+		}
+		wd.N = n
+	}
+	return wd, nil
+}
+
+// String formats the rule back into an RRULE value (without the "RRULE:" prefix).
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + [...]string{"DAILY", "WEEKLY", "MONTHLY", "YEARLY"}[r.Freq]}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	switch {
+	case r.Until != nil:
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	case r.untilDate != "":
+		parts = append(parts, "UNTIL="+r.untilDate)
+	case r.untilLocal != "":
+		parts = append(parts, "UNTIL="+r.untilLocal)
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, 0, len(r.ByDay))
+		for _, wd := range r.ByDay {
+			s := strings.ToUpper(wd.Day.String()[:2])
+			if wd.N != 0 {
+				s = strconv.Itoa(wd.N) + s
+			}
+			days = append(days, s)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+joinInts(r.ByMonthDay))
+	}
+	if len(r.ByMonth) > 0 {
+		ms := make([]int, 0, len(r.ByMonth))
+		for _, m := range r.ByMonth {
+			ms = append(ms, int(m))
+		}
+		parts = append(parts, "BYMONTH="+joinInts(ms))
+	}
+	if len(r.BySetPos) > 0 {
+		parts = append(parts, "BYSETPOS="+joinInts(r.BySetPos))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+strings.ToUpper(r.WeekStart.String()[:2]))
+	}
+	return strings.Join(parts, ";")
+}
+
+func joinInts(ns []int) string {
+	s := make([]string, 0, len(ns))
+	for _, n := range ns {
+		s = append(s, strconv.Itoa(n))
+	}
+	return strings.Join(s, ",")
+}
+
+// SetUntil ends the series at t (inclusive), replacing any COUNT.
+func (r *Rule) SetUntil(t time.Time) {
+	u := t.UTC()
+	r.Until, r.Count = &u, 0
+	r.untilDate, r.untilLocal = "", ""
+}
+
+// SetUntilDate ends the series on d's date (inclusive), replacing any COUNT.
+// All-day series use this form, since their UNTIL must be a DATE.
+func (r *Rule) SetUntilDate(d time.Time) {
+	r.Until, r.Count = nil, 0
+	r.untilDate, r.untilLocal = d.Format("20060102"), ""
+}
+
+// UntilAt resolves UNTIL for a series starting at dtstart, or nil when the
+// series has no end date.
+func (r Rule) UntilAt(dtstart time.Time) *time.Time {
+	switch {
+	case r.Until != nil:
+		return r.Until
+	case r.untilDate != "":
+		d, _ := time.ParseInLocation("20060102", r.untilDate, dtstart.Location())
+		end := d.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		return &end
+	case r.untilLocal != "":
+		t, _ := time.ParseInLocation("20060102T150405", r.untilLocal, dtstart.Location())
+		return &t
+	}
+	return nil
+}
+
+// Starts calls fn with each occurrence start of the series beginning at
+// dtstart, in order, until fn returns false or the series ends.
+func (r Rule) Starts(dtstart time.Time, fn func(time.Time) bool) {
+	until := r.UntilAt(dtstart)
+	loc := dtstart.Location()
+	hh, mm, ss := dtstart.Clock()
+
+	emitted := 0
+	for period := 0; period < maxPeriods; period++ {
+		for _, day := range r.periodDays(dtstart, period) {
+			t, _ := ResolveLocal(day.Year(), day.Month(), day.Day(), hh, mm, ss, loc)
+			// DTSTART itself may be the second of an ambiguous pair
+			if sameDate(day, dtstart) {
+				t = dtstart
+			}
+			if t.Before(dtstart) {
+				continue
+			}
+			if until != nil && t.After(*until) {
+				return
+			}
+			if !fn(t) {
+				return
+			}
+			emitted++
+			if r.Count > 0 && emitted >= r.Count {
+				return
+			}
+		}
+	}
+}
+
+// Between returns the occurrence starts in [from, to).
+func (r Rule) Between(dtstart, from, to time.Time) []time.Time {
+	var out []time.Time
+	r.Starts(dtstart, func(t time.Time) bool {
+		if !t.Before(to) {
+			return false
+		}
+		if !t.Before(from) {
+			out = append(out, t)
+		}
+		return true
+	})
+	return out
+}
+
+// periodDays returns the candidate dates (at midnight) in the n-th period of
+// the series, already filtered and sorted, with BYSETPOS applied.
+func (r Rule) periodDays(dtstart time.Time, n int) []time.Time {
+	loc := dtstart.Location()
+	y, m, d := dtstart.Date()
+	var days []time.Time
+
+	switch r.Freq {
+	case Daily:
+		day := time.Date(y, m, d+n*r.Interval, 0, 0, 0, 0, loc)
+		if r.matchMonth(day) && r.matchMonthDay(day) && r.matchWeekdayPlain(day) {
+			days = append(days, day)
+		}
+
+	case Weekly:
+		first := time.Date(y, m, d, 0, 0, 0, 0, loc)
+		offset := (int(first.Weekday()) - int(r.WeekStart) + 7) % 7
+		weekStart := first.AddDate(0, 0, -offset+7*n*r.Interval)
+		for i := 0; i < 7; i++ {
+			day := weekStart.AddDate(0, 0, i)
+			if !r.matchMonth(day) {
+				continue
+			}
+			if len(r.ByDay) == 0 {
+				if day.Weekday() == dtstart.Weekday() {
+					days = append(days, day)
+				}
+				continue
+			}
+			if r.matchWeekdayPlain(day) {
+				days = append(days, day)
+			}
+		}
+
+	case Monthly:
+		month := time.Date(y, m+time.Month(n*r.Interval), 1, 0, 0, 0, 0, loc)
+		if r.matchMonth(month) {
+			days = r.monthDays(month, d)
+		}
+
+	case Yearly:
+		year := y + n*r.Interval
+		switch {
+		case len(r.ByMonth) > 0:
+			for _, bm := range sortedMonths(r.ByMonth) {
+				days = append(days, r.monthDays(time.Date(year, bm, 1, 0, 0, 0, 0, loc), d)...)
+			}
+		case len(r.ByDay) > 0 && len(r.ByMonthDay) == 0:
+			days = r.yearWeekdays(year, loc)
+		default:
+			days = r.monthDays(time.Date(year, m, 1, 0, 0, 0, 0, loc), d)
+		}
+	}
+
+	return r.applySetPos(days)
+}
+
+// monthDays lists the matching dates of one month. defaultDay is used when
+// neither BYMONTHDAY nor BYDAY restrict the month.
+func (r Rule) monthDays(month time.Time, defaultDay int) []time.Time {
+	loc := month.Location()
+	last := month.AddDate(0, 1, -1).Day()
+
+	var days []time.Time
+	switch {
+	case len(r.ByMonthDay) > 0:
+		seen := map[int]bool{}
+		for _, md := range r.ByMonthDay {
+			dom := md
+			if md < 0 {
+				dom = last + md + 1
+			}
+			if dom < 1 || dom > last || seen[dom] {
+				continue
+			}
+			seen[dom] = true
+			day := time.Date(month.Year(), month.Month(), dom, 0, 0, 0, 0, loc)
+			if len(r.ByDay) == 0 || r.matchWeekdayInMonth(day) {
+				days = append(days, day)
+			}
+		}
+		sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	case len(r.ByDay) > 0:
+		for dom := 1; dom <= last; dom++ {
+			day := time.Date(month.Year(), month.Month(), dom, 0, 0, 0, 0, loc)
+			if r.matchWeekdayInMonth(day) {
+				days = append(days, day)
+			}
+		}
+	default:
+		// A series on the 31st skips shorter months, as RFC 5545 requires
+		if defaultDay <= last {
+			days = append(days, time.Date(month.Year(), month.Month(), defaultDay, 0, 0, 0, 0, loc))
+		}
+	}
+	return days
+}
+
+// yearWeekdays handles YEARLY;BYDAY without BYMONTH, where ordinals count within the year.
+func (r Rule) yearWeekdays(year int, loc *time.Location) []time.Time {
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	total := time.Date(year+1, time.January, 1, 0, 0, 0, 0, loc).AddDate(0, 0, -1).YearDay()
+
+	var days []time.Time
+	for i := 0; i < total; i++ {
+		day := start.AddDate(0, 0, i)
+		for _, wd := range r.ByDay {
+			if wd.Day != day.Weekday() {
+				continue
+			}
+			if wd.N == 0 || nthInSpan(i, total, wd.N) {
+				days = append(days, day)
+				break
+			}
+		}
+	}
+	return days
+}
+
+// nthInSpan reports whether the weekday at zero-based index i is the n-th
+// (or n-th from last when negative) of its kind in a span of total days.
+func nthInSpan(i, total, n int) bool {
+	if n > 0 {
+		return i/7+1 == n
+	}
+	return (total-1-i)/7+1 == -n
+}
+
+func (r Rule) matchWeekdayInMonth(day time.Time) bool {
+	last := day.AddDate(0, 1, -day.Day()).Day()
+	for _, wd := range r.ByDay {
+		if wd.Day != day.Weekday() {
+			continue
+		}
+		if wd.N == 0 || nthInSpan(day.Day()-1, last, wd.N) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchWeekdayPlain(day time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, wd := range r.ByDay {
+		if wd.Day == day.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchMonth(day time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, m := range r.ByMonth {
+		if m == day.Month() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchMonthDay(day time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	last := day.AddDate(0, 1, -day.Day()).Day()
+	for _, md := range r.ByMonthDay {
+		if md == day.Day() || (md < 0 && last+md+1 == day.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) applySetPos(days []time.Time) []time.Time {
+	if len(r.BySetPos) == 0 || len(days) == 0 {
+		return days
+	}
+
+	var out []time.Time
+	for i, day := range days {
+		for _, pos := range r.BySetPos {
+			if pos == i+1 || pos == i-len(days) {
+				out = append(out, day)
+				break
+			}
+		}
+	}
+	return out
+}
+
+func sortedMonths(ms []time.Month) []time.Month {
+	out := append([]time.Month(nil), ms...)
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
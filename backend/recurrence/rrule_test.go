@@ -0,0 +1,304 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStarts(t *testing.T) {
+	utc := time.UTC
+	ny := mustLoad(t, "America/New_York")
+	london := mustLoad(t, "Europe/London")
+	sydney := mustLoad(t, "Australia/Sydney")
+
+	tests := []struct {
+		name    string
+		rrule   string
+		dtstart time.Time
+		want    []string // RFC 3339
+	}{
+		// BYDAY with ordinals
+		{
+			name:    "second tuesday",
+			rrule:   "FREQ=MONTHLY;BYDAY=2TU;COUNT=4",
+			dtstart: time.Date(2025, 1, 14, 18, 0, 0, 0, london),
+			want: []string{
+				"2025-01-14T18:00:00Z",
+				"2025-02-11T18:00:00Z",
+				"2025-03-11T18:00:00Z",
+				"2025-04-08T18:00:00+01:00",
+			},
+		},
+		{
+			name:    "last friday",
+			rrule:   "FREQ=MONTHLY;BYDAY=-1FR;COUNT=3",
+			dtstart: time.Date(2025, 1, 31, 17, 0, 0, 0, utc),
+			want: []string{
+				"2025-01-31T17:00:00Z",
+				"2025-02-28T17:00:00Z",
+				"2025-03-28T17:00:00Z",
+			},
+		},
+		{
+			name:    "first and third monday",
+			rrule:   "FREQ=MONTHLY;BYDAY=1MO,3MO;COUNT=4",
+			dtstart: time.Date(2025, 9, 1, 19, 0, 0, 0, utc),
+			want: []string{
+				"2025-09-01T19:00:00Z",
+				"2025-09-15T19:00:00Z",
+				"2025-10-06T19:00:00Z",
+				"2025-10-20T19:00:00Z",
+			},
+		},
+		{
+			name:    "fourth thursday of november",
+			rrule:   "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH;COUNT=3",
+			dtstart: time.Date(2025, 11, 27, 15, 0, 0, 0, ny),
+			want: []string{
+				"2025-11-27T15:00:00-05:00",
+				"2026-11-26T15:00:00-05:00",
+				"2027-11-25T15:00:00-05:00",
+			},
+		},
+		{
+			name:    "last weekday of the month",
+			rrule:   "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1;COUNT=3",
+			dtstart: time.Date(2025, 5, 30, 12, 0, 0, 0, utc),
+			want: []string{
+				"2025-05-30T12:00:00Z",
+				"2025-06-30T12:00:00Z",
+				"2025-07-31T12:00:00Z",
+			},
+		},
+		{
+			name:    "weekdays without ordinals",
+			rrule:   "FREQ=WEEKLY;BYDAY=MO,WE,FR;COUNT=5",
+			dtstart: time.Date(2025, 6, 2, 8, 0, 0, 0, utc),
+			want: []string{
+				"2025-06-02T08:00:00Z",
+				"2025-06-04T08:00:00Z",
+				"2025-06-06T08:00:00Z",
+				"2025-06-09T08:00:00Z",
+				"2025-06-11T08:00:00Z",
+			},
+		},
+
+		// UNTIL and COUNT
+		{
+			name:    "until is inclusive",
+			rrule:   "FREQ=DAILY;UNTIL=20250103T090000Z",
+			dtstart: time.Date(2025, 1, 1, 9, 0, 0, 0, utc),
+			want: []string{
+				"2025-01-01T09:00:00Z",
+				"2025-01-02T09:00:00Z",
+				"2025-01-03T09:00:00Z",
+			},
+		},
+		{
+			name:    "until between occurrences",
+			rrule:   "FREQ=WEEKLY;UNTIL=20250115T000000Z",
+			dtstart: time.Date(2025, 1, 1, 9, 0, 0, 0, utc),
+			want: []string{
+				"2025-01-01T09:00:00Z",
+				"2025-01-08T09:00:00Z",
+			},
+		},
+		{
+			name:    "until as a date covers that whole day in the series' zone",
+			rrule:   "FREQ=DAILY;UNTIL=20250103",
+			dtstart: time.Date(2025, 1, 1, 20, 0, 0, 0, ny),
+			want: []string{
+				"2025-01-01T20:00:00-05:00",
+				"2025-01-02T20:00:00-05:00",
+				"2025-01-03T20:00:00-05:00",
+			},
+		},
+		{
+			name:    "floating until is read in the series' zone",
+			rrule:   "FREQ=DAILY;UNTIL=20250102T200000",
+			dtstart: time.Date(2025, 1, 1, 20, 0, 0, 0, ny),
+			want: []string{
+				"2025-01-01T20:00:00-05:00",
+				"2025-01-02T20:00:00-05:00",
+			},
+		},
+		{
+			name:    "count ends before until",
+			rrule:   "FREQ=DAILY;COUNT=2;UNTIL=20251231T000000Z",
+			dtstart: time.Date(2025, 1, 1, 9, 0, 0, 0, utc),
+			want: []string{
+				"2025-01-01T09:00:00Z",
+				"2025-01-02T09:00:00Z",
+			},
+		},
+		{
+			name:    "until ends before count",
+			rrule:   "FREQ=DAILY;COUNT=10;UNTIL=20250102T090000Z",
+			dtstart: time.Date(2025, 1, 1, 9, 0, 0, 0, utc),
+			want: []string{
+				"2025-01-01T09:00:00Z",
+				"2025-01-02T09:00:00Z",
+			},
+		},
+		{
+			name:    "count only counts occurrences from dtstart",
+			rrule:   "FREQ=WEEKLY;BYDAY=MO,FR;COUNT=3",
+			dtstart: time.Date(2025, 6, 4, 8, 0, 0, 0, utc), // a Wednesday
+			want: []string{
+				"2025-06-06T08:00:00Z",
+				"2025-06-09T08:00:00Z",
+				"2025-06-13T08:00:00Z",
+			},
+		},
+		{
+			name:    "the 31st skips shorter months",
+			rrule:   "FREQ=MONTHLY;COUNT=4",
+			dtstart: time.Date(2025, 1, 31, 9, 0, 0, 0, utc),
+			want: []string{
+				"2025-01-31T09:00:00Z",
+				"2025-03-31T09:00:00Z",
+				"2025-05-31T09:00:00Z",
+				"2025-07-31T09:00:00Z",
+			},
+		},
+
+		// Crossing DST changes
+		{
+			name:    "weekly over the london spring change",
+			rrule:   "FREQ=WEEKLY;COUNT=3",
+			dtstart: time.Date(2025, 3, 23, 10, 0, 0, 0, london),
+			want: []string{
+				"2025-03-23T10:00:00Z",
+				"2025-03-30T10:00:00+01:00",
+				"2025-04-06T10:00:00+01:00",
+			},
+		},
+		{
+			name:    "monthly onto the fall back overlap",
+			rrule:   "FREQ=MONTHLY;BYMONTHDAY=2;COUNT=3",
+			dtstart: time.Date(2025, 10, 2, 1, 30, 0, 0, ny),
+			want: []string{
+				"2025-10-02T01:30:00-04:00",
+				"2025-11-02T01:30:00-04:00",
+				"2025-12-02T01:30:00-05:00",
+			},
+		},
+		{
+			name:    "southern hemisphere autumn change",
+			rrule:   "FREQ=DAILY;COUNT=3",
+			dtstart: time.Date(2025, 4, 5, 9, 0, 0, 0, sydney),
+			want: []string{
+				"2025-04-05T09:00:00+11:00",
+				"2025-04-06T09:00:00+10:00",
+				"2025-04-07T09:00:00+10:00",
+			},
+		},
+		{
+			name:    "until in utc across a change",
+			rrule:   "FREQ=WEEKLY;UNTIL=20250406T090000Z",
+			dtstart: time.Date(2025, 3, 23, 10, 0, 0, 0, london),
+			want: []string{
+				"2025-03-23T10:00:00Z",
+				"2025-03-30T10:00:00+01:00",
+				"2025-04-06T10:00:00+01:00",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rrule)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tt.rrule, err)
+			}
+			var got []string
+			rule.Starts(tt.dtstart, func(start time.Time) bool {
+				got = append(got, start.Format(time.RFC3339))
+				// A runaway series fails below rather than hanging
+				return len(got) <= len(tt.want)
+			})
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d starts %v, want %v", len(got), got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("start %d: got %s, want %s", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	rule, err := Parse("RRULE:freq=monthly;interval=2;byday=-1FR,2mo;wkst=SU")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := []WeekdayNum{{N: -1, Day: time.Friday}, {N: 2, Day: time.Monday}}
+	if rule.Freq != Monthly || rule.Interval != 2 || rule.WeekStart != time.Sunday || len(rule.ByDay) != 2 || rule.ByDay[0] != want[0] || rule.ByDay[1] != want[1] {
+		t.Errorf("Parse = %+v", rule)
+	}
+
+	for _, bad := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;COUNT=0",
+		"FREQ=DAILY;INTERVAL=-1",
+		"FREQ=DAILY;UNTIL=2025-01-01",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=MONTHLY;BYDAY=0MO",
+		"FREQ=MONTHLY;BYDAY=54MO",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=YEARLY;BYMONTH=13",
+		"FREQ=MONTHLY;BYSETPOS=0",
+		"FREQ=WEEKLY;WKST=XY",
+		"FREQ=DAILY;COUNT",
+	} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	for _, s := range []string{
+		"FREQ=DAILY",
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE",
+		"FREQ=MONTHLY;COUNT=6;BYDAY=-1FR",
+		"FREQ=MONTHLY;UNTIL=20251231T235959Z;BYMONTHDAY=1,-1",
+		"FREQ=YEARLY;UNTIL=20300101;BYDAY=4TH;BYMONTH=11",
+		"FREQ=MONTHLY;UNTIL=20250601T090000;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1",
+		"FREQ=WEEKLY;BYDAY=SU;WKST=SU",
+	} {
+		rule, err := Parse(s)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", s, err)
+		}
+		if got := rule.String(); got != s {
+			t.Errorf("String = %q, want %q", got, s)
+		}
+	}
+}
+
+func TestSetUntil(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	dtstart := time.Date(2025, 1, 1, 20, 0, 0, 0, ny)
+
+	rule, _ := Parse("FREQ=DAILY;COUNT=10")
+	rule.SetUntil(time.Date(2025, 1, 2, 20, 0, 0, 0, ny))
+	if got := rule.String(); got != "FREQ=DAILY;UNTIL=20250103T010000Z" {
+		t.Errorf("String after SetUntil = %q", got)
+	}
+	if got := rule.Between(dtstart, dtstart, dtstart.AddDate(0, 1, 0)); len(got) != 2 {
+		t.Errorf("SetUntil left %d starts, want 2", len(got))
+	}
+
+	rule.SetUntilDate(time.Date(2025, 1, 4, 0, 0, 0, 0, ny))
+	if got := rule.String(); got != "FREQ=DAILY;UNTIL=20250104" {
+		t.Errorf("String after SetUntilDate = %q", got)
+	}
+	if got := rule.Between(dtstart, dtstart, dtstart.AddDate(0, 1, 0)); len(got) != 4 {
+		t.Errorf("SetUntilDate left %d starts, want 4", len(got))
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"pical/database/schemas"
+)
+
+// errNotEmpty stops seeding a database that already has people in it, so the
+// example data can't mix itself into real data.
+var errNotEmpty = errors.New("the database already has people in it; seed only fills an empty one")
+
+// runSeed handles `server seed [-timezone zone]`, which fills an empty
+// database with a made-up household to try the app out or work on the
+// frontend against; see seedDemo.
+func runSeed(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	zone := fs.String("timezone", "UTC", "IANA `zone` the events are in, e.g. Europe/London")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(*zone)
+	if err != nil {
+		return fmt.Errorf("unknown timezone %q", *zone)
+	}
+
+	n, err := seedDemo(ctx, db, loc)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("added 3 people, 2 calendars and %d events in %s\n", n, loc)
+	return nil
+}
+
+// seedIfEmpty is `server serve -demo`: the example data for a database with
+// nobody in it yet, and nothing for one that has, so the flag can stay on
+// across restarts.
+func seedIfEmpty(ctx context.Context, db *sql.DB, zone string) error {
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return fmt.Errorf("unknown timezone %q", zone)
+	}
+	n, err := seedDemo(ctx, db, loc)
+	if errors.Is(err, errNotEmpty) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("demo: added 3 people, 2 calendars and %d events in %s", n, loc)
+	return nil
+}
+
+// seedDemo adds three people, two calendars and a fortnight's worth of
+// one-off, repeating and all-day events starting from today, with a
+// cancelled and a moved occurrence and a few reminders, and returns how many
+// events it added. It returns errNotEmpty once anyone has been added.
+func seedDemo(ctx context.Context, db *sql.DB, loc *time.Location) (int, error) {
+	if err := schemas.MigrateTo(ctx, db, schemas.LatestMigration()); err != nil {
+		return 0, err
+	}
+	people, err := schemas.ListPeople(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	if len(people) > 0 {
+		return 0, errNotEmpty
+	}
+
+	ids := make(map[string]string)
+	for i, p := range []struct{ name, color string }{{"Alex", "#3b82f6"}, {"Sam", "#ef4444"}, {"Jamie", "#10b981"}} {
+		person, err := schemas.CreatePerson(ctx, db, schemas.Person{DisplayName: p.name, Color: &p.color, SortOrder: i})
+		if err != nil {
+			return 0, err
+		}
+		ids[p.name] = person.PersonID
+	}
+	for i, c := range []struct{ name, color string }{{"Family", "#8b5cf6"}, {"School", "#f59e0b"}} {
+		calendar, err := schemas.CreateCalendar(ctx, db, schemas.Calendar{Name: c.name, Color: &c.color, SortOrder: i})
+		if err != nil {
+			return 0, err
+		}
+		ids[c.name] = calendar.CalendarID
+	}
+	if _, err := schemas.CreateTag(ctx, db, schemas.Tag{Name: "sport"}); err != nil {
+		return 0, err
+	}
+
+	y, m, d := time.Now().In(loc).Date()
+	// at is hh:mm local time, days from today
+	at := func(days, hh, mm int) time.Time { return time.Date(y, m, d+days, hh, mm, 0, 0, loc) }
+	until := func(t time.Time, length time.Duration) *time.Time {
+		end := t.Add(length)
+		return &end
+	}
+	ptr := func(s string) *string { return &s }
+	sunday := (7 - int(at(0, 0, 0).Weekday())) % 7
+
+	events := []schemas.Event{
+		{PersonID: ids["Jamie"], CalendarID: ptr(ids["School"]), Title: "Swimming lesson", Tags: []string{"sport"},
+			StartTime: at(1, 17, 0), EndTime: until(at(1, 17, 0), 45*time.Minute), Rrule: ptr("FREQ=WEEKLY"), Location: ptr("Leisure centre")},
+		{PersonID: ids["Jamie"], CalendarID: ptr(ids["School"]), Title: "School trip", AllDay: true,
+			StartTime: at(3, 0, 0), Notes: ptr("Packed lunch and a coat")},
+		{PersonID: ids["Alex"], CalendarID: ptr(ids["Family"]), Title: "Put the bins out", AllDay: true, Task: true, Rollover: true,
+			StartTime: at(2, 0, 0), Rrule: ptr("FREQ=WEEKLY")},
+		{PersonID: ids["Alex"], CalendarID: ptr(ids["Family"]), Title: "Family dinner",
+			StartTime: at(sunday, 18, 0), EndTime: until(at(sunday, 18, 0), 90*time.Minute), Rrule: ptr("FREQ=WEEKLY")},
+		{PersonID: ids["Alex"], CalendarID: ptr(ids["Family"]), Title: "Sam's birthday", AllDay: true,
+			StartTime: at(9, 0, 0), Rrule: ptr("FREQ=YEARLY")},
+		{PersonID: ids["Sam"], Title: "Dentist",
+			StartTime: at(2, 10, 0), EndTime: until(at(2, 10, 0), 30*time.Minute), Location: ptr("High Street Dental")},
+		{PersonID: ids["Sam"], Title: "Team call", URL: ptr("https://meet.example.com/team"),
+			StartTime: at(0, 9, 0), EndTime: until(at(0, 9, 0), 30*time.Minute), Rrule: ptr("FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR")},
+		{PersonID: ids["Sam"], Title: "Focus time", Focus: true,
+			StartTime: at(1, 13, 0), EndTime: until(at(1, 13, 0), 2*time.Hour)},
+	}
+	created := make(map[string]schemas.Event, len(events))
+	for _, e := range events {
+		e.Timezone = loc.String()
+		out, err := schemas.CreateEvent(ctx, db, e)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", e.Title, err)
+		}
+		created[out.Title] = out
+	}
+
+	// Swimming is off next week, and dinner the week after starts later
+	swimming, dinner := created["Swimming lesson"], created["Family dinner"]
+	moved := at(sunday+7, 19, 0)
+	exceptions := []schemas.Exception{
+		{EventID: swimming.EventID, RecurrenceID: at(8, 17, 0), Kind: schemas.ExceptionCancel},
+		{EventID: dinner.EventID, RecurrenceID: at(sunday+7, 18, 0), Kind: schemas.ExceptionMove, NewStart: &moved, NewEnd: until(moved, 90*time.Minute)},
+	}
+	for _, ex := range exceptions {
+		if err := schemas.UpsertException(ctx, db, ex); err != nil {
+			return 0, err
+		}
+	}
+	reminders := []schemas.Reminder{
+		{EventID: created["Dentist"].EventID, OffsetMinutes: 60, Channel: schemas.ReminderAlert},
+		{EventID: swimming.EventID, OffsetMinutes: 30, Channel: schemas.ReminderAlert},
+		{EventID: created["Sam's birthday"].EventID, OffsetMinutes: 7 * 24 * 60, Channel: schemas.ReminderAlert},
+	}
+	for _, r := range reminders {
+		if _, err := schemas.CreateReminder(ctx, db, r); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(events), nil
+}
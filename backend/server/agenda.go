@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"pical/database/schemas"
+	"time"
+)
+
+// maxAgendaDays caps ?days on /api/agenda.
+const maxAgendaDays = 62
+
+const (
+	// How many matches after the window an agenda search lists
+	agendaSearchMatches = 3
+	// How far past the window it looks for them
+	agendaSearchLookahead = 366 * 24 * time.Hour
+)
+
+// getAgenda lists the occurrences of the next ?days days (default 7),
+// starting today in ?tz, grouped by day. Every day in the window is present,
+// even when empty, so displays can lay out a fixed grid. ?week=true starts
+// it on the first day of this week instead, and ?groupBy=person further
+// splits each day into a column per person. With ?q, which narrows the days
+// like the other filters, the days come wrapped in an AgendaSearch with the
+// next few matches after them, so a display can answer "when's the next
+// dentist appointment?" in one request.
+func (s *Server) getAgenda(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	loc, err := parseTZQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	days := parseIntQuery(r, "days", 7, 1, maxAgendaDays)
+
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	groupBy, err := parseGroupBy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	agenda, err := s.buildAgenda(r.Context(), filter, loc, days, parseBoolQuery(r, "week"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var out any = agenda
+	// Always by date here, so "person" and "date,person" mean the same
+	if groupBy == groupByPerson || groupBy == groupByDatePerson {
+		people, err := s.peopleColumns(r.Context(), filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out = splitDaysByPerson(people, agenda)
+	}
+
+	if filter.Search != "" {
+		next, err := s.nextMatches(r.Context(), filter, agendaEnd(agenda, loc))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out = AgendaSearch{Days: out, Next: next}
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// agendaEnd is midnight after the agenda's last day.
+func agendaEnd(agenda []AgendaDay, loc *time.Location) time.Time {
+	last, err := time.ParseInLocation("2006-01-02", agenda[len(agenda)-1].Date, loc)
+	if err != nil {
+		return time.Now()
+	}
+	return last.AddDate(0, 0, 1)
+}
+
+// nextMatches lists the first agendaSearchMatches occurrences of events
+// matching filter that start from after on, looking agendaSearchLookahead
+// ahead.
+func (s *Server) nextMatches(ctx context.Context, filter schemas.EventFilter, after time.Time) ([]EventOccurrence, error) {
+	occs, err := s.expandOccurrences(ctx, filter, after, after.Add(agendaSearchLookahead))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]EventOccurrence, 0, agendaSearchMatches)
+	for _, occ := range occs {
+		// Ones that began before and run on into the window's end are in the days already
+		if occ.StartTime.Before(after) {
+			continue
+		}
+		out = append(out, occ)
+		if len(out) == agendaSearchMatches {
+			break
+		}
+	}
+	return out, nil
+}
+
+// buildAgenda groups the occurrences of the days days starting today in loc,
+// or with week, on the first day of the household's week.
+func (s *Server) buildAgenda(ctx context.Context, filter schemas.EventFilter, loc *time.Location, days int, week bool) ([]AgendaDay, error) {
+	settings, err := schemas.GetSettings(ctx, s.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().In(loc)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	if week {
+		from = settings.WeekStartOf(now)
+	}
+	to := from.AddDate(0, 0, days)
+
+	occs, err := s.expandOccurrences(ctx, filter, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupByDates(occs, loc, from, to, settings), nil
+}
+
+// occurrenceDates returns the calendar dates an occurrence covers. Timed
+// occurrences are placed in loc; all-day ones keep the dates they have in
+// the event's own timezone, so a birthday doesn't slide to the day before
+// for a viewer further west.
+func occurrenceDates(occ EventOccurrence, loc *time.Location) []string {
+	if occ.Event.AllDay {
+		if l, err := time.LoadLocation(occ.Event.Timezone); err == nil {
+			loc = l
+		}
+	}
+
+	start := occ.StartTime.In(loc)
+	first := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+
+	// last is the final date covered; an end exactly at midnight doesn't touch that day
+	last := first
+	if occ.EndTime != nil && occ.EndTime.After(occ.StartTime) {
+		end := occ.EndTime.In(loc).Add(-time.Nanosecond)
+		last = time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, loc)
+	}
+
+	dates := make([]string, 0, 1)
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d.Format("2006-01-02"))
+	}
+	return dates
+}
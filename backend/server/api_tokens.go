@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"pical/database/schemas"
+	"strings"
+	"time"
+)
+
+// requestToken reads the API token a request carries, as a bearer token or,
+// for clients such as browsers opening a WebSocket that can't set headers,
+// an access_token query parameter.
+func requestToken(r *http.Request) string {
+	if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(v)
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// apiTokenLookup is where scopeMiddleware finds tokens: the database,
+// unless a test has set Server.tokens.
+type apiTokenLookup interface {
+	UseAPIToken(ctx context.Context, hash string, now time.Time) (*schemas.APIToken, error)
+	HasAdminAPIToken(ctx context.Context, now time.Time) (bool, error)
+}
+
+type dbAPITokens struct{ db *sql.DB }
+
+func (d dbAPITokens) UseAPIToken(ctx context.Context, hash string, now time.Time) (*schemas.APIToken, error) {
+	return schemas.UseAPIToken(ctx, d.db, hash, now)
+}
+
+func (d dbAPITokens) HasAdminAPIToken(ctx context.Context, now time.Time) (bool, error) {
+	return schemas.HasAdminAPIToken(ctx, d.db, now)
+}
+
+func (s *Server) apiTokens() apiTokenLookup {
+	if s.tokens != nil {
+		return s.tokens
+	}
+	return dbAPITokens{s.DB}
+}
+
+// scopeMiddleware holds requests carrying an API token to its scopes. With
+// admin set, every request needs the admin scope; otherwise reads need
+// read:events and anything else write:events. Requests without a token are
+// refused when the config requires one. Otherwise they are let through, as
+// the web app sends none, except to admin routes once an admin token has
+// been issued: whoever issued it has shown they use tokens, and the
+// installation shouldn't stay open to anyone on the network.
+func (s *Server) scopeMiddleware(admin bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secret := requestToken(r)
+			if secret == "" {
+				required := s.Config.RequireAPIToken
+				if !required && admin {
+					ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+					has, err := s.apiTokens().HasAdminAPIToken(ctx, time.Now())
+					cancel()
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+					required = has
+				}
+				if required {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="pical"`)
+					http.Error(w, "API token required", http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+			token, err := s.apiTokens().UseAPIToken(ctx, hashTokenSecret(secret), time.Now())
+			cancel()
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					log.Printf("api token rejected: %s %s from %s", r.Method, r.URL.Path, clientIP(r))
+					w.Header().Set("WWW-Authenticate", `Bearer realm="pical", error="invalid_token"`)
+					http.Error(w, "invalid or expired API token", http.StatusUnauthorized)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			scope := schemas.ScopeAdmin
+			if !admin {
+				scope = schemas.ScopeWriteEvents
+				if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+					scope = schemas.ScopeReadEvents
+				}
+			}
+			if !token.Allows(scope) {
+				log.Printf("api token %s (%s) lacks %s: %s %s from %s", token.TokenID, token.Name, scope, r.Method, r.URL.Path, clientIP(r))
+				http.Error(w, "API token lacks the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withAPIToken(r.Context(), token)))
+		})
+	}
+}
+
+func (s *Server) getAPITokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := schemas.ListAPITokens(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// createAPIToken issues a token. The secret is in the response only; it
+// can't be recovered later, just revoked and reissued.
+func (s *Server) createAPIToken(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in schemas.APIToken
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	issued, err := IssueAPIToken(r.Context(), s.DB, in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, issued)
+}
+
+// IssueAPIToken creates a token with a fresh secret. It is exported for
+// `server api-token`, which is how the first admin token is made once
+// tokens are required.
+func IssueAPIToken(ctx context.Context, db *sql.DB, in schemas.APIToken) (APITokenIssued, error) {
+	secret, hash, err := newTokenSecret()
+	if err != nil {
+		return APITokenIssued{}, err
+	}
+
+	created, err := schemas.CreateAPIToken(ctx, db, in, hash)
+	if err != nil {
+		return APITokenIssued{}, err
+	}
+	return APITokenIssued{APIToken: created, Token: secret}, nil
+}
+
+func (s *Server) deleteAPIToken(w http.ResponseWriter, r *http.Request, id string) {
+	err := schemas.DeleteAPIToken(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "api token not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"pical/database/schemas"
+	"testing"
+	"time"
+)
+
+// fakeAPITokens looks tokens up by secret instead of in the database.
+type fakeAPITokens map[string]schemas.APIToken
+
+func (f fakeAPITokens) UseAPIToken(ctx context.Context, hash string, now time.Time) (*schemas.APIToken, error) {
+	for secret, t := range f {
+		if hashTokenSecret(secret) == hash {
+			return &t, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (f fakeAPITokens) HasAdminAPIToken(ctx context.Context, now time.Time) (bool, error) {
+	for _, t := range f {
+		if t.Allows(schemas.ScopeAdmin) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestScopeMiddleware(t *testing.T) {
+	reader := schemas.APIToken{TokenID: "1", Name: "mirror", Scopes: []string{schemas.ScopeReadEvents}}
+	writer := schemas.APIToken{TokenID: "2", Name: "script", Scopes: []string{schemas.ScopeWriteEvents}}
+	admin := schemas.APIToken{TokenID: "3", Name: "me", Scopes: []string{schemas.ScopeAdmin}}
+
+	tests := []struct {
+		name    string
+		tokens  fakeAPITokens
+		require bool
+		method  string
+		path    string
+		secret  string
+		// 0 when the request should get past the middleware
+		status int
+	}{
+		{"read token reads", fakeAPITokens{"r": reader}, false, "GET", "/api/v1/events", "r", 0},
+		{"read token writes", fakeAPITokens{"r": reader}, false, "POST", "/api/v1/events", "r", http.StatusForbidden},
+		{"write token writes", fakeAPITokens{"w": writer}, false, "POST", "/api/v1/events", "w", 0},
+		{"write token on admin", fakeAPITokens{"w": writer}, false, "GET", "/api/v1/api-tokens", "w", http.StatusForbidden},
+		{"admin token on admin", fakeAPITokens{"a": admin}, false, "GET", "/api/v1/api-tokens", "a", 0},
+		{"unknown token", fakeAPITokens{"r": reader}, false, "GET", "/api/v1/events", "x", http.StatusUnauthorized},
+		{"no token, none issued", fakeAPITokens{}, false, "GET", "/api/v1/api-tokens", "", 0},
+		{"no token on admin once an admin token exists", fakeAPITokens{"a": admin}, false, "GET", "/api/v1/api-tokens", "", http.StatusUnauthorized},
+		{"no token on admin with only event tokens", fakeAPITokens{"r": reader}, false, "GET", "/api/v1/api-tokens", "", 0},
+		{"no token on the app API", fakeAPITokens{"a": admin}, false, "GET", "/api/v1/events", "", 0},
+		{"no token when required", fakeAPITokens{}, true, "GET", "/api/v1/events", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServerFields(t)
+			s.tokens = tt.tokens
+			s.Config.RequireAPIToken = tt.require
+
+			header := http.Header{}
+			if tt.secret != "" {
+				header.Set("Authorization", "Bearer "+tt.secret)
+			}
+			w := serve(s.Handler(), tt.method, tt.path, "{}", header)
+			if tt.status != 0 && w.Code != tt.status {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			if tt.status == 0 && (w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden) {
+				t.Fatalf("refused with %d %s", w.Code, w.Body)
+			}
+		})
+	}
+}
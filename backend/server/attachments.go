@@ -0,0 +1,318 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"pical/database/schemas"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// Uploads write to disk, so they get longer than plain DB work
+	attachmentTimeout = 2 * time.Minute
+	// How often files left behind by cascaded event deletes are removed
+	attachmentSweepInterval = time.Hour
+	// Unfinished uploads older than this are assumed abandoned
+	partialUploadAge = time.Hour
+)
+
+func (s *Server) attachmentPath(id string) string {
+	return filepath.Join(s.Config.AttachmentDir, id)
+}
+
+func (s *Server) getAttachments(w http.ResponseWriter, r *http.Request, id string) {
+	if _, err := s.Events.GetEvent(r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	attachments, err := schemas.ListEventAttachments(r.Context(), s.DB, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, attachments)
+}
+
+// uploadAttachment stores the multipart "file" field. The content type is
+// sniffed from the bytes rather than trusted from the client, and must be
+// one of AttachmentTypes.
+func (s *Server) uploadAttachment(w http.ResponseWriter, r *http.Request, id string) {
+	if _, err := s.Events.GetEvent(r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Headroom for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, s.Config.AttachmentMaxBytes+64<<10)
+	defer r.Body.Close()
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected a multipart/form-data upload", http.StatusBadRequest)
+		return
+	}
+	var part io.ReadCloser
+	var filename string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			http.Error(w, `missing "file" field`, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			uploadError(w, err)
+			return
+		}
+		if p.FormName() == "file" {
+			part, filename = p, p.FileName()
+			break
+		}
+		p.Close()
+	}
+	defer part.Close()
+
+	filename = strings.TrimSpace(filepath.Base(strings.ReplaceAll(filename, `\`, "/")))
+	if filename == "" || filename == "." || filename == "/" {
+		filename = "attachment"
+	}
+	if len(filename) > 255 {
+		ext := filepath.Ext(filename)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		filename = strings.ToValidUTF8(filename[:255-len(ext)], "") + ext
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		uploadError(w, err)
+		return
+	}
+	head = head[:n]
+	if n == 0 {
+		http.Error(w, "file is empty", http.StatusBadRequest)
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !slices.Contains(s.Config.AttachmentTypes, contentType) {
+		http.Error(w, fmt.Sprintf("files of type %s are not accepted", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	tmp, err := os.CreateTemp(s.Config.AttachmentDir, "upload-*.part")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, io.MultiReader(bytes.NewReader(head), io.LimitReader(part, s.Config.AttachmentMaxBytes+1-int64(n))))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		uploadError(w, err)
+		return
+	}
+	if size > s.Config.AttachmentMaxBytes {
+		http.Error(w, fmt.Sprintf("file is larger than %d bytes", s.Config.AttachmentMaxBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	status, threat, err := s.scanAttachment(r.Context(), tmp.Name())
+	if err != nil {
+		log.Printf("attachment scan: %v", err)
+		http.Error(w, "the file could not be scanned for viruses; try again later", http.StatusServiceUnavailable)
+		return
+	}
+	if threat != nil {
+		log.Printf("attachment %q on event %s quarantined: %s", filename, id, *threat)
+	}
+
+	created, err := schemas.CreateAttachment(r.Context(), s.DB, schemas.Attachment{
+		EventID:     id,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		Status:      status,
+		Threat:      threat,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := os.Rename(tmp.Name(), s.attachmentPath(created.AttachmentID)); err != nil {
+		if derr := schemas.DeleteAttachment(r.Context(), s.DB, id, created.AttachmentID); derr != nil {
+			log.Printf("attachment %s: undo insert: %v", created.AttachmentID, derr)
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// scanAttachment runs the uploaded file through the virus scanner, when one
+// is configured. An infected file is quarantined rather than refused, so the
+// admin can see what was caught.
+func (s *Server) scanAttachment(ctx context.Context, path string) (schemas.AttachmentStatus, *string, error) {
+	if s.scanner == nil {
+		return schemas.AttachmentUnscanned, nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	threat, err := s.scanner.Scan(ctx, f)
+	if err != nil {
+		return "", nil, err
+	}
+	if threat != "" {
+		return schemas.AttachmentQuarantined, &threat, nil
+	}
+	return schemas.AttachmentClean, nil, nil
+}
+
+func uploadError(w http.ResponseWriter, err error) {
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) {
+		http.Error(w, "upload is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "upload failed: "+err.Error(), http.StatusBadRequest)
+}
+
+// downloadAttachment serves the file with its stored type. Images and PDFs
+// open in the browser; anything else downloads.
+func (s *Server) downloadAttachment(w http.ResponseWriter, r *http.Request, id, attachmentID string) {
+	a, err := schemas.GetAttachment(r.Context(), s.DB, id, attachmentID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "attachment not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if a.Status == schemas.AttachmentQuarantined {
+		http.Error(w, "attachment is quarantined: it failed the virus scan", http.StatusForbidden)
+		return
+	}
+
+	f, err := os.Open(s.attachmentPath(a.AttachmentID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "attachment file is missing", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(a.ContentType, "image/") || a.ContentType == "application/pdf" {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", a.CreatedAt, f)
+}
+
+func (s *Server) deleteAttachment(w http.ResponseWriter, r *http.Request, id, attachmentID string) {
+	if err := schemas.DeleteAttachment(r.Context(), s.DB, id, attachmentID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "attachment not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.removeAttachmentFile(attachmentID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) removeAttachmentFile(id string) {
+	if err := os.Remove(s.attachmentPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("attachment %s: remove file: %v", id, err)
+	}
+}
+
+// runAttachmentSweeper removes files whose rows are gone until ctx is
+// canceled. Deleting an event removes its files directly, but events also
+// go when their feed, sync account or person does, or the trash is emptied.
+func (s *Server) runAttachmentSweeper(ctx context.Context) {
+	ticker := time.NewTicker(attachmentSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.sweepAttachments(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("attachment sweep: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) sweepAttachments(ctx context.Context, now time.Time) error {
+	// Listed before the IDs, so a file finished in between is never mistaken
+	// for an orphan
+	entries, err := os.ReadDir(s.Config.AttachmentDir)
+	if err != nil {
+		return err
+	}
+
+	listCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	ids, err := schemas.ListAttachmentIDs(listCtx, s.DB)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || ids[e.Name()] {
+			continue
+		}
+		if strings.HasSuffix(e.Name(), ".part") {
+			info, err := e.Info()
+			if err != nil || now.Sub(info.ModTime()) < partialUploadAge {
+				continue
+			}
+		}
+		if err := os.Remove(filepath.Join(s.Config.AttachmentDir, e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("attachment sweep: %v", err)
+		}
+	}
+	return nil
+}
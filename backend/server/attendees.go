@@ -0,0 +1,228 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pical/database/schemas"
+	"strings"
+	"time"
+)
+
+// rsvpURL is the public link an attendee answers an invitation at.
+func rsvpURL(secret string) string {
+	return "/api/rsvp/" + secret
+}
+
+func (s *Server) getAttendees(w http.ResponseWriter, r *http.Request, id string) {
+	if _, err := s.Events.GetEvent(r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	attendees, err := schemas.ListEventAttendees(r.Context(), s.DB, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, attendees)
+}
+
+func (s *Server) addAttendee(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var in schemas.Attendee
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	e, err := s.Events.GetEvent(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A person with focus time then declines on the spot, unless the
+	// request already answers for them
+	warnings := make([]EventWarning, 0)
+	if in.Email == nil && (in.Status == "" || in.Status == schemas.RSVPPending) && (in.PersonID != nil || in.PersonName != nil) {
+		var personID, personName string
+		if in.PersonID != nil {
+			personID = *in.PersonID
+		}
+		if in.PersonName != nil {
+			personName = *in.PersonName
+		}
+		personID, err = s.Events.ResolvePersonID(r.Context(), personID, personName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		in.PersonID = &personID
+		if warnings, err = s.focusConflicts(r.Context(), personID, *e, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(warnings) > 0 {
+			in.Status = schemas.RSVPDeclined
+		}
+	}
+
+	secret, hash, err := newTokenSecret()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	in.EventID = id
+	created, err := schemas.AddAttendee(r.Context(), s.DB, in, hash)
+	if err != nil {
+		if errors.Is(err, schemas.ErrAttendeeExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, AddedAttendee{Attendee: created, RSVPURL: rsvpURL(secret), Warnings: warnings})
+}
+
+// setAttendeeStatus records an RSVP.
+func (s *Server) setAttendeeStatus(w http.ResponseWriter, r *http.Request, id, attendeeID string) {
+	defer r.Body.Close()
+
+	var in RSVPRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := schemas.SetAttendeeStatus(r.Context(), s.DB, id, attendeeID, in.Status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "attendee not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (s *Server) deleteAttendee(w http.ResponseWriter, r *http.Request, id, attendeeID string) {
+	if err := schemas.DeleteAttendee(r.Context(), s.DB, id, attendeeID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "attendee not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// issueRSVPLink replaces an attendee's RSVP link, such as when the first
+// went astray. The secret is in the response only.
+func (s *Server) issueRSVPLink(w http.ResponseWriter, r *http.Request, id, attendeeID string) {
+	secret, hash, err := newTokenSecret()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := schemas.SetAttendeeRSVPToken(r.Context(), s.DB, id, attendeeID, hash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "attendee not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, RSVPLink{AttendeeID: attendeeID, URL: rsvpURL(secret)})
+}
+
+// rsvpHandler serves /api/rsvp/{secret} to the person invited, who has no
+// API token: GET shows the invitation and PUT answers it. Unknown secrets,
+// replaced ones and those for trashed events all look the same.
+func (s *Server) rsvpHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	secret := strings.TrimPrefix(r.URL.Path, "/api/rsvp/")
+	if secret == "" || strings.Contains(secret, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	a, err := schemas.GetAttendeeByRSVPToken(r.Context(), s.DB, hashTokenSecret(secret))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e, err := s.Events.GetEvent(r.Context(), a.EventID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		defer r.Body.Close()
+
+		var in RSVPRequest
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if a, err = schemas.SetAttendeeStatus(r.Context(), s.DB, a.EventID, a.AttendeeID, in.Status); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, newRSVPInvitation(*e, a))
+}
+
+func newRSVPInvitation(e schemas.Event, a schemas.Attendee) RSVPInvitation {
+	name := a.Name
+	if name == nil {
+		name = a.PersonName
+	}
+	return RSVPInvitation{
+		Title:       e.Title,
+		StartTime:   e.StartTime,
+		EndTime:     e.EndTime,
+		Timezone:    e.Timezone,
+		AllDay:      e.AllDay,
+		Rrule:       e.Rrule,
+		Location:    e.Location,
+		Name:        name,
+		Status:      a.Status,
+		RespondedAt: a.RespondedAt,
+	}
+}
@@ -0,0 +1,403 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"pical/backups"
+	"pical/database/schemas"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	maxBackupBytes = 64 << 20
+	// Restoring rewrites every table, which takes a while on a Pi's SD card
+	backupTimeout = 2 * time.Minute
+	// Scheduled backups are named for when they were made, so they sort in
+	// that order
+	backupPrefix     = "pical-backup-"
+	backupTimeLayout = "20060102T150405Z"
+)
+
+// getBackup downloads every table that holds the household's data, for
+// restoring on another install with importBackup. The rows are written as
+// Postgres gives them, whatever time format the request asks for.
+func (s *Server) getBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	backup, err := schemas.ExportBackup(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := "pical-backup-" + backup.ExportedAt.Format("2006-01-02") + ".json"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(backup)
+}
+
+// importBackup replaces everything getBackup covers with the backup in the
+// body, all at once or not at all. An encrypted one, as scheduled backups
+// can be, is decrypted with BackupKey.
+func (s *Server) importBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBackupBytes))
+	if err != nil {
+		http.Error(w, "backup is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if backups.IsEncrypted(data) {
+		if s.Config.BackupKey == nil {
+			http.Error(w, "backup is encrypted, and BACKUP_ENCRYPTION_KEY isn't set", http.StatusBadRequest)
+			return
+		}
+		if data, err = backups.Decrypt(s.Config.BackupKey, data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var in schemas.Backup
+	if err := json.Unmarshal(data, &in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if in.Tables == nil {
+		http.Error(w, "tables is required", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := schemas.RestoreBackup(r.Context(), s.DB, in)
+	if err != nil {
+		if errors.Is(err, schemas.ErrIncompatibleBackup) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, BackupRestoreReport{Migration: in.Migration, ExportedAt: in.ExportedAt, Rows: rows})
+}
+
+// runBackups writes the same dump as getBackup to BACKUP_TARGET and every
+// added backup target every BackupInterval, counting from the newest one
+// already at any of them, so restarts don't put the next one off. A failure
+// is sent to the admin and tried again an interval later.
+func (s *Server) runBackups(ctx context.Context) {
+	status := BackupStatus{}
+	dests, err := s.backupDests(ctx)
+	if err != nil {
+		log.Printf("backups: %v", err)
+	}
+	var last time.Time
+	for _, d := range dests {
+		st := BackupTargetStatus{}
+		names, err := d.files(ctx)
+		if err != nil {
+			log.Printf("backups: %s: %v", d.label(), err)
+		}
+		if len(names) > 0 {
+			newest := names[len(names)-1]
+			made, _ := backupTime(newest)
+			st.LastFile, st.LastSuccess = newest, &made
+			if made.After(last) {
+				last = made
+			}
+		}
+		status.set(d.name, st)
+	}
+	s.backupStatus.Store(&status)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(last.Add(s.Config.BackupInterval))):
+		}
+		last = time.Now()
+
+		runCtx, cancel := context.WithTimeout(ctx, backupTimeout)
+		err := s.makeBackup(runCtx)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			s.notifyAdmin(ctx, fmt.Sprintf("PiCal's scheduled backup failed: %v", err))
+			continue
+		}
+		if !s.Config.BackupRestoreDrill {
+			continue
+		}
+		drillCtx, cancel := context.WithTimeout(ctx, backupTimeout)
+		_, err = s.restoreDrill(drillCtx)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			log.Printf("backups: restore drill: %v", err)
+		}
+	}
+}
+
+// errNoBackups is returned by restoreDrill when no target has a backup yet.
+var errNoBackups = errors.New("there are no scheduled backups yet")
+
+// restoreDrill reads the newest scheduled backup back from its target and
+// restores it into a scratch schema, with schemas.VerifyBackup, to show it
+// can be. A failed drill is sent to the admin. The error is for when there
+// was nothing to restore, or the drill couldn't be run at all.
+func (s *Server) restoreDrill(ctx context.Context) (RestoreDrill, error) {
+	dest, file, err := s.latestBackup(ctx)
+	if err != nil {
+		return RestoreDrill{}, err
+	}
+
+	drill := RestoreDrill{File: file, Target: dest.label(), At: time.Now()}
+	var in schemas.Backup
+	data, err := dest.target.Get(ctx, file)
+	if err == nil {
+		err = json.Unmarshal(data, &in)
+	}
+	if err == nil {
+		drill.BackupCheck, err = schemas.VerifyBackup(ctx, s.DB, in)
+		if err != nil {
+			return RestoreDrill{}, err
+		}
+	} else {
+		drill.Error = err.Error()
+	}
+	if drill.Tables == nil {
+		drill.Tables = make([]schemas.BackupTableCheck, 0)
+	}
+	s.backupDrill.Store(&drill)
+
+	if !drill.OK {
+		s.notifyAdmin(ctx, fmt.Sprintf("PiCal's restore drill of %s from %s failed: %s", file, drill.Target, drill.Error))
+	}
+	return drill, nil
+}
+
+// latestBackup finds the newest scheduled backup at any target, and the
+// target it is at. Targets that can't be listed are skipped.
+func (s *Server) latestBackup(ctx context.Context) (backupDest, string, error) {
+	dests, err := s.backupDests(ctx)
+	if err != nil {
+		log.Printf("backups: %v", err)
+	}
+	var dest backupDest
+	newest := ""
+	for _, d := range dests {
+		names, err := d.files(ctx)
+		if err != nil {
+			log.Printf("backups: %s: %v", d.label(), err)
+			continue
+		}
+		// The names sort in the order the backups were made
+		if len(names) > 0 && names[len(names)-1] > newest {
+			dest, newest = d, names[len(names)-1]
+		}
+	}
+	if newest == "" {
+		return backupDest{}, "", errNoBackups
+	}
+	return dest, newest, nil
+}
+
+// verifyBackup runs a restore drill straight away, for checking a target
+// without waiting for the next scheduled backup.
+func (s *Server) verifyBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	drill, err := s.restoreDrill(r.Context())
+	if err != nil {
+		if errors.Is(err, errNoBackups) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, drill)
+}
+
+// backupDest is one place scheduled backups go.
+type backupDest struct {
+	// Empty for BACKUP_TARGET
+	name   string
+	target backups.Target
+	keep   int
+	// Why the target couldn't be opened, leaving target nil
+	err error
+}
+
+func (d backupDest) label() string {
+	if d.name == "" {
+		return "BACKUP_TARGET"
+	}
+	return d.name
+}
+
+// backupDests is BACKUP_TARGET, when there is one, and the added targets.
+// The error is for the added targets, when they couldn't be listed.
+func (s *Server) backupDests(ctx context.Context) ([]backupDest, error) {
+	dests := make([]backupDest, 0)
+	if s.backups != nil {
+		dests = append(dests, backupDest{target: s.backups, keep: s.Config.BackupKeep})
+	}
+	targets, err := schemas.ListBackupTargets(ctx, s.DB)
+	if err != nil {
+		return dests, err
+	}
+	for _, t := range targets {
+		target, err := s.openBackupTarget(t)
+		dests = append(dests, backupDest{name: t.Name, target: target, keep: t.Keep, err: err})
+	}
+	return dests, nil
+}
+
+// makeBackup writes one backup to every target, removing the oldest past
+// what each keeps. It fails if any target did, having still tried the rest.
+func (s *Server) makeBackup(ctx context.Context) error {
+	dests, listErr := s.backupDests(ctx)
+	if len(dests) == 0 {
+		return listErr
+	}
+
+	backup, err := schemas.ExportBackup(ctx, s.DB)
+	var data []byte
+	if err == nil {
+		data, err = json.Marshal(backup)
+	}
+	name := backupPrefix + backup.ExportedAt.Format(backupTimeLayout) + ".json"
+
+	old := s.backupStatus.Load()
+	status := BackupStatus{}
+	failures := make([]string, 0)
+	if listErr != nil {
+		failures = append(failures, listErr.Error())
+	}
+	for _, d := range dests {
+		st := old.target(d.name)
+		sendErr := err
+		if sendErr == nil {
+			sendErr = d.send(ctx, name, data)
+		}
+		if sendErr != nil {
+			now := time.Now()
+			st.LastError, st.LastFailure = sendErr.Error(), &now
+			if err == nil {
+				failures = append(failures, d.label()+": "+sendErr.Error())
+			}
+		} else {
+			st = BackupTargetStatus{LastFile: name, LastSuccess: &backup.ExportedAt}
+		}
+		status.set(d.name, st)
+	}
+	s.backupStatus.Store(&status)
+
+	if err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// send writes one backup to d and then removes the oldest past d.keep.
+func (d backupDest) send(ctx context.Context, name string, data []byte) error {
+	if d.err != nil {
+		return d.err
+	}
+	if err := d.target.Put(ctx, name, data); err != nil {
+		return err
+	}
+
+	if d.keep <= 0 {
+		return nil
+	}
+	names, err := d.files(ctx)
+	if err != nil {
+		log.Printf("backups: %s: %v", d.label(), err)
+		return nil
+	}
+	for _, old := range names[:max(len(names)-d.keep, 0)] {
+		if err := d.target.Delete(ctx, old); err != nil {
+			log.Printf("backups: %s: %v", d.label(), err)
+		}
+	}
+	return nil
+}
+
+// set records st as the status of the target called name.
+func (b *BackupStatus) set(name string, st BackupTargetStatus) {
+	if name == "" {
+		b.BackupTargetStatus = st
+		return
+	}
+	if b.Targets == nil {
+		b.Targets = make(map[string]BackupTargetStatus)
+	}
+	b.Targets[name] = st
+}
+
+// target is the status of the target called name, empty if there's none.
+func (b *BackupStatus) target(name string) BackupTargetStatus {
+	if b == nil {
+		return BackupTargetStatus{}
+	}
+	if name == "" {
+		return b.BackupTargetStatus
+	}
+	return b.Targets[name]
+}
+
+// files lists the scheduled backups at the target, oldest first, leaving
+// out anything else kept there.
+func (d backupDest) files(ctx context.Context) ([]string, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	names, err := d.target.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names = slices.DeleteFunc(names, func(name string) bool {
+		_, err := backupTime(name)
+		return err != nil
+	})
+	slices.Sort(names)
+	return names, nil
+}
+
+// backupTime is when the scheduled backup called name was made.
+func backupTime(name string) (time.Time, error) {
+	stamp, ok := strings.CutPrefix(name, backupPrefix)
+	if ok {
+		stamp, ok = strings.CutSuffix(stamp, ".json")
+	}
+	if !ok {
+		return time.Time{}, fmt.Errorf("not a backup: %s", name)
+	}
+	return time.Parse(backupTimeLayout, stamp)
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pical/backups"
+	"pical/database/schemas"
+	"strings"
+)
+
+// openBackupTarget is where an added backup target writes to, encrypting
+// with BackupKey when it asks for that.
+func (s *Server) openBackupTarget(t schemas.BackupTargetLogin) (backups.Target, error) {
+	cfg := backups.TargetConfig{
+		URL: t.URL,
+		S3: backups.S3Config{
+			Endpoint:  t.S3Endpoint,
+			Region:    t.S3Region,
+			AccessKey: t.Username,
+			SecretKey: t.Secret,
+		},
+		Username:   t.Username,
+		Password:   t.Secret,
+		PrivateKey: t.PrivateKey,
+		HostKey:    t.HostKey,
+	}
+	if cfg.S3.Endpoint == "" {
+		cfg.S3.Endpoint = "https://s3.amazonaws.com"
+	}
+	if cfg.S3.Region == "" {
+		cfg.S3.Region = "us-east-1"
+	}
+	if t.Encrypt {
+		if s.Config.BackupKey == nil {
+			return nil, fmt.Errorf("encrypt is set, but BACKUP_ENCRYPTION_KEY isn't")
+		}
+		cfg.Key = s.Config.BackupKey
+	}
+	return backups.New(cfg)
+}
+
+func (s *Server) backupTargetHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getBackupTargets(w, r)
+	case http.MethodPost:
+		s.createBackupTarget(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) backupTargetByIDHandler(w http.ResponseWriter, r *http.Request) {
+	// Path is like "/admin/backup-targets/{id}"
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/backup-targets/"), "/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !validID(w, "id", id) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.getBackupTarget(w, r, id)
+	case http.MethodPut:
+		s.updateBackupTarget(w, r, id)
+	case http.MethodDelete:
+		s.deleteBackupTarget(w, r, id)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getBackupTargets lists the added backup targets, without their secrets.
+func (s *Server) getBackupTargets(w http.ResponseWriter, r *http.Request) {
+	targets, err := schemas.ListBackupTargets(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]schemas.BackupTarget, 0, len(targets))
+	for _, t := range targets {
+		out = append(out, t.BackupTarget)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// checkBackupTarget reports, as a 400, a target that couldn't be written
+// to as given. It only opens the target, so a wrong password still shows
+// up at the next backup rather than here.
+func (s *Server) checkBackupTarget(w http.ResponseWriter, t schemas.BackupTargetLogin) bool {
+	if !compiledIn("backups") {
+		http.Error(w, "this build was made without backups", http.StatusBadRequest)
+		return false
+	}
+	if _, err := s.openBackupTarget(t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (s *Server) createBackupTarget(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in BackupTargetInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !s.checkBackupTarget(w, schemas.BackupTargetLogin(in)) {
+		return
+	}
+
+	created, err := schemas.CreateBackupTarget(r.Context(), s.DB, in.BackupTarget, in.BackupTargetSecrets)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) getBackupTarget(w http.ResponseWriter, r *http.Request, id string) {
+	t, err := schemas.GetBackupTarget(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "backup target not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, t.BackupTarget)
+}
+
+func (s *Server) updateBackupTarget(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var in BackupTargetInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	existing, err := schemas.GetBackupTarget(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "backup target not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	check := schemas.BackupTargetLogin(in)
+	if check.Secret == "" {
+		check.Secret = existing.Secret
+	}
+	if check.PrivateKey == "" {
+		check.PrivateKey = existing.PrivateKey
+	}
+	if !s.checkBackupTarget(w, check) {
+		return
+	}
+
+	updated, err := schemas.UpdateBackupTarget(r.Context(), s.DB, id, in.BackupTarget, in.BackupTargetSecrets)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "backup target not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// deleteBackupTarget stops backups going to a target; those already there
+// are left alone.
+func (s *Server) deleteBackupTarget(w http.ResponseWriter, r *http.Request, id string) {
+	if err := schemas.DeleteBackupTarget(r.Context(), s.DB, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "backup target not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Speech synthesis on a Pi takes a few seconds, well past the usual DB deadline.
+const ttsTimeout = 30 * time.Second
+
+// getBriefing is today's schedule as a short spoken-style summary, starting
+// today in ?tz (default: the server's local time). It takes the same filters
+// as GET /events, so ?person= gives one person's day.
+func (s *Server) getBriefing(w http.ResponseWriter, r *http.Request) {
+	text, ok := s.briefingText(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(text))
+}
+
+// getBriefingAudio reads the briefing aloud through TTSCommand, which gets
+// the text on stdin and must write a WAV file to stdout.
+func (s *Server) getBriefingAudio(w http.ResponseWriter, r *http.Request) {
+	args := strings.Fields(s.Config.TTSCommand)
+	if len(args) == 0 {
+		http.Error(w, "text-to-speech is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	text, ok := s.briefingText(w, r)
+	if !ok {
+		return
+	}
+
+	var out, stderr bytes.Buffer
+	cmd := exec.CommandContext(r.Context(), args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			http.Error(w, fmt.Sprintf("text-to-speech is not available: %s is not installed", args[0]), http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("briefing: %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+		http.Error(w, "text-to-speech failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out.Bytes())
+}
+
+// briefingText builds today's briefing, writing the error response itself
+// when it can't.
+func (s *Server) briefingText(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+
+	loc, err := parseLocalTZ(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		badRequest(w, err)
+		return "", false
+	}
+
+	agenda, err := s.buildAgenda(r.Context(), filter, loc, 1, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+
+	return briefing(agenda[0], loc), true
+}
+
+// briefing words a day of the agenda as sentences that read well aloud.
+func briefing(day AgendaDay, loc *time.Location) string {
+	var b strings.Builder
+
+	date, _ := time.ParseInLocation("2006-01-02", day.Date, loc)
+	fmt.Fprintf(&b, "Today is %s.\n", date.Format("Monday 2 January"))
+
+	switch n := len(day.Occurrences); n {
+	case 0:
+		b.WriteString("Nothing is planned today.\n")
+		return b.String()
+	case 1:
+		b.WriteString("There is one event today.\n")
+	default:
+		fmt.Fprintf(&b, "There are %d events today.\n", n)
+	}
+
+	for _, occ := range day.Occurrences {
+		switch {
+		case occ.Event.AllDay:
+			b.WriteString("All day")
+		case occ.StartTime.Before(date) && occ.EndTime != nil:
+			// Carried over from yesterday
+			fmt.Fprintf(&b, "Until %s", spokenTime(*occ.EndTime, loc))
+		default:
+			fmt.Fprintf(&b, "At %s", spokenTime(occ.StartTime, loc))
+		}
+		fmt.Fprintf(&b, ", %s", occ.Event.Title)
+		if occ.Event.PersonName != "" {
+			fmt.Fprintf(&b, ", for %s", occ.Event.PersonName)
+		}
+		b.WriteString(".\n")
+	}
+
+	return b.String()
+}
+
+func spokenTime(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format("3:04 PM")
+}
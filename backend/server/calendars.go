@@ -0,0 +1,92 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pical/database/schemas"
+)
+
+func (s *Server) getCalendars(w http.ResponseWriter, r *http.Request) {
+	calendars, err := schemas.ListCalendars(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, calendars)
+}
+
+func (s *Server) createCalendar(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in schemas.Calendar
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	created, err := schemas.CreateCalendar(r.Context(), s.DB, in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, SavedCalendar{Calendar: created, Warnings: colorWarnings(created.Color)})
+}
+
+func (s *Server) getCalendar(w http.ResponseWriter, r *http.Request, id string) {
+	c, err := schemas.GetCalendar(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "calendar not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (s *Server) updateCalendar(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var in schemas.Calendar
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := schemas.UpdateCalendar(r.Context(), s.DB, id, in)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "calendar not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SavedCalendar{Calendar: updated, Warnings: colorWarnings(updated.Color)})
+}
+
+// deleteCalendar only removes empty calendars; move or delete their events
+// and feeds first.
+func (s *Server) deleteCalendar(w http.ResponseWriter, r *http.Request, id string) {
+	err := schemas.DeleteCalendar(r.Context(), s.DB, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "calendar not found", http.StatusNotFound)
+		case errors.Is(err, schemas.ErrCalendarInUse):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// How many exchanges a capture keeps, dropping the oldest
+	captureMaxExchanges = 200
+	// Bodies longer than this are cut short
+	captureMaxBody = 16 << 10
+	// The longest a capture may run for
+	captureMaxDuration = time.Hour
+)
+
+// debugCapture keeps copies of requests and responses, with their secrets
+// blanked out, while an admin has turned it on for some routes. Nothing is
+// kept once it runs out or is turned off.
+type debugCapture struct {
+	mu        sync.Mutex
+	routes    []string
+	webhooks  bool
+	until     time.Time
+	exchanges []CapturedExchange
+}
+
+func (c *debugCapture) start(routes []string, webhooks bool, until time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.routes, c.webhooks, c.until = routes, webhooks, until
+	c.exchanges = nil
+}
+
+func (c *debugCapture) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.routes, c.webhooks, c.until = nil, false, time.Time{}
+	c.exchanges = nil
+}
+
+// active reports whether the capture is still running, forgetting what it
+// kept once it has run out.
+func (c *debugCapture) active(now time.Time) bool {
+	if c.until.IsZero() {
+		return false
+	}
+	if !now.Before(c.until) {
+		c.routes, c.webhooks, c.until = nil, false, time.Time{}
+		c.exchanges = nil
+		return false
+	}
+	return true
+}
+
+// The capture's own endpoint, which is never captured: each look at the
+// capture would otherwise be kept in it, along with everything before
+const captureRoute = "/admin/debug-capture"
+
+// capturing reports whether requests to path are being captured.
+func (c *debugCapture) capturing(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if strings.HasSuffix(path, captureRoute) || !c.active(time.Now()) {
+		return false
+	}
+	return slices.ContainsFunc(c.routes, func(route string) bool { return strings.HasPrefix(path, route) })
+}
+
+// capturingWebhooks reports whether outgoing webhook deliveries are being captured.
+func (c *debugCapture) capturingWebhooks() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active(time.Now()) && c.webhooks
+}
+
+func (c *debugCapture) record(ex CapturedExchange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.active(time.Now()) {
+		return
+	}
+	c.exchanges = append(c.exchanges, ex)
+	if len(c.exchanges) > captureMaxExchanges {
+		c.exchanges = slices.Delete(c.exchanges, 0, len(c.exchanges)-captureMaxExchanges)
+	}
+}
+
+func (c *debugCapture) status() DebugCaptureStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := DebugCaptureStatus{Routes: make([]string, 0), Exchanges: make([]CapturedExchange, 0)}
+	if !c.active(time.Now()) {
+		return out
+	}
+	until := c.until
+	out.Active, out.Until, out.Webhooks = true, &until, c.webhooks
+	out.Routes = append(out.Routes, c.routes...)
+	out.Exchanges = append(out.Exchanges, c.exchanges...)
+	return out
+}
+
+// captureMiddleware records the requests c is capturing, with their
+// responses. The body kept is what the handler read of the request and
+// the start of what it wrote back.
+func captureMiddleware(c *debugCapture) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !c.capturing(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			reqBody := &limitedBuffer{}
+			if r.Body != nil {
+				r.Body = readCloser{io.TeeReader(r.Body, reqBody), r.Body}
+			}
+			cw := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(cw, r)
+
+			c.record(CapturedExchange{
+				At:              start.UTC(),
+				Direction:       "in",
+				Method:          r.Method,
+				URL:             redactURL(r.URL),
+				Status:          cw.status,
+				DurationMillis:  time.Since(start).Milliseconds(),
+				RequestHeaders:  redactHeaders(r.Header),
+				RequestBody:     reqBody.String(),
+				ResponseHeaders: redactHeaders(cw.Header()),
+				ResponseBody:    cw.body.String(),
+				Truncated:       reqBody.truncated || cw.body.truncated,
+			})
+		})
+	}
+}
+
+// captureWriter passes a response through and keeps the start of it.
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   limitedBuffer
+}
+
+func (cw *captureWriter) WriteHeader(status int) {
+	cw.status = status
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// limitedBuffer keeps the first captureMaxBody bytes written to it and
+// notes whether there were more.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := captureMaxBody - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+	} else {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// String is the body with its secrets blanked out, or a note of its size
+// when it isn't text.
+func (b *limitedBuffer) String() string {
+	data := b.buf.Bytes()
+	// A multi-byte character may have been cut off at the end
+	if b.truncated {
+		for len(data) > 0 && !utf8.Valid(data) {
+			data = data[:len(data)-1]
+		}
+	}
+	if !utf8.Valid(data) {
+		return "[binary]"
+	}
+	return redactText(string(data))
+}
+
+const redacted = "[redacted]"
+
+// Headers whose values are credentials
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Pical-Signature"}
+
+// Words that mark a JSON key, form field or query parameter as holding a secret
+const secretWords = `token|secret|password|signature|authorization|apikey|api_key|code|p256dh|auth`
+
+var (
+	secretParam = regexp.MustCompile(`(?i)` + secretWords)
+	// "key": "value" where the key names a secret
+	secretJSON = regexp.MustCompile(`(?i)("[^"]*(?:` + secretWords + `)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*"?`)
+	// key=value where the key names a secret, as in a form body
+	secretForm = regexp.MustCompile(`(?i)((?:^|[&?\s])[^=&\s]*(?:` + secretWords + `)[^=&\s]*=)[^&\s]*`)
+)
+
+func redactHeaders(h http.Header) http.Header {
+	out := h.Clone()
+	for _, name := range secretHeaders {
+		if len(out.Values(name)) > 0 {
+			out.Set(name, redacted)
+		}
+	}
+	return out
+}
+
+func redactURL(u *url.URL) string {
+	q := u.Query()
+	for key := range q {
+		if secretParam.MatchString(key) {
+			q.Set(key, redacted)
+		}
+	}
+	out := *u
+	out.RawQuery = q.Encode()
+	return out.String()
+}
+
+// redactText blanks out the values of anything in a JSON or form body that
+// looks like a secret. It works on the text rather than parsing it, so a
+// truncated or malformed body is covered too.
+func redactText(s string) string {
+	s = secretJSON.ReplaceAllString(s, `$1"`+redacted+`"`)
+	return secretForm.ReplaceAllString(s, `${1}`+redacted)
+}
+
+// debugCaptureHandler turns capturing on with POST, shows what it has kept
+// with GET, and turns it off, forgetting everything, with DELETE.
+func (s *Server) debugCaptureHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.capture.status())
+	case http.MethodPost:
+		defer r.Body.Close()
+		var in DebugCaptureRequest
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if len(in.Routes) == 0 && !in.Webhooks {
+			http.Error(w, "routes or webhooks is required", http.StatusBadRequest)
+			return
+		}
+		for _, route := range in.Routes {
+			if !strings.HasPrefix(route, "/") {
+				http.Error(w, "routes must be paths starting with /", http.StatusBadRequest)
+				return
+			}
+		}
+		d := time.Duration(in.Minutes) * time.Minute
+		if d <= 0 || d > captureMaxDuration {
+			http.Error(w, "minutes must be between 1 and 60", http.StatusBadRequest)
+			return
+		}
+		s.capture.start(in.Routes, in.Webhooks, time.Now().Add(d))
+		writeJSON(w, http.StatusOK, s.capture.status())
+	case http.MethodDelete:
+		s.capture.stop()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
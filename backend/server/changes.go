@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"pical/database/schemas"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syncToken is what /api/changes hands out: the last change the client has
+// been sent, and when, so tokens can outlive only what the log remembers.
+type syncToken struct {
+	seq int64
+	at  time.Time
+}
+
+func (t syncToken) String() string {
+	return fmt.Sprintf("%d.%d", t.seq, t.at.Unix())
+}
+
+func parseSyncToken(v string) (syncToken, error) {
+	seq, at, ok := strings.Cut(v, ".")
+	if !ok {
+		return syncToken{}, fmt.Errorf("invalid since token")
+	}
+	n, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil || n < 0 {
+		return syncToken{}, fmt.Errorf("invalid since token")
+	}
+	unix, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return syncToken{}, fmt.Errorf("invalid since token")
+	}
+	return syncToken{seq: n, at: time.Unix(unix, 0)}, nil
+}
+
+// getChanges serves /api/changes: every event created, updated or deleted
+// since ?since, or every event there is without it. Exceptions, tags and
+// attendees count as part of their event. The response's token is the
+// ?since for the next call; while more is true, call again straight away.
+func (s *Server) getChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var since syncToken
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = parseSyncToken(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.Config.ChangeRetention > 0 && time.Since(since.at) > s.Config.ChangeRetention {
+			http.Error(w, "since token has expired; sync again without it", http.StatusGone)
+			return
+		}
+	}
+	limit, _ := s.parsePage(r)
+
+	now := time.Now()
+	changes, err := schemas.ListEventChanges(r.Context(), s.DB, since.seq, limit+1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	more := len(changes) > limit
+	if more {
+		changes = changes[:limit]
+	}
+
+	out := ChangeSet{
+		Token:   syncToken{seq: since.seq, at: now}.String(),
+		Created: make([]ChangedEvent, 0),
+		Updated: make([]ChangedEvent, 0),
+		Deleted: make([]string, 0),
+		More:    more,
+	}
+	if len(changes) == 0 {
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+	out.Token = syncToken{seq: changes[len(changes)-1].Seq, at: now}.String()
+
+	ids := make([]string, 0, len(changes))
+	for _, c := range changes {
+		if c.Deleted {
+			if since.seq > 0 {
+				out.Deleted = append(out.Deleted, c.EventID)
+			}
+			continue
+		}
+		ids = append(ids, c.EventID)
+	}
+
+	events, err := s.Events.GetEvents(r.Context(), ids)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	exs, err := schemas.ListAllExceptions(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	found := make(map[string]bool, len(events))
+	for _, e := range events {
+		found[e.EventID] = true
+		ce := ChangedEvent{Event: e, Exceptions: exs[e.EventID]}
+		if ce.Exceptions == nil {
+			ce.Exceptions = make([]schemas.Exception, 0)
+		}
+		if since.seq == 0 || e.CreatedAt.After(since.at) {
+			out.Created = append(out.Created, ce)
+		} else {
+			out.Updated = append(out.Updated, ce)
+		}
+	}
+	// Deleted after the log was read; its tombstone comes on a later call,
+	// but there is no reason to hold it back
+	for _, id := range ids {
+		if !found[id] && since.seq > 0 {
+			out.Deleted = append(out.Deleted, id)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// runChangePruner forgets deletions older than the retention daily until
+// ctx is canceled.
+func (s *Server) runChangePruner(ctx context.Context, retention time.Duration) {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		pruneCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		if err := schemas.PruneEventChanges(pruneCtx, s.DB, time.Now().Add(-retention)); err != nil && ctx.Err() == nil {
+			log.Printf("change log: %v", err)
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// The backgrounds colours are checked against, one per display theme
+var colorThemes = []struct{ name, background string }{
+	{"light", "#ffffff"},
+	{"dark", "#121212"},
+}
+
+// WCAG's minimum contrast for large text and graphics, which is what the
+// wall display mostly shows
+const minContrast = 3.0
+
+// checkColor reports how color reads on each theme's background, with the
+// nearest readable colour for the themes it fails on.
+func checkColor(color string) ([]ColorContrast, error) {
+	fg, err := parseHexColor(color)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]ColorContrast, 0, len(colorThemes))
+	for _, t := range colorThemes {
+		bg, _ := parseHexColor(t.background)
+		ratio := contrastRatio(fg, bg)
+		c := ColorContrast{
+			Theme:      t.name,
+			Background: t.background,
+			Ratio:      math.Round(ratio*100) / 100,
+			Readable:   ratio >= minContrast,
+		}
+		if !c.Readable {
+			if s, ok := readableColor(fg, bg); ok {
+				c.Suggestion = &s
+			}
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// colorWarnings is checkColor for a colour being saved: the themes it is
+// hard to read on. Saving goes ahead either way.
+func colorWarnings(color *string) []ColorContrast {
+	out := make([]ColorContrast, 0)
+	if color == nil {
+		return out
+	}
+	checks, err := checkColor(*color)
+	if err != nil {
+		return out
+	}
+	for _, c := range checks {
+		if !c.Readable {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// getColorContrast serves /api/colors/contrast?color=%23rrggbb, for pickers
+// that want to warn before anything is saved.
+func (s *Server) getColorContrast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	color := r.URL.Query().Get("color")
+	checks, err := checkColor(color)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ColorReport{Color: color, Themes: checks})
+}
+
+type rgb struct{ r, g, b float64 }
+
+// parseHexColor reads "#rgb" or "#rrggbb" into channels from 0 to 1.
+func parseHexColor(s string) (rgb, error) {
+	if len(s) == 4 && s[0] == '#' {
+		s = string([]byte{'#', s[1], s[1], s[2], s[2], s[3], s[3]})
+	}
+	if len(s) != 7 || s[0] != '#' {
+		return rgb{}, fmt.Errorf("color must be a hex colour like #3b82f6")
+	}
+	n, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return rgb{}, fmt.Errorf("color must be a hex colour like #3b82f6")
+	}
+	return rgb{float64(n>>16&0xff) / 255, float64(n>>8&0xff) / 255, float64(n&0xff) / 255}, nil
+}
+
+func (c rgb) hex() string {
+	ch := func(v float64) int { return int(math.Round(math.Max(0, math.Min(1, v)) * 255)) }
+	return fmt.Sprintf("#%02x%02x%02x", ch(c.r), ch(c.g), ch(c.b))
+}
+
+// luminance is the WCAG relative luminance of c.
+func (c rgb) luminance() float64 {
+	lin := func(v float64) float64 {
+		if v <= 0.03928 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	return 0.2126*lin(c.r) + 0.7152*lin(c.g) + 0.0722*lin(c.b)
+}
+
+func contrastRatio(a, b rgb) float64 {
+	la, lb := a.luminance(), b.luminance()
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
+
+// readableColor is fg made just dark or light enough to read on bg, keeping
+// its hue and saturation so it still looks like the same colour.
+func readableColor(fg, bg rgb) (string, bool) {
+	h, s, l := fg.hsl()
+	step := 0.01
+	if bg.luminance() < 0.5 {
+		step = -step
+	}
+	for l = l - step; l >= 0 && l <= 1; l -= step {
+		c := hslToRGB(h, s, l)
+		// Rounding to hex can lose the last bit of contrast
+		if c, _ = parseHexColor(c.hex()); contrastRatio(c, bg) >= minContrast {
+			return c.hex(), true
+		}
+	}
+	return "", false
+}
+
+func (c rgb) hsl() (h, s, l float64) {
+	max := math.Max(c.r, math.Max(c.g, c.b))
+	min := math.Min(c.r, math.Min(c.g, c.b))
+	l = (max + min) / 2
+	if max == min {
+		return 0, 0, l
+	}
+
+	d := max - min
+	if l > 0.5 {
+		s = d / (2 - max - min)
+	} else {
+		s = d / (max + min)
+	}
+	switch max {
+	case c.r:
+		h = math.Mod((c.g-c.b)/d+6, 6)
+	case c.g:
+		h = (c.b-c.r)/d + 2
+	default:
+		h = (c.r-c.g)/d + 4
+	}
+	return h / 6, s, l
+}
+
+func hslToRGB(h, s, l float64) rgb {
+	if s == 0 {
+		return rgb{l, l, l}
+	}
+	q := l * (1 + s)
+	if l >= 0.5 {
+		q = l + s - l*s
+	}
+	p := 2*l - q
+	hue := func(t float64) float64 {
+		t = math.Mod(t+1, 1)
+		switch {
+		case t < 1.0/6:
+			return p + (q-p)*6*t
+		case t < 0.5:
+			return q
+		case t < 2.0/3:
+			return p + (q-p)*(2.0/3-t)*6
+		}
+		return p
+	}
+	return rgb{hue(h + 1.0/3), hue(h), hue(h - 1.0/3)}
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"pical/database/schemas"
+)
+
+// eventCursor is what an event listing's nextCursor holds. The sort is kept
+// with the place so a cursor can't be replayed against another order.
+type eventCursor struct {
+	Sort schemas.EventSort `json:"s,omitempty"`
+	schemas.EventCursor
+}
+
+// encodeEventCursor makes the opaque ?cursor value for c under sort.
+func encodeEventCursor(sort schemas.EventSort, c schemas.EventCursor) string {
+	b, _ := json.Marshal(eventCursor{Sort: sort, EventCursor: c})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeEventCursor reads a ?cursor value made by encodeEventCursor,
+// checking it was made for sort.
+func decodeEventCursor(v string, sort schemas.EventSort) (schemas.EventCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return schemas.EventCursor{}, fmt.Errorf("invalid cursor")
+	}
+	var c eventCursor
+	if err := json.Unmarshal(b, &c); err != nil || !isUUID(c.EventID) || (c.Sort != "" && c.At == nil) {
+		return schemas.EventCursor{}, fmt.Errorf("invalid cursor")
+	}
+	if c.Sort != sort {
+		return schemas.EventCursor{}, fmt.Errorf("cursor was made for a different sort; pass the same sort with it")
+	}
+	return c.EventCursor, nil
+}
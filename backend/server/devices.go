@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"pical/database/schemas"
+	"strings"
+	"time"
+)
+
+// A device missing for this long gets a reboot queued instead of a reload;
+// a stuck browser is usually fixed by a reload, a stuck Pi is not.
+const rebootAfter = time.Hour
+
+var alertClient = &http.Client{Timeout: 10 * time.Second}
+
+func (s *Server) getDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := schemas.ListDevices(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, devices)
+}
+
+func (s *Server) createDevice(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in schemas.Device
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	created, err := schemas.CreateDevice(r.Context(), s.DB, in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) getDevice(w http.ResponseWriter, r *http.Request, id string) {
+	d, err := schemas.GetDevice(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "device not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, d)
+}
+
+func (s *Server) deleteDevice(w http.ResponseWriter, r *http.Request, id string) {
+	if err := schemas.DeleteDevice(r.Context(), s.DB, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "device not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deviceHeartbeat records that a kiosk is alive and tells it what to do next.
+func (s *Server) deviceHeartbeat(w http.ResponseWriter, r *http.Request, id string) {
+	before, err := schemas.RecordHeartbeat(r.Context(), s.DB, id, time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "device not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if before.MissingSince != nil {
+		log.Printf("device %s (%s) is back after %s", before.Name, before.DeviceID, time.Since(*before.MissingSince).Round(time.Second))
+	}
+
+	writeJSON(w, http.StatusOK, Heartbeat{
+		Command:         before.PendingCommand,
+		IntervalSeconds: int(s.Config.HeartbeatInterval / time.Second),
+	})
+}
+
+func (s *Server) queueDeviceCommand(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var in DeviceCommand
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !schemas.ValidDeviceCommand(in.Command) {
+		http.Error(w, fmt.Sprintf("command must be %q or %q", schemas.DeviceCommandReload, schemas.DeviceCommandReboot), http.StatusBadRequest)
+		return
+	}
+
+	d, err := schemas.QueueDeviceCommand(r.Context(), s.DB, id, in.Command)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "device not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, d)
+}
+
+// runWatchdog checks for devices that stopped sending heartbeats until ctx
+// is canceled.
+func (s *Server) runWatchdog(ctx context.Context) {
+	ticker := time.NewTicker(s.Config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		s.checkDevices(checkCtx, time.Now())
+		cancel()
+	}
+}
+
+// checkDevices flags devices that missed MissedHeartbeats heartbeats in a
+// row and alerts the admin once per outage.
+func (s *Server) checkDevices(ctx context.Context, now time.Time) {
+	cutoff := now.Add(-time.Duration(s.Config.MissedHeartbeats) * s.Config.HeartbeatInterval)
+	missing, err := schemas.MarkMissingDevices(ctx, s.DB, cutoff, now)
+	if err != nil {
+		log.Printf("device watchdog: %v", err)
+		return
+	}
+	for _, d := range missing {
+		s.notifyAdmin(ctx, fmt.Sprintf("PiCal display %q has missed %d heartbeats (last seen %s); it will reload when it reconnects.",
+			d.Name, s.Config.MissedHeartbeats, d.LastSeenAt.Format(time.RFC3339)))
+	}
+
+	escalated, err := schemas.EscalateMissingDevices(ctx, s.DB, now.Add(-rebootAfter))
+	if err != nil {
+		log.Printf("device watchdog: %v", err)
+		return
+	}
+	for _, d := range escalated {
+		log.Printf("device %s (%s) still missing; reboot queued", d.Name, d.DeviceID)
+	}
+}
+
+// notifyAdmin logs msg and, when AlertURL is set, posts it there as plain
+// text, which suits ntfy topics and most chat webhooks.
+func (s *Server) notifyAdmin(ctx context.Context, msg string) {
+	log.Print(msg)
+	if s.Config.AlertURL == "" {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.Config.AlertURL, strings.NewReader(msg))
+	if err != nil {
+		log.Printf("alert: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := alertClient.Do(req)
+	if err != nil {
+		log.Printf("alert: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("alert: unexpected status %s", resp.Status)
+	}
+}
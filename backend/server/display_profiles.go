@@ -0,0 +1,101 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pical/database/schemas"
+)
+
+func (s *Server) getDisplayProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := schemas.ListDisplayProfiles(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, profiles)
+}
+
+func (s *Server) createDisplayProfile(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in schemas.DisplayProfile
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	created, err := schemas.CreateDisplayProfile(r.Context(), s.DB, in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) getDisplayProfile(w http.ResponseWriter, r *http.Request, id string) {
+	p, err := schemas.GetDisplayProfile(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "display profile not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (s *Server) updateDisplayProfile(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var in schemas.DisplayProfile
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := schemas.UpdateDisplayProfile(r.Context(), s.DB, id, in)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "display profile not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (s *Server) deleteDisplayProfile(w http.ResponseWriter, r *http.Request, id string) {
+	err := schemas.DeleteDisplayProfile(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "display profile not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getDisplayProfileOccurrences is /api/occurrences limited to what the profile shows.
+func (s *Server) getDisplayProfileOccurrences(w http.ResponseWriter, r *http.Request, id string) {
+	if _, err := schemas.GetDisplayProfile(r.Context(), s.DB, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "display profile not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeOccurrences(w, r, schemas.EventFilter{DisplayProfileID: id})
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"fmt"
+	"pical/database/schemas"
+	"pical/recurrence"
+	"time"
+)
+
+// How far ahead a recurring event's occurrences are checked for DST changes
+const dstLookahead = 366 * 24 * time.Hour
+
+// dstWarnings returns a "dst" warning for each start of e that names a local
+// time its zone skips or repeats. The stored instant is what counts for a
+// one-off event, so it is only questioned when the offset it was written
+// with disagrees with the zone's, which is how a client that worked out the
+// offset for a skipped time shows up, or when the time it names happens
+// twice. Recurring events are checked from now, or their start if later,
+// for dstLookahead; those occurrences follow recurrence.ResolveLocal.
+func dstWarnings(e schemas.Event, now time.Time) []EventWarning {
+	out := make([]EventWarning, 0)
+	if e.AllDay {
+		return out
+	}
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		return out
+	}
+	var length time.Duration
+	if e.EndTime != nil {
+		length = e.EndTime.Sub(e.StartTime)
+	}
+	warn := func(start time.Time, msg string) {
+		w := EventWarning{Type: "dst", Message: msg, Event: e, StartTime: start}
+		if length > 0 {
+			end := start.Add(length)
+			w.EndTime = &end
+		}
+		out = append(out, w)
+	}
+
+	if !recurrence.Recurs(e) {
+		written := e.StartTime
+		local := written.In(loc)
+		_, writtenOffset := written.Zone()
+		_, zoneOffset := local.Zone()
+		_, kind := recurrence.ResolveLocal(written.Year(), written.Month(), written.Day(), written.Hour(), written.Minute(), written.Second(), loc)
+		switch {
+		case writtenOffset != zoneOffset && kind == recurrence.LocalNonexistent:
+			warn(local, fmt.Sprintf("%s on %s doesn't exist in %s; the clocks go forward then, so the event starts at %s",
+				written.Format("15:04"), occurrenceDay(e, local), e.Timezone, localClock(local)))
+		case writtenOffset == zoneOffset && kind == recurrence.LocalAmbiguous:
+			warn(local, fmt.Sprintf("%s on %s happens twice in %s as the clocks go back; the event starts at %s",
+				written.Format("15:04"), occurrenceDay(e, local), e.Timezone, localClock(local)))
+		}
+		return out
+	}
+
+	rule, err := recurrence.Parse(*e.Rrule)
+	if err != nil {
+		return out
+	}
+	start := e.StartTime.In(loc)
+	from := start
+	if now.After(from) {
+		from = now
+	}
+	hh, mm, ss := start.Clock()
+	for _, t := range rule.Between(start, from, from.Add(dstLookahead)) {
+		_, kind := recurrence.ResolveLocal(t.Year(), t.Month(), t.Day(), hh, mm, ss, loc)
+		switch kind {
+		case recurrence.LocalNonexistent:
+			warn(t, fmt.Sprintf("%s doesn't exist on %s in %s; the clocks go forward then, so that occurrence starts at %s",
+				start.Format("15:04"), occurrenceDay(e, t), e.Timezone, localClock(t)))
+		case recurrence.LocalAmbiguous:
+			warn(t, fmt.Sprintf("%s happens twice on %s in %s as the clocks go back; that occurrence starts at %s",
+				start.Format("15:04"), occurrenceDay(e, t), e.Timezone, localClock(t)))
+		}
+	}
+	return out
+}
+
+// localClock is a time of day with the offset that pins it down, e.g.
+// "03:30 EDT (-04:00)".
+func localClock(t time.Time) string {
+	return t.Format("15:04 MST (-07:00)")
+}
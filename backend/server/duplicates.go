@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"pical/database/schemas"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Titles at least this similar (0..1) are treated as the same appointment.
+const duplicateTitleSimilarity = 0.8
+
+// findDuplicates returns a warning for each existing event of the same person
+// that overlaps e in time and has a similar title, and with conflicts set,
+// for each one that overlaps with any other title. Only e's first occurrence
+// is checked, and e.PersonID must already be resolved.
+func (s *Server) findDuplicates(ctx context.Context, e schemas.Event, conflicts bool) ([]EventWarning, error) {
+	return s.findOverlaps(ctx, e.PersonID, e.Title, "", e.StartTime, e.EndTime, conflicts)
+}
+
+// findOverlaps checks the slot [start, end) of an event titled title against
+// personID's other occurrences, ignoring those of the event skipID.
+func (s *Server) findOverlaps(ctx context.Context, personID, title, skipID string, start time.Time, end *time.Time, conflicts bool) ([]EventWarning, error) {
+	from, to := start, instantEnd(start, end)
+
+	occs, err := s.expandOccurrences(ctx, schemas.EventFilter{PersonID: personID}, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]EventWarning, 0)
+	warned := make(map[string]bool)
+	for _, occ := range occs {
+		c := occ.Event
+		if warned[c.EventID] || c.EventID == skipID {
+			continue
+		}
+		// Open-ended occurrences are instants; keep ours consistent
+		if !occ.StartTime.Before(to) || !instantEnd(occ.StartTime, occ.EndTime).After(from) {
+			continue
+		}
+
+		w := EventWarning{
+			Type:      "duplicate",
+			Message:   fmt.Sprintf("%s already has %q at this time", c.PersonName, c.Title),
+			Event:     c,
+			StartTime: occ.StartTime,
+			EndTime:   occ.EndTime,
+		}
+		if !similarTitles(title, c.Title) {
+			if !conflicts {
+				continue
+			}
+			w.Type = "conflict"
+			w.Message = fmt.Sprintf("%s is busy with %q at this time", c.PersonName, c.Title)
+		}
+		warned[c.EventID] = true
+		out = append(out, w)
+	}
+
+	return out, nil
+}
+
+// overlapError is the 409 message for warnings a strict request refused.
+func overlapError(warnings []EventWarning) string {
+	for _, w := range warnings {
+		if w.Type == "duplicate" {
+			return "possible duplicate event"
+		}
+	}
+	return "conflicts with existing events"
+}
+
+// instantEnd gives events without a length a nanosecond so they still overlap.
+func instantEnd(start time.Time, end *time.Time) time.Time {
+	if end == nil || !end.After(start) {
+		return start.Add(time.Nanosecond)
+	}
+	return *end
+}
+
+// similarTitles reports whether a and b likely name the same appointment,
+// e.g. "Dentist" and "dentist appt." or "Sam - dentist" and "Dentist (Sam)".
+func similarTitles(a, b string) bool {
+	ta, tb := titleWords(a), titleWords(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return false
+	}
+
+	// All words of the shorter title appear in the longer one
+	if len(ta) > len(tb) {
+		ta, tb = tb, ta
+	}
+	inB := make(map[string]bool, len(tb))
+	for _, w := range tb {
+		inB[w] = true
+	}
+	shared := 0
+	for _, w := range ta {
+		if inB[w] {
+			shared++
+		}
+	}
+	if shared == len(ta) {
+		return true
+	}
+
+	// Otherwise allow for typos
+	return similarity(strings.Join(ta, " "), strings.Join(tb, " ")) >= duplicateTitleSimilarity
+}
+
+// titleWords lower-cases s and splits it into words, dropping punctuation.
+func titleWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// similarity is 1 minus the edit distance between a and b relative to the longer one.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	n := max(len(ra), len(rb))
+	if n == 0 {
+		return 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return 1 - float64(prev[len(rb)])/float64(n)
+}
@@ -4,15 +4,49 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"pical/database/schemas"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func (s *Server) getEvents(w http.ResponseWriter, r *http.Request) {
-	limit := parseIntQuery(r, "limit", 50, 1, 200)
-	offset := parseIntQuery(r, "offset", 0, 0, 1_000_000)
+	limit, offset := s.parsePage(r)
 
-	items, total, err := schemas.ListEvents(r.Context(), s.DB, limit, offset)
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	sort := schemas.EventSort(r.URL.Query().Get("sort"))
+	if err := sort.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Has("cursor") {
+		if q.Has("offset") {
+			http.Error(w, "cursor and offset can't be used together", http.StatusBadRequest)
+			return
+		}
+		var after *schemas.EventCursor
+		if v := q.Get("cursor"); v != "" {
+			c, err := decodeEventCursor(v, sort)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			after = &c
+		}
+		s.getEventsAfter(w, r, filter, sort, after, limit)
+		return
+	}
+
+	items, total, err := s.Events.ListEvents(r.Context(), filter, sort, limit, offset)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -25,10 +59,84 @@ func (s *Server) getEvents(w http.ResponseWriter, r *http.Request) {
 		Count:  len(items),
 		Total:  total,
 	}
+	if len(items) > 0 && offset+len(items) < total {
+		resp.NextCursor = encodeEventCursor(sort, sort.CursorFor(items[len(items)-1]))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// getEventsAfter is the keyset form of getEvents: the page starts after
+// the event the cursor marks, so events created or deleted on earlier pages
+// don't shift it the way they shift an offset.
+func (s *Server) getEventsAfter(w http.ResponseWriter, r *http.Request, filter schemas.EventFilter, sort schemas.EventSort, after *schemas.EventCursor, limit int) {
+	// One more than the page, to tell whether there is another
+	items, total, err := s.Events.ListEventsAfter(r.Context(), filter, sort, after, limit+1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := PagedResponse[schemas.Event]{Limit: limit, Total: total}
+	if len(items) > limit {
+		items = items[:limit]
+		resp.NextCursor = encodeEventCursor(sort, sort.CursorFor(items[len(items)-1]))
+	}
+	resp.Items, resp.Count = items, len(items)
 
 	writeJSON(w, http.StatusOK, resp)
 }
 
+// parseEventFilter reads the listing filters shared by event endpoints:
+// person, calendar, tag, allDay, recurring, createdAfter, createdBefore,
+// updatedAfter, updatedBefore and q.
+func parseEventFilter(r *http.Request) (schemas.EventFilter, error) {
+	q := r.URL.Query()
+	filter := schemas.EventFilter{
+		CalendarID: q.Get("calendar"),
+		PersonID:   q.Get("person"),
+		Tag:        q.Get("tag"),
+		Search:     strings.TrimSpace(q.Get("q")),
+	}
+	for key, v := range map[string]string{"calendar": filter.CalendarID, "person": filter.PersonID} {
+		if v != "" && !isUUID(v) {
+			return schemas.EventFilter{}, invalidIDError{param: key, value: v}
+		}
+	}
+
+	for key, dst := range map[string]**bool{"allDay": &filter.AllDay, "recurring": &filter.Recurring, "focus": &filter.Focus} {
+		v := q.Get(key)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return schemas.EventFilter{}, fmt.Errorf("%s must be true or false", key)
+		}
+		*dst = &b
+	}
+
+	bounds := map[string]**time.Time{
+		"createdAfter":  &filter.CreatedAfter,
+		"createdBefore": &filter.CreatedBefore,
+		"updatedAfter":  &filter.UpdatedAfter,
+		"updatedBefore": &filter.UpdatedBefore,
+	}
+	for key, dst := range bounds {
+		v := q.Get(key)
+		if v == "" {
+			continue
+		}
+		t, err := parseRangeBound(v, time.UTC, false)
+		if err != nil {
+			return schemas.EventFilter{}, fmt.Errorf("%s %v", key, err)
+		}
+		*dst = &t
+	}
+
+	return filter, nil
+}
+
 func (s *Server) createEvent(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
@@ -38,33 +146,201 @@ func (s *Server) createEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	created, err := schemas.CreateEvent(r.Context(), s.DB, in)
+	if err := schemas.ValidateEvent(in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	personID, err := s.Events.ResolvePersonID(r.Context(), in.PersonID, in.PersonName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in.PersonID = personID
+
+	// The same appointment often gets entered by two people
+	warnings, err := s.findDuplicates(r.Context(), in, parseBoolQuery(r, "conflicts"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(warnings) > 0 && parseBoolQuery(r, "strict") {
+		writeJSON(w, http.StatusConflict, DuplicateConflict{Error: overlapError(warnings), Warnings: warnings})
+		return
+	}
+
+	created, err := s.Events.CreateEvent(r.Context(), in)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	writeJSON(w, http.StatusCreated, created)
+	s.emitChange(r.Context(), schemas.WebhookEventCreated, created)
+
+	warnings = append(warnings, dstWarnings(created, time.Now())...)
+	w.Header().Set("ETag", eventETag(created))
+	writeJSON(w, http.StatusCreated, CreatedEvent{Event: created, Warnings: warnings})
+}
+
+// eventETag is the ETag an event is served with, its version.
+func eventETag(e schemas.Event) string {
+	return `"` + strconv.Itoa(e.Version) + `"`
 }
 
+// ifMatchVersion reads If-Match as the event version a write is made
+// against: 0 for "*", which any version satisfies, and -1, which none does,
+// for tags this server never hands out. ok is false without the header.
+func ifMatchVersion(r *http.Request) (version int, ok bool) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	if v == "" {
+		return 0, false
+	}
+	if v == "*" {
+		return 0, true
+	}
+	quoted, found := strings.CutPrefix(v, `"`)
+	quoted, closed := strings.CutSuffix(quoted, `"`)
+	n, err := strconv.Atoi(quoted)
+	if !found || !closed || err != nil || n <= 0 {
+		return -1, true
+	}
+	return n, true
+}
+
+// upsertExternalEvent is an idempotent create-or-replace keyed by another
+// system's ID, so scripts can push the same event repeatedly without keeping
+// track of what they already sent.
+func (s *Server) upsertExternalEvent(w http.ResponseWriter, r *http.Request, source, uid string) {
+	defer r.Body.Close()
+
+	var in schemas.Event
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := schemas.ValidateEvent(in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	personID, err := s.Events.ResolvePersonID(r.Context(), in.PersonID, in.PersonName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in.PersonID = personID
+
+	// Optional here, since pushing blind is the point; a body's version is
+	// not a precondition
+	in.Version, _ = ifMatchVersion(r)
+
+	out, created, err := s.Events.UpsertExternalEvent(r.Context(), source, uid, in)
+	if err != nil {
+		if errors.Is(err, schemas.ErrVersionMismatch) {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		s.emitChange(r.Context(), schemas.WebhookEventCreated, out)
+	} else {
+		s.notifyEventChanged(r.Context(), out, "was updated")
+		s.emitChange(r.Context(), schemas.WebhookEventUpdated, out)
+	}
+	w.Header().Set("ETag", eventETag(out))
+	writeJSON(w, status, out)
+}
+
+// deleteEvent moves an event to the trash, or with ?permanent=true deletes
+// it for good, from the trash or not. If-Match is optional; when sent, a
+// stale version gets 412 so a client can't delete an event it hasn't seen
+// the latest of.
 func (s *Server) deleteEvent(w http.ResponseWriter, r *http.Request, id string) {
+	version, _ := ifMatchVersion(r)
+	permanent := parseBoolQuery(r, "permanent")
+
+	existing, err := s.Events.GetEvent(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) && permanent {
+		existing, err = s.Events.GetTrashedEvent(r.Context(), id)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if existing.FeedID != nil {
+		http.Error(w, "event belongs to a subscribed feed and is read-only", http.StatusConflict)
+		return
+	}
+
+	if !permanent {
+		s.trashEvent(w, r, *existing, version)
+		return
+	}
+
+	// The rows cascade with the event; the files have to go by hand
+	attachments, err := s.Events.ListEventAttachments(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	err := schemas.DeleteEvent(r.Context(), s.DB, id)
+	err = s.Events.PurgeEvent(r.Context(), id, version)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			http.Error(w, "event not found", http.StatusNotFound)
 			return
 		}
+		if errors.Is(err, schemas.ErrVersionMismatch) {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, a := range attachments {
+		s.removeAttachmentFile(a.AttachmentID)
+	}
+	// Already announced when it went into the trash
+	if existing.DeletedAt == nil {
+		s.notifyEventChanged(r.Context(), *existing, "was deleted")
+		s.emitChange(r.Context(), schemas.WebhookEventDeleted, *existing)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) trashEvent(w http.ResponseWriter, r *http.Request, e schemas.Event, version int) {
+	if err := s.Events.DeleteEvent(r.Context(), e.EventID, version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, schemas.ErrVersionMismatch) {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	s.notifyEventChanged(r.Context(), e, "was deleted")
+	s.emitChange(r.Context(), schemas.WebhookEventDeleted, e)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (s *Server) getEvent(w http.ResponseWriter, r *http.Request, id string) {
 
-	out, err := schemas.GetEvent(r.Context(), s.DB, id)
+	out, err := s.Events.GetEvent(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			http.Error(w, "event not found", http.StatusNotFound)
@@ -74,5 +350,101 @@ func (s *Server) getEvent(w http.ResponseWriter, r *http.Request, id string) {
 		return
 	}
 
-	writeJSON(w, http.StatusCreated, out)
+	w.Header().Set("ETag", eventETag(*out))
+	writeJSON(w, http.StatusOK, out)
+}
+
+// maxBatchIDs caps GET /events?ids=, keeping the URL and the query small.
+const maxBatchIDs = 100
+
+// maxBatchDelete caps how many events one DELETE /events may move to
+// the trash.
+const maxBatchDelete = 1000
+
+// getEventsByID resolves several event IDs in one request. Events that don't
+// exist are left out, so the caller can tell which are gone.
+func (s *Server) getEventsByID(w http.ResponseWriter, r *http.Request) {
+	ids := make([]string, 0)
+	for _, v := range r.URL.Query()["ids"] {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		http.Error(w, "ids is required", http.StatusBadRequest)
+		return
+	}
+	if len(ids) > maxBatchIDs {
+		http.Error(w, fmt.Sprintf("at most %d ids per request", maxBatchIDs), http.StatusBadRequest)
+		return
+	}
+	for _, id := range ids {
+		if !validID(w, "ids", id) {
+			return
+		}
+	}
+
+	events, err := s.Events.GetEvents(r.Context(), ids)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, events)
+}
+
+// deleteEvents moves many events to the trash at once, such as everything a
+// bad import created: the events matching the GET /events filters in the
+// query, narrowed to the body's eventIds when it lists any. Either the
+// filters or the IDs have to say something, so a bare DELETE can't empty
+// the calendar. It is all or nothing, and refused when more than
+// maxBatchDelete events match. ?dryRun=true reports what would go.
+func (s *Server) deleteEvents(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in BatchDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if len(in.EventIDs) == 0 && filter == (schemas.EventFilter{}) {
+		http.Error(w, "eventIds or a filter is required", http.StatusBadRequest)
+		return
+	}
+	if len(in.EventIDs) > maxBatchDelete {
+		http.Error(w, fmt.Sprintf("at most %d eventIds per request", maxBatchDelete), http.StatusBadRequest)
+		return
+	}
+	for _, id := range in.EventIDs {
+		if !validID(w, "eventIds", id) {
+			return
+		}
+	}
+
+	dryRun := parseBoolQuery(r, "dryRun")
+	events, err := s.Events.TrashEvents(r.Context(), filter, in.EventIDs, maxBatchDelete, dryRun)
+	if err != nil {
+		if errors.Is(err, schemas.ErrTooManyEvents) {
+			http.Error(w, fmt.Sprintf("more than %d events match; narrow the filters", maxBatchDelete), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// No pushes: one per event would bury whoever they belong to
+	if !dryRun {
+		for _, e := range events {
+			s.emitChange(r.Context(), schemas.WebhookEventDeleted, e)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, BatchDeleteReport{Deleted: len(events), Events: events, DryRun: dryRun})
 }
@@ -0,0 +1,397 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"pical/database/schemas"
+	"pical/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Pushes and webhooks have no database to look themselves up in here
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestServer is a server whose events live in memory, with no database
+// behind anything else.
+func newTestServer(t *testing.T) (http.Handler, *store.Memory) {
+	t.Helper()
+	s, mem := newTestServerFields(t)
+	return s.Handler(), mem
+}
+
+// newTestServerFields is newTestServer before it is wrapped in its
+// middleware, for tests that set fields of their own first.
+func newTestServerFields(t *testing.T) (*Server, *store.Memory) {
+	t.Helper()
+	mem := store.NewMemory()
+	s := &Server{
+		Events: mem,
+		Mux:    http.NewServeMux(),
+		Fs:     http.NotFoundHandler(),
+		Config: Config{APIPrefix: "/api/v1"},
+
+		webhookWake: make(chan struct{}, 1),
+		realtime:    newRealtimeHub(),
+	}
+	s.routes()
+	if err := s.checkAPISpec(apiOperations); err != nil {
+		t.Fatal(err)
+	}
+	return s, mem
+}
+
+func serve(h http.Handler, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// createTestEvent posts an event for Ann and returns it as created.
+func createTestEvent(t *testing.T, h http.Handler) schemas.Event {
+	t.Helper()
+	w := serve(h, "POST", "/api/v1/events", `{"personName": "Ann", "title": "Dentist", "timezone": "Europe/London",
+		"startTime": "2030-05-01T09:00:00+01:00", "endTime": "2030-05-01T09:30:00+01:00"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	var out CreatedEvent
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	return out.Event
+}
+
+func TestCreateEvent(t *testing.T) {
+	h, mem := newTestServer(t)
+	ann := mem.AddPerson("Ann")
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"by person name", `{"personName": "ann", "title": "Swim", "timezone": "UTC", "startTime": "2030-05-01T17:00:00Z"}`, http.StatusCreated},
+		{"by person ID", `{"personId": "` + ann + `", "title": "Swim", "timezone": "UTC", "startTime": "2030-05-02T17:00:00Z"}`, http.StatusCreated},
+		{"unknown person", `{"personName": "Bob", "title": "Swim", "timezone": "UTC", "startTime": "2030-05-01T17:00:00Z"}`, http.StatusBadRequest},
+		{"no title", `{"personName": "Ann", "timezone": "UTC", "startTime": "2030-05-01T17:00:00Z"}`, http.StatusBadRequest},
+		{"unknown timezone", `{"personName": "Ann", "title": "Swim", "timezone": "Mars/Base", "startTime": "2030-05-01T17:00:00Z"}`, http.StatusBadRequest},
+		{"ends before it starts", `{"personName": "Ann", "title": "Swim", "timezone": "UTC", "startTime": "2030-05-01T17:00:00Z", "endTime": "2030-05-01T16:00:00Z"}`, http.StatusBadRequest},
+		{"not JSON", `{`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, "POST", "/api/v1/events", tt.body, nil)
+			if w.Code != tt.status {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			if tt.status != http.StatusCreated {
+				return
+			}
+			var out CreatedEvent
+			if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.EventID == "" || out.PersonID != ann || out.PersonName != "Ann" || out.Version != 1 {
+				t.Errorf("created %+v", out.Event)
+			}
+			if got := w.Header().Get("ETag"); got != `"1"` {
+				t.Errorf("ETag %q, want %q", got, `"1"`)
+			}
+		})
+	}
+}
+
+func TestCreateEventStrictDuplicate(t *testing.T) {
+	h, mem := newTestServer(t)
+	mem.AddPerson("Ann")
+	createTestEvent(t, h)
+
+	body := `{"personName": "Ann", "title": "Dentist", "timezone": "Europe/London", "startTime": "2030-05-01T09:15:00+01:00"}`
+	if w := serve(h, "POST", "/api/v1/events?strict=true", body, nil); w.Code != http.StatusConflict {
+		t.Fatalf("strict duplicate: got %d %s, want 409", w.Code, w.Body)
+	}
+	w := serve(h, "POST", "/api/v1/events", body, nil)
+	var out CreatedEvent
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("duplicate: got %d %s", w.Code, w.Body)
+	}
+	if len(out.Warnings) != 1 || out.Warnings[0].Type != "duplicate" {
+		t.Errorf("warnings %+v, want one duplicate", out.Warnings)
+	}
+}
+
+func TestGetEvent(t *testing.T) {
+	h, mem := newTestServer(t)
+	mem.AddPerson("Ann")
+	created := createTestEvent(t, h)
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"found", "/api/v1/events/" + created.EventID, http.StatusOK},
+		{"deprecated path", "/events/" + created.EventID, http.StatusOK},
+		{"missing", "/api/v1/events/00000000-0000-4000-8000-000000000000", http.StatusNotFound},
+		{"not an ID", "/api/v1/events/tuesday", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, "GET", tt.path, "", nil)
+			if w.Code != tt.status {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var out schemas.Event
+			if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.EventID != created.EventID || out.Title != "Dentist" || !out.StartTime.Equal(created.StartTime) {
+				t.Errorf("got %+v, want %+v", out, created)
+			}
+			if got := w.Header().Get("ETag"); got != `"1"` {
+				t.Errorf("ETag %q, want %q", got, `"1"`)
+			}
+		})
+	}
+}
+
+func TestDeleteEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		status  int
+		// Whether the event is still there afterwards
+		kept bool
+	}{
+		{"no If-Match", "", http.StatusNoContent, false},
+		{"stale version", `"2"`, http.StatusPreconditionFailed, true},
+		{"tag never handed out", `W/"abc"`, http.StatusPreconditionFailed, true},
+		{"current version", `"1"`, http.StatusNoContent, false},
+		{"any version", "*", http.StatusNoContent, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mem := newTestServer(t)
+			mem.AddPerson("Ann")
+			e := createTestEvent(t, h)
+			path := "/api/v1/events/" + e.EventID
+
+			header := http.Header{}
+			if tt.ifMatch != "" {
+				header.Set("If-Match", tt.ifMatch)
+			}
+			if w := serve(h, "DELETE", path, "", header); w.Code != tt.status {
+				t.Fatalf("delete: got %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+
+			w := serve(h, "GET", path, "", nil)
+			if kept := w.Code == http.StatusOK; kept != tt.kept {
+				t.Fatalf("after delete: GET answered %d", w.Code)
+			}
+			if tt.kept {
+				return
+			}
+			trashed, err := mem.GetTrashedEvent(t.Context(), e.EventID)
+			if err != nil {
+				t.Fatalf("not in the trash: %v", err)
+			}
+			if trashed.Version != 2 {
+				t.Errorf("trashed at version %d, want 2", trashed.Version)
+			}
+			if w := serve(h, "DELETE", path, "", http.Header{"If-Match": {"*"}}); w.Code != http.StatusNotFound {
+				t.Errorf("deleting again: got %d, want 404", w.Code)
+			}
+		})
+	}
+}
+
+func TestSplitSeriesStaleVersion(t *testing.T) {
+	h, mem := newTestServer(t)
+	mem.AddPerson("Ann")
+	w := serve(h, "POST", "/api/v1/events", `{"personName": "Ann", "title": "Swim", "timezone": "UTC",
+		"startTime": "2030-05-01T17:00:00Z", "rrule": "FREQ=WEEKLY"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	var e CreatedEvent
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	w = serve(h, "POST", "/api/v1/events/"+e.EventID+"/split", `{"recurrenceId": "2030-05-15T17:00:00Z"}`, http.Header{"If-Match": {`"2"`}})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("got %d %s, want 412", w.Code, w.Body)
+	}
+	if got, err := mem.GetEvent(t.Context(), e.EventID); err != nil || got.Version != 1 || *got.Rrule != "FREQ=WEEKLY" {
+		t.Fatalf("series changed despite the stale version: %+v, %v", got, err)
+	}
+}
+
+func TestPurgeEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		trashed bool
+		status  int
+	}{
+		{"live event", `"1"`, false, http.StatusNoContent},
+		{"from the trash", `"2"`, true, http.StatusNoContent},
+		{"stale version", `"1"`, true, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mem := newTestServer(t)
+			mem.AddPerson("Ann")
+			e := createTestEvent(t, h)
+			path := "/api/v1/events/" + e.EventID
+			if tt.trashed {
+				if w := serve(h, "DELETE", path, "", http.Header{"If-Match": {"*"}}); w.Code != http.StatusNoContent {
+					t.Fatalf("trash: %d %s", w.Code, w.Body)
+				}
+			}
+
+			w := serve(h, "DELETE", path+"?permanent=true", "", http.Header{"If-Match": {tt.ifMatch}})
+			if w.Code != tt.status {
+				t.Fatalf("purge: got %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			_, err := mem.GetTrashedEvent(t.Context(), e.EventID)
+			if gone := err != nil; gone != (tt.status == http.StatusNoContent) {
+				t.Fatalf("after purge: trashed lookup gave %v", err)
+			}
+			if w := serve(h, "GET", path, "", nil); w.Code != http.StatusNotFound {
+				t.Errorf("after purge: GET answered %d, want 404", w.Code)
+			}
+		})
+	}
+}
+
+func TestDeleteEvents(t *testing.T) {
+	h, mem := newTestServer(t)
+	ann := mem.AddPerson("Ann")
+	bob := mem.AddPerson("Bob")
+	create := func(person string) string {
+		t.Helper()
+		e, err := mem.CreateEvent(t.Context(), schemas.Event{PersonID: person, Title: "Swim", Timezone: "UTC", StartTime: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e.EventID
+	}
+	ann1, ann2, bob1 := create(ann), create(ann), create(bob)
+	trashed := func(id string) bool {
+		_, err := mem.GetTrashedEvent(t.Context(), id)
+		return err == nil
+	}
+	deleteEvents := func(t *testing.T, query, body string) BatchDeleteReport {
+		t.Helper()
+		w := serve(h, "DELETE", "/api/v1/events"+query, body, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d %s, want 200", w.Code, w.Body)
+		}
+		var out BatchDeleteReport
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	t.Run("nothing to go by", func(t *testing.T) {
+		if w := serve(h, "DELETE", "/api/v1/events", "", nil); w.Code != http.StatusBadRequest {
+			t.Fatalf("got %d %s, want 400", w.Code, w.Body)
+		}
+	})
+	t.Run("dry run", func(t *testing.T) {
+		out := deleteEvents(t, "?person="+ann+"&dryRun=true", "")
+		if !out.DryRun || out.Deleted != 2 {
+			t.Fatalf("got %+v, want a dry run of Ann's 2 events", out)
+		}
+		if trashed(ann1) || trashed(ann2) {
+			t.Fatal("a dry run moved events to the trash")
+		}
+	})
+	t.Run("IDs narrow the filter", func(t *testing.T) {
+		out := deleteEvents(t, "?person="+ann, `{"eventIds": ["`+ann1+`", "`+bob1+`"]}`)
+		if out.Deleted != 1 || out.Events[0].EventID != ann1 {
+			t.Fatalf("got %+v, want only Ann's first event", out)
+		}
+		if !trashed(ann1) || trashed(ann2) || trashed(bob1) {
+			t.Fatal("wrong events in the trash")
+		}
+	})
+	t.Run("IDs alone", func(t *testing.T) {
+		out := deleteEvents(t, "", `{"eventIds": ["`+bob1+`"]}`)
+		if out.Deleted != 1 || !trashed(bob1) {
+			t.Fatalf("got %+v, want Bob's event in the trash", out)
+		}
+	})
+	t.Run("more than maxBatchDelete", func(t *testing.T) {
+		for i := 0; i < maxBatchDelete; i++ {
+			create(ann)
+		}
+		w := serve(h, "DELETE", "/api/v1/events?person="+ann, "", nil)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("got %d %s, want 400", w.Code, w.Body)
+		}
+		if trashed(ann2) {
+			t.Fatal("events moved to the trash despite the refusal")
+		}
+	})
+}
+
+func TestEventsInvalidIDs(t *testing.T) {
+	h, mem := newTestServer(t)
+	mem.AddPerson("Ann")
+	e := createTestEvent(t, h)
+
+	tests := []struct {
+		name  string
+		path  string
+		param string
+		value string
+	}{
+		{"calendar filter", "/api/v1/events?calendar=kitchen", "calendar", "kitchen"},
+		{"person filter", "/api/v1/events?person=ann", "person", "ann"},
+		{"agenda person filter", "/api/v1/agenda?person=ann", "person", "ann"},
+		{"batch ids", "/api/v1/events?ids=" + e.EventID + ",tuesday", "ids", "tuesday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, "GET", tt.path, "", nil)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("got %d %s, want 400", w.Code, w.Body)
+			}
+			var out InvalidID
+			if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+				t.Fatalf("body %s: %v", w.Body, err)
+			}
+			if out.Param != tt.param || out.Value != tt.value {
+				t.Errorf("got %+v, want param %q value %q", out, tt.param, tt.value)
+			}
+		})
+	}
+
+	w := serve(h, "GET", "/api/v1/events?ids="+e.EventID, "", nil)
+	var got []schemas.Event
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("batch: got %d %s", w.Code, w.Body)
+	}
+	if len(got) != 1 || got[0].EventID != e.EventID {
+		t.Errorf("batch: got %+v", got)
+	}
+}
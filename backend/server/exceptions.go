@@ -0,0 +1,310 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pical/database/schemas"
+	"pical/recurrence"
+	"time"
+)
+
+// maxBulkOccurrences caps how many exceptions one bulk call may write.
+const maxBulkOccurrences = 1000
+
+// bulkExceptions cancels or moves every occurrence of an event in a range,
+// writing all of the exceptions in one transaction. With ?dryRun=true it
+// reports the exceptions it would write and changes nothing.
+func (s *Server) bulkExceptions(w http.ResponseWriter, r *http.Request, id string, kind schemas.ExceptionType) {
+	defer r.Body.Close()
+
+	var in BulkExceptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	e, ok := s.editableSeries(w, r, id)
+	if !ok {
+		return
+	}
+
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	from, err := parseRangeBound(in.From, loc, false)
+	if err != nil {
+		http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseRangeBound(in.To, loc, true)
+	if err != nil {
+		http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !to.After(from) {
+		http.Error(w, "to must be after from", http.StatusBadRequest)
+		return
+	}
+
+	var clock *time.Time
+	if kind == schemas.ExceptionMove {
+		if in.ShiftDays == 0 && in.ShiftMinutes == 0 && in.Time == "" {
+			http.Error(w, "move needs shiftDays, shiftMinutes or time", http.StatusBadRequest)
+			return
+		}
+		if e.AllDay && (in.ShiftMinutes != 0 || in.Time != "") {
+			http.Error(w, "all-day events can only be shifted by whole days", http.StatusBadRequest)
+			return
+		}
+		if in.Time != "" {
+			t, err := time.Parse("15:04", in.Time)
+			if err != nil {
+				http.Error(w, "time must be HH:MM", http.StatusBadRequest)
+				return
+			}
+			clock = &t
+		}
+	}
+
+	exs, err := schemas.ListEventExceptions(r.Context(), s.DB, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]schemas.Exception, 0)
+	for _, occ := range recurrence.Expand(*e, exs, from, to) {
+		// Expand also returns occurrences that started earlier and are still running
+		if occ.StartTime.Before(from) {
+			continue
+		}
+
+		ex := schemas.Exception{EventID: id, RecurrenceID: occ.RecurrenceID, Kind: kind}
+		if kind == schemas.ExceptionMove {
+			start := shiftWallClock(occ.StartTime.In(loc), in.ShiftDays, in.ShiftMinutes, clock)
+			ex.NewStart = &start
+			if occ.EndTime != nil {
+				end := start.Add(occ.EndTime.Sub(occ.StartTime))
+				if e.AllDay {
+					end = occ.EndTime.In(loc).AddDate(0, 0, in.ShiftDays)
+				}
+				ex.NewEnd = &end
+			}
+		}
+		out = append(out, ex)
+	}
+	if len(out) > maxBulkOccurrences {
+		http.Error(w, fmt.Sprintf("range covers more than %d occurrences", maxBulkOccurrences), http.StatusBadRequest)
+		return
+	}
+
+	dryRun := parseBoolQuery(r, "dryRun")
+	if err := schemas.UpsertExceptions(r.Context(), s.DB, out, dryRun); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(out) > 0 && !dryRun {
+		verb := "cancelled"
+		if kind == schemas.ExceptionMove {
+			verb = "moved"
+		}
+		s.notifyEventChanged(r.Context(), *e, fmt.Sprintf("had %d occurrences %s", len(out), verb))
+		s.emitChange(r.Context(), schemas.WebhookExceptionAdded, *e, out...)
+	}
+
+	writeJSON(w, http.StatusOK, BulkExceptionReport{Event: *e, Affected: len(out), Exceptions: out, DryRun: dryRun})
+}
+
+// editableSeries loads a recurring event whose exceptions may be changed,
+// writing 404, 409 (feed events) or 400 (one-off events) otherwise.
+func (s *Server) editableSeries(w http.ResponseWriter, r *http.Request, id string) (*schemas.Event, bool) {
+	e, err := s.Events.GetEvent(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if e.FeedID != nil {
+		http.Error(w, "event belongs to a subscribed feed and is read-only", http.StatusConflict)
+		return nil, false
+	}
+	if !recurrence.Recurs(*e) {
+		http.Error(w, "event does not recur", http.StatusBadRequest)
+		return nil, false
+	}
+	return e, true
+}
+
+func (s *Server) getEventExceptions(w http.ResponseWriter, r *http.Request, id string) {
+	if _, err := s.Events.GetEvent(r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	exs, err := schemas.ListEventExceptions(r.Context(), s.DB, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	limit, offset := s.parsePage(r)
+	writeJSON(w, http.StatusOK, pageOf(exs, limit, offset))
+}
+
+// createEventException cancels or moves a single occurrence, replacing any
+// exception it already had. The recurrenceId must be one of the series' starts.
+func (s *Server) createEventException(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var in schemas.Exception
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	e, ok := s.editableSeries(w, r, id)
+	if !ok {
+		return
+	}
+
+	if in.RecurrenceID.IsZero() {
+		http.Error(w, "recurrenceId is required", http.StatusBadRequest)
+		return
+	}
+	if !occursAt(*e, in.RecurrenceID) {
+		http.Error(w, "recurrenceId is not an occurrence of the series", http.StatusBadRequest)
+		return
+	}
+
+	switch in.Kind {
+	case schemas.ExceptionCancel:
+		in.NewStart, in.NewEnd = nil, nil
+	case schemas.ExceptionMove:
+		if in.NewStart == nil {
+			http.Error(w, "newStart is required for a move", http.StatusBadRequest)
+			return
+		}
+		if in.NewEnd != nil && in.NewEnd.Before(*in.NewStart) {
+			http.Error(w, "newEnd must not be before newStart", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("kind must be %d (cancel) or %d (move)", schemas.ExceptionCancel, schemas.ExceptionMove), http.StatusBadRequest)
+		return
+	}
+	in.EventID = id
+
+	warnings := make([]EventWarning, 0)
+	if in.Kind == schemas.ExceptionMove {
+		end := in.NewEnd
+		if end == nil && e.EndTime != nil {
+			moved := in.NewStart.Add(e.EndTime.Sub(e.StartTime))
+			end = &moved
+		}
+		var err error
+		warnings, err = s.findOverlaps(r.Context(), e.PersonID, e.Title, e.EventID, *in.NewStart, end, parseBoolQuery(r, "conflicts"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(warnings) > 0 && parseBoolQuery(r, "strict") {
+			writeJSON(w, http.StatusConflict, DuplicateConflict{Error: overlapError(warnings), Warnings: warnings})
+			return
+		}
+	}
+
+	if err := schemas.UpsertException(r.Context(), s.DB, in); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if in.Kind == schemas.ExceptionMove {
+		s.notifyEventChanged(r.Context(), *e, fmt.Sprintf("on %s was moved to %s", occurrenceDay(*e, in.RecurrenceID), occurrenceDay(*e, *in.NewStart)))
+	} else {
+		s.notifyEventChanged(r.Context(), *e, fmt.Sprintf("on %s was cancelled", occurrenceDay(*e, in.RecurrenceID)))
+	}
+	s.emitChange(r.Context(), schemas.WebhookExceptionAdded, *e, in)
+
+	writeJSON(w, http.StatusCreated, CreatedException{Exception: in, Warnings: warnings})
+}
+
+// deleteEventException restores an occurrence to what the rule says.
+func (s *Server) deleteEventException(w http.ResponseWriter, r *http.Request, id, recurrenceID string) {
+	rid, err := time.Parse(time.RFC3339, recurrenceID)
+	if err != nil {
+		http.Error(w, "recurrenceId must be an RFC 3339 time", http.StatusBadRequest)
+		return
+	}
+
+	e, ok := s.editableSeries(w, r, id)
+	if !ok {
+		return
+	}
+
+	if err := schemas.DeleteException(r.Context(), s.DB, id, rid); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "exception not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.notifyEventChanged(r.Context(), *e, fmt.Sprintf("on %s is back on as usual", occurrenceDay(*e, rid)))
+	s.emitChange(r.Context(), schemas.WebhookEventUpdated, *e)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// occursAt reports whether the series' rule produces an occurrence starting at t.
+func occursAt(e schemas.Event, t time.Time) bool {
+	rule, err := recurrence.Parse(*e.Rrule)
+	if err != nil {
+		return false
+	}
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	starts := rule.Between(e.StartTime.In(loc), t, t.Add(time.Second))
+	return len(starts) > 0 && starts[0].Equal(t)
+}
+
+// parseRangeBound reads an RFC 3339 timestamp or a plain date in loc. An
+// inclusive date bound points at the end of that day.
+func parseRangeBound(v string, loc *time.Location, inclusive bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, fmt.Errorf("required")
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseInLocation("2006-01-02", v, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must be a date (2006-01-02) or RFC 3339 time")
+	}
+	if inclusive {
+		d = d.AddDate(0, 0, 1)
+	}
+	return d, nil
+}
+
+// shiftWallClock moves t by whole days and minutes of local time, optionally
+// setting its time of day first, so a shift doesn't drift across DST changes.
+func shiftWallClock(t time.Time, days, minutes int, clock *time.Time) time.Time {
+	h, m := t.Hour(), t.Minute()
+	if clock != nil {
+		h, m = clock.Hour(), clock.Minute()
+	}
+	shifted, _ := recurrence.ResolveLocal(t.Year(), t.Month(), t.Day()+days, h, m+minutes, 0, t.Location())
+	return shifted
+}
@@ -0,0 +1,33 @@
+//go:build !minimal
+
+package server
+
+import (
+	"context"
+	"pical/backups"
+
+	// Registers sftp:// targets
+	_ "pical/backups/sftp"
+)
+
+func init() {
+	registerFeature("backups", func(s *Server) error {
+		if s.Config.BackupTarget.URL == "" {
+			return nil
+		}
+		cfg := s.Config.BackupTarget
+		cfg.Key = s.Config.BackupKey
+		target, err := backups.New(cfg)
+		if err != nil {
+			return err
+		}
+		s.backups = target
+		return nil
+	}, func(ctx context.Context, s *Server) {
+		// Targets can be added under /admin/backup-targets, so this runs
+		// even without BACKUP_TARGET
+		if s.Config.BackupInterval > 0 {
+			go s.runBackups(ctx)
+		}
+	})
+}
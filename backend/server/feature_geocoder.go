@@ -0,0 +1,21 @@
+//go:build !minimal
+
+package server
+
+import (
+	"context"
+	"pical/geocode"
+)
+
+func init() {
+	registerFeature("geocoder", func(s *Server) error {
+		if s.Config.GeocoderURL != "" {
+			s.geocoder = geocode.NewNominatim(s.Config.GeocoderURL)
+		}
+		return nil
+	}, func(ctx context.Context, s *Server) {
+		if s.geocoder != nil {
+			go s.runGeocoder(ctx)
+		}
+	})
+}
@@ -0,0 +1,21 @@
+//go:build !minimal
+
+package server
+
+import (
+	"context"
+	"pical/integrations/google"
+)
+
+func init() {
+	registerFeature(google.ProviderName, func(s *Server) error {
+		if s.Config.GoogleClientID != "" {
+			s.providers[google.ProviderName] = google.New(s.Config.GoogleClientID, s.Config.GoogleClientSecret, s.Config.GoogleRedirectURL)
+		}
+		return nil
+	}, func(ctx context.Context, s *Server) {
+		if p, ok := s.providers[google.ProviderName]; ok && s.Config.GoogleSyncInterval > 0 {
+			go s.runSync(ctx, p, s.Config.GoogleSyncInterval)
+		}
+	})
+}
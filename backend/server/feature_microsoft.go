@@ -0,0 +1,21 @@
+//go:build !minimal
+
+package server
+
+import (
+	"context"
+	"pical/integrations/microsoft"
+)
+
+func init() {
+	registerFeature(microsoft.ProviderName, func(s *Server) error {
+		if s.Config.MicrosoftClientID != "" {
+			s.providers[microsoft.ProviderName] = microsoft.New(s.Config.MicrosoftClientID, s.Config.MicrosoftTenant)
+		}
+		return nil
+	}, func(ctx context.Context, s *Server) {
+		if p, ok := s.providers[microsoft.ProviderName]; ok && s.Config.MicrosoftSyncInterval > 0 {
+			go s.runSync(ctx, p, s.Config.MicrosoftSyncInterval)
+		}
+	})
+}
@@ -0,0 +1,25 @@
+//go:build !minimal
+
+package server
+
+import (
+	"errors"
+	"pical/travel"
+)
+
+func init() {
+	registerFeature("travel", func(s *Server) error {
+		if s.Config.Travel.Provider == "" {
+			return nil
+		}
+		if s.Config.TravelOrigin == (travel.Point{}) {
+			return errors.New("TRAVEL_ORIGIN is required with TRAVEL_PROVIDER, as trips are timed from there")
+		}
+		router, err := travel.New(s.Config.Travel)
+		if err != nil {
+			return err
+		}
+		s.router = router
+		return nil
+	}, nil)
+}
@@ -0,0 +1,19 @@
+//go:build !minimal
+
+package server
+
+import "pical/virusscan"
+
+func init() {
+	registerFeature("virusscan", func(s *Server) error {
+		if s.Config.AttachmentScanner == "" {
+			return nil
+		}
+		scanner, err := virusscan.New(s.Config.AttachmentScanner)
+		if err != nil {
+			return err
+		}
+		s.scanner = scanner
+		return nil
+	}, nil)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"fmt"
+)
+
+// Optional subsystems are wired up from their own feature_*.go files, which
+// register here from init. Building with -tags minimal leaves those files,
+// and with them the packages they pull in, out of the binary.
+
+// optionalFeature is a subsystem a minimal build goes without.
+type optionalFeature struct {
+	name string
+	// Whether the config asks for the feature; asking for one that wasn't
+	// compiled in stops the server from starting
+	configured func(cfg Config) bool
+	// Filled in by the feature's file when it is compiled in. setup runs
+	// before the database is initialised, start once the server is ready
+	setup func(s *Server) error
+	start func(ctx context.Context, s *Server)
+}
+
+var optionalFeatures = []*optionalFeature{
+	{name: "google", configured: func(cfg Config) bool { return cfg.GoogleClientID != "" }},
+	{name: "microsoft", configured: func(cfg Config) bool { return cfg.MicrosoftClientID != "" }},
+	{name: "geocoder", configured: func(cfg Config) bool { return cfg.GeocoderURL != "" }},
+	{name: "virusscan", configured: func(cfg Config) bool { return cfg.AttachmentScanner != "" }},
+	{name: "backups", configured: func(cfg Config) bool { return cfg.BackupTarget.URL != "" }},
+	{name: "travel", configured: func(cfg Config) bool { return cfg.Travel.Provider != "" }},
+}
+
+// registerFeature is called from init by each optional subsystem's file.
+func registerFeature(name string, setup func(s *Server) error, start func(ctx context.Context, s *Server)) {
+	for _, f := range optionalFeatures {
+		if f.name == name {
+			f.setup, f.start = setup, start
+			return
+		}
+	}
+	panic("unknown optional feature " + name)
+}
+
+// compiledIn reports whether the optional feature name is in this build.
+func compiledIn(name string) bool {
+	for _, f := range optionalFeatures {
+		if f.name == name {
+			return f.setup != nil
+		}
+	}
+	return false
+}
+
+func (s *Server) setupFeatures() error {
+	for _, f := range optionalFeatures {
+		if f.setup == nil {
+			if f.configured(s.Config) {
+				return fmt.Errorf("%s is configured, but this build was made without it", f.name)
+			}
+			continue
+		}
+		if err := f.setup(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) startFeatures(ctx context.Context) {
+	for _, f := range optionalFeatures {
+		if f.start != nil {
+			f.start(ctx, s)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pical/database/schemas"
+	"pical/ical"
+	"strings"
+	"time"
+)
+
+// newTokenSecret returns a random URL-safe secret, for a feed or API token,
+// and the hash stored for it.
+func newTokenSecret() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	return secret, hashTokenSecret(secret), nil
+}
+
+func hashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Server) getFeedTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := schemas.ListFeedTokens(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// createFeedToken issues a token. The secret is in the response only; it
+// can't be recovered later, just revoked and reissued.
+func (s *Server) createFeedToken(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in schemas.FeedToken
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	secret, hash, err := newTokenSecret()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	created, err := schemas.CreateFeedToken(r.Context(), s.DB, in, hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, FeedTokenIssued{
+		FeedToken: created,
+		Token:     secret,
+		URL:       "/api/published/" + secret + ".ics",
+	})
+}
+
+func (s *Server) deleteFeedToken(w http.ResponseWriter, r *http.Request, id string) {
+	err := schemas.DeleteFeedToken(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "feed token not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getPublishedICS serves the calendar a token is scoped to. Unknown, revoked
+// and expired tokens all look the same to the caller.
+func (s *Server) getPublishedICS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	secret := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/published/"), ".ics")
+	if secret == "" || strings.Contains(secret, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	token, err := schemas.UseFeedToken(r.Context(), s.DB, hashTokenSecret(secret), time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	events, err := schemas.ListAllEvents(r.Context(), s.DB, token.Filter())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	exceptions, err := schemas.ListAllExceptions(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]ical.Item, 0, len(events))
+	for _, e := range events {
+		items = append(items, ical.Item{Event: e, Exceptions: exceptions[e.EventID]})
+	}
+
+	writeICS(w, "calendar.ics", token.Name, items)
+}
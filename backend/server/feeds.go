@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"pical/database/schemas"
+	"pical/ical"
+	"strings"
+	"time"
+)
+
+const (
+	feedFetchTimeout = 30 * time.Second
+	maxFeedBytes     = 10 << 20
+)
+
+var feedClient = &http.Client{Timeout: feedFetchTimeout}
+
+func (s *Server) getFeeds(w http.ResponseWriter, r *http.Request) {
+	feeds, err := schemas.ListFeeds(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, feeds)
+}
+
+func (s *Server) createFeed(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in schemas.Feed
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	created, err := schemas.CreateFeed(r.Context(), s.DB, in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// First fetch happens in the background so a slow feed doesn't hold up the response
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), feedFetchTimeout+10*time.Second)
+		defer cancel()
+		if _, err := s.refreshFeed(ctx, created); err != nil {
+			log.Printf("feed %s: initial refresh: %v", created.FeedID, err)
+		}
+	}()
+
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) getFeed(w http.ResponseWriter, r *http.Request, id string) {
+	feed, err := schemas.GetFeed(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "feed not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, feed)
+}
+
+func (s *Server) updateFeed(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var in schemas.Feed
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := schemas.UpdateFeed(r.Context(), s.DB, id, in)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "feed not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (s *Server) deleteFeed(w http.ResponseWriter, r *http.Request, id string) {
+	err := schemas.DeleteFeed(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "feed not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) refreshFeedNow(w http.ResponseWriter, r *http.Request, id string) {
+	feed, err := schemas.GetFeed(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "feed not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res, err := s.refreshFeed(r.Context(), *feed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	updated, err := schemas.GetFeed(r.Context(), s.DB, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, FeedRefreshReport{Feed: *updated, Result: res})
+}
+
+// runFeedRefresher re-fetches every feed on each tick until ctx is canceled.
+func (s *Server) runFeedRefresher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.refreshAllFeeds(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) refreshAllFeeds(ctx context.Context) {
+	listCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	feeds, err := schemas.ListFeeds(listCtx, s.DB)
+	cancel()
+	if err != nil {
+		log.Printf("feed refresh: list feeds: %v", err)
+		return
+	}
+
+	for _, f := range feeds {
+		if ctx.Err() != nil {
+			return
+		}
+		feedCtx, cancel := context.WithTimeout(ctx, feedFetchTimeout+10*time.Second)
+		res, err := s.refreshFeed(feedCtx, f)
+		cancel()
+		if err != nil {
+			log.Printf("feed %s (%s): %v", f.FeedID, f.Name, err)
+			continue
+		}
+		log.Printf("feed %s (%s): +%d ~%d -%d", f.FeedID, f.Name, res.Added, res.Updated, res.Removed)
+	}
+}
+
+// refreshFeed fetches one feed, diffs it into the events table and records
+// the outcome on the feed row.
+func (s *Server) refreshFeed(ctx context.Context, feed schemas.Feed) (schemas.FeedSyncResult, error) {
+	res, err := s.syncFeed(ctx, feed)
+	if recErr := schemas.RecordFeedRefresh(ctx, s.DB, feed.FeedID, time.Now(), err); recErr != nil {
+		log.Printf("feed %s: %v", feed.FeedID, recErr)
+	}
+	return res, err
+}
+
+func (s *Server) syncFeed(ctx context.Context, feed schemas.Feed) (schemas.FeedSyncResult, error) {
+	var res schemas.FeedSyncResult
+
+	decoded, err := fetchFeed(ctx, feed.URL, feed.PersonName)
+	if err != nil {
+		return res, err
+	}
+
+	items := make([]schemas.FeedItem, 0, len(decoded.Items))
+	for _, it := range decoded.Items {
+		// Everything in a feed is shown for the feed's person, in the feed's calendar
+		it.Event.PersonID, it.Event.PersonName = feed.PersonID, feed.PersonName
+		it.Event.CalendarID = feed.CalendarID
+		items = append(items, schemas.FeedItem{UID: it.UID, Event: it.Event, Exceptions: it.Exceptions})
+	}
+
+	res, err = schemas.SyncFeedEvents(ctx, s.DB, feed.FeedID, items)
+	res.Skipped += len(decoded.Skipped)
+	return res, err
+}
+
+func fetchFeed(ctx context.Context, url, person string) (ical.Decoded, error) {
+	// webcal:// is just a hint to calendar apps; the transport is plain HTTP(S)
+	if rest, ok := strings.CutPrefix(url, "webcal://"); ok {
+		url = "https://" + rest
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return ical.Decoded{}, err
+	}
+	req.Header.Set("Accept", "text/calendar")
+
+	resp, err := feedClient.Do(req)
+	if err != nil {
+		return ical.Decoded{}, fmt.Errorf("fetch: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ical.Decoded{}, fmt.Errorf("fetch: unexpected status %s", resp.Status)
+	}
+
+	decoded, err := ical.Decode(io.LimitReader(resp.Body, maxFeedBytes), ical.DecodeOptions{DefaultPerson: person})
+	if err != nil {
+		return ical.Decoded{}, fmt.Errorf("parse: %w", err)
+	}
+	return decoded, nil
+}
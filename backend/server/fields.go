@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"pical/database/schemas"
+	"reflect"
+	"strings"
+)
+
+// fieldSet is a parsed ?fields= list. Each key maps to the fields wanted
+// inside it, or to nil when the whole value is wanted.
+type fieldSet map[string]fieldSet
+
+// parseFields reads "title,startTime,event.title" into a fieldSet. Dots
+// reach into nested objects, and through arrays to the objects in them.
+func parseFields(v string) fieldSet {
+	fs := fieldSet{}
+	for _, path := range strings.Split(v, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		cur := fs
+		parts := strings.Split(path, ".")
+		for i, part := range parts {
+			sub, seen := cur[part]
+			if seen && sub == nil {
+				// Already wanted whole
+				break
+			}
+			if i == len(parts)-1 {
+				cur[part] = nil
+				break
+			}
+			if sub == nil {
+				sub = fieldSet{}
+				cur[part] = sub
+			}
+			cur = sub
+		}
+	}
+	return fs
+}
+
+// apply drops everything from a decoded JSON value that isn't in fs.
+func (fs fieldSet) apply(v any) any {
+	switch v := v.(type) {
+	case []any:
+		for i := range v {
+			v[i] = fs.apply(v[i])
+		}
+		return v
+	case map[string]any:
+		out := make(map[string]any, len(fs))
+		for k, sub := range fs {
+			val, ok := v[k]
+			if !ok {
+				continue
+			}
+			if sub != nil {
+				val = sub.apply(val)
+			}
+			out[k] = val
+		}
+		return out
+	}
+	return v
+}
+
+// pagedEnvelope marks responses whose fields apply to each item rather than
+// to the envelope itself.
+type pagedEnvelope interface {
+	pagedItems() any
+}
+
+func (p PagedResponse[T]) pagedItems() any { return p.Items }
+
+// jsonWriter carries the request's field selection and time format to
+// writeJSON.
+type jsonWriter struct {
+	http.ResponseWriter
+	// nil for every field
+	fields fieldSet
+	// One of schemas.TimeFormats; empty for the default
+	times string
+}
+
+func (w *jsonWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// jsonOptions returns a jsonWriter for w to set options on, carrying over
+// any w already has.
+func jsonOptions(w http.ResponseWriter) *jsonWriter {
+	if jw, ok := w.(*jsonWriter); ok {
+		next := *jw
+		return &next
+	}
+	return &jsonWriter{ResponseWriter: w}
+}
+
+// selectFields re-encodes v keeping only the selected fields, with its
+// times in format. A paged response keeps its envelope and is trimmed item
+// by item.
+func selectFields(v any, fs fieldSet, format string) (any, error) {
+	if p, ok := v.(pagedEnvelope); ok {
+		items, err := selectFields(p.pagedItems(), fs, format)
+		if err != nil {
+			return nil, err
+		}
+		env, err := toJSONValue(v, format)
+		if err != nil {
+			return nil, err
+		}
+		env.(map[string]any)["items"] = items
+		return env, nil
+	}
+
+	decoded, err := toJSONValue(v, format)
+	if err != nil {
+		return nil, err
+	}
+	return fs.apply(decoded), nil
+}
+
+// toJSONValue is v as encoding/json would decode it into an any, with its
+// times in format.
+func toJSONValue(v any, format string) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	// Keeps large integers and exact decimals as they were
+	dec.UseNumber()
+	var out any
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	if format != schemas.TimeFormatOffset {
+		out = formatTimes(reflect.ValueOf(v), out, format)
+	}
+	return out, nil
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"pical/database/schemas"
+	"pical/recurrence"
+	"time"
+)
+
+// How far ahead a recurring event's occurrences are checked against focus
+// time when someone is invited to it
+const focusLookahead = 8 * 7 * 24 * time.Hour
+
+// focusConflicts returns a warning for each of personID's focus blocks that
+// an occurrence of e overlaps. Recurring events are checked from now, or
+// their start if later, for focusLookahead.
+func (s *Server) focusConflicts(ctx context.Context, personID string, e schemas.Event, now time.Time) ([]EventWarning, error) {
+	from, to := e.StartTime, instantEnd(e.StartTime, e.EndTime)
+	var exs []schemas.Exception
+	if recurrence.Recurs(e) {
+		if now.After(from) {
+			from = now
+		}
+		to = from.Add(focusLookahead)
+		var err error
+		if exs, err = schemas.ListEventExceptions(ctx, s.DB, e.EventID); err != nil {
+			return nil, err
+		}
+	}
+	occs := recurrence.Expand(e, exs, from, to)
+	if len(occs) == 0 {
+		return make([]EventWarning, 0), nil
+	}
+	// Occurrences near the end of the window may run past it
+	for _, occ := range occs {
+		if end := instantEnd(occ.StartTime, occ.EndTime); end.After(to) {
+			to = end
+		}
+	}
+
+	focus := true
+	blocks, err := s.expandOccurrences(ctx, schemas.EventFilter{PersonID: personID, Focus: &focus}, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]EventWarning, 0)
+	warned := make(map[string]bool)
+	for _, b := range blocks {
+		if warned[b.Event.EventID] || b.Event.EventID == e.EventID || b.EndTime == nil {
+			continue
+		}
+		for _, occ := range occs {
+			if !occ.StartTime.Before(*b.EndTime) || !instantEnd(occ.StartTime, occ.EndTime).After(b.StartTime) {
+				continue
+			}
+			warned[b.Event.EventID] = true
+			out = append(out, EventWarning{
+				Type:      "focus",
+				Message:   fmt.Sprintf("%s is in focus time (%q) then, so the invitation was declined", b.Event.PersonName, b.Event.Title),
+				Event:     b.Event,
+				StartTime: b.StartTime,
+				EndTime:   b.EndTime,
+			})
+			break
+		}
+	}
+
+	return out, nil
+}
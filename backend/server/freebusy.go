@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"pical/database/schemas"
+	"sort"
+	"time"
+)
+
+// getFreeBusy reports when ?person (default everyone) is busy within
+// [from, to), as merged intervals with no event details. All-day events and
+// events without an end don't block time.
+func (s *Server) getFreeBusy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, err := parseOccurrenceRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := schemas.EventFilter{PersonID: q.Get("person"), CalendarID: q.Get("calendar")}
+	if filter.PersonID != "" && !validID(w, "person", filter.PersonID) ||
+		filter.CalendarID != "" && !validID(w, "calendar", filter.CalendarID) {
+		return
+	}
+	occs, err := s.expandOccurrences(r.Context(), filter, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, FreeBusy{From: from, To: to, Busy: busyIntervals(occs, from, to)})
+}
+
+// busyIntervals clips occurrences to [from, to) and merges any that overlap
+// or touch.
+func busyIntervals(occs []EventOccurrence, from, to time.Time) []BusyInterval {
+	spans := make([]BusyInterval, 0, len(occs))
+	for _, occ := range occs {
+		if occ.Event.AllDay || occ.EndTime == nil || !occ.EndTime.After(occ.StartTime) {
+			continue
+		}
+		start, end := occ.StartTime, *occ.EndTime
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			spans = append(spans, BusyInterval{Start: start, End: end})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+
+	busy := make([]BusyInterval, 0, len(spans))
+	for _, sp := range spans {
+		if n := len(busy); n > 0 && !sp.Start.After(busy[n-1].End) {
+			if sp.End.After(busy[n-1].End) {
+				busy[n-1].End = sp.End
+			}
+			continue
+		}
+		busy = append(busy, sp)
+	}
+	return busy
+}
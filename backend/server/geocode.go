@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"pical/database/schemas"
+	"pical/geocode"
+	"time"
+)
+
+const (
+	// How often the geocoder looks for new or edited locations
+	geocodeInterval = time.Minute
+	// Public Nominatim allows one request a second; self-hosted ones don't mind
+	geocodeSpacing = time.Second
+	geocodeBatch   = 50
+)
+
+// runGeocoder resolves event locations to coordinates in the background until
+// ctx is canceled, so saving an event never waits on the provider.
+func (s *Server) runGeocoder(ctx context.Context) {
+	ticker := time.NewTicker(geocodeInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.geocodePending(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("geocode: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// geocodePending looks up every pending location. A provider error stops the
+// pass; the rest are retried on the next one.
+func (s *Server) geocodePending(ctx context.Context) error {
+	for {
+		listCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		pending, err := schemas.ListPendingGeocodes(listCtx, s.DB, geocodeBatch)
+		cancel()
+		if err != nil || len(pending) == 0 {
+			return err
+		}
+
+		for _, p := range pending {
+			var lat, lng *float64
+			la, ln, err := s.geocoder.Geocode(ctx, p.Location)
+			switch {
+			case err == nil:
+				lat, lng = &la, &ln
+			case errors.Is(err, geocode.ErrNotFound):
+				log.Printf("geocode: no match for %q (event %s)", p.Location, p.EventID)
+			default:
+				return err
+			}
+
+			setCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			err = schemas.SetEventCoordinates(setCtx, s.DB, p.EventID, p.Location, lat, lng)
+			cancel()
+			if err != nil {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(geocodeSpacing):
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"pical/database/schemas"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Ways ?groupBy can arrange occurrences
+const (
+	groupByPerson     = "person"
+	groupByDate       = "date"
+	groupByDatePerson = "date,person"
+)
+
+// parseGroupBy reads ?groupBy; "" leaves occurrences as a flat list.
+func parseGroupBy(r *http.Request) (string, error) {
+	switch v := r.URL.Query().Get("groupBy"); v {
+	case "", groupByPerson, groupByDate, groupByDatePerson:
+		return v, nil
+	}
+	return "", fmt.Errorf("groupBy must be %s, %s or %s", groupByPerson, groupByDate, groupByDatePerson)
+}
+
+// groupOccurrences arranges occs, the occurrences within [from, to), as
+// groupBy asks. Dates are read in loc.
+func (s *Server) groupOccurrences(ctx context.Context, groupBy string, filter schemas.EventFilter, occs []EventOccurrence, loc *time.Location, from, to time.Time) (any, error) {
+	var settings schemas.Settings
+	if groupBy != groupByPerson {
+		var err error
+		if settings, err = schemas.GetSettings(ctx, s.DB); err != nil {
+			return nil, err
+		}
+	}
+	if groupBy == groupByDate {
+		return groupByDates(occs, loc, from, to, settings), nil
+	}
+
+	people, err := s.peopleColumns(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if groupBy == groupByPerson {
+		return groupByPeople(people, occs), nil
+	}
+
+	return splitDaysByPerson(people, groupByDates(occs, loc, from, to, settings)), nil
+}
+
+// splitDaysByPerson gives every day a column per person.
+func splitDaysByPerson(people []schemas.Person, days []AgendaDay) []PersonDay {
+	out := make([]PersonDay, len(days))
+	for i, day := range days {
+		out[i] = PersonDay{Date: day.Date, Weekend: day.Weekend, People: groupByPeople(people, day.Occurrences)}
+	}
+	return out
+}
+
+// peopleColumns is who gets a column: everyone, in their sort order, unless
+// the filter or its display profile narrows it to some of them.
+func (s *Server) peopleColumns(ctx context.Context, filter schemas.EventFilter) ([]schemas.Person, error) {
+	people, err := schemas.ListPeople(ctx, s.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	var only []string
+	if filter.PersonID != "" {
+		only = []string{filter.PersonID}
+	} else if filter.DisplayProfileID != "" {
+		p, err := schemas.GetDisplayProfile(ctx, s.DB, filter.DisplayProfileID)
+		if err != nil {
+			return nil, err
+		}
+		only = p.PersonIDs
+	}
+	if len(only) == 0 {
+		return people, nil
+	}
+
+	return slices.DeleteFunc(people, func(p schemas.Person) bool {
+		return !slices.Contains(only, p.PersonID)
+	}), nil
+}
+
+// groupByPeople gives each person a column of their occurrences, in the
+// order occs has them. People without any still get an empty column.
+func groupByPeople(people []schemas.Person, occs []EventOccurrence) []PersonColumn {
+	out := make([]PersonColumn, len(people))
+	index := make(map[string]int, len(people))
+	for i, p := range people {
+		out[i] = PersonColumn{PersonID: p.PersonID, PersonName: p.DisplayName, Color: p.Color, Occurrences: make([]EventOccurrence, 0)}
+		index[p.PersonID] = i
+	}
+
+	for _, occ := range occs {
+		if i, ok := index[occ.Event.PersonID]; ok {
+			out[i].Occurrences = append(out[i].Occurrences, occ)
+		}
+	}
+	return out
+}
+
+// groupByDates places occs under each date of [from, to) in loc that they
+// cover. Every date is present, even when empty, and all-day occurrences
+// head each one. The settings say which dates are the weekend.
+func groupByDates(occs []EventOccurrence, loc *time.Location, from, to time.Time, settings schemas.Settings) []AgendaDay {
+	start := from.In(loc)
+	first := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+	days := make([]AgendaDay, 0)
+	index := make(map[string]int)
+	for d := first; d.Before(to); d = d.AddDate(0, 0, 1) {
+		date := d.Format("2006-01-02")
+		index[date] = len(days)
+		days = append(days, AgendaDay{Date: date, Weekend: settings.IsWeekend(d.Weekday()), Occurrences: make([]EventOccurrence, 0)})
+	}
+
+	for _, occ := range occs {
+		for _, date := range occurrenceDates(occ, loc) {
+			if i, ok := index[date]; ok {
+				days[i].Occurrences = append(days[i].Occurrences, occ)
+			}
+		}
+	}
+
+	// occs is already in start order
+	for _, day := range days {
+		sort.SliceStable(day.Occurrences, func(i, j int) bool {
+			return day.Occurrences[i].Event.AllDay && !day.Occurrences[j].Event.AllDay
+		})
+	}
+	return days
+}
@@ -2,11 +2,29 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"pical/database/schemas"
+	"reflect"
 	"strconv"
 )
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
+	var opts jsonWriter
+	if jw, ok := w.(*jsonWriter); ok {
+		opts = *jw
+	}
+	// Errors are sent whole, whatever fields were asked for
+	if opts.fields != nil && status < 300 {
+		if trimmed, err := selectFields(v, opts.fields, opts.times); err == nil {
+			v = trimmed
+		}
+	} else if opts.times != schemas.TimeFormatOffset && v != nil && hasTimes(reflect.TypeOf(v)) {
+		if formatted, err := toJSONValue(v, opts.times); err == nil {
+			v = formatted
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(v)
@@ -29,3 +47,89 @@ func parseIntQuery(r *http.Request, key string, def, min, max int) int {
 	}
 	return n
 }
+
+// parsePage reads ?limit and ?offset for a paged listing. limit defaults to
+// the configured page size and is capped at the maximum.
+func (s *Server) parsePage(r *http.Request) (limit, offset int) {
+	maxSize := max(s.Config.MaxPageSize, 1)
+	size := min(max(s.Config.PageSize, 1), maxSize)
+	return parseIntQuery(r, "limit", size, 1, maxSize), parseIntQuery(r, "offset", 0, 0, 1_000_000)
+}
+
+// pageOf cuts one page out of a listing that was loaded whole.
+func pageOf[T any](all []T, limit, offset int) PagedResponse[T] {
+	items := all[min(offset, len(all)):]
+	items = items[:min(limit, len(items))]
+	return PagedResponse[T]{
+		Items:  items,
+		Limit:  limit,
+		Offset: offset,
+		Count:  len(items),
+		Total:  len(all),
+	}
+}
+
+// parseBoolQuery reads a boolean query flag; a bare "?key" counts as true.
+func parseBoolQuery(r *http.Request, key string) bool {
+	vals, ok := r.URL.Query()[key]
+	if !ok {
+		return false
+	}
+	if len(vals) == 0 || vals[0] == "" {
+		return true
+	}
+	b, err := strconv.ParseBool(vals[0])
+	return err == nil && b
+}
+
+// isUUID reports whether v is a UUID in the hyphenated form the API returns.
+func isUUID(v string) bool {
+	if len(v) != 36 {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validID answers 400 when the path parameter param isn't a UUID, so that a
+// malformed ID is refused before Postgres fails to cast it.
+func validID(w http.ResponseWriter, param, v string) bool {
+	if isUUID(v) {
+		return true
+	}
+	writeJSON(w, http.StatusBadRequest, InvalidID{Error: "invalid id", Param: param, Value: v})
+	return false
+}
+
+// invalidIDError is a query parameter that should be a UUID and isn't, for
+// parsers that return errors rather than write the response.
+type invalidIDError struct {
+	param, value string
+}
+
+func (e invalidIDError) Error() string {
+	return fmt.Sprintf("%s must be an ID", e.param)
+}
+
+// badRequest answers 400 with err, in validID's body when err is a malformed
+// ID.
+func badRequest(w http.ResponseWriter, err error) {
+	var bad invalidIDError
+	if errors.As(err, &bad) {
+		writeJSON(w, http.StatusBadRequest, InvalidID{Error: "invalid id", Param: bad.param, Value: bad.value})
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"log"
+	"pical/database/schemas"
+	"pical/recurrence"
+	"time"
+)
+
+// How often the materializer looks for stale events and a rolled-over horizon.
+const materializeInterval = time.Hour
+
+// occurrenceWindow is the span kept materialized: from the start of the
+// current month (UTC) for RecurrenceHorizonMonths months. It moves forward
+// once a month, which makes every event stale at once.
+func (s *Server) occurrenceWindow(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return from, from.AddDate(0, s.Config.RecurrenceHorizonMonths, 0)
+}
+
+// materializeOccurrences expands every event whose cached occurrences are
+// missing, invalidated by an edit, or for an older window.
+func (s *Server) materializeOccurrences(ctx context.Context, from, through time.Time) error {
+	ids, err := schemas.ListStaleOccurrenceEvents(ctx, s.DB, from, through)
+	if err != nil {
+		return err
+	}
+
+	expand := func(e schemas.Event, exs []schemas.Exception) []schemas.Occurrence {
+		occs := recurrence.Expand(e, exs, from, through)
+		out := make([]schemas.Occurrence, 0, len(occs))
+		for _, occ := range occs {
+			out = append(out, schemas.Occurrence(occ))
+		}
+		return out
+	}
+	for _, id := range ids {
+		if err := schemas.MaterializeOccurrences(ctx, s.DB, id, from, through, expand); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runMaterializer keeps the occurrence cache filled until ctx is canceled, so
+// reads rarely have to expand anything themselves.
+func (s *Server) runMaterializer(ctx context.Context) {
+	ticker := time.NewTicker(materializeInterval)
+	defer ticker.Stop()
+
+	for {
+		from, through := s.occurrenceWindow(time.Now())
+		if err := s.materializeOccurrences(ctx, from, through); err != nil && ctx.Err() == nil {
+			log.Printf("materialize occurrences: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
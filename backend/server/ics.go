@@ -0,0 +1,69 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"pical/database/schemas"
+	"pical/ical"
+	"time"
+)
+
+func (s *Server) exportEventICS(w http.ResponseWriter, r *http.Request, id string) {
+	e, err := s.Events.GetEvent(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	exceptions, err := schemas.ListEventExceptions(r.Context(), s.DB, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	items := []ical.Item{{Event: *e, Exceptions: exceptions}}
+	writeICS(w, e.EventID+".ics", e.Title, items)
+}
+
+func (s *Server) exportCalendarICS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	events, err := schemas.ListAllEvents(r.Context(), s.DB, schemas.EventFilter{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	exceptions, err := schemas.ListAllExceptions(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]ical.Item, 0, len(events))
+	for _, e := range events {
+		items = append(items, ical.Item{Event: e, Exceptions: exceptions[e.EventID]})
+	}
+
+	writeICS(w, "calendar.ics", "PiCal", items)
+}
+
+func writeICS(w http.ResponseWriter, filename, calName string, items []ical.Item) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+	if err := ical.Encode(w, calName, items, time.Now()); err != nil {
+		// Headers are already sent, so all we can do is record it
+		log.Printf("write ics: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"pical/database/schemas"
+	"time"
+)
+
+const (
+	// How long a key is remembered; retries after that run again
+	idempotencyRetention = 24 * time.Hour
+	// A claim whose request has not finished in this long is taken to have
+	// died with the server, and a retry may have the key
+	idempotencyStale = time.Minute
+	// Caps a body read for hashing; the handlers take far less
+	maxIdempotentBody = 1 << 20
+	maxIdempotencyKey = 255
+)
+
+// idempotent lets clients on flaky networks retry next safely: a request
+// sent again with the same Idempotency-Key header gets the first response
+// back instead of running twice. Requests without the header run as usual.
+// Failures (5xx) aren't kept, so they can be retried for real.
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		if err != nil {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		h := sha256.New()
+		io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+		h.Write(body)
+		hash := hex.EncodeToString(h.Sum(nil))
+
+		prev, err := schemas.ClaimIdempotencyKey(r.Context(), s.DB, key, hash)
+		if err == nil && prev != nil && prev.Status == 0 && time.Since(prev.CreatedAt) > idempotencyStale {
+			if err = schemas.ReleaseIdempotencyKey(r.Context(), s.DB, key); err == nil {
+				prev, err = schemas.ClaimIdempotencyKey(r.Context(), s.DB, key, hash)
+			}
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		switch {
+		case prev == nil:
+		case prev.RequestHash != hash:
+			http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+			return
+		case prev.Status == 0:
+			http.Error(w, "a request with this Idempotency-Key is still in progress", http.StatusConflict)
+			return
+		default:
+			if prev.ContentType != "" {
+				w.Header().Set("Content-Type", prev.ContentType)
+			}
+			if prev.ETag != "" {
+				w.Header().Set("ETag", prev.ETag)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(prev.Status)
+			_, _ = w.Write(prev.Body)
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		var rw http.ResponseWriter = rec
+		// Keep ?fields= and the time format working; writeJSON looks for the jsonWriter
+		if jw, ok := w.(*jsonWriter); ok {
+			rw = &jsonWriter{ResponseWriter: rec, fields: jw.fields, times: jw.times}
+		}
+		next(rw, r)
+
+		// The client may have given up already; the outcome is kept anyway
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+		defer cancel()
+		if rec.status >= 500 {
+			err = schemas.ReleaseIdempotencyKey(ctx, s.DB, key)
+		} else {
+			err = schemas.CompleteIdempotencyKey(ctx, s.DB, key, rec.status, rec.Header().Get("Content-Type"), rec.Header().Get("ETag"), rec.body.Bytes())
+		}
+		if err != nil {
+			log.Printf("idempotency: %v", err)
+		}
+	}
+}
+
+// recordingWriter passes a response through and keeps a copy of it.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// runIdempotencyPruner forgets expired keys hourly until ctx is canceled.
+func (s *Server) runIdempotencyPruner(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		pruneCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		if err := schemas.PruneIdempotencyKeys(pruneCtx, s.DB, time.Now().Add(-idempotencyRetention)); err != nil && ctx.Err() == nil {
+			log.Printf("idempotency: %v", err)
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
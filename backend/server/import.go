@@ -0,0 +1,194 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"pical/database/schemas"
+	"pical/ical"
+	"pical/importers"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxImportBytes = 10 << 20
+
+// importICS accepts either a raw text/calendar body or a multipart form with
+// the calendar in a "file" field. ?dryRun=true reports what would be
+// imported without storing it.
+func (s *Server) importICS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+
+	opts := ical.DecodeOptions{DefaultPerson: r.URL.Query().Get("person")}
+	if tz := r.URL.Query().Get("timezone"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			http.Error(w, "unknown timezone", http.StatusBadRequest)
+			return
+		}
+		opts.DefaultLocation = loc
+	}
+
+	body, err := importBody(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	decoded, err := ical.Decode(body, opts)
+	if err != nil {
+		http.Error(w, "invalid ics: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series := make([]schemas.Series, 0, len(decoded.Items))
+	skipped := make([]ImportSkip, 0, len(decoded.Skipped))
+	for _, sk := range decoded.Skipped {
+		skipped = append(skipped, ImportSkip{Ref: sk.UID, Summary: sk.Summary, Reason: sk.Reason})
+	}
+	for _, it := range decoded.Items {
+		if err := schemas.ValidateEvent(it.Event); err != nil {
+			skipped = append(skipped, ImportSkip{Ref: it.UID, Summary: it.Event.Title, Reason: err.Error()})
+			continue
+		}
+		series = append(series, schemas.Series{Event: it.Event, Exceptions: it.Exceptions, Reminders: it.Reminders})
+	}
+
+	dryRun := parseBoolQuery(r, "dryRun")
+	created, err := schemas.ImportSeries(r.Context(), s.DB, series, dryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ImportReport{
+		Imported: len(created),
+		Events:   created,
+		Skipped:  skipped,
+		DryRun:   dryRun,
+	})
+}
+
+const maxPreviewEvents = 20
+
+// importSourceHandler serves "/import/{source}" and "/import/{source}/preview"
+// for the CSV importers. ICS has its own exact route.
+func (s *Server) importSourceHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/import/"), "/")
+	name, action, _ := strings.Cut(rest, "/")
+
+	src, ok := importers.Lookup(name)
+	if !ok || (action != "" && action != "preview") {
+		http.Error(w, "unknown import source; supported: ics, "+strings.Join(importers.SourceNames(), ", "), http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+
+	body, err := importBody(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	records, err := importers.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The reviewed mapping comes back from the client; otherwise use the preset's guess
+	mapping := src.Guess(records[0])
+	if raw := r.FormValue("mapping"); raw != "" {
+		mapping = importers.Mapping{}
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			http.Error(w, "invalid mapping JSON", http.StatusBadRequest)
+			return
+		}
+	}
+	if mapping.DefaultPerson == "" {
+		mapping.DefaultPerson = r.URL.Query().Get("person")
+	}
+	if mapping.Timezone == "" {
+		mapping.Timezone = r.URL.Query().Get("timezone")
+	}
+
+	res, err := importers.Apply(records, mapping)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rowErrors := append([]importers.RowError{}, res.Errors...)
+
+	if action == "preview" {
+		sample := res.Events
+		if len(sample) > maxPreviewEvents {
+			sample = sample[:maxPreviewEvents]
+		}
+		writeJSON(w, http.StatusOK, ImportPreview{
+			Source:  src.Name,
+			Headers: res.Headers,
+			Fields:  importers.Fields,
+			Mapping: mapping,
+			Events:  append([]schemas.Event{}, sample...),
+			Total:   len(res.Events),
+			Errors:  rowErrors,
+		})
+		return
+	}
+
+	series := make([]schemas.Series, 0, len(res.Events))
+	for _, e := range res.Events {
+		series = append(series, schemas.Series{Event: e})
+	}
+
+	dryRun := parseBoolQuery(r, "dryRun")
+	created, err := schemas.ImportSeries(r.Context(), s.DB, series, dryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	skipped := make([]ImportSkip, 0, len(rowErrors))
+	for _, re := range rowErrors {
+		skipped = append(skipped, ImportSkip{Ref: "row " + strconv.Itoa(re.Row), Summary: re.Title, Reason: re.Reason})
+	}
+
+	writeJSON(w, http.StatusOK, ImportReport{
+		Imported: len(created),
+		Events:   created,
+		Skipped:  skipped,
+		DryRun:   dryRun,
+	})
+}
+
+func importBody(w http.ResponseWriter, r *http.Request) (io.ReadCloser, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return r.Body, nil
+	}
+
+	if err := r.ParseMultipartForm(maxImportBytes); err != nil {
+		return nil, err
+	}
+	f, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
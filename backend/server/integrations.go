@@ -0,0 +1,376 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"pical/database/schemas"
+	"pical/integrations"
+	"sync"
+	"time"
+)
+
+const (
+	integrationSyncTimeout = 2 * time.Minute
+	oauthStateTTL          = 10 * time.Minute
+)
+
+// knownProviders are the integrations PiCal can be configured with, when
+// they are compiled in.
+var knownProviders = map[string]bool{
+	"google":    true,
+	"microsoft": true,
+}
+
+// oauthStates remembers which account an in-flight consent redirect belongs to.
+type oauthStates struct {
+	mu      sync.Mutex
+	pending map[string]oauthState
+}
+
+type oauthState struct {
+	syncID  string
+	expires time.Time
+}
+
+func (o *oauthStates) issue(syncID string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	state := hex.EncodeToString(b)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.pending == nil {
+		o.pending = make(map[string]oauthState)
+	}
+	now := time.Now()
+	for k, v := range o.pending {
+		if now.After(v.expires) {
+			delete(o.pending, k)
+		}
+	}
+	o.pending[state] = oauthState{syncID: syncID, expires: now.Add(oauthStateTTL)}
+	return state, nil
+}
+
+func (o *oauthStates) take(state string) (string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	v, ok := o.pending[state]
+	delete(o.pending, state)
+	if !ok || time.Now().After(v.expires) {
+		return "", false
+	}
+	return v.syncID, true
+}
+
+func integrationAccount(st schemas.SyncState) IntegrationAccount {
+	return IntegrationAccount{SyncState: st, Connected: st.Connected()}
+}
+
+// provider returns the configured provider for name, writing 404 for unknown
+// names and 503 for known ones without credentials.
+func (s *Server) provider(w http.ResponseWriter, name string) (integrations.Provider, bool) {
+	if !knownProviders[name] {
+		http.Error(w, "not found", http.StatusNotFound)
+		return nil, false
+	}
+	if !compiledIn(name) {
+		http.Error(w, name+" sync is not in this build", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	p, ok := s.providers[name]
+	if !ok {
+		http.Error(w, name+" sync is not configured", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return p, true
+}
+
+func (s *Server) getIntegrationAccounts(w http.ResponseWriter, r *http.Request, p integrations.Provider) {
+	states, err := schemas.ListSyncStates(r.Context(), s.DB, p.Name())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]IntegrationAccount, 0, len(states))
+	for _, st := range states {
+		out = append(out, integrationAccount(st))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) createIntegrationAccount(w http.ResponseWriter, r *http.Request, p integrations.Provider) {
+	defer r.Body.Close()
+
+	var in schemas.SyncState
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	in.Provider = p.Name()
+
+	created, err := schemas.CreateSyncState(r.Context(), s.DB, in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out, err := s.startConnect(r.Context(), p, created)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, out)
+}
+
+// reconnectIntegrationAccount restarts sign-in, e.g. after the user revoked access.
+func (s *Server) reconnectIntegrationAccount(w http.ResponseWriter, r *http.Request, p integrations.Provider, id string) {
+	st, ok := s.lookupIntegrationAccount(w, r, p, id)
+	if !ok {
+		return
+	}
+
+	out, err := s.startConnect(r.Context(), p, *st)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// startConnect begins the provider's sign-in flow: a consent URL for
+// redirect-based providers, or a device code that is polled in the background.
+func (s *Server) startConnect(ctx context.Context, p integrations.Provider, st schemas.SyncState) (IntegrationConnect, error) {
+	out := IntegrationConnect{Account: integrationAccount(st)}
+
+	switch auth := p.(type) {
+	case integrations.RedirectAuth:
+		state, err := s.oauth.issue(st.SyncID)
+		if err != nil {
+			return out, err
+		}
+		out.AuthURL = auth.AuthCodeURL(state)
+
+	case integrations.DeviceAuth:
+		dc, err := auth.StartDevice(ctx)
+		if err != nil {
+			return out, err
+		}
+		out.Device = &dc
+
+		go func() {
+			pollCtx, cancel := context.WithTimeout(context.Background(), time.Duration(dc.ExpiresIn)*time.Second)
+			defer cancel()
+
+			tok, err := auth.PollDevice(pollCtx, dc)
+			if err != nil {
+				log.Printf("%s sync %s: device sign-in: %v", p.Name(), st.SyncID, err)
+				return
+			}
+			if err := schemas.UpdateSyncTokens(pollCtx, s.DB, st.SyncID, tok.AccessToken, tok.RefreshToken, tok.Expiry(time.Now())); err != nil {
+				log.Printf("%s sync %s: %v", p.Name(), st.SyncID, err)
+			}
+		}()
+	}
+
+	return out, nil
+}
+
+// oauthCallback is the redirect target after the user grants access.
+func (s *Server) oauthCallback(w http.ResponseWriter, r *http.Request, p integrations.Provider) {
+	q := r.URL.Query()
+	id, ok := s.oauth.take(q.Get("state"))
+	if !ok {
+		http.Error(w, "unknown or expired state", http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		http.Error(w, p.Name()+": "+e, http.StatusBadRequest)
+		return
+	}
+
+	tok, err := p.OAuth().Exchange(r.Context(), q.Get("code"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if err := schemas.UpdateSyncTokens(r.Context(), s.DB, id, tok.AccessToken, tok.RefreshToken, tok.Expiry(time.Now())); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("Calendar connected. You can close this window and pick a calendar in PiCal."))
+}
+
+func (s *Server) lookupIntegrationAccount(w http.ResponseWriter, r *http.Request, p integrations.Provider, id string) (*schemas.SyncState, bool) {
+	st, err := schemas.GetSyncState(r.Context(), s.DB, id)
+	if err == nil && st.Provider != p.Name() {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "account not found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return st, true
+}
+
+func (s *Server) getIntegrationAccount(w http.ResponseWriter, r *http.Request, p integrations.Provider, id string) {
+	st, ok := s.lookupIntegrationAccount(w, r, p, id)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, integrationAccount(*st))
+}
+
+func (s *Server) updateIntegrationAccount(w http.ResponseWriter, r *http.Request, p integrations.Provider, id string) {
+	defer r.Body.Close()
+
+	if _, ok := s.lookupIntegrationAccount(w, r, p, id); !ok {
+		return
+	}
+
+	var in schemas.SyncState
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := schemas.UpdateSyncSettings(r.Context(), s.DB, id, in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, integrationAccount(updated))
+}
+
+// deleteIntegrationAccount disconnects the account. Events already pulled stay in PiCal.
+func (s *Server) deleteIntegrationAccount(w http.ResponseWriter, r *http.Request, p integrations.Provider, id string) {
+	if _, ok := s.lookupIntegrationAccount(w, r, p, id); !ok {
+		return
+	}
+
+	if err := schemas.DeleteSyncState(r.Context(), s.DB, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "account not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getIntegrationCalendars(w http.ResponseWriter, r *http.Request, p integrations.Provider, id string) {
+	st, ok := s.lookupIntegrationAccount(w, r, p, id)
+	if !ok {
+		return
+	}
+
+	token, err := integrations.AccessToken(r.Context(), s.DB, p.OAuth(), st)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	cals, err := p.Calendars(r.Context(), token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, cals)
+}
+
+func (s *Server) syncIntegrationNow(w http.ResponseWriter, r *http.Request, p integrations.Provider, id string) {
+	st, ok := s.lookupIntegrationAccount(w, r, p, id)
+	if !ok {
+		return
+	}
+
+	res, err := s.syncAccount(r.Context(), p, *st)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	updated, err := schemas.GetSyncState(r.Context(), s.DB, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, IntegrationSyncReport{Account: integrationAccount(*updated), Result: res})
+}
+
+// syncAccount runs one account's sync. Runs are serialised so the background
+// loops and a manual sync never push the same event twice.
+func (s *Server) syncAccount(ctx context.Context, p integrations.Provider, st schemas.SyncState) (integrations.Result, error) {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	en := integrations.Engine{DB: s.DB}
+	return en.Sync(ctx, p, st)
+}
+
+// runSync syncs every connected account of p on each tick until ctx is canceled.
+func (s *Server) runSync(ctx context.Context, p integrations.Provider, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.syncAll(ctx, p)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) syncAll(ctx context.Context, p integrations.Provider) {
+	listCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	states, err := schemas.ListSyncStates(listCtx, s.DB, p.Name())
+	cancel()
+	if err != nil {
+		log.Printf("%s sync: list accounts: %v", p.Name(), err)
+		return
+	}
+
+	for _, st := range states {
+		if ctx.Err() != nil {
+			return
+		}
+		if !st.Connected() || st.CalendarID == "" {
+			continue
+		}
+		syncCtx, cancel := context.WithTimeout(ctx, integrationSyncTimeout)
+		res, err := s.syncAccount(syncCtx, p, st)
+		cancel()
+		if err != nil {
+			log.Printf("%s sync %s (%s): %v", p.Name(), st.SyncID, st.PersonName, err)
+			continue
+		}
+		log.Printf("%s sync %s (%s): pulled %d pushed %d deleted %d conflicts %d",
+			p.Name(), st.SyncID, st.PersonName, res.Pulled, res.Pushed, res.Deleted, res.Conflicts)
+	}
+}
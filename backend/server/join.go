@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"pical/database/schemas"
+	"pical/recurrence"
+	"sort"
+	"time"
+)
+
+// defaultJoinLength is how long an occurrence without an end stays joinable.
+const defaultJoinLength = time.Hour
+
+// joinURL returns the event's link if the occurrence [start, end) is joinable
+// at now: from JoinWindow before the start until the end.
+func (s *Server) joinURL(e schemas.Event, start time.Time, end *time.Time, now time.Time) *string {
+	if e.URL == nil {
+		return nil
+	}
+
+	until := start.Add(defaultJoinLength)
+	if end != nil {
+		until = *end
+	}
+	if now.Before(start.Add(-s.Config.JoinWindow)) || !now.Before(until) {
+		return nil
+	}
+	return e.URL
+}
+
+// markJoinable sets JoinURL on the occurrences that are joinable at now.
+func (s *Server) markJoinable(occs []EventOccurrence, now time.Time) {
+	for i := range occs {
+		occs[i].JoinURL = s.joinURL(occs[i].Event, occs[i].StartTime, occs[i].EndTime, now)
+	}
+}
+
+func (s *Server) getJoinable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	events, err := schemas.ListJoinableEvents(r.Context(), s.DB, now, s.Config.JoinWindow)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	exs, err := schemas.ListAllExceptions(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Occurrences without an end stay joinable for an hour after they start
+	from, to := now.Add(-defaultJoinLength), now.Add(s.Config.JoinWindow)
+	out := make([]Joinable, 0, len(events))
+	for _, e := range events {
+		for _, occ := range recurrence.Expand(e, exs[e.EventID], from, to.Add(time.Nanosecond)) {
+			if link := s.joinURL(e, occ.StartTime, occ.EndTime, now); link != nil {
+				out = append(out, Joinable{Event: e, JoinURL: *link, StartTime: occ.StartTime, EndTime: occ.EndTime})
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].StartTime.Before(out[j].StartTime) })
+
+	writeJSON(w, http.StatusOK, out)
+}
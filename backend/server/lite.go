@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"pical/database/schemas"
+	"strings"
+	"time"
+)
+
+// The lite pages are plain HTML with no scripts, so the calendar stays usable
+// from text browsers, very old browsers, or when the SPA bundle won't load.
+var liteTemplates = template.Must(template.New("lite").Funcs(template.FuncMap{
+	"clock": func(t time.Time, loc *time.Location) string { return t.In(loc).Format("15:04") },
+	"when":  liteWhen,
+	"mapURL": func(e schemas.Event) string {
+		if e.Latitude == nil || e.Longitude == nil {
+			return ""
+		}
+		return fmt.Sprintf("https://www.openstreetmap.org/?mlat=%f&mlon=%f#map=16/%f/%f", *e.Latitude, *e.Longitude, *e.Latitude, *e.Longitude)
+	},
+	"day": func(date string) string {
+		d, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return date
+		}
+		return d.Format("Monday 2 January")
+	},
+}).Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}} - PiCal</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 1em auto; padding: 0 1em; }
+h2 { border-bottom: 1px solid #ccc; font-size: 1.1em; }
+ul { list-style: none; padding: 0; }
+li { margin: 0.3em 0; }
+.time { display: inline-block; min-width: 7em; color: #555; }
+.empty { color: #888; }
+.weekend { background: #f4f4f4; }
+dt { font-weight: bold; margin-top: 0.5em; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "agenda"}}{{template "head" "Agenda"}}
+<h1>Agenda</h1>
+{{$loc := .Loc}}{{$q := .Query}}
+{{range .Days}}
+<h2{{if .Weekend}} class="weekend"{{end}}>{{day .Date}}</h2>
+{{if .Occurrences}}<ul>
+{{range .Occurrences}}<li><span class="time">{{if .Event.AllDay}}All day{{else}}{{clock .StartTime $loc}}{{if .EndTime}}-{{clock .EndTime $loc}}{{end}}{{end}}</span>
+<a href="/lite/events/{{.Event.EventID}}{{$q}}">{{.Event.Title}}</a>{{if .Event.PersonName}} ({{.Event.PersonName}}){{end}}</li>
+{{end}}</ul>
+{{else}}<p class="empty">Nothing planned.</p>
+{{end}}
+{{end}}
+<p>Times shown in {{.Loc}}.</p>
+</body>
+</html>
+{{end}}
+
+{{define "event"}}{{template "head" .Event.Title}}
+<p><a href="/lite{{.Query}}">&larr; Agenda</a></p>
+<h1>{{.Event.Title}}</h1>
+<dl>
+<dt>When</dt><dd>{{when .Event .Loc}}</dd>
+{{if .Event.PersonName}}<dt>Who</dt><dd>{{.Event.PersonName}}</dd>{{end}}
+{{if .Event.Location}}<dt>Where</dt><dd>{{.Event.Location}}{{with mapURL .Event}} (<a href="{{.}}">map</a>){{end}}</dd>{{end}}
+{{if .Event.Rrule}}<dt>Repeats</dt><dd>{{.Event.Rrule}}</dd>{{end}}
+{{if .Event.URL}}<dt>Link</dt><dd><a href="{{.Event.URL}}">{{.Event.URL}}</a></dd>{{end}}
+{{if .Event.Tags}}<dt>Tags</dt><dd>{{range $i, $t := .Event.Tags}}{{if $i}}, {{end}}{{$t}}{{end}}</dd>{{end}}
+{{if .Event.Notes}}<dt>Notes</dt><dd style="white-space: pre-wrap">{{.Event.Notes}}</dd>{{end}}
+</dl>
+</body>
+</html>
+{{end}}
+`))
+
+// liteHandler serves /lite (the agenda) and /lite/events/{id}.
+func (s *Server) liteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/lite"), "/")
+	if rest == "" {
+		s.getLiteAgenda(w, r)
+		return
+	}
+	id, ok := strings.CutPrefix(rest, "events/")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if !validID(w, "id", id) {
+		return
+	}
+	s.getLiteEvent(w, r, id)
+}
+
+func (s *Server) getLiteAgenda(w http.ResponseWriter, r *http.Request) {
+	loc, err := parseLocalTZ(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	days := parseIntQuery(r, "days", 7, 1, maxAgendaDays)
+
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	agenda, err := s.buildAgenda(r.Context(), filter, loc, days, parseBoolQuery(r, "week"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderLite(w, "agenda", map[string]any{
+		"Days":  agenda,
+		"Loc":   loc,
+		"Query": liteQuery(r),
+	})
+}
+
+func (s *Server) getLiteEvent(w http.ResponseWriter, r *http.Request, id string) {
+	loc, err := parseLocalTZ(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e, err := s.Events.GetEvent(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderLite(w, "event", map[string]any{
+		"Event": e,
+		"Loc":   loc,
+		"Query": liteQuery(r),
+	})
+}
+
+// parseLocalTZ is parseTZQuery for views mostly used on the Pi itself: without
+// ?tz they show the server's local time.
+func parseLocalTZ(r *http.Request) (*time.Location, error) {
+	if r.URL.Query().Get("tz") == "" {
+		return time.Local, nil
+	}
+	return parseTZQuery(r)
+}
+
+// liteQuery carries the query string across lite links, so the timezone and
+// filters survive moving between the agenda and an event.
+func liteQuery(r *http.Request) template.URL {
+	if r.URL.RawQuery == "" {
+		return ""
+	}
+	return template.URL("?" + url.Values(r.URL.Query()).Encode())
+}
+
+// liteWhen describes when e happens, in loc for timed events and in the
+// event's own timezone for all-day ones.
+func liteWhen(e schemas.Event, loc *time.Location) string {
+	if e.AllDay {
+		if l, err := time.LoadLocation(e.Timezone); err == nil {
+			loc = l
+		}
+		start := e.StartTime.In(loc)
+		out := start.Format("Mon 2 Jan 2006")
+		if e.EndTime != nil {
+			// All-day ends are exclusive
+			last := e.EndTime.In(loc).AddDate(0, 0, -1)
+			if last.After(start) {
+				out += " - " + last.Format("Mon 2 Jan 2006")
+			}
+		}
+		return out + ", all day"
+	}
+
+	start := e.StartTime.In(loc)
+	out := start.Format("Mon 2 Jan 2006 15:04")
+	if e.EndTime != nil {
+		end := e.EndTime.In(loc)
+		if end.Year() == start.Year() && end.YearDay() == start.YearDay() {
+			out += " - " + end.Format("15:04")
+		} else {
+			out += " - " + end.Format("Mon 2 Jan 2006 15:04")
+		}
+	}
+	return out + " (" + loc.String() + ")"
+}
+
+// renderLite executes the named page into a buffer first, so a template
+// error becomes a 500 instead of a half-written page.
+func renderLite(w http.ResponseWriter, name string, data any) {
+	var buf bytes.Buffer
+	if err := liteTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		log.Printf("lite: render %s: %v", name, err)
+		http.Error(w, "failed to render page", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pical/database/schemas"
+	"strings"
+)
+
+// mergeHandler serves /api/admin/merge/people and /api/admin/merge/calendars,
+// for folding a duplicate, like a "dad" typed for "Dad", into the one to
+// keep. With ?dryRun=true it reports what would move and changes nothing.
+func (s *Server) mergeHandler(w http.ResponseWriter, r *http.Request) {
+	kind := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/merge/"), "/")
+	merge := schemas.MergePeople
+	switch kind {
+	case "people":
+	case "calendars":
+		merge = schemas.MergeCalendars
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+
+	var in MergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !validID(w, "from", in.From) || !validID(w, "into", in.Into) {
+		return
+	}
+	if in.From == in.Into {
+		http.Error(w, "from and into are the same", http.StatusBadRequest)
+		return
+	}
+
+	dryRun := parseBoolQuery(r, "dryRun")
+	result, err := merge(r.Context(), s.DB, in.From, in.Into, dryRun)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "from or into not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// No pushes: one per event would bury whoever they belong to
+	if !dryRun {
+		for _, e := range result.Events {
+			if e.DeletedAt == nil {
+				s.emitChange(r.Context(), schemas.WebhookEventUpdated, e)
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, MergeReport{Events: result.Events, Moved: result.Moved, DryRun: dryRun})
+}
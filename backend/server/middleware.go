@@ -2,10 +2,34 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"pical/database"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// QueryCountMiddleware logs requests that run more than limit queries, with
+// the stack of the first query over, to catch N+1 patterns. It only sees
+// queries when the database was opened with CountQueries.
+func QueryCountMiddleware(limit int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, qc := database.WithQueryCounter(r.Context(), limit)
+			next.ServeHTTP(w, r.WithContext(ctx))
+
+			if n, stack := qc.Count(); n > limit {
+				log.Printf("%s %s ran %d queries (limit %d); first query over the limit:\n%s", r.Method, r.URL.Path, n, limit, stack)
+			}
+		})
+	}
+}
+
 func TimeoutMiddleware(d time.Duration) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -15,3 +39,301 @@ func TimeoutMiddleware(d time.Duration) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+const (
+	// clientTimeHeader carries the client's clock as RFC 3339; the standard Date header also works.
+	clientTimeHeader = "X-Client-Time"
+	serverTimeHeader = "X-Server-Time"
+	// clockSkewHeader is the client's clock minus the server's, in seconds.
+	clockSkewHeader = "X-Clock-Skew"
+)
+
+// clientTime reads the time the client says it sent the request.
+func clientTime(r *http.Request) (time.Time, bool) {
+	if v := r.Header.Get(clientTimeHeader); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		return t, err == nil
+	}
+	if v := r.Header.Get("Date"); v != "" {
+		t, err := http.ParseTime(v)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// ClockMiddleware stamps every response with the server time and, when the
+// client sent its own, the skew between the two. Mutations from a client
+// more than maxSkew off are refused, since the times they carry can't be
+// trusted; zero disables the check.
+func ClockMiddleware(maxSkew time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			now := time.Now()
+			w.Header().Set(serverTimeHeader, now.UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+
+			if ct, ok := clientTime(r); ok {
+				skew := ct.Sub(now)
+				w.Header().Set(clockSkewHeader, strconv.FormatFloat(skew.Seconds(), 'f', 3, 64))
+
+				mutating := r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+				if mutating && maxSkew > 0 && (skew > maxSkew || -skew > maxSkew) {
+					direction := "ahead of"
+					if skew < 0 {
+						direction, skew = "behind", -skew
+					}
+					msg := fmt.Sprintf("client clock is %s %s the server; fix the device time (see /api/time) and retry", skew.Round(time.Second), direction)
+					http.Error(w, msg, http.StatusBadRequest)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ParseTrustedProxies reads a comma-separated list of proxy addresses, each
+// an IP or a CIDR range like 10.0.0.0/8.
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0)
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if strings.Contains(v, "/") {
+			p, err := netip.ParsePrefix(v)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", v, err)
+			}
+			out = append(out, p.Masked())
+			continue
+		}
+		ip, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", v, err)
+		}
+		out = append(out, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+	}
+	return out, nil
+}
+
+func trustedProxy(trusted []netip.Prefix, addr string) bool {
+	ip, err := netip.ParseAddr(strings.TrimSpace(addr))
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RealIPMiddleware replaces the request's RemoteAddr with the client's own
+// address when it came through one of the trusted proxies. X-Forwarded-For
+// is read from the right, skipping further trusted hops, since everything
+// left of the last proxy is whatever the client chose to send; X-Real-IP is
+// used when there is no X-Forwarded-For. Requests from anywhere else keep
+// their address and have these headers ignored.
+func RealIPMiddleware(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer, port, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil || !trustedProxy(trusted, peer) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client := ""
+			hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := strings.TrimSpace(hops[i])
+				if hop == "" {
+					continue
+				}
+				client = hop
+				if !trustedProxy(trusted, hop) {
+					break
+				}
+			}
+			if client == "" {
+				client = strings.TrimSpace(r.Header.Get("X-Real-IP"))
+			}
+			if ip, err := netip.ParseAddr(client); err == nil {
+				r2 := r.Clone(r.Context())
+				r2.RemoteAddr = net.JoinHostPort(ip.Unmap().String(), port)
+				r = r2
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIP is the address a request came from, after RealIPMiddleware.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// BreakerMiddleware answers 503 while b is open, so requests fail at once
+// instead of waiting on a database that isn't answering. A nil b lets
+// everything through.
+func BreakerMiddleware(b *database.Breaker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if b == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if open, wait := b.Rejecting(); open {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "database unavailable; try again shortly", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ConcurrencyMiddleware runs at most n requests to the handler it wraps at
+// once. The rest queue for up to wait and are then answered 429, so a few
+// displays refreshing together can't tie the Pi up with one kind of heavy
+// request. Each handler it wraps gets its own n slots; n of zero or less
+// lets everything through.
+func ConcurrencyMiddleware(n int, wait time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if n <= 0 {
+			return next
+		}
+		slots := make(chan struct{}, n)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case slots <- struct{}{}:
+			case <-timer.C:
+				w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(wait.Seconds())), 1)))
+				http.Error(w, "too many requests like this one at once; try again shortly", http.StatusTooManyRequests)
+				return
+			case <-r.Context().Done():
+				// The client gave up while queued
+				return
+			}
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// routeGroup registers routes that share a middleware chain, so public,
+// device, authenticated and admin endpoints can each be wrapped differently.
+// The first middleware is the outermost. Patterns are recorded on the
+// server, so the API spec can be checked against them; deprecated aliases
+// aren't.
+type routeGroup struct {
+	s  *Server
+	mw []func(http.Handler) http.Handler
+}
+
+func newRouteGroup(s *Server, mw ...func(http.Handler) http.Handler) routeGroup {
+	return routeGroup{s: s, mw: mw}
+}
+
+// Handle registers pattern as it is, for routes outside the versioned API.
+func (g routeGroup) Handle(pattern string, h http.Handler) {
+	g.register(pattern, h)
+	g.s.routePatterns = append(g.s.routePatterns, pattern)
+}
+
+// HandleAPI registers pattern, relative to the API root like "/events",
+// under Config.APIPrefix. Handlers see the path with the prefix cut off.
+// Until clients have moved over, it is also served under /api as before,
+// as a deprecated alias.
+func (g routeGroup) HandleAPI(pattern string, h http.Handler) {
+	prefix := g.s.Config.APIPrefix
+	g.Handle(prefix+pattern, http.StripPrefix(prefix, h))
+	g.HandleAlias("/api", pattern, h)
+}
+
+// HandleAlias also serves the API route pattern under old, marking its
+// responses deprecated in favour of the one under Config.APIPrefix.
+func (g routeGroup) HandleAlias(old, pattern string, h http.Handler) {
+	if old == g.s.Config.APIPrefix {
+		return
+	}
+	g.register(old+pattern, deprecatedAlias(old, g.s.Config.APIPrefix)(http.StripPrefix(old, h)))
+}
+
+func (g routeGroup) register(pattern string, h http.Handler) {
+	for i := len(g.mw) - 1; i >= 0; i-- {
+		h = g.mw[i](h)
+	}
+	g.s.Mux.Handle(pattern, h)
+}
+
+// deprecatedAlias marks responses from a route's old path under old with a
+// Deprecation header and a Link to the same path under prefix.
+func deprecatedAlias(old, prefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", "<"+prefix+strings.TrimPrefix(r.URL.Path, old)+`>; rel="successor-version"`)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ParseAPIPrefix checks the path the versioned API is served under, such
+// as "/api/v1", dropping any trailing slash.
+func ParseAPIPrefix(v string) (string, error) {
+	v = strings.TrimRight(strings.TrimSpace(v), "/")
+	if !strings.HasPrefix(v, "/") || strings.ContainsAny(v, "{}?#") {
+		return "", fmt.Errorf("api prefix %q must be a path like /api/v1", v)
+	}
+	return v, nil
+}
+
+// PublicCORSMiddleware lets pages on any origin read the response, for
+// endpoints meant to be fetched from elsewhere such as published feeds.
+// Preflight requests are answered here.
+func PublicCORSMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NoStoreMiddleware keeps responses out of caches, for admin endpoints whose
+// responses can carry secrets such as freshly issued feed tokens.
+func NoStoreMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// FieldsMiddleware lets slow clients ask for only what they render, e.g.
+// ?fields=title,startTime,color. The selection is applied by writeJSON, so
+// it covers every JSON response behind it and leaves other bodies alone.
+func FieldsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.URL.Query().Get("fields"); v != "" {
+			if fs := parseFields(v); len(fs) > 0 {
+				jw := jsonOptions(w)
+				jw.fields = fs
+				w = jw
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"pical/database/schemas"
+	"pical/recurrence"
+	"slices"
+	"sort"
+	"time"
+)
+
+// maxOccurrenceRange caps how far apart from and to may be.
+const maxOccurrenceRange = 366 * 24 * time.Hour
+
+func (s *Server) getOccurrences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	calendar := r.URL.Query().Get("calendar")
+	if calendar != "" && !validID(w, "calendar", calendar) {
+		return
+	}
+	s.writeOccurrences(w, r, schemas.EventFilter{CalendarID: calendar})
+}
+
+// writeOccurrences expands the events matching filter into their occurrences
+// within [from, to). Date-only bounds are read in ?tz (default UTC), and a
+// date-only to includes that day. ?groupBy nests them by person and/or date.
+func (s *Server) writeOccurrences(w http.ResponseWriter, r *http.Request, filter schemas.EventFilter) {
+	from, to, err := parseOccurrenceRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	groupBy, err := parseGroupBy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out, err := s.expandOccurrences(r.Context(), filter, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if groupBy != "" {
+		// Already checked by parseOccurrenceRange
+		loc, _ := parseTZQuery(r)
+		grouped, err := s.groupOccurrences(r.Context(), groupBy, filter, out, loc, from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, grouped)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// parseOccurrenceRange reads ?from and ?to, which may be at most
+// maxOccurrenceRange apart.
+func parseOccurrenceRange(r *http.Request) (time.Time, time.Time, error) {
+	q := r.URL.Query()
+	loc, err := parseTZQuery(r)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	from, err := parseRangeBound(q.Get("from"), loc, false)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("from: %v", err)
+	}
+	to, err := parseRangeBound(q.Get("to"), loc, true)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("to: %v", err)
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must be after from")
+	}
+	if to.Sub(from) > maxOccurrenceRange {
+		return time.Time{}, time.Time{}, fmt.Errorf("range may cover at most 366 days")
+	}
+	return from, to, nil
+}
+
+// parseTZQuery reads ?tz, defaulting to UTC.
+func parseTZQuery(r *http.Request) (*time.Location, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", tz)
+	}
+	return loc, nil
+}
+
+// expandOccurrences returns every occurrence of the events matching filter
+// that overlaps [from, to), sorted by start. Unfiltered ranges are kept in
+// s.warmed until an event changes. Ranges inside the materialization
+// horizon are read from the occurrence cache; anything else is expanded on
+// the spot.
+func (s *Server) expandOccurrences(ctx context.Context, filter schemas.EventFilter, from, to time.Time) ([]EventOccurrence, error) {
+	if filter != (schemas.EventFilter{}) {
+		return s.expandUncached(ctx, filter, from, to)
+	}
+
+	seq, err := schemas.LatestEventChangeSeq(ctx, s.DB)
+	if err != nil {
+		return nil, err
+	}
+	if occs, ok := s.warmed.get(seq, from, to); ok {
+		// Ticked off and joinable change without the event changing
+		if err := s.markCompleted(ctx, occs); err != nil {
+			return nil, err
+		}
+		s.markJoinable(occs, time.Now())
+		return occs, nil
+	}
+
+	occs, err := s.expandUncached(ctx, filter, from, to)
+	if err != nil {
+		return nil, err
+	}
+	s.warmed.put(seq, from, to, occs)
+	return occs, nil
+}
+
+func (s *Server) expandUncached(ctx context.Context, filter schemas.EventFilter, from, to time.Time) ([]EventOccurrence, error) {
+	if s.Config.RecurrenceHorizonMonths > 0 {
+		windowFrom, windowTo := s.occurrenceWindow(time.Now())
+		if !from.Before(windowFrom) && !to.After(windowTo) {
+			return s.cachedOccurrences(ctx, filter, from, to, windowFrom, windowTo)
+		}
+	}
+
+	events, err := s.Events.ListEventsInRange(ctx, filter, from, to)
+	if err != nil {
+		return nil, err
+	}
+	// Only series have exceptions
+	exs := make(map[string][]schemas.Exception)
+	if slices.ContainsFunc(events, recurrence.Recurs) {
+		if exs, err = schemas.ListAllExceptions(ctx, s.DB); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]EventOccurrence, 0, len(events))
+	for _, e := range events {
+		for _, occ := range recurrence.Expand(e, exs[e.EventID], from, to) {
+			out = append(out, EventOccurrence{
+				Event:        e,
+				RecurrenceID: occ.RecurrenceID,
+				StartTime:    occ.StartTime,
+				EndTime:      occ.EndTime,
+				Moved:        occ.Moved,
+			})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].StartTime.Before(out[j].StartTime) })
+
+	if err := s.markCompleted(ctx, out); err != nil {
+		return nil, err
+	}
+	s.markJoinable(out, time.Now())
+	return out, nil
+}
+
+func (s *Server) cachedOccurrences(ctx context.Context, filter schemas.EventFilter, from, to, windowFrom, windowTo time.Time) ([]EventOccurrence, error) {
+	// Catch up on edits the background materializer hasn't reached yet
+	if err := s.materializeOccurrences(ctx, windowFrom, windowTo); err != nil {
+		return nil, err
+	}
+
+	cached, err := schemas.ListCachedOccurrences(ctx, s.DB, filter, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]EventOccurrence, 0, len(cached))
+	for _, c := range cached {
+		out = append(out, EventOccurrence{
+			Event:        c.Event,
+			RecurrenceID: c.Occurrence.RecurrenceID,
+			StartTime:    c.Occurrence.StartTime,
+			EndTime:      c.Occurrence.EndTime,
+			Moved:        c.Occurrence.Moved,
+		})
+	}
+	if err := s.markCompleted(ctx, out); err != nil {
+		return nil, err
+	}
+	s.markJoinable(out, time.Now())
+	return out, nil
+}
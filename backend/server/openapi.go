@@ -0,0 +1,658 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Served by the docs page, from a CDN, so the binary doesn't carry it
+const swaggerUIVersion = "5.17.14"
+
+// apiOperation documents one method on one path. The request and response
+// schemas are read off Go values of the types the handler decodes and
+// writes, so they follow those types as they change.
+type apiOperation struct {
+	method  string
+	path    string
+	summary string
+	// "public", "device", "authenticated", "admin" or "redirect", as in routes
+	access string
+	query  []apiParam
+	// A value of the JSON body's type; nil for none
+	body any
+	// Content type of a body that isn't JSON; multipart ones carry a "file"
+	upload string
+	status int
+	// Set when it answers 201 instead on creating something
+	orCreated bool
+	// A value of the response's type, or oneOf when ?groupBy and the like
+	// change its shape; nil for none
+	response any
+	// Content type of a response that isn't JSON
+	produces string
+	// Set for routes that stay where they are outside the API prefix
+	unversioned bool
+}
+
+// fullPath is where op is served with the API under prefix.
+func (op apiOperation) fullPath(prefix string) string {
+	if op.unversioned {
+		return op.path
+	}
+	return prefix + op.path
+}
+
+type apiParam struct {
+	name, description string
+}
+
+// oneOf is a response that comes in any of these shapes.
+type oneOf []any
+
+// apiDoc is the parts of OpenAPI 3.0 the spec uses.
+type apiDoc struct {
+	OpenAPI    string                       `json:"openapi"`
+	Info       apiInfo                      `json:"info"`
+	Paths      map[string]map[string]*apiOp `json:"paths"`
+	Components apiComponents                `json:"components"`
+	Security   []map[string][]string        `json:"security,omitempty"`
+	Tags       []map[string]string          `json:"tags,omitempty"`
+	ops        map[string]map[string]apiOperation
+}
+
+type apiInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type apiComponents struct {
+	Schemas         map[string]*jsonSchema       `json:"schemas"`
+	SecuritySchemes map[string]map[string]string `json:"securitySchemes"`
+}
+
+type apiOp struct {
+	Summary     string                 `json:"summary"`
+	Description string                 `json:"description,omitempty"`
+	Tags        []string               `json:"tags"`
+	Parameters  []apiOpParam           `json:"parameters,omitempty"`
+	RequestBody *apiBody               `json:"requestBody,omitempty"`
+	Responses   map[string]apiResponse `json:"responses"`
+	Security    *[]map[string][]string `json:"security,omitempty"`
+}
+
+type apiOpParam struct {
+	Name        string      `json:"name"`
+	In          string      `json:"in"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Schema      *jsonSchema `json:"schema"`
+}
+
+type apiBody struct {
+	Required bool                      `json:"required,omitempty"`
+	Content  map[string]apiContentType `json:"content"`
+}
+
+type apiResponse struct {
+	Description string                    `json:"description"`
+	Content     map[string]apiContentType `json:"content,omitempty"`
+}
+
+type apiContentType struct {
+	Schema *jsonSchema `json:"schema"`
+}
+
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Nullable             bool                   `json:"nullable,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	AllOf                []*jsonSchema          `json:"allOf,omitempty"`
+	OneOf                []*jsonSchema          `json:"oneOf,omitempty"`
+}
+
+// buildAPIDoc turns ops into an OpenAPI document, with the API under prefix.
+func buildAPIDoc(ops []apiOperation, prefix string) apiDoc {
+	g := schemaGen{schemas: make(map[string]*jsonSchema), names: make(map[reflect.Type]string)}
+	doc := apiDoc{
+		OpenAPI: "3.0.3",
+		Info:    apiInfo{Title: "PiCal", Version: Version},
+		Paths:   make(map[string]map[string]*apiOp),
+		Components: apiComponents{
+			Schemas: g.schemas,
+			SecuritySchemes: map[string]map[string]string{
+				"token": {"type": "http", "scheme": "bearer", "description": "An API token, also accepted as ?access_token"},
+			},
+		},
+		Security: []map[string][]string{{"token": {}}},
+		ops:      make(map[string]map[string]apiOperation),
+	}
+
+	tags := make(map[string]bool)
+	for _, op := range ops {
+		tag := apiTag(op.path)
+		tags[tag] = true
+		op.path = op.fullPath(prefix)
+		out := &apiOp{
+			Summary:   op.summary,
+			Tags:      []string{tag},
+			Responses: make(map[string]apiResponse),
+		}
+		switch op.access {
+		case "public", "device", "redirect":
+			out.Security = &[]map[string][]string{}
+		case "admin":
+			out.Description = "Needs an API token with the admin scope when one is sent."
+		}
+
+		for _, name := range pathParams(op.path) {
+			out.Parameters = append(out.Parameters, apiOpParam{Name: name, In: "path", Required: true, Schema: &jsonSchema{Type: "string"}})
+		}
+		for _, p := range op.query {
+			out.Parameters = append(out.Parameters, apiOpParam{Name: p.name, In: "query", Description: p.description, Schema: &jsonSchema{Type: "string"}})
+		}
+
+		switch {
+		case op.body != nil:
+			out.RequestBody = &apiBody{Required: true, Content: map[string]apiContentType{
+				"application/json": {Schema: g.schemaOf(op.body)},
+			}}
+		case op.upload == "multipart/form-data":
+			out.RequestBody = &apiBody{Required: true, Content: map[string]apiContentType{
+				op.upload: {Schema: &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{"file": {Type: "string", Format: "binary"}}}},
+			}}
+		case op.upload != "":
+			out.RequestBody = &apiBody{Required: true, Content: map[string]apiContentType{
+				op.upload: {Schema: &jsonSchema{Type: "string", Format: "binary"}},
+			}}
+		}
+
+		res := apiResponse{Description: http.StatusText(op.status)}
+		switch {
+		case op.produces != "":
+			res.Content = map[string]apiContentType{op.produces: {Schema: &jsonSchema{Type: "string", Format: "binary"}}}
+		case op.response != nil:
+			res.Content = map[string]apiContentType{"application/json": {Schema: g.schemaOf(op.response)}}
+		}
+		out.Responses[fmt.Sprint(op.status)] = res
+		if op.orCreated {
+			out.Responses["201"] = apiResponse{Description: http.StatusText(http.StatusCreated), Content: res.Content}
+		}
+		out.Responses["default"] = apiResponse{
+			Description: "An error, explained in plain text",
+			Content:     map[string]apiContentType{"text/plain": {Schema: &jsonSchema{Type: "string"}}},
+		}
+
+		if doc.Paths[op.path] == nil {
+			doc.Paths[op.path] = make(map[string]*apiOp)
+			doc.ops[op.path] = make(map[string]apiOperation)
+		}
+		doc.Paths[op.path][strings.ToLower(op.method)] = out
+		doc.ops[op.path][op.method] = op
+	}
+
+	for _, tag := range slices.Sorted(maps.Keys(tags)) {
+		doc.Tags = append(doc.Tags, map[string]string{"name": tag})
+	}
+	return doc
+}
+
+// apiTag groups a path by what it is about: "/events/{id}" and "/events"
+// are both "events", "/admin/export" is "admin" and "/api/published/{file}"
+// is "published".
+func apiTag(p string) string {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if parts[0] == "api" && len(parts) > 1 {
+		parts = parts[1:]
+	}
+	tag, _, _ := strings.Cut(parts[0], ".")
+	return tag
+}
+
+// pathParams lists the {names} in p, in order.
+func pathParams(p string) []string {
+	out := make([]string, 0)
+	for rest := p; ; {
+		_, after, ok := strings.Cut(rest, "{")
+		if !ok {
+			return out
+		}
+		name, tail, _ := strings.Cut(after, "}")
+		out = append(out, name)
+		rest = tail
+	}
+}
+
+// schemaGen writes JSON schemas for Go types the way encoding/json
+// serializes them, with named structs as components.
+type schemaGen struct {
+	schemas map[string]*jsonSchema
+	names   map[reflect.Type]string
+}
+
+var rawJSONType = reflect.TypeFor[json.RawMessage]()
+
+func (g *schemaGen) schemaOf(v any) *jsonSchema {
+	if alts, ok := v.(oneOf); ok {
+		out := &jsonSchema{}
+		for _, alt := range alts {
+			out.OneOf = append(out.OneOf, g.schemaOf(alt))
+		}
+		return out
+	}
+	return g.schema(reflect.TypeOf(v))
+}
+
+func (g *schemaGen) schema(t reflect.Type) *jsonSchema {
+	switch {
+	case t == timeType:
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case t == rawJSONType:
+		return &jsonSchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schema(t.Elem())
+		if s.Ref != "" {
+			return &jsonSchema{AllOf: []*jsonSchema{s}, Nullable: true}
+		}
+		s.Nullable = true
+		return s
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &jsonSchema{Type: "string", Format: "byte"}
+		}
+		// A nil slice is written as null
+		return &jsonSchema{Type: "array", Items: g.schema(t.Elem()), Nullable: t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: g.schema(t.Elem()), Nullable: true}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = g.componentName(t)
+			g.names[t] = name
+			// Registered before its fields, so a type that contains itself
+			// refers back to the component
+			g.schemas[name] = &jsonSchema{}
+			*g.schemas[name] = *g.object(t)
+		}
+		return &jsonSchema{Ref: "#/components/schemas/" + name}
+	}
+	return &jsonSchema{}
+}
+
+// componentName is t's name, with the package in front when another
+// package has a type by that name too, and type arguments spelt out:
+// PagedResponse[schemas.Event] is PagedResponseOfEvent.
+func (g *schemaGen) componentName(t reflect.Type) string {
+	name := t.Name()
+	if base, args, ok := strings.Cut(name, "["); ok {
+		name = base + "Of"
+		for _, arg := range strings.Split(strings.TrimSuffix(args, "]"), ",") {
+			name += arg[strings.LastIndexByte(arg, '.')+1:]
+		}
+	}
+	for other, taken := range g.names {
+		if taken == name && other != t {
+			return path.Base(t.PkgPath()) + "." + name
+		}
+	}
+	return name
+}
+
+// object is the schema of a struct's fields, embedded ones included.
+func (g *schemaGen) object(t reflect.Type) *jsonSchema {
+	out := &jsonSchema{Type: "object", Properties: make(map[string]*jsonSchema)}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || len(f.Index) > 1 && !visibleEmbedded(t, f) {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			// Its fields are listed in its place
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s := g.schema(f.Type)
+		if slices.Contains(strings.Split(opts, ","), "string") {
+			s = &jsonSchema{Type: "string"}
+		}
+		out.Properties[name] = s
+	}
+	return out
+}
+
+// visibleEmbedded reports whether f, promoted from an embedded struct, is
+// written by encoding/json: every struct it passes through must be
+// embedded without a name of its own.
+func visibleEmbedded(t reflect.Type, f reflect.StructField) bool {
+	for i := 1; i < len(f.Index); i++ {
+		outer := t.FieldByIndex(f.Index[:i])
+		if !outer.Anonymous || strings.Split(outer.Tag.Get("json"), ",")[0] != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAPISpec makes sure the documented operations and the routes agree:
+// every path in the doc reaches a route, and every route is reached by
+// some documented path. Paths that reach no route end up at "/", the
+// frontend.
+func (s *Server) checkAPISpec(ops []apiOperation) error {
+	documented := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, op := range ops {
+		path := op.fullPath(s.Config.APIPrefix)
+		key := op.method + " " + path
+		if seen[key] {
+			return fmt.Errorf("api spec: %s is documented twice", key)
+		}
+		seen[key] = true
+
+		_, pattern := s.Mux.Handler(&http.Request{Method: op.method, URL: &url.URL{Path: samplePath(path)}})
+		if pattern == "/" || pattern == "" {
+			return fmt.Errorf("api spec: %s doesn't reach any route", key)
+		}
+		documented[pattern] = true
+	}
+	for _, pattern := range s.routePatterns {
+		if pattern != "/" && !documented[pattern] {
+			return fmt.Errorf("api spec: route %s isn't documented", pattern)
+		}
+	}
+	return nil
+}
+
+// samplePath fills p's parameters in with an ID, to see where it routes.
+func samplePath(p string) string {
+	for _, name := range pathParams(p) {
+		p = strings.Replace(p, "{"+name+"}", "00000000-0000-4000-8000-000000000000", 1)
+	}
+	return p
+}
+
+// lookup finds the operation documented for r, if any. Where several
+// paths match, like "/api/v1/events/{id}.ics" and "/api/v1/events/{id}", the one
+// with the most literal text wins, as the handlers check those first.
+func (d apiDoc) lookup(r *http.Request) (apiOperation, bool) {
+	var best apiOperation
+	found, bestLen := false, -1
+	for p, byMethod := range d.ops {
+		op, ok := byMethod[r.Method]
+		if !ok || !matchPath(p, r.URL.Path) {
+			continue
+		}
+		literal := len(p)
+		for _, name := range pathParams(p) {
+			literal -= len(name) + 2
+		}
+		if literal > bestLen {
+			best, found, bestLen = op, true, literal
+		}
+	}
+	return best, found
+}
+
+// matchPath reports whether path is an instance of template, whose
+// segments can hold a parameter between literal text, like "{id}.ics".
+func matchPath(template, path string) bool {
+	want := strings.Split(strings.Trim(template, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	if len(want) != len(got) {
+		return false
+	}
+	for i, seg := range want {
+		before, rest, param := strings.Cut(seg, "{")
+		if !param {
+			if seg != got[i] {
+				return false
+			}
+			continue
+		}
+		_, after, _ := strings.Cut(rest, "}")
+		v, ok := strings.CutPrefix(got[i], before)
+		if !ok {
+			return false
+		}
+		if v, ok = strings.CutSuffix(v, after); !ok || v == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// specCheckMiddleware logs where responses stray from the spec: routes it
+// doesn't document, success statuses it doesn't list, and JSON bodies
+// that don't fit the documented schema. Deprecated aliases are left out,
+// as they answer like the routes they stand for. It is for development,
+// since it holds every response in memory to check it.
+func specCheckMiddleware(doc apiDoc, prefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &specWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+
+			if sw.Header().Get("Deprecation") != "" {
+				return
+			}
+			op, ok := doc.lookup(r)
+			switch {
+			case !ok:
+				if sw.status != http.StatusNotFound && sw.status != http.StatusMethodNotAllowed && isAPIPath(r.URL.Path, prefix) {
+					log.Printf("api spec: %s %s answered %d but isn't documented", r.Method, r.URL.Path, sw.status)
+				}
+				return
+			case sw.status >= 300 || sw.status == http.StatusSwitchingProtocols:
+				return
+			case sw.status != op.status && !(op.orCreated && sw.status == http.StatusCreated):
+				log.Printf("api spec: %s %s answered %d; the spec says %d", r.Method, r.URL.Path, sw.status, op.status)
+			}
+
+			mediaType, _, _ := mime.ParseMediaType(sw.Header().Get("Content-Type"))
+			if op.response == nil || op.produces != "" || mediaType != "application/json" {
+				return
+			}
+			var body any
+			if err := json.Unmarshal(sw.body.Bytes(), &body); err != nil {
+				log.Printf("api spec: %s %s answered with invalid JSON: %v", r.Method, r.URL.Path, err)
+				return
+			}
+			schema := doc.Paths[op.path][strings.ToLower(op.method)].Responses[fmt.Sprint(sw.status)].Content["application/json"].Schema
+			for _, problem := range doc.validate(body, schema, "$") {
+				log.Printf("api spec: %s %s: %s", r.Method, r.URL.Path, problem)
+			}
+		})
+	}
+}
+
+// isAPIPath reports whether p is the API's, under prefix, rather than the
+// frontend's.
+func isAPIPath(p, prefix string) bool {
+	return strings.HasPrefix(p, prefix+"/") || strings.HasPrefix(p, "/api/")
+}
+
+// specWriter passes a response through and keeps all of it.
+type specWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (sw *specWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *specWriter) Write(b []byte) (int, error) {
+	sw.body.Write(b)
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *specWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// Most problems validate reports for one response, so a wrong list doesn't
+// flood the log
+const maxSpecProblems = 5
+
+// validate lists where v, decoded JSON at where, doesn't fit s.
+func (d apiDoc) validate(v any, s *jsonSchema, where string) []string {
+	problems := make([]string, 0)
+	d.check(v, s, where, &problems)
+	return problems
+}
+
+func (d apiDoc) check(v any, s *jsonSchema, where string, problems *[]string) {
+	if len(*problems) >= maxSpecProblems {
+		return
+	}
+	if s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	if len(s.AllOf) == 1 {
+		inner := *d.resolve(s.AllOf[0])
+		inner.Nullable = s.Nullable
+		s = &inner
+	}
+	if len(s.OneOf) > 0 {
+		for _, alt := range s.OneOf {
+			if len(d.validate(v, alt, where)) == 0 {
+				return
+			}
+		}
+		*problems = append(*problems, where+" fits none of the shapes the spec allows")
+		return
+	}
+	if v == nil {
+		if !s.Nullable && s.Type != "" {
+			*problems = append(*problems, where+" is null")
+		}
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		m, ok := v.(map[string]any)
+		if !ok {
+			*problems = append(*problems, where+" isn't an object")
+			return
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			switch prop, known := s.Properties[k]; {
+			case known:
+				d.check(m[k], prop, where+"."+k, problems)
+			case s.AdditionalProperties != nil:
+				d.check(m[k], s.AdditionalProperties, where+"."+k, problems)
+			default:
+				*problems = append(*problems, where+"."+k+" isn't in the spec")
+			}
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			*problems = append(*problems, where+" isn't an array")
+			return
+		}
+		for i, item := range items {
+			d.check(item, s.Items, fmt.Sprintf("%s[%d]", where, i), problems)
+		}
+	case "string":
+		_, isString := v.(string)
+		// ?timeFormat=epochMillis writes times as numbers
+		_, isNumber := v.(float64)
+		if !isString && !(isNumber && s.Format == "date-time") {
+			*problems = append(*problems, where+" isn't a string")
+		}
+	case "integer", "number":
+		if _, ok := v.(float64); !ok {
+			*problems = append(*problems, where+" isn't a number")
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			*problems = append(*problems, where+" isn't a boolean")
+		}
+	}
+}
+
+func (d apiDoc) resolve(s *jsonSchema) *jsonSchema {
+	if s.Ref != "" {
+		return d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+// getOpenAPISpec serves the spec as JSON.
+func (s *Server) getOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.apiDoc)
+}
+
+// getAPIDocs serves Swagger UI, pointed at the spec.
+func (s *Server) getAPIDocs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, apiDocsPage, swaggerUIVersion, s.Config.APIPrefix+"/docs/openapi.json")
+}
+
+const apiDocsPage = `<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>PiCal API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@%[1]s/swagger-ui.css">
+</head>
+<body>
+<div id="docs"></div>
+<script src="https://unpkg.com/swagger-ui-dist@%[1]s/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "%[2]s", dom_id: "#docs"});</script>
+</body>
+</html>
+`
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"pical/database/schemas"
+	"pical/integrations"
+)
+
+var (
+	pageQuery = []apiParam{
+		{"limit", "Most items to return"},
+		{"offset", "Items to skip"},
+	}
+	filterQuery = []apiParam{
+		{"calendar", "Calendar ID"},
+		{"person", "Person ID"},
+		{"tag", "Tag name"},
+		{"q", "Text in the title or notes"},
+		{"allDay", "true or false"},
+		{"recurring", "true or false"},
+		{"focus", "true or false"},
+		{"createdAfter", "Time or date"},
+		{"createdBefore", "Time or date"},
+		{"updatedAfter", "Time or date"},
+		{"updatedBefore", "Time or date"},
+	}
+	rangeQuery = []apiParam{
+		{"from", "Start of the range, a time or date"},
+		{"to", "End of the range, a time or date"},
+		{"tz", "IANA zone dates are read in"},
+	}
+	groupQuery  = []apiParam{{"groupBy", "person, date or date,person"}}
+	dryRunQuery = []apiParam{{"dryRun", "true to report the change without making it"}}
+	importQuery = []apiParam{
+		{"person", "Who events without one belong to"},
+		{"timezone", "IANA zone for times without one"},
+		{"dryRun", "true to report the import without saving it"},
+	}
+)
+
+// params joins query parameter lists.
+func params(lists ...[]apiParam) []apiParam {
+	out := make([]apiParam, 0)
+	for _, l := range lists {
+		out = append(out, l...)
+	}
+	return out
+}
+
+// apiOperations is every route's methods, for the spec at /api/v1/docs.
+// Paths are relative to Config.APIPrefix unless unversioned. New refuses
+// to start when these and the routes disagree.
+var apiOperations = []apiOperation{
+	{method: "GET", path: "/health", summary: "Liveness check", access: "public", unversioned: true, status: 200, produces: "text/plain"},
+	{method: "GET", path: "/readyz", summary: "Readiness, with database and backup status", access: "public", unversioned: true, status: 200, response: ReadyStatus{}},
+	{method: "GET", path: "/time", summary: "Server time and clock skew", access: "public", status: 200, response: ServerTime{}},
+	{method: "GET", path: "/version", summary: "Version and optional features", access: "public", status: 200, response: VersionInfo{}},
+	{method: "GET", path: "/docs", summary: "This documentation", access: "public", status: 200, produces: "text/html"},
+	{method: "GET", path: "/docs/openapi.json", summary: "The OpenAPI spec", access: "public", status: 200, response: map[string]any{}},
+	{method: "GET", path: "/api/published/{secret}.ics", summary: "A published feed", access: "public", unversioned: true, status: 200, produces: "text/calendar"},
+	{method: "GET", path: "/api/rsvp/{secret}", summary: "An invitation, from its RSVP link", access: "public", unversioned: true, status: 200, response: RSVPInvitation{}},
+	{method: "PUT", path: "/api/rsvp/{secret}", summary: "Answer an invitation from its RSVP link", access: "public", unversioned: true, body: RSVPRequest{}, status: 200, response: RSVPInvitation{}},
+
+	{method: "POST", path: "/devices/{id}/heartbeat", summary: "Report a display is alive", access: "device", status: 200, response: Heartbeat{}},
+
+	{method: "GET", path: "/events", summary: "List events, or get several by ID", access: "authenticated",
+		query:  params(filterQuery, pageQuery, []apiParam{{"sort", "Field to sort by, - first for descending"}, {"cursor", "nextCursor of the page before"}, {"ids", "Comma-separated event IDs, instead of a page; ones that don't exist are left out"}}),
+		status: 200, response: oneOf{PagedResponse[schemas.Event]{}, []schemas.Event{}}},
+	{method: "POST", path: "/events", summary: "Create an event", access: "authenticated",
+		query:  []apiParam{{"conflicts", "true to warn about overlaps too"}, {"strict", "true to refuse with 409 when there are warnings"}},
+		body:   schemas.Event{},
+		status: 201, response: CreatedEvent{}},
+	{method: "GET", path: "/events/{id}", summary: "Get an event", access: "authenticated", status: 200, response: schemas.Event{}},
+	{method: "DELETE", path: "/events/{id}", summary: "Move an event to the trash", access: "authenticated",
+		query: []apiParam{{"permanent", "true to delete it for good"}}, status: 204},
+	{method: "DELETE", path: "/events", summary: "Move matching events to the trash", access: "authenticated",
+		query: params(filterQuery, dryRunQuery), body: BatchDeleteRequest{}, status: 200, response: BatchDeleteReport{}},
+	{method: "GET", path: "/trash", summary: "List trashed events", access: "authenticated", query: pageQuery, status: 200, response: PagedResponse[schemas.Event]{}},
+	{method: "GET", path: "/events/{id}.ics", summary: "An event as ICS", access: "authenticated", status: 200, produces: "text/calendar"},
+	{method: "PUT", path: "/events/external/{source}/{uid}", summary: "Create or update an event by its ID elsewhere", access: "authenticated",
+		body: schemas.Event{}, status: 200, orCreated: true, response: schemas.Event{}},
+	{method: "GET", path: "/events/{id}/exceptions", summary: "List a series' exceptions", access: "authenticated", query: pageQuery, status: 200, response: PagedResponse[schemas.Exception]{}},
+	{method: "POST", path: "/events/{id}/exceptions", summary: "Cancel or move one occurrence", access: "authenticated",
+		query:  []apiParam{{"conflicts", "true to warn about overlaps too"}, {"strict", "true to refuse with 409 when there are warnings"}},
+		body:   schemas.Exception{},
+		status: 201, response: CreatedException{}},
+	{method: "DELETE", path: "/events/{id}/exceptions/{recurrenceId}", summary: "Undo an exception", access: "authenticated", status: 204},
+	{method: "GET", path: "/events/{id}/attendees", summary: "List attendees", access: "authenticated", status: 200, response: []schemas.Attendee{}},
+	{method: "POST", path: "/events/{id}/attendees", summary: "Add an attendee", access: "authenticated", body: schemas.Attendee{}, status: 201, response: AddedAttendee{}},
+	{method: "POST", path: "/events/{id}/attendees/{attendeeId}", summary: "Replace an attendee's RSVP link", access: "authenticated", status: 201, response: RSVPLink{}},
+	{method: "PUT", path: "/events/{id}/attendees/{attendeeId}", summary: "Set an attendee's response", access: "authenticated", body: RSVPRequest{}, status: 200, response: schemas.Attendee{}},
+	{method: "DELETE", path: "/events/{id}/attendees/{attendeeId}", summary: "Remove an attendee", access: "authenticated", status: 204},
+	{method: "GET", path: "/events/{id}/reminders", summary: "List reminders", access: "authenticated", status: 200, response: []schemas.Reminder{}},
+	{method: "POST", path: "/events/{id}/reminders", summary: "Add a reminder", access: "authenticated", body: schemas.Reminder{}, status: 201, response: schemas.Reminder{}},
+	{method: "DELETE", path: "/events/{id}/reminders/{reminderId}", summary: "Remove a reminder", access: "authenticated", status: 204},
+	{method: "GET", path: "/events/{id}/attachments", summary: "List attachments", access: "authenticated", status: 200, response: []schemas.Attachment{}},
+	{method: "POST", path: "/events/{id}/attachments", summary: "Upload an attachment", access: "authenticated", upload: "multipart/form-data", status: 201, response: schemas.Attachment{}},
+	{method: "GET", path: "/events/{id}/attachments/{attachmentId}", summary: "Download an attachment", access: "authenticated", status: 200, produces: "application/octet-stream"},
+	{method: "DELETE", path: "/events/{id}/attachments/{attachmentId}", summary: "Remove an attachment", access: "authenticated", status: 204},
+	{method: "GET", path: "/events/{id}/completions", summary: "List a task's completions", access: "authenticated", query: pageQuery, status: 200, response: PagedResponse[schemas.TaskCompletion]{}},
+	{method: "POST", path: "/events/{id}/completions", summary: "Mark a task occurrence done", access: "authenticated", body: schemas.TaskCompletion{}, status: 201, response: schemas.TaskCompletion{}},
+	{method: "DELETE", path: "/events/{id}/completions/{recurrenceId}", summary: "Mark a task occurrence not done", access: "authenticated", status: 204},
+	{method: "GET", path: "/events/{id}/pauses", summary: "List a series' pauses", access: "authenticated", status: 200, response: []schemas.Pause{}},
+	{method: "POST", path: "/events/{id}/pauses", summary: "Pause a series between two dates", access: "authenticated", body: PauseRequest{}, status: 201, response: schemas.Pause{}},
+	{method: "DELETE", path: "/events/{id}/pauses/{pauseId}", summary: "Resume a paused series", access: "authenticated", status: 204},
+	{method: "POST", path: "/events/{id}/cancel", summary: "Cancel a series' occurrences in a range", access: "authenticated", query: dryRunQuery, body: BulkExceptionRequest{}, status: 200, response: BulkExceptionReport{}},
+	{method: "POST", path: "/events/{id}/move", summary: "Move a series' occurrences in a range", access: "authenticated", query: dryRunQuery, body: BulkExceptionRequest{}, status: 200, response: BulkExceptionReport{}},
+	{method: "POST", path: "/events/{id}/restore", summary: "Bring an event back from the trash", access: "authenticated", status: 200, response: schemas.Event{}},
+	{method: "POST", path: "/events/{id}/split", summary: "Split a series in two", access: "authenticated", body: SplitRequest{}, status: 201, response: SplitReport{}},
+	{method: "POST", path: "/events/{id}/rrule/preview-change", summary: "Preview a new rule for a series", access: "authenticated", body: RruleChange{}, status: 200, response: RruleChangePreview{}},
+
+	{method: "GET", path: "/calendar.ics", summary: "Matching events as ICS", access: "authenticated", query: filterQuery, status: 200, produces: "text/calendar"},
+	{method: "POST", path: "/import/ics", summary: "Import an ICS file", access: "authenticated", query: importQuery, upload: "text/calendar", status: 200, response: ImportReport{}},
+	{method: "POST", path: "/import/{source}", summary: "Import a CSV export", access: "authenticated", query: importQuery, upload: "multipart/form-data", status: 200, response: ImportReport{}},
+	{method: "POST", path: "/import/{source}/preview", summary: "Preview a CSV import", access: "authenticated", query: importQuery, upload: "multipart/form-data", status: 200, response: ImportPreview{}},
+
+	{method: "GET", path: "/joinable", summary: "Events with a meeting link about to start", access: "authenticated", status: 200, response: []Joinable{}},
+	{method: "GET", path: "/suggestions", summary: "Suggestions from past events", access: "authenticated",
+		query: []apiParam{{"q", "Title typed so far"}, {"limit", "Most suggestions"}}, status: 200, response: schemas.Suggestions{}},
+	{method: "GET", path: "/search", summary: "Search events", access: "authenticated",
+		query: params([]apiParam{{"q", "Search text"}, {"limit", "Most results"}}, filterQuery), status: 200, response: []schemas.SearchResult{}},
+	{method: "GET", path: "/occurrences", summary: "Occurrences in a range", access: "authenticated",
+		query:  params(rangeQuery, []apiParam{{"calendar", "Calendar ID"}}, groupQuery),
+		status: 200, response: oneOf{[]EventOccurrence{}, []PersonColumn{}, []AgendaDay{}, []PersonDay{}}},
+	{method: "GET", path: "/agenda", summary: "The days ahead", access: "authenticated",
+		query:  params([]apiParam{{"tz", "IANA zone"}, {"days", "How many days"}, {"week", "true to start on the week's first day"}}, filterQuery, groupQuery),
+		status: 200, response: oneOf{[]AgendaDay{}, []PersonDay{}, AgendaSearch{}}},
+	{method: "GET", path: "/freebusy", summary: "Busy times in a range", access: "authenticated",
+		query: params(rangeQuery, []apiParam{{"person", "Person ID"}, {"calendar", "Calendar ID"}}), status: 200, response: FreeBusy{}},
+	{method: "GET", path: "/briefing", summary: "Today's briefing as text", access: "authenticated", query: []apiParam{{"tz", "IANA zone"}}, status: 200, produces: "text/plain"},
+	{method: "GET", path: "/briefing.wav", summary: "Today's briefing spoken", access: "authenticated", query: []apiParam{{"tz", "IANA zone"}}, status: 200, produces: "audio/wav"},
+	{method: "GET", path: "/changes", summary: "Events changed since a sync token", access: "authenticated",
+		query: []apiParam{{"since", "Token from the last call"}, {"limit", "Most changes"}}, status: 200, response: ChangeSet{}},
+	{method: "GET", path: "/reminders/active", summary: "Fired reminders not yet dismissed", access: "authenticated", status: 200, response: []ActiveReminder{}},
+	{method: "POST", path: "/reminders/dismissals", summary: "Dismiss a fired reminder", access: "authenticated", body: ReminderDismissal{}, status: 200, response: schemas.ReminderDelivery{}},
+	{method: "GET", path: "/realtime", summary: "WebSocket of changes as they happen", access: "authenticated", status: 101},
+
+	{method: "GET", path: "/lite", summary: "Plain HTML agenda for old browsers", access: "authenticated", unversioned: true,
+		query: []apiParam{{"tz", "IANA zone"}, {"days", "How many days"}, {"week", "true to start on the week's first day"}}, status: 200, produces: "text/html"},
+	{method: "GET", path: "/lite/events/{id}", summary: "Plain HTML event", access: "authenticated", unversioned: true, query: []apiParam{{"tz", "IANA zone"}}, status: 200, produces: "text/html"},
+
+	{method: "GET", path: "/display-profiles", summary: "List display profiles", access: "authenticated", status: 200, response: []schemas.DisplayProfile{}},
+	{method: "POST", path: "/display-profiles", summary: "Create a display profile", access: "authenticated", body: schemas.DisplayProfile{}, status: 201, response: schemas.DisplayProfile{}},
+	{method: "GET", path: "/display-profiles/{id}", summary: "Get a display profile", access: "authenticated", status: 200, response: schemas.DisplayProfile{}},
+	{method: "PUT", path: "/display-profiles/{id}", summary: "Update a display profile", access: "authenticated", body: schemas.DisplayProfile{}, status: 200, response: schemas.DisplayProfile{}},
+	{method: "DELETE", path: "/display-profiles/{id}", summary: "Delete a display profile", access: "authenticated", status: 204},
+	{method: "GET", path: "/display-profiles/{id}/occurrences", summary: "A display profile's occurrences in a range", access: "authenticated",
+		query:  params(rangeQuery, groupQuery),
+		status: 200, response: oneOf{[]EventOccurrence{}, []PersonColumn{}, []AgendaDay{}, []PersonDay{}}},
+
+	{method: "GET", path: "/calendars", summary: "List calendars", access: "authenticated", status: 200, response: []schemas.Calendar{}},
+	{method: "POST", path: "/calendars", summary: "Create a calendar", access: "authenticated", body: schemas.Calendar{}, status: 201, response: SavedCalendar{}},
+	{method: "GET", path: "/calendars/{id}", summary: "Get a calendar", access: "authenticated", status: 200, response: schemas.Calendar{}},
+	{method: "PUT", path: "/calendars/{id}", summary: "Update a calendar", access: "authenticated", body: schemas.Calendar{}, status: 200, response: SavedCalendar{}},
+	{method: "DELETE", path: "/calendars/{id}", summary: "Delete an empty calendar", access: "authenticated", status: 204},
+	{method: "GET", path: "/tags", summary: "List tags", access: "authenticated", status: 200, response: []schemas.Tag{}},
+	{method: "POST", path: "/tags", summary: "Create a tag", access: "authenticated", body: schemas.Tag{}, status: 201, response: schemas.Tag{}},
+	{method: "GET", path: "/tags/{id}", summary: "Get a tag", access: "authenticated", status: 200, response: schemas.Tag{}},
+	{method: "PUT", path: "/tags/{id}", summary: "Update a tag", access: "authenticated", body: schemas.Tag{}, status: 200, response: schemas.Tag{}},
+	{method: "DELETE", path: "/tags/{id}", summary: "Delete a tag", access: "authenticated", status: 204},
+	{method: "GET", path: "/people", summary: "List people", access: "authenticated", status: 200, response: []schemas.Person{}},
+	{method: "POST", path: "/people", summary: "Add a person", access: "authenticated", body: schemas.Person{}, status: 201, response: SavedPerson{}},
+	{method: "GET", path: "/people/{id}", summary: "Get a person", access: "authenticated", status: 200, response: schemas.Person{}},
+	{method: "PUT", path: "/people/{id}", summary: "Update a person", access: "authenticated", body: schemas.Person{}, status: 200, response: SavedPerson{}},
+	{method: "DELETE", path: "/people/{id}", summary: "Remove a person nothing refers to", access: "authenticated", status: 204},
+	{method: "GET", path: "/people/{id}/push", summary: "A person's push settings", access: "authenticated", status: 200, response: schemas.PushSettings{}},
+	{method: "PUT", path: "/people/{id}/push", summary: "Set a person's push settings", access: "authenticated", body: schemas.PushSettings{}, status: 200, response: schemas.PushSettings{}},
+	{method: "DELETE", path: "/people/{id}/push", summary: "Turn a person's pushes off", access: "authenticated", status: 204},
+	{method: "POST", path: "/people/{id}/push/test", summary: "Send a person a test push", access: "authenticated", status: 204},
+	{method: "GET", path: "/colors/contrast", summary: "How readable a colour is on each theme", access: "authenticated",
+		query: []apiParam{{"color", "CSS hex colour"}}, status: 200, response: ColorReport{}},
+	{method: "GET", path: "/settings", summary: "Household settings", access: "authenticated", status: 200, response: schemas.Settings{}},
+	{method: "PUT", path: "/settings", summary: "Change household settings", access: "authenticated", body: schemas.Settings{}, status: 200, response: schemas.Settings{}},
+
+	{method: "GET", path: "/feeds", summary: "List subscribed feeds", access: "authenticated", status: 200, response: []schemas.Feed{}},
+	{method: "POST", path: "/feeds", summary: "Subscribe to a feed", access: "authenticated", body: schemas.Feed{}, status: 201, response: schemas.Feed{}},
+	{method: "GET", path: "/feeds/{id}", summary: "Get a feed", access: "authenticated", status: 200, response: schemas.Feed{}},
+	{method: "PUT", path: "/feeds/{id}", summary: "Update a feed", access: "authenticated", body: schemas.Feed{}, status: 200, response: schemas.Feed{}},
+	{method: "DELETE", path: "/feeds/{id}", summary: "Unsubscribe, removing its events", access: "authenticated", status: 204},
+	{method: "POST", path: "/feeds/{id}/refresh", summary: "Refresh a feed now", access: "authenticated", status: 200, response: FeedRefreshReport{}},
+
+	{method: "GET", path: "/integrations/{provider}", summary: "List a provider's accounts", access: "authenticated", status: 200, response: []IntegrationAccount{}},
+	{method: "POST", path: "/integrations/{provider}", summary: "Connect an account", access: "authenticated", body: schemas.SyncState{}, status: 201, response: IntegrationConnect{}},
+	{method: "GET", path: "/integrations/{provider}/{id}", summary: "Get an account", access: "authenticated", status: 200, response: IntegrationAccount{}},
+	{method: "PUT", path: "/integrations/{provider}/{id}", summary: "Update an account", access: "authenticated", body: schemas.SyncState{}, status: 200, response: IntegrationAccount{}},
+	{method: "DELETE", path: "/integrations/{provider}/{id}", summary: "Disconnect an account", access: "authenticated", status: 204},
+	{method: "GET", path: "/integrations/{provider}/{id}/calendars", summary: "List an account's remote calendars", access: "authenticated", status: 200, response: []integrations.Calendar{}},
+	{method: "POST", path: "/integrations/{provider}/{id}/connect", summary: "Sign an account in again", access: "authenticated", status: 200, response: IntegrationConnect{}},
+	{method: "POST", path: "/integrations/{provider}/{id}/sync", summary: "Sync an account now", access: "authenticated", status: 200, response: IntegrationSyncReport{}},
+	{method: "GET", path: "/api/integrations/{provider}/callback", summary: "Where the provider sends the browser back", access: "redirect", unversioned: true,
+		query: []apiParam{{"state", "From the connect step"}, {"code", "Authorization code"}, {"error", "Set when sign-in failed"}}, status: 200, produces: "text/plain"},
+
+	{method: "GET", path: "/admin/storage", summary: "Database size by table", access: "admin", status: 200, response: StorageReport{}},
+	{method: "GET", path: "/admin/telemetry", summary: "The usage report that would be sent", access: "admin", status: 200, response: TelemetryPreview{}},
+	{method: "GET", path: "/admin/debug-capture", summary: "Captured requests and webhook deliveries", access: "admin", status: 200, response: DebugCaptureStatus{}},
+	{method: "POST", path: "/admin/debug-capture", summary: "Start capturing", access: "admin", body: DebugCaptureRequest{}, status: 200, response: DebugCaptureStatus{}},
+	{method: "DELETE", path: "/admin/debug-capture", summary: "Stop capturing and forget what was kept", access: "admin", status: 204},
+	{method: "GET", path: "/admin/export", summary: "Download a backup", access: "admin", status: 200, response: schemas.Backup{}},
+	{method: "POST", path: "/admin/import", summary: "Replace everything with a backup", access: "admin", body: schemas.Backup{}, status: 200, response: BackupRestoreReport{}},
+	{method: "POST", path: "/admin/backups/verify", summary: "Restore the newest backup into a scratch schema to check it", access: "admin", status: 200, response: RestoreDrill{}},
+	{method: "GET", path: "/admin/backup-targets", summary: "List added backup targets", access: "admin", status: 200, response: []schemas.BackupTarget{}},
+	{method: "POST", path: "/admin/backup-targets", summary: "Add a backup target", access: "admin", body: BackupTargetInput{}, status: 201, response: schemas.BackupTarget{}},
+	{method: "GET", path: "/admin/backup-targets/{id}", summary: "Get a backup target", access: "admin", status: 200, response: schemas.BackupTarget{}},
+	{method: "PUT", path: "/admin/backup-targets/{id}", summary: "Change a backup target", access: "admin", body: BackupTargetInput{}, status: 200, response: schemas.BackupTarget{}},
+	{method: "DELETE", path: "/admin/backup-targets/{id}", summary: "Remove a backup target", access: "admin", status: 204},
+	{method: "POST", path: "/admin/default-reminders", summary: "Give matching events reminders", access: "admin", query: params(filterQuery, dryRunQuery), body: DefaultRemindersRequest{}, status: 200, response: DefaultRemindersReport{}},
+	{method: "POST", path: "/admin/merge/people", summary: "Merge one person into another", access: "admin", query: dryRunQuery, body: MergeRequest{}, status: 200, response: MergeReport{}},
+	{method: "POST", path: "/admin/merge/calendars", summary: "Merge one calendar into another", access: "admin", query: dryRunQuery, body: MergeRequest{}, status: 200, response: MergeReport{}},
+	{method: "GET", path: "/admin/open-recurrences", summary: "Old series with no end", access: "admin", query: []apiParam{{"years", "How long ago they started"}}, status: 200, response: []OpenRecurrence{}},
+	{method: "POST", path: "/admin/open-recurrences/end", summary: "End several series", access: "admin", query: dryRunQuery, body: EndRecurrencesRequest{}, status: 200, response: EndRecurrencesReport{}},
+	{method: "GET", path: "/feed-tokens", summary: "List feed tokens", access: "admin", status: 200, response: []schemas.FeedToken{}},
+	{method: "POST", path: "/feed-tokens", summary: "Publish a feed", access: "admin", body: schemas.FeedToken{}, status: 201, response: FeedTokenIssued{}},
+	{method: "DELETE", path: "/feed-tokens/{id}", summary: "Unpublish a feed", access: "admin", status: 204},
+	{method: "GET", path: "/api-tokens", summary: "List API tokens", access: "admin", status: 200, response: []schemas.APIToken{}},
+	{method: "POST", path: "/api-tokens", summary: "Issue an API token", access: "admin", body: schemas.APIToken{}, status: 201, response: APITokenIssued{}},
+	{method: "DELETE", path: "/api-tokens/{id}", summary: "Revoke an API token", access: "admin", status: 204},
+	{method: "GET", path: "/webhooks", summary: "List webhooks", access: "admin", status: 200, response: []schemas.Webhook{}},
+	{method: "POST", path: "/webhooks", summary: "Add a webhook", access: "admin", body: schemas.Webhook{}, status: 201, response: WebhookCreated{}},
+	{method: "GET", path: "/webhooks/{id}", summary: "Get a webhook", access: "admin", status: 200, response: schemas.Webhook{}},
+	{method: "PUT", path: "/webhooks/{id}", summary: "Update a webhook", access: "admin", body: schemas.Webhook{}, status: 200, response: schemas.Webhook{}},
+	{method: "DELETE", path: "/webhooks/{id}", summary: "Remove a webhook", access: "admin", status: 204},
+	{method: "GET", path: "/webhooks/{id}/deliveries", summary: "A webhook's recent deliveries", access: "admin", query: pageQuery, status: 200, response: PagedResponse[schemas.WebhookDelivery]{}},
+	{method: "GET", path: "/devices", summary: "List displays", access: "admin", status: 200, response: []schemas.Device{}},
+	{method: "POST", path: "/devices", summary: "Register a display", access: "admin", body: schemas.Device{}, status: 201, response: schemas.Device{}},
+	{method: "GET", path: "/devices/{id}", summary: "Get a display", access: "admin", status: 200, response: schemas.Device{}},
+	{method: "DELETE", path: "/devices/{id}", summary: "Forget a display", access: "admin", status: 204},
+	{method: "POST", path: "/devices/{id}/command", summary: "Queue a command for a display", access: "admin", body: DeviceCommand{}, status: 202, response: schemas.Device{}},
+	{method: "GET", path: "/metrics", summary: "Prometheus metrics", access: "admin", unversioned: true, status: 200, produces: "text/plain"},
+}
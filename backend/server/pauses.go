@@ -0,0 +1,103 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pical/database/schemas"
+	"slices"
+	"time"
+)
+
+func (s *Server) getEventPauses(w http.ResponseWriter, r *http.Request, id string) {
+	if _, err := s.Events.GetEvent(r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	out, err := schemas.ListEventPauses(r.Context(), s.DB, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// createEventPause skips a series' occurrences between two dates, leaving
+// its exceptions as they are.
+func (s *Server) createEventPause(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var in PauseRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	e, ok := s.editableSeries(w, r, id)
+	if !ok {
+		return
+	}
+
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	from, err := parseRangeBound(in.From, loc, false)
+	if err != nil {
+		http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	until, err := parseRangeBound(in.Until, loc, true)
+	if err != nil {
+		http.Error(w, "until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !until.After(from) {
+		http.Error(w, "until must be after from", http.StatusBadRequest)
+		return
+	}
+
+	out, err := schemas.CreatePause(r.Context(), s.DB, schemas.Pause{EventID: id, From: from, Until: until})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.Pauses = append(e.Pauses, out)
+	slices.SortStableFunc(e.Pauses, func(a, b schemas.Pause) int { return a.From.Compare(b.From) })
+
+	s.notifyEventChanged(r.Context(), *e, fmt.Sprintf("is paused from %s to %s", occurrenceDay(*e, from), occurrenceDay(*e, until.Add(-time.Nanosecond))))
+	s.emitChange(r.Context(), schemas.WebhookEventUpdated, *e)
+
+	writeJSON(w, http.StatusCreated, out)
+}
+
+// deleteEventPause resumes the occurrences a pause skipped.
+func (s *Server) deleteEventPause(w http.ResponseWriter, r *http.Request, id, pauseID string) {
+	e, ok := s.editableSeries(w, r, id)
+	if !ok {
+		return
+	}
+
+	p, err := schemas.DeletePause(r.Context(), s.DB, id, pauseID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "pause not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.Pauses = slices.DeleteFunc(e.Pauses, func(q schemas.Pause) bool { return q.PauseID == pauseID })
+
+	s.notifyEventChanged(r.Context(), *e, fmt.Sprintf("is back on from %s", occurrenceDay(*e, p.From)))
+	s.emitChange(r.Context(), schemas.WebhookEventUpdated, *e)
+
+	w.WriteHeader(http.StatusNoContent)
+}
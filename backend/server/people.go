@@ -0,0 +1,99 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pical/database/schemas"
+)
+
+func (s *Server) getPeople(w http.ResponseWriter, r *http.Request) {
+	people, err := schemas.ListPeople(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, people)
+}
+
+func (s *Server) createPerson(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in schemas.Person
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	created, err := schemas.CreatePerson(r.Context(), s.DB, in)
+	if err != nil {
+		if errors.Is(err, schemas.ErrPersonExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, SavedPerson{Person: created, Warnings: colorWarnings(created.Color)})
+}
+
+func (s *Server) getPerson(w http.ResponseWriter, r *http.Request, id string) {
+	p, err := schemas.GetPerson(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "person not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (s *Server) updatePerson(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var in schemas.Person
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := schemas.UpdatePerson(r.Context(), s.DB, id, in)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "person not found", http.StatusNotFound)
+		case errors.Is(err, schemas.ErrPersonExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SavedPerson{Person: updated, Warnings: colorWarnings(updated.Color)})
+}
+
+// deletePerson only removes people nothing refers to; move or delete their
+// events, feeds and sync accounts first.
+func (s *Server) deletePerson(w http.ResponseWriter, r *http.Request, id string) {
+	err := schemas.DeletePerson(r.Context(), s.DB, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "person not found", http.StatusNotFound)
+		case errors.Is(err, schemas.ErrPersonInUse):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
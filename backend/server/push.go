@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"pical/database/schemas"
+	"pical/push"
+	"time"
+)
+
+// Change notices are sent after the response, so they get their own deadline.
+const pushTimeout = 15 * time.Second
+
+func (s *Server) getPushSettings(w http.ResponseWriter, r *http.Request, personID string) {
+	p, err := schemas.GetPushSettings(r.Context(), s.DB, personID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "push notifications are not set up for this person", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	p.Token = nil
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (s *Server) setPushSettings(w http.ResponseWriter, r *http.Request, personID string) {
+	defer r.Body.Close()
+
+	var in schemas.PushSettings
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := schemas.GetPerson(r.Context(), s.DB, personID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "person not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	in.PersonID = personID
+	out, err := schemas.SetPushSettings(r.Context(), s.DB, in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out.Token = nil
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) deletePushSettings(w http.ResponseWriter, r *http.Request, personID string) {
+	if err := schemas.DeletePushSettings(r.Context(), s.DB, personID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "push notifications are not set up for this person", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// testPush sends a message straight away, so setup mistakes show up now
+// rather than at the first reminder.
+func (s *Server) testPush(w http.ResponseWriter, r *http.Request, personID string) {
+	p, err := schemas.GetPushSettings(r.Context(), s.DB, personID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "push notifications are not set up for this person", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	msg := push.Message{Title: "PiCal", Body: "Push notifications are working."}
+	if err := notifierFor(*p).Send(r.Context(), msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func notifierFor(p schemas.PushSettings) push.Notifier {
+	var topic, token string
+	if p.Topic != nil {
+		topic = *p.Topic
+	}
+	if p.Token != nil {
+		token = *p.Token
+	}
+
+	if p.Service == schemas.PushGotify {
+		return push.NewGotify(p.ServerURL, token)
+	}
+	return push.NewNtfy(p.ServerURL, topic, token)
+}
+
+// pushToPerson sends msg to the person's push server, if they have one.
+// Change notices only go to people who asked for them.
+func (s *Server) pushToPerson(ctx context.Context, personID string, msg push.Message, change bool) {
+	p, err := schemas.GetPushSettings(ctx, s.DB, personID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("push: %v", err)
+		}
+		return
+	}
+	if change && !p.EventChanges {
+		return
+	}
+
+	if err := notifierFor(*p).Send(ctx, msg); err != nil {
+		log.Printf("push to %s: %v", personID, err)
+	}
+}
+
+// notifyEventChanged tells the event's person what happened to it, after the
+// request that did it has been answered.
+func (s *Server) notifyEventChanged(ctx context.Context, e schemas.Event, what string) {
+	msg := push.Message{
+		Title: e.Title + " changed",
+		Body:  fmt.Sprintf("%s %s.", e.Title, what),
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), pushTimeout)
+	go func() {
+		defer cancel()
+		s.pushToPerson(ctx, e.PersonID, msg, true)
+	}()
+}
+
+// occurrenceDay names the day an occurrence of e falls on, in its timezone.
+func occurrenceDay(e schemas.Event, t time.Time) string {
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return t.In(loc).Format("Monday 2 January")
+}
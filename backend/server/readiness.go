@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"pical/database"
+	"time"
+)
+
+// How often an open breaker is checked for a cooldown that has run out, so
+// it closes again without waiting for a request to probe it
+const breakerProbeInterval = 5 * time.Second
+
+// getReadyz is /readyz: 200 once warm-up is over and the database answers a
+// ping, 503 while warming up, while it doesn't answer or while the breaker is
+// open. /health stays 200 regardless, since a restart wouldn't bring the
+// database back.
+func (s *Server) getReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	out := ReadyStatus{Ready: true}
+	if err := s.DB.PingContext(ctx); err != nil {
+		out.Ready, out.Error = false, err.Error()
+	} else if !s.warm.Load() {
+		out.Ready, out.Error = false, "warming up"
+	}
+	if b := s.Config.Breaker; b != nil {
+		stats := b.Stats()
+		out.Database = &stats
+	}
+	if status := s.backupStatus.Load(); status != nil {
+		backup := *status
+		backup.LastDrill = s.backupDrill.Load()
+		out.Backup = &backup
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	status := http.StatusOK
+	if !out.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, out)
+}
+
+// getMetrics serves the connection pool and breaker in the Prometheus text
+// format.
+func (s *Server) getMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metric := func(name, kind, help string, value any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+
+	pool := s.DB.Stats()
+	metric("pical_db_open_connections", "gauge", "Connections to the database, in use or idle.", pool.OpenConnections)
+	metric("pical_db_in_use_connections", "gauge", "Connections running a query.", pool.InUse)
+	metric("pical_db_wait_count_total", "counter", "Queries that had to wait for a free connection.", pool.WaitCount)
+	metric("pical_db_wait_seconds_total", "counter", "Time spent waiting for a free connection.", pool.WaitDuration.Seconds())
+
+	if b := s.Config.Breaker; b != nil {
+		stats := b.Stats()
+		fmt.Fprintf(w, "# HELP pical_db_breaker_state Circuit breaker state, 1 for the current one.\n# TYPE pical_db_breaker_state gauge\n")
+		for _, st := range []database.BreakerState{database.BreakerClosed, database.BreakerOpen, database.BreakerHalfOpen} {
+			value := 0
+			if st.String() == stats.State {
+				value = 1
+			}
+			fmt.Fprintf(w, "pical_db_breaker_state{state=%q} %d\n", st.String(), value)
+		}
+		metric("pical_db_breaker_failures", "gauge", "Database connection failures in a row.", stats.Failures)
+		metric("pical_db_breaker_trips_total", "counter", "Times the breaker has opened.", stats.Trips)
+	}
+}
+
+// runBreakerProbe pings the database whenever an open breaker's cooldown
+// is over, until ctx is canceled. Without it the breaker would only close
+// once a request came in to try.
+func (s *Server) runBreakerProbe(ctx context.Context, b *database.Breaker) {
+	ticker := time.NewTicker(breakerProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if open, _ := b.Rejecting(); open || b.State() == database.BreakerClosed {
+			continue
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		// The breaker logs the outcome
+		_ = s.DB.PingContext(pingCtx)
+		cancel()
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"pical/database/schemas"
+	"pical/websocket"
+	"sync"
+	"time"
+)
+
+const (
+	// How often idle clients are pinged, and how long one may stay silent
+	// before it is dropped
+	realtimePing = 30 * time.Second
+	realtimeIdle = 75 * time.Second
+	// Changes waiting for a slow client; one that falls this far behind is
+	// dropped and has to reconnect and reload
+	realtimeBuffer = 64
+)
+
+// realtimeHub fans changes out to the connected /api/realtime clients.
+type realtimeHub struct {
+	mu      sync.Mutex
+	clients map[*realtimeClient]struct{}
+}
+
+type realtimeClient struct {
+	conn *websocket.Conn
+	send chan []byte
+	done chan struct{}
+
+	mu     sync.Mutex
+	filter schemas.ChangeFilter
+}
+
+func newRealtimeHub() *realtimeHub {
+	return &realtimeHub{clients: make(map[*realtimeClient]struct{})}
+}
+
+func (h *realtimeHub) add(c *realtimeClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = struct{}{}
+}
+
+func (h *realtimeHub) remove(c *realtimeClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, c)
+}
+
+// publish queues msg for every client whose subscription matches.
+func (h *realtimeHub) publish(typ string, e schemas.Event, msg []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.clients {
+		if !c.matches(typ, e) {
+			continue
+		}
+		select {
+		case c.send <- msg:
+		default:
+			log.Printf("realtime: dropping a client that fell behind")
+			delete(h.clients, c)
+			c.conn.Close()
+		}
+	}
+}
+
+// closeAll disconnects every client once ctx is canceled, since hijacked
+// connections outlive the HTTP server's shutdown.
+func (h *realtimeHub) closeAll(ctx context.Context) {
+	<-ctx.Done()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		delete(h.clients, c)
+		c.conn.Close()
+	}
+}
+
+func (c *realtimeClient) matches(typ string, e schemas.Event) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.filter.Matches(typ, e)
+}
+
+// emitChange tells webhooks and realtime clients about a change to e.
+func (s *Server) emitChange(ctx context.Context, typ string, e schemas.Event, exs ...schemas.Exception) {
+	s.emitNotice(ctx, ChangeNotice{Type: typ, OccurredAt: time.Now(), Event: e, Exceptions: exs})
+}
+
+// emitReminder tells them a reminder for e has fired or been dismissed.
+func (s *Server) emitReminder(ctx context.Context, typ string, e schemas.Event, d schemas.ReminderDelivery) {
+	s.emitNotice(ctx, ChangeNotice{Type: typ, OccurredAt: time.Now(), Event: e, Reminder: &d})
+}
+
+func (s *Server) emitNotice(ctx context.Context, n ChangeNotice) {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		log.Printf("changes: %v", err)
+		return
+	}
+
+	s.realtime.publish(n.Type, n.Event, payload)
+	s.queueWebhooks(ctx, n.Type, n.Event, payload)
+}
+
+// realtimeSocket serves /api/realtime. Clients get every change until they
+// send a "subscribe" message narrowing it down.
+func (s *Server) realtimeSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	conn.ReadTimeout = realtimeIdle
+
+	c := &realtimeClient{
+		conn: conn,
+		send: make(chan []byte, realtimeBuffer),
+		done: make(chan struct{}),
+	}
+	s.realtime.add(c)
+	defer s.realtime.remove(c)
+
+	go c.writeLoop()
+	defer close(c.done)
+
+	for {
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.handle(msg)
+	}
+}
+
+// handle answers one message from the client.
+func (c *realtimeClient) handle(msg []byte) {
+	var in RealtimeRequest
+	if err := json.Unmarshal(msg, &in); err != nil {
+		c.reply(RealtimeReply{Type: "error", Error: "invalid JSON"})
+		return
+	}
+
+	switch in.Type {
+	case "subscribe":
+		if err := in.ChangeFilter.Validate(); err != nil {
+			c.reply(RealtimeReply{Type: "error", Error: err.Error()})
+			return
+		}
+		c.mu.Lock()
+		c.filter = in.ChangeFilter
+		c.mu.Unlock()
+		c.reply(RealtimeReply{Type: "subscribed", Filter: &in.ChangeFilter})
+	case "ping":
+		c.reply(RealtimeReply{Type: "pong"})
+	default:
+		c.reply(RealtimeReply{Type: "error", Error: `type must be "subscribe" or "ping"`})
+	}
+}
+
+func (c *realtimeClient) reply(out RealtimeReply) {
+	b, err := json.Marshal(out)
+	if err != nil {
+		log.Printf("realtime: %v", err)
+		return
+	}
+	select {
+	case c.send <- b:
+	default:
+		c.conn.Close()
+	}
+}
+
+// writeLoop sends queued messages and keeps the connection alive with pings.
+func (c *realtimeClient) writeLoop() {
+	ticker := time.NewTicker(realtimePing)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			err = c.conn.WriteText(msg)
+		case <-ticker.C:
+			err = c.conn.Ping()
+		}
+		if err != nil {
+			c.conn.Close()
+			return
+		}
+	}
+}
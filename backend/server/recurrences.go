@@ -0,0 +1,167 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pical/database/schemas"
+	"pical/recurrence"
+	"time"
+)
+
+// maxEndRecurrences caps how many series one bulk call may end.
+const maxEndRecurrences = 500
+
+// getOpenRecurrences lists editable recurring events with neither UNTIL nor
+// COUNT whose series started at least ?years (default 2) ago.
+func (s *Server) getOpenRecurrences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	years := parseIntQuery(r, "years", 2, 0, 100)
+	now := time.Now()
+
+	events, err := schemas.ListRecurringEventsBefore(r.Context(), s.DB, now.AddDate(-years, 0, 0))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]OpenRecurrence, 0)
+	for _, e := range events {
+		rule, err := recurrence.Parse(*e.Rrule)
+		if err != nil {
+			continue
+		}
+		loc, err := time.LoadLocation(e.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		start := e.StartTime.In(loc)
+		if rule.Count > 0 || rule.UntilAt(start) != nil {
+			continue
+		}
+
+		item := OpenRecurrence{Event: e, RunningSince: e.StartTime}
+		rule.Starts(start, func(t time.Time) bool {
+			if t.Before(now) {
+				return true
+			}
+			item.NextOccurrence = &t
+			return false
+		})
+		out = append(out, item)
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// endRecurrences sets the same last day on many series at once. Events that
+// can't be ended are reported as skipped; the rest are written together.
+// ?dryRun=true reports the same without writing anything.
+func (s *Server) endRecurrences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+
+	var in EndRecurrencesRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(in.EventIDs) == 0 {
+		http.Error(w, "eventIds is required", http.StatusBadRequest)
+		return
+	}
+	if len(in.EventIDs) > maxEndRecurrences {
+		http.Error(w, "too many eventIds", http.StatusBadRequest)
+		return
+	}
+	if in.Until == "" {
+		http.Error(w, "until is required", http.StatusBadRequest)
+		return
+	}
+
+	dryRun := parseBoolQuery(r, "dryRun")
+	report := EndRecurrencesReport{Updated: make([]schemas.Event, 0), Skipped: make([]RecurrenceSkip, 0), DryRun: dryRun}
+	rules := make(map[string]string, len(in.EventIDs))
+	for _, id := range in.EventIDs {
+		if _, dup := rules[id]; dup {
+			continue
+		}
+		skip := func(reason string) {
+			report.Skipped = append(report.Skipped, RecurrenceSkip{EventID: id, Reason: reason})
+		}
+
+		e, err := s.Events.GetEvent(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				skip("event not found")
+				continue
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if e.FeedID != nil {
+			skip("event belongs to a subscribed feed and is read-only")
+			continue
+		}
+		if !recurrence.Recurs(*e) {
+			skip("event does not repeat")
+			continue
+		}
+		rule, err := recurrence.Parse(*e.Rrule)
+		if err != nil {
+			skip("rrule: " + err.Error())
+			continue
+		}
+
+		loc, err := time.LoadLocation(e.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		until, err := parseRangeBound(in.Until, loc, true)
+		if err != nil {
+			http.Error(w, "until: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// A date-only bound points just past that day
+		if _, dateErr := time.Parse("2006-01-02", in.Until); dateErr == nil {
+			until = until.Add(-time.Second)
+		}
+		if until.Before(e.StartTime) {
+			skip("until is before the series starts")
+			continue
+		}
+
+		if e.AllDay {
+			rule.SetUntilDate(until.In(loc))
+		} else {
+			rule.SetUntil(until)
+		}
+		rules[id] = rule.String()
+	}
+
+	if len(rules) > 0 {
+		updated, err := schemas.SetEventRrules(r.Context(), s.DB, rules, dryRun)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		report.Updated = updated
+		if !dryRun {
+			for _, e := range updated {
+				s.emitChange(r.Context(), schemas.WebhookEventUpdated, e)
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,452 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"pical/database/schemas"
+	"pical/push"
+	"pical/recurrence"
+	"pical/travel"
+	"strings"
+	"time"
+)
+
+const (
+	// How often the scheduler looks for reminders that are due
+	reminderTick = time.Minute
+	// A reminder that comes due while the server is down is still sent if it
+	// is back within this long; after that the moment has passed
+	reminderGrace = 15 * time.Minute
+	// Deliveries are remembered this long, well past any occurrence they
+	// could still match
+	reminderDeliveryRetention = 60 * 24 * time.Hour
+	// Fired reminders nobody dismissed stop being shown after this long
+	reminderActiveWindow = 24 * time.Hour
+	// Leave-by reminders allow for trips of up to this long
+	travelMaxLead = 4 * time.Hour
+	// A travel time is asked for again after this long, as traffic changes,
+	// or straight away if the occurrence moves
+	travelCacheTTL = 30 * time.Minute
+)
+
+func (s *Server) getReminders(w http.ResponseWriter, r *http.Request, id string) {
+	if _, err := s.Events.GetEvent(r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	reminders, err := schemas.ListEventReminders(r.Context(), s.DB, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, reminders)
+}
+
+func (s *Server) createReminder(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var in schemas.Reminder
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.Events.GetEvent(r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	in.EventID = id
+	created, err := schemas.CreateReminder(r.Context(), s.DB, in)
+	if err != nil {
+		if errors.Is(err, schemas.ErrReminderExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// addDefaultReminders gives every event matching the GET /events filters in
+// the query the reminders in the body, skipping any an event already has,
+// all in one go. It is for bringing existing events in line with a default,
+// like 30 minutes' notice for everything on the school calendar.
+// ?dryRun=true reports what would be added without adding it.
+func (s *Server) addDefaultReminders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+
+	var in DefaultRemindersRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(in.Reminders) == 0 {
+		http.Error(w, "reminders is required", http.StatusBadRequest)
+		return
+	}
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	dryRun := parseBoolQuery(r, "dryRun")
+	matched, added, err := schemas.AddDefaultReminders(r.Context(), s.DB, filter, in.Reminders, dryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, DefaultRemindersReport{Matched: matched, Added: added, DryRun: dryRun})
+}
+
+func (s *Server) deleteReminder(w http.ResponseWriter, r *http.Request, id, reminderID string) {
+	if err := schemas.DeleteReminder(r.Context(), s.DB, id, reminderID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "reminder not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getActiveReminders lists the reminders that have fired and not been
+// dismissed yet, for a display or phone catching up after connecting.
+func (s *Server) getActiveReminders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deliveries, err := schemas.ListActiveReminders(r.Context(), s.DB, time.Now().Add(-reminderActiveWindow))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ids := make([]string, 0, len(deliveries))
+	for _, d := range deliveries {
+		ids = append(ids, d.EventID)
+	}
+	events, err := s.Events.GetEvents(r.Context(), ids)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	byID := make(map[string]schemas.Event, len(events))
+	for _, e := range events {
+		byID[e.EventID] = e
+	}
+
+	out := make([]ActiveReminder, 0, len(deliveries))
+	for _, d := range deliveries {
+		// Reminders of trashed events are left out
+		if e, ok := byID[d.EventID]; ok {
+			out = append(out, ActiveReminder{ReminderDelivery: d, Event: e})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// dismissReminder records that a fired reminder has been seen, and tells
+// every other client so they stop showing it.
+func (s *Server) dismissReminder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+
+	var in ReminderDismissal
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if in.ReminderID == "" || in.RecurrenceID.IsZero() {
+		http.Error(w, "reminderId and recurrenceId are required", http.StatusBadRequest)
+		return
+	}
+
+	d, err := schemas.DismissReminder(r.Context(), s.DB, in.ReminderID, in.RecurrenceID, in.DismissedBy)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "reminder has not fired for that occurrence", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	e, err := s.Events.GetEvent(r.Context(), d.EventID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if e != nil {
+		s.emitReminder(r.Context(), schemas.WebhookReminderDismissed, *e, d)
+	}
+
+	writeJSON(w, http.StatusOK, d)
+}
+
+// runReminders sends reminders as they come due until ctx is canceled.
+func (s *Server) runReminders(ctx context.Context) {
+	ticker := time.NewTicker(reminderTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		tickCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		if err := s.sendDueReminders(tickCtx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("reminders: %v", err)
+		}
+		cancel()
+	}
+}
+
+// sendDueReminders fires every reminder whose moment, OffsetMinutes before
+// an occurrence starts, fell within the grace period up to now. For a
+// leave-by reminder the travel time there comes off too. Recurring events
+// are expanded in their own timezone with their exceptions applied, so a
+// moved occurrence is reminded at its new time and a cancelled one not at
+// all.
+func (s *Server) sendDueReminders(ctx context.Context, now time.Time) error {
+	reminders, err := schemas.ListAllReminders(ctx, s.DB)
+	if err != nil {
+		return err
+	}
+
+	byEvent := make(map[string][]schemas.Reminder)
+	order := make([]string, 0)
+	for _, rem := range reminders {
+		if _, ok := byEvent[rem.EventID]; !ok {
+			order = append(order, rem.EventID)
+		}
+		byEvent[rem.EventID] = append(byEvent[rem.EventID], rem)
+	}
+
+	for _, eventID := range order {
+		e, err := s.Events.GetEvent(ctx, eventID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				// Deleted since the reminders were listed
+				continue
+			}
+			return err
+		}
+		var exs []schemas.Exception
+		if recurrence.Recurs(*e) {
+			if exs, err = schemas.ListEventExceptions(ctx, s.DB, eventID); err != nil {
+				return err
+			}
+		}
+
+		for _, rem := range byEvent[eventID] {
+			// Occurrences starting in (from, to] might have their reminder
+			// due now; how far ahead a leave-by one fires depends on the trip
+			from := now.Add(rem.Offset() - reminderGrace)
+			to := now.Add(rem.Offset())
+			if rem.LeaveBy {
+				to = to.Add(travelMaxLead)
+			}
+			for _, occ := range recurrence.Expand(*e, exs, from, to.Add(time.Nanosecond)) {
+				if !occ.StartTime.After(from) || occ.StartTime.After(to) {
+					continue
+				}
+				var trip time.Duration
+				if rem.LeaveBy {
+					if trip, err = s.travelTime(ctx, *e, occ, now); err != nil {
+						return err
+					}
+				}
+				if fires := occ.StartTime.Add(-rem.Offset() - trip); fires.After(now) || !fires.After(now.Add(-reminderGrace)) {
+					continue
+				}
+				d, claimed, err := schemas.ClaimReminderDelivery(ctx, s.DB, rem.ReminderID, occ.RecurrenceID)
+				if err != nil {
+					return err
+				}
+				if claimed {
+					s.deliverReminder(ctx, rem, *e, occ, trip)
+					s.emitReminder(ctx, schemas.WebhookReminderFired, *e, d)
+				}
+			}
+		}
+	}
+
+	if err := schemas.PruneTravelTimes(ctx, s.DB, now.Add(-reminderGrace)); err != nil {
+		return err
+	}
+	return schemas.PruneReminderDeliveries(ctx, s.DB, now.Add(-reminderDeliveryRetention))
+}
+
+// travelTime is how long the trip from TravelOrigin to the occurrence
+// takes, capped at travelMaxLead. It is zero when it can't be known, as
+// without a routing provider or coordinates for the event, so the reminder
+// still fires, if later than it should. A provider that fails is logged and
+// asked again on the next tick.
+func (s *Server) travelTime(ctx context.Context, e schemas.Event, occ recurrence.Occurrence, now time.Time) (time.Duration, error) {
+	if s.router == nil || e.Latitude == nil || e.Longitude == nil {
+		return 0, nil
+	}
+
+	cached, err := schemas.GetTravelTime(ctx, s.DB, e.EventID, occ.RecurrenceID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	// The same place as last time; the trip is worked out again if the
+	// event moves to another, or to another time, or traffic may have changed
+	samePlace := cached != nil && cached.Latitude == *e.Latitude && cached.Longitude == *e.Longitude
+	if samePlace && cached.StartTime.Equal(occ.StartTime) && now.Sub(cached.UpdatedAt) < travelCacheTTL {
+		return time.Duration(cached.Seconds) * time.Second, nil
+	}
+
+	to := travel.Point{Lat: *e.Latitude, Lng: *e.Longitude}
+	// Roughly when they'll set off, which matters for traffic
+	depart := occ.StartTime
+	if samePlace {
+		depart = depart.Add(-time.Duration(cached.Seconds) * time.Second)
+	}
+	trip, err := s.router.TravelTime(ctx, s.Config.TravelOrigin, to, depart)
+	if err != nil {
+		log.Printf("travel: event %s: %v", e.EventID, err)
+		if samePlace {
+			return time.Duration(cached.Seconds) * time.Second, nil
+		}
+		return 0, nil
+	}
+	trip = min(trip, travelMaxLead).Round(time.Minute)
+
+	if err := schemas.SaveTravelTime(ctx, s.DB, schemas.TravelTime{
+		EventID:      e.EventID,
+		RecurrenceID: occ.RecurrenceID,
+		StartTime:    occ.StartTime,
+		Latitude:     to.Lat,
+		Longitude:    to.Lng,
+		Seconds:      int(trip / time.Second),
+	}); err != nil {
+		return 0, err
+	}
+	return trip, nil
+}
+
+// deliverReminder sends a reminder for the occurrence, worded for when to
+// leave if trip, the travel time there, is known.
+func (s *Server) deliverReminder(ctx context.Context, rem schemas.Reminder, e schemas.Event, occ recurrence.Occurrence, trip time.Duration) {
+	title, text := "Reminder: "+e.Title, s.reminderText(e, occ, rem.Offset(), time.Now())
+	if trip > 0 {
+		title, text = s.leaveByText(e, occ, trip)
+	}
+	switch rem.Channel {
+	case schemas.ReminderAlert:
+		s.notifyAdmin(ctx, text)
+	case schemas.ReminderPush:
+		s.pushToPerson(ctx, e.PersonID, push.Message{Title: title, Body: text}, false)
+	default:
+		log.Printf("reminder %s: unknown channel %q", rem.ReminderID, rem.Channel)
+	}
+}
+
+// leaveByText words a leave-by reminder, as "Leave by 2:40 PM", in the
+// event's timezone.
+func (s *Server) leaveByText(e schemas.Event, occ recurrence.Occurrence, trip time.Duration) (string, string) {
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	leave := spokenTime(occ.StartTime.Add(-trip), loc)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Leave by %s for %s", leave, e.Title)
+	if e.PersonName != "" {
+		fmt.Fprintf(&b, " (%s)", e.PersonName)
+	}
+	fmt.Fprintf(&b, " at %s; the trip takes about %d minutes.", spokenTime(occ.StartTime, loc), int(trip/time.Minute))
+	if e.Location != nil {
+		fmt.Fprintf(&b, "\nWhere: %s", *e.Location)
+	}
+	return "Leave by " + leave + ": " + e.Title, b.String()
+}
+
+// reminderText words a reminder for the occurrence, in the event's timezone.
+// The event's link is only given once the occurrence is joinable at now.
+func (s *Server) reminderText(e schemas.Event, occ recurrence.Occurrence, lead time.Duration, now time.Time) string {
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	start := occ.StartTime.In(loc)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Reminder: %s", e.Title)
+	if e.PersonName != "" {
+		fmt.Fprintf(&b, " for %s", e.PersonName)
+	}
+	if e.AllDay {
+		fmt.Fprintf(&b, ", all day %s", start.Format("Monday 2 January"))
+	} else {
+		fmt.Fprintf(&b, ", %s at %s", start.Format("Monday 2 January"), spokenTime(start, loc))
+	}
+	fmt.Fprintf(&b, " (%s).", reminderLead(lead))
+	if e.Location != nil {
+		fmt.Fprintf(&b, "\nWhere: %s", *e.Location)
+	}
+	if link := s.joinURL(e, occ.StartTime, occ.EndTime, now); link != nil {
+		fmt.Fprintf(&b, "\nJoin: %s", *link)
+	}
+	return b.String()
+}
+
+// reminderLead says how far off the occurrence is, in the largest whole unit.
+func reminderLead(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("in 1 %s", unit)
+		}
+		return fmt.Sprintf("in %d %ss", n, unit)
+	}
+
+	switch {
+	case d <= 0:
+		return "starting now"
+	case d%(24*time.Hour) == 0:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d%time.Hour == 0:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d/time.Minute), "minute")
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"pical/database/schemas"
+	"pical/recurrence"
+	"time"
+)
+
+// How far past its start the occurrences of a rule change are compared
+// when the request gives no range
+const rruleChangeWindow = 366 * 24 * time.Hour
+
+// previewRruleChange reports what giving a series a new rule would do,
+// without changing anything: which occurrences in a range it keeps, drops
+// and adds, and which exceptions and task completions would be left
+// pointing at an occurrence that no longer exists. Those are checked across
+// the whole series, not just the range.
+func (s *Server) previewRruleChange(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var in RruleChange
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	e, ok := s.editableSeries(w, r, id)
+	if !ok {
+		return
+	}
+
+	next := *e
+	if in.Rrule != "" {
+		rule, err := recurrence.Parse(in.Rrule)
+		if err != nil {
+			http.Error(w, "rrule: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		normalized := rule.String()
+		next.Rrule = &normalized
+	} else {
+		next.Rrule = nil
+	}
+
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	now := time.Now()
+	from := e.StartTime
+	if now.After(from) {
+		from = now
+	}
+	if in.From != "" {
+		if from, err = parseRangeBound(in.From, loc, false); err != nil {
+			http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	to := from.Add(rruleChangeWindow)
+	if in.To != "" {
+		if to, err = parseRangeBound(in.To, loc, true); err != nil {
+			http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if !to.After(from) {
+		http.Error(w, "to must be after from", http.StatusBadRequest)
+		return
+	}
+
+	exs, err := schemas.ListEventExceptions(r.Context(), s.DB, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	completions, err := schemas.ListTaskCompletions(r.Context(), s.DB, []string{id})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	out := RruleChangePreview{
+		Event:             *e,
+		From:              from,
+		To:                to,
+		Kept:              make([]time.Time, 0),
+		Removed:           make([]time.Time, 0),
+		Created:           make([]time.Time, 0),
+		KeptOverrides:     make([]OccurrenceOverride, 0),
+		OrphanedOverrides: make([]OccurrenceOverride, 0),
+	}
+	if next.Rrule != nil {
+		out.Rrule = *next.Rrule
+	}
+
+	current := seriesStarts(*e, loc, from, to)
+	proposed := seriesStarts(next, loc, from, to)
+	inProposed := make(map[int64]bool, len(proposed))
+	for _, t := range proposed {
+		inProposed[t.UnixNano()] = true
+	}
+	inCurrent := make(map[int64]bool, len(current))
+	for _, t := range current {
+		inCurrent[t.UnixNano()] = true
+		if inProposed[t.UnixNano()] {
+			out.Kept = append(out.Kept, t)
+		} else {
+			out.Removed = append(out.Removed, t)
+		}
+	}
+	for _, t := range proposed {
+		if !inCurrent[t.UnixNano()] {
+			out.Created = append(out.Created, t)
+		}
+	}
+
+	overrides := make([]OccurrenceOverride, 0, len(exs)+len(completions))
+	for _, ex := range exs {
+		o := OccurrenceOverride{Kind: "cancel", RecurrenceID: ex.RecurrenceID}
+		if ex.Kind == schemas.ExceptionMove {
+			o.Kind, o.NewStart, o.NewEnd = "move", ex.NewStart, ex.NewEnd
+		}
+		overrides = append(overrides, o)
+	}
+	for _, c := range completions {
+		overrides = append(overrides, OccurrenceOverride{Kind: "completion", RecurrenceID: c.RecurrenceID})
+	}
+	for _, o := range overrides {
+		if next.Rrule != nil && occursAt(next, o.RecurrenceID) {
+			out.KeptOverrides = append(out.KeptOverrides, o)
+		} else {
+			out.OrphanedOverrides = append(out.OrphanedOverrides, o)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// seriesStarts lists the occurrence starts of e's rule in [from, to),
+// before any exceptions; just its start when it has no rule.
+func seriesStarts(e schemas.Event, loc *time.Location, from, to time.Time) []time.Time {
+	start := e.StartTime.In(loc)
+	if !recurrence.Recurs(e) {
+		if start.Before(from) || !start.Before(to) {
+			return nil
+		}
+		return []time.Time{start}
+	}
+	rule, err := recurrence.Parse(*e.Rrule)
+	if err != nil {
+		return nil
+	}
+	return rule.Between(start, from, to)
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"pical/database/schemas"
+	"strings"
+)
+
+func (s *Server) getSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	limit := parseIntQuery(r, "limit", 20, 1, 100)
+
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	// q is the ranked query here, not a substring match
+	filter.Search = ""
+
+	results, err := schemas.SearchEvents(r.Context(), s.DB, q, filter, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
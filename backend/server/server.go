@@ -3,24 +3,82 @@ package server
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"pical/database/schemas"
+	"pical/integrations"
+	"pical/store"
 	"strings"
 	"time"
 )
 
-func New(ctx context.Context, db *sql.DB, frontendDistDir string) (*Server, error) {
+func New(ctx context.Context, db *sql.DB, frontendDistDir string, cfg Config) (*Server, error) {
 	s := &Server{
-		DB:  db,
-		Mux: http.NewServeMux(),
-		Fs:  http.FileServer(http.Dir(frontendDistDir)),
+		DB:     db,
+		Events: store.NewPostgres(db),
+		Mux:    http.NewServeMux(),
+		Fs:     http.FileServer(http.Dir(frontendDistDir)),
+		Config: cfg,
+
+		webhookWake: make(chan struct{}, 1),
+		realtime:    newRealtimeHub(),
+	}
+	s.providers = make(map[string]integrations.Provider)
+	if err := s.setupFeatures(); err != nil {
+		return nil, err
 	}
 
 	err := s.initDatabase(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if err := os.MkdirAll(cfg.AttachmentDir, 0o750); err != nil {
+		return nil, fmt.Errorf("attachment dir: %w", err)
+	}
 
 	s.routes()
+	if err := s.checkAPISpec(apiOperations); err != nil {
+		return nil, err
+	}
+	s.apiDoc = buildAPIDoc(apiOperations, s.Config.APIPrefix)
+	if !cfg.RequireAPIToken {
+		log.Printf("REQUIRE_API_TOKEN is off: requests without an API token skip scope checks, except on admin routes once an admin token exists")
+	}
+
+	// The materializer waits for warm-up, which starts by doing its first pass
+	go func() {
+		s.warmUp(ctx)
+		if cfg.RecurrenceHorizonMonths > 0 {
+			s.runMaterializer(ctx)
+		}
+	}()
+	go s.runReminders(ctx)
+	go s.runWebhookDispatcher(ctx)
+	go s.realtime.closeAll(ctx)
+	go s.runAttachmentSweeper(ctx)
+	if cfg.ChangeRetention > 0 {
+		go s.runChangePruner(ctx, cfg.ChangeRetention)
+	}
+	go s.runIdempotencyPruner(ctx)
+	go s.runRollover(ctx)
+	if cfg.Breaker != nil {
+		go s.runBreakerProbe(ctx, cfg.Breaker)
+	}
+	if cfg.TrashRetention > 0 {
+		go s.runTrashEmptier(ctx, cfg.TrashRetention)
+	}
+	if cfg.HeartbeatInterval > 0 && cfg.MissedHeartbeats > 0 {
+		go s.runWatchdog(ctx)
+	}
+	if cfg.FeedRefreshInterval > 0 {
+		go s.runFeedRefresher(ctx, cfg.FeedRefreshInterval)
+	}
+	if cfg.TelemetryURL != "" {
+		go s.runTelemetry(ctx)
+	}
+	s.startFeatures(ctx)
 	return s, nil
 }
 
@@ -29,48 +87,895 @@ func (s *Server) routes() {
 		s.Fs,
 	)
 
-	s.Mux.HandleFunc("/health", s.health)
+	// Middleware differs by audience: public endpoints are fetched by other
+	// sites, calendar apps and invitees answering RSVP links, devices are
+	// kiosks reporting in, authenticated is the API the app itself uses, and
+	// admin manages the installation.
+	// Deadlines stay per route, since a group-wide one would cap the longer
+	// ones below.
+	public := newRouteGroup(s, PublicCORSMiddleware)
+	device := newRouteGroup(s)
+	authenticated := newRouteGroup(s, s.scopeMiddleware(false), TimeFormatMiddleware, FieldsMiddleware)
+	admin := newRouteGroup(s, NoStoreMiddleware, s.scopeMiddleware(true), TimeFormatMiddleware)
+	// OAuth providers redirect the browser back without any API token; the
+	// one-time state is what authorises the callback
+	redirects := newRouteGroup(s)
+
+	// Everything that needs the database fails fast while it is unreachable
+	breaker := BreakerMiddleware(s.Config.Breaker)
+	dbTimeout := TimeoutMiddleware(10 * time.Second)
+	dbTimeoutMiddleware := func(next http.Handler) http.Handler { return breaker(dbTimeout(next)) }
+	// Outside the deadline, so time spent queued doesn't count against it
+	heavy := ConcurrencyMiddleware(s.Config.HeavyConcurrency, s.Config.HeavyQueueTimeout)
+
+	public.Handle("/health", http.HandlerFunc(s.health))
+	public.Handle("/readyz", http.HandlerFunc(s.getReadyz))
+	public.HandleAPI("/time", http.HandlerFunc(s.getTime))
+	public.HandleAPI("/version", http.HandlerFunc(s.getVersion))
+	public.HandleAPI("/docs", http.HandlerFunc(s.getAPIDocs))
+	public.HandleAPI("/docs/openapi.json", http.HandlerFunc(s.getOpenAPISpec))
+	public.Handle("/api/published/", heavy(dbTimeoutMiddleware(http.HandlerFunc(s.getPublishedICS))))
+	public.Handle("/api/rsvp/", dbTimeoutMiddleware(http.HandlerFunc(s.rsvpHandler)))
+
+	// More specific than "/devices/", so heartbeats skip the admin chain
+	device.HandleAPI("/devices/{id}/heartbeat", dbTimeoutMiddleware(http.HandlerFunc(s.deviceByIDHandler)))
+
+	events := dbTimeoutMiddleware(http.HandlerFunc(s.eventHandler))
+	eventByID := dbTimeoutMiddleware(http.HandlerFunc(s.eventByIDHandler))
+	authenticated.HandleAPI("/events", events)
+	authenticated.HandleAPI("/events/", eventByID)
+	// Where events were before the API had a prefix
+	authenticated.HandleAlias("", "/events", events)
+	authenticated.HandleAlias("", "/events/", eventByID)
+	authenticated.HandleAPI("/trash", dbTimeoutMiddleware(http.HandlerFunc(s.getTrash)))
+	// Uploads can take a while to arrive before anything is stored
+	authenticated.HandleAPI("/events/{id}/attachments", breaker(TimeoutMiddleware(attachmentTimeout)(http.HandlerFunc(s.eventByIDHandler))))
+	authenticated.HandleAPI("/calendar.ics", heavy(dbTimeoutMiddleware(http.HandlerFunc(s.exportCalendarICS))))
+	authenticated.HandleAPI("/import/ics", dbTimeoutMiddleware(http.HandlerFunc(s.importICS)))
+	authenticated.HandleAPI("/import/", dbTimeoutMiddleware(http.HandlerFunc(s.importSourceHandler)))
 
-	dbTimeoutMiddleware := TimeoutMiddleware(10 * time.Second)
+	authenticated.HandleAPI("/joinable", dbTimeoutMiddleware(http.HandlerFunc(s.getJoinable)))
+	authenticated.HandleAPI("/suggestions", dbTimeoutMiddleware(http.HandlerFunc(s.getSuggestions)))
+	authenticated.HandleAPI("/search", dbTimeoutMiddleware(http.HandlerFunc(s.getSearch)))
 
-	s.Mux.Handle("/events", dbTimeoutMiddleware(http.HandlerFunc(s.eventHandler)))
-	s.Mux.Handle("/events/", dbTimeoutMiddleware(http.HandlerFunc(s.eventByIDHandler)))
+	authenticated.HandleAPI("/occurrences", heavy(dbTimeoutMiddleware(http.HandlerFunc(s.getOccurrences))))
+	authenticated.HandleAPI("/agenda", heavy(dbTimeoutMiddleware(http.HandlerFunc(s.getAgenda))))
+	authenticated.HandleAPI("/freebusy", heavy(dbTimeoutMiddleware(http.HandlerFunc(s.getFreeBusy))))
+	authenticated.HandleAPI("/briefing", dbTimeoutMiddleware(http.HandlerFunc(s.getBriefing)))
+	authenticated.HandleAPI("/changes", dbTimeoutMiddleware(http.HandlerFunc(s.getChanges)))
+	authenticated.HandleAPI("/reminders/active", dbTimeoutMiddleware(http.HandlerFunc(s.getActiveReminders)))
+	authenticated.HandleAPI("/reminders/dismissals", dbTimeoutMiddleware(http.HandlerFunc(s.dismissReminder)))
+	// Long-lived, so no deadline
+	authenticated.HandleAPI("/realtime", http.HandlerFunc(s.realtimeSocket))
+	authenticated.HandleAPI("/briefing.wav", heavy(breaker(TimeoutMiddleware(ttsTimeout)(http.HandlerFunc(s.getBriefingAudio)))))
+
+	// One handler for both, so they share their slots
+	lite := heavy(dbTimeoutMiddleware(http.HandlerFunc(s.liteHandler)))
+	authenticated.Handle("/lite", lite)
+	authenticated.Handle("/lite/", lite)
+
+	authenticated.HandleAPI("/display-profiles", dbTimeoutMiddleware(http.HandlerFunc(s.displayProfileHandler)))
+	authenticated.HandleAPI("/display-profiles/", dbTimeoutMiddleware(http.HandlerFunc(s.displayProfileByIDHandler)))
+
+	authenticated.HandleAPI("/calendars", dbTimeoutMiddleware(http.HandlerFunc(s.calendarHandler)))
+	authenticated.HandleAPI("/calendars/", dbTimeoutMiddleware(http.HandlerFunc(s.calendarByIDHandler)))
+	authenticated.HandleAPI("/tags", dbTimeoutMiddleware(http.HandlerFunc(s.tagHandler)))
+	authenticated.HandleAPI("/tags/", dbTimeoutMiddleware(http.HandlerFunc(s.tagByIDHandler)))
+	authenticated.HandleAPI("/people", dbTimeoutMiddleware(http.HandlerFunc(s.peopleHandler)))
+	authenticated.HandleAPI("/people/", dbTimeoutMiddleware(http.HandlerFunc(s.personByIDHandler)))
+	authenticated.HandleAPI("/colors/contrast", http.HandlerFunc(s.getColorContrast))
+	authenticated.HandleAPI("/settings", dbTimeoutMiddleware(http.HandlerFunc(s.settingsHandler)))
+
+	authenticated.HandleAPI("/feeds", dbTimeoutMiddleware(http.HandlerFunc(s.feedHandler)))
+	// Refreshing fetches a remote calendar, so it gets a longer deadline than plain DB work
+	authenticated.HandleAPI("/feeds/", breaker(TimeoutMiddleware(feedFetchTimeout+10*time.Second)(http.HandlerFunc(s.feedByIDHandler))))
+
+	// Sync and calendar listing call the provider, so they get the sync deadline
+	authenticated.HandleAPI("/integrations/", breaker(TimeoutMiddleware(integrationSyncTimeout)(http.HandlerFunc(s.integrationHandler))))
+	// Providers have this URL registered, so it keeps its place
+	redirects.Handle("/api/integrations/{provider}/callback", http.StripPrefix("/api", breaker(TimeoutMiddleware(integrationSyncTimeout)(http.HandlerFunc(s.integrationHandler)))))
+
+	admin.HandleAPI("/admin/storage", dbTimeoutMiddleware(http.HandlerFunc(s.getStorage)))
+	admin.HandleAPI("/admin/telemetry", dbTimeoutMiddleware(http.HandlerFunc(s.getTelemetry)))
+	admin.HandleAPI(captureRoute, http.HandlerFunc(s.debugCaptureHandler))
+	admin.HandleAPI("/admin/export", heavy(breaker(TimeoutMiddleware(backupTimeout)(http.HandlerFunc(s.getBackup)))))
+	admin.HandleAPI("/admin/import", breaker(TimeoutMiddleware(backupTimeout)(http.HandlerFunc(s.importBackup))))
+	admin.HandleAPI("/admin/backups/verify", heavy(breaker(TimeoutMiddleware(backupTimeout)(http.HandlerFunc(s.verifyBackup)))))
+	admin.HandleAPI("/admin/backup-targets", dbTimeoutMiddleware(http.HandlerFunc(s.backupTargetHandler)))
+	admin.HandleAPI("/admin/backup-targets/", dbTimeoutMiddleware(http.HandlerFunc(s.backupTargetByIDHandler)))
+	admin.HandleAPI("/admin/default-reminders", dbTimeoutMiddleware(http.HandlerFunc(s.addDefaultReminders)))
+	admin.HandleAPI("/admin/merge/", dbTimeoutMiddleware(http.HandlerFunc(s.mergeHandler)))
+	admin.HandleAPI("/admin/open-recurrences", dbTimeoutMiddleware(http.HandlerFunc(s.getOpenRecurrences)))
+	admin.HandleAPI("/admin/open-recurrences/end", dbTimeoutMiddleware(http.HandlerFunc(s.endRecurrences)))
+	admin.HandleAPI("/feed-tokens", dbTimeoutMiddleware(http.HandlerFunc(s.feedTokenHandler)))
+	admin.HandleAPI("/feed-tokens/", dbTimeoutMiddleware(http.HandlerFunc(s.feedTokenByIDHandler)))
+	admin.HandleAPI("/api-tokens", dbTimeoutMiddleware(http.HandlerFunc(s.apiTokenHandler)))
+	admin.HandleAPI("/api-tokens/", dbTimeoutMiddleware(http.HandlerFunc(s.apiTokenByIDHandler)))
+	admin.HandleAPI("/webhooks", dbTimeoutMiddleware(http.HandlerFunc(s.webhookHandler)))
+	admin.HandleAPI("/webhooks/", dbTimeoutMiddleware(http.HandlerFunc(s.webhookByIDHandler)))
+	admin.HandleAPI("/devices", dbTimeoutMiddleware(http.HandlerFunc(s.deviceHandler)))
+	admin.HandleAPI("/devices/", dbTimeoutMiddleware(http.HandlerFunc(s.deviceByIDHandler)))
+	admin.Handle("/metrics", http.HandlerFunc(s.getMetrics))
 }
 
 func (s *Server) eventHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
+		if r.URL.Query().Has("ids") {
+			s.getEventsByID(w, r)
+			return
+		}
 		s.getEvents(w, r)
 	case http.MethodPost:
-		s.createEvent(w, r)
+		s.idempotent(s.createEvent)(w, r)
+	case http.MethodDelete:
+		s.deleteEvents(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) displayProfileHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getDisplayProfiles(w, r)
+	case http.MethodPost:
+		s.createDisplayProfile(w, r)
 	default:
 		w.Header().Set("Allow", "GET, POST")
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func (s *Server) eventByIDHandler(w http.ResponseWriter, r *http.Request) {
-	// Path is like "/events/{id}" (because this handler is registered at "/events/")
-	id := strings.TrimPrefix(r.URL.Path, "/events/")
-	id = strings.Trim(id, "/") // defensive: "/events/{id}/"
+func (s *Server) displayProfileByIDHandler(w http.ResponseWriter, r *http.Request) {
+	// Path is like "/display-profiles/{id}" or "/display-profiles/{id}/occurrences"
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/display-profiles/"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+
+	if id == "" || strings.Contains(action, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !validID(w, "id", id) {
+		return
+	}
+
+	switch action {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			s.getDisplayProfile(w, r, id)
+		case http.MethodPut:
+			s.updateDisplayProfile(w, r, id)
+		case http.MethodDelete:
+			s.deleteDisplayProfile(w, r, id)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "occurrences":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.getDisplayProfileOccurrences(w, r, id)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (s *Server) feedTokenHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getFeedTokens(w, r)
+	case http.MethodPost:
+		s.createFeedToken(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) feedTokenByIDHandler(w http.ResponseWriter, r *http.Request) {
+	// Path is like "/feed-tokens/{id}"
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/feed-tokens/"), "/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !validID(w, "id", id) {
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.deleteFeedToken(w, r, id)
+}
+
+func (s *Server) apiTokenHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getAPITokens(w, r)
+	case http.MethodPost:
+		s.createAPIToken(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) apiTokenByIDHandler(w http.ResponseWriter, r *http.Request) {
+	// Path is like "/api-tokens/{id}"
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api-tokens/"), "/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !validID(w, "id", id) {
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.deleteAPIToken(w, r, id)
+}
+
+func (s *Server) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getWebhooks(w, r)
+	case http.MethodPost:
+		s.createWebhook(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) webhookByIDHandler(w http.ResponseWriter, r *http.Request) {
+	// Path is like "/webhooks/{id}" or "/webhooks/{id}/deliveries"
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/webhooks/"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+	if id == "" || (action != "" && action != "deliveries") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !validID(w, "id", id) {
+		return
+	}
+
+	if action == "deliveries" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.getWebhookDeliveries(w, r, id)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.getWebhook(w, r, id)
+	case http.MethodPut:
+		s.updateWebhook(w, r, id)
+	case http.MethodDelete:
+		s.deleteWebhook(w, r, id)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) calendarHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getCalendars(w, r)
+	case http.MethodPost:
+		s.createCalendar(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) settingsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getSettings(w, r)
+	case http.MethodPut:
+		s.setSettings(w, r)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) calendarByIDHandler(w http.ResponseWriter, r *http.Request) {
+	// Path is like "/calendars/{id}"
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/calendars/"), "/")
 
-	// Reject "/events/" (no id) and deeper paths like "/events/a/b"
 	if id == "" || strings.Contains(id, "/") {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
+	if !validID(w, "id", id) {
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
-		s.getEvent(w, r, id)
+		s.getCalendar(w, r, id)
+	case http.MethodPut:
+		s.updateCalendar(w, r, id)
 	case http.MethodDelete:
-		s.deleteEvent(w, r, id)
+		s.deleteCalendar(w, r, id)
 	default:
-		w.Header().Set("Allow", "GET, DELETE")
+		w.Header().Set("Allow", "GET, PUT, DELETE")
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+func (s *Server) tagHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getTags(w, r)
+	case http.MethodPost:
+		s.createTag(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) tagByIDHandler(w http.ResponseWriter, r *http.Request) {
+	// Path is like "/tags/{id}"
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/tags/"), "/")
+
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !validID(w, "id", id) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.getTag(w, r, id)
+	case http.MethodPut:
+		s.updateTag(w, r, id)
+	case http.MethodDelete:
+		s.deleteTag(w, r, id)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) deviceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getDevices(w, r)
+	case http.MethodPost:
+		s.createDevice(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) deviceByIDHandler(w http.ResponseWriter, r *http.Request) {
+	// Path is like "/devices/{id}", "/devices/{id}/heartbeat" or "/devices/{id}/command"
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/devices/"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+
+	if id == "" || strings.Contains(action, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !validID(w, "id", id) {
+		return
+	}
+
+	switch action {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			s.getDevice(w, r, id)
+		case http.MethodDelete:
+			s.deleteDevice(w, r, id)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "heartbeat", "command":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if action == "heartbeat" {
+			s.deviceHeartbeat(w, r, id)
+		} else {
+			s.queueDeviceCommand(w, r, id)
+		}
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (s *Server) peopleHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getPeople(w, r)
+	case http.MethodPost:
+		s.createPerson(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) personByIDHandler(w http.ResponseWriter, r *http.Request) {
+	// Path is like "/people/{id}", "/people/{id}/push" or "/people/{id}/push/test"
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/people/"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+
+	if id == "" || (action != "" && action != "push" && action != "push/test") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !validID(w, "id", id) {
+		return
+	}
+
+	switch action {
+	case "push":
+		switch r.Method {
+		case http.MethodGet:
+			s.getPushSettings(w, r, id)
+		case http.MethodPut:
+			s.setPushSettings(w, r, id)
+		case http.MethodDelete:
+			s.deletePushSettings(w, r, id)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	case "push/test":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.testPush(w, r, id)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.getPerson(w, r, id)
+	case http.MethodPut:
+		s.updatePerson(w, r, id)
+	case http.MethodDelete:
+		s.deletePerson(w, r, id)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) feedHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getFeeds(w, r)
+	case http.MethodPost:
+		s.createFeed(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) feedByIDHandler(w http.ResponseWriter, r *http.Request) {
+	// Path is like "/feeds/{id}" or "/feeds/{id}/refresh"
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/feeds/"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+
+	if id == "" || strings.Contains(action, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !validID(w, "id", id) {
+		return
+	}
+
+	switch action {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			s.getFeed(w, r, id)
+		case http.MethodPut:
+			s.updateFeed(w, r, id)
+		case http.MethodDelete:
+			s.deleteFeed(w, r, id)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "refresh":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.refreshFeedNow(w, r, id)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (s *Server) integrationHandler(w http.ResponseWriter, r *http.Request) {
+	// Path is like "/integrations/{provider}[/{id}[/{action}]]"
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/integrations/"), "/")
+	name, rest, _ := strings.Cut(rest, "/")
+	id, action, _ := strings.Cut(rest, "/")
+
+	if name == "" || strings.Contains(action, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	p, ok := s.provider(w, name)
+	if !ok {
+		return
+	}
+
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			s.getIntegrationAccounts(w, r, p)
+		case http.MethodPost:
+			s.createIntegrationAccount(w, r, p)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	if _, redirect := p.(integrations.RedirectAuth); redirect && id == "callback" && action == "" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.oauthCallback(w, r, p)
+		return
+	}
+	if !validID(w, "id", id) {
+		return
+	}
+
+	switch action {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			s.getIntegrationAccount(w, r, p, id)
+		case http.MethodPut:
+			s.updateIntegrationAccount(w, r, p, id)
+		case http.MethodDelete:
+			s.deleteIntegrationAccount(w, r, p, id)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "calendars":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.getIntegrationCalendars(w, r, p, id)
+	case "connect":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.reconnectIntegrationAccount(w, r, p, id)
+	case "sync":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.syncIntegrationNow(w, r, p, id)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (s *Server) eventByIDHandler(w http.ResponseWriter, r *http.Request) {
+	// Path is like "/events/{id}", "/events/{id}.ics" or "/events/{id}/{action}"
+	rest := strings.TrimPrefix(r.URL.Path, "/events/")
+
+	if id, ok := strings.CutSuffix(rest, ".ics"); ok && id != "" && !strings.Contains(id, "/") {
+		if !validID(w, "id", id) {
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.exportEventICS(w, r, id)
+		return
+	}
+
+	// "/events/external/{source}/{uid}"; the uid is everything after the source
+	if key, ok := strings.CutPrefix(rest, "external/"); ok {
+		source, uid, _ := strings.Cut(key, "/")
+		if source == "" || uid == "" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodPut {
+			w.Header().Set("Allow", "PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.upsertExternalEvent(w, r, source, uid)
+		return
+	}
+
+	id, action, _ := strings.Cut(strings.Trim(rest, "/"), "/")
+	action, sub, _ := strings.Cut(action, "/")
+	if id == "" || strings.Contains(sub, "/") || (sub != "" && action != "exceptions" && action != "attendees" && action != "reminders" && action != "attachments" && action != "completions" && action != "pauses" && action != "rrule") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !validID(w, "id", id) {
+		return
+	}
+
+	switch action {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			s.getEvent(w, r, id)
+		case http.MethodDelete:
+			s.deleteEvent(w, r, id)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "exceptions":
+		// "/events/{id}/exceptions" or "/events/{id}/exceptions/{recurrenceId}"
+		if sub != "" {
+			if r.Method != http.MethodDelete {
+				w.Header().Set("Allow", "DELETE")
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			s.deleteEventException(w, r, id, sub)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			s.getEventExceptions(w, r, id)
+		case http.MethodPost:
+			s.createEventException(w, r, id)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "attendees":
+		// "/events/{id}/attendees" or "/events/{id}/attendees/{attendeeId}"
+		if sub != "" {
+			if !validID(w, "attendeeId", sub) {
+				return
+			}
+			switch r.Method {
+			case http.MethodPost:
+				s.issueRSVPLink(w, r, id, sub)
+			case http.MethodPut:
+				s.setAttendeeStatus(w, r, id, sub)
+			case http.MethodDelete:
+				s.deleteAttendee(w, r, id, sub)
+			default:
+				w.Header().Set("Allow", "POST, PUT, DELETE")
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			s.getAttendees(w, r, id)
+		case http.MethodPost:
+			s.addAttendee(w, r, id)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "reminders":
+		// "/events/{id}/reminders" or "/events/{id}/reminders/{reminderId}"
+		if sub != "" {
+			if !validID(w, "reminderId", sub) {
+				return
+			}
+			if r.Method != http.MethodDelete {
+				w.Header().Set("Allow", "DELETE")
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			s.deleteReminder(w, r, id, sub)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			s.getReminders(w, r, id)
+		case http.MethodPost:
+			s.createReminder(w, r, id)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "attachments":
+		// "/events/{id}/attachments" or "/events/{id}/attachments/{attachmentId}"
+		if sub != "" {
+			if !validID(w, "attachmentId", sub) {
+				return
+			}
+			switch r.Method {
+			case http.MethodGet:
+				s.downloadAttachment(w, r, id, sub)
+			case http.MethodDelete:
+				s.deleteAttachment(w, r, id, sub)
+			default:
+				w.Header().Set("Allow", "GET, DELETE")
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			s.getAttachments(w, r, id)
+		case http.MethodPost:
+			s.uploadAttachment(w, r, id)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "completions":
+		// "/events/{id}/completions" or "/events/{id}/completions/{recurrenceId}"
+		if sub != "" {
+			if r.Method != http.MethodDelete {
+				w.Header().Set("Allow", "DELETE")
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			s.uncompleteTask(w, r, id, sub)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			s.getTaskCompletions(w, r, id)
+		case http.MethodPost:
+			s.completeTask(w, r, id)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "pauses":
+		// "/events/{id}/pauses" or "/events/{id}/pauses/{pauseId}"
+		if sub != "" {
+			if !validID(w, "pauseId", sub) {
+				return
+			}
+			if r.Method != http.MethodDelete {
+				w.Header().Set("Allow", "DELETE")
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			s.deleteEventPause(w, r, id, sub)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			s.getEventPauses(w, r, id)
+		case http.MethodPost:
+			s.createEventPause(w, r, id)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "cancel", "move":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		kind := schemas.ExceptionCancel
+		if action == "move" {
+			kind = schemas.ExceptionMove
+		}
+		s.bulkExceptions(w, r, id, kind)
+	case "restore":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.restoreEvent(w, r, id)
+	case "split":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.splitSeries(w, r, id)
+	case "rrule":
+		// "/events/{id}/rrule/preview-change"
+		if sub != "preview-change" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.previewRruleChange(w, r, id)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// Handler is the mux wrapped in the middleware every response goes through.
+func (s *Server) Handler() http.Handler {
+	var h http.Handler = s.Mux
+	if s.Config.APISpecCheck {
+		h = specCheckMiddleware(s.apiDoc, s.Config.APIPrefix)(h)
+	}
+	h = ClockMiddleware(s.Config.MaxClockSkew)(h)
+	h = captureMiddleware(&s.capture)(h)
+	if s.Config.QueryWarnThreshold > 0 {
+		h = QueryCountMiddleware(s.Config.QueryWarnThreshold)(h)
+	}
+	if len(s.Config.TrustedProxies) > 0 {
+		h = RealIPMiddleware(s.Config.TrustedProxies)(h)
+	}
+	return h
+}
+
+func (s *Server) getTime(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	out := ServerTime{Now: now.UTC(), UnixMillis: now.UnixMilli()}
+	if ct, ok := clientTime(r); ok {
+		skew := ct.Sub(now).Seconds()
+		out.SkewSeconds = &skew
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, out)
+}
+
 func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("ok"))
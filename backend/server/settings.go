@@ -0,0 +1,37 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"pical/database/schemas"
+)
+
+func (s *Server) getSettings(w http.ResponseWriter, r *http.Request) {
+	out, err := schemas.GetSettings(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// setSettings replaces the household's settings; fields left out go back to
+// their defaults.
+func (s *Server) setSettings(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	in := schemas.DefaultSettings()
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	out, err := schemas.SetSettings(r.Context(), s.DB, in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pical/database/schemas"
+	"pical/recurrence"
+	"time"
+)
+
+// splitSeries implements "this and following": the series ends just before
+// the chosen occurrence, and a new series carries on from it. Without an
+// event in the request the new series is a copy starting at that occurrence.
+// If-Match is optional; when sent, a stale version gets 412 and no split.
+func (s *Server) splitSeries(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var in SplitRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	e, ok := s.editableSeries(w, r, id)
+	if !ok {
+		return
+	}
+	version, _ := ifMatchVersion(r)
+	if version != 0 && version != e.Version {
+		http.Error(w, schemas.ErrVersionMismatch.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
+	if in.RecurrenceID.IsZero() {
+		http.Error(w, "recurrenceId is required", http.StatusBadRequest)
+		return
+	}
+	if !occursAt(*e, in.RecurrenceID) {
+		http.Error(w, "recurrenceId is not an occurrence of the series", http.StatusBadRequest)
+		return
+	}
+	if !in.RecurrenceID.After(e.StartTime) {
+		http.Error(w, "cannot split at the first occurrence; edit the whole series instead", http.StatusBadRequest)
+		return
+	}
+
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	start := e.StartTime.In(loc)
+	split := in.RecurrenceID.In(loc)
+
+	rule, err := recurrence.Parse(*e.Rrule)
+	if err != nil {
+		http.Error(w, "rrule: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	rest := rule
+
+	// Keep COUNT rules as counts so each half still reads naturally
+	if rule.Count > 0 {
+		before := len(rule.Between(start, start, split))
+		rule.Count = before
+		rest.Count -= before
+	} else if e.AllDay {
+		rule.SetUntilDate(split.AddDate(0, 0, -1))
+	} else {
+		rule.SetUntil(split.Add(-time.Second))
+	}
+
+	next := *e
+	moveExceptions := in.Event == nil
+	if in.Event != nil {
+		next = *in.Event
+	} else {
+		restRrule := rest.String()
+		next.Rrule = &restRrule
+		next.StartTime = split
+		if e.EndTime != nil {
+			end := split.Add(e.EndTime.Sub(e.StartTime))
+			if e.AllDay {
+				end = e.EndTime.In(loc).AddDate(0, 0, daysBetween(start, split))
+			}
+			next.EndTime = &end
+		}
+	}
+	next.EventID = ""
+
+	orig, created, err := schemas.SplitSeries(r.Context(), s.DB, id, version, rule.String(), next, in.RecurrenceID, moveExceptions)
+	if err != nil {
+		if errors.Is(err, schemas.ErrVersionMismatch) {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.notifyEventChanged(r.Context(), orig, fmt.Sprintf("changed from %s onwards", occurrenceDay(orig, in.RecurrenceID)))
+	s.emitChange(r.Context(), schemas.WebhookEventUpdated, orig)
+	s.emitChange(r.Context(), schemas.WebhookEventCreated, created)
+
+	writeJSON(w, http.StatusCreated, SplitReport{Original: orig, Created: created})
+}
+
+// daysBetween counts calendar days from a to b in a's location.
+func daysBetween(a, b time.Time) int {
+	b = b.In(a.Location())
+	from := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	to := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(to.Sub(from).Hours() / 24)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"pical/database/schemas"
+	"time"
+)
+
+// retentionPolicies says which tables are pruned, and how, under cfg.
+func retentionPolicies(cfg Config) map[string]RetentionPolicy {
+	seconds := func(d time.Duration) int64 { return int64(d / time.Second) }
+	return map[string]RetentionPolicy{
+		"events":              {Covers: "trashed events", MaxAgeSeconds: seconds(cfg.TrashRetention)},
+		"event_changes":       {Covers: "deletions", MaxAgeSeconds: seconds(cfg.ChangeRetention)},
+		"webhook_deliveries":  {Covers: "finished deliveries", MaxAgeSeconds: seconds(cfg.WebhookLogRetention), MaxRows: cfg.WebhookLogMaxRows},
+		"reminder_deliveries": {Covers: "all", MaxAgeSeconds: seconds(reminderDeliveryRetention)},
+		"idempotency_keys":    {Covers: "all", MaxAgeSeconds: seconds(idempotencyRetention)},
+	}
+}
+
+// getStorage reports how much room each table takes up, with how it is
+// pruned for those that are.
+func (s *Server) getStorage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tables, total, err := schemas.ListTableStorage(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	policies := retentionPolicies(s.Config)
+	out := StorageReport{DatabaseBytes: total, Tables: make([]TableUsage, 0, len(tables))}
+	for _, t := range tables {
+		u := TableUsage{TableStorage: t}
+		if p, ok := policies[t.Name]; ok {
+			u.Retention = &p
+		}
+		out.Tables = append(out.Tables, u)
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
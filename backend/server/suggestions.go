@@ -0,0 +1,24 @@
+package server
+
+import (
+	"net/http"
+	"pical/database/schemas"
+)
+
+func (s *Server) getSuggestions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := parseIntQuery(r, "limit", 8, 1, 50)
+
+	out, err := schemas.SuggestFromHistory(r.Context(), s.DB, r.URL.Query().Get("q"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
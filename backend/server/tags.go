@@ -0,0 +1,95 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pical/database/schemas"
+)
+
+func (s *Server) getTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := schemas.ListTags(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tags)
+}
+
+func (s *Server) createTag(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in schemas.Tag
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	created, err := schemas.CreateTag(r.Context(), s.DB, in)
+	if err != nil {
+		if errors.Is(err, schemas.ErrTagExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) getTag(w http.ResponseWriter, r *http.Request, id string) {
+	t, err := schemas.GetTag(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "tag not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, t)
+}
+
+func (s *Server) updateTag(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var in schemas.Tag
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := schemas.UpdateTag(r.Context(), s.DB, id, in)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "tag not found", http.StatusNotFound)
+		case errors.Is(err, schemas.ErrTagExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// deleteTag also takes the tag off every event that had it.
+func (s *Server) deleteTag(w http.ResponseWriter, r *http.Request, id string) {
+	err := schemas.DeleteTag(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "tag not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"pical/database/schemas"
+	"pical/recurrence"
+	"time"
+)
+
+const (
+	// How often rollover runs; each task rolls over the first time it runs
+	// after midnight in the task's own timezone
+	rolloverInterval = time.Hour
+	// How far back rollover looks for unfinished occurrences, so a server
+	// that was off for a while still catches up
+	rolloverLookback = 14
+)
+
+// taskOccurrence returns the task e, or answers 404 or 409 and false. A
+// recurrenceId must be one of its occurrences.
+func (s *Server) taskOccurrence(w http.ResponseWriter, r *http.Request, id string, recurrenceID time.Time) (*schemas.Event, bool) {
+	e, err := s.Events.GetEvent(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if !e.Task {
+		http.Error(w, "event is not a task", http.StatusConflict)
+		return nil, false
+	}
+	if recurrenceID.IsZero() {
+		return e, true
+	}
+	if recurrence.Recurs(*e) && !occursAt(*e, recurrenceID) || !recurrence.Recurs(*e) && !recurrenceID.Equal(e.StartTime) {
+		http.Error(w, "recurrenceId is not an occurrence of the task", http.StatusBadRequest)
+		return nil, false
+	}
+	return e, true
+}
+
+func (s *Server) getTaskCompletions(w http.ResponseWriter, r *http.Request, id string) {
+	if _, ok := s.taskOccurrence(w, r, id, time.Time{}); !ok {
+		return
+	}
+
+	out, err := schemas.ListTaskCompletions(r.Context(), s.DB, []string{id})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	limit, offset := s.parsePage(r)
+	writeJSON(w, http.StatusOK, pageOf(out, limit, offset))
+}
+
+// completeTask ticks off one occurrence of a task, which also stops it
+// rolling over.
+func (s *Server) completeTask(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var in schemas.TaskCompletion
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if in.RecurrenceID.IsZero() {
+		http.Error(w, "recurrenceId is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := s.taskOccurrence(w, r, id, in.RecurrenceID); !ok {
+		return
+	}
+
+	out, err := schemas.CompleteTask(r.Context(), s.DB, id, in.RecurrenceID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, out)
+}
+
+func (s *Server) uncompleteTask(w http.ResponseWriter, r *http.Request, id, recurrenceID string) {
+	rid, err := time.Parse(time.RFC3339, recurrenceID)
+	if err != nil {
+		http.Error(w, "recurrenceId must be an RFC 3339 time", http.StatusBadRequest)
+		return
+	}
+
+	if err := schemas.UncompleteTask(r.Context(), s.DB, id, rid); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "completion not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// markCompleted sets Completed on the occurrences of tasks that are done.
+func (s *Server) markCompleted(ctx context.Context, occs []EventOccurrence) error {
+	ids := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, occ := range occs {
+		if occ.Event.Task && !seen[occ.Event.EventID] {
+			seen[occ.Event.EventID] = true
+			ids = append(ids, occ.Event.EventID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	done, err := schemas.ListTaskCompletions(ctx, s.DB, ids)
+	if err != nil {
+		return err
+	}
+	byKey := make(map[string]bool, len(done))
+	for _, c := range done {
+		byKey[completionKey(c.EventID, c.RecurrenceID)] = true
+	}
+	for i, occ := range occs {
+		occs[i].Completed = byKey[completionKey(occ.Event.EventID, occ.RecurrenceID)]
+	}
+	return nil
+}
+
+func completionKey(eventID string, recurrenceID time.Time) string {
+	return eventID + "/" + recurrenceID.UTC().Format(time.RFC3339Nano)
+}
+
+// rollOver moves every unfinished occurrence of a rollover task that is
+// over by now onto today, in the task's timezone, with a move exception.
+func (s *Server) rollOver(ctx context.Context, now time.Time) error {
+	events, err := schemas.ListRolloverEvents(ctx, s.DB)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	exs, err := schemas.ListAllExceptions(ctx, s.DB)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(events))
+	for i, e := range events {
+		ids[i] = e.EventID
+	}
+	done, err := schemas.ListTaskCompletions(ctx, s.DB, ids)
+	if err != nil {
+		return err
+	}
+	completed := make(map[string]bool, len(done))
+	for _, c := range done {
+		completed[completionKey(c.EventID, c.RecurrenceID)] = true
+	}
+
+	for _, e := range events {
+		loc, err := time.LoadLocation(e.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		local := now.In(loc)
+		today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+
+		moves := make([]schemas.Exception, 0)
+		for _, occ := range recurrence.Expand(e, exs[e.EventID], today.AddDate(0, 0, -rolloverLookback), today) {
+			if completed[completionKey(e.EventID, occ.RecurrenceID)] {
+				continue
+			}
+			if occ.EndTime != nil && occ.EndTime.After(today) {
+				continue
+			}
+			start := occ.StartTime.In(loc)
+			newStart := time.Date(today.Year(), today.Month(), today.Day(), start.Hour(), start.Minute(), start.Second(), 0, loc)
+			ex := schemas.Exception{EventID: e.EventID, RecurrenceID: occ.RecurrenceID, Kind: schemas.ExceptionMove, NewStart: &newStart}
+			if occ.EndTime != nil {
+				// Keep multi-day chores as many days long
+				end := occ.EndTime.In(loc)
+				days := int(time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC).Sub(time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)).Hours() / 24)
+				newEnd := time.Date(newStart.Year(), newStart.Month(), newStart.Day()+days, end.Hour(), end.Minute(), end.Second(), 0, loc)
+				ex.NewEnd = &newEnd
+			}
+			moves = append(moves, ex)
+		}
+		if len(moves) == 0 {
+			continue
+		}
+
+		if err := schemas.UpsertExceptions(ctx, s.DB, moves, false); err != nil {
+			return err
+		}
+		s.emitChange(ctx, schemas.WebhookExceptionAdded, e, moves...)
+	}
+	return nil
+}
+
+// runRollover rolls unfinished chores over until ctx is canceled.
+func (s *Server) runRollover(ctx context.Context) {
+	ticker := time.NewTicker(rolloverInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.rollOver(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("rollover: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"pical/database/schemas"
+	"runtime"
+	"slices"
+	"time"
+)
+
+const (
+	telemetryInterval = 24 * time.Hour
+	// The first report waits a while, so a server that is being set up or
+	// restarted over and over doesn't send one each time
+	telemetryDelay = time.Hour
+)
+
+var telemetryClient = &http.Client{Timeout: 30 * time.Second}
+
+// telemetryReport is what runTelemetry sends: nothing that identifies the
+// household or the install, only what the build is, what it has turned on,
+// and roughly how much it holds.
+func (s *Server) telemetryReport(ctx context.Context) (TelemetryReport, error) {
+	_, events, err := s.Events.ListEventsAfter(ctx, schemas.EventFilter{}, "", nil, 0)
+	if err != nil {
+		return TelemetryReport{}, err
+	}
+
+	out := TelemetryReport{
+		Version:  Version,
+		Platform: runtime.GOOS + "/" + runtime.GOARCH,
+		Features: make([]string, 0),
+		Events:   countBucket(events),
+	}
+	for _, f := range optionalFeatures {
+		if f.setup != nil && f.configured(s.Config) {
+			out.Features = append(out.Features, f.name)
+		}
+	}
+	cfg := s.Config
+	for name, on := range map[string]bool{
+		"alerts":            cfg.AlertURL != "",
+		"audioBriefing":     cfg.TTSCommand != "",
+		"feedRefresh":       cfg.FeedRefreshInterval > 0,
+		"heartbeatWatchdog": cfg.HeartbeatInterval > 0 && cfg.MissedHeartbeats > 0,
+		"occurrenceCache":   cfg.RecurrenceHorizonMonths > 0,
+		"requireApiToken":   cfg.RequireAPIToken,
+	} {
+		if on {
+			out.Features = append(out.Features, name)
+		}
+	}
+	slices.Sort(out.Features)
+	return out, nil
+}
+
+// countBucket rounds n down to a power of ten, so a report says how big an
+// install is without giving its exact size: "0", "1+", "10+", "100+" and so
+// on.
+func countBucket(n int) string {
+	if n <= 0 {
+		return "0"
+	}
+	bucket := 1
+	for bucket*10 <= n {
+		bucket *= 10
+	}
+	return fmt.Sprintf("%d+", bucket)
+}
+
+// getTelemetry shows the report telemetry sends, or would send when it is
+// turned on, and where it goes.
+func (s *Server) getTelemetry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := s.telemetryReport(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, TelemetryPreview{
+		Enabled: s.Config.TelemetryURL != "",
+		URL:     s.Config.TelemetryURL,
+		Report:  report,
+	})
+}
+
+// runTelemetry posts a report to TelemetryURL every telemetryInterval.
+// Failures are only logged.
+func (s *Server) runTelemetry(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(telemetryDelay):
+	}
+
+	ticker := time.NewTicker(telemetryInterval)
+	defer ticker.Stop()
+
+	for {
+		sendCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		if err := s.sendTelemetry(sendCtx); err != nil && ctx.Err() == nil {
+			log.Printf("telemetry: %v", err)
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) sendTelemetry(ctx context.Context) error {
+	report, err := s.telemetryReport(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.Config.TelemetryURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := telemetryClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
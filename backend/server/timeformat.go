@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"pical/database/schemas"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeFormatHeader picks the time format like ?timeFormat= does.
+const timeFormatHeader = "X-Time-Format"
+
+type apiTokenKey struct{}
+
+// requestAPIToken is the token scopeMiddleware accepted for r, if any.
+func requestAPIToken(r *http.Request) *schemas.APIToken {
+	t, _ := r.Context().Value(apiTokenKey{}).(*schemas.APIToken)
+	return t
+}
+
+func withAPIToken(ctx context.Context, t *schemas.APIToken) context.Context {
+	return context.WithValue(ctx, apiTokenKey{}, t)
+}
+
+// TimeFormatMiddleware picks how writeJSON writes times for the request:
+// ?timeFormat=, else the X-Time-Format header, else the API token's
+// timeFormat, else RFC 3339 in UTC. It runs after scopeMiddleware, which
+// finds the token.
+func TimeFormatMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("timeFormat")
+		if format == "" {
+			format = strings.TrimSpace(r.Header.Get(timeFormatHeader))
+		}
+		if format == "" {
+			if t := requestAPIToken(r); t != nil {
+				format = t.TimeFormat
+			}
+		}
+		if format != "" {
+			if !slices.Contains(schemas.TimeFormats, format) {
+				http.Error(w, "timeFormat must be one of "+strings.Join(schemas.TimeFormats, ", "), http.StatusBadRequest)
+				return
+			}
+			jw := jsonOptions(w)
+			jw.times = format
+			w = jw
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// formatTimes rewrites the times in j, the decoded JSON of v, in format.
+// v's type says which strings are times, so text that only looks like one,
+// such as a title, is left alone. Values with their own MarshalJSON are kept
+// as they encoded themselves.
+func formatTimes(v reflect.Value, j any, format string) any {
+	if !v.IsValid() || !hasTimes(v.Type()) {
+		return j
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return j
+		}
+		return formatTimes(v.Elem(), j, format)
+	}
+	if v.Type() == timeType {
+		if _, ok := j.(string); !ok {
+			return j
+		}
+		t := v.Interface().(time.Time)
+		if format == schemas.TimeFormatEpochMillis {
+			return json.Number(strconv.FormatInt(t.UnixMilli(), 10))
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	if marshals(v.Type()) {
+		return j
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if m, ok := j.(map[string]any); ok {
+			formatStructTimes(v, m, format, nil)
+		}
+	case reflect.Slice, reflect.Array:
+		if a, ok := j.([]any); ok && len(a) == v.Len() {
+			for i := range a {
+				a[i] = formatTimes(v.Index(i), a[i], format)
+			}
+		}
+	case reflect.Map:
+		m, ok := j.(map[string]any)
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			return j
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			k := iter.Key().String()
+			if val, ok := m[k]; ok {
+				m[k] = formatTimes(iter.Value(), val, format)
+			}
+		}
+	}
+	return j
+}
+
+// formatStructTimes is formatTimes for a struct's fields, which encoding/json
+// has put in m. Fields of embedded structs are in m too, unless a field of
+// the outer struct, in shadowed, took their name.
+func formatStructTimes(v reflect.Value, m map[string]any, format string, shadowed map[string]bool) {
+	own := make(map[string]bool)
+	var embedded []reflect.Value
+	t := v.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || shadowed[name] {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			fv := v.Field(i)
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct && !marshals(fv.Type()) {
+				embedded = append(embedded, fv)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		own[name] = true
+		if val, ok := m[name]; ok {
+			m[name] = formatTimes(v.Field(i), val, format)
+		}
+	}
+	for name := range shadowed {
+		own[name] = true
+	}
+	for _, fv := range embedded {
+		formatStructTimes(fv, m, format, own)
+	}
+}
+
+var (
+	timeType      = reflect.TypeFor[time.Time]()
+	marshalerType = reflect.TypeFor[json.Marshaler]()
+	// By type, whether it can hold a time.Time anywhere in it
+	timeTypes sync.Map
+)
+
+func marshals(t reflect.Type) bool {
+	return t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType)
+}
+
+// hasTimes reports whether a value of type t can have a time.Time in it, so
+// the walk can skip everything that can't.
+func hasTimes(t reflect.Type) bool {
+	if v, ok := timeTypes.Load(t); ok {
+		return v.(bool)
+	}
+	// A type that refers back to itself is assumed to until worked out
+	timeTypes.Store(t, true)
+	found := false
+	switch t.Kind() {
+	case reflect.Interface:
+		found = true
+	case reflect.Struct:
+		if t == timeType {
+			found = true
+			break
+		}
+		// Other types that encode themselves are left as they are
+		if marshals(t) {
+			break
+		}
+		for i := range t.NumField() {
+			if hasTimes(t.Field(i).Type) {
+				found = true
+				break
+			}
+		}
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		found = hasTimes(t.Elem())
+	}
+	timeTypes.Store(t, found)
+	return found
+}
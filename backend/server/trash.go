@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"pical/database/schemas"
+	"time"
+)
+
+// restoreEvent takes an event back out of the trash.
+func (s *Server) restoreEvent(w http.ResponseWriter, r *http.Request, id string) {
+	out, err := schemas.RestoreEvent(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "event not in the trash", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.notifyEventChanged(r.Context(), out, "was restored")
+	s.emitChange(r.Context(), schemas.WebhookEventCreated, out)
+
+	w.Header().Set("ETag", eventETag(out))
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) getTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, offset := s.parsePage(r)
+	events, total, err := schemas.ListTrashedEvents(r.Context(), s.DB, limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := PagedResponse[schemas.Event]{
+		Items:  events,
+		Limit:  limit,
+		Offset: offset,
+		Count:  len(events),
+		Total:  total,
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// runTrashEmptier deletes events that have been in the trash for longer
+// than the retention daily until ctx is canceled.
+func (s *Server) runTrashEmptier(ctx context.Context, retention time.Duration) {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		emptyCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		if err := schemas.EmptyTrash(emptyCtx, s.DB, time.Now().Add(-retention)); err != nil && ctx.Err() == nil {
+			log.Printf("empty trash: %v", err)
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
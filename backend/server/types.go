@@ -3,12 +3,226 @@ package server
 import (
 	"database/sql"
 	"net/http"
+	"net/netip"
+	"pical/backups"
+	"pical/database"
+	"pical/database/schemas"
+	"pical/geocode"
+	"pical/importers"
+	"pical/integrations"
+	"pical/store"
+	"pical/travel"
+	"pical/virusscan"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Server struct {
-	DB  *sql.DB
-	Mux *http.ServeMux
-	Fs  http.Handler
+	DB *sql.DB
+	// Where event handlers read and write events; everything else still
+	// goes to DB directly
+	Events store.EventStore
+	Mux    *http.ServeMux
+	Fs     http.Handler
+	Config Config
+
+	// Calendar integrations with credentials configured, by provider name
+	providers map[string]integrations.Provider
+	// Resolves event locations to coordinates; nil when none is configured
+	geocoder geocode.Geocoder
+	// Works out travel times for leave-by reminders; nil when none is configured
+	router travel.Router
+	// Checks attachment uploads; nil when none is configured
+	scanner virusscan.Scanner
+	// BACKUP_TARGET; nil when none is configured
+	backups      backups.Target
+	backupStatus atomic.Pointer[BackupStatus]
+	// The latest restore drill; nil until one has run
+	backupDrill atomic.Pointer[RestoreDrill]
+	// Signals the webhook dispatcher that deliveries were queued
+	webhookWake chan struct{}
+	// Clients connected to /api/realtime
+	realtime *realtimeHub
+	oauth    oauthStates
+	// Where API tokens are looked up; the database when nil
+	tokens apiTokenLookup
+	syncMu sync.Mutex
+	// Set once startup warm-up is over; /readyz is 503 until then
+	warm atomic.Bool
+	// Unfiltered expansions such as the agenda and month grid, kept until
+	// an event changes
+	warmed warmCache
+	// Requests and webhook deliveries kept for debugging while an admin
+	// has asked for them
+	capture debugCapture
+	// Every pattern the route groups registered, and the spec documenting
+	// them, served at /api/docs
+	routePatterns []string
+	apiDoc        apiDoc
+}
+
+// Config holds the tunables main reads from the environment.
+type Config struct {
+	// How often subscribed ICS feeds are re-fetched; zero disables the refresher
+	FeedRefreshInterval time.Duration
+	// How long before an occurrence starts its meeting link is surfaced as joinable
+	JoinWindow time.Duration
+	// How far a client's clock may drift before its mutations are refused; zero disables the check
+	MaxClockSkew time.Duration
+	// How many months ahead recurring events are kept expanded in the
+	// occurrence cache; zero turns the cache off
+	RecurrenceHorizonMonths int
+	// Requests running more queries than this are logged with a stack; zero
+	// disables the check. Needs the database opened with CountQueries
+	QueryWarnThreshold int
+	// How often kiosks are asked to send a heartbeat, and how many in a row
+	// they may miss before being flagged; either zero disables the watchdog
+	HeartbeatInterval time.Duration
+	MissedHeartbeats  int
+	// Admin alerts are POSTed here as plain text; empty only logs them
+	AlertURL string
+	// Where scheduled backups are written, besides the targets added under
+	// /admin/backup-targets; an empty URL leaves just those. Its Key is
+	// taken from BackupKey
+	BackupTarget backups.TargetConfig
+	// Encrypts backups to BackupTarget, and to added targets asking for it;
+	// nil leaves them as they are
+	BackupKey []byte
+	// How often a backup is made, and how many BackupTarget keeps; zero
+	// BackupKeep keeps them all
+	BackupInterval time.Duration
+	BackupKeep     int
+	// Whether each scheduled backup is restored into a scratch schema
+	// afterwards, to prove it can be
+	BackupRestoreDrill bool
+	// Anonymous usage reports are POSTed here daily as JSON; empty, the
+	// default, sends nothing
+	TelemetryURL string
+	// Speech synthesizer for the audio briefing, split on spaces; it reads
+	// text on stdin and writes WAV to stdout. Empty disables the audio
+	TTSCommand string
+	// Nominatim server used to find coordinates for event locations; empty
+	// disables geocoding
+	GeocoderURL string
+	// Routing service for leave-by reminders, and where trips start from,
+	// usually home. Without a Provider they fire as plain reminders
+	Travel       travel.Config
+	TravelOrigin travel.Point
+	// Where attachment files are kept, and what uploads may be
+	AttachmentDir      string
+	AttachmentMaxBytes int64
+	// Accepted content types, as sniffed from the file itself
+	AttachmentTypes []string
+	// clamd socket (unix:// or tcp://) or HTTP scanner that uploads are
+	// checked with; empty stores them unscanned
+	AttachmentScanner string
+	// Paged listings return PageSize items unless ?limit asks for another
+	// number, which is capped at MaxPageSize
+	PageSize    int
+	MaxPageSize int
+	// How many requests to each heavy endpoint (expansion, export and audio)
+	// run at once, and how long the rest wait before being refused; zero
+	// HeavyConcurrency leaves them unlimited
+	HeavyConcurrency  int
+	HeavyQueueTimeout time.Duration
+	// How long deleted events stay in the trash; zero keeps them until
+	// deleted for good by hand
+	TrashRetention time.Duration
+	// How long deleted events are remembered for /api/changes; a sync token
+	// older than that has expired. Zero remembers them for good
+	ChangeRetention time.Duration
+	// Finished webhook deliveries are kept for WebhookLogRetention, and
+	// only the newest WebhookLogMaxRows of them; zero lifts either limit
+	WebhookLogRetention time.Duration
+	WebhookLogMaxRows   int
+	// Proxies whose X-Forwarded-For and X-Real-IP headers are believed
+	// about who the client is; empty takes every request's address as is
+	TrustedProxies []netip.Prefix
+	// Refuse requests to the app and admin APIs that carry no API token.
+	// Otherwise only requests that do carry one are held to its scopes
+	RequireAPIToken bool
+	// Shared with the database connector; while it is open, requests that
+	// need the database get a 503 straight away. Nil disables it
+	Breaker *database.Breaker
+	// Log responses that don't match the API spec, for catching drift while
+	// developing; it buffers every API response, so it is off by default
+	APISpecCheck bool
+	// Where the versioned API is served, like "/api/v1"; a v2 can later be
+	// mounted beside it. The routes also answer under /api, deprecated
+	APIPrefix string
+
+	// OAuth client for Google Calendar sync; sync is disabled without a client ID
+	GoogleClientID     string
+	GoogleClientSecret string
+	GoogleRedirectURL  string
+	// How often connected Google accounts are synced; zero disables background sync
+	GoogleSyncInterval time.Duration
+	// Public client for Microsoft 365/Outlook sync; sync is disabled without a client ID
+	MicrosoftClientID     string
+	MicrosoftTenant       string
+	MicrosoftSyncInterval time.Duration
+}
+
+// Heartbeat answers a kiosk's heartbeat.
+type Heartbeat struct {
+	// "reload" or "reboot", when one was queued while the device was away
+	Command         *string `json:"command,omitempty"`
+	IntervalSeconds int     `json:"intervalSeconds"`
+}
+
+// RSVPRequest sets an attendee's response.
+type RSVPRequest struct {
+	Status schemas.RSVPStatus `json:"status"`
+}
+
+// DeviceCommand queues a command for a kiosk's next heartbeat.
+type DeviceCommand struct {
+	Command string `json:"command"`
+}
+
+// ServerTime is what /api/time reports, for clients checking their clock.
+type ServerTime struct {
+	Now        time.Time `json:"now"`
+	UnixMillis int64     `json:"unixMillis"`
+	// Client clock minus server clock, when the request carried the client's time
+	SkewSeconds *float64 `json:"skewSeconds,omitempty"`
+}
+
+// VersionInfo answers /api/version.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Tags      string `json:"tags,omitempty"`
+	GoVersion string `json:"goVersion"`
+	// Every optional feature, whether or not this build has it
+	Features []FeatureInfo `json:"features"`
+}
+
+type FeatureInfo struct {
+	Name string `json:"name"`
+	// Left out by a -tags minimal build when false
+	Compiled bool `json:"compiled"`
+	// Compiled in and configured
+	Enabled bool `json:"enabled"`
+}
+
+// TelemetryReport is the anonymous usage report; see runTelemetry.
+type TelemetryReport struct {
+	Version  string `json:"version"`
+	Platform string `json:"platform"`
+	// Optional features and background jobs that are turned on
+	Features []string `json:"features"`
+	// Untrashed events, rounded down to a power of ten
+	Events string `json:"events"`
+}
+
+// TelemetryPreview answers /api/admin/telemetry.
+type TelemetryPreview struct {
+	// Whether Report is being sent, and where to
+	Enabled bool            `json:"enabled"`
+	URL     string          `json:"url,omitempty"`
+	Report  TelemetryReport `json:"report"`
 }
 
 type PagedResponse[T any] struct {
@@ -17,4 +231,556 @@ type PagedResponse[T any] struct {
 	Offset int `json:"offset"`
 	Count  int `json:"count"`
 	Total  int `json:"total"`
+	// Pass as ?cursor for the page after this one; empty on the last page
+	// and on listings that only page by offset
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+type ImportReport struct {
+	Imported int             `json:"imported"`
+	Events   []schemas.Event `json:"events"`
+	Skipped  []ImportSkip    `json:"skipped"`
+	// Set when nothing was stored; Events are what would have been
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// ImportSkip is an input record that was not imported. Ref identifies it in
+// the source: a VEVENT UID or a CSV row number.
+type ImportSkip struct {
+	Ref     string `json:"ref,omitempty"`
+	Summary string `json:"summary,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+type ImportPreview struct {
+	Source  string               `json:"source"`
+	Headers []string             `json:"headers"`
+	Fields  []string             `json:"fields"`
+	Mapping importers.Mapping    `json:"mapping"`
+	Events  []schemas.Event      `json:"events"`
+	Total   int                  `json:"total"`
+	Errors  []importers.RowError `json:"errors"`
+}
+
+type FeedRefreshReport struct {
+	Feed   schemas.Feed           `json:"feed"`
+	Result schemas.FeedSyncResult `json:"result"`
+}
+
+// EventOccurrence is one instance of an event within a listed range.
+type EventOccurrence struct {
+	Event schemas.Event `json:"event"`
+	// Original start of the instance, as used by exceptions
+	RecurrenceID time.Time  `json:"recurrenceId"`
+	StartTime    time.Time  `json:"startTime"`
+	EndTime      *time.Time `json:"endTime,omitempty"`
+	Moved        bool       `json:"moved,omitempty"`
+	// Set on occurrences of tasks that have been ticked off
+	Completed bool `json:"completed,omitempty"`
+	// The event's link, while the occurrence is joinable
+	JoinURL *string `json:"joinUrl,omitempty"`
+}
+
+// AgendaDay is one date of /api/agenda. Occurrences spanning several days
+// appear under each of them.
+type AgendaDay struct {
+	Date string `json:"date"` // 2006-01-02
+	// One of the household's weekend days
+	Weekend     bool              `json:"weekend"`
+	Occurrences []EventOccurrence `json:"occurrences"`
+}
+
+// AgendaSearch is /api/agenda with ?q: the days, as they would otherwise
+// be returned, and the next matches after them.
+type AgendaSearch struct {
+	Days any               `json:"days"`
+	Next []EventOccurrence `json:"next"`
+}
+
+// PersonColumn is one person's share of a grouped listing.
+type PersonColumn struct {
+	PersonID    string            `json:"personId"`
+	PersonName  string            `json:"personName"`
+	Color       *string           `json:"color,omitempty"`
+	Occurrences []EventOccurrence `json:"occurrences"`
+}
+
+// PersonDay is one date of a listing grouped by date and then person.
+type PersonDay struct {
+	Date    string         `json:"date"` // 2006-01-02
+	Weekend bool           `json:"weekend"`
+	People  []PersonColumn `json:"people"`
+}
+
+// FreeBusy lists the busy times within [From, To); everything else is free.
+type FreeBusy struct {
+	From time.Time      `json:"from"`
+	To   time.Time      `json:"to"`
+	Busy []BusyInterval `json:"busy"`
+}
+
+type BusyInterval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Joinable is an occurrence whose meeting link should be offered right now.
+type Joinable struct {
+	Event     schemas.Event `json:"event"`
+	JoinURL   string        `json:"joinUrl"`
+	StartTime time.Time     `json:"startTime"`
+	EndTime   *time.Time    `json:"endTime,omitempty"`
+}
+
+// EventWarning flags something the client may want to confirm with the user.
+type EventWarning struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	// The existing event and the occurrence of it that clashes, or for
+	// "dst", the event itself and the occurrence a DST change upsets
+	Event     schemas.Event `json:"event"`
+	StartTime time.Time     `json:"startTime"`
+	EndTime   *time.Time    `json:"endTime,omitempty"`
+}
+
+// CreatedEvent is the response to creating an event.
+type CreatedEvent struct {
+	schemas.Event
+	Warnings []EventWarning `json:"warnings"`
+}
+
+// AddedAttendee is the response to adding an attendee. Warnings lists the
+// focus time that made them decline.
+type AddedAttendee struct {
+	schemas.Attendee
+	// Where they can answer without an API token; shown only here
+	RSVPURL  string         `json:"rsvpUrl"`
+	Warnings []EventWarning `json:"warnings"`
+}
+
+// RSVPLink is a newly issued RSVP link for an attendee.
+type RSVPLink struct {
+	AttendeeID string `json:"attendeeId"`
+	URL        string `json:"url"`
+}
+
+// RSVPInvitation is what an RSVP link shows the person invited: enough of
+// the event to answer, and their answer so far.
+type RSVPInvitation struct {
+	Title       string             `json:"title"`
+	StartTime   time.Time          `json:"startTime"`
+	EndTime     *time.Time         `json:"endTime,omitempty"`
+	Timezone    string             `json:"timezone"`
+	AllDay      bool               `json:"allDay"`
+	Rrule       *string            `json:"rrule,omitempty"`
+	Location    *string            `json:"location,omitempty"`
+	Name        *string            `json:"name,omitempty"`
+	Status      schemas.RSVPStatus `json:"status"`
+	RespondedAt *time.Time         `json:"respondedAt,omitempty"`
+}
+
+// SavedPerson is the response to creating or updating a person. Warnings
+// lists the themes the colour is hard to read on.
+type SavedPerson struct {
+	schemas.Person
+	Warnings []ColorContrast `json:"warnings"`
+}
+
+// SavedCalendar is SavedPerson for calendars.
+type SavedCalendar struct {
+	schemas.Calendar
+	Warnings []ColorContrast `json:"warnings"`
+}
+
+// ColorContrast is how a colour reads on one display theme.
+type ColorContrast struct {
+	// "light" or "dark"
+	Theme      string  `json:"theme"`
+	Background string  `json:"background"`
+	Ratio      float64 `json:"ratio"`
+	Readable   bool    `json:"readable"`
+	// The nearest colour that is readable there; only when Readable is false
+	Suggestion *string `json:"suggestion,omitempty"`
+}
+
+// ColorReport answers /api/colors/contrast.
+type ColorReport struct {
+	Color  string          `json:"color"`
+	Themes []ColorContrast `json:"themes"`
+}
+
+// CreatedException is the response to adding a single-occurrence exception;
+// a move may carry the same warnings as a new event.
+type CreatedException struct {
+	schemas.Exception
+	Warnings []EventWarning `json:"warnings"`
+}
+
+// InvalidID is the 400 body when an ID in the path or query isn't a UUID.
+type InvalidID struct {
+	Error string `json:"error"`
+	Param string `json:"param"`
+	Value string `json:"value"`
+}
+
+// DuplicateConflict is the 409 body when a strict create or move finds
+// duplicates or conflicts.
+type DuplicateConflict struct {
+	Error    string         `json:"error"`
+	Warnings []EventWarning `json:"warnings"`
+}
+
+// IntegrationAccount is a sync_state row as shown to the UI.
+type IntegrationAccount struct {
+	schemas.SyncState
+	Connected bool `json:"connected"`
+}
+
+// IntegrationConnect tells the UI how to finish signing in: open AuthURL, or
+// show Device's code and verification URI.
+type IntegrationConnect struct {
+	Account IntegrationAccount       `json:"account"`
+	AuthURL string                   `json:"authUrl,omitempty"`
+	Device  *integrations.DeviceCode `json:"device,omitempty"`
+}
+
+type IntegrationSyncReport struct {
+	Account IntegrationAccount  `json:"account"`
+	Result  integrations.Result `json:"result"`
+}
+
+// BulkExceptionRequest selects the occurrences of one event starting in
+// [From, To). Dates without a time are read in the event's timezone, and a
+// date-only To includes that whole day.
+type BulkExceptionRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Move only: applied to each occurrence's wall-clock start
+	ShiftDays    int    `json:"shiftDays,omitempty"`
+	ShiftMinutes int    `json:"shiftMinutes,omitempty"`
+	Time         string `json:"time,omitempty"` // "15:04"; sets the start time of day before shifting
+}
+
+// PauseRequest pauses a series from From until Until, read as for
+// BulkExceptionRequest, so a date-only Until is the last day skipped.
+type PauseRequest struct {
+	From  string `json:"from"`
+	Until string `json:"until"`
+}
+
+// OpenRecurrence is a long-running series with no end date.
+type OpenRecurrence struct {
+	Event          schemas.Event `json:"event"`
+	RunningSince   time.Time     `json:"runningSince"`
+	NextOccurrence *time.Time    `json:"nextOccurrence,omitempty"`
+}
+
+// EndRecurrencesRequest ends each listed series on Until: a date (inclusive,
+// in each event's timezone) or an RFC 3339 time.
+type EndRecurrencesRequest struct {
+	EventIDs []string `json:"eventIds"`
+	Until    string   `json:"until"`
+}
+
+type RecurrenceSkip struct {
+	EventID string `json:"eventId"`
+	Reason  string `json:"reason"`
+}
+
+type EndRecurrencesReport struct {
+	Updated []schemas.Event  `json:"updated"`
+	Skipped []RecurrenceSkip `json:"skipped"`
+	DryRun  bool             `json:"dryRun,omitempty"`
+}
+
+// BatchDeleteRequest lists events to move to the trash; see deleteEvents.
+type BatchDeleteRequest struct {
+	EventIDs []string `json:"eventIds"`
+}
+
+type BatchDeleteReport struct {
+	Deleted int             `json:"deleted"`
+	Events  []schemas.Event `json:"events"`
+	DryRun  bool            `json:"dryRun,omitempty"`
+}
+
+// SplitRequest splits a series at RecurrenceID. Event, if given, is the
+// new definition of the series from that point; otherwise the original is
+// copied and its exceptions from that point move with it.
+type SplitRequest struct {
+	RecurrenceID time.Time      `json:"recurrenceId"`
+	Event        *schemas.Event `json:"event,omitempty"`
+}
+
+// RruleChange is a rule to preview for a series; empty ends the repeating.
+// From and To are read as for BulkExceptionRequest and default to the year
+// from now, or from the series' start if that is later.
+type RruleChange struct {
+	Rrule string `json:"rrule"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+// RruleChangePreview is what changing a series' rule to Rrule would do.
+// Kept, Removed and Created are occurrence starts in [From, To) that are
+// in both rules, only the current one and only the new one.
+type RruleChangePreview struct {
+	Event   schemas.Event `json:"event"`
+	Rrule   string        `json:"rrule"`
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Kept    []time.Time   `json:"kept"`
+	Removed []time.Time   `json:"removed"`
+	Created []time.Time   `json:"created"`
+	// Across the whole series: overrides whose occurrence the new rule
+	// still has, and those it would leave pointing at nothing
+	KeptOverrides     []OccurrenceOverride `json:"keptOverrides"`
+	OrphanedOverrides []OccurrenceOverride `json:"orphanedOverrides"`
+}
+
+// OccurrenceOverride is something stored against one occurrence of a series.
+type OccurrenceOverride struct {
+	// "cancel", "move" or "completion"
+	Kind         string     `json:"kind"`
+	RecurrenceID time.Time  `json:"recurrenceId"`
+	NewStart     *time.Time `json:"newStart,omitempty"`
+	NewEnd       *time.Time `json:"newEnd,omitempty"`
+}
+
+// StorageReport answers /api/admin/storage.
+type StorageReport struct {
+	DatabaseBytes int64        `json:"databaseBytes"`
+	Tables        []TableUsage `json:"tables"`
+}
+
+type TableUsage struct {
+	schemas.TableStorage
+	// Absent for tables that are never pruned
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+}
+
+// RetentionPolicy is how a table's history is pruned. Zero limits are off.
+type RetentionPolicy struct {
+	// Which rows are pruned; the others stay however old they are
+	Covers        string `json:"covers"`
+	MaxAgeSeconds int64  `json:"maxAgeSeconds,omitempty"`
+	MaxRows       int    `json:"maxRows,omitempty"`
+}
+
+type SplitReport struct {
+	Original schemas.Event `json:"original"`
+	Created  schemas.Event `json:"created"`
+}
+
+// FeedTokenIssued is returned once, when a token is created; Token is the
+// only copy of the secret.
+type FeedTokenIssued struct {
+	schemas.FeedToken
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// APITokenIssued is returned once, when a token is created; Token is the
+// only copy of the secret.
+type APITokenIssued struct {
+	schemas.APIToken
+	Token string `json:"token"`
+}
+
+// WebhookCreated is returned once, when a webhook is registered; Secret is
+// the key its payloads are signed with.
+type WebhookCreated struct {
+	schemas.Webhook
+	Secret string `json:"secret"`
+}
+
+// ChangeNotice tells webhooks and realtime clients about a change. It is the
+// body POSTed to webhooks.
+type ChangeNotice struct {
+	Type       string        `json:"type"`
+	OccurredAt time.Time     `json:"occurredAt"`
+	Event      schemas.Event `json:"event"`
+	// The exceptions an "exception.added" added
+	Exceptions []schemas.Exception `json:"exceptions,omitempty"`
+	// The delivery a "reminder.fired" or "reminder.dismissed" is about
+	Reminder *schemas.ReminderDelivery `json:"reminder,omitempty"`
+}
+
+// ActiveReminder is a fired reminder still waiting to be dismissed.
+type ActiveReminder struct {
+	schemas.ReminderDelivery
+	Event schemas.Event `json:"event"`
+}
+
+// ReminderDismissal is the body of POST /api/reminders/dismissals.
+type ReminderDismissal struct {
+	ReminderID   string    `json:"reminderId"`
+	RecurrenceID time.Time `json:"recurrenceId"`
+	// Optional; recorded as the delivery's dismissedBy
+	DismissedBy *string `json:"dismissedBy,omitempty"`
+}
+
+// DefaultRemindersRequest is the body of POST /api/admin/default-reminders:
+// the reminders every matching event should have. Only offsetMinutes,
+// channel and leaveBy are read.
+type DefaultRemindersRequest struct {
+	Reminders []schemas.Reminder `json:"reminders"`
+}
+
+type DefaultRemindersReport struct {
+	// Events the filters matched, whether or not they needed anything
+	Matched int                `json:"matched"`
+	Added   []schemas.Reminder `json:"added"`
+	DryRun  bool               `json:"dryRun,omitempty"`
+}
+
+// MergeRequest is the body of POST /api/admin/merge/people and
+// /api/admin/merge/calendars: From is folded into Into and deleted.
+type MergeRequest struct {
+	From string `json:"from"`
+	Into string `json:"into"`
+}
+
+type MergeReport struct {
+	// The events that moved, as they are after the merge
+	Events []schemas.Event `json:"events"`
+	// Other rows that moved, by table
+	Moved  map[string]int `json:"moved"`
+	DryRun bool           `json:"dryRun,omitempty"`
+}
+
+// DebugCaptureRequest turns on capturing at /api/admin/debug-capture.
+type DebugCaptureRequest struct {
+	// Path prefixes of requests to the server to capture, e.g.
+	// "/api/v1/integrations/"
+	Routes []string `json:"routes"`
+	// Also capture outgoing webhook deliveries
+	Webhooks bool `json:"webhooks"`
+	// How long to capture for, up to an hour
+	Minutes int `json:"minutes"`
+}
+
+type DebugCaptureStatus struct {
+	Active   bool       `json:"active"`
+	Routes   []string   `json:"routes"`
+	Webhooks bool       `json:"webhooks"`
+	Until    *time.Time `json:"until,omitempty"`
+	// Oldest first
+	Exchanges []CapturedExchange `json:"exchanges"`
+}
+
+// CapturedExchange is one request and its response, with credentials in
+// headers, the query and bodies replaced by "[redacted]".
+type CapturedExchange struct {
+	At time.Time `json:"at"`
+	// "in" for a request to the server, "out" for a webhook delivery
+	Direction       string      `json:"direction"`
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	Status          int         `json:"status,omitempty"`
+	DurationMillis  int64       `json:"durationMillis"`
+	RequestHeaders  http.Header `json:"requestHeaders"`
+	RequestBody     string      `json:"requestBody"`
+	ResponseHeaders http.Header `json:"responseHeaders,omitempty"`
+	ResponseBody    string      `json:"responseBody"`
+	// A body was longer than the capture keeps
+	Truncated bool `json:"truncated,omitempty"`
+	// Why a delivery got no response
+	Error string `json:"error,omitempty"`
+}
+
+type BackupRestoreReport struct {
+	// Which backup was restored
+	Migration  int       `json:"migration"`
+	ExportedAt time.Time `json:"exportedAt"`
+	// Rows restored, by table
+	Rows map[string]int `json:"rows"`
+}
+
+// RealtimeRequest is a message from a /api/realtime client: "subscribe",
+// with the filter to apply from then on, or "ping".
+type RealtimeRequest struct {
+	Type string `json:"type"`
+	schemas.ChangeFilter
+}
+
+// RealtimeReply answers a RealtimeRequest. Changes themselves arrive as
+// ChangeNotices.
+type RealtimeReply struct {
+	// "subscribed", "pong" or "error"
+	Type   string                `json:"type"`
+	Filter *schemas.ChangeFilter `json:"filter,omitempty"`
+	Error  string                `json:"error,omitempty"`
+}
+
+// ChangeSet is one page of /api/changes.
+type ChangeSet struct {
+	// The ?since for the next call
+	Token   string         `json:"token"`
+	Created []ChangedEvent `json:"created"`
+	Updated []ChangedEvent `json:"updated"`
+	// IDs of events that are gone
+	Deleted []string `json:"deleted"`
+	// More changes are waiting; call again with Token
+	More bool `json:"more"`
+}
+
+// ChangedEvent is an event as it is now, with all its exceptions.
+type ChangedEvent struct {
+	schemas.Event
+	Exceptions []schemas.Exception `json:"exceptions"`
+}
+
+type BulkExceptionReport struct {
+	Event      schemas.Event       `json:"event"`
+	Affected   int                 `json:"affected"`
+	Exceptions []schemas.Exception `json:"exceptions"`
+	DryRun     bool                `json:"dryRun,omitempty"`
+}
+
+// ReadyStatus answers /readyz. Database is left out when no breaker is
+// configured.
+type ReadyStatus struct {
+	Ready    bool                   `json:"ready"`
+	Error    string                 `json:"error,omitempty"`
+	Database *database.BreakerStats `json:"database,omitempty"`
+	// Only when scheduled backups are on; they don't affect Ready
+	Backup *BackupStatus `json:"backup,omitempty"`
+}
+
+type BackupStatus struct {
+	// BACKUP_TARGET's, left empty when there's none
+	BackupTargetStatus
+	// Each target added under /admin/backup-targets, by name
+	Targets map[string]BackupTargetStatus `json:"targets,omitempty"`
+	// The latest restore drill, once one has run
+	LastDrill *RestoreDrill `json:"lastDrill,omitempty"`
+}
+
+// RestoreDrill is how the newest backup fared being restored into a
+// scratch schema, which shows it could be restored for real.
+type RestoreDrill struct {
+	// The file restored, and the target it was read from: BACKUP_TARGET or
+	// an added target's name
+	File   string    `json:"file"`
+	Target string    `json:"target"`
+	At     time.Time `json:"at"`
+	schemas.BackupCheck
+}
+
+// BackupTargetStatus is how scheduled backups are going at one target.
+type BackupTargetStatus struct {
+	// The newest backup file, and when it was made
+	LastFile    string     `json:"lastFile,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	// Why the latest attempt failed, if it did
+	LastError   string     `json:"lastError,omitempty"`
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+}
+
+// BackupTargetInput is the body for adding or changing a backup target.
+// Secrets left out of a change stay as they were.
+type BackupTargetInput struct {
+	schemas.BackupTarget
+	schemas.BackupTargetSecrets
 }
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Version is stamped in at build time with
+// -ldflags "-X pical/server.Version=v1.2.3"; otherwise it stays "dev".
+var Version = "dev"
+
+// getVersion reports what this binary is: its version, the commit and build
+// tags it was built from, and which optional features were compiled in and
+// are turned on.
+func (s *Server) getVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	out := VersionInfo{Version: Version, GoVersion: runtime.Version(), Features: make([]FeatureInfo, 0, len(optionalFeatures))}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, st := range bi.Settings {
+			switch st.Key {
+			case "vcs.revision":
+				out.Revision = st.Value
+			case "-tags":
+				out.Tags = st.Value
+			}
+		}
+	}
+	for _, f := range optionalFeatures {
+		compiled := f.setup != nil
+		out.Features = append(out.Features, FeatureInfo{
+			Name:     f.name,
+			Compiled: compiled,
+			Enabled:  compiled && f.configured(s.Config),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"log"
+	"pical/database/schemas"
+	"slices"
+	"sync"
+	"time"
+)
+
+// How long startup spends warming up before /readyz gives in and reports
+// ready anyway
+const warmUpTimeout = 2 * time.Minute
+
+// How many unfiltered ranges warmCache holds: the agenda and month grid
+// warmUp builds, and the next day's or month's once they roll over
+const warmCacheSize = 4
+
+// warmUp does the work the first screens after a reboot would otherwise do
+// cold: it brings the occurrence cache up to date, then expands the next
+// week's agenda and this month's grid into s.warmed, where the displays'
+// first requests find them. Both are laid out in the Pi's own zone, since
+// that's where its displays are. /readyz reports not ready until it
+// finishes, or gives up after warmUpTimeout.
+func (s *Server) warmUp(ctx context.Context) {
+	defer s.warm.Store(true)
+
+	ctx, cancel := context.WithTimeout(ctx, warmUpTimeout)
+	defer cancel()
+	started := time.Now()
+
+	if s.Config.RecurrenceHorizonMonths > 0 {
+		from, through := s.occurrenceWindow(started)
+		if err := s.materializeOccurrences(ctx, from, through); err != nil {
+			log.Printf("warm up: materialize occurrences: %v", err)
+			return
+		}
+	}
+
+	if _, err := s.buildAgenda(ctx, schemas.EventFilter{}, time.Local, 7, false); err != nil {
+		log.Printf("warm up: agenda: %v", err)
+		return
+	}
+
+	settings, err := schemas.GetSettings(ctx, s.DB)
+	if err != nil {
+		log.Printf("warm up: settings: %v", err)
+		return
+	}
+	// Whole weeks around the month, as a month view lays them out
+	now := started.In(time.Local)
+	first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	gridFrom := settings.WeekStartOf(first)
+	gridTo := settings.WeekStartOf(first.AddDate(0, 1, -1)).AddDate(0, 0, 7)
+	if _, err := s.expandOccurrences(ctx, schemas.EventFilter{}, gridFrom, gridTo); err != nil {
+		log.Printf("warm up: month grid: %v", err)
+		return
+	}
+
+	log.Printf("warmed up in %s", time.Since(started).Round(time.Millisecond))
+}
+
+// warmCache holds the occurrences of a few unfiltered ranges, expanded as
+// of one seq of the event change log. Every event write logs a change, the
+// same writes that invalidate the occurrence cache, so a new seq empties it.
+type warmCache struct {
+	mu     sync.Mutex
+	seq    int64
+	ranges map[warmRange][]EventOccurrence
+	// Oldest first, to know which range to drop when full
+	order []warmRange
+}
+
+type warmRange struct {
+	from, to int64 // Unix nanoseconds
+}
+
+func (c *warmCache) get(seq int64, from, to time.Time) ([]EventOccurrence, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if seq != c.seq {
+		return nil, false
+	}
+	occs, ok := c.ranges[warmRange{from.UnixNano(), to.UnixNano()}]
+	return slices.Clone(occs), ok
+}
+
+func (c *warmCache) put(seq int64, from, to time.Time, occs []EventOccurrence) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if seq < c.seq {
+		// Expanded before a change another request has already seen
+		return
+	}
+	if seq != c.seq || c.ranges == nil {
+		c.seq, c.ranges, c.order = seq, make(map[warmRange][]EventOccurrence), nil
+	}
+
+	key := warmRange{from.UnixNano(), to.UnixNano()}
+	if _, ok := c.ranges[key]; !ok {
+		if len(c.order) == warmCacheSize {
+			delete(c.ranges, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.order = append(c.order, key)
+	}
+	c.ranges[key] = slices.Clone(occs)
+}
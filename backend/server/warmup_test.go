@@ -0,0 +1,53 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWarmCache(t *testing.T) {
+	var c warmCache
+	day := time.Date(2030, 5, 1, 0, 0, 0, 0, time.UTC)
+	occs := []EventOccurrence{{StartTime: day.Add(9 * time.Hour)}}
+
+	if _, ok := c.get(0, day, day.AddDate(0, 0, 7)); ok {
+		t.Fatal("hit before anything was put")
+	}
+	c.put(3, day, day.AddDate(0, 0, 7), occs)
+
+	// The same instants in another zone are the same range
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := c.get(3, day.In(london), day.AddDate(0, 0, 7).In(london))
+	if !ok || len(got) != 1 || !got[0].StartTime.Equal(occs[0].StartTime) {
+		t.Fatalf("got %v %v, want the week put", got, ok)
+	}
+	got[0].Completed = true
+	if again, _ := c.get(3, day, day.AddDate(0, 0, 7)); again[0].Completed {
+		t.Error("marking what get returned changed the cache")
+	}
+
+	if _, ok := c.get(3, day, day.AddDate(0, 0, 6)); ok {
+		t.Error("hit for a range never put")
+	}
+	if _, ok := c.get(4, day, day.AddDate(0, 0, 7)); ok {
+		t.Error("hit after an event changed")
+	}
+	c.put(2, day, day.AddDate(0, 0, 7), occs)
+	if _, ok := c.get(2, day, day.AddDate(0, 0, 7)); ok {
+		t.Error("an expansion older than the cache replaced it")
+	}
+
+	// Full: the oldest range goes first
+	for i := 0; i <= warmCacheSize; i++ {
+		c.put(5, day.AddDate(0, 0, i), day.AddDate(0, 0, i+7), occs)
+	}
+	if _, ok := c.get(5, day, day.AddDate(0, 0, 7)); ok {
+		t.Error("oldest range kept past warmCacheSize")
+	}
+	if _, ok := c.get(5, day.AddDate(0, 0, warmCacheSize), day.AddDate(0, 0, warmCacheSize+7)); !ok {
+		t.Error("newest range dropped")
+	}
+}
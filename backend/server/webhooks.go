@@ -0,0 +1,302 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"pical/database/schemas"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// How often the dispatcher looks for due deliveries when nothing wakes it
+	webhookPoll = 15 * time.Second
+	// A failed delivery is retried after 30s, then 1m, 2m and so on, giving
+	// up after webhookMaxAttempts, about an hour in
+	webhookFirstRetry  = 30 * time.Second
+	webhookMaxAttempts = 8
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *Server) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := schemas.ListWebhooks(r.Context(), s.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, hooks)
+}
+
+// createWebhook registers a webhook. The signing secret is in the response
+// only.
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in schemas.Webhook
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	created, err := schemas.CreateWebhook(r.Context(), s.DB, in, secret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, WebhookCreated{Webhook: created, Secret: secret})
+}
+
+func (s *Server) getWebhook(w http.ResponseWriter, r *http.Request, id string) {
+	h, err := schemas.GetWebhook(r.Context(), s.DB, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h)
+}
+
+func (s *Server) updateWebhook(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var in schemas.Webhook
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := schemas.UpdateWebhook(r.Context(), s.DB, id, in)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request, id string) {
+	if err := schemas.DeleteWebhook(r.Context(), s.DB, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getWebhookDeliveries is the webhook's delivery log, newest first.
+func (s *Server) getWebhookDeliveries(w http.ResponseWriter, r *http.Request, id string) {
+	if _, err := schemas.GetWebhook(r.Context(), s.DB, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	limit, offset := s.parsePage(r)
+	deliveries, total, err := schemas.ListWebhookDeliveries(r.Context(), s.DB, id, limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, PagedResponse[schemas.WebhookDelivery]{
+		Items:  deliveries,
+		Limit:  limit,
+		Offset: offset,
+		Count:  len(deliveries),
+		Total:  total,
+	})
+}
+
+// queueWebhooks queues payload, a change to e, for every webhook whose
+// filters match, and wakes the dispatcher. Failures are only logged: the
+// change itself has already been made.
+func (s *Server) queueWebhooks(ctx context.Context, typ string, e schemas.Event, payload []byte) {
+	hooks, err := schemas.ListWebhooks(ctx, s.DB)
+	if err != nil {
+		log.Printf("webhooks: %v", err)
+		return
+	}
+
+	queued := false
+	for _, h := range hooks {
+		if !h.Matches(typ, e) {
+			continue
+		}
+		if err := schemas.QueueWebhookDelivery(ctx, s.DB, h.WebhookID, typ, string(payload)); err != nil {
+			log.Printf("webhooks: %v", err)
+			continue
+		}
+		queued = true
+	}
+
+	if queued {
+		select {
+		case s.webhookWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// runWebhookDispatcher sends queued deliveries until ctx is canceled.
+func (s *Server) runWebhookDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(webhookPoll)
+	defer ticker.Stop()
+
+	var lastPrune time.Time
+	for {
+		dispatchCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		if err := s.dispatchWebhooks(dispatchCtx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("webhooks: %v", err)
+		}
+		if time.Since(lastPrune) > time.Hour {
+			var cutoff time.Time
+			if s.Config.WebhookLogRetention > 0 {
+				cutoff = time.Now().Add(-s.Config.WebhookLogRetention)
+			}
+			if err := schemas.PruneWebhookDeliveries(dispatchCtx, s.DB, cutoff, s.Config.WebhookLogMaxRows); err != nil && ctx.Err() == nil {
+				log.Printf("webhooks: %v", err)
+			}
+			lastPrune = time.Now()
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.webhookWake:
+		}
+	}
+}
+
+func (s *Server) dispatchWebhooks(ctx context.Context, now time.Time) error {
+	due, err := schemas.ListDueWebhookDeliveries(ctx, s.DB, now, 50)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		status, sendErr := s.sendWebhook(ctx, d, now)
+		var next *time.Time
+		var lastError *string
+		if sendErr != nil {
+			msg := sendErr.Error()
+			lastError = &msg
+			next = webhookRetryAt(now, d.Attempts)
+			if next == nil {
+				log.Printf("webhook delivery %s to %s failed for good: %s", d.DeliveryID, d.URL, msg)
+			}
+		}
+		if err := schemas.RecordWebhookAttempt(ctx, s.DB, d.DeliveryID, sendErr == nil, status, lastError, next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookRetryAt is when to try again after a delivery fails, having been
+// tried attempts times before, or nil when it has had all its attempts.
+func webhookRetryAt(now time.Time, attempts int) *time.Time {
+	if attempts+1 >= webhookMaxAttempts {
+		return nil
+	}
+	retry := now.Add(webhookFirstRetry << attempts)
+	return &retry
+}
+
+// sendWebhook POSTs one delivery. The X-PiCal-Signature header is
+// "sha256=" and the hex HMAC-SHA256, keyed with the webhook's secret, of the
+// X-PiCal-Timestamp value, a ".", and the body; receivers should reject old
+// timestamps to stop replays.
+func (s *Server) sendWebhook(ctx context.Context, d schemas.DueWebhookDelivery, now time.Time) (*int, error) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(d.Secret))
+	mac.Write([]byte(ts + "." + d.Payload))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, strings.NewReader(d.Payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PiCal-Webhook")
+	req.Header.Set("X-PiCal-Event", d.Type)
+	req.Header.Set("X-PiCal-Delivery", d.DeliveryID)
+	req.Header.Set("X-PiCal-Timestamp", ts)
+	req.Header.Set("X-PiCal-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	var ex *CapturedExchange
+	if s.capture.capturingWebhooks() {
+		reqBody := &limitedBuffer{}
+		reqBody.Write([]byte(d.Payload))
+		ex = &CapturedExchange{At: time.Now().UTC(), Direction: "out", Method: req.Method, URL: redactURL(req.URL),
+			RequestHeaders: redactHeaders(req.Header), RequestBody: reqBody.String(), Truncated: reqBody.truncated}
+		defer func() {
+			ex.DurationMillis = time.Since(ex.At).Milliseconds()
+			s.capture.record(*ex)
+		}()
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		if ex != nil {
+			ex.Error = err.Error()
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody := &limitedBuffer{}
+	_, _ = io.Copy(respBody, io.LimitReader(resp.Body, 64<<10))
+
+	status := resp.StatusCode
+	if ex != nil {
+		ex.Status, ex.ResponseHeaders, ex.ResponseBody = status, redactHeaders(resp.Header), respBody.String()
+		ex.Truncated = ex.Truncated || respBody.truncated
+	}
+	if status < 200 || status >= 300 {
+		return &status, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return &status, nil
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"pical/database/schemas"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSendWebhookSignature(t *testing.T) {
+	const secret = "s3cret"
+	const payload = `{"type":"event.created","event":{"eventId":"e1"}}`
+	now := time.Unix(1767225600, 0)
+
+	var got *http.Request
+	var body string
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got, body = r, string(b)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	s := &Server{}
+	d := schemas.DueWebhookDelivery{
+		WebhookDelivery: schemas.WebhookDelivery{DeliveryID: "d1", Type: schemas.WebhookEventCreated, Payload: payload},
+		URL:             srv.URL,
+		Secret:          secret,
+	}
+	code, err := s.sendWebhook(context.Background(), d, now)
+	if err != nil || code == nil || *code != http.StatusNoContent {
+		t.Fatalf("sendWebhook = %v, %v", code, err)
+	}
+
+	if body != payload {
+		t.Errorf("body = %q, want %q", body, payload)
+	}
+	ts := got.Header.Get("X-PiCal-Timestamp")
+	if ts != strconv.FormatInt(now.Unix(), 10) {
+		t.Errorf("X-PiCal-Timestamp = %q, want %d", ts, now.Unix())
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + body))
+	if sig, want := got.Header.Get("X-PiCal-Signature"), "sha256="+hex.EncodeToString(mac.Sum(nil)); sig != want {
+		t.Errorf("X-PiCal-Signature = %q, want %q", sig, want)
+	}
+	for header, want := range map[string]string{
+		"Content-Type":     "application/json",
+		"X-PiCal-Event":    schemas.WebhookEventCreated,
+		"X-PiCal-Delivery": "d1",
+	} {
+		if v := got.Header.Get(header); v != want {
+			t.Errorf("%s = %q, want %q", header, v, want)
+		}
+	}
+
+	// Signed with another secret, the receiver's check fails
+	other := hmac.New(sha256.New, []byte("other"))
+	other.Write([]byte(ts + "." + body))
+	if got.Header.Get("X-PiCal-Signature") == "sha256="+hex.EncodeToString(other.Sum(nil)) {
+		t.Error("signature doesn't depend on the secret")
+	}
+
+	status = http.StatusServiceUnavailable
+	code, err = s.sendWebhook(context.Background(), d, now)
+	if err == nil || code == nil || *code != http.StatusServiceUnavailable {
+		t.Errorf("sendWebhook to a failing receiver = %v, %v; want its status and an error", code, err)
+	}
+
+	srv.Close()
+	if code, err := s.sendWebhook(context.Background(), d, now); err == nil || code != nil {
+		t.Errorf("sendWebhook to a closed receiver = %v, %v; want no status and an error", code, err)
+	}
+}
+
+func TestWebhookRetryAt(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	want := []time.Duration{
+		30 * time.Second,
+		time.Minute,
+		2 * time.Minute,
+		4 * time.Minute,
+		8 * time.Minute,
+		16 * time.Minute,
+		32 * time.Minute,
+	}
+
+	var total time.Duration
+	for attempts, wait := range want {
+		next := webhookRetryAt(now, attempts)
+		if next == nil {
+			t.Fatalf("no retry after %d attempts, want one in %v", attempts+1, wait)
+		}
+		if got := next.Sub(now); got != wait {
+			t.Errorf("after %d attempts: retry in %v, want %v", attempts+1, got, wait)
+		}
+		total += wait
+	}
+	if next := webhookRetryAt(now, webhookMaxAttempts-1); next != nil {
+		t.Errorf("retry at %v after the last attempt, want none", next)
+	}
+	if attempts := len(want) + 1; attempts != webhookMaxAttempts {
+		t.Errorf("%d attempts in all, want %d", attempts, webhookMaxAttempts)
+	}
+	if total > 70*time.Minute {
+		t.Errorf("last attempt %v after the first, want about an hour", total)
+	}
+}
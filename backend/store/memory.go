@@ -0,0 +1,416 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"pical/database/schemas"
+)
+
+// Memory is an EventStore that keeps everything in a map, for exercising
+// handlers without a database. It validates and versions events the way
+// Postgres does, but there are no tags or calendars to check names and IDs
+// against, no attachments, and display profile filters aren't supported.
+type Memory struct {
+	mu       sync.Mutex
+	events   map[string]schemas.Event
+	people   map[string]string // ID to display name
+	external map[string]string // source and uid to event ID
+	// Clock for createdAt, updatedAt and deletedAt; time.Now when nil
+	Now func() time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		events:   make(map[string]schemas.Event),
+		people:   make(map[string]string),
+		external: make(map[string]string),
+	}
+}
+
+// AddPerson registers someone events can be created for, returning their ID.
+func (m *Memory) AddPerson(name string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := newID()
+	m.people[id] = name
+	return id
+}
+
+func (m *Memory) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+	return time.Now()
+}
+
+func (m *Memory) ListEvents(ctx context.Context, filter schemas.EventFilter, sort schemas.EventSort, limit, offset int) ([]schemas.Event, int, error) {
+	matched, err := m.sorted(filter, sort)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := len(matched)
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if limit >= 0 && offset+limit < total {
+		end = offset + limit
+	}
+	return matched[offset:end], total, nil
+}
+
+func (m *Memory) ListEventsAfter(ctx context.Context, filter schemas.EventFilter, sort schemas.EventSort, after *schemas.EventCursor, limit int) ([]schemas.Event, int, error) {
+	matched, err := m.sorted(filter, sort)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	page := matched
+	if after != nil {
+		// The cursor as an event, to compare the listing against
+		mark := schemas.Event{EventID: after.EventID, PersonName: after.PersonName, Title: after.Title}
+		if after.At != nil {
+			mark.CreatedAt, mark.UpdatedAt, mark.StartTime = *after.At, *after.At, *after.At
+		}
+		cmp := eventCompare(sort)
+		i, _ := slices.BinarySearchFunc(matched, mark, cmp)
+		if i < len(matched) && matched[i].EventID == after.EventID {
+			i++
+		}
+		page = matched[i:]
+	}
+	return page[:min(limit, len(page))], len(matched), nil
+}
+
+// ListEventsInRange keeps the one-off events overlapping [from, to), with
+// those without an end as instants, and the recurring ones starting before
+// to, as schemas.ListEventsInRange does.
+func (m *Memory) ListEventsInRange(ctx context.Context, filter schemas.EventFilter, from, to time.Time) ([]schemas.Event, error) {
+	matched, err := m.sorted(filter, schemas.EventSort("startTime"))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]schemas.Event, 0, len(matched))
+	for _, e := range matched {
+		end := e.StartTime
+		if e.EndTime != nil {
+			end = *e.EndTime
+		}
+		if e.StartTime.Before(to) && (e.Rrule != nil || !end.Before(from)) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// sorted returns the untrashed events that match filter, in sort's order.
+func (m *Memory) sorted(filter schemas.EventFilter, sort schemas.EventSort) ([]schemas.Event, error) {
+	if filter.DisplayProfileID != "" {
+		return nil, fmt.Errorf("display profile filters need the database")
+	}
+	if err := sort.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matched := make([]schemas.Event, 0)
+	for _, e := range m.events {
+		if e.DeletedAt == nil && matches(filter, e) {
+			matched = append(matched, e)
+		}
+	}
+	slices.SortFunc(matched, eventCompare(sort))
+	return matched, nil
+}
+
+// matches is EventFilter.where for one event.
+func matches(f schemas.EventFilter, e schemas.Event) bool {
+	switch {
+	case f.CalendarID != "" && (e.CalendarID == nil || *e.CalendarID != f.CalendarID),
+		f.PersonID != "" && e.PersonID != f.PersonID,
+		f.AllDay != nil && e.AllDay != *f.AllDay,
+		f.Recurring != nil && (e.Rrule != nil) != *f.Recurring,
+		f.Focus != nil && e.Focus != *f.Focus,
+		f.CreatedAfter != nil && !e.CreatedAt.After(*f.CreatedAfter),
+		f.CreatedBefore != nil && !e.CreatedAt.Before(*f.CreatedBefore),
+		f.UpdatedAfter != nil && !e.UpdatedAt.After(*f.UpdatedAfter),
+		f.UpdatedBefore != nil && !e.UpdatedAt.Before(*f.UpdatedBefore):
+		return false
+	}
+	if f.Search != "" {
+		q := strings.ToLower(f.Search)
+		if !strings.Contains(strings.ToLower(e.Title), q) && (e.Notes == nil || !strings.Contains(strings.ToLower(*e.Notes), q)) {
+			return false
+		}
+	}
+	if f.Tag != "" && !slices.ContainsFunc(e.Tags, func(t string) bool { return strings.EqualFold(t, f.Tag) }) {
+		return false
+	}
+	return true
+}
+
+// eventCompare is EventSort.orderBy for sorting in memory.
+func eventCompare(s schemas.EventSort) func(a, b schemas.Event) int {
+	col, desc := strings.CutPrefix(string(s), "-")
+	return func(a, b schemas.Event) int {
+		var c int
+		switch col {
+		case "createdAt":
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		case "updatedAt":
+			c = a.UpdatedAt.Compare(b.UpdatedAt)
+		case "startTime":
+			c = a.StartTime.Compare(b.StartTime)
+		default:
+			return cmp.Or(cmp.Compare(a.PersonName, b.PersonName), cmp.Compare(a.Title, b.Title), cmp.Compare(a.EventID, b.EventID))
+		}
+		if desc {
+			c = -c
+		}
+		return cmp.Or(c, cmp.Compare(a.EventID, b.EventID))
+	}
+}
+
+func (m *Memory) GetEvent(ctx context.Context, id string) (*schemas.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.events[id]
+	if !ok || e.DeletedAt != nil {
+		return nil, sql.ErrNoRows
+	}
+	return &e, nil
+}
+
+func (m *Memory) GetEvents(ctx context.Context, ids []string) ([]schemas.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]schemas.Event, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if e, ok := m.events[id]; ok && e.DeletedAt == nil && !seen[id] {
+			seen[id] = true
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (m *Memory) GetTrashedEvent(ctx context.Context, id string) (*schemas.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.events[id]
+	if !ok || e.DeletedAt == nil {
+		return nil, sql.ErrNoRows
+	}
+	return &e, nil
+}
+
+func (m *Memory) ResolvePersonID(ctx context.Context, id, name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.resolvePerson(id, name)
+}
+
+func (m *Memory) resolvePerson(id, name string) (string, error) {
+	if id != "" {
+		return id, nil
+	}
+	for pid, n := range m.people {
+		if strings.EqualFold(n, strings.TrimSpace(name)) {
+			return pid, nil
+		}
+	}
+	return "", fmt.Errorf("unknown person %q", name)
+}
+
+func (m *Memory) CreateEvent(ctx context.Context, in schemas.Event) (schemas.Event, error) {
+	if err := schemas.ValidateEvent(in); err != nil {
+		return schemas.Event{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	in.FeedID, in.FeedUID = nil, nil
+	return m.insert(in)
+}
+
+func (m *Memory) insert(in schemas.Event) (schemas.Event, error) {
+	personID, err := m.resolvePerson(in.PersonID, in.PersonName)
+	if err != nil {
+		return schemas.Event{}, err
+	}
+	now := m.now()
+	in.EventID = newID()
+	in.PersonID, in.PersonName = personID, m.people[personID]
+	in.Latitude, in.Longitude = nil, nil
+	in.AttendeeSummary = schemas.AttendeeSummary{}
+	in.Pauses = schemas.Pauses{}
+	in.CreatedAt, in.UpdatedAt, in.DeletedAt = now, now, nil
+	in.Version = 1
+	if in.Tags == nil {
+		in.Tags = []string{}
+	}
+	m.events[in.EventID] = in
+	return in, nil
+}
+
+func (m *Memory) UpsertExternalEvent(ctx context.Context, source, uid string, in schemas.Event) (schemas.Event, bool, error) {
+	if source == "" || uid == "" {
+		return schemas.Event{}, false, fmt.Errorf("source and uid are required")
+	}
+	if err := schemas.ValidateEvent(in); err != nil {
+		return schemas.Event{}, false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := source + "/" + uid
+	id, ok := m.external[key]
+	if !ok {
+		if in.Version != 0 {
+			return schemas.Event{}, false, schemas.ErrVersionMismatch
+		}
+		in.FeedID, in.FeedUID = nil, nil
+		out, err := m.insert(in)
+		if err != nil {
+			return schemas.Event{}, false, err
+		}
+		m.external[key] = out.EventID
+		return out, true, nil
+	}
+
+	old := m.events[id]
+	if in.Version != 0 && in.Version != old.Version {
+		return schemas.Event{}, false, schemas.ErrVersionMismatch
+	}
+	personID, err := m.resolvePerson(in.PersonID, in.PersonName)
+	if err != nil {
+		return schemas.Event{}, false, err
+	}
+	out := in
+	out.EventID, out.FeedID, out.FeedUID = id, old.FeedID, old.FeedUID
+	out.PersonID, out.PersonName = personID, m.people[personID]
+	out.Latitude, out.Longitude = nil, nil
+	// Coordinates only stay while the location they were found for does
+	if ptrEqual(in.Location, old.Location) {
+		out.Latitude, out.Longitude = old.Latitude, old.Longitude
+	}
+	out.AttendeeSummary, out.Pauses = old.AttendeeSummary, old.Pauses
+	out.CreatedAt, out.UpdatedAt, out.DeletedAt = old.CreatedAt, m.now(), nil
+	out.Version = old.Version + 1
+	if out.Tags == nil {
+		out.Tags = []string{}
+	}
+	m.events[id] = out
+	return out, false, nil
+}
+
+func (m *Memory) DeleteEvent(ctx context.Context, id string, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.events[id]
+	if !ok || e.DeletedAt != nil {
+		return sql.ErrNoRows
+	}
+	if version != 0 && version != e.Version {
+		return schemas.ErrVersionMismatch
+	}
+	now := m.now()
+	e.DeletedAt = &now
+	e.Version++
+	m.events[id] = e
+	return nil
+}
+
+func (m *Memory) PurgeEvent(ctx context.Context, id string, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.events[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if version != 0 && version != e.Version {
+		return schemas.ErrVersionMismatch
+	}
+	delete(m.events, id)
+	for key, eventID := range m.external {
+		if eventID == id {
+			delete(m.external, key)
+		}
+	}
+	return nil
+}
+
+// ListEventAttachments finds none, as there is nowhere to upload them to.
+func (m *Memory) ListEventAttachments(ctx context.Context, eventID string) ([]schemas.Attachment, error) {
+	return []schemas.Attachment{}, nil
+}
+
+// TrashEvents leaves feed events alone and decides all or nothing, as
+// schemas.TrashEvents does.
+func (m *Memory) TrashEvents(ctx context.Context, filter schemas.EventFilter, ids []string, limit int, dryRun bool) ([]schemas.Event, error) {
+	if filter.DisplayProfileID != "" {
+		return nil, fmt.Errorf("display profile filters need the database")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]schemas.Event, 0)
+	for _, e := range m.events {
+		if e.DeletedAt != nil || e.FeedID != nil || !matches(filter, e) {
+			continue
+		}
+		if len(ids) > 0 && !slices.Contains(ids, e.EventID) {
+			continue
+		}
+		out = append(out, e)
+	}
+	if len(out) > limit {
+		return nil, schemas.ErrTooManyEvents
+	}
+	slices.SortFunc(out, func(a, b schemas.Event) int { return cmp.Compare(a.EventID, b.EventID) })
+	if dryRun {
+		return out, nil
+	}
+
+	now := m.now()
+	for _, e := range out {
+		e.DeletedAt = &now
+		e.Version++
+		m.events[e.EventID] = e
+	}
+	return out, nil
+}
+
+func ptrEqual(a, b *string) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+// newID makes a random (version 4) UUID, as gen_random_uuid does.
+func newID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"pical/database/schemas"
+)
+
+// Postgres is the EventStore the server runs with, the schemas functions
+// over a connection pool.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) ListEvents(ctx context.Context, filter schemas.EventFilter, sort schemas.EventSort, limit, offset int) ([]schemas.Event, int, error) {
+	return schemas.ListEvents(ctx, p.db, filter, sort, limit, offset)
+}
+
+func (p *Postgres) ListEventsAfter(ctx context.Context, filter schemas.EventFilter, sort schemas.EventSort, after *schemas.EventCursor, limit int) ([]schemas.Event, int, error) {
+	return schemas.ListEventsAfter(ctx, p.db, filter, sort, after, limit)
+}
+
+func (p *Postgres) ListEventsInRange(ctx context.Context, filter schemas.EventFilter, from, to time.Time) ([]schemas.Event, error) {
+	return schemas.ListEventsInRange(ctx, p.db, filter, from, to)
+}
+
+func (p *Postgres) GetEvent(ctx context.Context, id string) (*schemas.Event, error) {
+	return schemas.GetEvent(ctx, p.db, id)
+}
+
+func (p *Postgres) GetEvents(ctx context.Context, ids []string) ([]schemas.Event, error) {
+	return schemas.GetEvents(ctx, p.db, ids)
+}
+
+func (p *Postgres) GetTrashedEvent(ctx context.Context, id string) (*schemas.Event, error) {
+	return schemas.GetTrashedEvent(ctx, p.db, id)
+}
+
+func (p *Postgres) ResolvePersonID(ctx context.Context, id, name string) (string, error) {
+	return schemas.ResolvePersonID(ctx, p.db, id, name)
+}
+
+func (p *Postgres) CreateEvent(ctx context.Context, in schemas.Event) (schemas.Event, error) {
+	return schemas.CreateEvent(ctx, p.db, in)
+}
+
+func (p *Postgres) UpsertExternalEvent(ctx context.Context, source, uid string, in schemas.Event) (schemas.Event, bool, error) {
+	return schemas.UpsertExternalEvent(ctx, p.db, source, uid, in)
+}
+
+func (p *Postgres) DeleteEvent(ctx context.Context, id string, version int) error {
+	return schemas.DeleteEvent(ctx, p.db, id, version)
+}
+
+func (p *Postgres) PurgeEvent(ctx context.Context, id string, version int) error {
+	return schemas.PurgeEvent(ctx, p.db, id, version)
+}
+
+func (p *Postgres) ListEventAttachments(ctx context.Context, eventID string) ([]schemas.Attachment, error) {
+	return schemas.ListEventAttachments(ctx, p.db, eventID)
+}
+
+func (p *Postgres) TrashEvents(ctx context.Context, filter schemas.EventFilter, ids []string, limit int, dryRun bool) ([]schemas.Event, error) {
+	return schemas.TrashEvents(ctx, p.db, filter, ids, limit, dryRun)
+}
@@ -0,0 +1,43 @@
+// Package store is the event storage the HTTP handlers go through, so they
+// can be run against something other than Postgres.
+package store
+
+import (
+	"context"
+	"time"
+
+	"pical/database/schemas"
+)
+
+// EventStore reads and writes events. Implementations report errors the
+// way the schemas functions do: sql.ErrNoRows for an event that isn't there
+// and schemas.ErrVersionMismatch for a write against a stale version.
+type EventStore interface {
+	ListEvents(ctx context.Context, filter schemas.EventFilter, sort schemas.EventSort, limit, offset int) ([]schemas.Event, int, error)
+	// ListEventsAfter is ListEvents paged by keyset: the page starts just
+	// after the event at after, or at the beginning when after is nil
+	ListEventsAfter(ctx context.Context, filter schemas.EventFilter, sort schemas.EventSort, after *schemas.EventCursor, limit int) ([]schemas.Event, int, error)
+	// ListEventsInRange returns the events matching filter that may have an
+	// occurrence in [from, to), recurring ones unexpanded
+	ListEventsInRange(ctx context.Context, filter schemas.EventFilter, from, to time.Time) ([]schemas.Event, error)
+	GetEvent(ctx context.Context, id string) (*schemas.Event, error)
+	// GetEvents returns the events with the given IDs in the order asked for,
+	// leaving out those that match nothing
+	GetEvents(ctx context.Context, ids []string) ([]schemas.Event, error)
+	GetTrashedEvent(ctx context.Context, id string) (*schemas.Event, error)
+	// ResolvePersonID returns id, or the ID of the person called name when id is empty
+	ResolvePersonID(ctx context.Context, id, name string) (string, error)
+	CreateEvent(ctx context.Context, in schemas.Event) (schemas.Event, error)
+	UpsertExternalEvent(ctx context.Context, source, uid string, in schemas.Event) (out schemas.Event, created bool, err error)
+	// DeleteEvent moves an event to the trash
+	DeleteEvent(ctx context.Context, id string, version int) error
+	// PurgeEvent deletes an event for good
+	PurgeEvent(ctx context.Context, id string, version int) error
+	// ListEventAttachments returns the event's attachments, whose files
+	// have to be removed by hand when it is purged
+	ListEventAttachments(ctx context.Context, eventID string) ([]schemas.Attachment, error)
+	// TrashEvents moves the events matching filter, only those of ids when
+	// it isn't empty, to the trash all at once, returning them as they were;
+	// schemas.ErrTooManyEvents when more than limit match
+	TrashEvents(ctx context.Context, filter schemas.EventFilter, ids []string, limit int, dryRun bool) ([]schemas.Event, error)
+}
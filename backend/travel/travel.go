@@ -0,0 +1,200 @@
+// Package travel estimates how long it takes to get from one place to
+// another, for reminders that say when to leave.
+package travel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoRoute means the provider answered but couldn't find a way there.
+var ErrNoRoute = errors.New("no route found")
+
+// Point is a place by its coordinates.
+type Point struct {
+	Lat float64
+	Lng float64
+}
+
+func (p Point) String() string {
+	return strconv.FormatFloat(p.Lat, 'f', -1, 64) + "," + strconv.FormatFloat(p.Lng, 'f', -1, 64)
+}
+
+// ParsePoint reads "lat,lng", as in "51.5072,-0.1276".
+func ParsePoint(s string) (Point, error) {
+	latStr, lngStr, ok := strings.Cut(s, ",")
+	lat, latErr := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	lng, lngErr := strconv.ParseFloat(strings.TrimSpace(lngStr), 64)
+	if !ok || latErr != nil || lngErr != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return Point{}, fmt.Errorf("%q isn't a latitude and longitude, as in 51.5072,-0.1276", s)
+	}
+	return Point{Lat: lat, Lng: lng}, nil
+}
+
+// Router estimates how long the trip from one point to another takes when
+// leaving at depart.
+type Router interface {
+	TravelTime(ctx context.Context, from, to Point, depart time.Time) (time.Duration, error)
+}
+
+// Config picks a provider and how to reach it.
+type Config struct {
+	// "osrm" or "google"
+	Provider string
+	// The OSRM server, or another address for Google's API; empty for a
+	// provider's public one
+	URL    string
+	APIKey string
+	// How the trip is made, in the provider's words: "driving", or for
+	// example "walking" or "cycling" on OSRM and "transit" on Google
+	Mode string
+}
+
+// New returns the router cfg asks for.
+func New(cfg Config) (Router, error) {
+	if cfg.Mode == "" {
+		cfg.Mode = "driving"
+	}
+	switch cfg.Provider {
+	case "osrm":
+		if cfg.URL == "" {
+			cfg.URL = "https://router.project-osrm.org"
+		}
+		return &OSRM{baseURL: strings.TrimRight(cfg.URL, "/"), profile: cfg.Mode}, nil
+	case "google":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("the google travel provider needs an API key")
+		}
+		if cfg.URL == "" {
+			cfg.URL = "https://maps.googleapis.com"
+		}
+		return &Google{baseURL: strings.TrimRight(cfg.URL, "/"), apiKey: cfg.APIKey, mode: cfg.Mode}, nil
+	}
+	return nil, fmt.Errorf("unknown travel provider %q; use osrm or google", cfg.Provider)
+}
+
+var client = &http.Client{Timeout: 15 * time.Second}
+
+// getJSON fetches u and decodes the JSON answer into out.
+func getJSON(ctx context.Context, name, u string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "PiCal (self-hosted family calendar)")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	// OSRM answers a route it can't find with a 400 and a code saying so
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: unexpected status %s: %s", name, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s: decode: %w", name, err)
+	}
+	return nil
+}
+
+// OSRM asks an OSRM server, the public demo one or a self-hosted instance.
+// It knows nothing of traffic, so the time doesn't depend on when you leave.
+type OSRM struct {
+	baseURL string
+	profile string
+}
+
+func (o *OSRM) TravelTime(ctx context.Context, from, to Point, depart time.Time) (time.Duration, error) {
+	coords := fmt.Sprintf("%s,%s;%s,%s",
+		strconv.FormatFloat(from.Lng, 'f', -1, 64), strconv.FormatFloat(from.Lat, 'f', -1, 64),
+		strconv.FormatFloat(to.Lng, 'f', -1, 64), strconv.FormatFloat(to.Lat, 'f', -1, 64))
+	u := o.baseURL + "/route/v1/" + url.PathEscape(o.profile) + "/" + coords + "?overview=false"
+
+	var out struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Routes  []struct {
+			// Seconds
+			Duration float64 `json:"duration"`
+		} `json:"routes"`
+	}
+	if err := getJSON(ctx, "osrm", u, &out); err != nil {
+		return 0, err
+	}
+	switch {
+	case out.Code == "NoRoute" || (out.Code == "Ok" && len(out.Routes) == 0):
+		return 0, ErrNoRoute
+	case out.Code != "Ok":
+		return 0, fmt.Errorf("osrm: %s: %s", out.Code, out.Message)
+	}
+	return time.Duration(out.Routes[0].Duration * float64(time.Second)), nil
+}
+
+// Google asks the Distance Matrix API, which allows for traffic when
+// driving.
+type Google struct {
+	baseURL string
+	apiKey  string
+	mode    string
+}
+
+func (g *Google) TravelTime(ctx context.Context, from, to Point, depart time.Time) (time.Duration, error) {
+	q := url.Values{
+		"origins":      {from.String()},
+		"destinations": {to.String()},
+		"mode":         {g.mode},
+		"key":          {g.apiKey},
+	}
+	// Google refuses departure times in the past
+	if depart.After(time.Now()) {
+		q.Set("departure_time", strconv.FormatInt(depart.Unix(), 10))
+	}
+
+	type seconds struct {
+		Value float64 `json:"value"`
+	}
+	var out struct {
+		Status       string `json:"status"`
+		ErrorMessage string `json:"error_message"`
+		Rows         []struct {
+			Elements []struct {
+				Status            string   `json:"status"`
+				Duration          *seconds `json:"duration"`
+				DurationInTraffic *seconds `json:"duration_in_traffic"`
+			} `json:"elements"`
+		} `json:"rows"`
+	}
+	if err := getJSON(ctx, "google", g.baseURL+"/maps/api/distancematrix/json?"+q.Encode(), &out); err != nil {
+		return 0, err
+	}
+	if out.Status != "OK" {
+		return 0, fmt.Errorf("google: %s: %s", out.Status, out.ErrorMessage)
+	}
+	if len(out.Rows) == 0 || len(out.Rows[0].Elements) == 0 {
+		return 0, ErrNoRoute
+	}
+	el := out.Rows[0].Elements[0]
+	switch {
+	case el.Status == "ZERO_RESULTS" || el.Status == "NOT_FOUND":
+		return 0, ErrNoRoute
+	case el.Status != "OK":
+		return 0, fmt.Errorf("google: %s", el.Status)
+	case el.DurationInTraffic != nil:
+		return time.Duration(el.DurationInTraffic.Value) * time.Second, nil
+	case el.Duration != nil:
+		return time.Duration(el.Duration.Value) * time.Second, nil
+	}
+	return 0, ErrNoRoute
+}
@@ -0,0 +1,108 @@
+package travel
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	home   = Point{Lat: 51.5, Lng: -0.12}
+	school = Point{Lat: 51.52, Lng: -0.1}
+)
+
+func TestOSRM(t *testing.T) {
+	var path string
+	answer := `{"code":"Ok","routes":[{"duration":1234.5}]}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if answer == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"code":"NoRoute","message":"Impossible route"}`))
+			return
+		}
+		w.Write([]byte(answer))
+	}))
+	defer srv.Close()
+
+	router, err := New(Config{Provider: "osrm", URL: srv.URL + "/"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	trip, err := router.TravelTime(context.Background(), home, school, time.Now())
+	if err != nil {
+		t.Fatalf("TravelTime: %v", err)
+	}
+	if want := 1234500 * time.Millisecond; trip != want {
+		t.Errorf("trip = %v, want %v", trip, want)
+	}
+	// OSRM takes longitude first
+	if want := "/route/v1/driving/-0.12,51.5;-0.1,51.52"; path != want {
+		t.Errorf("path = %q, want %q", path, want)
+	}
+
+	answer = ""
+	if _, err := router.TravelTime(context.Background(), home, school, time.Now()); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("err = %v, want ErrNoRoute", err)
+	}
+}
+
+func TestGoogle(t *testing.T) {
+	if _, err := New(Config{Provider: "google"}); err == nil {
+		t.Error("New without an API key succeeded")
+	}
+
+	var query map[string][]string
+	answer := `{"status":"OK","rows":[{"elements":[{"status":"OK","duration":{"value":900},"duration_in_traffic":{"value":1200}}]}]}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte(answer))
+	}))
+	defer srv.Close()
+
+	router, err := New(Config{Provider: "google", URL: srv.URL, APIKey: "k", Mode: "transit"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	depart := time.Now().Add(time.Hour).Truncate(time.Second)
+	trip, err := router.TravelTime(context.Background(), home, school, depart)
+	if err != nil {
+		t.Fatalf("TravelTime: %v", err)
+	}
+	if trip != 20*time.Minute {
+		t.Errorf("trip = %v, want the 20m in traffic", trip)
+	}
+	for key, want := range map[string]string{"origins": "51.5,-0.12", "destinations": "51.52,-0.1", "mode": "transit", "key": "k"} {
+		if got := query[key]; len(got) != 1 || got[0] != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if got, want := query["departure_time"], strconv.FormatInt(depart.Unix(), 10); len(got) != 1 || got[0] != want {
+		t.Errorf("departure_time = %q, want %q", got, want)
+	}
+
+	answer = `{"status":"OK","rows":[{"elements":[{"status":"ZERO_RESULTS"}]}]}`
+	if _, err := router.TravelTime(context.Background(), home, school, depart); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("err = %v, want ErrNoRoute", err)
+	}
+	answer = `{"status":"REQUEST_DENIED","error_message":"The provided API key is invalid."}`
+	if _, err := router.TravelTime(context.Background(), home, school, depart); err == nil || errors.Is(err, ErrNoRoute) {
+		t.Errorf("err = %v, want the provider's error", err)
+	}
+}
+
+func TestParsePoint(t *testing.T) {
+	p, err := ParsePoint(" 51.5072, -0.1276 ")
+	if err != nil || p != (Point{Lat: 51.5072, Lng: -0.1276}) {
+		t.Errorf("ParsePoint = %+v, %v", p, err)
+	}
+	for _, bad := range []string{"", "51.5", "north,west", "91,0", "0,181"} {
+		if _, err := ParsePoint(bad); err == nil {
+			t.Errorf("ParsePoint(%q) succeeded", bad)
+		}
+	}
+}
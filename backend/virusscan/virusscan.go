@@ -0,0 +1,166 @@
+// Package virusscan checks uploaded files with an antivirus scanner.
+package virusscan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Scanner reads a file and reports the threat it found in it, or "" when
+// it is clean.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (threat string, err error)
+}
+
+// New returns the scanner addr points at: "unix:///run/clamav/clamd.ctl" or
+// "tcp://host:3310" for clamd, or an http(s) URL for an HTTP scanner.
+func New(addr string) (Scanner, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("scanner address: %w", err)
+	}
+	switch u.Scheme {
+	case "unix":
+		return NewClamd("unix", u.Path), nil
+	case "tcp":
+		return NewClamd("tcp", u.Host), nil
+	case "http", "https":
+		return NewHTTP(addr), nil
+	}
+	return nil, fmt.Errorf("scanner address must start with unix://, tcp://, http:// or https://")
+}
+
+// clamdChunk is how much of the file goes in each INSTREAM chunk; clamd's
+// StreamMaxLength still caps the total.
+const clamdChunk = 64 << 10
+
+// Clamd scans with a ClamAV daemon over its INSTREAM command.
+type Clamd struct {
+	network string
+	addr    string
+}
+
+func NewClamd(network, addr string) *Clamd {
+	return &Clamd{network: network, addr: addr}
+}
+
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.addr)
+	if err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	w := bufio.NewWriter(conn)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	buf := make([]byte, clamdChunk)
+	var size [4]byte
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, err := w.Write(size[:]); err != nil {
+				return "", fmt.Errorf("clamd: %w", err)
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return "", fmt.Errorf("clamd: %w", err)
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return "", rerr
+		}
+	}
+	// A zero-length chunk ends the stream
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := w.Write(size[:]); err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply reads "stream: OK", "stream: Eicar-Signature FOUND" or
+// "... ERROR".
+func parseClamdReply(reply string) (string, error) {
+	result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", reply)
+}
+
+var client = &http.Client{Timeout: time.Minute}
+
+// HTTP posts the file to a scanning service, which must answer 200 with
+// {"infected": true|false, "threat": "..."}.
+type HTTP struct {
+	url string
+}
+
+func NewHTTP(url string) *HTTP {
+	return &HTTP{url: url}
+}
+
+func (h *HTTP) Scan(ctx context.Context, r io.Reader) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, r)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("scanner: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("scanner: unexpected status %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	var result struct {
+		Infected bool   `json:"infected"`
+		Threat   string `json:"threat"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("scanner: decode: %w", err)
+	}
+	if !result.Infected {
+		return "", nil
+	}
+	if result.Threat == "" {
+		return "unknown threat", nil
+	}
+	return result.Threat, nil
+}
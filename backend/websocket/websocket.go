@@ -0,0 +1,284 @@
+// Package websocket is the server side of the WebSocket protocol (RFC 6455),
+// as much of it as exchanging JSON messages with a browser needs.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxMessage caps a message from the client, across all its fragments.
+const MaxMessage = 64 << 10
+
+// How long a frame may take to write before the connection is dropped
+const writeTimeout = 10 * time.Second
+
+// Fixed by the protocol; mixed into Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close status codes
+const (
+	closeNormal   = 1000
+	closeProtocol = 1002
+	closeTooBig   = 1009
+)
+
+// ErrClosed is returned once either side has closed the connection.
+var ErrClosed = errors.New("websocket: connection closed")
+
+// Conn is an upgraded connection. Reads must come from one goroutine;
+// writes may come from several.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	// When set, the connection is dropped if nothing, not even a pong,
+	// arrives for this long
+	ReadTimeout time.Duration
+
+	wmu       sync.Mutex
+	closeOnce sync.Once
+}
+
+// Upgrade answers a WebSocket handshake and takes over the connection.
+// Requests from pages on another host are refused, as the rest of the API
+// refuses them through CORS. On failure the response has been written.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, fmt.Errorf("websocket: method %s", r.Method)
+	}
+	if !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, fmt.Errorf("websocket: not an upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("websocket: version %q", r.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, fmt.Errorf("websocket: missing key")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			http.Error(w, "cross-origin WebSocket refused", http.StatusForbidden)
+			return nil, fmt.Errorf("websocket: origin %q", origin)
+		}
+	}
+
+	nc, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("websocket: %w", err)
+	}
+	// Deadlines the server set for the request would otherwise still apply
+	_ = nc.SetDeadline(time.Time{})
+
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err := brw.Flush(); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("websocket: handshake: %w", err)
+	}
+
+	return &Conn{conn: nc, br: brw.Reader}, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerHas reports whether the comma-separated header name lists token.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// on the way. It returns ErrClosed when the client closes the connection.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.closeWith(closeNormal)
+			return nil, ErrClosed
+		case opText, opBinary:
+			if started {
+				c.closeWith(closeProtocol)
+				return nil, fmt.Errorf("websocket: new message inside a fragmented one")
+			}
+			started = true
+			msg = payload
+		case opContinuation:
+			if !started {
+				c.closeWith(closeProtocol)
+				return nil, fmt.Errorf("websocket: continuation without a message")
+			}
+			msg = append(msg, payload...)
+		default:
+			c.closeWith(closeProtocol)
+			return nil, fmt.Errorf("websocket: unknown opcode %#x", op)
+		}
+
+		if len(msg) > MaxMessage {
+			c.closeWith(closeTooBig)
+			return nil, fmt.Errorf("websocket: message over %d bytes", MaxMessage)
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	if c.ReadTimeout > 0 {
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+	}
+
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = h[0]&0x80 != 0
+	op = h[0] & 0x0f
+	if h[0]&0x70 != 0 {
+		return false, 0, nil, fmt.Errorf("websocket: reserved bits set")
+	}
+	// Clients must mask everything they send
+	if h[1]&0x80 == 0 {
+		return false, 0, nil, fmt.Errorf("websocket: unmasked frame from client")
+	}
+
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= opClose && (!fin || n > 125) {
+		return false, 0, nil, fmt.Errorf("websocket: malformed control frame")
+	}
+	if n > MaxMessage {
+		return false, 0, nil, fmt.Errorf("websocket: frame over %d bytes", MaxMessage)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// WriteText sends msg as one text message.
+func (c *Conn) WriteText(msg []byte) error {
+	return c.writeFrame(opText, msg)
+}
+
+// Ping asks the client to answer with a pong, keeping ReadTimeout at bay.
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	// Server frames are never masked
+	h := make([]byte, 0, 10)
+	h = append(h, 0x80|op)
+	switch n := len(payload); {
+	case n <= 125:
+		h = append(h, byte(n))
+	case n <= 0xffff:
+		h = append(h, 126)
+		h = binary.BigEndian.AppendUint16(h, uint16(n))
+	default:
+		h = append(h, 127)
+		h = binary.BigEndian.AppendUint64(h, uint64(n))
+	}
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(append(h, payload...)); err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return ErrClosed
+		}
+		return err
+	}
+	return nil
+}
+
+// Close says goodbye to the client and closes the connection. It is safe
+// to call more than once.
+func (c *Conn) Close() error {
+	c.closeWith(closeNormal)
+	return nil
+}
+
+func (c *Conn) closeWith(code uint16) {
+	c.closeOnce.Do(func() {
+		_ = c.writeFrame(opClose, binary.BigEndian.AppendUint16(nil, code))
+		c.conn.Close()
+	})
+}